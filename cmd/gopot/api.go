@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jackyes/GoPot/pkg/eventrpc"
+	"github.com/jackyes/GoPot/pkg/honeypot"
+)
+
+// loadGeo loads the geo database when one is configured and the reports or
+// sharing need it, and returns nil otherwise.
+func loadGeo(cfg *honeypot.Config) (*honeypot.GeoDB, error) {
+	if cfg.GeoDB == "" || (cfg.Reports.Dir == "" && cfg.Sharing.URL == "") {
+		return nil, nil
+	}
+	geo, err := honeypot.LoadGeoDB(cfg.GeoDB)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load geo database: %w", err)
+	}
+	consoleLogger.Printf("Loaded geo database with %d ranges", geo.Len())
+	return geo, nil
+}
+
+// setupSharing starts sharing anonymized statistics when an endpoint is
+// configured, and returns nil otherwise.
+func setupSharing(srv *honeypot.Server, cfg *honeypot.Config, geo *honeypot.GeoDB) (*honeypot.Sharer, error) {
+	if cfg.Sharing.URL == "" {
+		return nil, nil
+	}
+	sharer, err := honeypot.NewSharer(cfg.Sharing, geo, consoleLogger)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sharing configuration: %w", err)
+	}
+	srv.AddOutput(sharer)
+	consoleLogger.Printf("Sharing anonymized statistics with %s as sensor %s", cfg.Sharing.URL, sharer.Sensor())
+	return sharer, nil
+}
+
+// setupReports starts the country and ASN aggregation reports when a
+// report directory is configured, and returns nil otherwise.
+func setupReports(srv *honeypot.Server, cfg *honeypot.Config, geo *honeypot.GeoDB) (*honeypot.Aggregator, error) {
+	if cfg.Reports.Dir == "" {
+		return nil, nil
+	}
+	interval := time.Hour
+	if cfg.Reports.Interval != "" {
+		var err error
+		if interval, err = time.ParseDuration(cfg.Reports.Interval); err != nil {
+			return nil, fmt.Errorf("invalid report interval %q", cfg.Reports.Interval)
+		}
+	}
+	agg, err := honeypot.NewAggregator(cfg.Reports.Dir, interval, geo, consoleLogger)
+	if err != nil {
+		return nil, err
+	}
+	srv.AddOutput(agg)
+	return agg, nil
+}
+
+// setupTrends starts the weekly trend report when it is enabled, and returns
+// nil otherwise.
+func setupTrends(srv *honeypot.Server, cfg *honeypot.Config) (*honeypot.TrendTracker, error) {
+	if !cfg.Reports.Weekly {
+		return nil, nil
+	}
+	if cfg.Reports.Dir == "" {
+		return nil, fmt.Errorf("the weekly report needs a report directory")
+	}
+	trends, err := honeypot.NewTrendTracker(cfg.Reports.Dir, consoleLogger)
+	if err != nil {
+		return nil, err
+	}
+	trends.Notify = func(report honeypot.TrendReport, markdown, html string) {
+		consoleLogger.Printf("Weekly report written to %s and %s", markdown, html)
+		if cfg.Reports.Push {
+			srv.RaiseAlert("low", "weekly_report", report.Summary()+", see "+markdown)
+		}
+	}
+	srv.AddOutput(trends)
+	return trends, nil
+}
+
+// setupStorage opens the event storage when a DSN is configured, and returns
+// nil otherwise. Old events are pruned hourly when a retention is set.
+func setupStorage(srv *honeypot.Server, config *honeypot.Config) (honeypot.Storage, error) {
+	cfg := config.Storage
+	if cfg.DSN == "" {
+		return nil, nil
+	}
+	var retention time.Duration
+	if cfg.Retention != "" {
+		var err error
+		if retention, err = time.ParseDuration(cfg.Retention); err != nil || retention <= 0 {
+			return nil, fmt.Errorf("invalid storage retention %q", cfg.Retention)
+		}
+	}
+	st, err := honeypot.OpenStorage(cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open storage: %w", err)
+	}
+	if err := addOutput(srv, config, "storage", honeypot.StorageOutput{Storage: st}); err != nil {
+		st.Close()
+		return nil, err
+	}
+	if retention > 0 {
+		go srv.PruneEvery(st, retention, time.Hour, nil)
+	}
+	return st, nil
+}
+
+// setupArtifacts opens the artifact database when a path is configured, and
+// returns nil otherwise.
+func setupArtifacts(srv *honeypot.Server, cfg *honeypot.Config) (*honeypot.ArtifactDB, error) {
+	if cfg.Artifacts.Path == "" {
+		return nil, nil
+	}
+	db, err := honeypot.NewArtifactDB(cfg.Artifacts, consoleLogger)
+	if err != nil {
+		return nil, err
+	}
+	srv.AddOutput(db)
+	return db, nil
+}
+
+// startAPI serves the HTTP query API in the background when it is configured.
+func startAPI(srv *honeypot.Server, agg *honeypot.Aggregator, st honeypot.Storage, artifacts *honeypot.ArtifactDB, purger *honeypot.Purger, collector *honeypot.Collector, cfg honeypot.APIConfig) {
+	if cfg.Listen == "" {
+		return
+	}
+	api := honeypot.NewAPI(srv, agg, cfg.Token)
+	api.Storage = st
+	api.Artifacts = artifacts
+	api.Purger = purger
+	api.Collector = collector
+	consoleLogger.Printf("API listening on %s", cfg.Listen)
+	go func() {
+		if err := http.ListenAndServe(cfg.Listen, api); err != nil {
+			consoleLogger.Printf("API server stopped: %s", err)
+		}
+	}()
+}
+
+// startStream serves the gRPC live event stream in the background when it
+// is configured.
+func startStream(srv *honeypot.Server, cfg honeypot.APIConfig) error {
+	if cfg.StreamListen == "" {
+		return nil
+	}
+	stream := honeypot.NewEventStream()
+	if err := eventrpc.ServeStream(stream, cfg.StreamListen, cfg.Token, consoleLogger); err != nil {
+		return err
+	}
+	srv.AddOutput(stream)
+	consoleLogger.Printf("Event stream listening on %s", cfg.StreamListen)
+	return nil
+}
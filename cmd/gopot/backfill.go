@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackyes/GoPot/pkg/honeypot"
+)
+
+// backfillOptions select the stored events of a backfill and the outputs
+// they are sent to.
+type backfillOptions struct {
+	since, until, types, outputs string
+}
+
+// backfill sends the stored events selected by opts to the remote outputs
+// of the configuration named in opts.outputs, every remote output if none
+// is named, and returns the exit status. Outputs keep their filters.
+func backfill(cfg *honeypot.Config, opts backfillOptions) int {
+	if cfg.Storage.DSN == "" {
+		fmt.Fprintln(os.Stderr, "backfill reads the event storage, but none is configured")
+		return 2
+	}
+	var q honeypot.StorageQuery
+	var err error
+	if q.Since, err = parseTime(opts.since); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -since %q: want an RFC 3339 time or a duration such as 720h\n", opts.since)
+		return 2
+	}
+	if q.Until, err = parseTime(opts.until); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -until %q: want an RFC 3339 time or a duration such as 24h\n", opts.until)
+		return 2
+	}
+	q.Types = splitList(opts.types)
+	wanted := splitList(opts.outputs)
+	for _, name := range wanted {
+		if !strings.HasPrefix(name, "remote:") || !slices.Contains(cfg.OutputNames(), name) {
+			var remotes []string
+			for _, output := range cfg.OutputNames() {
+				if strings.HasPrefix(output, "remote:") {
+					remotes = append(remotes, output)
+				}
+			}
+			fmt.Fprintf(os.Stderr, "Cannot backfill output %q: only remote outputs are (configured: %s)\n", name, strings.Join(remotes, ", "))
+			return 2
+		}
+	}
+
+	srv := honeypot.NewServer(0)
+	srv.Log = consoleLogger
+	var remotes []*honeypot.RemoteOutput
+	for _, rc := range cfg.Remotes {
+		remote, err := honeypot.NewRemoteOutput(rc, consoleLogger)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(wanted) > 0 && !slices.Contains(wanted, "remote:"+remote.Name()) {
+			remote.Close()
+			continue
+		}
+		if err := addOutput(srv, cfg, "remote:"+remote.Name(), remote); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		remotes = append(remotes, remote)
+	}
+	if len(remotes) == 0 {
+		fmt.Fprintln(os.Stderr, "No remote outputs are configured to backfill")
+		return 2
+	}
+	st, err := honeypot.OpenStorage(cfg.Storage.DSN)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open storage: %s\n", err)
+		return 1
+	}
+	defer st.Close()
+
+	start := time.Now()
+	n, err := srv.Backfill(st, q)
+	status := 0
+	if err != nil {
+		consoleLogger.Printf("Backfill stopped after %d events: %s", n, err)
+		status = 1
+	}
+	for _, remote := range remotes {
+		remote.Close() // Deliver the events still queued
+		stats := remote.Stats()
+		consoleLogger.Printf("Output %s: %s", remote.Name(), stats)
+		if stats.Dropped > 0 {
+			status = 1
+		}
+	}
+	consoleLogger.Printf("Backfilled %d events in %s", n, time.Since(start).Round(time.Millisecond))
+	return status
+}
+
+// parseTime parses an RFC 3339 time or a duration counted back from now;
+// empty means no bound.
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/jackyes/GoPot/pkg/honeypot"
+)
+
+// portOverrides are per-port settings given on the command line, applied on
+// top of the ports of the configuration.
+type portOverrides struct {
+	proxyPorts string // -proxy-ports
+	handlerMap string // -handler-map
+}
+
+// envPrefix prefixes the environment variable equivalent of every flag:
+// GOPOT_ followed by the flag name in upper case with dashes as underscores.
+const envPrefix = "GOPOT_"
+
+// envSetPrefix prefixes the environment variables setting keys of the
+// configuration file: GOPOT_SET_ followed by the key in upper case, with
+// double underscores between the keys of nested objects, e.g.
+// GOPOT_SET_SPLUNK__TOKEN for splunk.token.
+const envSetPrefix = envPrefix + "SET_"
+
+// envName returns the environment variable that sets the flag name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadConfiguration builds the configuration from, in increasing order of
+// precedence, the defaults, the optional -config file, GOPOT_* environment
+// variables and command line flags.
+func loadConfiguration() (*honeypot.Config, portOverrides) {
+	defaults := honeypot.DefaultConfig()
+	var (
+		configPath, environment, ports, plugins, scripts, redact, tags, canaries, trustedProxies string
+		sampleConnections                                                                        int
+		profileHelp                                                                              = "host identity impersonated by all personas: " + strings.Join(honeypot.IdentityProfiles(), ", ")
+		overrides                                                                                portOverrides
+		flags                                                                                    = *defaults
+	)
+	flag.StringVar(&configPath, "config", "", "JSON configuration file; flags given explicitly override its settings")
+	flag.StringVar(&environment, "environment", "", "environment of the configuration file applied, e.g. prod, overriding its environment key")
+	flag.StringVar(&ports, "ports", honeypot.DefaultPorts, "comma-separated list of ports to listen on; supports ranges (8000-8100), * and exclusions (!8080)")
+	flag.IntVar(&flags.RedirectPort, "redirect-port", 0, "port netfilter redirects the TCP ports to, listened on instead of them, serving each connection as the port it targeted; Linux only, 0 to listen on every port")
+	flag.StringVar(&overrides.proxyPorts, "proxy-ports", "", "ports that receive HAProxy PROXY protocol v1/v2 headers, same syntax as -ports")
+	flag.StringVar(&flags.Watchlist, "watchlist", "", "file of leaked usernames, emails and password hashes that raise an alert when used")
+	flag.StringVar(&canaries, "canaries", "", "comma-separated username:password logins seeded where only a leak would expose them, raising a canary event and alert when presented; :password matches any username")
+	flag.StringVar(&flags.Honeytokens.File, "honeytoken-file", "", "file of the honeytokens planted in the decoy files and generated by gopot honeytoken, raising an alert when used; empty to disable")
+	flag.StringVar(&flags.Honeytokens.TrackURL, "honeytoken-track-url", "", "public URL of the honeytoken tracking listener URL tokens point to, e.g. https://cdn.example.com/assets")
+	flag.StringVar(&flags.Honeytokens.Listen, "honeytoken-listen", "", "address the honeytoken tracking listener serves on, e.g. :8081, empty for none")
+	flag.StringVar(&flags.AlertWebhook, "alert-webhook", "", "URL that alerts are POSTed to as JSON")
+	flag.StringVar(&flags.Profile, "profile", defaults.Profile, profileHelp)
+	flag.StringVar(&flags.Hostname, "hostname", "", "override the host name of the selected profile")
+	flag.StringVar(&flags.Seed, "seed", "", "seed of the challenges, cookies, tokens and certificates personas make up, so every run repeats them; empty for unpredictable values")
+	flag.StringVar(&flags.DefaultHandler, "handler", defaults.DefaultHandler, "handler serving ports without an explicit -handler-map assignment")
+	flag.StringVar(&overrides.handlerMap, "handler-map", "", "semicolon-separated handler assignments, e.g. \"23=telnet;8000-8100=http\"")
+	flag.StringVar(&plugins, "plugins", "", "comma-separated list of Go plugin (.so) files providing extra handlers")
+	flag.StringVar(&scripts, "scripts", "", "comma-separated name=path dialog scripts registered as handlers, e.g. \"ftp=dialogs/ftp.dialog\"")
+	flag.IntVar(&flags.MaxConnections, "max-connections", defaults.MaxConnections, "maximum number of concurrent connections")
+	flag.IntVar(&flags.ScanDetection.Ports, "scan-ports", defaults.ScanDetection.Ports, "distinct ports one client reaches within a minute that make a vertical scan, reported as a scan event; 0 to disable")
+	flag.Float64Var(&flags.Anomaly.Sensitivity, "anomaly-sensitivity", defaults.Anomaly.Sensitivity, "standard deviations above a port's baseline rate that raise a rate_spike alert, 0 to disable")
+	flag.IntVar(&sampleConnections, "sample-connections", 0, "keep one in this many connection events of each /24 (IPv6 /48) network per minute, credentials and data are always kept; 0 to keep all")
+	flag.StringVar(&flags.GeoDB, "geo-db", "", "IP-to-ASN database in iptoasn.com TSV format used for country and ASN reports")
+	flag.StringVar(&flags.Reports.Dir, "report-dir", "", "directory for periodic per-country and per-ASN reports, empty to disable")
+	flag.StringVar(&flags.Reports.Interval, "report-interval", "1h", "period covered by each report")
+	flag.BoolVar(&flags.Reports.Weekly, "weekly-report", false, "write a weekly trend report to the report directory")
+	flag.BoolVar(&flags.Reports.Push, "push-weekly-report", false, "send the summary of each weekly report to the alert channels")
+	flag.StringVar(&flags.FailLog.Path, "fail-log", "", "file a line is appended to for every login attempt and alert of a client, for fail2ban, empty to disable")
+	flag.BoolVar(&flags.GreyNoise.Enabled, "greynoise", false, "classify clients with the GreyNoise Community API, so events of benign scanners like Shodan can be filtered out")
+	flag.BoolVar(&flags.Journal.Enabled, "journal", false, "write events to the systemd journal with structured fields, as gopot; Linux only")
+	flag.StringVar(&flags.EventLog.Source, "event-log", "", "source events are written under to the Application log of the Windows Event Log, e.g. GoPot; Windows only, empty to disable")
+	flag.StringVar(&flags.Firewall.Backend, "firewall", "", "block clients after 5 login attempts or alerts in 10 minutes on the host firewall for an hour: "+strings.Join(honeypot.FirewallBackends, ", ")+", empty to disable")
+	flag.BoolVar(&flags.Kubernetes.Enabled, "kubernetes", false, "Kubernetes mode: probe endpoints on "+honeypot.DefaultProbeAddr+", pod metadata in event labels, Services of kubernetes.services, and reloads when the configuration file changes")
+	flag.BoolVar(&flags.Ingress, "ingress", false, "ingress mode: in a container without host networking, expect PROXY protocol headers on every port, from a frontend like traefik")
+	flag.StringVar(&trustedProxies, "trusted-proxies", "", "comma-separated addresses and CIDR networks of the load balancers whose PROXY headers are believed; headers from other peers are served as client data; empty for loopback and private addresses")
+	flag.BoolVar(&flags.Sandbox, "sandbox", false, "confine the process once its ports are bound: landlock and seccomp on Linux, pledge and unveil on OpenBSD, procctl on FreeBSD")
+	flag.StringVar(&flags.Sharing.URL, "share-url", "", "community endpoint anonymized statistics (ports, countries, payload hashes) are shared with hourly, empty to disable")
+	flag.StringVar(&flags.API.Listen, "api-listen", "", "address of the HTTP query API, e.g. 127.0.0.1:8088, empty to disable")
+	flag.StringVar(&flags.API.StreamListen, "api-stream-listen", "", "address of the gRPC live event stream, e.g. 127.0.0.1:8089, empty to disable")
+	flag.StringVar(&flags.Storage.DSN, "storage", "", "DSN of the event storage, e.g. file:///var/lib/gopot/events.jsonl, empty to disable")
+	flag.StringVar(&flags.Storage.Retention, "storage-retention", "", "age after which stored events are pruned, e.g. 720h, empty to keep them")
+	flag.StringVar(&flags.Artifacts.Path, "artifact-db", "", "file of the database relating client addresses, JA3 fingerprints, credentials and payload hashes, empty to disable")
+	flag.StringVar(&flags.Archive.Bucket, "archive-bucket", "", "S3 bucket rotated logs, captures, samples and reports are uploaded to hourly, with the credentials in AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY; empty to disable")
+	flag.BoolVar(&flags.Artifacts.RDNS, "artifact-rdns", false, "resolve the reverse DNS name of every client address for the artifact database")
+	flag.StringVar(&flags.ModbusDevice, "modbus-device", "", "JSON device profile impersonated by the modbus handler, empty for a Modicon M340")
+	flag.StringVar(&flags.SNMPAgent, "snmp-agent", "", "JSON MIB profile served by the snmp handler, empty to describe the host identity")
+	flag.StringVar(&flags.Elasticsearch, "elasticsearch-cluster", "", "JSON cluster profile served by the elasticsearch handler, empty for a 7.17 node with customer data indices")
+	flag.StringVar(&flags.UPnPDevice, "upnp-device", "", "JSON gateway profile announced by the ssdp handler and served by the upnp handler, empty for an OpenWrt router")
+	flag.StringVar(&flags.TFTPRoot, "tftp-root", "", "directory of the bait files served by the tftp handler, empty to answer every read with File not found")
+	flag.StringVar(&flags.ShellTree, "shell-tree", "", "JSON file tree shown by the shell of the telnet handler on top of the decoy files and honeytokens")
+	flag.StringVar(&flags.NTPServer, "ntp-server", "", "NTP server the clock is checked against hourly, e.g. pool.ntp.org")
+	flag.StringVar(&flags.Limits.ConnectTimeout, "connect-timeout", "", "time a client has to send its first data (default 10s)")
+	flag.StringVar(&flags.Limits.IdleTimeout, "idle-timeout", "", "silence that ends a session once the client has sent data (default 15s)")
+	flag.StringVar(&flags.Limits.SessionTimeout, "session-timeout", "", "maximum length of a session (default 2m)")
+	flag.StringVar(&flags.Limits.HandlerTimeout, "handler-timeout", "", "wall-clock budget of a handler, after which its session is cut off (default session timeout plus 1m)")
+	flag.IntVar(&flags.Limits.MaxBytes, "max-bytes", 0, "bytes read from a client before its session is closed (default 65536)")
+	flag.StringVar(&flags.DrainTimeout, "drain-timeout", defaults.DrainTimeout, "how long active connections may finish on shutdown before they are closed")
+	flag.IntVar(&flags.PortLimit, "port-limit", defaults.PortLimit, "maximum number of concurrent connections per port, 0 for no per-port limit")
+	flag.StringVar(&flags.Capture.Dir, "capture-dir", "", "directory for pcap captures of attacker traffic following a payload URL, empty to disable")
+	flag.StringVar(&flags.Capture.Duration, "capture-duration", "5m", "how long each follow-up capture runs")
+	flag.IntVar(&flags.QueueSize, "queue-size", defaults.QueueSize, "connections that may wait for a free worker before new ones are dropped")
+	flag.StringVar(&flags.PortCollision, "port-collision", defaults.PortCollision, "what to do when a local service already answers on a port: warn and listen anyway, refuse to listen on it, or off")
+	flag.StringVar(&flags.LegalNotice.Jurisdiction, "legal-notice", "", "legal notice interactive personas show first, one of "+strings.Join(honeypot.LegalNoticeJurisdictions(), ", ")+", empty for none")
+	flag.StringVar(&redact, "redact", "", "comma-separated personal data masked in events, keeping hashes of the originals: "+strings.Join(honeypot.RedactionPatterns(), ", "))
+	flag.BoolVar(&flags.DecoyTraffic.Enabled, "decoy-traffic", false, "generate the NTP syncs and update checks of an ordinary host of the profile, so the sensor isn't silent on the network")
+	flag.StringVar(&flags.OTel.Endpoint, "otel-endpoint", "", "OTLP/HTTP receiver session spans and metrics are exported to, e.g. http://otel-collector:4318, empty to disable")
+	flag.StringVar(&flags.StatsD.Addr, "statsd", "", "StatsD, DogStatsD or Telegraf agent connection, byte and session duration metrics are sent to over UDP, e.g. 127.0.0.1:8125, empty to disable")
+	flag.StringVar(&flags.GELF.Addr, "gelf", "", "host:port of a Graylog GELF UDP input events are sent to, e.g. graylog:12201, empty to disable")
+	flag.StringVar(&flags.Splunk.URL, "splunk-url", "", "Splunk HTTP Event Collector events are sent to, e.g. https://splunk:8088, with the token set in the configuration file; empty to disable")
+	flag.StringVar(&flags.Responder.URL, "responder-url", "", "OpenAI-compatible chat completions endpoint, e.g. of a local llama.cpp or Ollama server, generating replies to unknown shell commands and HTTP paths; empty to disable")
+	flag.StringVar(&flags.DataEncoding, "data-encoding", "", "how the data field of data events holds payloads that are not printable text: "+strings.Join(honeypot.DataEncodings, ", ")+"; empty for escaped")
+	flag.BoolVar(&flags.ReadOnly, "read-only", false, "never send clients a byte: no banners or responses, only record connections and what clients send")
+	flag.StringVar(&flags.LogDir, "log-dir", defaults.LogDir, "directory log.txt and the daily archives are written to")
+	flag.StringVar(&flags.LogFormat, "log-format", "", "format of the lines of log.txt: text, or cef for ArcSight and other SIEMs (default text)")
+	flag.StringVar(&flags.LogSigning.Method, "log-signing", "", "sign every line of log.txt, chained to the previous one, so rewrites are detected by gopot verify-log: "+strings.Join(honeypot.LogSigningMethods, " or ")+", empty to disable")
+	flag.StringVar(&flags.LogSigning.Key, "log-signing-key", "", "file of the hex HMAC key, replaced by the next key every day, or of the PEM Ed25519 private key log lines are signed with")
+	flag.StringVar(&flags.NodeID, "node-id", "", "identifier of the sensor, added to every event as the node_id label")
+	flag.StringVar(&flags.Region, "region", "", "where the sensor is deployed, added to every event as the region label")
+	flag.StringVar(&tags, "tags", "", "comma-separated key=value labels added to every event, e.g. \"env=prod,team=soc\"")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [validate|backfill|purge -ip ADDRESS|verify-log|honeytoken|replay|k8s manifest -image IMAGE|schema] [flags]\n\n"+
+			"validate checks the configuration and exits without listening.\n"+
+			"backfill sends stored events to remote outputs, e.g. a newly added SIEM collector.\n"+
+			"purge deletes what the sensor keeps about a client address and prints a report.\n"+
+			"verify-log checks the signatures of the text log and its archives, or of the files given after the flags.\n"+
+			"honeytoken generates a honeytoken in -honeytoken-file and prints its values to plant, or lists those generated with -list.\n"+
+			"replay plays recorded sessions, from the files given after the flags or -session in the event storage, against their handler or -target.\n"+
+			"k8s manifest prints the Kubernetes manifests running the configuration.\n"+
+			"schema prints the JSON Schema of the events.\n\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\nEvery flag can also be set with an environment variable named after it, e.g. %s for -ports.\n", envName("ports"))
+		fmt.Fprintf(flag.CommandLine.Output(), "Keys of the configuration file can be set with %s followed by the key, e.g. %sSPLUNK__TOKEN for splunk.token.\n", envSetPrefix, envSetPrefix)
+		fmt.Fprintln(flag.CommandLine.Output(), "Flags override environment variables, which override the configuration file.")
+	}
+	flag.Parse()
+
+	// Environment variables count as flags that weren't given on the command line
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	flag.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || explicit[f.Name] {
+			return
+		}
+		if err := flag.Set(f.Name, value); err != nil {
+			consoleLogger.Printf("Invalid value %q for %s: %s", value, envName(f.Name), err)
+			os.Exit(1)
+		}
+	})
+
+	cfg := defaults
+	if environment != "" && configPath == "" {
+		consoleLogger.Print("-environment selects settings of a configuration file, but no -config is given")
+		os.Exit(1)
+	}
+	if configPath != "" {
+		var err error
+		if cfg, err = honeypot.LoadConfigEnvironment(configPath, environment); err != nil {
+			consoleLogger.Printf("Unable to load configuration: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	// Then the keys of the configuration file set by the environment, in a
+	// stable order so that a nested key wins over the object holding it
+	var settings []string
+	for _, entry := range os.Environ() {
+		if strings.HasPrefix(entry, envSetPrefix) {
+			settings = append(settings, entry)
+		}
+	}
+	sort.Strings(settings)
+	for _, entry := range settings {
+		name, value, _ := strings.Cut(entry, "=")
+		key := strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(name, envSetPrefix), "__", "."))
+		if err := cfg.Set(key, value); err != nil {
+			consoleLogger.Printf("Invalid %s: %s", name, err)
+			os.Exit(1)
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "ports":
+			cfg.Ports = []honeypot.PortConfig{{Ports: ports}}
+		case "redirect-port":
+			cfg.RedirectPort = flags.RedirectPort
+		case "log-dir":
+			cfg.LogDir = flags.LogDir
+		case "log-format":
+			cfg.LogFormat = flags.LogFormat
+		case "log-signing":
+			cfg.LogSigning.Method = flags.LogSigning.Method
+		case "log-signing-key":
+			cfg.LogSigning.Key = flags.LogSigning.Key
+		case "watchlist":
+			cfg.Watchlist = flags.Watchlist
+		case "honeytoken-file":
+			cfg.Honeytokens.File = flags.Honeytokens.File
+		case "honeytoken-track-url":
+			cfg.Honeytokens.TrackURL = flags.Honeytokens.TrackURL
+		case "honeytoken-listen":
+			cfg.Honeytokens.Listen = flags.Honeytokens.Listen
+		case "alert-webhook":
+			cfg.AlertWebhook = flags.AlertWebhook
+		case "profile":
+			cfg.Profile = flags.Profile
+		case "hostname":
+			cfg.Hostname = flags.Hostname
+		case "seed":
+			cfg.Seed = flags.Seed
+		case "handler":
+			cfg.DefaultHandler = flags.DefaultHandler
+		case "max-connections":
+			cfg.MaxConnections = flags.MaxConnections
+		case "capture-dir":
+			cfg.Capture.Dir = flags.Capture.Dir
+		case "capture-duration":
+			cfg.Capture.Duration = flags.Capture.Duration
+		case "scan-ports":
+			cfg.ScanDetection.Ports = flags.ScanDetection.Ports
+		case "anomaly-sensitivity":
+			cfg.Anomaly.Sensitivity = flags.Anomaly.Sensitivity
+		case "sample-connections":
+			cfg.Sampling = slices.DeleteFunc(cfg.Sampling, func(r honeypot.SamplingRule) bool { return r.Type == honeypot.EventConnection })
+			if sampleConnections > 0 {
+				cfg.Sampling = append(cfg.Sampling, honeypot.SamplingRule{Type: honeypot.EventConnection, OneIn: sampleConnections})
+			}
+		case "geo-db":
+			cfg.GeoDB = flags.GeoDB
+		case "modbus-device":
+			cfg.ModbusDevice = flags.ModbusDevice
+		case "upnp-device":
+			cfg.UPnPDevice = flags.UPnPDevice
+		case "tftp-root":
+			cfg.TFTPRoot = flags.TFTPRoot
+		case "shell-tree":
+			cfg.ShellTree = flags.ShellTree
+		case "snmp-agent":
+			cfg.SNMPAgent = flags.SNMPAgent
+		case "elasticsearch-cluster":
+			cfg.Elasticsearch = flags.Elasticsearch
+		case "port-collision":
+			cfg.PortCollision = flags.PortCollision
+		case "read-only":
+			cfg.ReadOnly = flags.ReadOnly
+		case "data-encoding":
+			cfg.DataEncoding = flags.DataEncoding
+		case "fail-log":
+			cfg.FailLog.Path = flags.FailLog.Path
+		case "greynoise":
+			cfg.GreyNoise.Enabled = flags.GreyNoise.Enabled
+		case "journal":
+			cfg.Journal.Enabled = flags.Journal.Enabled
+		case "event-log":
+			cfg.EventLog.Source = flags.EventLog.Source
+		case "firewall":
+			cfg.Firewall.Backend = flags.Firewall.Backend
+		case "kubernetes":
+			cfg.Kubernetes.Enabled = flags.Kubernetes.Enabled
+		case "ingress":
+			cfg.Ingress = flags.Ingress
+		case "trusted-proxies":
+			cfg.TrustedProxies = splitList(trustedProxies)
+		case "sandbox":
+			cfg.Sandbox = flags.Sandbox
+		case "share-url":
+			cfg.Sharing.URL = flags.Sharing.URL
+		case "responder-url":
+			cfg.Responder.URL = flags.Responder.URL
+		case "otel-endpoint":
+			cfg.OTel.Endpoint = flags.OTel.Endpoint
+		case "statsd":
+			cfg.StatsD.Addr = flags.StatsD.Addr
+		case "gelf":
+			cfg.GELF.Addr = flags.GELF.Addr
+		case "splunk-url":
+			cfg.Splunk.URL = flags.Splunk.URL
+		case "decoy-traffic":
+			cfg.DecoyTraffic.Enabled = flags.DecoyTraffic.Enabled
+		case "legal-notice":
+			cfg.LegalNotice = honeypot.LegalNoticeConfig{Jurisdiction: flags.LegalNotice.Jurisdiction}
+		case "report-dir":
+			cfg.Reports.Dir = flags.Reports.Dir
+		case "report-interval":
+			cfg.Reports.Interval = flags.Reports.Interval
+		case "weekly-report":
+			cfg.Reports.Weekly = flags.Reports.Weekly
+		case "push-weekly-report":
+			cfg.Reports.Push = flags.Reports.Push
+		case "api-listen":
+			cfg.API.Listen = flags.API.Listen
+		case "api-stream-listen":
+			cfg.API.StreamListen = flags.API.StreamListen
+		case "storage":
+			cfg.Storage.DSN = flags.Storage.DSN
+		case "storage-retention":
+			cfg.Storage.Retention = flags.Storage.Retention
+		case "archive-bucket":
+			cfg.Archive.Bucket = flags.Archive.Bucket
+		case "artifact-db":
+			cfg.Artifacts.Path = flags.Artifacts.Path
+		case "artifact-rdns":
+			cfg.Artifacts.RDNS = flags.Artifacts.RDNS
+		case "ntp-server":
+			cfg.NTPServer = flags.NTPServer
+		case "connect-timeout":
+			cfg.Limits.ConnectTimeout = flags.Limits.ConnectTimeout
+		case "idle-timeout":
+			cfg.Limits.IdleTimeout = flags.Limits.IdleTimeout
+		case "session-timeout":
+			cfg.Limits.SessionTimeout = flags.Limits.SessionTimeout
+		case "handler-timeout":
+			cfg.Limits.HandlerTimeout = flags.Limits.HandlerTimeout
+		case "max-bytes":
+			cfg.Limits.MaxBytes = flags.Limits.MaxBytes
+		case "drain-timeout":
+			cfg.DrainTimeout = flags.DrainTimeout
+		case "port-limit":
+			cfg.PortLimit = flags.PortLimit
+		case "queue-size":
+			cfg.QueueSize = flags.QueueSize
+		case "node-id":
+			cfg.NodeID = flags.NodeID
+		case "region":
+			cfg.Region = flags.Region
+		case "tags":
+			cfg.Tags = make(map[string]string)
+			for _, entry := range splitList(tags) {
+				key, value, ok := strings.Cut(entry, "=")
+				if !ok || key == "" {
+					consoleLogger.Printf("Invalid tag %q, want key=value", entry)
+					os.Exit(1)
+				}
+				cfg.Tags[key] = value
+			}
+		case "canaries":
+			cfg.Canaries = nil
+			for _, entry := range splitList(canaries) {
+				username, password, ok := strings.Cut(entry, ":")
+				if !ok || password == "" {
+					consoleLogger.Printf("Invalid canary credential %q, want username:password", entry)
+					os.Exit(1)
+				}
+				cfg.Canaries = append(cfg.Canaries, honeypot.CanaryCredential{Username: username, Password: password})
+			}
+		case "redact":
+			cfg.Redaction = honeypot.RedactionConfig{Patterns: splitList(redact)}
+		case "plugins":
+			cfg.Plugins = splitList(plugins)
+		case "scripts":
+			cfg.Scripts = make(map[string]string)
+			for _, entry := range splitList(scripts) {
+				name, path, ok := strings.Cut(entry, "=")
+				if !ok || name == "" {
+					consoleLogger.Printf("Invalid script %q, want name=path", entry)
+					os.Exit(1)
+				}
+				cfg.Scripts[name] = path
+			}
+		}
+	})
+	return cfg, overrides
+}
+
+// apply sets the command line proxy and handler assignments on the listening ports.
+func (o portOverrides) apply(ports map[string]*honeypot.PortOptions) error {
+	proxied, invalid := honeypot.ParsePortSpec(o.proxyPorts)
+	if len(invalid) > 0 {
+		return fmt.Errorf("invalid proxy port specification: %s", strings.Join(invalid, ", "))
+	}
+	for _, port := range proxied {
+		if opts, ok := ports[port]; ok {
+			opts.Proxy = true
+		}
+	}
+
+	assignments, err := honeypot.ParseHandlerMap(o.handlerMap)
+	if err != nil {
+		return err
+	}
+	for port, name := range assignments {
+		if opts, ok := ports[port]; ok {
+			opts.Handler = name
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
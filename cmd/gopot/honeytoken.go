@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/jackyes/GoPot/pkg/honeypot"
+)
+
+// honeytokenOptions select what gopot honeytoken does.
+type honeytokenOptions struct {
+	kind     string // kind of the token generated, one of honeypot.HoneytokenKinds
+	note     string // where it is planted
+	username string // of a credential token, empty for a random one
+	list     bool   // list the tokens generated instead
+}
+
+// honeytoken generates a honeytoken in the configured file, for the sensors
+// watching it to alert on, and prints its values ready to be planted, or
+// lists the tokens generated. It returns the exit status.
+func honeytoken(cfg *honeypot.Config, opts honeytokenOptions) int {
+	if cfg.Honeytokens.File == "" {
+		fmt.Fprintln(os.Stderr, "honeytoken needs -honeytoken-file, the file the sensors watch")
+		return 2
+	}
+	st, err := honeypot.OpenHoneytokens(cfg.Honeytokens)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if opts.list {
+		for _, t := range st.Tokens() {
+			fmt.Printf("%s  %-10s %s  %s\n", t.ID, t.Kind, t.Created.Format("2006-01-02"), t.Note)
+		}
+		return 0
+	}
+	t, err := st.Generate(opts.kind, opts.note, opts.username)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("# honeytoken %s", t.ID)
+	if t.Note != "" {
+		fmt.Printf(", %s", t.Note)
+	}
+	fmt.Println()
+	switch t.Kind {
+	case "aws":
+		fmt.Printf("[default]\naws_access_key_id = %s\naws_secret_access_key = %s\n", t.AccessKeyID, t.SecretAccessKey)
+	case "url":
+		fmt.Println(t.URL)
+	case "credential":
+		fmt.Printf("username: %s\npassword: %s\n", t.Username, t.Password)
+	}
+	return 0
+}
+
+// startHoneytokenTracker serves the honeytoken tracking listener in the
+// background when it is configured.
+func startHoneytokenTracker(srv *honeypot.Server, listen string) {
+	if listen == "" {
+		return
+	}
+	consoleLogger.Printf("Honeytoken tracking listener on %s", listen)
+	go func() {
+		if err := http.ListenAndServe(listen, srv.HoneytokenTracker()); err != nil {
+			consoleLogger.Printf("Honeytoken tracking listener stopped: %s", err)
+		}
+	}()
+}
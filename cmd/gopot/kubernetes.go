@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jackyes/GoPot/pkg/honeypot"
+)
+
+// watchConfig checks the configuration file at path every interval, as the
+// kubelet updates a mounted ConfigMap in place. Once its content changes and
+// gopot validate accepts the configuration, it shuts srv down and sets
+// reload, for main to replace the process with a new one. Invalid changes
+// are reported and ignored until the file changes again.
+func watchConfig(srv *honeypot.Server, path string, interval time.Duration, reload *atomic.Bool) {
+	last, _ := os.ReadFile(path)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			content, err := os.ReadFile(path)
+			if err != nil || bytes.Equal(content, last) {
+				continue
+			}
+			last = content
+			if output, err := validateCommand().CombinedOutput(); err != nil {
+				srv.Emit(honeypot.Event{Type: honeypot.EventError, Message: "Configuration " + path + " changed but is invalid, keeping the running one: " + strings.TrimSpace(string(output))})
+				continue
+			}
+			srv.Emit(honeypot.Event{Type: honeypot.EventInfo, Message: "Configuration " + path + " changed, reloading"})
+			reload.Store(true)
+			srv.Shutdown()
+			return
+		}
+	}()
+}
+
+// validateCommand returns gopot validate run with the arguments and
+// environment of this process, to check a configuration the way the
+// reloaded process will read it.
+func validateCommand() *exec.Cmd {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	return exec.Command(exe, append([]string{"validate"}, os.Args[1:]...)...)
+}
+
+// reexec replaces the process with a new run of its executable, with the
+// same arguments and environment.
+func reexec() {
+	exe, err := os.Executable()
+	if err == nil {
+		err = syscall.Exec(exe, os.Args, os.Environ())
+	}
+	consoleLogger.Printf("Unable to reload: %s", err)
+	os.Exit(1)
+}
+
+// manifest prints the Kubernetes manifests running cfg on the standard
+// output and returns the exit status: 1 if the configuration is invalid.
+// Plugins are loaded so the transports of their handlers are known; the
+// -proxy-ports and -handler-map assignments are passed on to the container.
+func manifest(cfg *honeypot.Config, overrides portOverrides, opts honeypot.ManifestOptions) int {
+	for _, path := range cfg.Plugins {
+		if _, err := loadPlugin(path); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	cfg.Kubernetes.Enabled = true
+	invalid := false
+	for _, p := range cfg.Validate() {
+		if !p.Warning {
+			fmt.Fprintln(os.Stderr, p)
+			invalid = true
+		}
+	}
+	if invalid {
+		return 1
+	}
+	ports, err := cfg.PortOptions()
+	if err == nil {
+		err = overrides.apply(ports)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if overrides.proxyPorts != "" {
+		opts.Args = append(opts.Args, "-proxy-ports", overrides.proxyPorts)
+	}
+	if overrides.handlerMap != "" {
+		opts.Args = append(opts.Args, "-handler-map", overrides.handlerMap)
+	}
+	manifests, err := honeypot.KubernetesManifest(cfg, ports, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Print(manifests)
+	return 0
+}
@@ -0,0 +1,716 @@
+// Command gopot is a multi-port honeypot that logs every connection, the
+// data clients send and the credentials they try.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jackyes/GoPot/pkg/eventrpc"
+	"github.com/jackyes/GoPot/pkg/honeypot"
+	_ "github.com/jackyes/GoPot/pkg/zstd"
+)
+
+// consoleLogger reports high-level activity on the standard output.
+var consoleLogger = log.New(os.Stdout, "", log.LstdFlags)
+
+// setupSignalHandling configures handling for SIGINT and SIGTERM signals.
+// It gracefully shuts down the server, which makes ListenAndServe return.
+// A second signal during the drain period exits immediately.
+func setupSignalHandling(srv *honeypot.Server) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-sigs
+		consoleLogger.Printf("Received signal: %s", sig)
+		srv.Emit(honeypot.Event{Type: honeypot.EventInfo, Message: "Shutting down due to signal: " + sig.String()})
+		go srv.Shutdown() // Stop listeners, drain and close all open connections
+
+		sig = <-sigs
+		consoleLogger.Printf("Received second signal: %s, exiting without draining", sig)
+		os.Exit(1)
+	}()
+}
+
+// addOutput adds an output to srv, restricted by the filter configured for
+// it under name, if any.
+func addOutput(srv *honeypot.Server, cfg *honeypot.Config, name string, o honeypot.Output) error {
+	if f, ok := cfg.Filters[name]; ok {
+		filtered, err := honeypot.NewFilteredOutput(o, f)
+		if err != nil {
+			return fmt.Errorf("invalid filter of output %s: %w", name, err)
+		}
+		o = filtered
+	}
+	srv.AddOutput(o)
+	return nil
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		cfg, overrides := loadConfiguration()
+		os.Exit(validate(cfg, overrides))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		var opts backfillOptions
+		flag.StringVar(&opts.since, "since", "", "start of the stored events sent, an RFC 3339 time or a duration back from now such as 720h")
+		flag.StringVar(&opts.until, "until", "", "end of the stored events sent, same format as -since, empty for now")
+		flag.StringVar(&opts.types, "type", "", "comma-separated event types sent, empty for all")
+		flag.StringVar(&opts.outputs, "outputs", "", "comma-separated remote outputs the events are sent to, e.g. remote:siem, empty for all")
+		cfg, _ := loadConfiguration()
+		os.Exit(backfill(cfg, opts))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "purge" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		ip := flag.String("ip", "", "client address whose stored events, log lines, artifacts and captures are deleted")
+		reportPath := flag.String("purge-report", "", "file the JSON purge report is also written to")
+		cfg, _ := loadConfiguration()
+		os.Exit(purge(cfg, *ip, *reportPath))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify-log" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		cfg, _ := loadConfiguration()
+		os.Exit(verifyLog(cfg))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "honeytoken" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		var opts honeytokenOptions
+		flag.StringVar(&opts.kind, "kind", "aws", "kind of honeytoken generated: "+strings.Join(honeypot.HoneytokenKinds, ", "))
+		flag.StringVar(&opts.note, "note", "", "where the honeytoken is planted, repeated in its alerts")
+		flag.StringVar(&opts.username, "username", "", "username of a credential honeytoken, empty for a random one")
+		flag.BoolVar(&opts.list, "list", false, "list the honeytokens generated instead")
+		cfg, _ := loadConfiguration()
+		os.Exit(honeytoken(cfg, opts))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		var opts replayOptions
+		flag.StringVar(&opts.session, "session", "", "session replayed, read from the event storage, or selected in the event files given")
+		flag.StringVar(&opts.target, "target", "", "host:port of a live sensor the sessions are replayed against, empty to replay them against their handler in process")
+		flag.StringVar(&opts.port, "port", "0", "port plain transcripts were recorded on, served by -handler")
+		flag.BoolVar(&opts.udp, "udp", false, "plain transcripts are sessions of datagrams")
+		flag.DurationVar(&opts.quiet, "quiet", 0, "how long the server may stay silent before the next client step is sent, 0 for 200ms or the fixture's")
+		flag.BoolVar(&opts.timeline, "timeline", false, "print the recorded sessions, events with their time offsets, instead of replaying them")
+		cfg, _ := loadConfiguration()
+		os.Exit(replay(cfg, opts))
+	}
+	if len(os.Args) > 2 && os.Args[1] == "k8s" && os.Args[2] == "manifest" {
+		os.Args = append(os.Args[:1], os.Args[3:]...)
+		var opts honeypot.ManifestOptions
+		flag.StringVar(&opts.Name, "name", "gopot", "name of the Deployment, its configuration and the Service of ports without one of their own")
+		flag.StringVar(&opts.Namespace, "namespace", "", "namespace of the manifests, empty for the namespace of the kubectl context")
+		flag.StringVar(&opts.Image, "image", "", "container image of GoPot")
+		flag.IntVar(&opts.Replicas, "replicas", 1, "pods of the Deployment")
+		flag.StringVar(&opts.ServiceType, "service-type", "LoadBalancer", "type of the Services: ClusterIP, NodePort or LoadBalancer")
+		flag.BoolVar(&opts.Secret, "secret", false, "ship the configuration as a Secret rather than a ConfigMap, for configurations holding API keys")
+		cfg, overrides := loadConfiguration()
+		os.Exit(manifest(cfg, overrides, opts))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		fmt.Print(honeypot.EventSchema)
+		os.Exit(0)
+	}
+	cfg, overrides := loadConfiguration()
+
+	logFile, err := honeypot.NewLogFileOutput(cfg.LogDir)
+	if err != nil {
+		log.Fatal(err) // Fatal error if the log file cannot be opened
+	}
+	if cfg.LogFormat != "" && !slices.Contains(honeypot.LogFormats, cfg.LogFormat) {
+		consoleLogger.Printf("Invalid log format %q, want %s", cfg.LogFormat, strings.Join(honeypot.LogFormats, " or "))
+		os.Exit(1)
+	}
+	logFile.Format = cfg.LogFormat
+	if cfg.LogSigning.Method != "" {
+		if err := logFile.EnableSigning(cfg.LogSigning); err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	srv := honeypot.NewServer(cfg.MaxConnections)
+	srv.QueueSize = cfg.QueueSize
+	srv.PortLimit = cfg.PortLimit
+	srv.PortCollision = cfg.PortCollision
+	srv.ReadOnly = cfg.ReadOnly
+	srv.DataEncoding = cfg.DataEncoding
+	if srv.ReadOnly {
+		consoleLogger.Print("Read-only mode: nothing is sent to clients, their connections and data are only recorded")
+	}
+	if cfg.Redaction.Enabled() {
+		if srv.Redactor, err = honeypot.NewRedactor(cfg.Redaction); err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+	}
+	if cfg.RedirectPort != 0 {
+		if !honeypot.IsValidPort(strconv.Itoa(cfg.RedirectPort)) || runtime.GOOS != "linux" {
+			consoleLogger.Printf("Invalid redirect port %d: ports are 1-65535, and redirected connections are only followed on Linux", cfg.RedirectPort)
+			os.Exit(1)
+		}
+		srv.RedirectPort = strconv.Itoa(cfg.RedirectPort)
+	}
+	if srv.TrustedProxies, err = honeypot.ParseTrustedProxies(cfg.TrustedProxies); err != nil {
+		consoleLogger.Println(err)
+		os.Exit(1)
+	}
+	if !slices.Contains(honeypot.CollisionPolicies, cfg.PortCollision) {
+		consoleLogger.Printf("Invalid port collision policy %q, want %s", cfg.PortCollision, strings.Join(honeypot.CollisionPolicies, ", "))
+		os.Exit(1)
+	}
+	if srv.DrainTimeout, err = time.ParseDuration(cfg.DrainTimeout); err != nil {
+		consoleLogger.Printf("Invalid drain timeout %q", cfg.DrainTimeout)
+		os.Exit(1)
+	}
+	srv.Log = consoleLogger
+	srv.Labels = cfg.SensorLabels()
+	if cfg.Kubernetes.Enabled {
+		for key, value := range honeypot.KubernetesMetadata() {
+			srv.Labels[key] = value
+		}
+	}
+	if err := addOutput(srv, cfg, "console", honeypot.NewLogOutput(os.Stdout)); err != nil {
+		consoleLogger.Println(err)
+		os.Exit(1)
+	}
+	if err := addOutput(srv, cfg, "log_file", logFile); err != nil {
+		consoleLogger.Println(err)
+		os.Exit(1)
+	}
+	for _, hc := range cfg.Hooks {
+		hook, err := honeypot.NewHookOutput(hc, consoleLogger)
+		if err == nil {
+			err = addOutput(srv, cfg, "hook:"+hook.Name(), hook)
+		}
+		if err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+	}
+	var remotes []*honeypot.RemoteOutput
+	for _, rc := range cfg.Remotes {
+		remote, err := honeypot.NewRemoteOutput(rc, consoleLogger)
+		if err == nil {
+			err = addOutput(srv, cfg, "remote:"+remote.Name(), remote)
+		}
+		if err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+		remotes = append(remotes, remote)
+	}
+	var failLog *honeypot.FailLogOutput
+	if cfg.FailLog.Path != "" {
+		failLog, err = honeypot.NewFailLogOutput(cfg.FailLog)
+		if err == nil {
+			err = addOutput(srv, cfg, "fail_log", failLog)
+		}
+		if err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+		consoleLogger.Printf("Writing the fail log to %s", cfg.FailLog.Path)
+	}
+	var dshield *honeypot.DShieldOutput
+	if cfg.DShield.UserID != "" {
+		dshield, err = honeypot.NewDShieldOutput(cfg.DShield, consoleLogger)
+		if err == nil {
+			err = addOutput(srv, cfg, "dshield", dshield)
+		}
+		if err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+		if cfg.DShield.DryRun {
+			consoleLogger.Printf("Logging DShield submissions of user %s without sending them", cfg.DShield.UserID)
+		} else {
+			consoleLogger.Printf("Submitting connections to DShield as user %s", cfg.DShield.UserID)
+		}
+	}
+	var journal *honeypot.JournalOutput
+	if cfg.Journal.Enabled {
+		journal, err = honeypot.NewJournalOutput(cfg.Journal)
+		if err == nil {
+			err = addOutput(srv, cfg, "journal", journal)
+		}
+		if err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+		consoleLogger.Print("Writing events to the systemd journal")
+	}
+	var eventLog *honeypot.EventLogOutput
+	if cfg.EventLog.Source != "" {
+		eventLog, err = honeypot.NewEventLogOutput(cfg.EventLog)
+		if err == nil {
+			err = addOutput(srv, cfg, "event_log", eventLog)
+		}
+		if err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+		consoleLogger.Printf("Writing events to the Windows Event Log as %s", cfg.EventLog.Source)
+	}
+	var crowdsec *honeypot.CrowdSecOutput
+	if cfg.CrowdSec.URL != "" {
+		crowdsec, err = honeypot.NewCrowdSecOutput(cfg.CrowdSec, consoleLogger)
+		if err == nil {
+			err = addOutput(srv, cfg, "crowdsec", crowdsec)
+		}
+		if err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+		consoleLogger.Printf("Pushing alerts to CrowdSec at %s as machine %s", cfg.CrowdSec.URL, cfg.CrowdSec.MachineID)
+	}
+	var otel *honeypot.OTelOutput
+	if cfg.OTel.Endpoint != "" {
+		otel, err = honeypot.NewOTelOutput(cfg.OTel, consoleLogger)
+		if err == nil {
+			otel.Server = srv
+			err = addOutput(srv, cfg, "otel", otel)
+		}
+		if err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+		consoleLogger.Printf("Exporting session spans and metrics to OpenTelemetry at %s", cfg.OTel.Endpoint)
+	}
+	var statsd *honeypot.StatsDOutput
+	if cfg.StatsD.Addr != "" {
+		statsd, err = honeypot.NewStatsDOutput(cfg.StatsD, consoleLogger)
+		if err == nil {
+			err = addOutput(srv, cfg, "statsd", statsd)
+		}
+		if err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+		consoleLogger.Printf("Sending metrics to StatsD at %s", cfg.StatsD.Addr)
+	}
+	var gelf *honeypot.GELFOutput
+	if cfg.GELF.Addr != "" {
+		gelf, err = honeypot.NewGELFOutput(cfg.GELF, consoleLogger)
+		if err == nil {
+			err = addOutput(srv, cfg, "gelf", gelf)
+		}
+		if err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+		consoleLogger.Printf("Sending events to Graylog at %s", cfg.GELF.Addr)
+	}
+	var splunk *honeypot.SplunkOutput
+	if cfg.Splunk.URL != "" {
+		splunk, err = honeypot.NewSplunkOutput(cfg.Splunk, consoleLogger)
+		if err == nil {
+			err = addOutput(srv, cfg, "splunk", splunk)
+		}
+		if err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+		consoleLogger.Printf("Sending events to Splunk at %s", cfg.Splunk.URL)
+	}
+	srv.AlertWebhook = cfg.AlertWebhook
+	if f, ok := cfg.Filters["webhook"]; ok {
+		if srv.WebhookFilter, err = honeypot.NewEventFilter(f); err != nil {
+			consoleLogger.Printf("Invalid filter of output webhook: %s", err)
+			os.Exit(1)
+		}
+	}
+	setupSignalHandling(srv)
+
+	if cfg.Anomaly.Sensitivity > 0 {
+		if err := srv.EnableAnomalyDetection(cfg.Anomaly); err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.ScanDetection.Ports > 0 {
+		if err := srv.EnableScanDetection(cfg.ScanDetection); err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if len(cfg.Sampling) > 0 {
+		if err := srv.EnableSampling(cfg.Sampling); err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.NTPServer != "" {
+		srv.MonitorClock(cfg.NTPServer, time.Hour)
+	}
+
+	if cfg.Capture.Dir != "" {
+		if err := srv.EnableCapture(cfg.Capture); err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if err := registerHandlers(cfg); err != nil {
+		consoleLogger.Println(err)
+		os.Exit(1)
+	}
+
+	if !honeypot.HandlerRegistered(cfg.DefaultHandler) {
+		consoleLogger.Printf("Unknown handler %q (available: %s)", cfg.DefaultHandler, strings.Join(honeypot.HandlerNames(), ", "))
+		os.Exit(1)
+	}
+	srv.DefaultHandler = cfg.DefaultHandler
+
+	srv.Limits, err = cfg.SessionLimits()
+	if err != nil {
+		consoleLogger.Println(err)
+		os.Exit(1)
+	}
+	srv.Ports, err = cfg.PortOptions()
+	if err != nil {
+		consoleLogger.Println(err)
+		os.Exit(1)
+	}
+	if err := overrides.apply(srv.Ports); err != nil {
+		consoleLogger.Println(err)
+		os.Exit(1)
+	}
+	for port, opts := range srv.Ports {
+		if opts.Handler != "" && !honeypot.HandlerRegistered(opts.Handler) {
+			consoleLogger.Printf("Unknown handler %q for port %s (available: %s)", opts.Handler, port, strings.Join(honeypot.HandlerNames(), ", "))
+			os.Exit(1)
+		}
+	}
+	if len(srv.Ports) == 0 {
+		consoleLogger.Println("No valid ports provided. Exiting.")
+		os.Exit(1)
+	}
+	if container := honeypot.DetectContainer(); container.Runtime != "" {
+		srv.Container = &container
+		switch {
+		case container.HostNetwork:
+			consoleLogger.Printf("Running in a %s", container)
+		case cfg.Ingress:
+			for _, opts := range srv.Ports {
+				opts.Proxy = true
+			}
+			consoleLogger.Printf("Running in a %s in ingress mode, expecting PROXY headers on every port", container)
+		default:
+			consoleLogger.Printf("Running in a %s: if its ports are published through Docker's userland proxy, every client appears as the gateway; use host networking or -ingress", container)
+		}
+	}
+
+	srv.Identity, err = honeypot.NewHostIdentity(cfg.Profile, cfg.Hostname)
+	if err != nil {
+		consoleLogger.Println(err)
+		os.Exit(1)
+	}
+	if srv.Identity.LegalNotice, err = cfg.LegalNotice.Notice(cfg.Profile); err != nil {
+		consoleLogger.Printf("Invalid legal notice: %s", err)
+		os.Exit(1)
+	}
+	srv.Identity.Seed = cfg.Seed
+	srv.Files = srv.Identity.DecoyFS()
+	consoleLogger.Printf("Impersonating %s (%s) with profile %s", srv.Identity.FQDN(), srv.Identity.OS, srv.Identity.Profile)
+	if cfg.Seed != "" {
+		consoleLogger.Print("Seeded randomness: personas make up the same values on every run")
+	}
+	if len(cfg.DecoyAddresses.Hosts) > 0 {
+		if srv.Decoys, err = cfg.DecoyHosts(); err != nil {
+			consoleLogger.Printf("Invalid decoy addresses: %s", err)
+			os.Exit(1)
+		}
+		if err := srv.AnnounceDecoys(cfg.DecoyAddresses.Interface); err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+		for addr, host := range srv.Decoys {
+			for port, opts := range host.Ports {
+				if !honeypot.HandlerRegistered(opts.Handler) {
+					consoleLogger.Printf("Unknown handler %q for port %s of %s (available: %s)", opts.Handler, port, addr, strings.Join(honeypot.HandlerNames(), ", "))
+					os.Exit(1)
+				}
+			}
+			consoleLogger.Printf("Impersonating %s (%s) with profile %s on %s", host.Identity.FQDN(), host.Identity.OS, host.Identity.Profile, addr)
+		}
+	}
+	if cfg.DecoyTraffic.Enabled {
+		if err := srv.GenerateDecoyTraffic(cfg.DecoyTraffic); err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+	}
+	if cfg.Environment != "" {
+		consoleLogger.Printf("Using the %s environment of the configuration", cfg.Environment)
+	}
+
+	if cfg.Watchlist != "" {
+		wl, err := honeypot.LoadWatchlist(cfg.Watchlist)
+		if err != nil {
+			consoleLogger.Printf("Unable to load watchlist: %s", err)
+			os.Exit(1)
+		}
+		srv.Watchlist = wl
+		consoleLogger.Printf("Loaded watchlist with %d entries", wl.Len())
+	}
+	if len(cfg.Canaries) > 0 {
+		srv.Canaries = cfg.Canaries
+		consoleLogger.Printf("Watching for %d canary credentials", len(cfg.Canaries))
+	}
+	if cfg.Honeytokens.File != "" {
+		tokens, err := honeypot.OpenHoneytokens(cfg.Honeytokens)
+		if err == nil {
+			err = srv.EnableHoneytokens(tokens)
+		}
+		if err != nil {
+			consoleLogger.Printf("Unable to plant honeytokens: %s", err)
+			os.Exit(1)
+		}
+		consoleLogger.Printf("Watching for %d honeytokens of %s", len(tokens.Tokens()), cfg.Honeytokens.File)
+		startHoneytokenTracker(srv, cfg.Honeytokens.Listen)
+	}
+	if len(cfg.Feeds) > 0 {
+		if srv.Reputation, err = honeypot.NewReputationFeeds(cfg.Feeds, consoleLogger); err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+	}
+	if cfg.GreyNoise.Enabled {
+		if srv.GreyNoise, err = honeypot.NewGreyNoise(cfg.GreyNoise, consoleLogger); err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+		consoleLogger.Print("Classifying clients with GreyNoise")
+	}
+
+	if cfg.Responder.URL != "" {
+		if srv.Responder, err = honeypot.NewResponder(cfg.Responder); err != nil {
+			consoleLogger.Printf("Invalid responder: %s", err)
+			os.Exit(1)
+		}
+		consoleLogger.Printf("Generating replies with %s", cfg.Responder.URL)
+	}
+
+	if cfg.ModbusDevice != "" {
+		device, err := honeypot.LoadModbusDevice(cfg.ModbusDevice)
+		if err != nil {
+			consoleLogger.Printf("Unable to load Modbus device profile: %s", err)
+			os.Exit(1)
+		}
+		srv.ModbusDevice = device
+		consoleLogger.Printf("Impersonating Modbus device %s %s", device.Vendor, device.ProductCode)
+	}
+	if cfg.SNMPAgent != "" {
+		if srv.SNMPAgent, err = honeypot.LoadSNMPAgent(cfg.SNMPAgent); err != nil {
+			consoleLogger.Printf("Unable to load SNMP agent profile: %s", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.Elasticsearch != "" {
+		if srv.Elasticsearch, err = honeypot.LoadElasticsearchCluster(cfg.Elasticsearch); err != nil {
+			consoleLogger.Printf("Unable to load Elasticsearch cluster profile: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.UPnPDevice != "" {
+		if srv.UPnPDevice, err = honeypot.LoadUPnPDevice(cfg.UPnPDevice); err != nil {
+			consoleLogger.Printf("Unable to load UPnP device profile: %s", err)
+			os.Exit(1)
+		}
+	}
+	srv.TFTPRoot = cfg.TFTPRoot
+	if cfg.ShellTree != "" {
+		if srv.ShellTree, err = honeypot.LoadShellTree(cfg.ShellTree); err != nil {
+			consoleLogger.Printf("Unable to load shell file tree: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	geo, err := loadGeo(cfg)
+	if err != nil {
+		consoleLogger.Println(err)
+		os.Exit(1)
+	}
+	agg, err := setupReports(srv, cfg, geo)
+	if err != nil {
+		consoleLogger.Println(err)
+		os.Exit(1)
+	}
+	sharer, err := setupSharing(srv, cfg, geo)
+	if err != nil {
+		consoleLogger.Println(err)
+		os.Exit(1)
+	}
+	trends, err := setupTrends(srv, cfg)
+	if err != nil {
+		consoleLogger.Println(err)
+		os.Exit(1)
+	}
+	st, err := setupStorage(srv, cfg)
+	if err != nil {
+		consoleLogger.Println(err)
+		os.Exit(1)
+	}
+	artifacts, err := setupArtifacts(srv, cfg)
+	if err != nil {
+		consoleLogger.Println(err)
+		os.Exit(1)
+	}
+	var archiver *honeypot.Archiver
+	if cfg.Archive.Bucket != "" {
+		if archiver, err = honeypot.NewArchiver(cfg.Archive, cfg.ArchiveSources(), consoleLogger); err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+		archiver.Start()
+		consoleLogger.Printf("Archiving logs and artifacts to %s", archiver.URL())
+	}
+	purger := &honeypot.Purger{Storage: st, Logs: logFile, FailLog: cfg.FailLog.Path, Artifacts: artifacts, CaptureDir: cfg.Capture.Dir, ReportDir: cfg.Reports.Dir, Archive: archiver}
+	var collector *honeypot.Collector
+	if cfg.Collector.Listen != "" {
+		collector = honeypot.NewCollector(srv)
+		if err := eventrpc.ServeCollector(collector, cfg.Collector, consoleLogger); err != nil {
+			consoleLogger.Printf("Unable to start the collector: %s", err)
+			os.Exit(1)
+		}
+		consoleLogger.Printf("Collecting the events of sensors on %s", cfg.Collector.Listen)
+	}
+	startAPI(srv, agg, st, artifacts, purger, collector, cfg.API)
+	if err := startStream(srv, cfg.API); err != nil {
+		consoleLogger.Printf("Unable to start the event stream: %s", err)
+		os.Exit(1)
+	}
+
+	if cfg.Firewall.Backend != "" {
+		if err := srv.EnableFirewall(cfg.Firewall); err != nil {
+			consoleLogger.Printf("Unable to enable the firewall: %s", err)
+			os.Exit(1)
+		}
+		consoleLogger.Printf("Blocking clients on %s", cfg.Firewall.Backend)
+	}
+
+	var reload atomic.Bool
+	if cfg.Kubernetes.Enabled {
+		if err := honeypot.ServeProbes(srv, cfg.Kubernetes.ProbeAddr()); err != nil {
+			consoleLogger.Printf("Unable to serve the probe endpoints: %s", err)
+			os.Exit(1)
+		}
+		consoleLogger.Printf("Probe endpoints listening on %s", cfg.Kubernetes.ProbeAddr())
+		interval, err := cfg.Kubernetes.ReloadInterval()
+		if err != nil {
+			consoleLogger.Println(err)
+			os.Exit(1)
+		}
+		if interval > 0 && cfg.Path != "" {
+			watchConfig(srv, cfg.Path, interval, &reload)
+		}
+	}
+
+	if cfg.Sandbox {
+		policy := cfg.SandboxPolicy()
+		srv.Sandbox = &policy
+	}
+	srv.Summary = cfg.RunSummary()
+
+	ports := make([]string, 0, len(srv.Ports))
+	for port := range srv.Ports {
+		ports = append(ports, port)
+	}
+	ports = append(ports, srv.DecoyPorts()...)
+	sort.Slice(ports, func(i, j int) bool {
+		a, _ := strconv.Atoi(ports[i])
+		b, _ := strconv.Atoi(ports[j])
+		return a < b
+	})
+	if srv.RedirectPort == "" && len(ports) > honeypot.MaxListeners {
+		consoleLogger.Printf("%d ports to listen on, more than %d: redirect them to one port with netfilter and set -redirect-port", len(ports), honeypot.MaxListeners)
+		os.Exit(1)
+	}
+	srv.ListenAndServe(ports)
+
+	srv.Emit(honeypot.Event{Type: honeypot.EventInfo, Message: "Sessions by attack stage: " + srv.StageSummary()})
+	srv.Emit(honeypot.Event{Type: honeypot.EventInfo, Message: "Connection pool: " + srv.Stats().String()})
+	srv.Emit(honeypot.Event{Type: honeypot.EventInfo, Message: "Application shutting down."})
+	for _, remote := range remotes {
+		remote.Close() // Deliver the events still queued
+		consoleLogger.Printf("Output %s: %s", remote.Name(), remote.Stats())
+	}
+	if dshield != nil {
+		dshield.Close() // Submit the connections still queued
+		consoleLogger.Printf("Output %s: %s", dshield.Name(), dshield.Stats())
+	}
+	if journal != nil {
+		journal.Close()
+	}
+	if eventLog != nil {
+		eventLog.Close()
+	}
+	if crowdsec != nil {
+		crowdsec.Close() // Push the sessions still queued
+		consoleLogger.Printf("Output %s: %s", crowdsec.Name(), crowdsec.Stats())
+	}
+	if otel != nil {
+		otel.Close() // Export the spans still queued and the last metrics
+		consoleLogger.Printf("Output %s: %s", otel.Name(), otel.Stats())
+	}
+	if statsd != nil {
+		statsd.Close() // Send the metrics still waiting
+	}
+	if gelf != nil {
+		gelf.Close() // Send the events still queued
+		consoleLogger.Printf("Output %s: %s", gelf.Name(), gelf.Stats())
+	}
+	if splunk != nil {
+		splunk.Close() // Send the events still queued
+		consoleLogger.Printf("Output %s: %s", splunk.Name(), splunk.Stats())
+	}
+	if agg != nil {
+		agg.Close() // Store the report of the last partial period
+	}
+	if trends != nil {
+		trends.Close() // Store the summary of the current week
+	}
+	if sharer != nil {
+		sharer.Close() // Share the statistics of the last partial period
+	}
+	if st != nil {
+		st.Close()
+	}
+	if artifacts != nil {
+		if err := artifacts.Close(); err != nil { // Store the artifacts seen since the last save
+			consoleLogger.Printf("Error storing artifact database: %s", err)
+		}
+	}
+	if srv.Reputation != nil {
+		srv.Reputation.Close()
+	}
+	logFile.Close() // Close the log file
+	if archiver != nil {
+		archiver.Close() // Upload the files still pending
+		consoleLogger.Printf("Archive: %s", archiver.Stats())
+	}
+	if failLog != nil {
+		failLog.Close()
+	}
+	if reload.Load() {
+		reexec()
+	}
+}
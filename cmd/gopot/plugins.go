@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"plugin"
+	"sort"
+	"strings"
+
+	"github.com/jackyes/GoPot/pkg/honeypot"
+)
+
+// loadPlugin opens a Go plugin built with -buildmode=plugin. Plugins register
+// their handlers with honeypot.RegisterHandler from an init function, which
+// runs when the plugin is opened; loadPlugin returns the names they added.
+// A handler name the plugin registers twice, or that the binary or an
+// earlier plugin already has, is returned as an error rather than crashing
+// the sensor. Plugins may also check with honeypot.AddHandler.
+func loadPlugin(path string) (added []string, err error) {
+	before := make(map[string]bool)
+	for _, name := range honeypot.HandlerNames() {
+		before[name] = true
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			// The panic of RegisterHandler in the init function of the plugin
+			added, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	if _, err := plugin.Open(path); err != nil {
+		return nil, err
+	}
+
+	for _, name := range honeypot.HandlerNames() {
+		if !before[name] {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	return added, nil
+}
+
+// registerHandlers loads the plugins and registers the dialog scripts of
+// cfg, whose handlers are then served like the built-in ones.
+func registerHandlers(cfg *honeypot.Config) error {
+	for _, path := range cfg.Plugins {
+		names, err := loadPlugin(path)
+		if err != nil {
+			return fmt.Errorf("unable to load plugin %s: %w", path, err)
+		}
+		consoleLogger.Printf("Loaded plugin %s with handlers: %s", path, strings.Join(names, ", "))
+	}
+	for name, path := range cfg.Scripts {
+		script, err := honeypot.LoadDialogScript(path)
+		if err != nil {
+			return fmt.Errorf("unable to load script: %w", err)
+		}
+		if honeypot.HandlerRegistered(name) || honeypot.AddHandler(name, script) != nil {
+			return fmt.Errorf("script name %q is already used by a handler", name)
+		}
+	}
+	return nil
+}
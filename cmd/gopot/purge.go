@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/jackyes/GoPot/pkg/honeypot"
+)
+
+// purge deletes what the configured backends keep about ip, prints the JSON
+// purge report on the standard output, also writing it to reportPath if
+// set, and returns the exit status: 1 if a backend could not be purged.
+// Run it with the configuration of the sensor, while the sensor is stopped
+// or through its API, so the files it rewrites are not being written.
+func purge(cfg *honeypot.Config, ip, reportPath string) int {
+	if ip == "" {
+		fmt.Fprintln(os.Stderr, "purge needs -ip, the client address to purge")
+		return 2
+	}
+	purger := &honeypot.Purger{FailLog: cfg.FailLog.Path, CaptureDir: cfg.Capture.Dir, ReportDir: cfg.Reports.Dir}
+	logs, err := honeypot.NewLogFileOutput(cfg.LogDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer logs.Close()
+	purger.Logs = logs
+	if cfg.Storage.DSN != "" {
+		st, err := honeypot.OpenStorage(cfg.Storage.DSN)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to open storage: %s\n", err)
+			return 1
+		}
+		defer st.Close()
+		purger.Storage = st
+	}
+	if cfg.Artifacts.Path != "" {
+		// No reverse DNS lookups are needed to delete
+		db, err := honeypot.NewArtifactDB(honeypot.ArtifactConfig{Path: cfg.Artifacts.Path}, consoleLogger)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer db.Close()
+		purger.Artifacts = db
+	}
+
+	if cfg.Archive.Bucket != "" {
+		archiver, err := honeypot.NewArchiver(cfg.Archive, cfg.ArchiveSources(), consoleLogger)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		purger.Archive = archiver
+	}
+
+	report, err := purger.Purge(ip)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	content, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(content))
+	if reportPath != "" {
+		if err := os.WriteFile(reportPath, append(content, '\n'), 0o640); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write the purge report: %s\n", err)
+			return 1
+		}
+	}
+	if len(report.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackyes/GoPot/pkg/honeypot"
+	"github.com/jackyes/GoPot/pkg/testkit"
+)
+
+// replayOptions select the sessions a replay reads and what it does with them.
+type replayOptions struct {
+	session  string        // session of the stored or exported events replayed, empty for all those of the files
+	target   string        // address of a live sensor the sessions are replayed against, empty to replay in process
+	port     string        // port plain transcripts were recorded on
+	udp      bool          // plain transcripts are sessions of datagrams
+	quiet    time.Duration // how long the server may stay silent before the next step is sent, 0 for the default
+	timeline bool          // print the recorded sessions instead of replaying them
+}
+
+// replaySession is a session to replay: the client side of a fixture and
+// what was recorded of it.
+type replaySession struct {
+	fixture  *testkit.Fixture
+	expected testkit.Transcript // both sides as recorded, nil if unknown
+	events   []honeypot.Event   // as recorded by a sensor, nil if unknown
+}
+
+// replay replays the client side of recorded sessions, read from the files
+// named on the command line or from the event storage, against a handler
+// run in process or a live sensor, prints what each side sent and the
+// events raised, and returns the exit status: 1 if a replayed transcript
+// differs from the recorded one. With opts.timeline, the recorded sessions
+// are printed instead.
+func replay(cfg *honeypot.Config, opts replayOptions) int {
+	if err := registerHandlers(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	sessions, err := replaySessions(cfg, opts, flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	status := 0
+	for i, s := range sessions {
+		if i > 0 {
+			fmt.Println()
+		}
+		f := s.fixture
+		if opts.quiet > 0 {
+			f.Quiet = opts.quiet
+		}
+		transport := "tcp"
+		if f.UDP {
+			transport = "udp"
+		}
+		fmt.Printf("== %s: %s on port %s/%s, %d client steps\n", f.Name, f.Handler, f.Port, transport, len(f.Client))
+		if opts.timeline {
+			switch {
+			case s.events != nil:
+				printTimeline(s.events)
+			case s.expected != nil:
+				fmt.Print(s.expected.String())
+			default:
+				fmt.Print(f.Client.String())
+			}
+			continue
+		}
+
+		var (
+			got    testkit.Transcript
+			events []honeypot.Event
+		)
+		if opts.target != "" {
+			conn, err := net.Dial(transport, opts.target)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			got = testkit.Exchange(conn, f.Client, f.Quiet, f.UDP)
+		} else {
+			result, err := testkit.Run(f)
+			if err != nil {
+				fmt.Printf("FAIL %s: %s\n", f.Name, err)
+				status = 1
+				continue
+			}
+			got = result.Transcript
+			for _, ev := range result.Events {
+				if ev.Session != "" { // not the messages of the server's shutdown
+					events = append(events, ev)
+				}
+			}
+		}
+		fmt.Print(got.String())
+		if len(events) > 0 {
+			fmt.Println("-- events")
+			printTimeline(events)
+		}
+		if s.events != nil && opts.target == "" {
+			fmt.Printf("-- alerts recorded: %s; replayed: %s\n", alertKinds(s.events), alertKinds(events))
+		}
+		if s.expected != nil && opts.target == "" {
+			if diff := testkit.Diff(s.expected.Mask(f.Masks), got); diff != "" {
+				fmt.Printf("FAIL %s: %s", f.Name, diff)
+				status = 1
+			} else {
+				fmt.Printf("ok   %s: the transcript matches the recording\n", f.Name)
+			}
+		}
+	}
+	return status
+}
+
+// replaySessions reads the sessions opts select from files: fixtures, with
+// their golden transcript if they have one, JSON lines of exported events,
+// or plain transcripts, served by the configured default handler. Without
+// files, the session named by opts is read from the event storage.
+func replaySessions(cfg *honeypot.Config, opts replayOptions, files []string) ([]replaySession, error) {
+	if len(files) == 0 {
+		if opts.session == "" || cfg.Storage.DSN == "" {
+			return nil, fmt.Errorf("replay needs transcript, fixture or event files, or -session and an event storage")
+		}
+		st, err := honeypot.OpenStorage(cfg.Storage.DSN)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open storage: %w", err)
+		}
+		defer st.Close()
+		events, err := st.Query(honeypot.StorageQuery{Session: opts.session})
+		if err != nil {
+			return nil, err
+		}
+		if len(events) == 0 {
+			return nil, fmt.Errorf("no events of session %s are stored", opts.session)
+		}
+		f, err := testkit.SessionFixture(events, cfg.Profile)
+		if err != nil {
+			return nil, err
+		}
+		return []replaySession{{fixture: f, events: events}}, nil
+	}
+
+	var sessions []replaySession
+	for _, path := range files {
+		switch filepath.Ext(path) {
+		case ".fixture":
+			f, err := testkit.LoadFixture(path)
+			if err != nil {
+				return nil, err
+			}
+			s := replaySession{fixture: f}
+			if content, err := os.ReadFile(f.GoldenPath()); err == nil {
+				if s.expected, err = testkit.ParseTranscript(string(content)); err != nil {
+					return nil, fmt.Errorf("%s: %w", f.GoldenPath(), err)
+				}
+			}
+			sessions = append(sessions, s)
+		case ".json", ".jsonl":
+			bySession, order, err := readSessions(path, opts.session)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range order {
+				f, err := testkit.SessionFixture(bySession[id], cfg.Profile)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", path, err)
+				}
+				sessions = append(sessions, replaySession{fixture: f, events: bySession[id]})
+			}
+		default:
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			t, err := testkit.ParseTranscript(string(content))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			f := &testkit.Fixture{
+				Path:    path,
+				Name:    strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+				Handler: cfg.DefaultHandler,
+				Port:    opts.port,
+				UDP:     opts.udp,
+				Profile: cfg.Profile,
+				Seed:    testkit.DefaultSeed,
+				Quiet:   testkit.DefaultQuiet,
+			}
+			for _, step := range t {
+				if step.From == testkit.FromClient {
+					f.Client = append(f.Client, step)
+				}
+			}
+			sessions = append(sessions, replaySession{fixture: f, expected: t})
+		}
+	}
+	if len(sessions) == 0 {
+		return nil, fmt.Errorf("no session %s in %s", opts.session, strings.Join(files, ", "))
+	}
+	return sessions, nil
+}
+
+// readSessions reads a file of events, one JSON object per line as the
+// file storage and the API export write them, and returns the events of
+// each session, or of session only if set, in order, and the sessions in
+// the order they started.
+func readSessions(path, session string) (map[string][]honeypot.Event, []string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	bySession := make(map[string][]honeypot.Event)
+	var order []string
+	sc := bufio.NewScanner(file)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	for n := 1; sc.Scan(); n++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var ev honeypot.Event
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		if ev.Session == "" || session != "" && ev.Session != session {
+			continue
+		}
+		if _, ok := bySession[ev.Session]; !ok {
+			order = append(order, ev.Session)
+		}
+		bySession[ev.Session] = append(bySession[ev.Session], ev)
+	}
+	return bySession, order, sc.Err()
+}
+
+// printTimeline prints events one per line: the time since the first, the
+// type, with the severity of alerts, and the log line.
+func printTimeline(events []honeypot.Event) {
+	for _, ev := range events {
+		kind := ev.Type
+		if ev.Severity != "" {
+			kind += "/" + ev.Severity
+		}
+		offset := ev.Time.Sub(events[0].Time)
+		if ev.BootID != "" && ev.BootID == events[0].BootID {
+			offset = ev.Mono - events[0].Mono // unaffected by clock changes
+		}
+		fmt.Printf("+%-9s %-16s %s\n", offset.Round(time.Millisecond), kind, ev.Text())
+	}
+}
+
+// alertKinds lists the kinds of the alerts among events, "none" if there
+// are none.
+func alertKinds(events []honeypot.Event) string {
+	var kinds []string
+	for _, ev := range events {
+		if kind, _ := ev.Fields["alert"].(string); ev.Type == honeypot.EventAlert && kind != "" {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) == 0 {
+		return "none"
+	}
+	return strings.Join(kinds, ", ")
+}
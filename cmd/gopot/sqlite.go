@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package main
+
+// The SQLite driver of sqlite:// event storage, linked into binaries built
+// with -tags sqlite. It is written in Go, so the binary still needs no cgo.
+import _ "modernc.org/sqlite"
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jackyes/GoPot/pkg/honeypot"
+)
+
+// validate reports the problems of the configuration on the standard output
+// without binding any sockets, and returns the exit status: 1 if any problem
+// is an error. Plugins are loaded, and valid scripts registered, so the
+// handlers they provide can be checked.
+func validate(cfg *honeypot.Config, overrides portOverrides) int {
+	var problems []honeypot.ConfigProblem
+	for i, path := range cfg.Plugins {
+		if _, err := loadPlugin(path); err != nil {
+			problems = append(problems, honeypot.ConfigProblem{Field: fmt.Sprintf("plugins[%d]", i), Message: err.Error()})
+		}
+	}
+	problems = append(problems, cfg.Validate()...)
+
+	for name, path := range cfg.Scripts {
+		if honeypot.HandlerRegistered(name) {
+			continue
+		}
+		if script, err := honeypot.LoadDialogScript(path); err == nil {
+			honeypot.RegisterHandler(name, script)
+		}
+	}
+	if ports, err := cfg.PortOptions(); err == nil {
+		if err := overrides.apply(ports); err != nil {
+			problems = append(problems, honeypot.ConfigProblem{Field: "command line", Message: err.Error()})
+		}
+	}
+
+	errors, warnings := 0, 0
+	for _, p := range problems {
+		fmt.Println(p)
+		if p.Warning {
+			warnings++
+		} else {
+			errors++
+		}
+	}
+	if errors > 0 {
+		fmt.Printf("Configuration is invalid: %d error(s), %d warning(s).\n", errors, warnings)
+		return 1
+	}
+	fmt.Printf("Configuration is valid: %d warning(s).\n", warnings)
+	return 0
+}
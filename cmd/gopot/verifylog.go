@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jackyes/GoPot/pkg/honeypot"
+)
+
+// verifyLog checks the signature chain of the text log files given, by
+// default the daily archives of the log directory and log.txt, with the
+// configured signing method and key, prints the lines failing and a
+// summary, and returns the exit status: 1 if a line fails.
+func verifyLog(cfg *honeypot.Config) int {
+	if cfg.LogSigning.Method == "" || cfg.LogSigning.Key == "" {
+		fmt.Fprintln(os.Stderr, "verify-log needs -log-signing and -log-signing-key, the initial HMAC key or the Ed25519 public key")
+		return 2
+	}
+	files := flag.Args()
+	if len(files) == 0 {
+		files, _ = filepath.Glob(filepath.Join(cfg.LogDir, "log-*.txt"))
+		sort.Strings(files)
+		if _, err := os.Stat(filepath.Join(cfg.LogDir, "log.txt")); err == nil {
+			files = append(files, filepath.Join(cfg.LogDir, "log.txt"))
+		}
+	}
+	v, err := honeypot.VerifyLog(cfg.LogSigning.Method, cfg.LogSigning.Key, files)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	for _, problem := range v.Problems {
+		fmt.Println(problem)
+	}
+	fmt.Printf("%d files, %d lines verified, %d unsigned lines before signing was enabled, %d problems\n", v.Files, v.Lines, v.Unsigned, len(v.Problems))
+	if len(v.Problems) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,194 @@
+package eventrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+
+	"github.com/jackyes/GoPot/pkg/eventrpc/gopotv1"
+	"github.com/jackyes/GoPot/pkg/honeypot"
+	_ "github.com/jackyes/GoPot/pkg/zstd" // registered before the gRPC compressors are
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip" // gzip is left to the compressor of gRPC
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxBatch bounds a batch a sensor sends, before and after decompression.
+const maxBatch = 64 << 20
+
+func init() {
+	honeypot.RegisterRemoteTransport("grpcs", openCollector)
+	for _, name := range honeypot.Compressions() {
+		if encoding.GetCompressor(name) == nil {
+			c, _ := honeypot.LookupCompression(name)
+			encoding.RegisterCompressor(grpcCompressor{name: name, compression: c})
+		}
+	}
+}
+
+// grpcCompressor offers gRPC a compression of package honeypot, so that
+// batches travel with the compression of their remote output.
+type grpcCompressor struct {
+	name        string
+	compression honeypot.Compression
+}
+
+func (c grpcCompressor) Name() string { return c.name }
+
+func (c grpcCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return c.compression.NewWriter(w)
+}
+
+func (c grpcCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	zr, err := c.compression.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return closeAtEOF{zr}, nil
+}
+
+// closeAtEOF releases a decompressor once it is read to the end, since gRPC
+// never closes it.
+type closeAtEOF struct{ io.ReadCloser }
+
+func (r closeAtEOF) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		r.Close()
+	}
+	return n, err
+}
+
+// ServeCollector listens on cfg.Listen and serves the Collector service of
+// gopotv1 in the background, with mutual TLS, passing the batches of
+// sensors to c. Failures are reported to logger.
+func ServeCollector(c *honeypot.Collector, cfg honeypot.CollectorConfig, logger *log.Logger) error {
+	config, err := cfg.TLSConfig()
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return err
+	}
+	gs := grpc.NewServer(grpc.Creds(credentials.NewTLS(config)), grpc.MaxRecvMsgSize(maxBatch))
+	gopotv1.RegisterCollectorServer(gs, &collectorService{collector: c})
+	go func() {
+		if err := gs.Serve(ln); err != nil {
+			logger.Printf("Collector stopped: %s", err)
+		}
+	}()
+	return nil
+}
+
+type collectorService struct {
+	gopotv1.UnimplementedCollectorServer
+	collector *honeypot.Collector
+}
+
+// Send implements gopotv1.CollectorServer. The sensor is named by the
+// common name of its certificate.
+func (s *collectorService) Send(ctx context.Context, req *gopotv1.SendRequest) (*gopotv1.SendResponse, error) {
+	p, _ := peer.FromContext(ctx)
+	var sensor string
+	if p != nil {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+			sensor = info.State.PeerCertificates[0].Subject.CommonName
+		}
+	}
+	if sensor == "" {
+		return nil, status.Error(codes.Unauthenticated, "a client certificate with a common name is required")
+	}
+	events := make([]honeypot.Event, len(req.GetEvents()))
+	for i, msg := range req.GetEvents() {
+		events[i] = FromProto(msg)
+	}
+	s.collector.Receive(sensor, p.Addr.String(), events)
+	return &gopotv1.SendResponse{Events: uint32(len(events))}, nil
+}
+
+// collectorTransport delivers the batches of a remote output to the
+// Collector service of a GoPot, for grpcs URLs.
+type collectorTransport struct {
+	conn     *grpc.ClientConn
+	client   gopotv1.CollectorClient
+	metadata metadata.MD
+}
+
+// openCollector implements honeypot.RemoteTransportOpener. Headers are sent
+// as metadata.
+func openCollector(cfg honeypot.RemoteConfig) (honeypot.RemoteTransport, error) {
+	if cfg.TLS == nil {
+		return nil, errors.New("grpcs URLs need tls with the client certificate of the sensor")
+	}
+	config, err := cfg.TLS.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.NewClient(u.Host, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	if err != nil {
+		return nil, err
+	}
+	return &collectorTransport{conn: conn, client: gopotv1.NewCollectorClient(conn), metadata: metadata.New(cfg.Headers)}, nil
+}
+
+// Encode implements honeypot.RemoteTransport, returning a SendRequest.
+func (t *collectorTransport) Encode(events []honeypot.Event) ([]byte, error) {
+	req := &gopotv1.SendRequest{Events: make([]*gopotv1.Event, len(events))}
+	for i, ev := range events {
+		msg, err := ToProto(ev)
+		if err != nil {
+			return nil, err
+		}
+		req.Events[i] = msg
+	}
+	return proto.Marshal(req)
+}
+
+// Send implements honeypot.RemoteTransport. A compressed batch is sent
+// with the gRPC compressor of its encoding.
+func (t *collectorTransport) Send(ctx context.Context, body []byte, encoding string) error {
+	var opts []grpc.CallOption
+	if encoding != "" {
+		compression, ok := honeypot.LookupCompression(encoding)
+		if !ok {
+			return fmt.Errorf("unsupported compression %q", encoding)
+		}
+		zr, err := compression.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		body, err = io.ReadAll(io.LimitReader(zr, maxBatch))
+		zr.Close()
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.UseCompressor(encoding))
+	}
+	req := new(gopotv1.SendRequest)
+	if err := proto.Unmarshal(body, req); err != nil {
+		return err
+	}
+	_, err := t.client.Send(metadata.NewOutgoingContext(ctx, t.metadata), req, opts...)
+	return err
+}
+
+// Close implements honeypot.RemoteTransport.
+func (t *collectorTransport) Close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,84 @@
+// Package eventrpc carries the events of a GoPot over gRPC, with the
+// protobuf schema of package gopotv1: the live event stream, and the
+// collector receiving the events of sensors, whose remote outputs deliver
+// to grpcs URLs once this package is imported. It lives apart from package
+// honeypot, which only depends on the standard library.
+package eventrpc
+
+//go:generate buf generate
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jackyes/GoPot/pkg/eventrpc/gopotv1"
+	"github.com/jackyes/GoPot/pkg/honeypot"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ToProto converts ev to its protobuf message. Fields go through JSON, as
+// they would in a JSON output, so that their values take the same shape.
+func ToProto(ev honeypot.Event) (*gopotv1.Event, error) {
+	msg := &gopotv1.Event{
+		SchemaVersion: int32(ev.Schema),
+		Time:          timestamppb.New(ev.Time),
+		Seq:           ev.Seq,
+		MonoNs:        int64(ev.Mono),
+		BootId:        ev.BootID,
+		ClockOffsetNs: int64(ev.Offset),
+		Type:          ev.Type,
+		Session:       ev.Session,
+		Handler:       ev.Handler,
+		Severity:      ev.Severity,
+		Port:          ev.Port,
+		SrcAddr:       ev.SrcAddr,
+		DstAddr:       ev.DstAddr,
+		Host:          ev.Host,
+		Message:       ev.Message,
+		Labels:        ev.Labels,
+	}
+	if len(ev.Fields) > 0 {
+		data, err := json.Marshal(ev.Fields)
+		if err != nil {
+			return nil, err
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+		if msg.Fields, err = structpb.NewStruct(fields); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// FromProto converts msg back to an event, with the fields a JSON decoder
+// would give.
+func FromProto(msg *gopotv1.Event) honeypot.Event {
+	ev := honeypot.Event{
+		Schema:   int(msg.GetSchemaVersion()),
+		Seq:      msg.GetSeq(),
+		Mono:     time.Duration(msg.GetMonoNs()),
+		BootID:   msg.GetBootId(),
+		Offset:   time.Duration(msg.GetClockOffsetNs()),
+		Type:     msg.GetType(),
+		Session:  msg.GetSession(),
+		Handler:  msg.GetHandler(),
+		Severity: msg.GetSeverity(),
+		Port:     msg.GetPort(),
+		SrcAddr:  msg.GetSrcAddr(),
+		DstAddr:  msg.GetDstAddr(),
+		Host:     msg.GetHost(),
+		Message:  msg.GetMessage(),
+		Labels:   msg.GetLabels(),
+	}
+	if msg.GetTime() != nil {
+		ev.Time = msg.GetTime().AsTime()
+	}
+	if msg.GetFields() != nil {
+		ev.Fields = msg.GetFields().AsMap()
+	}
+	return ev
+}
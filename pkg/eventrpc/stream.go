@@ -0,0 +1,117 @@
+package eventrpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jackyes/GoPot/pkg/eventrpc/gopotv1"
+	"github.com/jackyes/GoPot/pkg/honeypot"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// ServeStream listens on addr and serves the EventStream service of
+// gopotv1 in the background, passing on the events of stream. When token
+// is set, clients authenticate with "authorization: Bearer <token>"
+// metadata, as on the HTTP API. Reflection lists the service for tools
+// such as grpcurl.
+func ServeStream(stream *honeypot.EventStream, addr, token string, logger *log.Logger) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	gs := grpc.NewServer()
+	gopotv1.RegisterEventStreamServer(gs, &streamService{stream: stream, token: token, logger: logger})
+	reflection.Register(gs)
+	go func() {
+		if err := gs.Serve(ln); err != nil {
+			logger.Printf("Event stream stopped: %s", err)
+		}
+	}()
+	return nil
+}
+
+type streamService struct {
+	gopotv1.UnimplementedEventStreamServer
+	stream *honeypot.EventStream
+	token  string
+	logger *log.Logger
+}
+
+// Subscribe implements gopotv1.EventStreamServer. When the client falls
+// behind, an info event tells how many events it missed.
+func (s *streamService) Subscribe(req *gopotv1.SubscribeRequest, out gopotv1.EventStream_SubscribeServer) error {
+	if err := authorize(out.Context(), s.token); err != nil {
+		return err
+	}
+	filter, err := honeypot.ParseStreamFilter(strings.Join(req.GetTypes(), ","), req.GetPorts(), strings.Join(req.GetSrcIps(), ","), req.GetMinSeverity())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	sub, err := s.stream.Subscribe(filter)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	defer sub.Close()
+	// Tell the client the subscription is live before the first event
+	if err := out.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	var reported uint64
+	for {
+		select {
+		case <-out.Context().Done():
+			return nil
+		case ev := <-sub.C:
+			if dropped := sub.Dropped(); dropped != reported {
+				if err := s.send(out, honeypot.Event{
+					Time:    time.Now(),
+					Type:    honeypot.EventInfo,
+					Message: fmt.Sprintf("Event stream fell behind and missed %d events", dropped-reported),
+					Fields:  map[string]any{"dropped": dropped - reported},
+				}); err != nil {
+					return err
+				}
+				reported = dropped
+			}
+			if err := s.send(out, ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// send sends ev, skipping it when it cannot be converted.
+func (s *streamService) send(out gopotv1.EventStream_SubscribeServer, ev honeypot.Event) error {
+	msg, err := ToProto(ev)
+	if err != nil {
+		s.logger.Printf("Event stream skipped a %s event: %s", ev.Type, err)
+		return nil
+	}
+	return out.Send(msg)
+}
+
+// authorize checks the bearer token of the call when token is set.
+func authorize(ctx context.Context, token string) error {
+	if token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	got := ""
+	if values := md.Get("authorization"); len(values) > 0 {
+		got = values[0]
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+token)) != 1 {
+		return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package honeypot
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// reportTopPorts is the number of ports listed per row of a report.
+const reportTopPorts = 5
+
+// AggregateReport summarises the connections of one period by country, by
+// autonomous system and, on sensors with virtual hosts, by host reached,
+// and the engagement of the sessions that ended in it by persona.
+type AggregateReport struct {
+	Start     time.Time      `json:"start"`
+	End       time.Time      `json:"end"`
+	Countries []AggregateRow `json:"countries,omitempty"` // by connections, descending
+	ASNs      []AggregateRow `json:"asns,omitempty"`      // by connections, descending
+	Hosts     []AggregateRow `json:"hosts,omitempty"`     // by connections, descending, see Event.Host
+	Personas  []PersonaRow   `json:"personas,omitempty"`  // by persona, best score first
+}
+
+// AggregateRow is the activity of one country, autonomous system or host.
+type AggregateRow struct {
+	Key              string      `json:"key"`            // country code, "AS" followed by the number or host name
+	Name             string      `json:"name,omitempty"` // AS description
+	Connections      int         `json:"connections"`
+	Sources          int         `json:"sources"`           // distinct source addresses
+	NewSources       int         `json:"new_sources"`       // sources never seen in an earlier period
+	ReturningSources int         `json:"returning_sources"` // sources seen in an earlier period
+	TopPorts         []PortCount `json:"top_ports"`
+}
+
+// PortCount is the number of connections to a port.
+type PortCount struct {
+	Port        string `json:"port"`
+	Connections int    `json:"connections"`
+}
+
+// aggregateBucket accumulates one row during a period.
+type aggregateBucket struct {
+	name        string
+	connections int
+	sources     map[string]bool // source address -> new in this period
+	ports       map[string]int
+}
+
+// Aggregator is an Output that counts connections per country and
+// autonomous system and closes a report every interval. Reports are appended
+// to reports.jsonl in its directory and the source addresses seen so far to
+// sources.txt, so new and returning sources are told apart across restarts.
+type Aggregator struct {
+	geo      *GeoDB
+	dir      string
+	interval time.Duration
+
+	mu        sync.Mutex
+	start     time.Time
+	countries map[string]*aggregateBucket
+	asns      map[string]*aggregateBucket
+	hosts     map[string]*aggregateBucket
+	personas  map[string]*PersonaRow
+	seen      map[string]bool // sources of earlier periods
+	reports   []AggregateReport
+	sources   *os.File
+
+	log       *log.Logger
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewAggregator loads the reports and sources stored in dir and starts
+// counting. geo may be nil, in which case every source is unknown. Errors
+// storing reports are written to logger.
+func NewAggregator(dir string, interval time.Duration, geo *GeoDB, logger *log.Logger) (*Aggregator, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid report interval %s", interval)
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	a := &Aggregator{geo: geo, dir: dir, interval: interval, seen: make(map[string]bool), log: logger, stop: make(chan struct{})}
+	a.reset(time.Now())
+
+	if err := a.loadReports(); err != nil {
+		return nil, err
+	}
+	sources, err := os.OpenFile(filepath.Join(dir, "sources.txt"), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(sources)
+	for scanner.Scan() {
+		a.seen[scanner.Text()] = true
+	}
+	if err := scanner.Err(); err != nil {
+		sources.Close()
+		return nil, err
+	}
+	a.sources = sources
+	go a.run()
+	return a, nil
+}
+
+func (a *Aggregator) loadReports() error {
+	file, err := os.Open(filepath.Join(a.dir, "reports.jsonl"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var r AggregateReport
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf("reading stored reports: %w", err)
+		}
+		a.reports = append(a.reports, r)
+	}
+	return scanner.Err()
+}
+
+func (a *Aggregator) reset(now time.Time) {
+	a.start = now
+	a.countries = make(map[string]*aggregateBucket)
+	a.asns = make(map[string]*aggregateBucket)
+	a.hosts = make(map[string]*aggregateBucket)
+	a.personas = make(map[string]*PersonaRow)
+}
+
+// Write implements Output.
+func (a *Aggregator) Write(ev Event) error {
+	if ev.Type == EventSessionEnd {
+		a.countSession(ev)
+		return nil
+	}
+	if ev.Type != EventConnection {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(ev.SrcAddr)
+	if err != nil {
+		return nil
+	}
+	info := a.geo.Lookup(net.ParseIP(host))
+	asKey := "AS" + strconv.FormatUint(uint64(info.ASN), 10)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	buckets := []*aggregateBucket{
+		a.bucket(a.countries, info.Country, ""),
+		a.bucket(a.asns, asKey, info.ASName),
+	}
+	if ev.Host != "" {
+		buckets = append(buckets, a.bucket(a.hosts, ev.Host, ""))
+	}
+	for _, b := range buckets {
+		b.connections++
+		b.ports[ev.Port]++
+		if _, ok := b.sources[host]; !ok {
+			b.sources[host] = !a.seen[host]
+		}
+	}
+	return nil
+}
+
+// countSession adds an ended session to the row of its persona.
+func (a *Aggregator) countSession(ev Event) {
+	handler, _ := ev.Fields["handler"].(string)
+	stage, _ := ev.Fields["stage"].(string)
+	seconds, _ := ev.Fields["duration"].(float64)
+	bytes, _ := ev.Fields["received_bytes"].(int)
+	if handler == "" {
+		return
+	}
+	row := newPersonaRow(handler, ev.Labels)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if existing, ok := a.personas[row.Key]; ok {
+		row = existing
+	} else {
+		a.personas[row.Key] = row
+	}
+	row.add(stage, seconds, bytes)
+}
+
+func (a *Aggregator) bucket(buckets map[string]*aggregateBucket, key, name string) *aggregateBucket {
+	b, ok := buckets[key]
+	if !ok {
+		b = &aggregateBucket{name: name, sources: make(map[string]bool), ports: make(map[string]int)}
+		buckets[key] = b
+	}
+	return b
+}
+
+// run closes a report every interval until Close is called.
+func (a *Aggregator) run() {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case now := <-ticker.C:
+			if _, err := a.closePeriod(now); err != nil {
+				a.log.Printf("Error storing aggregation report: %s", err)
+			}
+		}
+	}
+}
+
+// closePeriod turns the current counts into a report, stores it and starts a new period.
+func (a *Aggregator) closePeriod(now time.Time) (AggregateReport, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	report := AggregateReport{
+		Start:     a.start.UTC(),
+		End:       now.UTC(),
+		Countries: aggregateRows(a.countries),
+		ASNs:      aggregateRows(a.asns),
+		Hosts:     aggregateRows(a.hosts),
+		Personas:  personaRows(a.personas),
+	}
+	var newSources []string
+	for _, b := range a.countries {
+		for source := range b.sources {
+			if !a.seen[source] {
+				a.seen[source] = true
+				newSources = append(newSources, source)
+			}
+		}
+	}
+	a.reset(now)
+	a.reports = append(a.reports, report)
+
+	if len(newSources) > 0 {
+		sort.Strings(newSources)
+		w := bufio.NewWriter(a.sources)
+		for _, source := range newSources {
+			fmt.Fprintln(w, source)
+		}
+		if err := w.Flush(); err != nil {
+			return report, err
+		}
+	}
+	line, err := json.Marshal(report)
+	if err != nil {
+		return report, err
+	}
+	file, err := os.OpenFile(filepath.Join(a.dir, "reports.jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return report, err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return report, err
+}
+
+// Close stops the periodic reports and stores the report of the current partial period.
+func (a *Aggregator) Close() error {
+	var err error
+	a.closeOnce.Do(func() {
+		close(a.stop)
+		_, err = a.closePeriod(time.Now())
+		a.sources.Close()
+	})
+	return err
+}
+
+// Reports returns the stored reports that ended after since, oldest first.
+func (a *Aggregator) Reports(since time.Time) []AggregateReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	i := sort.Search(len(a.reports), func(i int) bool { return a.reports[i].End.After(since) })
+	return append([]AggregateReport(nil), a.reports[i:]...)
+}
+
+// current returns the persona rows of the period in progress as a report.
+func (a *Aggregator) current() AggregateReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return AggregateReport{Start: a.start.UTC(), End: time.Now().UTC(), Personas: personaRows(a.personas)}
+}
+
+func aggregateRows(buckets map[string]*aggregateBucket) []AggregateRow {
+	rows := make([]AggregateRow, 0, len(buckets))
+	for key, b := range buckets {
+		row := AggregateRow{Key: key, Name: b.name, Connections: b.connections, Sources: len(b.sources)}
+		for _, isNew := range b.sources {
+			if isNew {
+				row.NewSources++
+			} else {
+				row.ReturningSources++
+			}
+		}
+		for port, n := range b.ports {
+			row.TopPorts = append(row.TopPorts, PortCount{Port: port, Connections: n})
+		}
+		sort.Slice(row.TopPorts, func(i, j int) bool {
+			if row.TopPorts[i].Connections != row.TopPorts[j].Connections {
+				return row.TopPorts[i].Connections > row.TopPorts[j].Connections
+			}
+			return row.TopPorts[i].Port < row.TopPorts[j].Port
+		})
+		if len(row.TopPorts) > reportTopPorts {
+			row.TopPorts = row.TopPorts[:reportTopPorts]
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Connections != rows[j].Connections {
+			return rows[i].Connections > rows[j].Connections
+		}
+		return rows[i].Key < rows[j].Key
+	})
+	return rows
+}
@@ -0,0 +1,63 @@
+package honeypot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// alert is the JSON document delivered to the alert webhook.
+type alert struct {
+	Time     time.Time         `json:"time"`
+	Severity string            `json:"severity"`
+	Event    string            `json:"event"`
+	Message  string            `json:"message"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// RaiseAlert emits an alert event about this connection. kind names what
+// happened, e.g. "watchlist_hit".
+func (m *ConnMeta) RaiseAlert(severity, kind, message string) {
+	m.Emit(newAlertEvent(severity, kind, message))
+}
+
+// RaiseAlert emits an alert event not tied to a connection.
+func (s *Server) RaiseAlert(severity, kind, message string) {
+	s.Emit(newAlertEvent(severity, kind, message))
+}
+
+func newAlertEvent(severity, kind, message string) Event {
+	return Event{
+		Type:     EventAlert,
+		Severity: severity,
+		Message:  fmt.Sprintf("ALERT [%s] %s: %s", severity, kind, message),
+		Fields:   map[string]any{"alert": kind, "description": message},
+	}
+}
+
+// deliverAlert POSTs an alert event to the alert webhook in the background.
+func (s *Server) deliverAlert(ev Event) {
+	if s.AlertWebhook == "" || !s.WebhookFilter.Match(ev) {
+		return
+	}
+	kind, _ := ev.Fields["alert"].(string)
+	message, _ := ev.Fields["description"].(string)
+	body, err := json.Marshal(alert{Time: ev.Time.UTC(), Severity: ev.Severity, Event: kind, Message: message, Labels: ev.Labels})
+	if err != nil {
+		return
+	}
+	go func() {
+		client := http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(s.AlertWebhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			s.Log.Printf("Error delivering alert to webhook: %s", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			s.Log.Printf("Alert webhook returned %s", resp.Status)
+		}
+	}()
+}
@@ -0,0 +1,135 @@
+package honeypot
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AnomalyConfig tunes the detection of connection rate spikes. Each port
+// keeps an exponentially weighted moving average (EWMA) of its connections
+// per interval and of their variance; an interval whose count exceeds the
+// average by Sensitivity standard deviations raises a rate_spike alert.
+type AnomalyConfig struct {
+	Interval       string  `json:"interval,omitempty"`        // length of a counting interval, default 1m
+	Alpha          float64 `json:"alpha,omitempty"`           // EWMA weight of the latest interval, default 0.1
+	Sensitivity    float64 `json:"sensitivity"`               // standard deviations above the average that count as a spike, 0 disables detection
+	MinConnections int     `json:"min_connections,omitempty"` // intervals with fewer connections never alert, default 20
+	Warmup         int     `json:"warmup,omitempty"`          // intervals to learn the baseline before alerting, default 30
+}
+
+// portBaseline is the EWMA state of one port.
+type portBaseline struct {
+	count     int     // connections in the current interval
+	mean      float64 // average connections per interval
+	variance  float64
+	intervals int // intervals observed
+}
+
+// anomalyDetector keeps the baselines of all ports, see AnomalyConfig.
+type anomalyDetector struct {
+	cfg      AnomalyConfig
+	interval time.Duration
+	mu       sync.Mutex
+	ports    map[string]*portBaseline
+	closed   int // intervals closed since detection started
+}
+
+// EnableAnomalyDetection starts raising rate_spike alerts for ports whose
+// traffic jumps far above their baseline, which usually means a new campaign.
+func (s *Server) EnableAnomalyDetection(cfg AnomalyConfig) error {
+	d := &anomalyDetector{cfg: cfg, interval: time.Minute, ports: make(map[string]*portBaseline)}
+	if cfg.Interval != "" {
+		interval, err := time.ParseDuration(cfg.Interval)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid anomaly interval %q", cfg.Interval)
+		}
+		d.interval = interval
+	}
+	if cfg.Sensitivity <= 0 {
+		return fmt.Errorf("anomaly sensitivity must be positive")
+	}
+	if d.cfg.Alpha <= 0 || d.cfg.Alpha > 1 {
+		d.cfg.Alpha = 0.1
+	}
+	if d.cfg.MinConnections <= 0 {
+		d.cfg.MinConnections = 20
+	}
+	if d.cfg.Warmup <= 0 {
+		d.cfg.Warmup = 30
+	}
+	s.anomaly = d
+	go s.watchRates()
+	return nil
+}
+
+// countConnection records a connection to port for anomaly detection.
+func (s *Server) countConnection(port string) {
+	d := s.anomaly
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b, ok := d.ports[port]
+	if !ok {
+		// A port first seen now had no connections in the intervals before
+		b = &portBaseline{intervals: d.closed}
+		d.ports[port] = b
+	}
+	b.count++
+}
+
+// watchRates closes a counting interval every d.interval until Shutdown.
+func (s *Server) watchRates() {
+	d := s.anomaly
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			for _, spike := range d.closeInterval() {
+				s.Emit(spike)
+			}
+		}
+	}
+}
+
+// closeInterval compares each port's count with its baseline, folds the count
+// into the baseline and returns the alerts for ports that spiked.
+func (d *anomalyDetector) closeInterval() []Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ports := make([]string, 0, len(d.ports))
+	for port := range d.ports {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+
+	var alerts []Event
+	for _, port := range ports {
+		b := d.ports[port]
+		x := float64(b.count)
+		threshold := b.mean + d.cfg.Sensitivity*math.Sqrt(b.variance)
+		if b.intervals >= d.cfg.Warmup && b.count >= d.cfg.MinConnections && x > threshold {
+			ev := newAlertEvent("medium", "rate_spike", fmt.Sprintf("port %s received %d connections in %s, baseline %.1f (threshold %.1f)",
+				port, b.count, d.interval, b.mean, threshold))
+			ev.Port = port
+			ev.Fields["connections"] = b.count
+			ev.Fields["baseline"] = b.mean
+			alerts = append(alerts, ev)
+		}
+
+		diff := x - b.mean
+		b.mean += d.cfg.Alpha * diff
+		b.variance = (1 - d.cfg.Alpha) * (b.variance + d.cfg.Alpha*diff*diff)
+		b.intervals++
+		b.count = 0
+	}
+	d.closed++
+	return alerts
+}
@@ -0,0 +1,415 @@
+package honeypot
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIConfig enables the HTTP query API.
+type APIConfig struct {
+	Listen       string `json:"listen"`                  // address to listen on, e.g. "127.0.0.1:8088", empty to disable
+	StreamListen string `json:"stream_listen,omitempty"` // address the gRPC event stream listens on, e.g. "127.0.0.1:8089", empty to disable
+	Token        string `json:"token,omitempty"`         // bearer token required on every request and stream, empty to allow all
+}
+
+// API serves JSON endpoints about a running honeypot, all read-only but
+// purge:
+//
+//	GET /healthz                                health of the server, 503 unless ok, see Server.Health
+//	GET /readyz                                 health of the server, 503 while it is down
+//	GET /api/stats                              connection pool statistics
+//	GET /api/outputs                            delivery statistics of remote outputs
+//	GET /api/sensors                            sensors a collector received events from
+//	GET /api/reports?since=24h&by=country&limit=10  aggregation reports
+//	GET /api/personas?since=168h                    engagement of personas over the reports since, see ComparePersonas
+//	GET /api/search?q=evil.example.com&since=720h   stored events whose payload contains q
+//	GET /api/export?since=720h&type=data            stored events as NDJSON for notebooks
+//	GET /api/artifacts?value=192.0.2.1&depth=2      an artifact and the artifacts related to it
+//	GET /api/graph?format=graphml&since=720h        the artifact graph for link analysis tools
+//	POST /api/purge?ip=192.0.2.1                    delete what the sensor keeps about an address, see Purger
+//
+// since is an RFC 3339 time or a duration back from now, by restricts the
+// rows to "country", "asn", "host" or "persona" and limit caps the rows per
+// report.
+type API struct {
+	Server     *Server
+	Aggregator *Aggregator // nil when aggregation is disabled
+	Storage    Storage     // searched by /api/search and /api/export, nil when storage is disabled
+	Artifacts  *ArtifactDB // looked up by /api/artifacts, nil when the artifact database is disabled
+	Purger     *Purger     // run by /api/purge, which is only served with a Token; nil to disable
+	Collector  *Collector  // listed by /api/sensors, nil when the server is no collector
+	Token      string      // bearer token required on every request, empty to allow all
+
+	mux *http.ServeMux
+}
+
+// NewAPI returns the API of srv.
+func NewAPI(srv *Server, aggregator *Aggregator, token string) *API {
+	api := &API{Server: srv, Aggregator: aggregator, Token: token, mux: http.NewServeMux()}
+	api.mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { serveHealthz(api.Server, w) })
+	api.mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) { serveReadyz(api.Server, w) })
+	api.mux.HandleFunc("/api/stats", api.serveStats)
+	api.mux.HandleFunc("/api/outputs", api.serveOutputs)
+	api.mux.HandleFunc("/api/schema", api.serveSchema)
+	api.mux.HandleFunc("/api/sensors", api.serveSensors)
+	api.mux.HandleFunc("/api/reports", api.serveReports)
+	api.mux.HandleFunc("/api/personas", api.servePersonas)
+	api.mux.HandleFunc("/api/search", api.serveSearch)
+	api.mux.HandleFunc("/api/export", api.serveExport)
+	api.mux.HandleFunc("/api/artifacts", api.serveArtifacts)
+	api.mux.HandleFunc("/api/graph", api.serveGraph)
+	api.mux.HandleFunc("/api/purge", api.servePurge)
+	return api
+}
+
+// ServeHTTP implements http.Handler.
+func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if api.Token != "" {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, []byte("Bearer "+api.Token)) != 1 {
+			apiError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+	}
+	if r.URL.Path == "/api/purge" {
+		if r.Method != http.MethodPost {
+			apiError(w, http.StatusMethodNotAllowed, "purge needs POST")
+			return
+		}
+	} else if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		apiError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+	api.mux.ServeHTTP(w, r)
+}
+
+func (api *API) serveStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, api.Server.Stats())
+}
+
+func (api *API) serveOutputs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, api.Server.OutputStats())
+}
+
+func (api *API) serveSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	io.WriteString(w, EventSchema)
+}
+
+func (api *API) serveSensors(w http.ResponseWriter, r *http.Request) {
+	if api.Collector == nil {
+		apiError(w, http.StatusNotFound, "the collector is disabled")
+		return
+	}
+	writeJSON(w, api.Collector.Sensors())
+}
+
+func (api *API) serveReports(w http.ResponseWriter, r *http.Request) {
+	if api.Aggregator == nil {
+		apiError(w, http.StatusNotFound, "aggregation reports are disabled")
+		return
+	}
+	query := r.URL.Query()
+	since, err := parseSince(query.Get("since"))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "invalid since: want an RFC 3339 time or a duration such as 24h")
+		return
+	}
+	limit := 0
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			apiError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+	by := query.Get("by")
+	if by != "" && by != "country" && by != "asn" && by != "host" && by != "persona" {
+		apiError(w, http.StatusBadRequest, `by must be "country", "asn", "host" or "persona"`)
+		return
+	}
+
+	reports := api.Aggregator.Reports(since)
+	for i := range reports {
+		if by != "" && by != "country" {
+			reports[i].Countries = nil
+		}
+		if by != "" && by != "asn" {
+			reports[i].ASNs = nil
+		}
+		if by != "" && by != "host" {
+			reports[i].Hosts = nil
+		}
+		if by != "" && by != "persona" {
+			reports[i].Personas = nil
+		}
+		if limit > 0 {
+			reports[i].Countries = reports[i].Countries[:min(limit, len(reports[i].Countries))]
+			reports[i].ASNs = reports[i].ASNs[:min(limit, len(reports[i].ASNs))]
+			reports[i].Hosts = reports[i].Hosts[:min(limit, len(reports[i].Hosts))]
+			reports[i].Personas = reports[i].Personas[:min(limit, len(reports[i].Personas))]
+		}
+	}
+	writeJSON(w, reports)
+}
+
+// servePersonas compares the engagement of personas over the reports
+// ending after since, including the current period's sessions.
+func (api *API) servePersonas(w http.ResponseWriter, r *http.Request) {
+	if api.Aggregator == nil {
+		apiError(w, http.StatusNotFound, "aggregation reports are disabled")
+		return
+	}
+	since, err := parseSince(r.URL.Query().Get("since"))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "invalid since: want an RFC 3339 time or a duration such as 24h")
+		return
+	}
+	reports := append(api.Aggregator.Reports(since), api.Aggregator.current())
+	writeJSON(w, ComparePersonas(reports))
+}
+
+// Results returned by /api/search without a limit, and at most.
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 10000
+)
+
+// serveSearch returns the newest stored events, oldest first, whose payload
+// contains q. since, until, type (comma-separated), port, src_ip and session
+// narrow the search down, limit caps the results.
+func (api *API) serveSearch(w http.ResponseWriter, r *http.Request) {
+	if api.Storage == nil {
+		apiError(w, http.StatusNotFound, "event storage is disabled")
+		return
+	}
+	query := r.URL.Query()
+	q, ok := storageQuery(w, query)
+	if !ok {
+		return
+	}
+	if q.Text == "" {
+		apiError(w, http.StatusBadRequest, "missing q: the text to search payloads for")
+		return
+	}
+	q.Limit = defaultSearchLimit
+	var err error
+	if v := query.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 || q.Limit > maxSearchLimit {
+			apiError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit: want 1 to %d", maxSearchLimit))
+			return
+		}
+	}
+	events, err := api.Storage.Query(q)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "searching events: "+err.Error())
+		return
+	}
+	if events == nil {
+		events = []Event{}
+	}
+	writeJSON(w, events)
+}
+
+// serveExport streams the stored events matching the filters of
+// /api/search, oldest first and without a limit, as newline-delimited JSON
+// that pandas.read_json(url, lines=True) loads. It is compressed for
+// clients accepting gzip.
+func (api *API) serveExport(w http.ResponseWriter, r *http.Request) {
+	if api.Storage == nil {
+		apiError(w, http.StatusNotFound, "event storage is disabled")
+		return
+	}
+	q, ok := storageQuery(w, r.URL.Query())
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="gopot-events.ndjson"`)
+	var out io.Writer = w
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+	bw := bufio.NewWriterSize(out, 64<<10)
+	err := ExportEvents(api.Storage, q, func(event []byte) error {
+		bw.Write(event)
+		return bw.WriteByte('\n')
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		// The status is already sent: abort the response so the client
+		// sees a truncated download rather than a complete one
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// storageQuery parses the filters of /api/search and /api/export, reporting
+// invalid ones to the client.
+func storageQuery(w http.ResponseWriter, query url.Values) (StorageQuery, bool) {
+	q := StorageQuery{
+		Text:    query.Get("q"),
+		Port:    query.Get("port"),
+		SrcIP:   query.Get("src_ip"),
+		Session: query.Get("session"),
+	}
+	var err error
+	if q.Since, err = parseSince(query.Get("since")); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid since: want an RFC 3339 time or a duration such as 24h")
+		return q, false
+	}
+	if q.Until, err = parseSince(query.Get("until")); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid until: want an RFC 3339 time or a duration such as 24h")
+		return q, false
+	}
+	if v := query.Get("type"); v != "" {
+		q.Types = strings.Split(v, ",")
+	}
+	return q, true
+}
+
+// Related artifacts returned by /api/artifacts without a limit, and at most.
+const (
+	defaultArtifactLimit = 100
+	maxArtifactLimit     = 10000
+)
+
+// serveArtifacts looks value up in the artifact database, as the artifact
+// kind given or as every kind. depth 2 also returns the artifacts related to
+// the related ones, limit caps the related artifacts of each match.
+func (api *API) serveArtifacts(w http.ResponseWriter, r *http.Request) {
+	if api.Artifacts == nil {
+		apiError(w, http.StatusNotFound, "the artifact database is disabled")
+		return
+	}
+	query := r.URL.Query()
+	value, kind := query.Get("value"), query.Get("kind")
+	if value == "" {
+		apiError(w, http.StatusBadRequest, "missing value: an address, host name, JA3 hash, username:password or payload SHA-256")
+		return
+	}
+	switch kind {
+	case "", ArtifactIP, ArtifactRDNS, ArtifactJA3, ArtifactCredential, ArtifactPayload:
+	default:
+		apiError(w, http.StatusBadRequest, "invalid kind: want ip, rdns, ja3, credential or payload")
+		return
+	}
+	depth, limit := 1, defaultArtifactLimit
+	var err error
+	if v := query.Get("depth"); v != "" {
+		if depth, err = strconv.Atoi(v); err != nil || depth < 1 || depth > 2 {
+			apiError(w, http.StatusBadRequest, "invalid depth: want 1 or 2")
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxArtifactLimit {
+			apiError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit: want 1 to %d", maxArtifactLimit))
+			return
+		}
+	}
+	results := api.Artifacts.Lookup(kind, value, depth, limit)
+	if results == nil {
+		results = []ArtifactLookup{}
+	}
+	writeJSON(w, results)
+}
+
+// graphContentTypes are the media types of the graph export formats.
+var graphContentTypes = map[string]string{
+	GraphML:     "application/graphml+xml",
+	GraphCypher: "text/plain; charset=utf-8",
+	GraphJSON:   "application/json",
+}
+
+// serveGraph exports the artifacts seen since the given time, all of them
+// by default, and their relations in the given format, GraphML by default.
+func (api *API) serveGraph(w http.ResponseWriter, r *http.Request) {
+	if api.Artifacts == nil {
+		apiError(w, http.StatusNotFound, "the artifact database is disabled")
+		return
+	}
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = GraphML
+	}
+	contentType, ok := graphContentTypes[format]
+	if !ok {
+		apiError(w, http.StatusBadRequest, "invalid format: want "+strings.Join(GraphFormats, ", "))
+		return
+	}
+	since, err := parseSince(query.Get("since"))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "invalid since: want an RFC 3339 time or a duration such as 24h")
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="gopot-artifacts.%s"`, format))
+	api.Artifacts.Graph(since).Write(w, format)
+}
+
+// parseSince parses an RFC 3339 time or a duration counted back from now;
+// empty means the beginning of time.
+func parseSince(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// servePurge deletes the stored events, log lines, artifacts and captures
+// of the address ip and returns the PurgeReport. It deletes data for good,
+// so it is refused unless the API requires a token.
+func (api *API) servePurge(w http.ResponseWriter, r *http.Request) {
+	if api.Purger == nil {
+		apiError(w, http.StatusNotFound, "purging is disabled")
+		return
+	}
+	if api.Token == "" {
+		apiError(w, http.StatusForbidden, "purging needs an API token")
+		return
+	}
+	ip := r.URL.Query().Get("ip")
+	if ip == "" {
+		apiError(w, http.StatusBadRequest, "missing ip: the client address to purge")
+		return
+	}
+	report, err := api.Purger.Purge(ip)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// The address is not logged, it would be kept again
+	api.Server.Log.Printf("Purged the data of a client address on API request: %d events, %d log lines, %d artifacts, %d captures",
+		report.Events, report.LogLines, len(report.Artifacts), len(report.Captures))
+	writeJSON(w, report)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func apiError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,591 @@
+package honeypot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Archiving: the file recording the uploads of a directory, and how long a
+// file must be left alone before it is uploaded, so files still being
+// written, such as running captures, wait for the next run.
+const (
+	archiveStateFile = ".archive.json"
+	archiveSettle    = time.Minute
+)
+
+// ArchiveConfig uploads rotated logs and artifacts to an S3-compatible
+// bucket, see Archiver.
+type ArchiveConfig struct {
+	Bucket    string `json:"bucket"`               // bucket name, empty to disable
+	Endpoint  string `json:"endpoint,omitempty"`   // URL of the S3 service, e.g. http://minio:9000, default https://s3.<region>.amazonaws.com
+	Region    string `json:"region,omitempty"`     // default us-east-1
+	AccessKey string `json:"access_key,omitempty"` // default $AWS_ACCESS_KEY_ID
+	SecretKey string `json:"secret_key,omitempty"` // default $AWS_SECRET_ACCESS_KEY
+	Prefix    string `json:"prefix,omitempty"`     // prepended to the object keys, default the host name of the sensor and a slash
+	Interval  string `json:"interval,omitempty"`   // between uploads, default 1h
+	Retention string `json:"retention,omitempty"`  // age after which archived objects are deleted from the bucket, empty to keep them
+}
+
+// ArchiveSource is a directory whose files an Archiver uploads, under the
+// keys of its name followed by their path in the directory.
+type ArchiveSource struct {
+	Name    string // first element of the object keys, e.g. "logs"
+	Dir     string
+	Pattern string // names of the files uploaded, see path.Match, empty for all
+	Gzip    bool   // compress the files, adding .gz to their keys
+}
+
+// ArchiveSources returns what an Archiver of c uploads: the daily archives
+// of the text log, the captures, the samples and the reports, each of them
+// when configured.
+func (c *Config) ArchiveSources() []ArchiveSource {
+	sources := []ArchiveSource{{Name: "logs", Dir: c.LogDir, Pattern: "log-*.txt", Gzip: true}}
+	if c.Capture.Dir != "" {
+		sources = append(sources, ArchiveSource{Name: "captures", Dir: c.Capture.Dir, Pattern: "*.pcap", Gzip: true})
+	}
+	if c.Artifacts.Samples.Dir != "" {
+		sources = append(sources, ArchiveSource{Name: "samples", Dir: c.Artifacts.Samples.Dir})
+	}
+	if c.Reports.Dir != "" {
+		sources = append(sources, ArchiveSource{Name: "reports", Dir: c.Reports.Dir})
+	}
+	return sources
+}
+
+// ArchiveStats describes the work of an Archiver since it started.
+type ArchiveStats struct {
+	Uploaded  uint64    `json:"uploaded"`             // files uploaded
+	Bytes     uint64    `json:"bytes"`                // bytes uploaded, after compression
+	Deleted   uint64    `json:"deleted"`              // objects deleted past the retention or by purges
+	Failed    uint64    `json:"failed"`               // requests that failed
+	LastRun   time.Time `json:"last_run"`             // end of the last run, zero before the first
+	LastError string    `json:"last_error,omitempty"` // error of the last failed request
+}
+
+func (st ArchiveStats) String() string {
+	return fmt.Sprintf("uploaded=%d bytes=%d deleted=%d failed=%d", st.Uploaded, st.Bytes, st.Deleted, st.Failed)
+}
+
+// archived records the file a key was uploaded from, to upload it again
+// when it changes.
+type archived struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Archiver uploads files to an S3-compatible bucket, so the data of a
+// disposable sensor outlives it. Every interval it uploads the files of
+// its sources that are new or changed since their last upload, once they
+// have been left alone for a minute, and deletes the objects under its
+// prefix older than the retention. What was uploaded is recorded in a
+// .archive.json file in each source directory. Requests are signed with
+// AWS Signature Version 4 and address the bucket in the path, which AWS,
+// MinIO, Ceph, Backblaze B2, Wasabi and the like accept.
+type Archiver struct {
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	prefix    string
+	interval  time.Duration
+	retention time.Duration
+	sources   []ArchiveSource
+	client    *http.Client
+	log       *log.Logger
+
+	mu    sync.Mutex // serializes runs
+	state map[string]map[string]archived
+
+	statsMu sync.Mutex
+	stats   ArchiveStats
+	failing bool // a request of the current run failed
+
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewArchiver validates cfg and reads what was already uploaded from
+// sources. Start begins the uploads; failures are reported to logger.
+func NewArchiver(cfg ArchiveConfig, sources []ArchiveSource, logger *log.Logger) (*Archiver, error) {
+	a := &Archiver{
+		endpoint:  strings.TrimSuffix(cfg.Endpoint, "/"),
+		bucket:    cfg.Bucket,
+		region:    cfg.Region,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		prefix:    cfg.Prefix,
+		interval:  time.Hour,
+		sources:   sources,
+		client:    &http.Client{Timeout: 5 * time.Minute},
+		log:       logger,
+		state:     make(map[string]map[string]archived),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if strings.ContainsAny(a.bucket, "/?# ") {
+		return nil, fmt.Errorf("archive: invalid bucket %q", a.bucket)
+	}
+	if a.region == "" {
+		a.region = "us-east-1"
+	}
+	if a.endpoint == "" {
+		a.endpoint = "https://s3." + a.region + ".amazonaws.com"
+	}
+	if u, err := url.Parse(a.endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return nil, fmt.Errorf("archive: endpoint %q is not an http or https URL without a path", cfg.Endpoint)
+	}
+	if a.accessKey == "" {
+		a.accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if a.secretKey == "" {
+		a.secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if a.accessKey == "" || a.secretKey == "" {
+		return nil, errors.New("archive: access_key and secret_key are required, or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY in the environment")
+	}
+	if a.prefix == "" {
+		host, _ := os.Hostname()
+		a.prefix = host + "/"
+	}
+	a.prefix = strings.TrimPrefix(a.prefix, "/")
+	var err error
+	if cfg.Interval != "" {
+		if a.interval, err = time.ParseDuration(cfg.Interval); err != nil || a.interval <= 0 {
+			return nil, fmt.Errorf("archive: invalid interval %q", cfg.Interval)
+		}
+	}
+	if cfg.Retention != "" {
+		if a.retention, err = time.ParseDuration(cfg.Retention); err != nil || a.retention <= 0 {
+			return nil, fmt.Errorf("archive: invalid retention %q", cfg.Retention)
+		}
+	}
+	for _, src := range sources {
+		if _, err := path.Match(src.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("archive: invalid pattern %q of %s", src.Pattern, src.Name)
+		}
+		uploaded := make(map[string]archived)
+		content, err := os.ReadFile(filepath.Join(src.Dir, archiveStateFile))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("archive: %w", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(content, &uploaded); err != nil {
+				return nil, fmt.Errorf("archive: parsing %s: %w", filepath.Join(src.Dir, archiveStateFile), err)
+			}
+		}
+		a.state[src.Name] = uploaded
+	}
+	return a, nil
+}
+
+// URL returns the location of the archive, for log messages.
+func (a *Archiver) URL() string {
+	return a.endpoint + "/" + a.bucket + "/" + a.prefix
+}
+
+// Start uploads every interval until Close.
+func (a *Archiver) Start() {
+	a.started = true
+	go func() {
+		defer close(a.done)
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+		for {
+			a.Run(false)
+			select {
+			case <-ticker.C:
+			case <-a.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Run uploads the files that are new or changed, all of them if now is set
+// or only those left alone for a minute otherwise, then applies the
+// retention.
+func (a *Archiver) Run(now bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.statsMu.Lock()
+	a.failing = false
+	a.statsMu.Unlock()
+	for _, src := range a.sources {
+		a.upload(src, now)
+	}
+	if a.retention > 0 {
+		a.expire()
+	}
+	a.statsMu.Lock()
+	a.stats.LastRun = time.Now()
+	a.statsMu.Unlock()
+}
+
+// upload uploads the pending files of src. The caller holds a.mu.
+func (a *Archiver) upload(src ArchiveSource, now bool) {
+	uploaded := a.state[src.Name]
+	changed := false
+	filepath.WalkDir(src.Dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") && file != src.Dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || strings.HasSuffix(d.Name(), ".tmp") {
+			return nil
+		}
+		if ok, _ := path.Match(src.Pattern, d.Name()); src.Pattern != "" && !ok {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, _ := filepath.Rel(src.Dir, file)
+		rel = filepath.ToSlash(rel)
+		if prev, ok := uploaded[rel]; ok && prev.Size == info.Size() && prev.ModTime.Equal(info.ModTime()) {
+			return nil
+		}
+		if !now && time.Since(info.ModTime()) < archiveSettle {
+			return nil
+		}
+		if err := a.put(src, rel, file); err != nil {
+			a.fail(err)
+			return nil
+		}
+		uploaded[rel] = archived{Size: info.Size(), ModTime: info.ModTime()}
+		changed = true
+		return nil
+	})
+	if changed {
+		a.save(src)
+	}
+}
+
+// put uploads file, at rel in src.
+func (a *Archiver) put(src ArchiveSource, rel, file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	key := a.key(src, rel)
+	contentType := mime.TypeByExtension(path.Ext(rel))
+	if src.Gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(content)
+		zw.Close()
+		content, contentType = buf.Bytes(), "application/gzip"
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	resp, err := a.do(http.MethodPut, key, nil, content, contentType)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	a.statsMu.Lock()
+	a.stats.Uploaded++
+	a.stats.Bytes += uint64(len(content))
+	a.statsMu.Unlock()
+	return nil
+}
+
+// key returns the object key of the file at rel in src.
+func (a *Archiver) key(src ArchiveSource, rel string) string {
+	key := a.prefix + src.Name + "/" + rel
+	if src.Gzip {
+		key += ".gz"
+	}
+	return key
+}
+
+// save records the uploads of src. The caller holds a.mu.
+func (a *Archiver) save(src ArchiveSource) {
+	content, err := json.Marshal(a.state[src.Name])
+	if err == nil {
+		file := filepath.Join(src.Dir, archiveStateFile)
+		if err = os.WriteFile(file+".tmp", content, 0o640); err == nil {
+			err = os.Rename(file+".tmp", file)
+		}
+	}
+	if err != nil && a.log != nil {
+		a.log.Printf("Archive: unable to record the uploads of %s: %s", src.Dir, err)
+	}
+}
+
+// expire deletes the objects under the prefix older than the retention.
+// The caller holds a.mu.
+func (a *Archiver) expire() {
+	cutoff := time.Now().Add(-a.retention)
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {a.prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := a.do(http.MethodGet, "", query, nil, "")
+		if err != nil {
+			a.fail(err)
+			return
+		}
+		var list struct {
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+			Contents              []struct {
+				Key          string    `xml:"Key"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			a.fail(fmt.Errorf("listing %s: %w", a.URL(), err))
+			return
+		}
+		for _, obj := range list.Contents {
+			if obj.LastModified.Before(cutoff) {
+				a.delete(obj.Key)
+			}
+		}
+		if !list.IsTruncated || list.NextContinuationToken == "" {
+			return
+		}
+		token = list.NextContinuationToken
+	}
+}
+
+// delete deletes the object key and reports whether it succeeded.
+func (a *Archiver) delete(key string) bool {
+	resp, err := a.do(http.MethodDelete, key, nil, nil, "")
+	if err != nil {
+		a.fail(err)
+		return false
+	}
+	resp.Body.Close()
+	a.statsMu.Lock()
+	a.stats.Deleted++
+	a.statsMu.Unlock()
+	return true
+}
+
+// Sync brings the objects of files of the sources up to date after they
+// were rewritten or deleted, as by a purge: the objects of deleted files
+// are deleted and the others uploaded again. It returns the keys deleted
+// or replaced; files never uploaded are left to the next run.
+func (a *Archiver) Sync(files []string) ([]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var (
+		keys []string
+		errs []string
+	)
+	for _, file := range files {
+		for _, src := range a.sources {
+			rel, err := filepath.Rel(src.Dir, file)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				continue
+			}
+			rel = filepath.ToSlash(rel)
+			if _, ok := a.state[src.Name][rel]; !ok {
+				break
+			}
+			key := a.key(src, rel)
+			info, err := os.Stat(file)
+			switch {
+			case os.IsNotExist(err):
+				if !a.delete(key) {
+					errs = append(errs, "deleting "+key)
+					break
+				}
+				delete(a.state[src.Name], rel)
+			case err != nil:
+				errs = append(errs, err.Error())
+			default:
+				if err := a.put(src, rel, file); err != nil {
+					a.fail(err)
+					errs = append(errs, err.Error())
+					break
+				}
+				a.state[src.Name][rel] = archived{Size: info.Size(), ModTime: info.ModTime()}
+			}
+			keys = append(keys, key)
+			a.save(src)
+			break
+		}
+	}
+	sort.Strings(keys)
+	if len(errs) > 0 {
+		return keys, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return keys, nil
+}
+
+// fail records a failed request, logging the first failure of a run.
+func (a *Archiver) fail(err error) {
+	a.statsMu.Lock()
+	a.stats.Failed++
+	a.stats.LastError = err.Error()
+	first := !a.failing
+	a.failing = true
+	a.statsMu.Unlock()
+	if a.log != nil && first {
+		a.log.Printf("Archive: %s", err)
+	}
+}
+
+// Stats returns what the archiver did since it started.
+func (a *Archiver) Stats() ArchiveStats {
+	a.statsMu.Lock()
+	defer a.statsMu.Unlock()
+	return a.stats
+}
+
+// Close stops the periodic uploads and uploads the files still pending,
+// those being written included, so a sensor being shut down for good
+// leaves nothing behind but its current log file.
+func (a *Archiver) Close() error {
+	close(a.stop)
+	if a.started {
+		<-a.done
+	}
+	a.Run(true)
+	return nil
+}
+
+// do sends a request for the object key, or the bucket if key is empty,
+// signed with AWS Signature Version 4, and returns the response to a
+// successful one.
+func (a *Archiver) do(method, key string, query url.Values, body []byte, contentType string) (*http.Response, error) {
+	uri := "/" + s3Escape(a.bucket)
+	if key != "" {
+		uri += "/" + s3Escape(key)
+	}
+	rawQuery := s3Query(query)
+	target := a.endpoint + uri
+	if rawQuery != "" {
+		target += "?" + rawQuery
+	}
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	a.sign(req, uri, rawQuery, hex.EncodeToString(sum[:]), time.Now())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var reply struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		if xml.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&reply) == nil && reply.Code != "" {
+			return nil, fmt.Errorf("%s %s returned %s: %s: %s", method, a.endpoint+uri, resp.Status, reply.Code, reply.Message)
+		}
+		return nil, fmt.Errorf("%s %s returned %s", method, a.endpoint+uri, resp.Status)
+	}
+	return resp, nil
+}
+
+// sign sets the X-Amz-Date, X-Amz-Content-Sha256 and Authorization headers
+// of req for AWS Signature Version 4 at time now, where uri and rawQuery are
+// the canonical path and query of req, see s3Escape and s3Query, and
+// payloadHash is the hex SHA-256 of its body.
+func (a *Archiver) sign(req *http.Request, uri, rawQuery, payloadHash string, now time.Time) {
+	now = now.UTC()
+	date, stamp := now.Format("20060102"), now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", stamp)
+
+	canonical := strings.Join([]string{
+		req.Method, uri, rawQuery,
+		"host:" + req.URL.Host, "x-amz-content-sha256:" + payloadHash, "x-amz-date:" + stamp, "",
+		"host;x-amz-content-sha256;x-amz-date", payloadHash,
+	}, "\n")
+	scope := date + "/" + a.region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+	signingKey := []byte("AWS4" + a.secretKey)
+	for _, part := range []string{date, a.region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+a.accessKey+"/"+scope+
+		", SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature="+hex.EncodeToString(hmacSHA256(signingKey, toSign)))
+}
+
+// s3Query returns the canonical form of query: its escaped parameters sorted
+// by name and value.
+func s3Query(query url.Values) string {
+	type param struct{ name, value string }
+	var params []param
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, param{s3Escape(name), strings.ReplaceAll(s3Escape(value), "/", "%2F")})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i].name != params[j].name {
+			return params[i].name < params[j].name
+		}
+		return params[i].value < params[j].value
+	})
+	parts := make([]string, len(params))
+	for i, p := range params {
+		parts[i] = p.name + "=" + p.value
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape escapes s as AWS Signature Version 4 requires, leaving the
+// unreserved characters of RFC 3986 and slashes alone.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,674 @@
+package honeypot
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Artifact kinds recorded by an ArtifactDB.
+const (
+	ArtifactIP         = "ip"         // client address
+	ArtifactRDNS       = "rdns"       // reverse DNS name of a client address
+	ArtifactJA3        = "ja3"        // MD5 JA3 fingerprint of a TLS ClientHello
+	ArtifactCredential = "credential" // "username:password" login attempt
+	ArtifactPayload    = "payload"    // SHA-256 of a chunk of received data
+	ArtifactURL        = "url"        // payload URL of a download command, see ExtractDropperURLs
+	ArtifactSample     = "sample"     // SHA-256 of a file fetched from a payload URL
+)
+
+// Limits of the artifact database.
+const (
+	maxArtifacts         = 500_000   // artifacts kept, later ones are only counted
+	maxArtifactRelations = 2_000_000 // relations kept, later ones are only counted
+	artifactSaveInterval = time.Minute
+	rdnsInterval         = 24 * time.Hour // how long a reverse DNS lookup is trusted
+	rdnsTimeout          = 5 * time.Second
+	rdnsQueue            = 1000 // addresses waiting for a lookup before new ones are skipped
+)
+
+// ArtifactConfig enables the artifact database.
+type ArtifactConfig struct {
+	Path       string           `json:"path"`           // file the database is kept in, empty to disable
+	RDNS       bool             `json:"rdns,omitempty"` // resolve the reverse DNS name of every client address
+	VirusTotal VirusTotalConfig `json:"virustotal"`     // look new payload and sample hashes up in VirusTotal
+	Samples    SampleConfig     `json:"samples"`        // fetch the files payload URLs point to
+}
+
+// Artifact is something observed in attacker traffic.
+type Artifact struct {
+	Kind      string    `json:"kind"` // one of the Artifact* constants
+	Value     string    `json:"value"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Count     int       `json:"count"` // times it was observed
+
+	VirusTotal *VirusTotalReport `json:"virustotal,omitempty"` // of payloads and samples, once looked up
+	Fetch      *SampleFetch      `json:"fetch,omitempty"`      // of URLs, once fetched
+	Sample     *SampleInfo       `json:"sample,omitempty"`     // of samples
+}
+
+// ID returns the identifier of the artifact, its kind and value separated by
+// a colon, e.g. "ip:192.0.2.1".
+func (a Artifact) ID() string { return a.Kind + ":" + a.Value }
+
+// ArtifactRelation tells how often two artifacts were observed together, e.g.
+// a credential tried from an address.
+type ArtifactRelation struct {
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Count     int       `json:"count"`
+}
+
+// RelatedArtifact is an artifact related to a looked up one.
+type RelatedArtifact struct {
+	Artifact
+	Relation ArtifactRelation `json:"relation"`
+	Via      string           `json:"via,omitempty"` // ID of the artifact linking the two, empty for direct relations
+}
+
+// ArtifactLookup is an artifact and the artifacts related to it.
+type ArtifactLookup struct {
+	Artifact
+	Related []RelatedArtifact `json:"related"`
+}
+
+// ArtifactStats describes the content of an ArtifactDB.
+type ArtifactStats struct {
+	Artifacts map[string]int `json:"artifacts"` // by kind
+	Relations int            `json:"relations"`
+	Skipped   int            `json:"skipped"` // artifacts and relations not kept because the database is full
+}
+
+// artifactFile is the stored form of an ArtifactDB.
+type artifactFile struct {
+	Artifacts []*Artifact          `json:"artifacts"`
+	Relations []storedArtifactLink `json:"relations"`
+}
+
+type storedArtifactLink struct {
+	A string `json:"a"`
+	B string `json:"b"`
+	ArtifactRelation
+}
+
+// ArtifactDB is an Output building a local intelligence graph from events:
+// client addresses, their reverse DNS names, the JA3 fingerprints of their TLS
+// clients, the credentials they tried and the hashes of the payloads they
+// sent, each related to the address it came from. Relations between other
+// artifacts go through addresses: looking up a password hash at depth 2
+// lists every address that sent it and what else those addresses did. The
+// database is kept in a JSON file, saved every minute and on Close. With
+// VirusTotal lookups, new payloads get the detections of their hash. With
+// sample fetching, the files payload URLs point to are downloaded and
+// recorded as samples related to their URL.
+type ArtifactDB struct {
+	path    string
+	log     *log.Logger
+	rdns    bool
+	vt      *VirusTotal
+	fetcher *sampleFetcher
+
+	mu        sync.RWMutex
+	artifacts map[string]*Artifact                    // by ID
+	links     map[string]map[string]*ArtifactRelation // by artifact ID, both ways
+	relations int
+	skipped   int
+	dirty     bool
+	resolved  map[string]time.Time // last reverse DNS lookup by address
+	scanning  map[string]bool      // payloads and samples queued for VirusTotal
+	fetching  map[string]bool      // URLs queued for fetching
+
+	lookups   chan string
+	scans     chan string
+	fetches   chan string
+	stop      chan struct{}
+	done      sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewArtifactDB opens the database kept at cfg.Path, creating it if needed,
+// and reports save and lookup failures to logger.
+func NewArtifactDB(cfg ArtifactConfig, logger *log.Logger) (*ArtifactDB, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o750); err != nil {
+		return nil, err
+	}
+	db := &ArtifactDB{
+		path:      cfg.Path,
+		log:       logger,
+		rdns:      cfg.RDNS,
+		artifacts: make(map[string]*Artifact),
+		links:     make(map[string]map[string]*ArtifactRelation),
+		resolved:  make(map[string]time.Time),
+		scanning:  make(map[string]bool),
+		fetching:  make(map[string]bool),
+		lookups:   make(chan string, rdnsQueue),
+		scans:     make(chan string, virusTotalQueue),
+		fetches:   make(chan string, sampleQueue),
+		stop:      make(chan struct{}),
+	}
+	if cfg.VirusTotal.APIKey != "" {
+		vt, err := NewVirusTotal(cfg.VirusTotal)
+		if err != nil {
+			return nil, err
+		}
+		db.vt = vt
+	}
+	if cfg.Samples.Dir != "" {
+		fetcher, err := newSampleFetcher(cfg.Samples)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(cfg.Samples.Dir, 0o750); err != nil {
+			return nil, err
+		}
+		db.fetcher = fetcher
+	}
+	if err := db.load(); err != nil {
+		return nil, fmt.Errorf("reading artifact database %s: %w", cfg.Path, err)
+	}
+	db.done.Add(1)
+	go db.run()
+	if db.rdns {
+		db.done.Add(1)
+		go db.resolve()
+	}
+	if db.vt != nil {
+		db.done.Add(1)
+		go db.scan()
+	}
+	if db.fetcher != nil {
+		db.done.Add(1)
+		go db.fetch()
+	}
+	return db, nil
+}
+
+func (db *ArtifactDB) load() error {
+	content, err := os.ReadFile(db.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var stored artifactFile
+	if err := json.Unmarshal(content, &stored); err != nil {
+		return err
+	}
+	for _, a := range stored.Artifacts {
+		db.artifacts[a.ID()] = a
+	}
+	for _, l := range stored.Relations {
+		if db.artifacts[l.A] == nil || db.artifacts[l.B] == nil {
+			continue
+		}
+		rel := l.ArtifactRelation
+		db.setLink(l.A, l.B, &rel)
+	}
+	return nil
+}
+
+// save writes the database if it changed since the last save.
+func (db *ArtifactDB) save() error {
+	db.mu.Lock()
+	if !db.dirty {
+		db.mu.Unlock()
+		return nil
+	}
+	stored := artifactFile{Artifacts: make([]*Artifact, 0, len(db.artifacts)), Relations: make([]storedArtifactLink, 0, db.relations)}
+	for _, a := range db.artifacts {
+		stored.Artifacts = append(stored.Artifacts, a)
+	}
+	for a, related := range db.links {
+		for b, rel := range related {
+			if a < b {
+				stored.Relations = append(stored.Relations, storedArtifactLink{A: a, B: b, ArtifactRelation: *rel})
+			}
+		}
+	}
+	content, err := json.Marshal(stored)
+	db.dirty = err != nil
+	db.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(db.path+".tmp", content, 0o640); err == nil {
+		err = os.Rename(db.path+".tmp", db.path)
+	}
+	if err != nil {
+		db.mu.Lock()
+		db.dirty = true
+		db.mu.Unlock()
+	}
+	return err
+}
+
+// Write implements Output.
+func (db *ArtifactDB) Write(ev Event) error {
+	if ev.SrcAddr == "" {
+		return nil
+	}
+	ip := Artifact{Kind: ArtifactIP, Value: srcIP(ev.SrcAddr)}.ID()
+	var observed []string
+	switch ev.Type {
+	case EventConnection:
+		db.observe(ip, ev.Time, true)
+		if db.rdns {
+			db.queueLookup(ip, ev.Time)
+		}
+		return nil
+	case EventData:
+		data := EventPayload(ev)
+		if len(data) == 0 {
+			return nil
+		}
+		payload, _ := ev.Fields["data_sha256"].(string) // hash of the data before redaction
+		if payload == "" {
+			sum := sha256.Sum256(data)
+			payload = hex.EncodeToString(sum[:])
+		}
+		observed = append(observed, Artifact{Kind: ArtifactPayload, Value: payload}.ID())
+		if _, hash, ok := JA3(data); ok {
+			observed = append(observed, Artifact{Kind: ArtifactJA3, Value: hash}.ID())
+		}
+	case EventCredential:
+		username, _ := ev.Fields["username"].(string)
+		password, _ := ev.Fields["password"].(string)
+		observed = append(observed, Artifact{Kind: ArtifactCredential, Value: username + ":" + password}.ID())
+	case EventAlert:
+		url, _ := ev.Fields["url"].(string)
+		if ev.Fields["alert"] != "dropper_url" || url == "" {
+			return nil
+		}
+		observed = append(observed, Artifact{Kind: ArtifactURL, Value: url}.ID())
+	default:
+		return nil
+	}
+	if !db.observe(ip, ev.Time, false) {
+		return nil
+	}
+	for _, id := range observed {
+		if db.observe(id, ev.Time, true) {
+			db.relate(ip, id, ev.Time)
+			if db.vt != nil && strings.HasPrefix(id, ArtifactPayload+":") {
+				db.queueScan(id)
+			}
+			if db.fetcher != nil && strings.HasPrefix(id, ArtifactURL+":") {
+				db.queueFetch(id)
+			}
+		}
+	}
+	return nil
+}
+
+// observe records an artifact seen at t, counting the observation if count
+// is set. It reports whether the artifact is in the database.
+func (db *ArtifactDB) observe(id string, t time.Time, count bool) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	a := db.artifacts[id]
+	if a == nil {
+		if len(db.artifacts) >= maxArtifacts {
+			db.skipped++
+			return false
+		}
+		kind, value, _ := strings.Cut(id, ":")
+		a = &Artifact{Kind: kind, Value: value, FirstSeen: t}
+		db.artifacts[id] = a
+	}
+	if t.After(a.LastSeen) {
+		a.LastSeen = t
+	}
+	if count {
+		a.Count++
+	}
+	db.dirty = true
+	return true
+}
+
+// relate records that two artifacts in the database were seen together at t.
+func (db *ArtifactDB) relate(a, b string, t time.Time) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.artifacts[a] == nil || db.artifacts[b] == nil {
+		return
+	}
+	rel := db.links[a][b]
+	if rel == nil {
+		if db.relations >= maxArtifactRelations {
+			db.skipped++
+			return
+		}
+		rel = &ArtifactRelation{FirstSeen: t}
+		db.setLink(a, b, rel)
+	}
+	if t.After(rel.LastSeen) {
+		rel.LastSeen = t
+	}
+	rel.Count++
+	db.dirty = true
+}
+
+// setLink stores a relation under both artifacts. Callers must hold db.mu
+// for writing.
+func (db *ArtifactDB) setLink(a, b string, rel *ArtifactRelation) {
+	for _, pair := range [][2]string{{a, b}, {b, a}} {
+		related := db.links[pair[0]]
+		if related == nil {
+			related = make(map[string]*ArtifactRelation)
+			db.links[pair[0]] = related
+		}
+		related[pair[1]] = rel
+	}
+	db.relations++
+}
+
+// queueLookup asks for the reverse DNS name of an address unless it was
+// looked up within rdnsInterval. Addresses are skipped while the queue is full.
+func (db *ArtifactDB) queueLookup(ip string, t time.Time) {
+	db.mu.Lock()
+	if last, ok := db.resolved[ip]; ok && t.Sub(last) < rdnsInterval {
+		db.mu.Unlock()
+		return
+	}
+	db.resolved[ip] = t
+	db.mu.Unlock()
+	select {
+	case db.lookups <- ip:
+	default:
+	}
+}
+
+// resolve looks up the queued addresses one at a time until Close.
+func (db *ArtifactDB) resolve() {
+	defer db.done.Done()
+	for {
+		select {
+		case <-db.stop:
+			return
+		case ip := <-db.lookups:
+			ctx, cancel := context.WithTimeout(context.Background(), rdnsTimeout)
+			names, _ := net.DefaultResolver.LookupAddr(ctx, strings.TrimPrefix(ip, ArtifactIP+":"))
+			cancel()
+			now := time.Now()
+			for _, name := range names {
+				id := Artifact{Kind: ArtifactRDNS, Value: strings.TrimSuffix(strings.ToLower(name), ".")}.ID()
+				if db.observe(id, now, true) {
+					db.relate(ip, id, now)
+				}
+			}
+		}
+	}
+}
+
+// queueScan asks VirusTotal about a payload or sample unless it was looked up, or,
+// if VirusTotal did not know it, looked up within virusTotalRecheck.
+// Payloads are skipped while the queue is full.
+func (db *ArtifactDB) queueScan(id string) {
+	db.mu.Lock()
+	a := db.artifacts[id]
+	if a == nil || db.scanning[id] || (a.VirusTotal != nil && (a.VirusTotal.Known || time.Since(a.VirusTotal.Checked) < virusTotalRecheck)) {
+		db.mu.Unlock()
+		return
+	}
+	db.scanning[id] = true
+	db.mu.Unlock()
+	select {
+	case db.scans <- id:
+	default:
+		db.mu.Lock()
+		delete(db.scanning, id)
+		db.mu.Unlock()
+	}
+}
+
+// scan looks the queued payloads and samples up in VirusTotal, one every interval of
+// its rate, until Close.
+func (db *ArtifactDB) scan() {
+	defer db.done.Done()
+	wait := func(d time.Duration) bool {
+		select {
+		case <-db.stop:
+			return false
+		case <-time.After(d):
+			return true
+		}
+	}
+	for {
+		select {
+		case <-db.stop:
+			return
+		case id := <-db.scans:
+			_, hash, _ := strings.Cut(id, ":")
+			report, retry, err := db.vt.Report(hash)
+			db.mu.Lock()
+			delete(db.scanning, id)
+			if a := db.artifacts[id]; err == nil && a != nil {
+				a.VirusTotal = report
+				db.dirty = true
+			}
+			db.mu.Unlock()
+			if err != nil {
+				db.log.Printf("Unable to look %s up in VirusTotal: %s", id, err)
+			}
+			if !wait(max(db.vt.interval, retry)) {
+				return
+			}
+		}
+	}
+}
+
+// queueFetch asks for the sample behind a payload URL unless it was
+// fetched. URLs are skipped while the queue is full.
+func (db *ArtifactDB) queueFetch(id string) {
+	db.mu.Lock()
+	a := db.artifacts[id]
+	if a == nil || a.Fetch != nil || db.fetching[id] {
+		db.mu.Unlock()
+		return
+	}
+	db.fetching[id] = true
+	db.mu.Unlock()
+	select {
+	case db.fetches <- id:
+	default:
+		db.mu.Lock()
+		delete(db.fetching, id)
+		db.mu.Unlock()
+	}
+}
+
+// fetch downloads the samples of the queued URLs, one every interval of
+// the fetcher's rate, until Close. Each sample is related to its URL and,
+// with VirusTotal lookups, looked up.
+func (db *ArtifactDB) fetch() {
+	defer db.done.Done()
+	for {
+		select {
+		case <-db.stop:
+			return
+		case id := <-db.fetches:
+			url := strings.TrimPrefix(id, ArtifactURL+":")
+			hash, info, err := db.fetcher.fetch(url)
+			now := time.Now()
+			result := &SampleFetch{Time: now.UTC(), Sample: hash}
+			if err != nil {
+				result.Error = err.Error()
+				db.log.Printf("Unable to fetch the sample of %s: %s", url, err)
+			} else {
+				db.log.Printf("Fetched sample %s (%s, %d bytes) from %s", hash, info.Type, info.Size, url)
+			}
+			db.mu.Lock()
+			delete(db.fetching, id)
+			if a := db.artifacts[id]; a != nil {
+				a.Fetch = result
+				db.dirty = true
+			}
+			db.mu.Unlock()
+			if err == nil {
+				sample := Artifact{Kind: ArtifactSample, Value: hash}.ID()
+				if db.observe(sample, now, true) {
+					db.mu.Lock()
+					if a := db.artifacts[sample]; a.Sample == nil {
+						a.Sample = info
+					}
+					db.mu.Unlock()
+					db.relate(id, sample, now)
+					if db.vt != nil {
+						db.queueScan(sample)
+					}
+				}
+			}
+			select {
+			case <-db.stop:
+				return
+			case <-time.After(db.fetcher.interval):
+			}
+		}
+	}
+}
+
+// run saves the database every artifactSaveInterval until Close.
+func (db *ArtifactDB) run() {
+	defer db.done.Done()
+	ticker := time.NewTicker(artifactSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-ticker.C:
+			if err := db.save(); err != nil {
+				db.log.Printf("Error storing artifact database: %s", err)
+			}
+		}
+	}
+}
+
+// Lookup returns the artifact of the given kind and value with the
+// artifacts related to it, most recently seen together first, up to limit
+// of them (0 for all). At depth 2 the artifacts related to those are
+// included as well. An empty kind looks value up as every kind. It returns
+// nil when nothing matches.
+func (db *ArtifactDB) Lookup(kind, value string, depth, limit int) []ArtifactLookup {
+	kinds := []string{kind}
+	if kind == "" {
+		kinds = []string{ArtifactIP, ArtifactRDNS, ArtifactJA3, ArtifactCredential, ArtifactPayload, ArtifactURL, ArtifactSample}
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var results []ArtifactLookup
+	for _, kind := range kinds {
+		v := value
+		if kind != ArtifactIP && kind != ArtifactCredential && kind != ArtifactURL {
+			v = strings.ToLower(v) // hashes and host names
+		}
+		id := Artifact{Kind: kind, Value: v}.ID()
+		a := db.artifacts[id]
+		if a == nil {
+			continue
+		}
+		result := ArtifactLookup{Artifact: *a, Related: []RelatedArtifact{}}
+		seen := map[string]bool{id: true}
+		direct := db.related(id)
+		for _, r := range direct {
+			seen[r.ID()] = true
+		}
+		result.Related = append(result.Related, direct...)
+		if depth >= 2 {
+			for _, direct := range direct {
+				for _, indirect := range db.related(direct.ID()) {
+					if !seen[indirect.ID()] {
+						seen[indirect.ID()] = true
+						indirect.Via = direct.ID()
+						result.Related = append(result.Related, indirect)
+					}
+				}
+			}
+		}
+		if limit > 0 && len(result.Related) > limit {
+			result.Related = result.Related[:limit]
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// related returns the artifacts directly related to id, most recently seen
+// together first. Callers must hold db.mu.
+func (db *ArtifactDB) related(id string) []RelatedArtifact {
+	related := make([]RelatedArtifact, 0, len(db.links[id]))
+	for other, rel := range db.links[id] {
+		related = append(related, RelatedArtifact{Artifact: *db.artifacts[other], Relation: *rel})
+	}
+	sort.Slice(related, func(i, j int) bool {
+		if !related[i].Relation.LastSeen.Equal(related[j].Relation.LastSeen) {
+			return related[i].Relation.LastSeen.After(related[j].Relation.LastSeen)
+		}
+		return related[i].ID() < related[j].ID()
+	})
+	return related
+}
+
+// Purge deletes the artifact of a client address with its relations, and
+// the artifacts only it was related to, such as its reverse DNS names and
+// the payloads only it sent, then saves the database. It returns the IDs of
+// the artifacts deleted, none if the address is unknown.
+func (db *ArtifactDB) Purge(ip string) ([]string, error) {
+	id := Artifact{Kind: ArtifactIP, Value: ip}.ID()
+	db.mu.Lock()
+	if db.artifacts[id] == nil {
+		db.mu.Unlock()
+		return nil, nil
+	}
+	removed := []string{id}
+	for other := range db.links[id] {
+		delete(db.links[other], id)
+		db.relations--
+		if len(db.links[other]) == 0 {
+			delete(db.links, other)
+			delete(db.artifacts, other)
+			removed = append(removed, other)
+		}
+	}
+	delete(db.links, id)
+	delete(db.artifacts, id)
+	delete(db.resolved, id)
+	db.dirty = true
+	db.mu.Unlock()
+	sort.Strings(removed)
+	return removed, db.save()
+}
+
+// Stats returns the number of artifacts by kind and of relations.
+func (db *ArtifactDB) Stats() ArtifactStats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	st := ArtifactStats{Artifacts: make(map[string]int), Relations: db.relations, Skipped: db.skipped}
+	for _, a := range db.artifacts {
+		st.Artifacts[a.Kind]++
+	}
+	return st
+}
+
+// Close stops the lookups and saves the database.
+func (db *ArtifactDB) Close() error {
+	var err error
+	db.closeOnce.Do(func() {
+		close(db.stop)
+		db.done.Wait()
+		err = db.save()
+	})
+	return err
+}
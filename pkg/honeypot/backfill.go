@@ -0,0 +1,57 @@
+package honeypot
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// WaitingOutput is implemented by outputs that queue events and can wait
+// for room in the queue instead of dropping them, which bulk writes such as
+// Server.Backfill need.
+type WaitingOutput interface {
+	Output
+	WriteWait(ev Event) error
+}
+
+// Backfill emits the events of st matching q again, oldest first, to the
+// outputs of s, so that an output added later, such as a new SIEM
+// collector, receives the history. The events are emitted anew with the
+// time, sequence number and boot ID of s, and keep their own in the
+// original_time, original_seq and original_boot_id fields, with backfill
+// set. WaitingOutputs are waited for, not dropping events, and alerts are
+// not posted to the alert webhook. It returns the number of events emitted.
+func (s *Server) Backfill(st Storage, q StorageQuery) (int, error) {
+	n := 0
+	err := ExportEvents(st, q, func(content []byte) error {
+		var stored Event
+		if err := json.Unmarshal(content, &stored); err != nil {
+			return fmt.Errorf("reading stored event: %w", err)
+		}
+		fields := make(map[string]any, len(stored.Fields)+4)
+		for key, value := range stored.Fields {
+			fields[key] = value
+		}
+		fields["original_time"] = stored.Time.Format(time.RFC3339Nano)
+		fields["original_seq"] = stored.Seq
+		fields["original_boot_id"] = stored.BootID
+		fields["backfill"] = true
+		ev := stored
+		ev.Time, ev.Offset, ev.Fields = time.Time{}, 0, fields
+		ev = s.stamp(ev)
+		for _, o := range s.outputs {
+			var err error
+			if w, ok := o.(WaitingOutput); ok {
+				err = w.WriteWait(ev)
+			} else {
+				err = o.Write(ev)
+			}
+			if err != nil {
+				s.Log.Printf("Error writing backfilled %s event to %T: %s", ev.Type, o, err)
+			}
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,389 @@
+package honeypot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Delivery retries of a batch before it is dropped, and the wait before the first.
+const (
+	batchRetries      = 3
+	batchRetryBackoff = time.Second
+)
+
+// BatchConfig controls how a remote output groups and compresses events.
+type BatchConfig struct {
+	Size        int         `json:"size,omitempty"`        // events per batch, default 100
+	Interval    string      `json:"interval,omitempty"`    // longest an event waits for its batch to fill, default 5s
+	Queue       int         `json:"queue,omitempty"`       // events waiting to be batched before new ones are dropped, default 10000
+	Compression string      `json:"compression,omitempty"` // one of Compressions, such as "gzip", or empty for none
+	Spool       SpoolConfig `json:"spool"`                 // keeps undeliverable batches on disk, disabled without a directory
+}
+
+// BatchStats describes the deliveries of a Batcher.
+type BatchStats struct {
+	Batches     uint64        `json:"batches"`         // batches delivered
+	Events      uint64        `json:"events"`          // events delivered
+	Failed      uint64        `json:"failed"`          // delivery attempts that failed
+	Dropped     uint64        `json:"dropped"`         // events lost to a full queue or failed batches
+	RawBytes    uint64        `json:"raw_bytes"`       // payload bytes before compression
+	SentBytes   uint64        `json:"sent_bytes"`      // payload bytes sent
+	LastLatency time.Duration `json:"last_latency_ns"` // delivery time of the last batch
+	MaxLatency  time.Duration `json:"max_latency_ns"`  // longest delivery time of a batch
+	Queued      int           `json:"queued"`          // events waiting to be batched
+	QueueSize   int           `json:"queue_size"`      // events that may wait before new ones are dropped
+	Offline     bool          `json:"offline"`         // the last delivery failed
+	Spool       *SpoolStats   `json:"spool,omitempty"` // nil without a spool
+}
+
+func (st BatchStats) String() string {
+	ratio := 1.0
+	if st.SentBytes > 0 {
+		ratio = float64(st.RawBytes) / float64(st.SentBytes)
+	}
+	text := fmt.Sprintf("batches=%d events=%d failed=%d dropped=%d sent=%dB compression=%.1fx max_latency=%s",
+		st.Batches, st.Events, st.Failed, st.Dropped, st.SentBytes, ratio, st.MaxLatency)
+	if st.Spool != nil {
+		text += fmt.Sprintf(" spooled=%d replayed=%d evicted=%d waiting=%d/%dB",
+			st.Spool.Spooled, st.Spool.Replayed, st.Spool.Evicted, st.Spool.Files, st.Spool.Bytes)
+	}
+	return text
+}
+
+// Compression compresses the payloads of batches, named after the
+// Content-Encoding it produces. gzip is built in; others, such as zstd from
+// package github.com/jackyes/GoPot/pkg/zstd, are registered with
+// RegisterCompression.
+type Compression struct {
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	compressions = map[string]Compression{"gzip": {
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		NewReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	}}
+	compressionsMu sync.RWMutex
+)
+
+// RegisterCompression makes a compression available to batching outputs
+// under its Content-Encoding name. Packages providing one call it from an
+// init function.
+func RegisterCompression(name string, c Compression) {
+	compressionsMu.Lock()
+	defer compressionsMu.Unlock()
+	if _, exists := compressions[name]; exists {
+		panic("compression registered twice: " + name)
+	}
+	compressions[name] = c
+}
+
+// LookupCompression returns the compression registered as name.
+func LookupCompression(name string) (Compression, bool) {
+	compressionsMu.RLock()
+	defer compressionsMu.RUnlock()
+	c, ok := compressions[name]
+	return c, ok
+}
+
+// Compressions returns the names of the registered compressions in sorted
+// order.
+func Compressions() []string {
+	compressionsMu.RLock()
+	defer compressionsMu.RUnlock()
+	names := make([]string, 0, len(compressions))
+	for name := range compressions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BatchEncoder turns a batch of events into a request payload.
+type BatchEncoder func(events []Event) ([]byte, error)
+
+// BatchSender delivers an encoded, possibly compressed, payload. encoding is
+// the Content-Encoding of body, empty when it is not compressed.
+type BatchSender func(body []byte, encoding string) error
+
+// Batcher collects events and hands them to a sender in batches of up to
+// Size events, at least every Interval, so remote outputs make few large
+// requests instead of one per event. Write never blocks: events arriving
+// while the queue is full are dropped and counted.
+//
+// With a spool, batches that cannot be delivered are written to disk instead
+// of being dropped. While the destination is unreachable new batches go
+// straight to the spool, and every interval the oldest spooled batch is
+// tried again; once it gets through the spool is replayed oldest first,
+// alongside the new batches.
+type Batcher struct {
+	Log *log.Logger // receives delivery failures, nil to discard them
+
+	name        string
+	size        int
+	interval    time.Duration
+	compression string
+	encode      BatchEncoder
+	send        BatchSender
+	spool       *spool // nil without a spool
+	offline     atomic.Bool
+
+	mu      sync.RWMutex // held for writing while the queue is closed
+	queue   chan Event
+	done    chan struct{}
+	closing atomic.Bool
+
+	batches, events, failed, dropped atomic.Uint64
+	rawBytes, sentBytes              atomic.Uint64
+	lastLatency, maxLatency          atomic.Int64
+}
+
+// NewBatcher validates cfg and starts delivering. name identifies the output
+// in errors and statistics.
+func NewBatcher(name string, cfg BatchConfig, encode BatchEncoder, send BatchSender) (*Batcher, error) {
+	b := &Batcher{name: name, size: 100, interval: 5 * time.Second, compression: cfg.Compression, encode: encode, send: send, done: make(chan struct{})}
+	if cfg.Size < 0 || cfg.Queue < 0 {
+		return nil, fmt.Errorf("%s: batch size and queue must not be negative", name)
+	}
+	if cfg.Size > 0 {
+		b.size = cfg.Size
+	}
+	if cfg.Interval != "" {
+		interval, err := time.ParseDuration(cfg.Interval)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("%s: invalid batch interval %q", name, cfg.Interval)
+		}
+		b.interval = interval
+	}
+	if cfg.Compression != "" {
+		if _, ok := LookupCompression(cfg.Compression); !ok {
+			return nil, fmt.Errorf("%s: unsupported compression %q, want %s or none", name, cfg.Compression, strings.Join(Compressions(), ", "))
+		}
+	}
+	if cfg.Spool.Dir != "" {
+		sp, err := openSpool(cfg.Spool)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		b.spool = sp
+	}
+	queue := cfg.Queue
+	if queue == 0 {
+		queue = 10000
+	}
+	b.queue = make(chan Event, queue)
+	go b.run()
+	return b, nil
+}
+
+// Name returns the name of the output.
+func (b *Batcher) Name() string { return b.name }
+
+// Write implements Output.
+func (b *Batcher) Write(ev Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closing.Load() {
+		return nil
+	}
+	select {
+	case b.queue <- ev:
+		return nil
+	default:
+		b.dropped.Add(1)
+		return fmt.Errorf("%s queue full, dropped %s event", b.name, ev.Type)
+	}
+}
+
+// WriteWait implements WaitingOutput.
+func (b *Batcher) WriteWait(ev Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closing.Load() {
+		return fmt.Errorf("%s is closed", b.name)
+	}
+	b.queue <- ev
+	return nil
+}
+
+func (b *Batcher) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	batch := make([]Event, 0, b.size)
+	for {
+		select {
+		case ev, ok := <-b.queue:
+			if !ok {
+				b.deliver(batch)
+				return
+			}
+			batch = append(batch, ev)
+			if len(batch) >= b.size {
+				b.deliver(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			b.deliver(batch)
+			batch = batch[:0]
+			b.replay()
+		}
+	}
+}
+
+// deliver encodes, compresses and sends a batch, retrying failed attempts.
+func (b *Batcher) deliver(batch []Event) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := b.encode(batch)
+	if err != nil {
+		b.dropped.Add(uint64(len(batch)))
+		return
+	}
+	raw := len(body)
+	encoding := ""
+	if b.compression != "" {
+		compression, _ := LookupCompression(b.compression)
+		var buf bytes.Buffer
+		zw, err := compression.NewWriter(&buf)
+		if err == nil {
+			zw.Write(body)
+			err = zw.Close()
+		}
+		if err != nil {
+			b.dropped.Add(uint64(len(batch)))
+			return
+		}
+		body, encoding = buf.Bytes(), b.compression
+	}
+
+	if b.spool != nil && b.offline.Load() {
+		b.toSpool(body, encoding, len(batch), raw, nil)
+		return
+	}
+	backoff := batchRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := b.attempt(body, encoding)
+		if err == nil {
+			b.events.Add(uint64(len(batch)))
+			b.rawBytes.Add(uint64(raw))
+			return
+		}
+		if attempt == batchRetries || b.closing.Load() {
+			b.offline.Store(true)
+			if b.spool != nil {
+				b.toSpool(body, encoding, len(batch), raw, err)
+				return
+			}
+			b.dropped.Add(uint64(len(batch)))
+			if b.Log != nil {
+				b.Log.Printf("Output %s: dropped a batch of %d events after %d attempt(s): %s", b.name, len(batch), attempt+1, err)
+			}
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// attempt sends a payload once and records the outcome.
+func (b *Batcher) attempt(body []byte, encoding string) error {
+	start := time.Now()
+	err := b.send(body, encoding)
+	latency := time.Since(start)
+	b.lastLatency.Store(int64(latency))
+	storeMax(&b.maxLatency, int64(latency))
+	if err != nil {
+		b.failed.Add(1)
+		return err
+	}
+	b.offline.Store(false)
+	b.batches.Add(1)
+	b.sentBytes.Add(uint64(len(body)))
+	return nil
+}
+
+// toSpool keeps an undelivered payload of n events, raw bytes before
+// compression, on disk.
+func (b *Batcher) toSpool(body []byte, encoding string, n, raw int, cause error) {
+	if cause != nil && b.Log != nil {
+		b.Log.Printf("Output %s unreachable, spooling batches to %s: %s", b.name, b.spool.dir, cause)
+	}
+	evicted, err := b.spool.put(body, encoding, n, raw)
+	b.dropped.Add(uint64(evicted))
+	if err != nil {
+		b.dropped.Add(uint64(n))
+		if b.Log != nil {
+			b.Log.Printf("Output %s: dropped a batch of %d events: %s", b.name, n, err)
+		}
+	}
+}
+
+// replay delivers spooled batches oldest first, for at most one interval so
+// new events are not held up, and stops at the first failure.
+func (b *Batcher) replay() {
+	if b.spool == nil || b.closing.Load() {
+		return
+	}
+	deadline := time.Now().Add(b.interval)
+	for time.Now().Before(deadline) {
+		f, body, encoding, ok := b.spool.oldest()
+		if !ok {
+			return
+		}
+		wasOffline := b.offline.Load()
+		if err := b.attempt(body, encoding); err != nil {
+			b.offline.Store(true)
+			return
+		}
+		if wasOffline && b.Log != nil {
+			b.Log.Printf("Output %s reachable again, replaying %d spooled batches", b.name, b.spool.stats().Files)
+		}
+		b.events.Add(uint64(f.events))
+		b.rawBytes.Add(uint64(f.raw))
+		b.spool.remove(f.name)
+	}
+}
+
+// Stats returns the delivery statistics.
+func (b *Batcher) Stats() BatchStats {
+	st := BatchStats{
+		Batches:     b.batches.Load(),
+		Events:      b.events.Load(),
+		Failed:      b.failed.Load(),
+		Dropped:     b.dropped.Load(),
+		RawBytes:    b.rawBytes.Load(),
+		SentBytes:   b.sentBytes.Load(),
+		LastLatency: time.Duration(b.lastLatency.Load()),
+		MaxLatency:  time.Duration(b.maxLatency.Load()),
+		Queued:      len(b.queue),
+		QueueSize:   cap(b.queue),
+		Offline:     b.offline.Load(),
+	}
+	if b.spool != nil {
+		st.Spool = b.spool.stats()
+	}
+	return st
+}
+
+// Close delivers the events still queued, or spools them if the destination
+// is unreachable, and stops the batcher.
+func (b *Batcher) Close() error {
+	b.mu.Lock()
+	if !b.closing.Swap(true) {
+		close(b.queue)
+	}
+	b.mu.Unlock()
+	<-b.done
+	return nil
+}
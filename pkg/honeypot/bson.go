@@ -0,0 +1,276 @@
+package honeypot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// bsonDoc is a BSON document, with its keys in order like MongoDB expects
+// for commands.
+type bsonDoc []bsonElem
+
+type bsonElem struct {
+	Key   string
+	Value any // float64, string, bsonDoc, []any, []byte, bsonObjectID, bool, time.Time, nil, int32, int64 or bsonRaw
+}
+
+// bsonObjectID is a 12-byte ObjectId.
+type bsonObjectID [12]byte
+
+// bsonRaw is a value of a type that is decoded but not interpreted, such as
+// a timestamp or a regular expression, kept with its type number.
+type bsonRaw struct {
+	Type byte
+	Data []byte
+}
+
+var errBSON = errors.New("malformed BSON document")
+
+// Get returns the value of key, nil if the document has none.
+func (d bsonDoc) Get(key string) any {
+	for _, e := range d {
+		if e.Key == key {
+			return e.Value
+		}
+	}
+	return nil
+}
+
+// String returns the value of key if it is a string.
+func (d bsonDoc) String(key string) string {
+	s, _ := d.Get(key).(string)
+	return s
+}
+
+// Doc returns the value of key if it is a document.
+func (d bsonDoc) Doc(key string) bsonDoc {
+	doc, _ := d.Get(key).(bsonDoc)
+	return doc
+}
+
+// MarshalJSON renders d in MongoDB's relaxed extended JSON, keeping the
+// order of the keys, for logging.
+func (d bsonDoc) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, e := range d {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(e.Key)
+		b.Write(key)
+		b.WriteByte(':')
+		value, err := json.Marshal(bsonJSON(e.Value))
+		if err != nil {
+			return nil, err
+		}
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+func bsonJSON(v any) any {
+	switch v := v.(type) {
+	case []any:
+		values := make([]any, len(v))
+		for i, value := range v {
+			values[i] = bsonJSON(value)
+		}
+		return values
+	case bsonObjectID:
+		return map[string]string{"$oid": hex.EncodeToString(v[:])}
+	case time.Time:
+		return map[string]string{"$date": v.UTC().Format(time.RFC3339Nano)}
+	case []byte:
+		return map[string]any{"$binary": v}
+	case bsonRaw:
+		return map[string]any{"$type": fmt.Sprintf("%02x", v.Type), "$data": v.Data}
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Sprint(v)
+		}
+	}
+	return v
+}
+
+// parseBSON decodes the document at the start of b and returns it and its
+// length.
+func parseBSON(b []byte) (bsonDoc, int, error) {
+	if len(b) < 5 {
+		return nil, 0, errBSON
+	}
+	length := int(int32(binary.LittleEndian.Uint32(b)))
+	if length < 5 || length > len(b) || b[length-1] != 0 {
+		return nil, 0, errBSON
+	}
+	doc := bsonDoc{}
+	r := b[4 : length-1]
+	for len(r) > 0 {
+		typ := r[0]
+		key, rest, ok := bytes.Cut(r[1:], []byte{0})
+		if !ok {
+			return nil, 0, errBSON
+		}
+		value, n, err := parseBSONValue(typ, rest)
+		if err != nil {
+			return nil, 0, err
+		}
+		doc = append(doc, bsonElem{Key: string(key), Value: value})
+		r = rest[n:]
+	}
+	return doc, length, nil
+}
+
+// bsonFixedSizes are the lengths of the values of fixed-size types.
+var bsonFixedSizes = map[byte]int{0x01: 8, 0x07: 12, 0x08: 1, 0x09: 8, 0x0A: 0, 0x10: 4, 0x11: 8, 0x12: 8, 0x13: 16, 0x7F: 0, 0xFF: 0}
+
+func parseBSONValue(typ byte, b []byte) (any, int, error) {
+	if size, ok := bsonFixedSizes[typ]; ok {
+		if len(b) < size {
+			return nil, 0, errBSON
+		}
+		switch typ {
+		case 0x01:
+			return math.Float64frombits(binary.LittleEndian.Uint64(b)), 8, nil
+		case 0x07:
+			var id bsonObjectID
+			copy(id[:], b)
+			return id, 12, nil
+		case 0x08:
+			return b[0] != 0, 1, nil
+		case 0x09:
+			return time.UnixMilli(int64(binary.LittleEndian.Uint64(b))), 8, nil
+		case 0x0A:
+			return nil, 0, nil
+		case 0x10:
+			return int32(binary.LittleEndian.Uint32(b)), 4, nil
+		case 0x12:
+			return int64(binary.LittleEndian.Uint64(b)), 8, nil
+		}
+		return bsonRaw{Type: typ, Data: b[:size]}, size, nil
+	}
+	switch typ {
+	case 0x02, 0x0D, 0x0E: // string, JavaScript code, symbol
+		if len(b) < 4 {
+			return nil, 0, errBSON
+		}
+		length := int(int32(binary.LittleEndian.Uint32(b)))
+		if length < 1 || length > len(b)-4 || b[3+length] != 0 {
+			return nil, 0, errBSON
+		}
+		return string(b[4 : 3+length]), 4 + length, nil
+	case 0x03, 0x04:
+		doc, n, err := parseBSON(b)
+		if err != nil || typ == 0x03 {
+			return doc, n, err
+		}
+		values := make([]any, len(doc))
+		for i, e := range doc {
+			values[i] = e.Value
+		}
+		return values, n, nil
+	case 0x05:
+		if len(b) < 5 {
+			return nil, 0, errBSON
+		}
+		length := int(int32(binary.LittleEndian.Uint32(b)))
+		if length < 0 || length > len(b)-5 {
+			return nil, 0, errBSON
+		}
+		return b[5 : 5+length], 5 + length, nil
+	case 0x0B: // regular expression: pattern and options
+		pattern := bytes.IndexByte(b, 0)
+		if pattern < 0 {
+			return nil, 0, errBSON
+		}
+		options := bytes.IndexByte(b[pattern+1:], 0)
+		if options < 0 {
+			return nil, 0, errBSON
+		}
+		n := pattern + options + 2
+		return bsonRaw{Type: typ, Data: b[:n]}, n, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unsupported type 0x%02x", errBSON, typ)
+}
+
+// appendBSON appends the encoding of d to b.
+func appendBSON(b []byte, d bsonDoc) []byte {
+	start := len(b)
+	b = append(b, 0, 0, 0, 0)
+	for _, e := range d {
+		b = appendBSONValue(b, e.Key, e.Value)
+	}
+	b = append(b, 0)
+	binary.LittleEndian.PutUint32(b[start:], uint32(len(b)-start))
+	return b
+}
+
+func appendBSONValue(b []byte, key string, v any) []byte {
+	element := func(typ byte) {
+		b = append(b, typ)
+		b = append(b, key...)
+		b = append(b, 0)
+	}
+	switch v := v.(type) {
+	case float64:
+		element(0x01)
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+	case string:
+		element(0x02)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(v)+1))
+		b = append(b, v...)
+		b = append(b, 0)
+	case bsonDoc:
+		element(0x03)
+		b = appendBSON(b, v)
+	case []any:
+		element(0x04)
+		array := make(bsonDoc, len(v))
+		for i, value := range v {
+			array[i] = bsonElem{Key: fmt.Sprint(i), Value: value}
+		}
+		b = appendBSON(b, array)
+	case []byte:
+		element(0x05)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(v)))
+		b = append(b, 0)
+		b = append(b, v...)
+	case bsonObjectID:
+		element(0x07)
+		b = append(b, v[:]...)
+	case bool:
+		element(0x08)
+		if v {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+	case time.Time:
+		element(0x09)
+		b = binary.LittleEndian.AppendUint64(b, uint64(v.UnixMilli()))
+	case nil:
+		element(0x0A)
+	case int32:
+		element(0x10)
+		b = binary.LittleEndian.AppendUint32(b, uint32(v))
+	case int:
+		element(0x10)
+		b = binary.LittleEndian.AppendUint32(b, uint32(v))
+	case int64:
+		element(0x12)
+		b = binary.LittleEndian.AppendUint64(b, uint64(v))
+	case bsonRaw:
+		element(v.Type)
+		b = append(b, v.Data...)
+	default:
+		panic(fmt.Sprintf("appendBSONValue: unsupported type %T", v))
+	}
+	return b
+}
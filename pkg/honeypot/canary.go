@@ -0,0 +1,44 @@
+package honeypot
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CanaryCredential is a login seeded where only a leak would expose it, such
+// as a password manager export, a paste or a document of a file share: an
+// attacker presenting it reveals that the place it was seeded leaked.
+type CanaryCredential struct {
+	Username string `json:"username"`       // empty to match the password with any username
+	Password string `json:"password"`       // compared exactly
+	Note     string `json:"note,omitempty"` // where it was seeded, repeated in its events
+}
+
+// matches reports whether cred presents c. Usernames are compared without
+// regard to case, as most services do.
+func (c CanaryCredential) matches(cred Credential) bool {
+	return cred.Password == c.Password && (c.Username == "" || strings.EqualFold(cred.Username, c.Username))
+}
+
+// checkCanaries emits a canary event and raises a high canary_credential
+// alert when cred is one of the canary credentials of the server.
+func (m *ConnMeta) checkCanaries(cred Credential) {
+	for _, c := range m.server.Canaries {
+		if !c.matches(cred) {
+			continue
+		}
+		seeded := ""
+		fields := map[string]any{"username": cred.Username}
+		if c.Note != "" {
+			seeded = ", seeded in " + c.Note
+			fields["canary_note"] = c.Note
+		}
+		m.Emit(Event{
+			Type:    EventCanary,
+			Message: fmt.Sprintf("Canary credential used on port %s from %s: username=%q%s", m.Port, m.ClientAddr, cred.Username, seeded),
+			Fields:  fields,
+		})
+		m.RaiseAlert("high", "canary_credential", fmt.Sprintf("canary credential of %q%s used on port %s from %s", cred.Username, seeded, m.Port, m.ClientAddr))
+		return
+	}
+}
@@ -0,0 +1,205 @@
+package honeypot
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CaptureConfig enables packet captures of an attacker's follow-up traffic,
+// started when a session sends a payload URL, to catch second-stage activity
+// such as the download itself or a reverse shell.
+type CaptureConfig struct {
+	Dir           string `json:"dir"`                      // directory pcap files are written to, empty disables captures
+	Duration      string `json:"duration,omitempty"`       // how long to capture, default 5m
+	Interface     string `json:"interface,omitempty"`      // interface to capture on, default all
+	MaxBytes      int64  `json:"max_bytes,omitempty"`      // size limit of one capture file, default 50 MB
+	MaxConcurrent int    `json:"max_concurrent,omitempty"` // captures running at once, default 4
+}
+
+// capturer runs the captures configured by a CaptureConfig.
+type capturer struct {
+	cfg      CaptureConfig
+	duration time.Duration
+	slots    chan struct{}
+	mu       sync.Mutex
+	running  map[string]string // pcap file by attacker IP
+}
+
+// EnableCapture validates cfg and turns on follow-up traffic captures.
+// Capturing needs raw socket privileges (CAP_NET_RAW) and is only supported on Linux.
+func (s *Server) EnableCapture(cfg CaptureConfig) error {
+	c := &capturer{cfg: cfg, duration: 5 * time.Minute, running: make(map[string]string)}
+	if cfg.Dir == "" {
+		return fmt.Errorf("capture directory is required")
+	}
+	if cfg.Duration != "" {
+		d, err := time.ParseDuration(cfg.Duration)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid capture duration %q", cfg.Duration)
+		}
+		c.duration = d
+	}
+	if c.cfg.MaxBytes <= 0 {
+		c.cfg.MaxBytes = 50 << 20
+	}
+	if c.cfg.MaxConcurrent <= 0 {
+		c.cfg.MaxConcurrent = 4
+	}
+	c.slots = make(chan struct{}, c.cfg.MaxConcurrent)
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return fmt.Errorf("creating capture directory: %w", err)
+	}
+	s.capture = c
+	return nil
+}
+
+// startCapture begins capturing traffic between the client of m and the
+// address it reached, unless a capture of the client is already running, and
+// records which pcap file holds the traffic following the session. UDP
+// sessions are not captured: their source address can be forged to point a
+// capture at any host.
+func (s *Server) startCapture(m *ConnMeta, url string) {
+	c := s.capture
+	if c == nil || m.datagram {
+		return
+	}
+	host, _, err := net.SplitHostPort(m.ClientAddr)
+	if err != nil {
+		return
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return
+	}
+	// LocalAddr may be followed by the original destination of a redirect
+	local, _, _ := strings.Cut(m.LocalAddr, " ")
+	localIP := net.ParseIP(srcIP(local))
+	fields := map[string]any{"url": url}
+
+	c.mu.Lock()
+	if path, ok := c.running[ip.String()]; ok {
+		c.mu.Unlock()
+		fields["pcap"] = path
+		m.Emit(Event{Type: EventInfo, Message: fmt.Sprintf("Traffic of %s is already being captured to %s", ip, path), Fields: fields})
+		return
+	}
+	select {
+	case c.slots <- struct{}{}:
+	default:
+		c.mu.Unlock()
+		m.Emit(Event{Type: EventError, Message: fmt.Sprintf("Too many captures running, not capturing traffic of %s", ip), Fields: fields})
+		return
+	}
+	name := fmt.Sprintf("%s-%s-%s.pcap", time.Now().UTC().Format("20060102T150405Z"), strings.ReplaceAll(ip.String(), ":", "_"), m.Session)
+	path := filepath.Join(c.cfg.Dir, name)
+	c.running[ip.String()] = path
+	c.mu.Unlock()
+
+	fields["pcap"] = path
+	m.Emit(Event{Type: EventInfo, Message: fmt.Sprintf("Capturing traffic of %s for %s to %s", ip, c.duration, path), Fields: fields})
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.running, ip.String())
+			c.mu.Unlock()
+			<-c.slots
+		}()
+		ctx, cancel := context.WithTimeout(s.ctx, c.duration)
+		defer cancel()
+		packets, err := c.run(ctx, ip, localIP, path)
+		ev := Event{Session: m.Session, Port: m.Port, SrcAddr: m.ClientAddr, Labels: m.Labels, Fields: map[string]any{"pcap": path, "packets": packets}}
+		if err != nil {
+			ev.Type, ev.Message = EventError, fmt.Sprintf("Capture of %s to %s failed after %d packets: %s", ip, path, packets, err)
+		} else {
+			ev.Type, ev.Message = EventInfo, fmt.Sprintf("Capture of %s finished, %d packets written to %s", ip, packets, path)
+		}
+		s.Emit(ev)
+	}()
+}
+
+// run writes the packets between ip and local, or to and from ip when local
+// is nil, to a new pcap file until ctx is done or the file reaches its size
+// limit.
+func (c *capturer) run(ctx context.Context, ip, local net.IP, path string) (int, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	pw := &pcapWriter{w: bufio.NewWriter(file)}
+	if err := pw.writeHeader(); err != nil {
+		return 0, err
+	}
+	packets, err := capturePackets(ctx, c.cfg.Interface, ip, local, pw, c.cfg.MaxBytes)
+	if flushErr := pw.w.Flush(); err == nil {
+		err = flushErr
+	}
+	return packets, err
+}
+
+// pcapWriter writes packets in the classic libpcap file format with the raw
+// IP link type, readable by tcpdump and Wireshark.
+type pcapWriter struct {
+	w       *bufio.Writer
+	written int64
+}
+
+const (
+	pcapSnapLen   = 65535
+	linkTypeRawIP = 101
+)
+
+func (pw *pcapWriter) writeHeader() error {
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4) // magic, microsecond timestamps
+	binary.LittleEndian.PutUint16(hdr[4:], 2)          // version 2.4
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], linkTypeRawIP)
+	_, err := pw.w.Write(hdr[:])
+	pw.written += int64(len(hdr))
+	return err
+}
+
+func (pw *pcapWriter) writePacket(ts time.Time, packet []byte) error {
+	var hdr [16]byte
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(len(packet)))
+	if _, err := pw.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := pw.w.Write(packet)
+	pw.written += int64(len(hdr) + len(packet))
+	return err
+}
+
+// involves reports whether the IP packet was sent from or to ip.
+func involves(packet []byte, ip net.IP) bool {
+	if len(packet) == 0 {
+		return false
+	}
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) < 20 {
+			return false
+		}
+		return ip.Equal(net.IP(packet[12:16])) || ip.Equal(net.IP(packet[16:20]))
+	case 6:
+		if len(packet) < 40 {
+			return false
+		}
+		return ip.Equal(net.IP(packet[8:24])) || ip.Equal(net.IP(packet[24:40]))
+	}
+	return false
+}
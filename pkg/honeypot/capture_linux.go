@@ -0,0 +1,120 @@
+//go:build linux
+
+package honeypot
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// capturePackets reads IP packets from a packet socket and writes those
+// between ip and local, see captureFilter, until ctx is done or maxBytes
+// have been written.
+func capturePackets(ctx context.Context, iface string, ip, local net.IP, pw *pcapWriter, maxBytes int64) (int, error) {
+	proto := htons(syscall.ETH_P_ALL)
+	// SOCK_DGRAM strips the link-layer header, leaving the IP packet
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, int(proto))
+	if err != nil {
+		return 0, fmt.Errorf("opening packet socket: %w", err)
+	}
+	defer syscall.Close(fd)
+	if err := syscall.AttachLsf(fd, captureFilter(ip, local)); err != nil {
+		return 0, fmt.Errorf("attaching capture filter: %w", err)
+	}
+
+	if iface != "" {
+		ifi, err := net.InterfaceByName(iface)
+		if err != nil {
+			return 0, err
+		}
+		if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: proto, Ifindex: ifi.Index}); err != nil {
+			return 0, fmt.Errorf("binding to %s: %w", iface, err)
+		}
+	}
+	// Wake up regularly to notice the end of the capture
+	tv := syscall.NsecToTimeval(int64(500 * time.Millisecond))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, pcapSnapLen)
+	packets := 0
+	for ctx.Err() == nil && pw.written < maxBytes {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			return packets, err
+		}
+		if !involves(buf[:n], ip) { // queued before the filter was attached
+			continue
+		}
+		if err := pw.writePacket(time.Now(), buf[:n]); err != nil {
+			return packets, err
+		}
+		packets++
+	}
+	return packets, nil
+}
+
+func htons(v uint16) uint16 { return v<<8 | v>>8 }
+
+// captureFilter returns the classic BPF program run by the kernel on the
+// packets of a capture, so that only those between ip and local reach the
+// packet socket rather than every packet of the host. When local is nil, or
+// of the other address family, the packets from or to ip are kept.
+func captureFilter(ip, local net.IP) []syscall.SockFilter {
+	version, src, dst, addr := uint32(6), uint32(8), uint32(24), ip.To16()
+	if v4 := ip.To4(); v4 != nil {
+		version, src, dst, addr = 4, 12, 16, v4
+	}
+	var peer net.IP
+	if local != nil && (local.To4() != nil) == (version == 4) {
+		peer = local.To16()
+		if version == 4 {
+			peer = local.To4()
+		}
+	}
+
+	// A packet is kept if its addresses match one of the alternatives, each
+	// tried in turn until a comparison fails
+	type match struct {
+		offset uint32
+		addr   net.IP
+	}
+	alternatives := [][]match{{{src, addr}}, {{dst, addr}}}
+	if peer != nil {
+		alternatives = [][]match{{{src, addr}, {dst, peer}}, {{src, peer}, {dst, addr}}}
+	}
+	words := len(addr) / 4
+	length := 2*words*len(alternatives[0]) + 1 // instructions of an alternative
+	const header = 3
+	drop := header + len(alternatives)*length
+
+	prog := []syscall.SockFilter{
+		{Code: syscall.BPF_LD | syscall.BPF_B | syscall.BPF_ABS, K: 0},
+		{Code: syscall.BPF_ALU | syscall.BPF_RSH | syscall.BPF_K, K: 4},
+		{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, K: version, Jf: uint8(drop - header)},
+	}
+	for i, alt := range alternatives {
+		next := header + (i+1)*length // the next alternative, or drop after the last
+		for _, m := range alt {
+			for w := 0; w < words; w++ {
+				prog = append(prog, syscall.SockFilter{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, K: m.offset + uint32(4*w)})
+				prog = append(prog, syscall.SockFilter{
+					Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K,
+					K:    binary.BigEndian.Uint32(m.addr[4*w:]),
+					Jf:   uint8(next - len(prog) - 1),
+				})
+			}
+		}
+		prog = append(prog, syscall.SockFilter{Code: syscall.BPF_RET | syscall.BPF_K, K: pcapSnapLen})
+	}
+	return append(prog, syscall.SockFilter{Code: syscall.BPF_RET | syscall.BPF_K, K: 0})
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package honeypot
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// capturePackets is only implemented on Linux.
+func capturePackets(ctx context.Context, iface string, ip, local net.IP, pw *pcapWriter, maxBytes int64) (int, error) {
+	return 0, errors.New("packet capture is only supported on Linux")
+}
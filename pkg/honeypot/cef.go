@@ -0,0 +1,161 @@
+package honeypot
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// cefMaxString bounds the custom strings of a CEF record, as ArcSight does.
+const cefMaxString = 4000
+
+// cefNames are the CEF names of the event types, their Name unless they
+// are alerts, which are named by their description.
+var cefNames = map[string]string{
+	EventConnection:     "Connection",
+	EventData:           "Data received",
+	EventCredential:     "Login attempt",
+	EventCanary:         "Canary credential used",
+	EventSessionEnd:     "Session ended",
+	EventAlert:          "Alert",
+	EventDDoSPrep:       "DDoS amplifier probed",
+	EventFirewall:       "Firewall block",
+	EventScan:           "Port scan",
+	EventError:          "Sensor error",
+	EventInfo:           "Information",
+	EventHandlerTimeout: "Handler timeout",
+	EventConfigSummary:  "Sensor started",
+}
+
+// cefSeverities are the CEF severities, from 0 to 10, of the event types
+// other than alerts, whose severity follows Event.Severity.
+var cefSeverities = map[string]int{
+	EventConnection:     2,
+	EventData:           3,
+	EventCredential:     4,
+	EventCanary:         10,
+	EventDDoSPrep:       7,
+	EventFirewall:       5,
+	EventScan:           5,
+	EventError:          3,
+	EventHandlerTimeout: 3,
+}
+
+var cefVersion = sync.OnceValue(func() string { return ReadBuildInfo().Version })
+
+// FormatCEF returns ev as an ArcSight Common Event Format record, for SIEMs
+// requiring CEF. The signature ID is the event type, or "alert:" followed by
+// the alert kind, and the extension maps the event with the standard keys
+// (rt, src, spt, dst, dpt, proto, app, msg, suser, in, out, act, cat,
+// externalId, deviceExternalId, dhost) and labelled custom ones: cs1 the
+// session, cs2 the password of a login attempt, cs3 the labels, cs4 the
+// alert kind, cs5 the attack stage, cs6 the data received and cn1 the
+// duration of a session in milliseconds.
+func FormatCEF(ev Event) string {
+	signature, name, severity := ev.Type, cefNames[ev.Type], cefSeverities[ev.Type]
+	if name == "" {
+		name = ev.Type
+	}
+	if ev.Type == EventAlert {
+		kind, _ := ev.Fields["alert"].(string)
+		signature = "alert:" + kind
+		if description, _ := ev.Fields["description"].(string); description != "" {
+			name = description
+		}
+		switch ev.Severity {
+		case "high":
+			severity = 9
+		case "medium":
+			severity = 6
+		default:
+			severity = 3
+		}
+	}
+
+	var ext []string
+	add := func(key string, value string) {
+		if value != "" {
+			ext = append(ext, key+"="+cefEscape(value))
+		}
+	}
+	custom := func(key, label, value string) {
+		if value != "" {
+			if len(value) > cefMaxString {
+				value = value[:cefMaxString]
+			}
+			ext = append(ext, key+"="+cefEscape(value), key+"Label="+label)
+		}
+	}
+	str := func(key string) string {
+		s, _ := ev.Fields[key].(string)
+		return s
+	}
+	num := func(key string) string {
+		if n, ok := fieldNumber(ev.Fields, key); ok {
+			return strconv.FormatInt(int64(n), 10)
+		}
+		return ""
+	}
+
+	add("rt", strconv.FormatInt(ev.Time.UnixMilli(), 10))
+	add("cat", ev.Type)
+	if host, port, err := net.SplitHostPort(ev.SrcAddr); err == nil {
+		add("src", host)
+		add("spt", port)
+	}
+	if host, _, err := net.SplitHostPort(ev.DstAddr); err == nil {
+		add("dst", host)
+	}
+	add("dpt", ev.Port)
+	add("dhost", ev.Host)
+	if ev.Session != "" {
+		proto := "TCP"
+		if str("transport") == "udp" {
+			proto = "UDP"
+		}
+		add("proto", proto)
+	}
+	add("app", ev.Handler)
+	add("msg", ev.Message)
+	add("suser", str("username"))
+	add("in", num("received_bytes"))
+	add("out", num("sent_bytes"))
+	add("act", str("firewall_action"))
+	add("externalId", strconv.FormatUint(ev.Seq, 10))
+	add("deviceExternalId", ev.BootID)
+	custom("cs1", "session", ev.Session)
+	custom("cs2", "password", str("password"))
+	if len(ev.Labels) > 0 {
+		keys := make([]string, 0, len(ev.Labels))
+		for key := range ev.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		labels := make([]string, len(keys))
+		for i, key := range keys {
+			labels[i] = key + "=" + ev.Labels[key]
+		}
+		custom("cs3", "labels", strings.Join(labels, ","))
+	}
+	custom("cs4", "alert", str("alert"))
+	custom("cs5", "stage", str("stage"))
+	custom("cs6", "payload", str("data"))
+	if duration, ok := fieldNumber(ev.Fields, "duration"); ok {
+		ext = append(ext, "cn1="+strconv.FormatInt(int64(duration*1000), 10), "cn1Label=duration_ms")
+	}
+
+	return "CEF:0|GoPot|GoPot|" + cefHeader(cefVersion()) + "|" + cefHeader(signature) + "|" + cefHeader(name) + "|" +
+		strconv.Itoa(severity) + "|" + strings.Join(ext, " ")
+}
+
+// cefHeader escapes a header field of a CEF record.
+func cefHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ").Replace(s)
+}
+
+// cefEscape escapes an extension value of a CEF record.
+func cefEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,102 @@
+package honeypot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// MaxClockSkew is the clock offset beyond which CheckClock raises a clock_skew alert.
+const MaxClockSkew = 2 * time.Second
+
+// ntpEpochOffset is the number of seconds between the NTP epoch (1900) and the Unix epoch.
+const ntpEpochOffset = 2208988800
+
+// QueryNTP asks an NTP server for the offset of the local clock: the
+// duration to add to local time to get the server's time.
+func QueryNTP(server string) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req := make([]byte, 48)
+	req[0] = 0x23 // leap indicator 0, version 4, client mode
+	t1 := time.Now()
+	binary.BigEndian.PutUint64(req[40:], toNTPTime(t1)) // echoed back as the originate timestamp
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	t4 := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	if n < 48 || resp[0]&0x07 != 4 {
+		return 0, errors.New("invalid NTP response")
+	}
+	if resp[1] == 0 {
+		return 0, errors.New("NTP server is not synchronised (kiss of death)")
+	}
+	t2 := fromNTPTime(binary.BigEndian.Uint64(resp[32:]))
+	t3 := fromNTPTime(binary.BigEndian.Uint64(resp[40:]))
+	return (t2.Sub(t1) + t3.Sub(t4)) / 2, nil
+}
+
+func toNTPTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return secs<<32 | frac
+}
+
+func fromNTPTime(v uint64) time.Time {
+	secs := int64(v>>32) - ntpEpochOffset
+	nanos := int64((v & 0xffffffff) * 1e9 >> 32)
+	return time.Unix(secs, nanos)
+}
+
+// CheckClock measures the local clock against an NTP server. The offset is
+// recorded in every later event, so a collector can correct the timestamps,
+// and a clock_skew alert is raised when it exceeds MaxClockSkew.
+func (s *Server) CheckClock(server string) error {
+	offset, err := QueryNTP(server)
+	if err != nil {
+		return fmt.Errorf("querying NTP server %s: %w", server, err)
+	}
+	s.clockOffset.Store(int64(offset))
+	s.clockChecked.Store(true)
+	switch {
+	case offset > MaxClockSkew:
+		s.RaiseAlert("medium", "clock_skew", fmt.Sprintf("local clock is %s behind %s", offset.Round(time.Millisecond), server))
+	case offset < -MaxClockSkew:
+		s.RaiseAlert("medium", "clock_skew", fmt.Sprintf("local clock is %s ahead of %s", -offset.Round(time.Millisecond), server))
+	}
+	return nil
+}
+
+// MonitorClock calls CheckClock now and then every interval until Shutdown,
+// reporting failures as error events.
+func (s *Server) MonitorClock(server string, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := s.CheckClock(server); err != nil {
+				s.logf(EventError, "Clock check failed: %s", err)
+			}
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,121 @@
+package honeypot
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CollectorConfig makes a GoPot the collector of a fleet of sensors, which
+// forward their events with remote outputs to a grpcs URL, authenticated
+// by client certificates, see RemoteTLSConfig.
+type CollectorConfig struct {
+	Listen   string `json:"listen"`    // address sensors connect to, e.g. ":7443", empty to disable
+	Cert     string `json:"cert"`      // PEM certificate of the collector
+	Key      string `json:"key"`       // PEM private key of Cert
+	ClientCA string `json:"client_ca"` // PEM certificates of the CAs sensor certificates must chain to
+}
+
+// SensorStats describes what a Collector received from a sensor.
+type SensorStats struct {
+	Name     string    `json:"name"`      // common name of the sensor's certificate
+	Addr     string    `json:"addr"`      // address of its last delivery
+	BootID   string    `json:"boot_id"`   // of the last event received, changes when the sensor restarts
+	Batches  uint64    `json:"batches"`   // batches received
+	Events   uint64    `json:"events"`    // events received
+	LastSeen time.Time `json:"last_seen"` // time of its last delivery
+}
+
+// Collector receives the events of sensors and emits them on a Server,
+// whose outputs store, aggregate and serve them like its own. Package
+// eventrpc serves the gRPC service sensors deliver batches to with mutual
+// TLS, and passes each to Receive. Each sensor is named by the common name
+// of its certificate, which is set as the "sensor" label of its events.
+type Collector struct {
+	server *Server
+
+	mu      sync.Mutex
+	sensors map[string]*SensorStats
+}
+
+// NewCollector returns a collector emitting the events it receives on srv.
+func NewCollector(srv *Server) *Collector {
+	return &Collector{server: srv, sensors: make(map[string]*SensorStats)}
+}
+
+// TLSConfig returns the TLS configuration of a collector, which requires
+// sensors to present a certificate signed by ClientCA.
+func (cfg CollectorConfig) TLSConfig() (*tls.Config, error) {
+	if cfg.Cert == "" || cfg.Key == "" || cfg.ClientCA == "" {
+		return nil, errors.New("the collector needs cert, key and client_ca")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate: %w", err)
+	}
+	clients, err := loadCertPool(cfg.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clients,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// Receive emits a batch of events the sensor named sensor delivered from
+// addr, labelled with its name whatever the events claim, and counts it in
+// the statistics of the sensor.
+func (c *Collector) Receive(sensor, addr string, events []Event) {
+	for _, ev := range events {
+		labels := make(map[string]string, len(ev.Labels)+1)
+		for key, value := range ev.Labels {
+			labels[key] = value
+		}
+		labels["sensor"] = sensor
+		ev.Labels = labels
+		c.server.Ingest(ev)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.sensors[sensor]
+	if !ok {
+		st = &SensorStats{Name: sensor}
+		c.sensors[sensor] = st
+	}
+	st.Addr, st.LastSeen = addr, time.Now()
+	st.Batches++
+	st.Events += uint64(len(events))
+	if len(events) > 0 {
+		st.BootID = events[len(events)-1].BootID
+	}
+}
+
+// Sensors returns what the collector received from each sensor, by name.
+func (c *Collector) Sensors() []SensorStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sensors := make([]SensorStats, 0, len(c.sensors))
+	for _, st := range c.sensors {
+		sensors = append(sensors, *st)
+	}
+	sort.Slice(sensors, func(i, j int) bool { return sensors[i].Name < sensors[j].Name })
+	return sensors
+}
+
+// Ingest emits an event another GoPot emitted, such as one of the sensors
+// of a Collector. Unlike Emit it keeps the time, ordering fields and boot ID
+// of ev, which tell the events of the other GoPot apart, and leaves its
+// labels alone. ev is redacted and delivered to the outputs and the alert
+// webhook; the firewall ignores it, since its clients connected elsewhere.
+func (s *Server) Ingest(ev Event) {
+	if s.Redactor != nil {
+		ev = s.Redactor.Redact(ev)
+	}
+	s.deliver(ev)
+}
@@ -0,0 +1,78 @@
+package honeypot
+
+import (
+	"net"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Port collision policies, see Server.PortCollision.
+const (
+	CollisionWarn   = "warn"   // raise an alert and listen anyway
+	CollisionRefuse = "refuse" // raise an alert and leave the port to the local service
+	CollisionOff    = "off"    // don't check
+)
+
+// CollisionPolicies are the valid values of Server.PortCollision.
+var CollisionPolicies = []string{CollisionWarn, CollisionRefuse, CollisionOff}
+
+// collisionTimeout bounds the connection attempt and banner read of a
+// collision check; local services accept in well under a millisecond.
+const collisionTimeout = 300 * time.Millisecond
+
+// localService connects to port on the loopback addresses and reports
+// whether a service accepts connections there, with the first line it sends,
+// if any, to identify it: a honeypot answering in front of a production SSH
+// or database server would hide it from its own users, or worse, get real
+// users' passwords logged.
+func localService(port string) (string, bool) {
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), collisionTimeout)
+		if err != nil {
+			continue
+		}
+		conn.SetReadDeadline(time.Now().Add(collisionTimeout))
+		buf := make([]byte, 256)
+		n, _ := conn.Read(buf)
+		conn.Close()
+		line, _, _ := strings.Cut(string(buf[:n]), "\n")
+		line = strings.TrimSpace(strings.Map(func(r rune) rune {
+			if !unicode.IsPrint(r) {
+				return -1
+			}
+			return r
+		}, line))
+		return line, true
+	}
+	return "", false
+}
+
+// checkCollision looks for a local service already answering on port before
+// it is listened on, and reports whether ListenAndServe should go ahead.
+func (s *Server) checkCollision(port string) bool {
+	if s.PortCollision == CollisionOff {
+		return true
+	}
+	banner, found := localService(port)
+	if !found {
+		return true
+	}
+	refuse := s.PortCollision == CollisionRefuse
+	severity, message := "medium", "a local service already answers on port "+port
+	if banner != "" {
+		message += " with " + banner
+	}
+	if refuse {
+		severity, message = "high", message+"; not listening on it"
+	} else {
+		message += "; listening anyway, set the port collision policy to refuse to leave it alone"
+	}
+	ev := newAlertEvent(severity, "port_collision", message)
+	ev.Fields["port"] = port
+	if banner != "" {
+		ev.Fields["local_banner"] = banner
+	}
+	s.Emit(ev)
+	return !refuse
+}
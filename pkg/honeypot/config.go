@@ -0,0 +1,376 @@
+package honeypot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Config is the JSON configuration file of a GoPot sensor.
+type Config struct {
+	Ports          []PortConfig       `json:"ports"`           // listeners, in order; later entries override earlier ones
+	RedirectPort   int                `json:"redirect_port"`   // port netfilter redirects the TCP ports to, listened on instead of them, see Server.RedirectPort; Linux only
+	DefaultHandler string             `json:"handler"`         // handler for ports that don't name one
+	LogDir         string             `json:"log_dir"`         // directory of the text log files
+	LogFormat      string             `json:"log_format"`      // format of their lines, one of LogFormats, empty for text
+	LogSigning     LogSigningConfig   `json:"log_signing"`     // signature chain making them tamper-evident
+	Profile        string             `json:"profile"`         // host identity preset
+	Hostname       string             `json:"hostname"`        // overrides the host name of Profile
+	Seed           string             `json:"seed"`            // makes the randomness of personas reproducible, see HostIdentity.Seed
+	Watchlist      string             `json:"watchlist"`       // leaked-credential watchlist file
+	Honeytokens    HoneytokenConfig   `json:"honeytokens"`     // unique bait values planted in decoy files, see Server.EnableHoneytokens
+	Canaries       []CanaryCredential `json:"canaries"`        // logins seeded to detect leaks, see Server.Canaries
+	AlertWebhook   string             `json:"alert_webhook"`   // URL alerts are POSTed to
+	Plugins        []string           `json:"plugins"`         // Go plugin files providing handlers
+	Scripts        map[string]string  `json:"scripts"`         // dialog scripts registered as handlers, by name
+	MaxConnections int                `json:"max_connections"` // concurrent connections across all ports
+	QueueSize      int                `json:"queue_size"`      // accepted connections waiting for a free worker
+	PortLimit      int                `json:"port_limit"`      // connections per listener unless its port group sets max_connections
+	DrainTimeout   string             `json:"drain_timeout"`   // how long active connections may finish on shutdown
+	Hooks          []HookConfig       `json:"hooks"`           // commands run for matching events
+	Capture        CaptureConfig      `json:"capture"`         // follow-up traffic captures, disabled without a directory
+	NTPServer      string             `json:"ntp_server"`      // server the local clock is checked against hourly, empty to disable
+	Anomaly        AnomalyConfig      `json:"anomaly"`         // connection rate spike alerts
+	ScanDetection  ScanConfig         `json:"scan_detection"`  // scan events correlating the connections of each client, see Server.EnableScanDetection
+	Sampling       []SamplingRule     `json:"sampling"`        // noisy event types thinned out by client network, see Server.EnableSampling
+	Limits         LimitsConfig       `json:"limits"`          // timeouts and read limits, port groups may override them
+	GeoDB          string             `json:"geo_db"`          // IP-to-country/ASN database, see LoadGeoDB
+	Reports        ReportsConfig      `json:"reports"`         // periodic country and ASN reports
+	API            APIConfig          `json:"api"`             // HTTP query API
+	Storage        StorageConfig      `json:"storage"`         // event storage backend
+	Artifacts      ArtifactConfig     `json:"artifacts"`       // database of artifacts observed in attacker traffic
+	Archive        ArchiveConfig      `json:"archive"`         // rotated logs and artifacts uploaded to an S3-compatible bucket
+	ModbusDevice   string             `json:"modbus_device"`   // device profile of the modbus handler, see LoadModbusDevice
+	SNMPAgent      string             `json:"snmp_agent"`      // MIB profile of the snmp handler, see LoadSNMPAgent
+	Elasticsearch  string             `json:"elasticsearch"`   // cluster profile of the elasticsearch handler, see LoadElasticsearchCluster
+	UPnPDevice     string             `json:"upnp_device"`     // gateway profile of the ssdp and upnp handlers, see LoadUPnPDevice
+	TFTPRoot       string             `json:"tftp_root"`       // directory of the bait files served by the tftp handler, empty to serve none
+	ShellTree      string             `json:"shell_tree"`      // files the shell emulator shows on top of its defaults, see LoadShellTree
+	PortCollision  string             `json:"port_collision"`  // "warn", "refuse" or "off", see Server.PortCollision
+	ReadOnly       bool               `json:"read_only"`       // send clients nothing, see Server.ReadOnly
+	DataEncoding   string             `json:"data_encoding"`   // how data events hold payloads that are not printable text, see DataEncodings
+	LegalNotice    LegalNoticeConfig  `json:"legal_notice"`    // consent banner of interactive personas
+	Redaction      RedactionConfig    `json:"redaction"`       // personal data masked in events, see Redactor
+	DecoyTraffic   DecoyTrafficConfig `json:"decoy_traffic"`   // outbound background traffic of an ordinary host
+	DecoyAddresses DecoyAddressConfig `json:"decoy_addresses"` // further hosts impersonated on unused addresses of the LAN
+	Responder      ResponderConfig    `json:"responder"`       // language model answering what personas have no canned reply for
+	Sharing        SharingConfig      `json:"sharing"`         // anonymized statistics shared with a community endpoint, opt-in
+	GreyNoise      GreyNoiseConfig    `json:"greynoise"`       // classification of clients as background scanners, opt-in
+	DShield        DShieldConfig      `json:"dshield"`         // connections submitted to the SANS Internet Storm Center, opt-in
+	Journal        JournalConfig      `json:"journal"`         // events written to the systemd journal with structured fields, Linux only
+	EventLog       EventLogConfig     `json:"event_log"`       // events written to the Windows Event Log, Windows only
+	CrowdSec       CrowdSecConfig     `json:"crowdsec"`        // sessions pushed to a CrowdSec agent as alerts, opt-in
+	OTel           OTelConfig         `json:"otel"`            // session spans and metrics exported to an OpenTelemetry collector over OTLP/HTTP
+	StatsD         StatsDConfig       `json:"statsd"`          // counters and timings sent to a StatsD, DogStatsD or Telegraf agent
+	GELF           GELFConfig         `json:"gelf"`            // events sent to a Graylog GELF input over UDP or TCP
+	Splunk         SplunkConfig       `json:"splunk"`          // events sent to a Splunk HTTP Event Collector
+	FailLog        FailLogConfig      `json:"fail_log"`        // plain text log of client actions for fail2ban
+	Firewall       FirewallConfig     `json:"firewall"`        // clients blocked on the host firewall, disabled without a backend
+	Sandbox        bool               `json:"sandbox"`         // confine the process once its ports are bound, see SandboxPolicy
+	Ingress        bool               `json:"ingress"`         // in a container without host networking, expect PROXY headers on every port
+	TrustedProxies []string           `json:"trusted_proxies"` // addresses and networks of the load balancers sending PROXY headers, empty for loopback and private ones
+	Kubernetes     KubernetesConfig   `json:"kubernetes"`      // in-cluster deployment: probes, pod metadata, Services and reloads
+	Collector      CollectorConfig    `json:"collector"`       // events received from sensors over mutual TLS, see Collector
+	NodeID         string             `json:"node_id"`         // identifies the sensor in the node_id label of its events, empty for none
+	Region         string             `json:"region"`          // where the sensor is deployed, in the region label of its events, empty for none
+	Tags           map[string]string  `json:"tags"`            // further labels of every event, e.g. {"env": "prod"}, see SensorLabels
+
+	Remotes []RemoteConfig          `json:"remotes"` // HTTP endpoints and GoPot collectors receiving batches of events
+	Feeds   []FeedConfig            `json:"feeds"`   // IP reputation feeds tagging or dropping connections
+	Filters map[string]OutputFilter `json:"filters"` // events each output receives, by output name, see OutputNames
+
+	Environment  string                     `json:"environment"`  // entry of Environments applied, e.g. "prod"
+	Environments map[string]json.RawMessage `json:"environments"` // settings overriding the others, by environment name, see LoadConfigEnvironment
+
+	Path string `json:"-"` // file the configuration was read from, empty for DefaultConfig
+}
+
+// StorageConfig selects the backend events are stored in, see OpenStorage.
+type StorageConfig struct {
+	DSN       string `json:"dsn"`                 // e.g. "file:///var/lib/gopot/events.jsonl", empty to disable
+	Retention string `json:"retention,omitempty"` // age after which events are pruned, empty to keep them
+}
+
+// ReportsConfig enables the periodic country and ASN aggregation reports.
+type ReportsConfig struct {
+	Dir      string `json:"dir"`                // directory reports are stored in, empty to disable
+	Interval string `json:"interval,omitempty"` // report period, default 1h
+	Weekly   bool   `json:"weekly,omitempty"`   // also write a weekly trend report diffed against the previous week
+	Push     bool   `json:"push,omitempty"`     // raise an alert with the summary of each weekly report
+}
+
+// PortConfig configures a group of listening ports.
+type PortConfig struct {
+	Ports   string            `json:"ports"`             // port specification, see ParsePortSpec
+	Handler string            `json:"handler,omitempty"` // handler name, empty for the default handler
+	Proxy   bool              `json:"proxy,omitempty"`   // expect a PROXY protocol header
+	Labels  map[string]string `json:"labels,omitempty"`  // echoed into every event from these ports
+
+	ServicePort int `json:"service_port,omitempty"` // port clients target when a Service or NAT maps it to the group's single port, reported instead
+
+	MaxConnections int           `json:"max_connections,omitempty"` // connections each of these listeners may have at once
+	Priority       int           `json:"priority,omitempty"`        // weight of these ports when the connection pool is saturated, higher ones are served first and shed last
+	Limits         *LimitsConfig `json:"limits,omitempty"`          // overrides of the global timeouts and read limits
+}
+
+// PortOptions are the effective settings of one listening port.
+type PortOptions struct {
+	Handler string            // handler name, empty for Server.DefaultHandler
+	Proxy   bool              // expect a PROXY protocol header
+	Labels  map[string]string // echoed into every event from the port
+
+	ServicePort string // port clients target, reported in events instead of the listening one, empty for the same
+
+	MaxConnections int            // connections the port's listener may have at once, 0 for Server.PortLimit
+	Priority       int            // connections queued for a worker are served by descending priority, and shed by ascending priority when the queue is full; default 0
+	Limits         *SessionLimits // timeouts and read limits, nil for Server.Limits
+}
+
+// DefaultConfig returns the configuration used when no file is given.
+func DefaultConfig() *Config {
+	return &Config{
+		Ports: []PortConfig{
+			{Ports: DefaultPorts + ",!110,!143,!993,!995"},
+			{Ports: "110", Handler: "pop3"}, {Ports: "143", Handler: "imap"}, {Ports: "993", Handler: "imaps"}, {Ports: "995", Handler: "pop3s"},
+		},
+		DefaultHandler: "banner",
+		LogDir:         ".",
+		Profile:        DefaultProfile,
+		MaxConnections: 100,
+		QueueSize:      100,
+		PortLimit:      25,
+		DrainTimeout:   "10s",
+		Anomaly:        AnomalyConfig{Sensitivity: 4},
+		PortCollision:  CollisionWarn,
+	}
+}
+
+// LoadConfig reads a JSON configuration file on top of DefaultConfig,
+// applying the environment its environment key selects.
+// Unknown keys are rejected so typos don't go unnoticed.
+func LoadConfig(path string) (*Config, error) {
+	return LoadConfigEnvironment(path, "")
+}
+
+// LoadConfigEnvironment reads a JSON configuration file like LoadConfig and
+// applies the settings of one of its environments, the one its environment
+// key selects if environment is empty. Environments hold settings in the
+// format of the file itself, so one file can describe lab and production
+// sensors that differ in outputs, alerting or retention. Their objects are
+// merged into the file's key by key and their lists replace the file's.
+// Every environment is checked for unknown keys, not just the selected one.
+func LoadConfigEnvironment(path, environment string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := DefaultConfig()
+	cfg.Path = path
+	if err := decodeConfig(content, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for _, name := range cfg.EnvironmentNames() {
+		var scratch Config
+		if err := decodeConfig(cfg.Environments[name], &scratch); err != nil {
+			return nil, fmt.Errorf("parsing %s: environments.%s: %w", path, name, err)
+		}
+		if scratch.Environment != "" || scratch.Environments != nil {
+			return nil, fmt.Errorf("parsing %s: environments.%s: environments cannot select or define environments", path, name)
+		}
+	}
+	if environment == "" {
+		environment = cfg.Environment
+	}
+	if environment == "" {
+		return cfg, nil
+	}
+	settings, ok := cfg.Environments[environment]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown environment %q (available: %s)", path, environment, strings.Join(cfg.EnvironmentNames(), ", "))
+	}
+	if err := decodeConfig(settings, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: environments.%s: %w", path, environment, err)
+	}
+	cfg.Environment = environment
+	return cfg, nil
+}
+
+// EnvironmentNames returns the names of the environments of c in sorted order.
+func (c *Config) EnvironmentNames() []string {
+	names := make([]string, 0, len(c.Environments))
+	for name := range c.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set sets the setting of the configuration file at key, the keys of the
+// objects leading to it separated by dots, e.g. splunk.token, to value:
+// the value itself for string settings, JSON for the others, such as
+// remotes or hooks, which replace the list of the file. Objects are merged
+// key by key like environments.
+func (c *Config) Set(key, value string) error {
+	quoted, _ := json.Marshal(value)
+	var err error
+	for _, raw := range [][]byte{quoted, []byte(value)} {
+		doc := raw
+		parts := strings.Split(key, ".")
+		for i := len(parts) - 1; i >= 0; i-- {
+			name, _ := json.Marshal(parts[i])
+			doc = append(append(append(append([]byte("{"), name...), ':'), doc...), '}')
+		}
+		var typeErr *json.UnmarshalTypeError
+		if err = decodeConfig(doc, c); !errors.As(err, &typeErr) {
+			break // a string setting, or not a type mismatch to retry as JSON
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
+
+func decodeConfig(content []byte, cfg *Config) error {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(cfg)
+}
+
+// OutputNames returns the names the outputs of c go by in Filters: "console",
+// "log_file", "webhook", "storage", "hook:" followed by each hook name and
+// "remote:" followed by each remote output name.
+func (c *Config) OutputNames() []string {
+	names := []string{"console", "log_file", "webhook", "storage"}
+	for _, hc := range c.Hooks {
+		names = append(names, "hook:"+hookName(hc))
+	}
+	for _, rc := range c.Remotes {
+		names = append(names, "remote:"+remoteName(rc))
+	}
+	if c.FailLog.Path != "" {
+		names = append(names, "fail_log")
+	}
+	if c.DShield.UserID != "" {
+		names = append(names, "dshield")
+	}
+	if c.CrowdSec.URL != "" {
+		names = append(names, "crowdsec")
+	}
+	if c.EventLog.Source != "" {
+		names = append(names, "event_log")
+	}
+	if c.Journal.Enabled {
+		names = append(names, "journal")
+	}
+	if c.OTel.Endpoint != "" {
+		names = append(names, "otel")
+	}
+	if c.StatsD.Addr != "" {
+		names = append(names, "statsd")
+	}
+	if c.GELF.Addr != "" {
+		names = append(names, "gelf")
+	}
+	if c.Splunk.URL != "" {
+		names = append(names, "splunk")
+	}
+	return names
+}
+
+// SensorLabels returns the labels identifying the sensor in every event:
+// the tags, and node_id and region, which win over tags of the same name.
+func (c *Config) SensorLabels() map[string]string {
+	labels := make(map[string]string, len(c.Tags)+2)
+	for key, value := range c.Tags {
+		labels[key] = value
+	}
+	if c.NodeID != "" {
+		labels["node_id"] = c.NodeID
+	}
+	if c.Region != "" {
+		labels["region"] = c.Region
+	}
+	return labels
+}
+
+// SessionLimits returns the global limits: DefaultSessionLimits with the
+// settings of c.Limits applied.
+func (c *Config) SessionLimits() (SessionLimits, error) {
+	limits, err := c.Limits.Apply(DefaultSessionLimits())
+	if err != nil {
+		return limits, fmt.Errorf("limits: %w", err)
+	}
+	return limits, nil
+}
+
+// PortOptions expands the port groups into the settings of each port.
+// When a port appears in several groups the handler and connection limit of
+// the last group naming one win, labels and session limits are merged and
+// proxying is enabled if any group asks for it.
+func (c *Config) PortOptions() (map[string]*PortOptions, error) {
+	global, err := c.SessionLimits()
+	if err != nil {
+		return nil, err
+	}
+	groups := c.Ports
+	if c.Kubernetes.Enabled {
+		groups = append(groups[:len(groups):len(groups)], c.Kubernetes.portGroups()...)
+	}
+	return expandPortGroups(groups, global)
+}
+
+// expandPortGroups is PortOptions for the port groups of any host, with
+// global as the session limits of groups that set none.
+func expandPortGroups(groups []PortConfig, global SessionLimits) (map[string]*PortOptions, error) {
+	options := make(map[string]*PortOptions)
+	for _, group := range groups {
+		ports, invalid := ParsePortSpec(group.Ports)
+		if len(invalid) > 0 {
+			return nil, fmt.Errorf("invalid port specification in %q: %s", group.Ports, strings.Join(invalid, ", "))
+		}
+		if group.ServicePort != 0 && (len(ports) != 1 || group.ServicePort < 1 || group.ServicePort > 65535) {
+			return nil, fmt.Errorf("service_port of ports %q needs a single port and must be between 1 and 65535", group.Ports)
+		}
+		for _, port := range ports {
+			opts, ok := options[port]
+			if !ok {
+				opts = &PortOptions{Labels: make(map[string]string)}
+				options[port] = opts
+			}
+			if group.Handler != "" {
+				opts.Handler = group.Handler
+			}
+			opts.Proxy = opts.Proxy || group.Proxy
+			if group.ServicePort != 0 {
+				opts.ServicePort = strconv.Itoa(group.ServicePort)
+			}
+			for key, value := range group.Labels {
+				opts.Labels[key] = value
+			}
+			if group.MaxConnections > 0 {
+				opts.MaxConnections = group.MaxConnections
+			}
+			if group.Priority != 0 {
+				opts.Priority = group.Priority
+			}
+			if group.Limits != nil {
+				base := global
+				if opts.Limits != nil {
+					base = *opts.Limits
+				}
+				limits, err := group.Limits.Apply(base)
+				if err != nil {
+					return nil, fmt.Errorf("limits of ports %q: %w", group.Ports, err)
+				}
+				opts.Limits = &limits
+			}
+		}
+	}
+	return options, nil
+}
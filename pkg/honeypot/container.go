@@ -0,0 +1,123 @@
+package honeypot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// ContainerInfo describes the container GoPot runs in.
+type ContainerInfo struct {
+	Runtime     string     // "docker", "podman", "kubernetes" or "containerd", empty outside containers
+	HostNetwork bool       // the container shares the network namespace of the host
+	Gateway     netip.Addr // default gateway of the container, the bridge of its network
+}
+
+// hostInterfacePrefixes name the interfaces only the network namespace of a
+// container host has: container bridges, veth peers and CNI overlays.
+var hostInterfacePrefixes = []string{"docker", "br-", "veth", "podman", "cni", "cbr", "flannel", "cali", "cilium", "weave", "kube-"}
+
+// DetectContainer finds out whether GoPot runs in a container, and if so
+// whether it has its own network. Outside Linux it reports no container.
+func DetectContainer() ContainerInfo {
+	var info ContainerInfo
+	switch {
+	case os.Getenv("KUBERNETES_SERVICE_HOST") != "":
+		info.Runtime = "kubernetes"
+	case fileExists("/.dockerenv"):
+		info.Runtime = "docker"
+	case fileExists("/run/.containerenv"):
+		info.Runtime = "podman"
+	default:
+		cgroup, _ := os.ReadFile("/proc/1/cgroup")
+		switch s := string(cgroup); {
+		case strings.Contains(s, "kubepods"):
+			info.Runtime = "kubernetes"
+		case strings.Contains(s, "docker"):
+			info.Runtime = "docker"
+		case strings.Contains(s, "libpod"):
+			info.Runtime = "podman"
+		case strings.Contains(s, "containerd"):
+			info.Runtime = "containerd"
+		}
+	}
+	if info.Runtime == "" {
+		return info
+	}
+	if ifaces, err := net.Interfaces(); err == nil {
+		for _, iface := range ifaces {
+			for _, prefix := range hostInterfacePrefixes {
+				if strings.HasPrefix(iface.Name, prefix) {
+					info.HostNetwork = true
+				}
+			}
+		}
+	}
+	info.Gateway = defaultGateway()
+	return info
+}
+
+// String describes the container for log messages.
+func (info ContainerInfo) String() string {
+	if info.HostNetwork {
+		return fmt.Sprintf("%s container with host networking", info.Runtime)
+	}
+	if info.Gateway.IsValid() {
+		return fmt.Sprintf("%s container behind gateway %s", info.Runtime, info.Gateway)
+	}
+	return info.Runtime + " container"
+}
+
+// defaultGateway reads the IPv4 default gateway from the routing table of
+// Linux.
+func defaultGateway() netip.Addr {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return netip.Addr{}
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags ..., in hexadecimal and host byte order
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		var ip [4]byte
+		binary.BigEndian.PutUint32(ip[:], binary.LittleEndian.Uint32(raw))
+		if gw := netip.AddrFrom4(ip); !gw.IsUnspecified() {
+			return gw
+		}
+	}
+	return netip.Addr{}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// checkContainerProxy warns, once, when a client of a port without PROXY
+// headers connects from the gateway of the container: the port is published
+// through Docker's userland proxy, which connects on behalf of every client,
+// so they all share the gateway's address.
+func (s *Server) checkContainerProxy(remote net.Addr) {
+	if s.Container == nil || s.Container.HostNetwork || !s.Container.Gateway.IsValid() || s.proxyWarned.Load() {
+		return
+	}
+	addr, err := netip.ParseAddr(srcIP(remote.String()))
+	if err != nil || addr.Unmap() != s.Container.Gateway || !s.proxyWarned.CompareAndSwap(false, true) {
+		return
+	}
+	s.logf(EventError, "Client connected from %s, the gateway of the container: a userland proxy hides the addresses of clients. "+
+		"Run the container with host networking, disable Docker's userland-proxy, or put a frontend sending PROXY headers in front and enable ingress mode.", addr)
+}
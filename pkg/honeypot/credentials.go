@@ -0,0 +1,105 @@
+package honeypot
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// Credential is a username/password pair recovered from client data.
+type Credential struct {
+	Username string
+	Password string
+}
+
+// ExtractCredentials looks for login attempts in the plaintext protocols
+// most commonly seen on the default ports: FTP/POP3 USER and PASS commands,
+// IMAP LOGIN and HTTP Basic authorization headers.
+func ExtractCredentials(data string) []Credential {
+	var creds []Credential
+	var pending *Credential // USER seen, waiting for PASS
+
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "USER":
+			if len(fields) >= 2 {
+				if pending != nil {
+					creds = append(creds, *pending)
+				}
+				pending = &Credential{Username: fields[1]}
+			}
+			continue
+		case "PASS":
+			password := strings.TrimSpace(line[len(fields[0]):])
+			if pending != nil {
+				pending.Password = password
+				creds = append(creds, *pending)
+				pending = nil
+			} else {
+				creds = append(creds, Credential{Password: password})
+			}
+			continue
+		case "AUTHORIZATION:":
+			if len(fields) == 3 && strings.EqualFold(fields[1], "Basic") {
+				if decoded, err := base64.StdEncoding.DecodeString(fields[2]); err == nil {
+					user, pass, _ := strings.Cut(string(decoded), ":")
+					creds = append(creds, Credential{Username: user, Password: pass})
+				}
+			}
+			continue
+		}
+
+		// IMAP: "<tag> LOGIN <user> <password>"
+		if len(fields) >= 4 && strings.EqualFold(fields[1], "LOGIN") {
+			creds = append(creds, Credential{
+				Username: strings.Trim(fields[2], `"`),
+				Password: strings.Trim(fields[3], `"`),
+			})
+		}
+	}
+	if pending != nil {
+		creds = append(creds, *pending)
+	}
+	return creds
+}
+
+// LogCredentials logs the login attempts found in data. A username sent
+// without a password is held back until the next chunk, so protocols that
+// send USER and PASS in separate packets are still logged as one attempt.
+func (m *ConnMeta) LogCredentials(data string) {
+	for _, cred := range ExtractCredentials(data) {
+		if cred.Password == "" {
+			m.FlushCredentials()
+			m.pendingUser = cred.Username
+			continue
+		}
+		if cred.Username == "" {
+			cred.Username = m.pendingUser
+		}
+		m.pendingUser = ""
+		m.logCredential(cred)
+	}
+}
+
+// LogCredential logs a login attempt that a handler parsed itself, such as
+// an SNMP community, and marks the session as brute-forcing.
+func (m *ConnMeta) LogCredential(cred Credential) {
+	m.FlushCredentials()
+	if m.Stage < StageBruteForce {
+		m.Stage = StageBruteForce
+	}
+	m.logCredential(cred)
+}
+
+// FlushCredentials logs a username that is still waiting for its password.
+func (m *ConnMeta) FlushCredentials() {
+	if m.pendingUser != "" {
+		m.logCredential(Credential{Username: m.pendingUser})
+		m.pendingUser = ""
+	}
+}
@@ -0,0 +1,316 @@
+package honeypot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CrowdSecConfig pushes the sessions of clients to the local API (LAPI) of
+// a CrowdSec agent, see CrowdSecOutput.
+type CrowdSecConfig struct {
+	URL       string            `json:"url"`                 // LAPI, e.g. http://127.0.0.1:8080, empty to disable
+	MachineID string            `json:"machine_id"`          // machine registered with "cscli machines add"
+	Password  string            `json:"password"`            // its password
+	Actions   []string          `json:"actions,omitempty"`   // fail log actions that make a session worth reporting, default auth_failure and alert, see FailLogActions
+	Scenarios map[string]string `json:"scenarios,omitempty"` // scenario of the sessions of each handler, default gopot/ followed by the handler name
+	Ban       string            `json:"ban,omitempty"`       // duration of the ban decision of each alert, default 4h, 0 for alerts without decisions
+	Batch     BatchConfig       `json:"batch"`               // default 100 sessions, at least every 10s
+}
+
+// crowdsecSession counts the actions of a session until it ends.
+type crowdsecSession struct {
+	actions map[string]int
+	first   time.Time
+}
+
+// CrowdSecOutput is an Output making GoPot a CrowdSec machine: every session
+// in which the client performs one of the configured actions is pushed to
+// the LAPI as an alert of the scenario of its handler, such as
+// gopot/telnet, with a ban decision the bouncers of the agent enforce. The
+// alerts reach the CrowdSec console and the community like those of the
+// agent's own scenarios, depending on its settings. Sessions are reported
+// when they end, so the output must receive session_end events. UDP
+// sessions are never reported, see spoofable.
+type CrowdSecOutput struct {
+	*Batcher
+	url       string
+	machineID string
+	password  string
+	actions   []string
+	scenarios map[string]string
+	ban       time.Duration
+	log       *log.Logger
+	client    *http.Client
+
+	mu       sync.Mutex
+	sessions map[string]*crowdsecSession
+
+	tokenMu sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewCrowdSecOutput validates cfg and starts pushing. Failed pushes are
+// reported to logger.
+func NewCrowdSecOutput(cfg CrowdSecConfig, logger *log.Logger) (*CrowdSecOutput, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("crowdsec: %q is not an http or https URL", cfg.URL)
+	}
+	if cfg.MachineID == "" || cfg.Password == "" {
+		return nil, errors.New("crowdsec: machine_id and password are required")
+	}
+	o := &CrowdSecOutput{
+		url:       strings.TrimSuffix(cfg.URL, "/"),
+		machineID: cfg.MachineID,
+		password:  cfg.Password,
+		actions:   cfg.Actions,
+		scenarios: cfg.Scenarios,
+		ban:       4 * time.Hour,
+		log:       logger,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		sessions:  make(map[string]*crowdsecSession),
+	}
+	if len(o.actions) == 0 {
+		o.actions = []string{FailAuthFailure, FailAlert}
+	}
+	for _, action := range o.actions {
+		if !slices.Contains(FailLogActions, action) {
+			return nil, fmt.Errorf("crowdsec: unknown action %q, want one of %s", action, strings.Join(FailLogActions, ", "))
+		}
+	}
+	for handler, scenario := range o.scenarios {
+		if scenario == "" {
+			return nil, fmt.Errorf("crowdsec: empty scenario for handler %s", handler)
+		}
+	}
+	if cfg.Ban != "" {
+		if o.ban, err = time.ParseDuration(cfg.Ban); err != nil || o.ban < 0 {
+			return nil, fmt.Errorf("crowdsec: invalid ban duration %q", cfg.Ban)
+		}
+	}
+	batch := cfg.Batch
+	if batch.Interval == "" {
+		batch.Interval = "10s"
+	}
+	batcher, err := NewBatcher("crowdsec", batch, o.encode, o.push)
+	if err != nil {
+		return nil, err
+	}
+	batcher.Log = logger
+	o.Batcher = batcher
+	return o, nil
+}
+
+// Write implements Output, counting the actions of each session and queueing
+// the sessions with any once they end.
+func (o *CrowdSecOutput) Write(ev Event) error {
+	if ev.Session == "" || spoofable(ev) {
+		return nil
+	}
+	o.mu.Lock()
+	if action := failAction(ev); action != "" && slices.Contains(o.actions, action) {
+		sess, ok := o.sessions[ev.Session]
+		if !ok {
+			sess = &crowdsecSession{actions: make(map[string]int), first: ev.Time}
+			o.sessions[ev.Session] = sess
+		}
+		sess.actions[action]++
+	}
+	sess, ended := o.sessions[ev.Session]
+	if ev.Type != EventSessionEnd || !ended {
+		o.mu.Unlock()
+		return nil
+	}
+	delete(o.sessions, ev.Session)
+	o.mu.Unlock()
+
+	fields := make(map[string]any, len(ev.Fields)+2)
+	for key, value := range ev.Fields {
+		fields[key] = value
+	}
+	fields["crowdsec_actions"] = sess.actions
+	fields["crowdsec_start"] = sess.first
+	ev.Fields = fields
+	return o.Batcher.Write(ev)
+}
+
+// Scenario returns the scenario the sessions of handler are reported as.
+func (o *CrowdSecOutput) Scenario(handler string) string {
+	if scenario, ok := o.scenarios[handler]; ok {
+		return scenario
+	}
+	return "gopot/" + handler
+}
+
+// crowdsecAlert is an alert of the LAPI, with the fields it requires.
+type crowdsecAlert struct {
+	Scenario        string             `json:"scenario"`
+	ScenarioHash    string             `json:"scenario_hash"`
+	ScenarioVersion string             `json:"scenario_version"`
+	Message         string             `json:"message"`
+	EventsCount     int                `json:"events_count"`
+	StartAt         string             `json:"start_at"`
+	StopAt          string             `json:"stop_at"`
+	Capacity        int                `json:"capacity"`
+	Leakspeed       string             `json:"leakspeed"`
+	Simulated       bool               `json:"simulated"`
+	Remediation     bool               `json:"remediation"`
+	Events          []crowdsecEvent    `json:"events"`
+	Source          crowdsecSource     `json:"source"`
+	Decisions       []crowdsecDecision `json:"decisions,omitempty"`
+}
+
+type crowdsecEvent struct {
+	Timestamp string         `json:"timestamp"`
+	Meta      []crowdsecMeta `json:"meta"`
+}
+
+type crowdsecMeta struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type crowdsecSource struct {
+	Scope string `json:"scope"`
+	Value string `json:"value"`
+	IP    string `json:"ip"`
+}
+
+type crowdsecDecision struct {
+	Duration string `json:"duration"`
+	Origin   string `json:"origin"`
+	Scenario string `json:"scenario"`
+	Scope    string `json:"scope"`
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+}
+
+// encode turns the ended sessions of a batch into alerts.
+func (o *CrowdSecOutput) encode(events []Event) ([]byte, error) {
+	alerts := make([]crowdsecAlert, 0, len(events))
+	for _, ev := range events {
+		actions, _ := ev.Fields["crowdsec_actions"].(map[string]int)
+		start, _ := ev.Fields["crowdsec_start"].(time.Time)
+		handler, _ := ev.Fields["handler"].(string)
+		ip := srcIP(ev.SrcAddr)
+		scenario := o.Scenario(handler)
+
+		names := make([]string, 0, len(actions))
+		count := 0
+		for action, n := range actions {
+			names = append(names, action)
+			count += n
+		}
+		sort.Strings(names)
+		summary := make([]string, len(names))
+		for i, action := range names {
+			summary[i] = fmt.Sprintf("%d %s", actions[action], action)
+		}
+		meta := []crowdsecMeta{
+			{"source_ip", ip},
+			{"service", handler},
+			{"target_port", ev.Port},
+			{"session", ev.Session},
+			{"actions", strings.Join(summary, ", ")},
+		}
+		if stage, _ := ev.Fields["stage"].(string); stage != "" {
+			meta = append(meta, crowdsecMeta{"stage", stage})
+		}
+		alert := crowdsecAlert{
+			Scenario:    scenario,
+			Message:     fmt.Sprintf("%s performed %s on port %s (%s)", ip, strings.Join(summary, ", "), ev.Port, handler),
+			EventsCount: count,
+			StartAt:     start.UTC().Format(time.RFC3339),
+			StopAt:      ev.Time.UTC().Format(time.RFC3339),
+			Leakspeed:   "0s",
+			Events:      []crowdsecEvent{{Timestamp: ev.Time.UTC().Format(time.RFC3339), Meta: meta}},
+			Source:      crowdsecSource{Scope: "Ip", Value: ip, IP: ip},
+		}
+		if o.ban > 0 {
+			alert.Remediation = true
+			alert.Decisions = []crowdsecDecision{{Duration: o.ban.String(), Origin: "gopot", Scenario: scenario, Scope: "Ip", Type: "ban", Value: ip}}
+		}
+		alerts = append(alerts, alert)
+	}
+	return json.Marshal(alerts)
+}
+
+// login returns a token of the LAPI, logging in again when the last one
+// is about to expire or renew is set.
+func (o *CrowdSecOutput) login(renew bool) (string, error) {
+	o.tokenMu.Lock()
+	defer o.tokenMu.Unlock()
+	if !renew && o.token != "" && time.Until(o.expires) > time.Minute {
+		return o.token, nil
+	}
+	var scenarios []string
+	for _, name := range HandlerNames() {
+		scenarios = append(scenarios, o.Scenario(name))
+	}
+	body, err := json.Marshal(map[string]any{"machine_id": o.machineID, "password": o.password, "scenarios": scenarios})
+	if err != nil {
+		return "", err
+	}
+	resp, err := o.client.Post(o.url+"/v1/watchers/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("login to %s as %s returned %s", o.url, o.machineID, resp.Status)
+	}
+	var login struct {
+		Token  string    `json:"token"`
+		Expire time.Time `json:"expire"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&login); err != nil || login.Token == "" {
+		return "", fmt.Errorf("login to %s returned no token", o.url)
+	}
+	o.token, o.expires = login.Token, login.Expire
+	return o.token, nil
+}
+
+// push posts a batch of alerts, logging in again once if the token was
+// refused.
+func (o *CrowdSecOutput) push(body []byte, encoding string) error {
+	for renew := false; ; renew = true {
+		token, err := o.login(renew)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPost, o.url+"/v1/alerts", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := o.client.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized && !renew {
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("%s returned %s", o.url, resp.Status)
+		}
+		return nil
+	}
+}
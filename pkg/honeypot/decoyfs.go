@@ -0,0 +1,209 @@
+package honeypot
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DecoyFS is a read-only, in-memory file system of plausible looking files.
+// It implements fs.FS so every persona (FTP, HTTP, shell...) browses the same tree.
+type DecoyFS struct {
+	files       map[string]*decoyFile // keyed by slash-separated path without leading '/'
+	honeytokens []string              // bait values planted in the generated files
+}
+
+// decoyFile is one file or directory of a DecoyFS.
+type decoyFile struct {
+	name    string
+	content []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// GenerateDecoyFS builds a decoy tree for host. The same seed always yields
+// the same tree, so the host looks identical across connections and restarts.
+func GenerateDecoyFS(hostname, user string, windows bool, seed int64) *DecoyFS {
+	rng := rand.New(rand.NewSource(seed))
+	dfs := &DecoyFS{files: make(map[string]*decoyFile)}
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	age := func() time.Time { return base.Add(time.Duration(rng.Intn(500*24)) * time.Hour) }
+
+	awsKey := "AKIA" + randomString(rng, 16, "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567")
+	awsSecret := randomString(rng, 40, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/")
+	dbPassword := randomString(rng, 14, "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789!@#")
+	dfs.honeytokens = []string{awsKey, awsSecret, dbPassword}
+	awsCredentials := fmt.Sprintf("[default]\naws_access_key_id = %s\naws_secret_access_key = %s\nregion = us-east-1\n", awsKey, awsSecret)
+	indexPage := fmt.Sprintf("<html><head><title>%s</title></head><body><h1>It works!</h1></body></html>\n", hostname)
+
+	if windows {
+		home := "Users/" + user
+		dfs.add("Windows/System32/drivers/etc/hosts", age(), "# Copyright (c) 1993-2009 Microsoft Corp.\n127.0.0.1       localhost\n")
+		dfs.add(home+"/.aws/credentials", age(), awsCredentials)
+		dfs.add("inetpub/wwwroot/index.html", age(), indexPage)
+		dfs.add("inetpub/wwwroot/web.config", age(), fmt.Sprintf("<configuration>\n  <connectionStrings>\n    <add name=\"Intranet\" connectionString=\"Server=localhost;Database=intranet;User Id=webapp;Password=%s;\" />\n  </connectionStrings>\n</configuration>\n", dbPassword))
+		dfs.add(home+"/Documents/network-inventory.txt", age(), decoyInventory(rng, hostname))
+		dfs.add(home+"/Documents/meeting-notes-q3.txt", age(), decoyMeetingNotes)
+		dfs.add("Backups/intranet-db.sql", age(), decoyDump(rng, dbPassword))
+		return dfs
+	}
+
+	home := "home/" + user
+	dfs.add("etc/hostname", age(), hostname+"\n")
+	dfs.add("etc/passwd", age(), fmt.Sprintf("root:x:0:0:root:/root:/bin/bash\n"+
+		"daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin\n"+
+		"www-data:x:33:33:www-data:/var/www:/usr/sbin/nologin\n"+
+		"mysql:x:112:117:MySQL Server,,,:/nonexistent:/bin/false\n"+
+		"%s:x:1000:1000:%s,,,:/%s:/bin/bash\n", user, user, home))
+	dfs.add("root/.aws/credentials", age(), awsCredentials)
+	dfs.add("var/www/html/index.html", age(), indexPage)
+	dfs.add("var/www/html/config.php", age(), fmt.Sprintf("<?php\ndefine('DB_HOST', 'localhost');\ndefine('DB_NAME', 'intranet');\ndefine('DB_USER', 'webapp');\ndefine('DB_PASSWORD', '%s');\n", dbPassword))
+	dfs.add(home+"/.bash_history", age(), "ls -la\ncd /var/www/html\nmysql -u webapp -p intranet\nsudo systemctl restart apache2\n")
+	dfs.add(home+"/Documents/network-inventory.txt", age(), decoyInventory(rng, hostname))
+	dfs.add(home+"/Documents/meeting-notes-q3.txt", age(), decoyMeetingNotes)
+	dfs.add("var/backups/intranet-db.sql", age(), decoyDump(rng, dbPassword))
+	return dfs
+}
+
+// decoyMeetingNotes hints at weak password hygiene to keep intruders curious.
+const decoyMeetingNotes = "Q3 planning\n- migrate backups to new NAS\n- rotate service account passwords (overdue)\n- VPN renewal\n"
+
+// add stores a regular file, creating its parent directories.
+func (dfs *DecoyFS) add(name string, modTime time.Time, content string) {
+	dfs.files[name] = &decoyFile{name: path.Base(name), content: []byte(content), mode: 0644, modTime: modTime}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if _, ok := dfs.files[dir]; !ok {
+			dfs.files[dir] = &decoyFile{name: path.Base(dir), mode: fs.ModeDir | 0755, modTime: modTime}
+		}
+	}
+}
+
+// Open implements fs.FS.
+func (dfs *DecoyFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &openDecoyFile{decoyFile: &decoyFile{name: ".", mode: fs.ModeDir | 0755}, fsys: dfs, path: name}, nil
+	}
+	file, ok := dfs.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &openDecoyFile{decoyFile: file, fsys: dfs, path: name}, nil
+}
+
+// readDir lists the direct children of dir in name order.
+func (dfs *DecoyFS) readDir(dir string) []fs.DirEntry {
+	prefix := dir + "/"
+	if dir == "." {
+		prefix = ""
+	}
+	var entries []fs.DirEntry
+	for name, file := range dfs.files {
+		if strings.HasPrefix(name, prefix) && !strings.Contains(name[len(prefix):], "/") {
+			entries = append(entries, fs.FileInfoToDirEntry(file))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries
+}
+
+// decoyFile implements fs.FileInfo.
+func (f *decoyFile) Name() string       { return f.name }
+func (f *decoyFile) Size() int64        { return int64(len(f.content)) }
+func (f *decoyFile) Mode() fs.FileMode  { return f.mode }
+func (f *decoyFile) ModTime() time.Time { return f.modTime }
+func (f *decoyFile) IsDir() bool        { return f.mode.IsDir() }
+func (f *decoyFile) Sys() any           { return nil }
+
+// openDecoyFile is an fs.File handle onto a decoyFile.
+type openDecoyFile struct {
+	*decoyFile
+	fsys    *DecoyFS
+	path    string
+	offset  int
+	entries []fs.DirEntry // directory entries not yet returned by ReadDir
+	listed  bool
+}
+
+func (f *openDecoyFile) Stat() (fs.FileInfo, error) { return f.decoyFile, nil }
+
+func (f *openDecoyFile) Close() error { return nil }
+
+func (f *openDecoyFile) Read(b []byte) (int, error) {
+	if f.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.path, Err: fs.ErrInvalid}
+	}
+	if f.offset >= len(f.content) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.content[f.offset:])
+	f.offset += n
+	return n, nil
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (f *openDecoyFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: f.path, Err: fs.ErrInvalid}
+	}
+	if !f.listed {
+		f.entries = f.fsys.readDir(f.path)
+		f.listed = true
+	}
+	if n <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(f.entries))
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+// decoyInventory produces a small internal host inventory with believable addressing.
+func decoyInventory(rng *rand.Rand, hostname string) string {
+	var b strings.Builder
+	subnet := fmt.Sprintf("10.%d.%d", rng.Intn(250)+1, rng.Intn(250)+1)
+	fmt.Fprintf(&b, "HOST\tIP\tROLE\n%s\t%s.%d\tweb/intranet\n", hostname, subnet, rng.Intn(50)+10)
+	for _, role := range []string{"dc01", "fileserver", "backup-nas", "vpn-gw", "sql01"} {
+		fmt.Fprintf(&b, "%s\t%s.%d\t%s\n", role, subnet, rng.Intn(190)+60, role)
+	}
+	return b.String()
+}
+
+// decoyDump produces a fake SQL dump of an intranet user table.
+func decoyDump(rng *rand.Rand, dbPassword string) string {
+	var b strings.Builder
+	b.WriteString("-- MySQL dump 10.13  Distrib 8.0.35\n-- Host: localhost    Database: intranet\n\n")
+	b.WriteString("CREATE TABLE `users` (\n  `id` int NOT NULL AUTO_INCREMENT,\n  `email` varchar(255) NOT NULL,\n  `password_hash` char(60) NOT NULL,\n  PRIMARY KEY (`id`)\n);\n\n")
+	b.WriteString("INSERT INTO `users` VALUES ")
+	names := []string{"j.miller", "s.khan", "a.rossi", "m.nguyen", "p.schmidt", "admin"}
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, "(%d,'%s@intranet.local','$2y$10$%s')", i+1, name, randomString(rng, 53, "./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"))
+	}
+	fmt.Fprintf(&b, ";\n-- replication user: repl / %s\n", dbPassword)
+	return b.String()
+}
+
+// randomString returns n characters drawn from alphabet.
+func randomString(rng *rand.Rand, n int, alphabet string) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = alphabet[rng.Intn(len(alphabet))]
+	}
+	return string(b)
+}
@@ -0,0 +1,147 @@
+package honeypot
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// DecoyHostConfig is a virtual host impersonated on an additional address,
+// with its own identity, services and labels.
+type DecoyHostConfig struct {
+	Address  string            `json:"address"`            // IPv4 or IPv6 address, unused on the LAN
+	Profile  string            `json:"profile,omitempty"`  // host identity preset, default that of the sensor
+	Hostname string            `json:"hostname,omitempty"` // overrides the host name of Profile
+	Ports    []PortConfig      `json:"ports,omitempty"`    // services of the host, default those of the sensor
+	Labels   map[string]string `json:"labels,omitempty"`   // echoed into every event of the host, under the labels of its ports
+}
+
+// DecoyHost is a virtual host impersonated on an additional address, see
+// Server.Decoys.
+type DecoyHost struct {
+	Identity *HostIdentity
+	Files    *DecoyFS
+	Ports    map[string]*PortOptions // services of the host, nil for those of the server; connections to other ports are refused
+	Labels   map[string]string       // echoed into every event of the host
+}
+
+// DecoyHosts returns the virtual hosts of c.DecoyAddresses by address, with
+// the legal notices of their profiles and the seed of c.
+func (c *Config) DecoyHosts() (map[netip.Addr]*DecoyHost, error) {
+	global, err := c.SessionLimits()
+	if err != nil {
+		return nil, err
+	}
+	hosts := make(map[netip.Addr]*DecoyHost)
+	names := make(map[string]bool)
+	for i, hc := range c.DecoyAddresses.Hosts {
+		addr, err := netip.ParseAddr(hc.Address)
+		if err != nil {
+			return nil, fmt.Errorf("hosts[%d]: %w", i, err)
+		}
+		addr = addr.Unmap()
+		if !addr.IsGlobalUnicast() && !addr.IsLinkLocalUnicast() {
+			return nil, fmt.Errorf("hosts[%d]: %s is not a unicast address", i, addr)
+		}
+		if _, ok := hosts[addr]; ok {
+			return nil, fmt.Errorf("hosts[%d]: %s configured twice", i, addr)
+		}
+		profile := hc.Profile
+		if profile == "" {
+			profile = c.Profile
+		}
+		id, err := NewHostIdentity(profile, hc.Hostname)
+		if err != nil {
+			return nil, fmt.Errorf("hosts[%d]: %w", i, err)
+		}
+		if names[id.FQDN()] {
+			return nil, fmt.Errorf("hosts[%d]: host name %s is used by another host, set a hostname", i, id.FQDN())
+		}
+		names[id.FQDN()] = true
+		if id.LegalNotice, err = c.LegalNotice.Notice(profile); err != nil {
+			return nil, fmt.Errorf("hosts[%d]: %w", i, err)
+		}
+		id.Seed = c.Seed
+		host := &DecoyHost{Identity: id, Files: id.DecoyFS(), Labels: hc.Labels}
+		if len(hc.Ports) > 0 {
+			if host.Ports, err = expandPortGroups(hc.Ports, global); err != nil {
+				return nil, fmt.Errorf("hosts[%d]: %w", i, err)
+			}
+			for port, opts := range host.Ports {
+				if opts.Handler == "" {
+					opts.Handler = c.DefaultHandler
+				}
+				_, stream := LookupHandler(opts.Handler)
+				if _, packet := LookupPacketHandler(opts.Handler); packet && !stream {
+					return nil, fmt.Errorf("hosts[%d]: handler %q of port %s does not serve TCP, which is all virtual hosts are told apart on", i, opts.Handler, port)
+				}
+			}
+		}
+		hosts[addr] = host
+	}
+	return hosts, nil
+}
+
+// decoyHost returns the virtual host a connection to the local address
+// local, redirected from orig when orig is valid, reached: the one of
+// Decoys on the original destination or the local address, or nil for the
+// server's own.
+func (s *Server) decoyHost(local, orig netip.AddrPort) *DecoyHost {
+	for _, ap := range []netip.AddrPort{orig, local} {
+		if !ap.IsValid() {
+			continue
+		}
+		if host, ok := s.Decoys[ap.Addr().Unmap()]; ok {
+			return host
+		}
+	}
+	return nil
+}
+
+// hostPortOptions returns the settings of port on host, or opts, those of
+// the server, if host is nil or has the server's services. It reports false
+// when the host has no service on the port: a port missing from the host's
+// own, or listened on for other virtual hosts only.
+func (s *Server) hostPortOptions(host *DecoyHost, port string, opts *PortOptions) (*PortOptions, bool) {
+	if host != nil && host.Ports != nil {
+		opts, ok := host.Ports[port]
+		return opts, ok
+	}
+	if _, ok := s.Ports[port]; !ok {
+		for _, other := range s.Decoys {
+			if _, ok := other.Ports[port]; ok {
+				return nil, false
+			}
+		}
+	}
+	return opts, true
+}
+
+// DecoyPorts returns the ports the virtual hosts of Decoys serve that the
+// server itself does not, which must be listened on too.
+func (s *Server) DecoyPorts() []string {
+	var ports []string
+	seen := make(map[string]bool)
+	for _, host := range s.Decoys {
+		for port := range host.Ports {
+			if _, ok := s.Ports[port]; !ok && !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	return ports
+}
+
+// refuseConnection records a connection to a port the virtual host it
+// reached has no service on, and resets it like a closed port would be.
+func (s *Server) refuseConnection(conn net.Conn, meta *ConnMeta) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	meta.Emit(Event{
+		Type:    EventConnection,
+		Message: fmt.Sprintf("Refused connection on port %s from %s to %s: %s has no service on it", meta.Port, meta.ClientAddr, meta.LocalAddr, meta.Identity.FQDN()),
+		Fields:  map[string]any{"refused": true},
+	})
+}
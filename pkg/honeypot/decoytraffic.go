@@ -0,0 +1,185 @@
+package honeypot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DecoyTrafficConfig makes the sensor generate the outbound traffic of an
+// ordinary host of its profile, clock syncs and update checks, so it does
+// not look suspiciously silent to an attacker watching the network segment.
+// Only the hosts listed are contacted.
+type DecoyTrafficConfig struct {
+	Enabled  bool     `json:"enabled"`
+	NTP      []string `json:"ntp,omitempty"`      // NTP servers polled, default those of the profile
+	URLs     []string `json:"urls,omitempty"`     // http(s) update checks fetched, default those of the profile
+	Interval string   `json:"interval,omitempty"` // mean time between two requests, default 10m
+}
+
+// decoyTrafficProfile is the background traffic of a host profile.
+type decoyTrafficProfile struct {
+	ntp       []string
+	urls      []string
+	userAgent string // of the client making the update checks
+}
+
+// decoyTrafficProfiles are the background traffic of the built-in profiles:
+// systemd-timesyncd or W32Time, and apt or the certificate trust list
+// updates of CryptoAPI.
+var decoyTrafficProfiles = map[string]decoyTrafficProfile{
+	"ubuntu-web": {
+		ntp: []string{"ntp.ubuntu.com"},
+		urls: []string{
+			"http://archive.ubuntu.com/ubuntu/dists/jammy-updates/InRelease",
+			"http://security.ubuntu.com/ubuntu/dists/jammy-security/InRelease",
+		},
+		userAgent: "Debian APT-HTTP/1.3 (2.4.11)",
+	},
+	"debian-db": {
+		ntp: []string{"0.debian.pool.ntp.org", "1.debian.pool.ntp.org", "2.debian.pool.ntp.org", "3.debian.pool.ntp.org"},
+		urls: []string{
+			"http://deb.debian.org/debian/dists/bookworm-updates/InRelease",
+			"http://security.debian.org/debian-security/dists/bookworm-security/InRelease",
+		},
+		userAgent: "Debian APT-HTTP/1.3 (2.6.1)",
+	},
+	"windows-fileserver": {
+		ntp: []string{"time.windows.com"},
+		urls: []string{
+			"http://ctldl.windowsupdate.com/msdownload/update/v3/static/trustedr/en/authrootstl.cab",
+			"http://ctldl.windowsupdate.com/msdownload/update/v3/static/trustedr/en/disallowedcertstl.cab",
+		},
+		userAgent: "Microsoft-CryptoAPI/10.0",
+	},
+}
+
+// maxDecoyBytes bounds what is downloaded by one update check.
+const maxDecoyBytes = 4 << 20
+
+// decoyTraffic is the effective configuration of the generator.
+type decoyTraffic struct {
+	decoyTrafficProfile
+	interval time.Duration
+	hosts    []string // allow list of the URLs, redirects elsewhere aren't followed
+}
+
+// newDecoyTraffic validates cfg and fills in the defaults of profile.
+func newDecoyTraffic(cfg DecoyTrafficConfig, profile string) (*decoyTraffic, error) {
+	d := &decoyTraffic{decoyTrafficProfile: decoyTrafficProfiles[profile], interval: 10 * time.Minute}
+	if len(cfg.NTP) > 0 {
+		d.ntp = cfg.NTP
+	}
+	if len(cfg.URLs) > 0 {
+		d.urls = cfg.URLs
+	}
+	if d.userAgent == "" {
+		d.userAgent = "Debian APT-HTTP/1.3 (2.4.11)"
+	}
+	if cfg.Interval != "" {
+		interval, err := time.ParseDuration(cfg.Interval)
+		if err != nil || interval < time.Second {
+			return nil, fmt.Errorf("invalid interval %q, want a duration of at least 1s", cfg.Interval)
+		}
+		d.interval = interval
+	}
+	for _, rawURL := range d.urls {
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid URL %q, want an http or https URL", rawURL)
+		}
+		if !slices.Contains(d.hosts, u.Host) {
+			d.hosts = append(d.hosts, u.Host)
+		}
+	}
+	for _, server := range d.ntp {
+		if server == "" {
+			return nil, errors.New("empty NTP server")
+		}
+	}
+	if len(d.ntp) == 0 && len(d.urls) == 0 {
+		return nil, fmt.Errorf("no NTP servers or URLs for the %s profile", profile)
+	}
+	return d, nil
+}
+
+// GenerateDecoyTraffic validates cfg and, until Shutdown, polls its NTP
+// servers and fetches its update URLs in random order, at exponentially
+// distributed intervals averaging cfg.Interval, as a host's timers would.
+// Update checks send the User-Agent of the profile's package manager and
+// If-Modified-Since after the first one. Failures are reported as error
+// events; the traffic itself is not recorded.
+func (s *Server) GenerateDecoyTraffic(cfg DecoyTrafficConfig) error {
+	d, err := newDecoyTraffic(cfg, s.Identity.Profile)
+	if err != nil {
+		return fmt.Errorf("decoy traffic: %w", err)
+	}
+	client := &http.Client{
+		Timeout: time.Minute,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !slices.Contains(d.hosts, req.URL.Host) {
+				return fmt.Errorf("not following redirect to %s, which is not listed", req.URL.Host)
+			}
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+	fetched := make(map[string]time.Time)
+	rng := s.Identity.Rand("decoy_traffic")
+	go func() {
+		for {
+			wait := time.Duration(rng.ExpFloat64() * float64(d.interval))
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			if n := rng.Intn(len(d.ntp) + len(d.urls)); n < len(d.ntp) {
+				if _, err := QueryNTP(d.ntp[n]); err != nil {
+					s.logf(EventError, "Decoy NTP sync with %s failed: %s", d.ntp[n], err)
+				}
+			} else {
+				target := d.urls[n-len(d.ntp)]
+				if err := d.fetch(client, target, fetched); err != nil {
+					s.logf(EventError, "Decoy update check of %s failed: %s", target, err)
+				}
+			}
+		}
+	}()
+	s.logf(EventInfo, "Generating decoy traffic to %s about every %s", strings.Join(append(slices.Clone(d.ntp), d.hosts...), ", "), d.interval)
+	return nil
+}
+
+// fetch makes an update check of target, conditional if fetched records
+// an earlier one.
+func (d *decoyTraffic) fetch(client *http.Client, target string, fetched map[string]time.Time) error {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", d.userAgent)
+	req.Header.Set("Cache-Control", "max-age=0")
+	if last, ok := fetched[target]; ok {
+		req.Header.Set("If-Modified-Since", last.UTC().Format(http.TimeFormat))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, io.LimitReader(resp.Body, maxDecoyBytes)); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified {
+		return fmt.Errorf("HTTP status %s", resp.Status)
+	}
+	fetched[target] = time.Now()
+	return nil
+}
@@ -0,0 +1,175 @@
+package honeypot
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DShieldURL is the submission API of the SANS Internet Storm Center.
+const DShieldURL = "https://www.dshield.org/submitapi/"
+
+// DShieldConfig submits connections to DShield, see DShieldOutput.
+type DShieldConfig struct {
+	UserID string      `json:"user_id"`           // DShield account number, empty to disable
+	APIKey string      `json:"api_key"`           // API key of the account, from its "My Information" page
+	URL    string      `json:"url,omitempty"`     // submission endpoint, default DShieldURL
+	DryRun bool        `json:"dry_run,omitempty"` // log the submissions instead of sending them
+	Batch  BatchConfig `json:"batch"`             // default 1000 connections, at least every 30m
+}
+
+// dshieldEntry is a connection in the firewall log format of DShield.
+type dshieldEntry struct {
+	Time  int64  `json:"time"`
+	SIP   string `json:"sip"`
+	DIP   string `json:"dip"`
+	Proto int    `json:"proto"` // 6 for TCP, 17 for UDP
+	SPort int    `json:"sport"`
+	DPort int    `json:"dport"`
+	Flags string `json:"flags,omitempty"` // TCP flags of the first packet
+}
+
+// DShieldOutput is an Output contributing the connections of the sensor to
+// the Internet Storm Center: it submits them in batches as firewall log
+// entries, one per session, like the DShield sensor's own firewall logs.
+// Only the time, addresses, ports and protocol of connections are
+// submitted; events of other types and dropped connections are ignored.
+type DShieldOutput struct {
+	*Batcher
+	url    string
+	userID string
+	apiKey string
+	dryRun bool
+	log    *log.Logger
+	client *http.Client
+}
+
+// NewDShieldOutput validates cfg and starts submitting. Failed submissions,
+// and the submissions themselves in dry-run mode, are reported to logger.
+func NewDShieldOutput(cfg DShieldConfig, logger *log.Logger) (*DShieldOutput, error) {
+	if _, err := strconv.ParseUint(cfg.UserID, 10, 64); err != nil {
+		return nil, fmt.Errorf("dshield: user_id %q is not an account number", cfg.UserID)
+	}
+	if cfg.APIKey == "" && !cfg.DryRun {
+		return nil, errors.New("dshield: api_key is required unless dry_run is set")
+	}
+	o := &DShieldOutput{url: cfg.URL, userID: cfg.UserID, apiKey: cfg.APIKey, dryRun: cfg.DryRun, log: logger, client: &http.Client{Timeout: 30 * time.Second}}
+	if o.url == "" {
+		o.url = DShieldURL
+	}
+	if u, err := url.Parse(o.url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("dshield: %q is not an http or https URL", o.url)
+	}
+	batch := cfg.Batch
+	if batch.Size == 0 {
+		batch.Size = 1000
+	}
+	if batch.Interval == "" {
+		batch.Interval = "30m"
+	}
+	batcher, err := NewBatcher("dshield", batch, encodeDShield, o.submit)
+	if err != nil {
+		return nil, err
+	}
+	batcher.Log = logger
+	o.Batcher = batcher
+	return o, nil
+}
+
+// Write implements Output, queueing the connections to submit.
+func (o *DShieldOutput) Write(ev Event) error {
+	if _, ok := dshieldConnection(ev); !ok {
+		return nil
+	}
+	return o.Batcher.Write(ev)
+}
+
+// dshieldConnection returns the firewall log entry of a connection event,
+// and false for other events.
+func dshieldConnection(ev Event) (dshieldEntry, bool) {
+	if ev.Type != EventConnection {
+		return dshieldEntry{}, false
+	}
+	if dropped, _ := ev.Fields["dropped"].(bool); dropped {
+		return dshieldEntry{}, false
+	}
+	src, srcPort, err := net.SplitHostPort(ev.SrcAddr)
+	if err != nil {
+		return dshieldEntry{}, false
+	}
+	dst, _, err := net.SplitHostPort(ev.DstAddr)
+	if err != nil {
+		return dshieldEntry{}, false
+	}
+	entry := dshieldEntry{Time: ev.Time.Unix(), SIP: src, DIP: dst, Proto: 6, Flags: "S"}
+	if transport, _ := ev.Fields["transport"].(string); transport == "udp" {
+		entry.Proto, entry.Flags = 17, ""
+	}
+	entry.SPort, _ = strconv.Atoi(srcPort)
+	entry.DPort, _ = strconv.Atoi(ev.Port)
+	return entry, true
+}
+
+// encodeDShield encodes the connections of a batch as a firewall log submission.
+func encodeDShield(events []Event) ([]byte, error) {
+	logs := make([]dshieldEntry, 0, len(events))
+	for _, ev := range events {
+		if entry, ok := dshieldConnection(ev); ok {
+			logs = append(logs, entry)
+		}
+	}
+	return json.Marshal(map[string]any{"type": "firewall", "logs": logs})
+}
+
+// authHeader returns the X-ISC-Authorization header of a submission: an
+// HMAC of the API key keyed with a fresh nonce and the account number.
+func (o *DShieldOutput) authHeader() string {
+	raw := make([]byte, 8)
+	rand.Read(raw)
+	nonce := base64.StdEncoding.EncodeToString(raw)
+	mac := hmac.New(sha256.New, []byte(nonce+o.userID))
+	mac.Write([]byte(o.apiKey))
+	credentials := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return fmt.Sprintf("ISC-HMAC-SHA256 Credentials=%s Userid=%s Nonce=%s", credentials, o.userID, nonce)
+}
+
+func (o *DShieldOutput) submit(body []byte, encoding string) error {
+	if o.dryRun {
+		if o.log != nil {
+			o.log.Printf("DShield dry run, not submitting to %s: %s", o.url, body)
+		}
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodPost, o.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	req.Header.Set("X-ISC-Authorization", o.authHeader())
+	req.Header.Set("X-ISC-LogType", "firewall")
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", o.url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package honeypot
+
+import (
+	"maps"
+	"math"
+	"sort"
+)
+
+// Port labels grouping sessions in engagement reports. Sessions are rated
+// by persona, the handler serving them unless PersonaLabel names another,
+// and variant, so that configurations of one persona can be compared:
+// two ports serving one handler with different VariantLabel values, or
+// two dialog scripts sharing a PersonaLabel.
+const (
+	PersonaLabel = "persona"
+	VariantLabel = "variant"
+)
+
+// personaMinSessions is the number of sessions every variant of a persona
+// needs before one of them can be named the leader.
+const personaMinSessions = 10
+
+// PersonaRow is the engagement of the sessions served by one variant of a
+// persona: how long attackers stayed, how much they sent and how far they
+// went.
+type PersonaRow struct {
+	Key        string         `json:"key"` // persona, followed by "/" and the variant if there is one
+	Persona    string         `json:"persona"`
+	Variant    string         `json:"variant,omitempty"`
+	Handler    string         `json:"handler"`
+	Sessions   int            `json:"sessions"`
+	Seconds    float64        `json:"seconds"`          // total duration of the sessions
+	Bytes      int            `json:"bytes"`            // total bytes received from clients
+	Stages     map[string]int `json:"stages"`           // sessions by furthest attack stage reached
+	MeanSecs   float64        `json:"mean_seconds"`     // per session
+	MeanBytes  float64        `json:"mean_bytes"`       // per session
+	Score      float64        `json:"score"`            // mean stage reached, from 0 for recon to 3 for post-exploit
+	ScoreError float64        `json:"score_error"`      // standard error of Score
+	Leader     bool           `json:"leader,omitempty"` // Score is ahead of every other variant of the persona, see ComparePersonas
+}
+
+// newPersonaRow returns an empty row for the sessions served by handler
+// with labels.
+func newPersonaRow(handler string, labels map[string]string) *PersonaRow {
+	persona, variant := labels[PersonaLabel], labels[VariantLabel]
+	if persona == "" {
+		persona = handler
+	} else if variant == "" && persona != handler {
+		variant = handler
+	}
+	key := persona
+	if variant != "" {
+		key += "/" + variant
+	}
+	return &PersonaRow{Key: key, Persona: persona, Variant: variant, Handler: handler, Stages: make(map[string]int)}
+}
+
+// add counts a session.
+func (r *PersonaRow) add(stage string, seconds float64, bytes int) {
+	r.Sessions++
+	r.Seconds += seconds
+	r.Bytes += bytes
+	r.Stages[stage]++
+}
+
+// merge adds the sessions of other, a row of the same persona.
+func (r *PersonaRow) merge(other PersonaRow) {
+	r.Sessions += other.Sessions
+	r.Seconds += other.Seconds
+	r.Bytes += other.Bytes
+	for stage, n := range other.Stages {
+		r.Stages[stage] += n
+	}
+}
+
+// ComparePersonas merges the persona rows of reports, so that variants
+// are compared over a longer period than one report.
+func ComparePersonas(reports []AggregateReport) []PersonaRow {
+	rows := make(map[string]*PersonaRow)
+	for _, report := range reports {
+		for _, row := range report.Personas {
+			merged, ok := rows[row.Key]
+			if !ok {
+				merged = &PersonaRow{Key: row.Key, Persona: row.Persona, Variant: row.Variant, Handler: row.Handler, Stages: make(map[string]int)}
+				rows[row.Key] = merged
+			}
+			merged.merge(row)
+		}
+	}
+	return personaRows(rows)
+}
+
+// personaRows computes the means and scores of rows and returns them by
+// persona, best score first. A variant is marked as the leader of its
+// persona when every variant has had personaMinSessions sessions and its
+// score exceeds each of the others by more than twice the standard error
+// of the difference, roughly 95% confidence that it engages attackers
+// more deeply.
+func personaRows(rows map[string]*PersonaRow) []PersonaRow {
+	result := make([]PersonaRow, 0, len(rows))
+	for _, r := range rows {
+		row := *r
+		row.Stages = maps.Clone(r.Stages)
+		if row.Sessions > 0 {
+			n := float64(row.Sessions)
+			row.MeanSecs = math.Round(row.Seconds/n*1000) / 1000
+			row.MeanBytes = math.Round(float64(row.Bytes)/n*10) / 10
+			for rank, name := range stageNames {
+				row.Score += float64(rank*row.Stages[name]) / n
+			}
+			if row.Sessions > 1 {
+				var squares float64
+				for rank, name := range stageNames {
+					squares += float64(row.Stages[name]) * math.Pow(float64(rank)-row.Score, 2)
+				}
+				row.ScoreError = math.Sqrt(squares / (n - 1) / n)
+			}
+		}
+		result = append(result, row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Persona != result[j].Persona {
+			return result[i].Persona < result[j].Persona
+		}
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].Key < result[j].Key
+	})
+
+	for start := 0; start < len(result); {
+		end := start + 1
+		for end < len(result) && result[end].Persona == result[start].Persona {
+			end++
+		}
+		variants := result[start:end]
+		leader := len(variants) > 1
+		for i, other := range variants {
+			if other.Sessions < personaMinSessions {
+				leader = false
+			}
+			if i > 0 && variants[0].Score-other.Score <= 2*math.Hypot(variants[0].ScoreError, other.ScoreError) {
+				leader = false
+			}
+		}
+		variants[0].Leader = leader
+		start = end
+	}
+	for i := range result {
+		result[i].Score = math.Round(result[i].Score*1000) / 1000
+		result[i].ScoreError = math.Round(result[i].ScoreError*1000) / 1000
+	}
+	return result
+}
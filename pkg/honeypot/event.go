@@ -0,0 +1,78 @@
+package honeypot
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Event types emitted by the server and its handlers.
+const (
+	EventConnection = "connection"  // a client connected
+	EventData       = "data"        // a client sent data
+	EventCredential = "credential"  // a client attempted to log in
+	EventCanary     = "canary"      // a client logged in with a canary credential, see Server.Canaries
+	EventSessionEnd = "session_end" // a session finished
+	EventAlert      = "alert"       // something needs an analyst's attention
+	EventDDoSPrep   = "ddos_prep"   // a client probed or primed a reflection amplifier for a DDoS attack
+	EventFirewall   = "firewall"    // a client was blocked on the host firewall or its block expired, see Server.EnableFirewall
+	EventScan       = "scan"        // a client scanned ports or addresses of the sensor, see Server.EnableScanDetection
+	EventError      = "error"       // a listener or handler failed
+	EventInfo       = "info"        // anything else worth recording
+
+	EventHandlerTimeout = "handler_timeout" // a handler overran its wall-clock budget and was cut off, see SessionLimits.HandlerBudget
+	EventConfigSummary  = "config_summary"  // the server started listening; describes its ports, handlers, outputs and build, see Server.Summary
+)
+
+// EventSchemaVersion is the version of the layout of Event and of the
+// fields of each event type, described by EventSchema. Within a version
+// names and meanings never change: fields and event types may be added, but
+// renaming, retyping or removing one takes a new version.
+const EventSchemaVersion = 1
+
+// Event is a single record of honeypot activity delivered to every Output.
+// Time is the sensor's wall clock, which may be wrong; Seq and Mono order the
+// events of one BootID even if the clock jumps.
+type Event struct {
+	Schema   int               `json:"schema_version"` // EventSchemaVersion of the emitting server, 0 before versions were introduced
+	Time     time.Time         `json:"time"`
+	Seq      uint64            `json:"seq"`                       // position in the events emitted since BootID started
+	Mono     time.Duration     `json:"mono_ns"`                   // monotonic clock time since BootID started, unaffected by clock changes
+	BootID   string            `json:"boot_id"`                   // random identifier of the emitting server instance
+	Offset   time.Duration     `json:"clock_offset_ns,omitempty"` // NTP-measured correction for Time, see Server.CheckClock
+	Type     string            `json:"type"`                      // one of the Event* constants
+	Session  string            `json:"session,omitempty"`         // connection the event belongs to, see ConnMeta.Session
+	Handler  string            `json:"handler,omitempty"`         // handler serving the session, which names the protocol spoken, e.g. "telnet"
+	Severity string            `json:"severity,omitempty"`        // "low", "medium" or "high" for alerts, empty otherwise
+	Port     string            `json:"port,omitempty"`            // port the client targeted
+	SrcAddr  string            `json:"src_addr,omitempty"`        // client address
+	DstAddr  string            `json:"dst_addr,omitempty"`        // local address the client reached
+	Host     string            `json:"host,omitempty"`            // virtual host the client reached, set when the server has several, see Server.Decoys
+	Message  string            `json:"message"`                   // human readable description, see Text
+	Labels   map[string]string `json:"labels,omitempty"`          // labels of the port the event came from and of the server, see Server.Labels
+	Fields   map[string]any    `json:"fields,omitempty"`
+}
+
+// Text returns the line written to text logs: the message followed by the
+// port labels, if any, in key order.
+func (ev Event) Text() string {
+	if len(ev.Labels) == 0 {
+		return ev.Message
+	}
+	keys := make([]string, 0, len(ev.Labels))
+	for key := range ev.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(ev.Message)
+	b.WriteString(" [")
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(key + "=" + ev.Labels[key])
+	}
+	b.WriteByte(']')
+	return b.String()
+}
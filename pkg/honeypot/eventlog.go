@@ -0,0 +1,126 @@
+package honeypot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// EventLogConfig enables the Windows Event Log output.
+type EventLogConfig struct {
+	Source string `json:"source"` // event source of the Application log events are written under, e.g. GoPot; empty to disable
+}
+
+// Event IDs of the events written to the Windows Event Log, by event type.
+// Events of unknown types are written as EventInfo.
+var EventLogIDs = map[string]uint32{
+	EventConnection: 1000,
+	EventData:       1001,
+	EventCredential: 1002,
+	EventSessionEnd: 1003,
+	EventAlert:      1004,
+	EventDDoSPrep:   1005,
+	EventFirewall:   1006,
+	EventError:      1007,
+	EventInfo:       1008,
+
+	EventHandlerTimeout: 1009,
+	EventConfigSummary:  1010,
+	EventCanary:         1011,
+	EventScan:           1012,
+}
+
+// Windows event types, see ReportEventW.
+const (
+	eventLogError        = 0x0001
+	eventLogWarning      = 0x0002
+	eventLogInformation  = 0x0004
+	eventLogAuditFailure = 0x0010
+)
+
+// eventLogMaxRunes bounds the text of an event, below the 31839 characters
+// the Event Log takes in one string.
+const eventLogMaxRunes = 31000
+
+// eventLogWriter writes to the Event Log, see openEventLog.
+type eventLogWriter interface {
+	report(kind uint16, id uint32, text string) error
+	close() error
+}
+
+// EventLogOutput is an Output writing events to the Application log of the
+// Windows Event Log under a source of its own, for Windows Event Forwarding
+// and the collectors reading it. Each event has the ID of its type in
+// EventLogIDs; login attempts are failure audits, high severity alerts and
+// errors are errors, other alerts warnings and the rest information. The
+// text of an event is its log line followed by the event as JSON. Only
+// available on Windows.
+type EventLogOutput struct {
+	source string
+	log    eventLogWriter
+}
+
+// NewEventLogOutput registers the event source of cfg. The source should
+// have been created beforehand with a message file, see the README, for
+// Event Viewer to show the text of the events.
+func NewEventLogOutput(cfg EventLogConfig) (*EventLogOutput, error) {
+	if err := checkEventLogSource(cfg.Source); err != nil {
+		return nil, err
+	}
+	w, err := openEventLog(cfg.Source)
+	if err != nil {
+		return nil, err
+	}
+	return &EventLogOutput{source: cfg.Source, log: w}, nil
+}
+
+// checkEventLogSource reports whether source can name an event source.
+func checkEventLogSource(source string) error {
+	if source == "" || len(source) > 255 || strings.ContainsAny(source, `\/`) {
+		return fmt.Errorf("invalid event log source %q", source)
+	}
+	return nil
+}
+
+// eventLogKind returns the Windows event type of ev.
+func eventLogKind(ev Event) uint16 {
+	switch ev.Type {
+	case EventCredential:
+		return eventLogAuditFailure
+	case EventError, EventHandlerTimeout, EventCanary:
+		return eventLogError
+	case EventAlert:
+		if ev.Severity == "high" {
+			return eventLogError
+		}
+		return eventLogWarning
+	}
+	return eventLogInformation
+}
+
+// eventLogText returns the text of ev in the Event Log.
+func eventLogText(ev Event) string {
+	text := ev.Text()
+	if data, err := json.Marshal(ev); err == nil {
+		text += "\r\n\r\n" + string(data)
+	}
+	text = strings.ReplaceAll(text, "\x00", `\x00`)
+	if runes := []rune(text); len(runes) > eventLogMaxRunes {
+		text = string(runes[:eventLogMaxRunes]) + "..."
+	}
+	return text
+}
+
+// Write implements Output.
+func (o *EventLogOutput) Write(ev Event) error {
+	id, ok := EventLogIDs[ev.Type]
+	if !ok {
+		id = EventLogIDs[EventInfo]
+	}
+	return o.log.report(eventLogKind(ev), id, eventLogText(ev))
+}
+
+// Close deregisters the event source.
+func (o *EventLogOutput) Close() error {
+	return o.log.close()
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package honeypot
+
+import "errors"
+
+// openEventLog is only implemented on Windows.
+func openEventLog(source string) (eventLogWriter, error) {
+	return nil, errors.New("the Windows Event Log is only available on Windows")
+}
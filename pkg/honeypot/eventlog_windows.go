@@ -0,0 +1,54 @@
+//go:build windows
+
+package honeypot
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                  = syscall.NewLazyDLL("advapi32.dll")
+	procRegisterEventSource   = advapi32.NewProc("RegisterEventSourceW")
+	procDeregisterEventSource = advapi32.NewProc("DeregisterEventSource")
+	procReportEvent           = advapi32.NewProc("ReportEventW")
+)
+
+// windowsEventLog is an event source handle of the local Event Log.
+type windowsEventLog struct {
+	handle uintptr
+}
+
+// openEventLog registers source with the Event Log of the local computer.
+func openEventLog(source string) (eventLogWriter, error) {
+	name, err := syscall.UTF16PtrFromString(source)
+	if err != nil {
+		return nil, err
+	}
+	handle, _, err := procRegisterEventSource.Call(0, uintptr(unsafe.Pointer(name)))
+	if handle == 0 {
+		return nil, fmt.Errorf("registering event source %s: %w", source, err)
+	}
+	return &windowsEventLog{handle: handle}, nil
+}
+
+func (l *windowsEventLog) report(kind uint16, id uint32, text string) error {
+	s, err := syscall.UTF16PtrFromString(text)
+	if err != nil {
+		return err
+	}
+	strings := [1]*uint16{s}
+	ok, _, err := procReportEvent.Call(l.handle, uintptr(kind), 0, uintptr(id), 0, 1, 0, uintptr(unsafe.Pointer(&strings[0])), 0)
+	if ok == 0 {
+		return fmt.Errorf("reporting event: %w", err)
+	}
+	return nil
+}
+
+func (l *windowsEventLog) close() error {
+	if ok, _, err := procDeregisterEventSource.Call(l.handle); ok == 0 {
+		return err
+	}
+	return nil
+}
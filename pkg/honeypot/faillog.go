@@ -0,0 +1,146 @@
+package honeypot
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions of the fail log, see FailLogOutput.
+const (
+	FailConnect     = "connect"      // a client connected
+	FailAuthFailure = "auth_failure" // a client tried to log in
+	FailAlert       = "alert"        // a client caused an alert
+)
+
+// FailLogActions are the actions a fail log can record.
+var FailLogActions = []string{FailConnect, FailAuthFailure, FailAlert}
+
+// FailLogConfig enables the fail log.
+type FailLogConfig struct {
+	Path    string   `json:"path"`              // file lines are appended to, empty to disable
+	Actions []string `json:"actions,omitempty"` // actions recorded, default auth_failure and alert, see FailLogActions
+}
+
+// FailLogOutput appends a line per client action to a plain text file for
+// fail2ban and other log watchers to ban on, one format for every
+// protocol:
+//
+//	2026-10-15T08:41:07Z gopot action=auth_failure ip=198.51.100.7 port=22
+//
+// The time is UTC in RFC 3339 and the fields always come in this order.
+// Nothing the client chose, like usernames, is written, so lines cannot be
+// forged to ban someone else. The file is reopened when it is rotated away.
+type FailLogOutput struct {
+	path    string
+	actions []string
+
+	mu     sync.Mutex
+	file   *os.File // nil when reopening failed
+	closed bool
+}
+
+// NewFailLogOutput validates cfg and opens (or creates) its file.
+func NewFailLogOutput(cfg FailLogConfig) (*FailLogOutput, error) {
+	actions := cfg.Actions
+	if len(actions) == 0 {
+		actions = []string{FailAuthFailure, FailAlert}
+	}
+	for _, action := range actions {
+		if !slices.Contains(FailLogActions, action) {
+			return nil, fmt.Errorf("unknown fail log action %q, want one of %s", action, strings.Join(FailLogActions, ", "))
+		}
+	}
+	o := &FailLogOutput{path: cfg.Path, actions: actions}
+	if err := o.open(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *FailLogOutput) open() error {
+	file, err := os.OpenFile(o.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("unable to open fail log: %w", err)
+	}
+	o.file = file
+	return nil
+}
+
+// failAction returns the fail log action of ev, empty if it has none.
+func failAction(ev Event) string {
+	switch ev.Type {
+	case EventConnection:
+		if dropped, _ := ev.Fields["dropped"].(bool); !dropped {
+			return FailConnect
+		}
+	case EventCredential:
+		return FailAuthFailure
+	case EventAlert:
+		return FailAlert
+	}
+	return ""
+}
+
+// spoofable reports whether ev comes from a UDP client, whose source
+// address anyone can forge: a few datagrams sent in the name of the
+// operator, the gateway or the resolver would get them banned if the events
+// of UDP clients counted towards bans.
+func spoofable(ev Event) bool {
+	return ev.Fields["transport"] == "udp"
+}
+
+// Write implements Output. The actions of UDP clients are left out, see
+// spoofable.
+func (o *FailLogOutput) Write(ev Event) error {
+	action := failAction(ev)
+	if action == "" || !slices.Contains(o.actions, action) || spoofable(ev) {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(ev.SrcAddr)
+	if err != nil {
+		return nil // alerts about the sensor itself
+	}
+	line := fmt.Sprintf("%s gopot action=%s ip=%s port=%s\n", ev.Time.UTC().Format(time.RFC3339), action, host, ev.Port)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return nil
+	}
+	// Follow logrotate and purges, which move the file away
+	if current, err := os.Stat(o.path); o.file == nil || err != nil || !sameFile(o.file, current) {
+		if o.file != nil {
+			o.file.Close()
+			o.file = nil
+		}
+		if err := o.open(); err != nil {
+			return err
+		}
+	}
+	_, err = o.file.WriteString(line)
+	return err
+}
+
+// sameFile reports whether info describes the open file f.
+func sameFile(f *os.File, info os.FileInfo) bool {
+	open, err := f.Stat()
+	return err == nil && os.SameFile(open, info)
+}
+
+// Close closes the fail log.
+func (o *FailLogOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = true
+	if o.file == nil {
+		return nil
+	}
+	err := o.file.Close()
+	o.file = nil
+	return err
+}
@@ -0,0 +1,326 @@
+package honeypot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Feed actions, see FeedConfig.
+const (
+	FeedTag   = "tag"   // annotate the connection
+	FeedDrop  = "drop"  // close the connection before a handler sees it
+	FeedAllow = "allow" // exempt the connection from drop feeds
+)
+
+// maxFeedBytes bounds the size of a downloaded feed.
+const maxFeedBytes = 64 << 20
+
+// FeedConfig is an IP reputation list, such as Spamhaus DROP, a FireHOL list
+// or an internal feed, refreshed periodically.
+type FeedConfig struct {
+	Name     string `json:"name"`               // attribution in events and log messages
+	URL      string `json:"url"`                // http(s) URL or path of a file
+	Action   string `json:"action,omitempty"`   // "tag" (default), "drop" or "allow"
+	Interval string `json:"interval,omitempty"` // refresh period, default 24h
+}
+
+// FeedMatch is a feed listing an address.
+type FeedMatch struct {
+	Feed   string `json:"feed"`
+	Range  string `json:"range"` // entry of the feed containing the address
+	Action string `json:"action"`
+}
+
+// feedList is the parsed content of a feed: its prefixes and the prefix
+// lengths they use, so a lookup masks the address once per length.
+type feedList struct {
+	prefixes map[netip.Prefix]bool
+	lengths  []int
+}
+
+func (l *feedList) lookup(addr netip.Addr) (netip.Prefix, bool) {
+	for _, bits := range l.lengths {
+		if bits > addr.BitLen() {
+			continue
+		}
+		prefix, _ := addr.Prefix(bits)
+		if l.prefixes[prefix] {
+			return prefix, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// Len returns the number of entries of the list.
+func (l *feedList) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.prefixes)
+}
+
+// parseFeed reads one address or CIDR range per line. Text after ';' or '#'
+// is a comment, as in Spamhaus DROP ("192.0.2.0/24 ; SBL123") and FireHOL
+// lists, and lines that are not addresses are skipped.
+func parseFeed(r io.Reader) (*feedList, error) {
+	l := &feedList{prefixes: make(map[netip.Prefix]bool)}
+	lengths := make(map[int]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, ";#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var prefix netip.Prefix
+		if strings.Contains(fields[0], "/") {
+			p, err := netip.ParsePrefix(fields[0])
+			if err != nil {
+				continue
+			}
+			prefix = p.Masked()
+		} else {
+			addr, err := netip.ParseAddr(fields[0])
+			if err != nil {
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		l.prefixes[prefix] = true
+		lengths[prefix.Bits()] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for bits := range lengths {
+		l.lengths = append(l.lengths, bits)
+	}
+	// Most specific first, so matches name the narrowest entry
+	sort.Sort(sort.Reverse(sort.IntSlice(l.lengths)))
+	return l, nil
+}
+
+// feed is a configured feed and its latest content.
+type feed struct {
+	FeedConfig
+	interval time.Duration
+
+	mu           sync.RWMutex
+	list         *feedList // nil until the first successful fetch
+	etag         string
+	lastModified string
+}
+
+// ReputationFeeds looks up client addresses in IP reputation feeds kept up
+// to date in the background. A feed that cannot be refreshed keeps its
+// previous content.
+type ReputationFeeds struct {
+	feeds  []*feed
+	client *http.Client
+	log    *log.Logger
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// NewReputationFeeds validates cfgs and starts fetching the feeds. Fetch
+// results and failures are reported to logger.
+func NewReputationFeeds(cfgs []FeedConfig, logger *log.Logger) (*ReputationFeeds, error) {
+	rf := &ReputationFeeds{client: &http.Client{Timeout: time.Minute}, log: logger, stop: make(chan struct{})}
+	names := make(map[string]bool)
+	for _, cfg := range cfgs {
+		f, err := newFeed(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("feed %s configured twice", cfg.Name)
+		}
+		names[cfg.Name] = true
+		rf.feeds = append(rf.feeds, f)
+	}
+	for _, f := range rf.feeds {
+		go rf.run(f)
+	}
+	return rf, nil
+}
+
+// newFeed validates cfg and fills in its defaults.
+func newFeed(cfg FeedConfig) (*feed, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("feed %s has no name", cfg.URL)
+	}
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("feed %s has no URL", cfg.Name)
+	}
+	switch cfg.Action {
+	case "":
+		cfg.Action = FeedTag
+	case FeedTag, FeedDrop, FeedAllow:
+	default:
+		return nil, fmt.Errorf("feed %s: invalid action %q, want tag, drop or allow", cfg.Name, cfg.Action)
+	}
+	f := &feed{FeedConfig: cfg, interval: 24 * time.Hour}
+	if cfg.Interval != "" {
+		interval, err := time.ParseDuration(cfg.Interval)
+		if err != nil || interval < time.Minute {
+			return nil, fmt.Errorf("feed %s: invalid interval %q, want at least 1m", cfg.Name, cfg.Interval)
+		}
+		f.interval = interval
+	}
+	return f, nil
+}
+
+// run refreshes f every interval until Close.
+func (rf *ReputationFeeds) run(f *feed) {
+	rf.refresh(f)
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rf.stop:
+			return
+		case <-ticker.C:
+			rf.refresh(f)
+		}
+	}
+}
+
+func (rf *ReputationFeeds) refresh(f *feed) {
+	list, err := rf.fetch(f)
+	switch {
+	case err != nil:
+		f.mu.RLock()
+		kept := f.list.Len()
+		f.mu.RUnlock()
+		rf.log.Printf("Unable to refresh feed %s, keeping %d entries: %s", f.Name, kept, err)
+	case list != nil:
+		f.mu.Lock()
+		f.list = list
+		f.mu.Unlock()
+		rf.log.Printf("Loaded feed %s with %d entries", f.Name, list.Len())
+	}
+}
+
+// fetch downloads or reads a feed. It returns a nil list when an HTTP feed
+// has not changed since the last fetch.
+func (rf *ReputationFeeds) fetch(f *feed) (*feedList, error) {
+	u, err := url.Parse(f.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		path := f.URL
+		if err == nil && u.Scheme == "file" {
+			path = u.Path
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return parseFeed(io.LimitReader(file, maxFeedBytes))
+	}
+	req, err := http.NewRequest(http.MethodGet, f.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Conditional requests keep us within the fetch limits of list providers
+	f.mu.RLock()
+	if f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+	if f.lastModified != "" {
+		req.Header.Set("If-Modified-Since", f.lastModified)
+	}
+	f.mu.RUnlock()
+	resp, err := rf.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", f.URL, resp.Status)
+	}
+	list, err := parseFeed(io.LimitReader(resp.Body, maxFeedBytes))
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.etag, f.lastModified = resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	f.mu.Unlock()
+	return list, nil
+}
+
+// Lookup returns the feeds listing the IP of addr, a host:port or bare
+// address, in configuration order.
+func (rf *ReputationFeeds) Lookup(addr string) []FeedMatch {
+	ip, err := netip.ParseAddr(srcIP(addr))
+	if err != nil {
+		return nil
+	}
+	ip = ip.Unmap().WithZone("")
+	var matches []FeedMatch
+	for _, f := range rf.feeds {
+		f.mu.RLock()
+		list := f.list
+		f.mu.RUnlock()
+		if list == nil {
+			continue
+		}
+		if prefix, ok := list.lookup(ip); ok {
+			matches = append(matches, FeedMatch{Feed: f.Name, Range: prefix.String(), Action: f.Action})
+		}
+	}
+	return matches
+}
+
+// Close stops refreshing the feeds.
+func (rf *ReputationFeeds) Close() error {
+	rf.once.Do(func() { close(rf.stop) })
+	return nil
+}
+
+// feedVerdict returns the drop feed a connection is refused by, unless an
+// allow feed lists it too.
+func feedVerdict(matches []FeedMatch) (FeedMatch, bool) {
+	var drop *FeedMatch
+	for i, m := range matches {
+		switch m.Action {
+		case FeedAllow:
+			return FeedMatch{}, false
+		case FeedDrop:
+			if drop == nil {
+				drop = &matches[i]
+			}
+		}
+	}
+	if drop == nil {
+		return FeedMatch{}, false
+	}
+	return *drop, true
+}
+
+// feedNames lists the feeds of matches for log messages.
+func feedNames(matches []FeedMatch) string {
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = m.Feed + " (" + m.Range + ")"
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,118 @@
+package honeypot
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+)
+
+// severityRank orders alert severities; events without one rank 0.
+var severityRank = map[string]int{"low": 1, "medium": 2, "high": 3}
+
+// OutputFilter selects the events an output receives, so for instance full
+// data can go to a database while only high severity alerts reach a pager.
+// Empty fields select everything.
+type OutputFilter struct {
+	Events      []string `json:"events,omitempty"`       // event types, see the Event* constants
+	Ports       string   `json:"ports,omitempty"`        // port specification, same syntax as -ports; events without a port are dropped
+	MinSeverity string   `json:"min_severity,omitempty"` // "low", "medium" or "high"; events without a severity are dropped
+	SampleRate  float64  `json:"sample_rate,omitempty"`  // fraction of the selected sessions kept, 0 keeps all
+}
+
+// EventFilter is a compiled OutputFilter. Sampling keeps or drops whole
+// sessions, so a kept session is complete.
+type EventFilter struct {
+	events      map[string]bool
+	ports       map[string]bool
+	minSeverity int
+	sampleRate  float64
+}
+
+// NewEventFilter validates and compiles f.
+func NewEventFilter(f OutputFilter) (*EventFilter, error) {
+	ef := &EventFilter{events: stringSet(f.Events), sampleRate: f.SampleRate}
+	if f.Ports != "" {
+		ports, invalid := ParsePortSpec(f.Ports)
+		if len(invalid) > 0 {
+			return nil, fmt.Errorf("invalid filter ports: %s", strings.Join(invalid, ", "))
+		}
+		ef.ports = stringSet(ports)
+	}
+	if f.MinSeverity != "" {
+		rank, ok := severityRank[f.MinSeverity]
+		if !ok {
+			return nil, fmt.Errorf("invalid minimum severity %q, want low, medium or high", f.MinSeverity)
+		}
+		ef.minSeverity = rank
+	}
+	if f.SampleRate < 0 || f.SampleRate > 1 {
+		return nil, fmt.Errorf("invalid sample rate %g, want a fraction between 0 and 1", f.SampleRate)
+	}
+	return ef, nil
+}
+
+// Match reports whether ev passes the filter. A nil filter passes everything.
+func (ef *EventFilter) Match(ev Event) bool {
+	if ef == nil {
+		return true
+	}
+	if len(ef.events) > 0 && !ef.events[ev.Type] {
+		return false
+	}
+	if ef.ports != nil && !ef.ports[ev.Port] {
+		return false
+	}
+	if severityRank[ev.Severity] < ef.minSeverity {
+		return false
+	}
+	if ef.sampleRate > 0 && ef.sampleRate < 1 {
+		return sampled(ev.Session, ef.sampleRate)
+	}
+	return true
+}
+
+// sampled decides whether an event of session is kept at rate. Events of
+// one session share the decision; events outside sessions are drawn at random.
+func sampled(session string, rate float64) bool {
+	if session == "" {
+		return rand.Float64() < rate
+	}
+	h := fnv.New64a()
+	h.Write([]byte(session))
+	return float64(h.Sum64()%1_000_000) < rate*1_000_000
+}
+
+// FilteredOutput passes the events selected by Filter on to Output.
+type FilteredOutput struct {
+	Output Output
+	Filter *EventFilter
+}
+
+// NewFilteredOutput validates f and returns o restricted to the events it selects.
+func NewFilteredOutput(o Output, f OutputFilter) (*FilteredOutput, error) {
+	filter, err := NewEventFilter(f)
+	if err != nil {
+		return nil, err
+	}
+	return &FilteredOutput{Output: o, Filter: filter}, nil
+}
+
+// Write implements Output.
+func (fo *FilteredOutput) Write(ev Event) error {
+	if !fo.Filter.Match(ev) {
+		return nil
+	}
+	return fo.Output.Write(ev)
+}
+
+// WriteWait implements WaitingOutput, waiting if Output is one.
+func (fo *FilteredOutput) WriteWait(ev Event) error {
+	if !fo.Filter.Match(ev) {
+		return nil
+	}
+	if w, ok := fo.Output.(WaitingOutput); ok {
+		return w.WriteWait(ev)
+	}
+	return fo.Output.Write(ev)
+}
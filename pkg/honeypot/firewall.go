@@ -0,0 +1,498 @@
+package honeypot
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Firewall backends, see FirewallConfig.
+const (
+	FirewallNftables = "nftables" // a table of its own with sets of blocked addresses, Linux
+	FirewallIptables = "iptables" // a chain of its own jumped to from INPUT, for IPv4 and IPv6, Linux
+	FirewallPF       = "pf"       // a table the pf.conf rules block, BSD and macOS
+)
+
+// FirewallBackends are the firewalls clients can be blocked on.
+var FirewallBackends = []string{FirewallNftables, FirewallIptables, FirewallPF}
+
+// FirewallRule blocks a client once it performs Count of Actions within Window.
+type FirewallRule struct {
+	Actions  []string `json:"actions,omitempty"`  // fail log actions counted, default auth_failure and alert, see FailLogActions
+	Count    int      `json:"count"`              // actions that trigger a block
+	Window   string   `json:"window"`             // period the actions are counted over, e.g. 10m
+	Duration string   `json:"duration,omitempty"` // how long the block lasts, default the duration of the firewall
+}
+
+// FirewallConfig enables blocking clients on the firewall of the host, which
+// turns the sensor into a tripwire protecting the services next to it.
+type FirewallConfig struct {
+	Backend   string         `json:"backend"`              // one of FirewallBackends, empty to disable
+	Rules     []FirewallRule `json:"rules,omitempty"`      // default 5 auth_failure or alert actions in 10m
+	Duration  string         `json:"duration,omitempty"`   // how long blocks last, default 1h
+	Exempt    []string       `json:"exempt,omitempty"`     // addresses and prefixes never blocked, besides loopback
+	Table     string         `json:"table,omitempty"`      // nftables table, iptables chain or pf table holding the blocks, default gopot
+	MaxBlocks int            `json:"max_blocks,omitempty"` // blocks in place at once, default 10000
+}
+
+// firewallRule is a FirewallRule with its settings parsed.
+type firewallRule struct {
+	actions  []string
+	count    int
+	window   time.Duration
+	duration time.Duration
+}
+
+func (r firewallRule) String() string {
+	return fmt.Sprintf("%d %s actions in %s", r.count, strings.Join(r.actions, "/"), r.window)
+}
+
+// firewallHits are the times a client performed the actions of a rule.
+type firewallHits struct {
+	rule int
+	addr netip.Addr
+}
+
+// firewallBlock is a block about to be put in place.
+type firewallBlock struct {
+	addr   netip.Addr
+	until  time.Time
+	reason string // the rule that matched
+}
+
+// firewall blocks clients matching its rules, see FirewallConfig.
+type firewall struct {
+	backend   firewallBackend
+	name      string
+	rules     []firewallRule
+	exempt    []netip.Prefix
+	maxBlocks int
+
+	mu      sync.Mutex
+	hits    map[firewallHits][]time.Time
+	blocked map[netip.Addr]time.Time // expiry of each block
+	pending chan firewallBlock       // blocks for runFirewall to put in place
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// newFirewall validates cfg and returns its firewall, without touching the
+// firewall of the host yet.
+func newFirewall(cfg FirewallConfig) (*firewall, error) {
+	table := cfg.Table
+	if table == "" {
+		table = "gopot"
+	}
+	for _, c := range table {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return nil, fmt.Errorf("invalid firewall table %q, use letters, digits, - and _", table)
+		}
+	}
+	f := &firewall{
+		name:      cfg.Backend,
+		maxBlocks: cfg.MaxBlocks,
+		hits:      make(map[firewallHits][]time.Time),
+		blocked:   make(map[netip.Addr]time.Time),
+		pending:   make(chan firewallBlock, 64),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	switch cfg.Backend {
+	case FirewallNftables:
+		f.backend = nftables{table: table}
+	case FirewallIptables:
+		f.backend = iptables{chain: table}
+	case FirewallPF:
+		f.backend = pf{table: table}
+	default:
+		return nil, fmt.Errorf("unknown firewall backend %q, want one of %s", cfg.Backend, strings.Join(FirewallBackends, ", "))
+	}
+	if f.maxBlocks <= 0 {
+		f.maxBlocks = 10000
+	}
+
+	duration := time.Hour
+	if cfg.Duration != "" {
+		d, err := time.ParseDuration(cfg.Duration)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid firewall duration %q, want at least 1s", cfg.Duration)
+		}
+		duration = d
+	}
+	rules := cfg.Rules
+	if len(rules) == 0 {
+		rules = []FirewallRule{{Count: 5, Window: "10m"}}
+	}
+	for i, rc := range rules {
+		r := firewallRule{actions: rc.Actions, count: rc.Count, duration: duration}
+		if len(r.actions) == 0 {
+			r.actions = []string{FailAuthFailure, FailAlert}
+		}
+		for _, action := range r.actions {
+			if !slices.Contains(FailLogActions, action) {
+				return nil, fmt.Errorf("firewall rule %d: unknown action %q, want one of %s", i, action, strings.Join(FailLogActions, ", "))
+			}
+		}
+		if r.count <= 0 {
+			return nil, fmt.Errorf("firewall rule %d: count must be positive", i)
+		}
+		window, err := time.ParseDuration(rc.Window)
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("firewall rule %d: invalid window %q", i, rc.Window)
+		}
+		r.window = window
+		if rc.Duration != "" {
+			d, err := time.ParseDuration(rc.Duration)
+			if err != nil || d < time.Second {
+				return nil, fmt.Errorf("firewall rule %d: invalid duration %q, want at least 1s", i, rc.Duration)
+			}
+			r.duration = d
+		}
+		f.rules = append(f.rules, r)
+	}
+	for _, s := range cfg.Exempt {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(s)
+			if aerr != nil {
+				return nil, fmt.Errorf("invalid firewall exemption %q, want an address or a prefix", s)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		f.exempt = append(f.exempt, prefix.Masked())
+	}
+	return f, nil
+}
+
+// EnableFirewall validates cfg, prepares the table of the firewall backend
+// and starts blocking the clients that match its rules. Each block and its
+// expiry is reported by a firewall event. Blocks are lifted by Shutdown.
+// Changing the firewall needs root privileges (CAP_NET_ADMIN on Linux).
+func (s *Server) EnableFirewall(cfg FirewallConfig) error {
+	f, err := newFirewall(cfg)
+	if err != nil {
+		return err
+	}
+	if err := f.backend.setup(); err != nil {
+		return fmt.Errorf("setting up %s: %w", f.name, err)
+	}
+	s.firewall = f
+	go s.runFirewall()
+	return nil
+}
+
+// exempted reports whether addr must never be blocked.
+func (f *firewall) exempted(addr netip.Addr) bool {
+	if addr.IsLoopback() || addr.IsUnspecified() {
+		return true
+	}
+	for _, prefix := range f.exempt {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// observe counts the fail log action of ev, if any, against the rules and
+// queues a block when the client reaches the count of one. The actions of
+// UDP clients never count, see spoofable.
+func (f *firewall) observe(ev Event) {
+	action := failAction(ev)
+	if action == "" || spoofable(ev) {
+		return
+	}
+	addr, err := netip.ParseAddr(srcIP(ev.SrcAddr))
+	if err != nil || f.exempted(addr.Unmap()) {
+		return
+	}
+	addr = addr.Unmap().WithZone("")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.blocked[addr]; ok {
+		return // traffic already on its way in, or the block not yet in place
+	}
+	for i, r := range f.rules {
+		if !slices.Contains(r.actions, action) {
+			continue
+		}
+		key := firewallHits{rule: i, addr: addr}
+		hits := append(f.hits[key], ev.Time)
+		for len(hits) > 0 && ev.Time.Sub(hits[0]) > r.window {
+			hits = hits[1:]
+		}
+		f.hits[key] = hits
+		if len(hits) < r.count {
+			continue
+		}
+		if len(f.blocked) >= f.maxBlocks {
+			return
+		}
+		block := firewallBlock{addr: addr, until: ev.Time.Add(r.duration), reason: r.String()}
+		select {
+		case f.pending <- block:
+			f.blocked[addr] = block.until
+			for j := range f.rules {
+				delete(f.hits, firewallHits{rule: j, addr: addr})
+			}
+		default:
+			// run is behind, the next action tries again
+		}
+		return
+	}
+}
+
+// runFirewall puts blocks in place and lifts them once they expire, until
+// Shutdown calls closeFirewall.
+func (s *Server) runFirewall() {
+	f := s.firewall
+	defer close(f.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case block := <-f.pending:
+			s.putBlock(block)
+		case now := <-ticker.C:
+			s.expireBlocks(now)
+		}
+	}
+}
+
+// putBlock puts block in place and emits its firewall event.
+func (s *Server) putBlock(block firewallBlock) {
+	f := s.firewall
+	d := time.Until(block.until).Round(time.Second)
+	if err := f.backend.block(block.addr, max(d, time.Second)); err != nil {
+		f.mu.Lock()
+		delete(f.blocked, block.addr)
+		f.mu.Unlock()
+		s.logf(EventError, "Unable to block %s on %s: %s", block.addr, f.name, err)
+		return
+	}
+	s.Emit(Event{
+		Type:    EventFirewall,
+		SrcAddr: block.addr.String(),
+		Message: fmt.Sprintf("Blocked %s on %s until %s after %s", block.addr, f.name, block.until.UTC().Format(time.RFC3339), block.reason),
+		Fields: map[string]any{
+			"firewall_action": "block",
+			"backend":         f.name,
+			"until":           block.until.UTC().Format(time.RFC3339),
+			"reason":          block.reason,
+		},
+	})
+}
+
+// expireBlocks lifts the blocks expired by now, emitting their firewall
+// events, and forgets actions too old to count.
+func (s *Server) expireBlocks(now time.Time) {
+	f := s.firewall
+	var expired []netip.Addr
+	f.mu.Lock()
+	for addr, until := range f.blocked {
+		if !now.Before(until) {
+			expired = append(expired, addr)
+			delete(f.blocked, addr)
+		}
+	}
+	for key, hits := range f.hits {
+		if now.Sub(hits[len(hits)-1]) > f.rules[key.rule].window {
+			delete(f.hits, key)
+		}
+	}
+	f.mu.Unlock()
+
+	slices.SortFunc(expired, netip.Addr.Compare)
+	for _, addr := range expired {
+		if err := f.backend.unblock(addr); err != nil {
+			s.logf(EventError, "Unable to unblock %s on %s: %s", addr, f.name, err)
+			continue
+		}
+		s.Emit(Event{
+			Type:    EventFirewall,
+			SrcAddr: addr.String(),
+			Message: fmt.Sprintf("Unblocked %s on %s, the block expired", addr, f.name),
+			Fields:  map[string]any{"firewall_action": "unblock", "backend": f.name},
+		})
+	}
+}
+
+// closeFirewall stops blocking clients and removes the table of the backend
+// with the blocks still in place.
+func (s *Server) closeFirewall() {
+	f := s.firewall
+	if f == nil {
+		return
+	}
+	close(f.stop)
+	<-f.done
+	f.mu.Lock()
+	lifted := len(f.blocked)
+	f.blocked = make(map[netip.Addr]time.Time)
+	f.mu.Unlock()
+	if err := f.backend.teardown(); err != nil {
+		s.logf(EventError, "Unable to remove the blocks from %s: %s", f.name, err)
+		return
+	}
+	s.logf(EventInfo, "Lifted %d firewall blocks on %s.", lifted, f.name)
+}
+
+// firewallBackend changes the firewall of the host.
+type firewallBackend interface {
+	setup() error                                 // creates the table of blocks, emptying any left over
+	block(addr netip.Addr, d time.Duration) error // blocks addr, for d if the backend expires blocks itself
+	unblock(addr netip.Addr) error
+	teardown() error // removes the table and its blocks
+}
+
+// runCommand runs a firewall command, with stdin as its input if not empty,
+// and returns its output in the error if it fails.
+func runCommand(stdin string, argv ...string) error {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if output = bytes.TrimSpace(output); len(output) > 0 {
+			return fmt.Errorf("%s: %w: %s", argv[0], err, output)
+		}
+		return fmt.Errorf("%s: %w", argv[0], err)
+	}
+	return nil
+}
+
+// nftables blocks clients with two sets of addresses with timeouts, so
+// blocks expire even if the sensor dies, dropped by a chain of a table of
+// its own hooked before the usual filter chains.
+type nftables struct {
+	table string
+}
+
+func (n nftables) set(addr netip.Addr) string {
+	if addr.Is4() {
+		return "blocked4"
+	}
+	return "blocked6"
+}
+
+func (n nftables) setup() error {
+	return runCommand(fmt.Sprintf(`table inet %[1]s
+delete table inet %[1]s
+table inet %[1]s {
+	set blocked4 { type ipv4_addr; flags timeout; }
+	set blocked6 { type ipv6_addr; flags timeout; }
+	chain input {
+		type filter hook input priority -10; policy accept;
+		ip saddr @blocked4 drop
+		ip6 saddr @blocked6 drop
+	}
+}
+`, n.table), "nft", "-f", "-")
+}
+
+func (n nftables) block(addr netip.Addr, d time.Duration) error {
+	seconds := strconv.Itoa(int(d / time.Second))
+	return runCommand("", "nft", "add", "element", "inet", n.table, n.set(addr), "{ "+addr.String()+" timeout "+seconds+"s }")
+}
+
+func (n nftables) unblock(netip.Addr) error {
+	return nil // the element timed out
+}
+
+func (n nftables) teardown() error {
+	return runCommand("", "nft", "delete", "table", "inet", n.table)
+}
+
+// iptables blocks clients with DROP rules in a chain of its own, in both
+// iptables and ip6tables, that INPUT jumps to first.
+type iptables struct {
+	chain string
+}
+
+func (t iptables) command(addr netip.Addr) string {
+	if addr.Is4() {
+		return "iptables"
+	}
+	return "ip6tables"
+}
+
+func (t iptables) setup() error {
+	for _, command := range []string{"iptables", "ip6tables"} {
+		if runCommand("", command, "-w", "-N", t.chain) != nil {
+			// Left over by a sensor that died
+			if err := runCommand("", command, "-w", "-F", t.chain); err != nil {
+				return err
+			}
+		}
+		if runCommand("", command, "-w", "-C", "INPUT", "-j", t.chain) != nil {
+			if err := runCommand("", command, "-w", "-I", "INPUT", "-j", t.chain); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t iptables) block(addr netip.Addr, _ time.Duration) error {
+	return runCommand("", t.command(addr), "-w", "-A", t.chain, "-s", addr.String(), "-j", "DROP")
+}
+
+func (t iptables) unblock(addr netip.Addr) error {
+	return runCommand("", t.command(addr), "-w", "-D", t.chain, "-s", addr.String(), "-j", "DROP")
+}
+
+func (t iptables) teardown() error {
+	var errs []string
+	for _, command := range []string{"iptables", "ip6tables"} {
+		for _, args := range [][]string{{"-D", "INPUT", "-j", t.chain}, {"-F", t.chain}, {"-X", t.chain}} {
+			if err := runCommand("", append([]string{command, "-w"}, args...)...); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// pf blocks clients by adding them to a table, which a rule of pf.conf must
+// block, e.g. "block drop in quick from <gopot>", and kills their states so
+// open connections are cut too.
+type pf struct {
+	table string
+}
+
+func (p pf) setup() error {
+	if _, err := exec.LookPath("pfctl"); err != nil {
+		return err
+	}
+	runCommand("", "pfctl", "-q", "-t", p.table, "-T", "flush") // fails if the table doesn't exist yet
+	return nil
+}
+
+func (p pf) block(addr netip.Addr, _ time.Duration) error {
+	if err := runCommand("", "pfctl", "-q", "-t", p.table, "-T", "add", addr.String()); err != nil {
+		return err
+	}
+	runCommand("", "pfctl", "-q", "-k", addr.String()) // no states is fine
+	return nil
+}
+
+func (p pf) unblock(addr netip.Addr) error {
+	return runCommand("", "pfctl", "-q", "-t", p.table, "-T", "delete", addr.String())
+}
+
+func (p pf) teardown() error {
+	return runCommand("", "pfctl", "-q", "-t", p.table, "-T", "flush")
+}
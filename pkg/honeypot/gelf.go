@@ -0,0 +1,269 @@
+package honeypot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Chunked GELF messages over UDP: the magic bytes opening each chunk, the
+// size of its header, the most chunks a message may be split into and the
+// default chunk size, which fits the MTU of most WAN links.
+const (
+	gelfChunkMagic   = "\x1e\x0f"
+	gelfChunkHeader  = 12
+	gelfMaxChunks    = 128
+	gelfDefaultChunk = 1420
+)
+
+// GELFConfig sends events to a Graylog GELF input, see GELFOutput.
+type GELFConfig struct {
+	Addr        string      `json:"addr"`                  // host:port of the input, e.g. graylog:12201, empty to disable
+	Transport   string      `json:"transport,omitempty"`   // "udp" (default) or "tcp"
+	Compression string      `json:"compression,omitempty"` // of UDP messages, "gzip" (default) or "none"
+	ChunkSize   int         `json:"chunk_size,omitempty"`  // largest UDP datagram, default 1420; use 8192 on a LAN
+	Host        string      `json:"host,omitempty"`        // host of the messages, default the host name of the sensor
+	Batch       BatchConfig `json:"batch"`                 // default 100 events at least every second; compression is per message
+}
+
+// GELFOutput is an Output sending events to Graylog in the Graylog Extended
+// Log Format, so they land in Graylog with their properties as fields
+// without extractors. The short message is the log line of the event, its
+// level follows its severity as in the journal, and every property becomes
+// an additional field: _event_type, _session, _handler, _port, _src_ip,
+// _src_port, _dst_addr and the like, each field of the event under its own
+// name, such as _username, and each label as _label_ followed by its key.
+//
+// Over UDP messages are compressed and split into chunks as large as the
+// datagram size allows; over TCP they are sent uncompressed, terminated by
+// a null byte, over a connection that is opened again when it fails.
+type GELFOutput struct {
+	*Batcher
+	host      string
+	addr      string
+	transport string
+	gzip      bool
+	chunkSize int
+
+	mu   sync.Mutex
+	conn net.Conn // nil until connected
+}
+
+// NewGELFOutput validates cfg and starts sending. Failed deliveries are
+// reported to logger.
+func NewGELFOutput(cfg GELFConfig, logger *log.Logger) (*GELFOutput, error) {
+	o := &GELFOutput{host: cfg.Host, addr: cfg.Addr, transport: cfg.Transport, gzip: true, chunkSize: cfg.ChunkSize}
+	if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+		return nil, fmt.Errorf("gelf: invalid address %q: %w", cfg.Addr, err)
+	}
+	switch o.transport {
+	case "":
+		o.transport = "udp"
+	case "udp", "tcp":
+	default:
+		return nil, fmt.Errorf("gelf: unknown transport %q, want udp or tcp", cfg.Transport)
+	}
+	switch cfg.Compression {
+	case "", "gzip":
+	case "none":
+		o.gzip = false
+	default:
+		return nil, fmt.Errorf("gelf: unsupported compression %q, want gzip or none", cfg.Compression)
+	}
+	if o.chunkSize == 0 {
+		o.chunkSize = gelfDefaultChunk
+	}
+	if o.chunkSize <= gelfChunkHeader || o.chunkSize > 65507 {
+		return nil, fmt.Errorf("gelf: chunk size %d out of range", cfg.ChunkSize)
+	}
+	if o.host == "" {
+		o.host, _ = os.Hostname()
+	}
+	batch := cfg.Batch
+	if batch.Compression != "" {
+		return nil, fmt.Errorf("gelf: batch compression is not supported, set compression instead")
+	}
+	if batch.Interval == "" {
+		batch.Interval = "1s"
+	}
+	batcher, err := NewBatcher("gelf", batch, o.encode, o.send)
+	if err != nil {
+		return nil, err
+	}
+	batcher.Log = logger
+	o.Batcher = batcher
+	return o, nil
+}
+
+// encode turns a batch of events into GELF messages, each terminated by a
+// null byte.
+func (o *GELFOutput) encode(events []Event) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, ev := range events {
+		msg, err := json.Marshal(o.message(ev))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(msg)
+		buf.WriteByte(0)
+	}
+	return buf.Bytes(), nil
+}
+
+// message returns the GELF message of ev.
+func (o *GELFOutput) message(ev Event) map[string]any {
+	msg := map[string]any{
+		"version":         "1.1",
+		"host":            o.host,
+		"short_message":   ev.Message,
+		"timestamp":       float64(ev.Time.UnixMilli()) / 1000,
+		"level":           journalPriority(ev),
+		"_event_type":     ev.Type,
+		"_seq":            ev.Seq,
+		"_boot_id":        ev.BootID,
+		"_schema_version": ev.Schema,
+	}
+	if msg["short_message"] == "" {
+		msg["short_message"] = ev.Type
+	}
+	for name, value := range map[string]string{
+		"_session":    ev.Session,
+		"_handler":    ev.Handler,
+		"_severity":   ev.Severity,
+		"_port":       ev.Port,
+		"_src_addr":   ev.SrcAddr,
+		"_dst_addr":   ev.DstAddr,
+		"_decoy_host": ev.Host,
+	} {
+		if value != "" {
+			msg[name] = value
+		}
+	}
+	if host, port, err := net.SplitHostPort(ev.SrcAddr); err == nil {
+		msg["_src_ip"], msg["_src_port"] = host, port
+	}
+	for key, value := range ev.Labels {
+		msg["_label_"+gelfField(key)] = value
+	}
+	for key, value := range ev.Fields {
+		name := "_" + gelfField(key)
+		if taken, ok := msg[name]; ok || name == "_id" {
+			if s, ok := value.(string); ok && taken == s {
+				continue // e.g. the handler of session_end events
+			}
+			name = "_field_" + gelfField(key)
+		}
+		switch v := value.(type) {
+		case string, int, int64, uint64, float64:
+			msg[name] = v
+		case bool:
+			msg[name] = fmt.Sprint(v)
+		default:
+			content, err := json.Marshal(v)
+			if err != nil {
+				continue
+			}
+			msg[name] = string(content)
+		}
+	}
+	return msg
+}
+
+// gelfField returns name as part of a GELF field name, which may only hold
+// letters, digits, underscores, dots and dashes.
+func gelfField(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '.' || r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// send delivers the null-terminated messages of body.
+func (o *GELFOutput) send(body []byte, _ string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.conn == nil {
+		conn, err := net.DialTimeout(o.transport, o.addr, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		o.conn = conn
+	}
+	var err error
+	if o.transport == "tcp" {
+		o.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		_, err = o.conn.Write(body)
+	} else {
+		for _, msg := range bytes.Split(bytes.TrimSuffix(body, []byte{0}), []byte{0}) {
+			if err = o.sendDatagrams(msg); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		o.conn.Close()
+		o.conn = nil
+	}
+	return err
+}
+
+// sendDatagrams sends a message over UDP, compressed unless disabled, in
+// chunks if it does not fit a datagram. Messages needing more than
+// gelfMaxChunks chunks are dropped, since Graylog would discard them.
+func (o *GELFOutput) sendDatagrams(msg []byte) error {
+	if o.gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(msg)
+		zw.Close()
+		msg = buf.Bytes()
+	}
+	if len(msg) <= o.chunkSize {
+		_, err := o.conn.Write(msg)
+		return err
+	}
+	size := o.chunkSize - gelfChunkHeader
+	count := (len(msg) + size - 1) / size
+	if count > gelfMaxChunks {
+		if o.Log != nil {
+			o.Log.Printf("Output gelf: dropped a message of %d bytes, more than %d chunks", len(msg), gelfMaxChunks)
+		}
+		return nil
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	chunk := make([]byte, 0, o.chunkSize)
+	for i := 0; i < count; i++ {
+		part := msg[i*size : min((i+1)*size, len(msg))]
+		chunk = append(chunk[:0], gelfChunkMagic...)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, part...)
+		if _, err := o.conn.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close sends the events still queued and disconnects.
+func (o *GELFOutput) Close() error {
+	err := o.Batcher.Close()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.conn != nil {
+		o.conn.Close()
+		o.conn = nil
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+package honeypot
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GeoInfo is what a GeoDB knows about an address.
+type GeoInfo struct {
+	Country string `json:"country"` // ISO 3166 alpha-2 code, "??" when unknown
+	ASN     uint32 `json:"asn"`     // autonomous system number, 0 when unknown
+	ASName  string `json:"as_name"` // description of the autonomous system
+}
+
+// unknownGeo describes addresses missing from the database.
+var unknownGeo = GeoInfo{Country: "??", ASName: "unknown"}
+
+// geoRange is one line of the database.
+type geoRange struct {
+	start, end net.IP // 16-byte form
+	info       GeoInfo
+}
+
+// GeoDB maps IP addresses to their country and autonomous system.
+type GeoDB struct {
+	ranges []geoRange // sorted by start, not overlapping
+}
+
+// LoadGeoDB reads an IP-to-ASN database in the tab-separated format published
+// by iptoasn.com (ip2asn-combined.tsv): range start, range end, AS number,
+// country code and AS description per line. IPv4 and IPv6 ranges may be mixed.
+func LoadGeoDB(path string) (*GeoDB, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	db := &GeoDB{}
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 5)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("%s:%d: want start, end, ASN and country separated by tabs", path, lineNo)
+		}
+		start, end := net.ParseIP(fields[0]).To16(), net.ParseIP(fields[1]).To16()
+		asn, err := strconv.ParseUint(fields[2], 10, 32)
+		if start == nil || end == nil || err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid range or AS number", path, lineNo)
+		}
+		r := geoRange{start: start, end: end, info: GeoInfo{Country: fields[3], ASN: uint32(asn)}}
+		if len(fields) == 5 {
+			r.info.ASName = fields[4]
+		}
+		if r.info.Country == "None" || r.info.Country == "" {
+			r.info.Country = unknownGeo.Country
+		}
+		if asn == 0 {
+			continue // unrouted space
+		}
+		db.ranges = append(db.ranges, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(db.ranges, func(i, j int) bool { return bytes.Compare(db.ranges[i].start, db.ranges[j].start) < 0 })
+	return db, nil
+}
+
+// Len returns the number of ranges in the database.
+func (db *GeoDB) Len() int { return len(db.ranges) }
+
+// Lookup returns the country and autonomous system of ip. Addresses missing
+// from the database, and every address when db is nil, are reported as unknown.
+func (db *GeoDB) Lookup(ip net.IP) GeoInfo {
+	ip = ip.To16()
+	if db == nil || ip == nil {
+		return unknownGeo
+	}
+	// Find the last range starting at or before ip
+	i := sort.Search(len(db.ranges), func(i int) bool { return bytes.Compare(db.ranges[i].start, ip) > 0 }) - 1
+	if i < 0 || bytes.Compare(ip, db.ranges[i].end) > 0 {
+		return unknownGeo
+	}
+	return db.ranges[i].info
+}
@@ -0,0 +1,207 @@
+package honeypot
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Graph export formats, see ArtifactGraph.Write.
+const (
+	GraphML     = "graphml" // GraphML, imported by Maltego, Gephi, yEd and Neo4j's APOC
+	GraphCypher = "cypher"  // Cypher statements for cypher-shell or the Neo4j browser
+	GraphJSON   = "json"    // {"nodes": [...], "edges": [...]}
+)
+
+// GraphFormats lists the formats ArtifactGraph.Write supports.
+var GraphFormats = []string{GraphML, GraphCypher, GraphJSON}
+
+// artifactEdgeTypes names the relation between an address and the other
+// kinds of artifact.
+var artifactEdgeTypes = map[string]string{
+	ArtifactRDNS:       "RESOLVES_TO",
+	ArtifactJA3:        "FINGERPRINTED_AS",
+	ArtifactCredential: "TRIED",
+	ArtifactPayload:    "SENT",
+	ArtifactURL:        "DOWNLOADS",
+	ArtifactSample:     "SERVES",
+}
+
+// artifactLabels are the Neo4j labels of the artifact kinds.
+var artifactLabels = map[string]string{
+	ArtifactIP:         "IP",
+	ArtifactRDNS:       "Hostname",
+	ArtifactJA3:        "JA3",
+	ArtifactCredential: "Credential",
+	ArtifactPayload:    "Payload",
+	ArtifactURL:        "URL",
+	ArtifactSample:     "Sample",
+}
+
+// ArtifactNode is an artifact in an exported graph.
+type ArtifactNode struct {
+	ID string `json:"id"` // see Artifact.ID
+	Artifact
+}
+
+// ArtifactEdge is a relation in an exported graph, from an address to what
+// it was seen with, or from a URL to the sample it served.
+type ArtifactEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Type   string `json:"type"` // e.g. "TRIED" for a credential, see artifactEdgeTypes
+	ArtifactRelation
+}
+
+// ArtifactGraph is a snapshot of an ArtifactDB, ordered by ID so exports
+// are reproducible.
+type ArtifactGraph struct {
+	Nodes []ArtifactNode `json:"nodes"`
+	Edges []ArtifactEdge `json:"edges"`
+}
+
+// Graph returns the artifacts seen at or after since, zero for all of them,
+// and the relations among them seen since then.
+func (db *ArtifactDB) Graph(since time.Time) ArtifactGraph {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	g := ArtifactGraph{Nodes: []ArtifactNode{}, Edges: []ArtifactEdge{}}
+	included := make(map[string]bool)
+	for id, a := range db.artifacts {
+		if !a.LastSeen.Before(since) {
+			included[id] = true
+			g.Nodes = append(g.Nodes, ArtifactNode{ID: id, Artifact: *a})
+		}
+	}
+	for a, related := range db.links {
+		for b, rel := range related {
+			if a >= b || !included[a] || !included[b] || rel.LastSeen.Before(since) {
+				continue
+			}
+			source, target := a, b
+			if kind := db.artifacts[target].Kind; kind == ArtifactIP || kind == ArtifactURL && db.artifacts[source].Kind == ArtifactSample {
+				source, target = target, source
+			}
+			edgeType := artifactEdgeTypes[db.artifacts[target].Kind]
+			if edgeType == "" {
+				edgeType = "SEEN_WITH"
+			}
+			g.Edges = append(g.Edges, ArtifactEdge{Source: source, Target: target, Type: edgeType, ArtifactRelation: *rel})
+		}
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].Source != g.Edges[j].Source {
+			return g.Edges[i].Source < g.Edges[j].Source
+		}
+		return g.Edges[i].Target < g.Edges[j].Target
+	})
+	return g
+}
+
+// Write writes the graph to w in one of the GraphFormats.
+func (g ArtifactGraph) Write(w io.Writer, format string) error {
+	bw := bufio.NewWriter(w)
+	switch format {
+	case GraphML:
+		g.writeGraphML(bw)
+	case GraphCypher:
+		g.writeCypher(bw)
+	case GraphJSON:
+		if err := json.NewEncoder(bw).Encode(g); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown graph format %q, want %s", format, strings.Join(GraphFormats, ", "))
+	}
+	return bw.Flush()
+}
+
+func (g ArtifactGraph) writeGraphML(w *bufio.Writer) {
+	w.WriteString(xml.Header)
+	w.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	for _, key := range []struct{ id, scope, typ string }{
+		{"kind", "node", "string"}, {"value", "node", "string"}, {"type", "edge", "string"},
+		{"first_seen", "all", "string"}, {"last_seen", "all", "string"}, {"count", "all", "int"},
+	} {
+		fmt.Fprintf(w, `  <key id="%s" for="%s" attr.name="%s" attr.type="%s"/>`+"\n", key.id, key.scope, key.id, key.typ)
+	}
+	w.WriteString(`  <graph id="gopot" edgedefault="directed">` + "\n")
+	data := func(key, value string) {
+		fmt.Fprintf(w, `<data key="%s">%s</data>`, key, xmlText(value))
+	}
+	for _, n := range g.Nodes {
+		fmt.Fprintf(w, `    <node id="%s">`, xmlText(n.ID))
+		data("kind", n.Kind)
+		data("value", n.Value)
+		data("first_seen", n.FirstSeen.UTC().Format(time.RFC3339))
+		data("last_seen", n.LastSeen.UTC().Format(time.RFC3339))
+		data("count", fmt.Sprint(n.Count))
+		w.WriteString("</node>\n")
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(w, `    <edge source="%s" target="%s">`, xmlText(e.Source), xmlText(e.Target))
+		data("type", e.Type)
+		data("first_seen", e.FirstSeen.UTC().Format(time.RFC3339))
+		data("last_seen", e.LastSeen.UTC().Format(time.RFC3339))
+		data("count", fmt.Sprint(e.Count))
+		w.WriteString("</edge>\n")
+	}
+	w.WriteString("  </graph>\n</graphml>\n")
+}
+
+// xmlText escapes s for XML text and attributes; characters XML cannot
+// represent, which attackers send in payloads and credentials, become U+FFFD.
+func xmlText(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// writeCypher writes statements that MERGE the graph into Neo4j, so an
+// export can be loaded again later to update the counts.
+func (g ArtifactGraph) writeCypher(w *bufio.Writer) {
+	w.WriteString("CREATE CONSTRAINT gopot_artifact_id IF NOT EXISTS FOR (a:Artifact) REQUIRE a.id IS UNIQUE;\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(w, "MERGE (a:Artifact {id: %s}) SET a:%s, a.kind = %s, a.value = %s, a.first_seen = datetime(%s), a.last_seen = datetime(%s), a.count = %d;\n",
+			cypherString(n.ID), artifactLabels[n.Kind], cypherString(n.Kind), cypherString(n.Value),
+			cypherString(n.FirstSeen.UTC().Format(time.RFC3339)), cypherString(n.LastSeen.UTC().Format(time.RFC3339)), n.Count)
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(w, "MATCH (a:Artifact {id: %s}), (b:Artifact {id: %s}) MERGE (a)-[r:%s]->(b) SET r.first_seen = datetime(%s), r.last_seen = datetime(%s), r.count = %d;\n",
+			cypherString(e.Source), cypherString(e.Target), e.Type,
+			cypherString(e.FirstSeen.UTC().Format(time.RFC3339)), cypherString(e.LastSeen.UTC().Format(time.RFC3339)), e.Count)
+	}
+}
+
+// cypherString quotes s as a Cypher string literal.
+func cypherString(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, r := range s {
+		switch r {
+		case '\'', '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
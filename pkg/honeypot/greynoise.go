@@ -0,0 +1,246 @@
+package honeypot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GreyNoiseURL is the Community API of GreyNoise, taking the address after
+// the final slash.
+const GreyNoiseURL = "https://api.greynoise.io/v3/community/"
+
+// GreyNoiseUnseen is the classification of addresses GreyNoise has neither
+// seen scanning the internet nor knows as a business service.
+const GreyNoiseUnseen = "unseen"
+
+// GreyNoiseConfig enables the classification of clients by GreyNoise.
+type GreyNoiseConfig struct {
+	Enabled   bool   `json:"enabled"`              // look clients up, opt-in
+	APIKey    string `json:"api_key,omitempty"`    // key of the account, optional for the Community API
+	URL       string `json:"url,omitempty"`        // API the address is appended to, default GreyNoiseURL
+	Rate      int    `json:"rate,omitempty"`       // lookups per minute, default 10
+	CacheTTL  string `json:"cache_ttl,omitempty"`  // how long a classification is reused, default 24h
+	CacheSize int    `json:"cache_size,omitempty"` // addresses remembered, default 100000
+}
+
+// GreyNoiseInfo is what GreyNoise knows about an address.
+type GreyNoiseInfo struct {
+	Classification string `json:"classification"`      // "benign", "malicious", "unknown" or GreyNoiseUnseen
+	Noise          bool   `json:"noise"`               // seen scanning the internet
+	RIOT           bool   `json:"riot"`                // a common business service, e.g. a CDN or an update server
+	Name           string `json:"name,omitempty"`      // the scanner or service, e.g. "Shodan.io"
+	LastSeen       string `json:"last_seen,omitempty"` // day GreyNoise last saw it scanning
+}
+
+// greyNoiseEntry is a cached classification.
+type greyNoiseEntry struct {
+	info    GreyNoiseInfo
+	expires time.Time
+}
+
+// GreyNoise classifies client addresses with the GreyNoise API, to tell
+// benign background scanners such as Shodan and Censys from the rest.
+// Lookups run in the background at a limited rate and their results are
+// cached, so a client is classified from the events after its first lookup
+// completes, usually from its next session on.
+type GreyNoise struct {
+	url       string
+	apiKey    string
+	interval  time.Duration // between lookups
+	ttl       time.Duration
+	cacheSize int
+	client    *http.Client
+	log       *log.Logger
+
+	mu      sync.Mutex
+	cache   map[netip.Addr]greyNoiseEntry
+	pending map[netip.Addr]bool
+	queue   chan netip.Addr
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// NewGreyNoise validates cfg and starts the lookups. Failed lookups are
+// reported to logger.
+func NewGreyNoise(cfg GreyNoiseConfig, logger *log.Logger) (*GreyNoise, error) {
+	g := &GreyNoise{
+		url:       cfg.URL,
+		apiKey:    cfg.APIKey,
+		ttl:       24 * time.Hour,
+		cacheSize: cfg.CacheSize,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		log:       logger,
+		cache:     make(map[netip.Addr]greyNoiseEntry),
+		pending:   make(map[netip.Addr]bool),
+		queue:     make(chan netip.Addr, 1000),
+		stop:      make(chan struct{}),
+	}
+	if g.url == "" {
+		g.url = GreyNoiseURL
+	}
+	if u, err := url.Parse(g.url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("greynoise: %q is not an http or https URL", g.url)
+	}
+	if !strings.HasSuffix(g.url, "/") {
+		g.url += "/"
+	}
+	rate := cfg.Rate
+	if rate == 0 {
+		rate = 10
+	}
+	if rate < 0 {
+		return nil, fmt.Errorf("greynoise: rate must be positive")
+	}
+	g.interval = time.Minute / time.Duration(rate)
+	if cfg.CacheTTL != "" {
+		ttl, err := time.ParseDuration(cfg.CacheTTL)
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("greynoise: invalid cache_ttl %q", cfg.CacheTTL)
+		}
+		g.ttl = ttl
+	}
+	if g.cacheSize <= 0 {
+		g.cacheSize = 100000
+	}
+	go g.run()
+	return g, nil
+}
+
+// Lookup returns the classification of the address of clientAddr, an
+// address with or without a port, if it is cached, and otherwise queues its
+// lookup and returns false. Addresses that are not routable on the internet
+// are never looked up.
+func (g *GreyNoise) Lookup(clientAddr string) (GreyNoiseInfo, bool) {
+	addr, err := netip.ParseAddr(srcIP(clientAddr))
+	if err != nil {
+		return GreyNoiseInfo{}, false
+	}
+	addr = addr.Unmap().WithZone("")
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return GreyNoiseInfo{}, false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if entry, ok := g.cache[addr]; ok && time.Now().Before(entry.expires) {
+		return entry.info, true
+	}
+	if !g.pending[addr] {
+		select {
+		case g.queue <- addr:
+			g.pending[addr] = true
+		default:
+			// The queue is full; a later session of the client tries again
+		}
+	}
+	return GreyNoiseInfo{}, false
+}
+
+// run looks the queued addresses up, one every interval, until Close.
+func (g *GreyNoise) run() {
+	var pause time.Time // set by rate limiting
+	for {
+		select {
+		case <-g.stop:
+			return
+		case addr := <-g.queue:
+			if wait := time.Until(pause); wait > 0 {
+				select {
+				case <-g.stop:
+					return
+				case <-time.After(wait):
+				}
+			}
+			info, retry, err := g.query(addr)
+			g.mu.Lock()
+			delete(g.pending, addr)
+			if err == nil {
+				g.store(addr, info)
+			}
+			g.mu.Unlock()
+			if err != nil && g.log != nil {
+				g.log.Printf("Unable to look %s up in GreyNoise: %s", addr, err)
+			}
+			if retry > 0 {
+				pause = time.Now().Add(retry)
+			}
+			select {
+			case <-g.stop:
+				return
+			case <-time.After(g.interval):
+			}
+		}
+	}
+}
+
+// store caches info, making room by dropping expired entries, or else
+// any, when the cache is full. g.mu must be held.
+func (g *GreyNoise) store(addr netip.Addr, info GreyNoiseInfo) {
+	if len(g.cache) >= g.cacheSize {
+		now := time.Now()
+		for cached, entry := range g.cache {
+			if now.After(entry.expires) {
+				delete(g.cache, cached)
+			}
+		}
+		for cached := range g.cache {
+			if len(g.cache) < g.cacheSize {
+				break
+			}
+			delete(g.cache, cached)
+		}
+	}
+	g.cache[addr] = greyNoiseEntry{info: info, expires: time.Now().Add(g.ttl)}
+}
+
+// query asks GreyNoise about addr. When the API limits the rate it returns
+// how long to wait before the next query.
+func (g *GreyNoise) query(addr netip.Addr) (GreyNoiseInfo, time.Duration, error) {
+	req, err := http.NewRequest(http.MethodGet, g.url+addr.String(), nil)
+	if err != nil {
+		return GreyNoiseInfo{}, 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if g.apiKey != "" {
+		req.Header.Set("key", g.apiKey)
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return GreyNoiseInfo{}, 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		io.Copy(io.Discard, resp.Body)
+		return GreyNoiseInfo{Classification: GreyNoiseUnseen}, 0, nil
+	case http.StatusTooManyRequests:
+		retry := 10 * time.Minute
+		if seconds, err := time.ParseDuration(resp.Header.Get("Retry-After") + "s"); err == nil && seconds > 0 {
+			retry = seconds
+		}
+		return GreyNoiseInfo{}, retry, fmt.Errorf("rate limited, pausing lookups for %s", retry)
+	default:
+		return GreyNoiseInfo{}, 0, fmt.Errorf("%s returned %s", g.url, resp.Status)
+	}
+	var info GreyNoiseInfo
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&info); err != nil {
+		return GreyNoiseInfo{}, 0, err
+	}
+	if info.Classification == "" {
+		info.Classification = GreyNoiseUnseen
+	}
+	return info, 0, nil
+}
+
+// Close stops the lookups.
+func (g *GreyNoise) Close() {
+	g.once.Do(func() { close(g.stop) })
+}
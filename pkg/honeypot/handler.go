@@ -0,0 +1,197 @@
+package honeypot
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	mathrand "math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Handler emulates a protocol on an accepted connection. Serve returns when
+// the session is over; the caller closes the connection afterwards.
+type Handler interface {
+	Serve(ctx context.Context, conn net.Conn, meta *ConnMeta) error
+}
+
+// HandlerFunc adapts an ordinary function to the Handler interface.
+type HandlerFunc func(ctx context.Context, conn net.Conn, meta *ConnMeta) error
+
+// Serve calls f(ctx, conn, meta).
+func (f HandlerFunc) Serve(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	return f(ctx, conn, meta)
+}
+
+// ConnMeta describes the connection being served and the host the handler impersonates.
+type ConnMeta struct {
+	Session    string            // random identifier shared by all events of the connection
+	Port       string            // port the client targeted
+	ClientAddr string            // client address, as reported by the PROXY header when present
+	LocalAddr  string            // local address the client reached, see destinationAddr
+	Identity   *HostIdentity     // fake host shared by all personas on the address the client reached
+	Files      *DecoyFS          // decoy file system of that host
+	Stage      AttackStage       // furthest attack stage observed so far, see Observe
+	Labels     map[string]string // labels configured for the port, copied into every event
+	Limits     SessionLimits     // timeouts and read limits for the port
+	Reputation []FeedMatch       // reputation feeds listing the client, see Server.Reputation
+
+	// HandlerState is for the handler's own use. The datagrams of a UDP
+	// session share it, so packet handlers keep their session state here.
+	HandlerState any
+	Started      time.Time // when the connection was accepted
+
+	server      *Server
+	handler     string          // name of the handler serving the session
+	host        string          // FQDN of Identity when the server has virtual hosts, see Event.Host
+	received    int             // bytes received from the client, see countingConn
+	sent        int             // bytes sent to the client, see countingConn
+	firstSent   time.Time       // when the client was first sent anything, such as a banner
+	reset       bool            // the client reset the connection, as connect scans do
+	datagram    bool            // the session is a UDP sender's, see PacketListener
+	pendingUser string          // username waiting for its password, see LogCredentials
+	droppers    map[string]bool // payload URLs already reported, see logDropperURLs
+	withheld    int             // bytes of answers discarded in read-only mode, see Server.ReadOnly
+	honeytokens map[string]bool // honeytokens already reported, see checkHoneytokens
+	rand        *mathrand.Rand  // see Rand
+}
+
+// Emit fills in the connection details of ev and sends it to the server's outputs.
+func (m *ConnMeta) Emit(ev Event) {
+	ev.Session = m.Session
+	ev.Handler = m.handler
+	ev.Port = m.Port
+	ev.SrcAddr = m.ClientAddr
+	ev.DstAddr = m.LocalAddr
+	ev.Host = m.host
+	ev.Labels = m.Labels
+	if m.datagram && ev.Fields["transport"] == nil {
+		// Tells the events of forgeable UDP clients apart, see spoofable
+		fields := make(map[string]any, len(ev.Fields)+1)
+		maps.Copy(fields, ev.Fields)
+		fields["transport"] = "udp"
+		ev.Fields = fields
+	}
+	if data, ok := ev.Fields["data"].(string); ok && ev.Type == EventData {
+		// Payloads that are not printable text would not survive JSON or
+		// text logs as they are, see EventPayload
+		fields := maps.Clone(ev.Fields)
+		fields["data"], fields["data_encoding"] = encodePayload(data, m.server.DataEncoding)
+		ev.Fields = fields
+	}
+	if m.server.GreyNoise != nil {
+		if info, ok := m.server.GreyNoise.Lookup(m.ClientAddr); ok {
+			fields := make(map[string]any, len(ev.Fields)+1)
+			for key, value := range ev.Fields {
+				fields[key] = value
+			}
+			fields["greynoise"] = info
+			ev.Fields = fields
+		}
+	}
+	m.server.Emit(ev)
+	if m.server.honeytokens != nil && (ev.Type == EventData || ev.Type == EventCredential) {
+		m.checkHoneytokens(ev)
+	}
+}
+
+// Logf emits an informational event about this connection.
+func (m *ConnMeta) Logf(format string, args ...any) {
+	m.Emit(Event{Type: EventInfo, Message: fmt.Sprintf(format, args...)})
+}
+
+// LogData records data received from the client: it is logged, scanned for
+// login attempts and payload URLs and used to advance the session's attack
+// stage.
+func (m *ConnMeta) LogData(data string) {
+	m.Emit(Event{
+		Type:    EventData,
+		Message: fmt.Sprintf("Received data on port %s from %s: %s", m.Port, m.ClientAddr, escapePayload(data)),
+		Fields:  map[string]any{"data": data},
+	})
+	m.LogCredentials(data)
+	m.logDropperURLs(data)
+	m.Observe(data)
+}
+
+var (
+	handlers   = map[string]Handler{} // registry of protocol handlers by name
+	handlersMu sync.RWMutex
+)
+
+// RegisterHandler makes a handler available for assignment to ports.
+// In-tree handlers and plugins call it from an init function. It panics if
+// name is taken, see AddHandler.
+func RegisterHandler(name string, h Handler) {
+	if err := AddHandler(name, h); err != nil {
+		panic(err.Error())
+	}
+}
+
+// AddHandler is RegisterHandler returning an error when name is taken
+// instead of panicking, for plugins and other handlers added at run time,
+// whose names may clash with those of the build they are loaded into.
+func AddHandler(name string, h Handler) error {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	if _, exists := handlers[name]; exists {
+		return fmt.Errorf("handler registered twice: %s", name)
+	}
+	handlers[name] = h
+	return nil
+}
+
+// LookupHandler returns the handler registered under name.
+func LookupHandler(name string) (Handler, bool) {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	h, ok := handlers[name]
+	return h, ok
+}
+
+// HandlerNames returns the names of the registered TCP and UDP handlers in sorted order.
+func HandlerNames() []string {
+	handlersMu.RLock()
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	handlersMu.RUnlock()
+	for _, name := range packetHandlerNames() {
+		if _, ok := LookupHandler(name); !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseHandlerMap parses "port-spec=handler" assignments separated by ';',
+// for example "23=telnet;8000-8100,!8080=http", into a handler name per port.
+func ParseHandlerMap(spec string) (map[string]string, error) {
+	assignments := make(map[string]string)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		portSpec, name, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid handler assignment %q, want ports=handler", entry)
+		}
+		if !HandlerRegistered(name) {
+			return nil, fmt.Errorf("unknown handler %q (available: %s)", name, strings.Join(HandlerNames(), ", "))
+		}
+		ports, invalid := ParsePortSpec(portSpec)
+		if len(invalid) > 0 {
+			return nil, fmt.Errorf("invalid ports in handler assignment %q: %s", entry, strings.Join(invalid, ", "))
+		}
+		for _, port := range ports {
+			assignments[port] = name
+		}
+	}
+	return assignments, nil
+}
@@ -0,0 +1,68 @@
+package honeypot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+func init() {
+	RegisterHandler("banner", HandlerFunc(serveBanner))
+}
+
+// sshPorts are the ports the banner handler greets with the SSH
+// identification string of the host instead of an authentication failure,
+// as SSH clients wait for it before sending anything.
+var sshPorts = map[string]bool{"22": true, "2222": true}
+
+// serveBanner is the default handler: it rejects the client with a generic
+// authentication failure, or greets it with the SSH banner of the host on
+// SSH ports, after the host's legal notice if it has one, then logs
+// everything it sends back until the client goes quiet, hangs up or a
+// session limit is reached. Attackers often send the interesting payload
+// only in their second or third packet.
+func serveBanner(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	greeting := "Authentication failed.\n"
+	if sshPorts[meta.Port] {
+		greeting = meta.Identity.SSHBanner() + "\r\n"
+	}
+	if _, err := conn.Write([]byte(meta.Identity.LegalBanner("") + greeting)); err != nil {
+		return fmt.Errorf("writing to connection: %w", err)
+	}
+
+	// Read and log client data chunk by chunk
+	buffer := make([]byte, meta.Limits.ReadBuffer)
+	total := 0
+	for {
+		conn.SetReadDeadline(meta.NextReadDeadline(total > 0))
+		n, err := conn.Read(buffer[:min(len(buffer), meta.Limits.MaxBytes-total)])
+		if n > 0 {
+			total += n
+			meta.LogData(string(buffer[:n]))
+		}
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case errors.Is(err, os.ErrDeadlineExceeded) && meta.SessionExpired():
+			meta.Logf("Closing session on port %s from %s: maximum duration of %s reached", meta.Port, meta.ClientAddr, meta.Limits.SessionTimeout)
+			return nil
+		case errors.Is(err, os.ErrDeadlineExceeded) && total == 0:
+			meta.Logf("Closing session on port %s from %s: no data within %s", meta.Port, meta.ClientAddr, meta.Limits.ConnectTimeout)
+			return nil
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			meta.Logf("Closing session on port %s from %s: idle for %s", meta.Port, meta.ClientAddr, meta.Limits.IdleTimeout)
+			return nil
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil // shutting down
+			}
+			return fmt.Errorf("reading from connection: %w", err)
+		case total >= meta.Limits.MaxBytes:
+			meta.Logf("Closing session on port %s from %s: %d bytes received", meta.Port, meta.ClientAddr, total)
+			return nil
+		}
+	}
+}
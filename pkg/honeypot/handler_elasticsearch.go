@@ -0,0 +1,372 @@
+package honeypot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterHandler("elasticsearch", HandlerFunc(serveElasticsearch))
+}
+
+// ElasticsearchIndex is an index listed by the elasticsearch handler.
+type ElasticsearchIndex struct {
+	Name   string `json:"name"`
+	Docs   int64  `json:"docs"`
+	SizeMB int64  `json:"size_mb"` // primary store size
+}
+
+// ElasticsearchCluster is the cluster impersonated by the elasticsearch
+// handler. Empty names are derived from the server's host identity.
+type ElasticsearchCluster struct {
+	ClusterName string               `json:"cluster_name,omitempty"`
+	NodeName    string               `json:"node_name,omitempty"`
+	Version     string               `json:"version,omitempty"`
+	Indices     []ElasticsearchIndex `json:"indices,omitempty"`
+}
+
+// DefaultElasticsearchCluster returns a 7.17 cluster holding the kind of
+// data ransom campaigns look for.
+func DefaultElasticsearchCluster() *ElasticsearchCluster {
+	return &ElasticsearchCluster{
+		Version: "7.17.9",
+		Indices: []ElasticsearchIndex{
+			{"customers", 1843207, 1126},
+			{"users_v2", 412930, 287},
+			{"orders-2024", 9370112, 4608},
+			{"payment_transactions", 5120884, 3390},
+			{"crm_contacts", 228410, 131},
+			{"app-logs-2024.05", 48213009, 19456},
+			{".kibana_1", 212, 1},
+		},
+	}
+}
+
+// LoadElasticsearchCluster reads a cluster profile in JSON on top of
+// DefaultElasticsearchCluster; its indices replace the default ones.
+func LoadElasticsearchCluster(path string) (*ElasticsearchCluster, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cluster := DefaultElasticsearchCluster()
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cluster); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i, index := range cluster.Indices {
+		if index.Name == "" || strings.ContainsAny(index.Name, ` "*,/\|<>?`) {
+			return nil, fmt.Errorf("%s: indices[%d]: invalid index name %q", path, i, index.Name)
+		}
+	}
+	return cluster, nil
+}
+
+// esSession is the state of an Elasticsearch connection: deletions and
+// writes are only seen by the client that made them.
+type esSession struct {
+	meta    *ConnMeta
+	cluster *ElasticsearchCluster
+	deleted map[string]bool
+	created map[string]int64 // indices created by the client, with their documents
+}
+
+// serveElasticsearch impersonates an open Elasticsearch node, the target of
+// ransom campaigns that delete every index and leave a note, and of
+// cryptominers exploiting the old scripting RCEs. It answers the root
+// document, the _cat APIs, cluster health, searches, deletes and document
+// writes, and logs every request with its body. Deleting indices raises an
+// elasticsearch_delete alert and search scripts an elasticsearch_script alert.
+func serveElasticsearch(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	cluster := meta.server.Elasticsearch
+	if cluster == nil {
+		cluster = DefaultElasticsearchCluster()
+	}
+	s := &esSession{meta: meta, cluster: cluster, deleted: make(map[string]bool), created: make(map[string]int64)}
+	return serveHTTPPersona(ctx, conn, meta, "Elasticsearch", func(req *http.Request, body []byte) httpReply {
+		status, resp := s.handle(req, body)
+		return httpReply{Status: status, Headers: [][2]string{{"X-elastic-product", "Elasticsearch"}, {"content-type", esContentType(resp)}}, Body: resp}
+	})
+}
+
+func esContentType(resp []byte) string {
+	if len(resp) > 0 && (resp[0] == '{' || resp[0] == '[') {
+		return "application/json; charset=UTF-8"
+	}
+	return "text/plain; charset=UTF-8"
+}
+
+// handle returns the status and body of the response to a request.
+func (s *esSession) handle(req *http.Request, body []byte) (int, []byte) {
+	urlPath := path.Clean("/" + req.URL.Path)
+	parts := strings.Split(strings.Trim(urlPath, "/"), "/")
+	if parts[0] == "" {
+		parts = nil
+	}
+	query := req.URL.Query()
+	switch {
+	case len(parts) == 0:
+		if req.Method == http.MethodDelete {
+			return s.deleteIndices("_all")
+		}
+		return http.StatusOK, s.root()
+	case parts[0] == "_cat" && len(parts) > 1 && parts[1] == "indices":
+		return http.StatusOK, s.catIndices(query)
+	case parts[0] == "_cat" && len(parts) > 1 && parts[1] == "health":
+		return http.StatusOK, []byte(fmt.Sprintf("%d %s %s green 1 1 %d %d 0 0 0 0 - 100.0%%\n",
+			time.Now().Unix(), time.Now().UTC().Format("15:04:05"), s.clusterName(), len(s.indices()), len(s.indices())))
+	case parts[0] == "_cluster" && len(parts) > 1 && parts[1] == "health":
+		return http.StatusOK, esJSON(map[string]any{
+			"cluster_name": s.clusterName(), "status": "green", "timed_out": false, "number_of_nodes": 1,
+			"number_of_data_nodes": 1, "active_primary_shards": len(s.indices()), "active_shards": len(s.indices()),
+			"relocating_shards": 0, "initializing_shards": 0, "unassigned_shards": 0, "active_shards_percent_as_number": 100.0,
+		})
+	case parts[len(parts)-1] == "_search" || strings.HasSuffix(parts[len(parts)-1], "_search"):
+		return s.search(parts, body)
+	case len(parts) >= 2 && (parts[1] == "_doc" || parts[1] == "_create"):
+		return s.write(parts)
+	case strings.HasPrefix(parts[0], "_"):
+		return http.StatusBadRequest, esError(http.StatusBadRequest, "illegal_argument_exception",
+			fmt.Sprintf("request [%s] contains unrecognized parameter: [%s]", urlPath, parts[0]))
+	case req.Method == http.MethodDelete && len(parts) == 1:
+		return s.deleteIndices(parts[0])
+	case len(parts) == 1 && (req.Method == http.MethodPut || req.Method == http.MethodPost):
+		s.created[parts[0]] = 0
+		delete(s.deleted, parts[0])
+		return http.StatusOK, esJSON(map[string]any{"acknowledged": true, "shards_acknowledged": true, "index": parts[0]})
+	case len(parts) == 1:
+		if _, ok := s.index(parts[0]); ok {
+			return http.StatusOK, esJSON(map[string]any{parts[0]: map[string]any{
+				"aliases": map[string]any{}, "mappings": map[string]any{},
+				"settings": map[string]any{"index": map[string]any{"number_of_shards": "1", "number_of_replicas": "1", "provided_name": parts[0]}},
+			}})
+		}
+	}
+	name := parts[0]
+	return http.StatusNotFound, esError(http.StatusNotFound, "index_not_found_exception", "no such index ["+name+"]")
+}
+
+func (s *esSession) clusterName() string {
+	if s.cluster.ClusterName != "" {
+		return s.cluster.ClusterName
+	}
+	domain, _, _ := strings.Cut(s.meta.Identity.Domain, ".")
+	return domain + "-prod"
+}
+
+func (s *esSession) nodeName() string {
+	if s.cluster.NodeName != "" {
+		return s.cluster.NodeName
+	}
+	return strings.ToLower(s.meta.Identity.Hostname)
+}
+
+// esID returns a stable base64 identifier like the UUIDs of clusters and indices.
+func (s *esSession) esID(name string) string {
+	sum := sha1.Sum([]byte(s.meta.Identity.FQDN() + "/" + name))
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+func (s *esSession) root() []byte {
+	version := s.cluster.Version
+	hash := sha1.Sum([]byte(version))
+	return esJSON(map[string]any{
+		"name":         s.nodeName(),
+		"cluster_name": s.clusterName(),
+		"cluster_uuid": s.esID("cluster"),
+		"version": map[string]any{
+			"number": version, "build_flavor": "default", "build_type": "deb", "build_hash": hex.EncodeToString(hash[:]),
+			"build_date":     "2023-01-31T05:34:43.305517834Z",
+			"build_snapshot": false, "lucene_version": "8.11.1",
+			"minimum_wire_compatibility_version": "6.8.0", "minimum_index_compatibility_version": "6.0.0-beta1",
+		},
+		"tagline": "You Know, for Search",
+	})
+}
+
+// indices returns the indices the client sees, with the ones it created.
+func (s *esSession) indices() []ElasticsearchIndex {
+	var indices []ElasticsearchIndex
+	for _, index := range s.cluster.Indices {
+		if !s.deleted[index.Name] {
+			indices = append(indices, index)
+		}
+	}
+	for name, docs := range s.created {
+		if !slices.ContainsFunc(indices, func(i ElasticsearchIndex) bool { return i.Name == name }) {
+			indices = append(indices, ElasticsearchIndex{Name: name, Docs: docs})
+		}
+	}
+	slices.SortFunc(indices, func(a, b ElasticsearchIndex) int { return strings.Compare(a.Name, b.Name) })
+	return indices
+}
+
+func (s *esSession) index(name string) (ElasticsearchIndex, bool) {
+	for _, index := range s.indices() {
+		if index.Name == name {
+			return index, true
+		}
+	}
+	return ElasticsearchIndex{}, false
+}
+
+// catIndices lists the indices as a table, or JSON with format=json.
+func (s *esSession) catIndices(query map[string][]string) []byte {
+	var rows []map[string]string
+	for _, index := range s.indices() {
+		size, total := esSize(index.SizeMB), esSize(index.SizeMB*2)
+		if index.SizeMB == 0 {
+			size, total = "4.5kb", "9kb"
+		}
+		rows = append(rows, map[string]string{
+			"health": "green", "status": "open", "index": index.Name, "uuid": s.esID(index.Name)[:22],
+			"pri": "1", "rep": "1", "docs.count": strconv.FormatInt(index.Docs, 10), "docs.deleted": "0",
+			"store.size": total, "pri.store.size": size,
+		})
+	}
+	if slices.Contains(query["format"], "json") {
+		if rows == nil {
+			rows = []map[string]string{}
+		}
+		return esJSON(rows)
+	}
+	columns := []string{"health", "status", "index", "uuid", "pri", "rep", "docs.count", "docs.deleted", "store.size", "pri.store.size"}
+	widths := make([]int, len(columns))
+	_, verbose := query["v"]
+	for i, column := range columns {
+		if verbose {
+			widths[i] = len(column)
+		}
+		for _, row := range rows {
+			widths[i] = max(widths[i], len(row[column]))
+		}
+	}
+	var b strings.Builder
+	line := func(value func(string) string) {
+		for i, column := range columns {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			fmt.Fprintf(&b, "%-*s", widths[i], value(column))
+		}
+		b.WriteByte('\n')
+	}
+	if verbose {
+		line(func(column string) string { return column })
+	}
+	for _, row := range rows {
+		line(func(column string) string { return row[column] })
+	}
+	return []byte(b.String())
+}
+
+// search answers a search with no hits but the totals of the indices, and
+// raises an alert for scripts, the vector of CVE-2014-3120 and CVE-2015-1427.
+func (s *esSession) search(parts []string, body []byte) (int, []byte) {
+	target := "_all"
+	if len(parts) > 1 {
+		target = parts[0]
+	}
+	if bytes.Contains(body, []byte(`"script`)) {
+		if s.meta.Stage < StageExploit {
+			s.meta.Stage = StageExploit
+		}
+		s.meta.RaiseAlert("high", "elasticsearch_script", fmt.Sprintf("search script sent to port %s from %s: %s", s.meta.Port, s.meta.ClientAddr, body))
+	}
+	var docs int64
+	shards := 0
+	for _, index := range s.indices() {
+		if target == "_all" || target == "*" || strings.Contains(","+target+",", ","+index.Name+",") || (strings.HasSuffix(target, "*") && strings.HasPrefix(index.Name, strings.TrimSuffix(target, "*"))) {
+			docs += index.Docs
+			shards++
+		}
+	}
+	if shards == 0 && target != "_all" && target != "*" {
+		return http.StatusNotFound, esError(http.StatusNotFound, "index_not_found_exception", "no such index ["+target+"]")
+	}
+	relation := "eq"
+	if docs > 10000 {
+		docs, relation = 10000, "gte"
+	}
+	return http.StatusOK, esJSON(map[string]any{
+		"took": 3 + shards*2, "timed_out": false,
+		"_shards": map[string]any{"total": shards, "successful": shards, "skipped": 0, "failed": 0},
+		"hits":    map[string]any{"total": map[string]any{"value": docs, "relation": relation}, "max_score": nil, "hits": []any{}},
+	})
+}
+
+// deleteIndices acknowledges the deletion of indices, e.g. "_all" or "*",
+// and raises an alert: ransom campaigns delete everything before leaving
+// their note.
+func (s *esSession) deleteIndices(target string) (int, []byte) {
+	var names []string
+	for _, index := range s.indices() {
+		for _, pattern := range strings.Split(target, ",") {
+			if matched, _ := path.Match(pattern, index.Name); matched || pattern == "_all" {
+				names = append(names, index.Name)
+				break
+			}
+		}
+	}
+	if len(names) == 0 {
+		return http.StatusNotFound, esError(http.StatusNotFound, "index_not_found_exception", "no such index ["+target+"]")
+	}
+	for _, name := range names {
+		s.deleted[name] = true
+		delete(s.created, name)
+	}
+	s.meta.RaiseAlert("high", "elasticsearch_delete", fmt.Sprintf("%d indices deleted on port %s from %s: %s", len(names), s.meta.Port, s.meta.ClientAddr, strings.Join(names, ", ")))
+	return http.StatusOK, esJSON(map[string]any{"acknowledged": true})
+}
+
+// write acknowledges a document written to an index, creating it if needed.
+func (s *esSession) write(parts []string) (int, []byte) {
+	name := parts[0]
+	id := s.esID(name + "/" + s.meta.randomID())[:20]
+	if len(parts) > 2 {
+		id = parts[2]
+	}
+	if _, ok := s.index(name); !ok {
+		s.created[name] = 0
+		delete(s.deleted, name)
+	}
+	if _, ok := s.created[name]; ok {
+		s.created[name]++
+	}
+	return http.StatusCreated, esJSON(map[string]any{
+		"_index": name, "_type": "_doc", "_id": id, "_version": 1, "result": "created",
+		"_shards": map[string]any{"total": 2, "successful": 1, "failed": 0}, "_seq_no": 0, "_primary_term": 1,
+	})
+}
+
+// esSize formats a size like the _cat APIs.
+func esSize(mb int64) string {
+	if mb >= 1024 {
+		return strconv.FormatFloat(float64(mb)/1024, 'f', 1, 64) + "gb"
+	}
+	return strconv.FormatInt(mb, 10) + "mb"
+}
+
+func esError(status int, kind, reason string) []byte {
+	cause := map[string]any{"type": kind, "reason": reason}
+	return esJSON(map[string]any{"error": map[string]any{"root_cause": []any{cause}, "type": kind, "reason": reason}, "status": status})
+}
+
+func esJSON(v any) []byte {
+	b, _ := json.Marshal(v)
+	return b
+}
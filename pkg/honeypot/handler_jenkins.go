@@ -0,0 +1,210 @@
+package honeypot
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	RegisterHandler("jenkins", HandlerFunc(serveJenkins))
+}
+
+// jenkinsVersion is the impersonated Jenkins LTS release, unless the host
+// identity lists another one.
+const jenkinsVersion = "2.387.1"
+
+// groovyExecute matches the commands Groovy scripts run with "...".execute().
+var groovyExecute = regexp.MustCompile(`["']([^"']+)["']\s*\.execute\(\)`)
+
+// jenkinsSession is the state of one connection to the jenkins handler.
+type jenkinsSession struct {
+	meta    *ConnMeta
+	version string
+	cookie  string // JSESSIONID cookie name, per host
+	crumb   string
+}
+
+// serveJenkins impersonates a Jenkins controller whose script console is
+// reachable without logging in, a misconfiguration Java RCE scanners and
+// cryptominer campaigns look for. It serves the dashboard, the login form,
+// whose credentials are logged, the crumb issuer, the JSON API and the
+// script console. Groovy scripts sent to the console or to the Script
+// Security checks (CVE-2019-1003000) raise a jenkins_script alert and get
+// plausible output for the usual reconnaissance commands; CLI payloads
+// (CVE-2017-1000353) raise java_deserialization.
+func serveJenkins(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	id := meta.Identity
+	s := &jenkinsSession{meta: meta, version: jenkinsVersion}
+	if v := id.Software["jenkins"]; v != "" {
+		s.version = v
+	}
+	sum := sha256.Sum256([]byte(id.FQDN() + "/jenkins"))
+	s.cookie = "JSESSIONID." + hex.EncodeToString(sum[:4])
+	s.crumb = hex.EncodeToString(sum[4:20]) + meta.randomID() + meta.randomID()
+	return serveHTTPPersona(ctx, conn, meta, "Jenkins", s.handle)
+}
+
+func (s *jenkinsSession) headers(extra ...[2]string) [][2]string {
+	sum := sha256.Sum256([]byte(s.meta.Identity.FQDN() + "/jenkins/identity"))
+	headers := [][2]string{
+		httpDate(),
+		{"X-Content-Type-Options", "nosniff"},
+		{"Content-Type", "text/html;charset=utf-8"},
+		{"Expires", "Thu, 01 Jan 1970 00:00:00 GMT"},
+		{"Cache-Control", "no-cache,no-store,must-revalidate"},
+		{"X-Hudson-Theme", "default"},
+		{"Referrer-Policy", "same-origin"},
+		{"Cross-Origin-Opener-Policy", "same-origin"},
+		{"Set-Cookie", s.cookie + "=node0" + s.meta.randomID() + ".node0; Path=/; HttpOnly"},
+		{"X-Hudson", "1.395"},
+		{"X-Jenkins", s.version},
+		{"X-Jenkins-Session", hex.EncodeToString(sum[:4])},
+		{"X-Frame-Options", "sameorigin"},
+		{"X-Instance-Identity", "MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA" + base64.StdEncoding.EncodeToString(sum[:])},
+		{"Server", "Jetty(10.0.13)"},
+	}
+	for _, h := range extra {
+		for i := range headers {
+			if headers[i][0] == h[0] {
+				headers[i][1] = h[1]
+				h[0] = ""
+			}
+		}
+		if h[0] != "" {
+			headers = append(headers, h)
+		}
+	}
+	return headers
+}
+
+func (s *jenkinsSession) page(status int, title, content string) httpReply {
+	body := fmt.Sprintf(`<!DOCTYPE html><html class=""><head resURL="/static/%[1]s" data-rooturl="" data-resurl="/static/%[1]s" data-imagesurl="/static/%[1]s/images" data-crumb-header="Jenkins-Crumb" data-crumb-value="%[2]s">
+<title>%[3]s [Jenkins]</title><link rel="stylesheet" href="/static/%[1]s/jsbundles/styles.css" type="text/css"></head>
+<body id="jenkins" class="yui-skin-sam two-column jenkins-%[4]s" data-version="%[4]s">
+<header id="page-header" class="page-header"><div class="page-header__brand"><a id="jenkins-home-link" href="/"><span class="jenkins-mobile-hide">Jenkins</span></a></div></header>
+<div id="page-body" class="app-page-body">%[5]s</div>
+<footer class="page-footer"><div class="page-footer__links"><a rel="noopener noreferrer" href="https://www.jenkins.io/" target="_blank">Jenkins %[4]s</a></div></footer></body></html>`,
+		s.crumb[:8], s.crumb, html.EscapeString(title), s.version, content)
+	return httpReply{Status: status, Headers: s.headers(), Body: []byte(body)}
+}
+
+// jobs returns the jobs listed on the dashboard.
+func (s *jenkinsSession) jobs() []string {
+	domain, _, _ := strings.Cut(s.meta.Identity.Domain, ".")
+	return []string{domain + "-api-build", domain + "-web-deploy-prod", "db-backup-nightly", "terraform-apply"}
+}
+
+func (s *jenkinsSession) handle(req *http.Request, body []byte) httpReply {
+	urlPath := path.Clean("/" + req.URL.Path)
+	form := httpForm(body)
+	switch {
+	case urlPath == "/":
+		var rows strings.Builder
+		for _, job := range s.jobs() {
+			fmt.Fprintf(&rows, `<tr id="job_%s" class=" job-status-blue"><td><a href="job/%[1]s/" class="jenkins-table__link model-link inside"><span>%[1]s</span></a></td><td>1 day 4 hr - <a href="job/%[1]s/lastSuccessfulBuild/">#%d</a></td></tr>`,
+				job, 40+len(job)*7)
+		}
+		return s.page(http.StatusOK, "Dashboard", `<div id="main-panel"><h1>Welcome to Jenkins!</h1><table id="projectstatus" class="jenkins-table sortable">`+rows.String()+`</table></div>`)
+	case urlPath == "/login":
+		return s.page(http.StatusOK, "Sign in", `<div class="simple-page"><form method="post" name="login" action="j_spring_security_check">
+<input autocomplete="username" name="j_username" id="j_username" type="text"><input autocomplete="current-password" name="j_password" id="j_password" type="password">
+<input name="from" type="hidden"><button type="submit" name="Submit" class="jenkins-button jenkins-button--primary">Sign in</button></form></div>`)
+	case urlPath == "/j_spring_security_check" || urlPath == "/j_acegi_security_check":
+		s.meta.LogCredential(Credential{Username: form.Get("j_username"), Password: form.Get("j_password")})
+		return httpReply{Status: http.StatusFound, Headers: s.headers([2]string{"Location", "/loginError"})}
+	case urlPath == "/loginError":
+		return s.page(http.StatusUnauthorized, "Sign in", `<div class="simple-page"><div class="app-sign-in-register__error">Invalid username or password</div></div>`)
+	case urlPath == "/crumbIssuer/api/json":
+		return s.json(fmt.Sprintf(`{"_class":"hudson.security.csrf.DefaultCrumbIssuer","crumb":"%s","crumbRequestField":"Jenkins-Crumb"}`, s.crumb))
+	case urlPath == "/api/json":
+		var jobs []string
+		for _, job := range s.jobs() {
+			jobs = append(jobs, fmt.Sprintf(`{"_class":"hudson.model.FreeStyleProject","name":"%s","url":"http://%s/job/%[1]s/","color":"blue"}`, job, req.Host))
+		}
+		return s.json(fmt.Sprintf(`{"_class":"hudson.model.Hudson","assignedLabels":[{"name":"built-in"}],"mode":"NORMAL","nodeDescription":"the Jenkins controller's built-in node","nodeName":"","numExecutors":2,"description":null,"jobs":[%s],"quietingDown":false,"slaveAgentPort":50000,"useCrumbs":true,"useSecurity":true,"views":[{"_class":"hudson.model.AllView","name":"all","url":"http://%s/"}]}`,
+			strings.Join(jobs, ","), req.Host))
+	case urlPath == "/whoAmI":
+		return s.page(http.StatusOK, "Who Am I", `<div id="main-panel"><h1>Who Am I?</h1><table><tr><td>Name:</td><td>anonymous</td></tr><tr><td>IsAuthenticated?:</td><td>false</td></tr><tr><td>Authorities:</td><td><ul><li>"anonymous"</li></ul></td></tr></table></div>`)
+	case urlPath == "/script" && req.Method == http.MethodPost:
+		output := s.runScript(form.Get("script"), urlPath)
+		return s.page(http.StatusOK, "Script Console", s.consoleForm(form.Get("script"))+`<h2>Result</h2><pre>`+html.EscapeString(output)+`</pre>`)
+	case urlPath == "/script" || urlPath == "/manage/script":
+		return s.page(http.StatusOK, "Script Console", s.consoleForm(""))
+	case urlPath == "/scriptText" || urlPath == "/manage/scriptText":
+		output := s.runScript(form.Get("script"), urlPath)
+		return httpReply{Status: http.StatusOK, Headers: s.headers([2]string{"Content-Type", "text/plain;charset=utf-8"}), Body: []byte(output)}
+	case strings.Contains(urlPath, "/checkScript") || strings.Contains(urlPath, "/checkPipelineScript") || strings.Contains(urlPath, "/checkScriptCompile"):
+		script := req.URL.Query().Get("value")
+		if script == "" {
+			script = form.Get("value")
+		}
+		s.runScript(script, urlPath)
+		return httpReply{Status: http.StatusOK, Headers: s.headers(), Body: []byte("<div/>")}
+	case urlPath == "/cli":
+		// The download and upload halves of a CLI connection over HTTP
+		return httpReply{Status: http.StatusOK, Headers: s.headers([2]string{"Content-Type", "application/octet-stream"})}
+	}
+	return s.page(http.StatusNotFound, "Not Found", `<div id="main-panel"><h1>Not Found</h1><p>The requested page could not be found.</p></div>`)
+}
+
+func (s *jenkinsSession) json(body string) httpReply {
+	return httpReply{Status: http.StatusOK, Headers: s.headers([2]string{"Content-Type", "application/json;charset=utf-8"}), Body: []byte(body)}
+}
+
+func (s *jenkinsSession) consoleForm(script string) string {
+	return `<div id="main-panel"><h1>Script Console</h1><p>Type in an arbitrary <a href="https://www.groovy-lang.org">Groovy script</a> and execute it on the server.</p>
+<form action="script" method="post"><textarea id="script" name="script" class="script">` + html.EscapeString(script) + `</textarea>
+<input name="Jenkins-Crumb" type="hidden" value="` + s.crumb + `"><div align="right"><button name="Submit" class="jenkins-button jenkins-button--primary">Run</button></div></form></div>`
+}
+
+// runScript raises the alert of a Groovy script submitted to path and
+// returns its pretended output: that of the commands it executes, if known
+// or generated by the server's Responder.
+func (s *jenkinsSession) runScript(script, urlPath string) string {
+	if script == "" {
+		return ""
+	}
+	meta := s.meta
+	if meta.Stage < StageExploit {
+		meta.Stage = StageExploit
+	}
+	meta.RaiseAlert("high", "jenkins_script", fmt.Sprintf("Groovy script sent to %s on port %s from %s: %s", urlPath, meta.Port, meta.ClientAddr, script))
+	id := meta.Identity
+	var out strings.Builder
+	for _, match := range groovyExecute.FindAllStringSubmatch(script, -1) {
+		command := strings.Fields(match[1])
+		if len(command) == 0 {
+			continue
+		}
+		switch path.Base(command[0]) {
+		case "id":
+			out.WriteString("uid=115(jenkins) gid=121(jenkins) groups=121(jenkins)\n")
+		case "whoami":
+			out.WriteString("jenkins\n")
+		case "hostname":
+			out.WriteString(id.Hostname + "\n")
+		case "uname":
+			if len(command) > 1 && strings.Contains(command[1], "a") {
+				fmt.Fprintf(&out, "Linux %s %s #115-Ubuntu SMP x86_64 x86_64 x86_64 GNU/Linux\n", id.Hostname, id.Kernel)
+			} else {
+				out.WriteString("Linux\n")
+			}
+		case "pwd":
+			out.WriteString("/var/lib/jenkins\n")
+		default:
+			if output, ok := meta.respond(ResponderShell, match[1]); ok {
+				out.WriteString(output)
+			}
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,509 @@
+package honeypot
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+func init() {
+	RegisterHandler("ldap", HandlerFunc(serveLDAP))
+}
+
+// LDAP protocol operations (RFC 4511), as BER application tags.
+const (
+	ldapBindRequest      = 0x60
+	ldapBindResponse     = 0x61
+	ldapUnbindRequest    = 0x42
+	ldapSearchRequest    = 0x63
+	ldapSearchEntry      = 0x64
+	ldapSearchDone       = 0x65
+	ldapModifyRequest    = 0x66
+	ldapAddRequest       = 0x68
+	ldapDelRequest       = 0x4A
+	ldapModDNRequest     = 0x6C
+	ldapCompareRequest   = 0x6E
+	ldapAbandonRequest   = 0x50
+	ldapExtendedRequest  = 0x77
+	ldapExtendedResponse = 0x78
+)
+
+// LDAP result codes.
+const (
+	ldapSuccess            = 0
+	ldapProtocolError      = 2
+	ldapNoSuchObject       = 32
+	ldapInsufficientAccess = 50
+	ldapUnwillingToPerform = 53
+)
+
+const berEnumerated = 0x0A
+
+// ldapMaxMessage bounds the size of one LDAP message.
+const ldapMaxMessage = 1 << 20
+
+// ldapResponses are the responses to the update operations, which are all refused.
+var ldapResponses = map[byte]byte{
+	ldapModifyRequest: 0x67, ldapAddRequest: 0x69, ldapDelRequest: 0x6B, ldapModDNRequest: 0x6D, ldapCompareRequest: 0x6F,
+}
+
+// ldapOpNames name the operations in events.
+var ldapOpNames = map[byte]string{
+	ldapBindRequest: "bind", ldapUnbindRequest: "unbind", ldapSearchRequest: "search", ldapModifyRequest: "modify",
+	ldapAddRequest: "add", ldapDelRequest: "delete", ldapModDNRequest: "modrdn", ldapCompareRequest: "compare",
+	ldapAbandonRequest: "abandon", ldapExtendedRequest: "extended",
+}
+
+// ldapSession is one connection to the ldap handler.
+type ldapSession struct {
+	conn   net.Conn
+	meta   *ConnMeta
+	suffix string // naming context of the directory, e.g. "dc=corp,dc=local"
+}
+
+// serveLDAP impersonates an LDAP directory, OpenLDAP or, on Windows
+// profiles, Active Directory. Binds are accepted and their DNs and
+// passwords logged as credentials; searches are logged with their filters
+// and the root DSE is answered. A base object search for a name outside the
+// directory is what a JNDI lookup by a Java application looks like, the
+// callback of a log4shell payload pointing at this host: it raises a
+// log4shell_callback alert, and commands in the path formats of JNDI
+// exploit kits, such as Basic/Command/Base64/..., are decoded and scanned
+// for payload URLs. No Java object is ever returned.
+func serveLDAP(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	s := &ldapSession{conn: conn, meta: meta, suffix: ldapSuffix(meta.Identity.Domain)}
+	reader := bufio.NewReaderSize(conn, meta.Limits.ReadBuffer)
+	for received := false; ; received = true {
+		conn.SetReadDeadline(meta.NextReadDeadline(received))
+		raw, err := readBERMessage(reader, ldapMaxMessage)
+		if err != nil {
+			if errors.Is(err, errBER) {
+				meta.LogData(string(raw))
+				return nil
+			}
+			return binaryReadError(ctx, meta, raw, err, received)
+		}
+		id, op, content, err := parseLDAPMessage(raw)
+		if err != nil {
+			meta.LogData(string(raw))
+			return nil
+		}
+		reply, done := s.handle(id, op, content)
+		if len(reply) > 0 {
+			if _, err := conn.Write(reply); err != nil {
+				return fmt.Errorf("writing to connection: %w", err)
+			}
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// readBERMessage reads one BER value, tag, length and content, of at most
+// limit bytes. Values that are not BER end with errBER and what was read.
+func readBERMessage(r *bufio.Reader, limit int) ([]byte, error) {
+	header, err := r.Peek(2)
+	if err != nil {
+		return header, err
+	}
+	if header[0] != berSequence {
+		raw, _ := r.Peek(r.Buffered())
+		return raw, errBER
+	}
+	size := 2
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7F
+		if n == 0 || n > 4 {
+			raw, _ := r.Peek(r.Buffered())
+			return raw, errBER
+		}
+		if header, err = r.Peek(2 + n); err != nil {
+			return header, err
+		}
+		length = 0
+		for _, b := range header[2:] {
+			length = length<<8 | int(b)
+		}
+		size += n
+	}
+	if length > limit {
+		raw, _ := r.Peek(r.Buffered())
+		return raw, errBER
+	}
+	raw := make([]byte, size+length)
+	n, err := io.ReadFull(r, raw)
+	return raw[:n], err
+}
+
+// parseLDAPMessage splits an LDAPMessage into its message ID, protocol
+// operation tag and operation content. Controls are ignored.
+func parseLDAPMessage(raw []byte) (id int, op byte, content []byte, err error) {
+	tag, msg, _, err := readTLV(raw)
+	if err != nil || tag != berSequence {
+		return 0, 0, nil, errBER
+	}
+	tag, idContent, msg, err := readTLV(msg)
+	if err != nil || tag != berInteger {
+		return 0, 0, nil, errBER
+	}
+	if id, err = parseBERInt(idContent); err != nil {
+		return 0, 0, nil, err
+	}
+	if op, content, _, err = readTLV(msg); err != nil {
+		return 0, 0, nil, err
+	}
+	return id, op, content, nil
+}
+
+// handle answers one operation. done is set when the client unbinds.
+func (s *ldapSession) handle(id int, op byte, content []byte) (reply []byte, done bool) {
+	switch op {
+	case ldapBindRequest:
+		return s.bind(id, content), false
+	case ldapSearchRequest:
+		return s.search(id, content), false
+	case ldapUnbindRequest:
+		s.logOp(op, "", nil)
+		return nil, true
+	case ldapAbandonRequest:
+		return nil, false
+	case ldapExtendedRequest:
+		name := ""
+		if tag, oid, _, err := readTLV(content); err == nil && tag == 0x80 {
+			name = string(oid)
+		}
+		s.logOp(op, name, map[string]any{"ldap_extended": name})
+		// StartTLS and the password modify and whoami operations are refused
+		return ldapMessage(id, ldapExtendedResponse, ldapResult(ldapProtocolError, "", "unsupported extended operation")), false
+	}
+	if response, ok := ldapResponses[op]; ok {
+		dn := ""
+		if tag, name, _, err := readTLV(content); err == nil && tag == berOctetString {
+			dn = string(name)
+		} else if op == ldapDelRequest {
+			dn = string(content)
+		}
+		s.logOp(op, dn, map[string]any{"ldap_dn": dn, "data": string(content)})
+		return ldapMessage(id, response, ldapResult(ldapInsufficientAccess, "", "no write access to parent")), false
+	}
+	s.meta.LogData(string(content))
+	return nil, true
+}
+
+// logOp emits a data event about an operation on dn.
+func (s *ldapSession) logOp(op byte, dn string, fields map[string]any) {
+	if fields == nil {
+		fields = make(map[string]any)
+	}
+	name := ldapOpNames[op]
+	fields["ldap_op"] = name
+	message := fmt.Sprintf("LDAP %s on port %s from %s", name, s.meta.Port, s.meta.ClientAddr)
+	if dn != "" {
+		message = fmt.Sprintf("LDAP %s of %q on port %s from %s", name, dn, s.meta.Port, s.meta.ClientAddr)
+	}
+	s.meta.Emit(Event{Type: EventData, Message: message, Fields: fields})
+	s.meta.Observe(dn)
+}
+
+// bind accepts every bind, logging simple bind DNs and passwords and the
+// SASL mechanism of others.
+func (s *ldapSession) bind(id int, content []byte) []byte {
+	tag, version, rest, err := readTLV(content)
+	if err != nil || tag != berInteger {
+		return ldapMessage(id, ldapBindResponse, ldapResult(ldapProtocolError, "", "decoding error"))
+	}
+	v, _ := parseBERInt(version)
+	tag, name, rest, err := readTLV(rest)
+	if err != nil || tag != berOctetString {
+		return ldapMessage(id, ldapBindResponse, ldapResult(ldapProtocolError, "", "decoding error"))
+	}
+	auth, credentials, _, err := readTLV(rest)
+	if err != nil {
+		return ldapMessage(id, ldapBindResponse, ldapResult(ldapProtocolError, "", "decoding error"))
+	}
+	dn := string(name)
+	fields := map[string]any{"ldap_dn": dn, "ldap_version": v}
+	switch auth {
+	case 0x80: // simple
+		fields["ldap_auth"] = "simple"
+		s.logOp(ldapBindRequest, dn, fields)
+		if dn != "" || len(credentials) > 0 {
+			s.meta.LogCredential(Credential{Username: dn, Password: string(credentials)})
+		}
+	case 0xA3: // SASL
+		mechanism := ""
+		if tag, m, _, err := readTLV(credentials); err == nil && tag == berOctetString {
+			mechanism = string(m)
+		}
+		fields["ldap_auth"] = "sasl"
+		fields["ldap_mechanism"] = mechanism
+		s.logOp(ldapBindRequest, dn, fields)
+	default:
+		s.logOp(ldapBindRequest, dn, fields)
+		return ldapMessage(id, ldapBindResponse, ldapResult(ldapUnwillingToPerform, "", "unsupported authentication method"))
+	}
+	return ldapMessage(id, ldapBindResponse, ldapResult(ldapSuccess, "", ""))
+}
+
+// search answers a search request: the root DSE, nothing for the rest of
+// the directory, and noSuchObject for names outside it, after raising a
+// log4shell_callback alert for JNDI lookups.
+func (s *ldapSession) search(id int, content []byte) []byte {
+	tag, base, rest, err := readTLV(content)
+	if err != nil || tag != berOctetString {
+		return ldapMessage(id, ldapSearchDone, ldapResult(ldapProtocolError, "", "decoding error"))
+	}
+	scope := -1
+	if tag, v, r, err := readTLV(rest); err == nil && tag == berEnumerated {
+		scope, _ = parseBERInt(v)
+		rest = r
+	}
+	// derefAliases, sizeLimit, timeLimit, typesOnly
+	for i := 0; i < 4 && err == nil; i++ {
+		_, _, rest, err = readTLV(rest)
+	}
+	filter := ""
+	if err == nil {
+		var raw []byte
+		if _, _, r, err := readTLV(rest); err == nil {
+			raw = rest[:len(rest)-len(r)]
+		}
+		filter = formatLDAPFilter(raw)
+	}
+	dn := string(base)
+	fields := map[string]any{"ldap_dn": dn, "ldap_scope": scope, "ldap_filter": filter}
+	lookup := scope == 0 && dn != "" && !strings.HasSuffix(strings.ToLower(dn), s.suffix)
+	if lookup {
+		fields["jndi_lookup"] = true
+		if command := decodeJNDIPath(dn); command != "" {
+			fields["jndi_command"] = command
+		}
+	}
+	s.logOp(ldapSearchRequest, dn, fields)
+	if !lookup {
+		if dn == "" && scope == 0 {
+			return append(ldapMessage(id, ldapSearchEntry, s.rootDSE()), ldapMessage(id, ldapSearchDone, ldapResult(ldapSuccess, "", ""))...)
+		}
+		return ldapMessage(id, ldapSearchDone, ldapResult(ldapSuccess, "", ""))
+	}
+
+	if s.meta.Stage < StageExploit {
+		s.meta.Stage = StageExploit
+	}
+	message := fmt.Sprintf("JNDI lookup of %q on port %s from %s", dn, s.meta.Port, s.meta.ClientAddr)
+	if command, ok := fields["jndi_command"].(string); ok {
+		message += fmt.Sprintf(", command %q", command)
+		s.meta.Observe(command)
+		s.meta.logDropperURLs(command)
+	}
+	s.meta.RaiseAlert("high", "log4shell_callback", message)
+	return ldapMessage(id, ldapSearchDone, ldapResult(ldapNoSuchObject, s.suffix, ""))
+}
+
+// rootDSE returns the search result entry of the root DSE.
+func (s *ldapSession) rootDSE() []byte {
+	id := s.meta.Identity
+	attributes := [][]string{
+		{"objectClass", "top", "OpenLDAProotDSE"},
+		{"namingContexts", s.suffix},
+		{"supportedLDAPVersion", "3"},
+		{"supportedSASLMechanisms", "DIGEST-MD5", "CRAM-MD5", "NTLM"},
+		{"supportedExtension", "1.3.6.1.4.1.4203.1.11.1", "1.3.6.1.4.1.4203.1.11.3", "1.3.6.1.1.8"},
+		{"subschemaSubentry", "cn=Subschema"},
+	}
+	if id.IsWindows() {
+		host := strings.ToUpper(id.Hostname)
+		attributes = [][]string{
+			{"currentTime", time.Now().UTC().Format("20060102150405.0Z")},
+			{"subschemaSubentry", "CN=Aggregate,CN=Schema,CN=Configuration," + strings.ToUpper(s.suffix)},
+			{"dsServiceName", "CN=NTDS Settings,CN=" + host + ",CN=Servers,CN=Default-First-Site-Name,CN=Sites,CN=Configuration," + strings.ToUpper(s.suffix)},
+			{"namingContexts", strings.ToUpper(s.suffix), "CN=Configuration," + strings.ToUpper(s.suffix)},
+			{"defaultNamingContext", strings.ToUpper(s.suffix)},
+			{"rootDomainNamingContext", strings.ToUpper(s.suffix)},
+			{"supportedLDAPVersion", "3", "2"},
+			{"supportedSASLMechanisms", "GSSAPI", "GSS-SPNEGO", "EXTERNAL", "DIGEST-MD5"},
+			{"dnsHostName", id.FQDN()},
+			{"ldapServiceName", id.Domain + ":" + strings.ToLower(host) + "$@" + strings.ToUpper(id.Domain)},
+			{"serverName", "CN=" + host + ",CN=Servers,CN=Default-First-Site-Name,CN=Sites,CN=Configuration," + strings.ToUpper(s.suffix)},
+			{"domainFunctionality", "7"},
+			{"forestFunctionality", "7"},
+			{"domainControllerFunctionality", "7"},
+			{"isSynchronized", "TRUE"},
+			{"isGlobalCatalogReady", "TRUE"},
+		}
+	}
+	var attrs []byte
+	for _, attr := range attributes {
+		var values []byte
+		for _, v := range attr[1:] {
+			values = appendTLV(values, berOctetString, []byte(v))
+		}
+		var a []byte
+		a = appendTLV(a, berOctetString, []byte(attr[0]))
+		a = appendTLV(a, 0x31, values)
+		attrs = appendTLV(attrs, berSequence, a)
+	}
+	var entry []byte
+	entry = appendTLV(entry, berOctetString, nil)
+	return appendTLV(entry, berSequence, attrs)
+}
+
+// ldapResult encodes the LDAPResult of a response.
+func ldapResult(code int, matchedDN, diagnostic string) []byte {
+	var b []byte
+	b = appendTLV(b, berEnumerated, berInt(berEnumerated, int64(code)).content)
+	b = appendTLV(b, berOctetString, []byte(matchedDN))
+	return appendTLV(b, berOctetString, []byte(diagnostic))
+}
+
+// ldapMessage encodes an LDAPMessage.
+func ldapMessage(id int, op byte, content []byte) []byte {
+	var b []byte
+	b = appendTLV(b, berInteger, berInt(berInteger, int64(id)).content)
+	b = appendTLV(b, op, content)
+	return appendTLV(nil, berSequence, b)
+}
+
+// ldapSuffix returns the naming context of a DNS domain, e.g.
+// "dc=corp,dc=local" for corp.local.
+func ldapSuffix(domain string) string {
+	var parts []string
+	for _, label := range strings.Split(strings.ToLower(domain), ".") {
+		if label != "" {
+			parts = append(parts, "dc="+label)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// formatLDAPFilter renders an encoded search filter in the string form of
+// RFC 4515, e.g. "(&(objectClass=user)(cn=adm*))".
+func formatLDAPFilter(raw []byte) string {
+	tag, content, _, err := readTLV(raw)
+	if err != nil {
+		return ""
+	}
+	pair := func() (string, string) {
+		_, attr, rest, err := readTLV(content)
+		if err != nil {
+			return "", ""
+		}
+		_, value, _, _ := readTLV(rest)
+		return string(attr), string(value)
+	}
+	switch tag {
+	case 0xA0, 0xA1, 0xA2: // and, or, not
+		var b strings.Builder
+		b.WriteString("(" + string("&|!"[tag-0xA0]))
+		for len(content) > 0 {
+			_, _, rest, err := readTLV(content)
+			if err != nil {
+				break
+			}
+			b.WriteString(formatLDAPFilter(content[:len(content)-len(rest)]))
+			content = rest
+		}
+		return b.String() + ")"
+	case 0xA3, 0xA5, 0xA6, 0xA8: // equality, greaterOrEqual, lessOrEqual, approxMatch
+		attr, value := pair()
+		op := map[byte]string{0xA3: "=", 0xA5: ">=", 0xA6: "<=", 0xA8: "~="}[tag]
+		return "(" + attr + op + value + ")"
+	case 0xA4: // substrings
+		_, attr, rest, err := readTLV(content)
+		if err != nil {
+			return ""
+		}
+		_, subs, _, _ := readTLV(rest)
+		value := ""
+		final := false
+		for len(subs) > 0 {
+			subTag, sub, r, err := readTLV(subs)
+			if err != nil {
+				break
+			}
+			if subTag != 0x80 && !strings.HasSuffix(value, "*") {
+				value += "*"
+			}
+			value += string(sub)
+			final = subTag == 0x82
+			subs = r
+		}
+		if !final {
+			value += "*"
+		}
+		return "(" + string(attr) + "=" + value + ")"
+	case 0x87: // present
+		return "(" + string(content) + "=*)"
+	}
+	return fmt.Sprintf("(?%#x)", tag)
+}
+
+// decodeJNDIPath returns the command carried by the name a JNDI lookup
+// asks for in the path formats of the JNDI exploit kits, e.g.
+// "Basic/Command/Base64/aWQ=" or "TomcatBypass/Command/whoami", or by a
+// path segment that is base64 encoded text.
+func decodeJNDIPath(name string) string {
+	segments := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	for i, segment := range segments {
+		switch strings.ToLower(segment) {
+		case "base64":
+			if i+1 < len(segments) {
+				if text, ok := decodeBase64Text(strings.Join(segments[i+1:], "/")); ok {
+					return text
+				}
+			}
+		case "command":
+			if i+1 < len(segments) && !strings.EqualFold(segments[i+1], "base64") {
+				command := strings.Join(segments[i+1:], "/")
+				if unescaped, err := url.PathUnescape(command); err == nil {
+					command = unescaped
+				}
+				return command
+			}
+		case "reverseshell":
+			if i+2 < len(segments) {
+				return "reverse shell to " + net.JoinHostPort(segments[i+1], segments[i+2])
+			}
+		}
+	}
+	for _, segment := range segments {
+		if len(segment) >= 16 {
+			if text, ok := decodeBase64Text(segment); ok {
+				return text
+			}
+		}
+	}
+	return ""
+}
+
+// decodeBase64Text decodes base64, standard or URL-safe, padded or not,
+// that encodes printable text.
+func decodeBase64Text(s string) (string, bool) {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		decoded, err := enc.DecodeString(s)
+		if err != nil || !utf8.Valid(decoded) {
+			continue
+		}
+		printable := true
+		for _, r := range string(decoded) {
+			if r < ' ' && r != '\n' && r != '\r' && r != '\t' {
+				printable = false
+				break
+			}
+		}
+		if printable && len(decoded) > 0 {
+			return string(decoded), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,558 @@
+package honeypot
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterHandler("imap", mailHandler("imap", false))
+	RegisterHandler("imaps", mailHandler("imap", true))
+	RegisterHandler("pop3", mailHandler("pop3", false))
+	RegisterHandler("pop3s", mailHandler("pop3", true))
+}
+
+// Limits of the mail handlers.
+const (
+	mailMaxBadCommands = 10    // invalid commands before the server hangs up, like Dovecot
+	imapMaxLiteral     = 8192  // longest IMAP literal accepted
+	tlsMaxHello        = 16384 // bytes of a ClientHello logged
+)
+
+// errMailLiteral reports an IMAP literal longer than imapMaxLiteral.
+var errMailLiteral = errors.New("IMAP literal too long")
+
+// mailSession is one connection to an IMAP or POP3 handler.
+type mailSession struct {
+	ctx      context.Context
+	conn     net.Conn
+	reader   *bufio.Reader
+	meta     *ConnMeta
+	protocol string // "imap" or "pop3"
+	tls      bool   // the session is encrypted, so STARTTLS is not offered
+	received bool
+	total    int
+	bad      int    // invalid commands so far
+	user     string // POP3 USER waiting for its PASS
+}
+
+// mailHandler returns the IMAP or POP3 handler, expecting TLS first for
+// implicitTLS (IMAPS on 993, POP3S on 995).
+//
+// The handlers impersonate Dovecot, or Microsoft Exchange on Windows
+// profiles, to capture mail credential stuffing: they answer the greeting,
+// CAPABILITY/CAPA, ID, NOOP and logout commands, offer STARTTLS/STLS, and
+// log the credentials of LOGIN, USER/PASS and AUTHENTICATE/AUTH PLAIN,
+// LOGIN and XOAUTH2, whose base64 is decoded. Every login fails. The
+// certificate of encrypted sessions is the host identity's, and their
+// ClientHello is logged so that its JA3 fingerprint is recorded.
+func mailHandler(protocol string, implicitTLS bool) HandlerFunc {
+	return func(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+		s := &mailSession{ctx: ctx, conn: conn, meta: meta, protocol: protocol}
+		if implicitTLS {
+			tlsConn, err := startTLS(ctx, conn, meta, strings.ToUpper(protocol)+"S")
+			if tlsConn == nil {
+				return err
+			}
+			s.conn, s.tls, s.received = tlsConn, true, true
+		}
+		s.reader = bufio.NewReaderSize(s.conn, meta.Limits.ReadBuffer)
+		if protocol == "imap" {
+			return s.serveIMAP()
+		}
+		return s.servePOP3()
+	}
+}
+
+// startTLS runs the server side of a TLS handshake on conn with the host
+// identity's certificate. The ClientHello is logged as data; a client that
+// doesn't speak TLS has what it sent logged instead. It returns a nil
+// connection when the handshake failed.
+func startTLS(ctx context.Context, conn net.Conn, meta *ConnMeta, product string) (*tls.Conn, error) {
+	cert, err := meta.Identity.TLSCertificate()
+	if err != nil {
+		return nil, fmt.Errorf("creating the %s certificate: %w", product, err)
+	}
+	recorder := &helloRecorder{Conn: conn}
+	tlsConn := tls.Server(recorder, &tls.Config{Certificates: []tls.Certificate{*cert}, MinVersion: tls.VersionTLS10})
+	tlsConn.SetDeadline(meta.NextReadDeadline(true))
+	err = tlsConn.HandshakeContext(ctx)
+	recorder.done = true
+	tlsConn.SetWriteDeadline(time.Time{})
+	hello := string(recorder.hello)
+	if len(hello) > 0 && hello[0] != 0x16 {
+		meta.LogData(hello)
+		return nil, nil
+	}
+	if len(hello) > 0 {
+		serverName := tlsConn.ConnectionState().ServerName
+		meta.Emit(Event{
+			Type:    EventData,
+			Message: fmt.Sprintf("%s TLS ClientHello on port %s from %s (%d bytes, server name %q)", product, meta.Port, meta.ClientAddr, len(hello), serverName),
+			Fields:  map[string]any{"data": hello, "tls_server_name": serverName},
+		})
+	}
+	if err != nil {
+		meta.Logf("%s TLS handshake on port %s from %s failed: %s", product, meta.Port, meta.ClientAddr, err)
+		return nil, nil
+	}
+	return tlsConn, nil
+}
+
+// helloRecorder is a connection keeping what is read from it until done,
+// up to tlsMaxHello bytes.
+type helloRecorder struct {
+	net.Conn
+	hello []byte
+	done  bool
+}
+
+func (c *helloRecorder) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if !c.done && len(c.hello) < tlsMaxHello {
+		c.hello = append(c.hello, b[:min(n, tlsMaxHello-len(c.hello))]...)
+	}
+	return n, err
+}
+
+// readLine reads a line, without its line ending, or the first
+// ReadBuffer bytes of a longer one.
+func (s *mailSession) readLine() (string, error) {
+	if s.total >= s.meta.Limits.MaxBytes {
+		s.meta.Logf("Closing session on port %s from %s: %d bytes received", s.meta.Port, s.meta.ClientAddr, s.total)
+		return "", io.EOF
+	}
+	s.conn.SetReadDeadline(s.meta.NextReadDeadline(s.received))
+	raw, err := s.reader.ReadSlice('\n')
+	s.total += len(raw)
+	if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+		return "", binaryReadError(s.ctx, s.meta, raw, err, s.received)
+	}
+	s.received = true
+	return strings.TrimRight(string(raw), "\r\n"), nil
+}
+
+// send writes lines to the client, each ended by CRLF.
+func (s *mailSession) send(lines ...string) error {
+	if _, err := io.WriteString(s.conn, strings.Join(lines, "\r\n")+"\r\n"); err != nil {
+		return fmt.Errorf("writing to connection: %w", err)
+	}
+	return nil
+}
+
+// logCommand emits a data event about a command. Passwords are left out of
+// the message but kept in data, the line received.
+func (s *mailSession) logCommand(command, line string, fields map[string]any) {
+	if fields == nil {
+		fields = make(map[string]any)
+	}
+	fields["data"] = line
+	fields["mail_protocol"] = s.protocol
+	fields["mail_command"] = command
+	s.meta.Emit(Event{
+		Type:    EventData,
+		Message: fmt.Sprintf("%s %s on port %s from %s", strings.ToUpper(s.protocol), command, s.meta.Port, s.meta.ClientAddr),
+		Fields:  fields,
+	})
+	s.meta.Observe(line)
+}
+
+// exchange reports whether the host impersonates Microsoft Exchange rather than Dovecot.
+func (s *mailSession) exchange() bool {
+	return s.meta.Identity.IsWindows()
+}
+
+// dovecotGreeting returns Dovecot's greeting text, which names the
+// distribution it was packaged by.
+func (s *mailSession) dovecotGreeting() string {
+	for _, distribution := range []string{"Ubuntu", "Debian"} {
+		if strings.HasPrefix(s.meta.Identity.OS, distribution) {
+			return "Dovecot (" + distribution + ") ready."
+		}
+	}
+	return "Dovecot ready."
+}
+
+// upgrade switches the session to TLS after STARTTLS or STLS.
+func (s *mailSession) upgrade() error {
+	tlsConn, err := startTLS(s.ctx, s.conn, s.meta, strings.ToUpper(s.protocol))
+	if tlsConn == nil {
+		if err == nil {
+			err = io.EOF
+		}
+		return err
+	}
+	s.conn, s.tls = tlsConn, true
+	s.reader = bufio.NewReaderSize(tlsConn, s.meta.Limits.ReadBuffer)
+	return nil
+}
+
+// badCommand counts an invalid command and reports whether the server
+// hangs up.
+func (s *mailSession) badCommand() bool {
+	s.bad++
+	return s.bad >= mailMaxBadCommands
+}
+
+// imapCapabilities returns the capabilities advertised by the IMAP server.
+func (s *mailSession) imapCapabilities() string {
+	caps := "IMAP4rev1 SASL-IR LOGIN-REFERRALS ID ENABLE IDLE LITERAL+"
+	if s.exchange() {
+		caps = "IMAP4 IMAP4rev1 SASL-IR UIDPLUS MOVE ID UNSELECT CHILDREN IDLE NAMESPACE LITERAL+"
+	}
+	if !s.tls {
+		caps += " STARTTLS"
+	}
+	return caps + " AUTH=PLAIN AUTH=LOGIN AUTH=XOAUTH2"
+}
+
+func (s *mailSession) serveIMAP() error {
+	greeting := "* OK [CAPABILITY " + s.imapCapabilities() + "] " + s.dovecotGreeting()
+	if s.exchange() {
+		greeting = "* OK The Microsoft Exchange IMAP4 service is ready."
+	}
+	if err := s.send(s.meta.Identity.LegalBanner("* OK ") + greeting); err != nil {
+		return err
+	}
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		args, err := s.imapArguments(line)
+		if errors.Is(err, errMailLiteral) {
+			s.meta.LogData(line)
+			return s.send("* BYE Literal too large.")
+		} else if err != nil {
+			return err
+		}
+		if len(args) < 2 {
+			s.meta.LogData(line)
+			if s.badCommand() {
+				return s.send("* BYE Too many invalid IMAP commands.")
+			}
+			if err := s.send("* BAD Error in IMAP command received by server."); err != nil {
+				return err
+			}
+			continue
+		}
+		tag, command := args[0], strings.ToUpper(args[1])
+		var reply []string
+		switch command {
+		case "CAPABILITY":
+			s.logCommand(command, line, nil)
+			reply = []string{"* CAPABILITY " + s.imapCapabilities(), tag + " OK Pre-login capabilities listed, post-login capabilities have more."}
+		case "NOOP":
+			s.logCommand(command, line, nil)
+			reply = []string{tag + " OK NOOP completed."}
+		case "ID":
+			s.logCommand(command, line, map[string]any{"mail_client_id": strings.Join(args[2:], " ")})
+			reply = []string{`* ID ("name" "Dovecot")`, tag + " OK ID completed."}
+			if s.exchange() {
+				reply[0] = "* ID NIL"
+			}
+		case "LOGOUT":
+			s.logCommand(command, line, nil)
+			return s.send("* BYE Logging out", tag+" OK Logout completed.")
+		case "STARTTLS":
+			s.logCommand(command, line, nil)
+			if s.tls {
+				reply = []string{tag + " BAD TLS is already active."}
+				break
+			}
+			if err := s.send(tag + " OK Begin TLS negotiation now."); err != nil {
+				return err
+			}
+			if err := s.upgrade(); err != nil {
+				return err
+			}
+			continue
+		case "LOGIN":
+			if len(args) < 4 {
+				s.logCommand(command, line, nil)
+				reply = []string{tag + " BAD Error in IMAP command LOGIN: Missing arguments."}
+				break
+			}
+			s.logCommand(command, line, map[string]any{"mail_auth": "login"})
+			s.meta.LogCredential(Credential{Username: args[2], Password: args[3]})
+			reply = []string{tag + " NO [AUTHENTICATIONFAILED] Authentication failed."}
+		case "AUTHENTICATE":
+			mechanism, initial := "", ""
+			if len(args) > 2 {
+				mechanism = strings.ToUpper(args[2])
+			}
+			if len(args) > 3 {
+				initial = args[3]
+			}
+			s.logCommand(command, line, map[string]any{"mail_auth": strings.ToLower(mechanism)})
+			result, err := s.authenticate(mechanism, initial)
+			if err != nil {
+				return err
+			}
+			reply = []string{tag + " " + map[string]string{
+				"failed":      "NO [AUTHENTICATIONFAILED] Authentication failed.",
+				"aborted":     "BAD Authentication aborted by client.",
+				"malformed":   "BAD Invalid base64 data in continued response",
+				"unsupported": "NO [CANNOT] Unsupported authentication mechanism.",
+			}[result]}
+		default:
+			s.logCommand(command, line, nil)
+			if s.badCommand() {
+				return s.send("* BYE Too many invalid IMAP commands.")
+			}
+			reply = []string{tag + " BAD Error in IMAP command received by server."}
+			if s.exchange() {
+				reply = []string{tag + " BAD Command received in Invalid state."}
+			}
+		}
+		if err := s.send(reply...); err != nil {
+			return err
+		}
+	}
+}
+
+// imapArguments splits an IMAP command into its tag, command and
+// arguments: atoms, quoted strings and literals, for which the rest of the
+// command is read from the client.
+func (s *mailSession) imapArguments(line string) ([]string, error) {
+	var args []string
+	for rest := line; ; {
+		rest = strings.TrimLeft(rest, " ")
+		switch {
+		case rest == "":
+			return args, nil
+		case rest[0] == '"':
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				b.WriteByte(rest[i])
+			}
+			args = append(args, b.String())
+			rest = rest[min(i+1, len(rest)):]
+		case rest[0] == '{' && strings.HasSuffix(rest, "}"):
+			n, err := strconv.Atoi(strings.TrimSuffix(rest[1:len(rest)-1], "+"))
+			if err != nil || n < 0 {
+				return append(args, rest), nil
+			}
+			if n > imapMaxLiteral {
+				return args, errMailLiteral
+			}
+			if !strings.HasSuffix(rest, "+}") {
+				if err := s.send("+ OK"); err != nil {
+					return args, err
+				}
+			}
+			literal := make([]byte, n)
+			s.conn.SetReadDeadline(s.meta.NextReadDeadline(true))
+			read, err := io.ReadFull(s.reader, literal)
+			s.total += read
+			if err != nil {
+				return args, binaryReadError(s.ctx, s.meta, literal[:read], err, true)
+			}
+			args = append(args, string(literal))
+			if rest, err = s.readLine(); err != nil {
+				return args, err
+			}
+		default:
+			end := strings.IndexByte(rest, ' ')
+			if end < 0 {
+				end = len(rest)
+			}
+			args = append(args, rest[:end])
+			rest = rest[end:]
+		}
+	}
+}
+
+// pop3Capabilities returns the CAPA list of the POP3 server.
+func (s *mailSession) pop3Capabilities() []string {
+	caps := []string{"+OK", "CAPA", "TOP", "UIDL", "RESP-CODES", "PIPELINING", "AUTH-RESP-CODE"}
+	if s.exchange() {
+		caps = []string{"+OK", "TOP", "UIDL", "SASL PLAIN LOGIN XOAUTH2", "USER"}
+	} else {
+		caps = append(caps, "USER", "SASL PLAIN LOGIN XOAUTH2")
+	}
+	if !s.tls {
+		caps = append(caps, "STLS")
+	}
+	return append(caps, ".")
+}
+
+func (s *mailSession) servePOP3() error {
+	greeting := "+OK " + s.dovecotGreeting()
+	if s.exchange() {
+		greeting = "+OK The Microsoft Exchange POP3 service is ready."
+	}
+	if err := s.send(greeting); err != nil {
+		return err
+	}
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		command, argument, _ := strings.Cut(line, " ")
+		command = strings.ToUpper(command)
+		reply := []string{"+OK"}
+		switch command {
+		case "CAPA":
+			s.logCommand(command, line, nil)
+			reply = s.pop3Capabilities()
+		case "NOOP":
+			s.logCommand(command, line, nil)
+		case "QUIT":
+			s.logCommand(command, line, nil)
+			return s.send("+OK Logging out.")
+		case "STLS":
+			s.logCommand(command, line, nil)
+			if s.tls {
+				reply = []string{"-ERR TLS is already active."}
+				break
+			}
+			if err := s.send("+OK Begin TLS negotiation now."); err != nil {
+				return err
+			}
+			if err := s.upgrade(); err != nil {
+				return err
+			}
+			continue
+		case "USER":
+			s.logCommand(command, line, nil)
+			s.user = argument
+		case "PASS":
+			s.logCommand(command, line, map[string]any{"mail_auth": "user"})
+			s.meta.LogCredential(Credential{Username: s.user, Password: argument})
+			s.user = ""
+			reply = []string{"-ERR [AUTH] Authentication failed."}
+		case "APOP":
+			name, digest, _ := strings.Cut(argument, " ")
+			s.logCommand(command, line, map[string]any{"mail_auth": "apop", "mail_apop_digest": digest})
+			s.meta.LogCredential(Credential{Username: name})
+			reply = []string{"-ERR [AUTH] Authentication failed."}
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(argument, " ")
+			mechanism = strings.ToUpper(mechanism)
+			s.logCommand(command, line, map[string]any{"mail_auth": strings.ToLower(mechanism)})
+			if mechanism == "" {
+				reply = []string{"+OK", "PLAIN", "LOGIN", "XOAUTH2", "."}
+				break
+			}
+			result, err := s.authenticate(mechanism, initial)
+			if err != nil {
+				return err
+			}
+			reply = []string{map[string]string{
+				"failed":      "-ERR [AUTH] Authentication failed.",
+				"aborted":     "-ERR Authentication aborted by client.",
+				"malformed":   "-ERR Invalid base64 data in continued response",
+				"unsupported": "-ERR Unsupported authentication mechanism.",
+			}[result]}
+		default:
+			s.logCommand(command, line, nil)
+			if s.badCommand() {
+				return s.send("-ERR Too many bad commands.")
+			}
+			reply = []string{"-ERR Unknown command."}
+			if s.exchange() {
+				reply = []string{"-ERR Command is not valid in this state."}
+			}
+		}
+		if err := s.send(reply...); err != nil {
+			return err
+		}
+	}
+}
+
+// authenticate runs a SASL exchange of mechanism, starting with the
+// initial response if the client sent one, and logs the credentials it
+// carries. The result is "failed", "aborted", "malformed" or
+// "unsupported".
+func (s *mailSession) authenticate(mechanism, initial string) (string, error) {
+	// prompt sends a continuation and returns the client's decoded answer
+	prompt := func(challenge string) (string, string, error) {
+		if err := s.send("+ " + challenge); err != nil {
+			return "", "", err
+		}
+		line, err := s.readLine()
+		if err != nil {
+			return "", "", err
+		}
+		if line == "*" {
+			return "", "aborted", nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return "", "malformed", nil
+		}
+		return string(decoded), "", nil
+	}
+	response := func(challenge string) (string, string, error) {
+		if initial == "" {
+			return prompt(challenge)
+		}
+		answer := initial
+		initial = ""
+		if answer == "=" {
+			return "", "", nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(answer)
+		if err != nil {
+			return "", "malformed", nil
+		}
+		return string(decoded), "", nil
+	}
+
+	switch mechanism {
+	case "PLAIN":
+		message, result, err := response("")
+		if err != nil || result != "" {
+			return result, err
+		}
+		// authzid NUL authcid NUL passwd
+		parts := strings.SplitN(message, "\x00", 3)
+		if len(parts) != 3 {
+			return "malformed", nil
+		}
+		s.meta.LogCredential(Credential{Username: parts[1], Password: parts[2]})
+	case "LOGIN":
+		user, result, err := response("VXNlcm5hbWU6") // "Username:"
+		if err != nil || result != "" {
+			return result, err
+		}
+		password, result, err := prompt("UGFzc3dvcmQ6") // "Password:"
+		if err != nil || result != "" {
+			return result, err
+		}
+		s.meta.LogCredential(Credential{Username: user, Password: password})
+	case "XOAUTH2":
+		message, result, err := response("")
+		if err != nil || result != "" {
+			return result, err
+		}
+		// "user=" user ^A "auth=Bearer " token ^A ^A
+		var cred Credential
+		for _, field := range strings.Split(message, "\x01") {
+			if user, ok := strings.CutPrefix(field, "user="); ok {
+				cred.Username = user
+			} else if auth, ok := strings.CutPrefix(field, "auth="); ok {
+				cred.Password = strings.TrimPrefix(auth, "Bearer ")
+			}
+		}
+		s.meta.LogCredential(cred)
+	default:
+		return "unsupported", nil
+	}
+	return "failed", nil
+}
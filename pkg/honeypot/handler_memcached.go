@@ -0,0 +1,446 @@
+package honeypot
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterHandler("memcached", HandlerFunc(serveMemcached))
+	RegisterPacketHandler("memcached", PacketHandlerFunc(serveMemcachedPacket))
+}
+
+const (
+	memcachedMaxItem     = 1 << 20 // item size limit, memcached's default -I
+	memcachedUDPHeader   = 8       // request ID, sequence number, datagram count, reserved
+	memcachedUDPPayload  = 1400    // bytes of a response per datagram, like memcached
+	memcachedFloodGets   = 10      // UDP retrievals in one session that make a get flood
+	memcachedPrimingSize = 8 << 10 // stored values this large are amplification payloads
+)
+
+// memcachedItem is a value stored by a client.
+type memcachedItem struct {
+	flags uint64
+	value []byte
+	cas   uint64
+}
+
+// memcachedState is the cache of one session: clients only see what they
+// stored themselves, so the honeypot cannot be used to pass data around, and
+// a UDP get flood from spoofed addresses finds nothing to reflect.
+type memcachedState struct {
+	items    map[string]*memcachedItem
+	cas      uint64
+	gets     int   // retrieval commands received over UDP
+	keys     int   // keys they asked for
+	request  int   // bytes of those commands
+	answered int   // bytes their answers would have if nothing was held back
+	flooded  bool  // a get flood was reported, later UDP retrievals aren't logged one by one
+	sets     int64 // for stats
+}
+
+func memcachedSession(meta *ConnMeta) *memcachedState {
+	st, _ := meta.HandlerState.(*memcachedState)
+	if st == nil {
+		st = &memcachedState{items: make(map[string]*memcachedItem)}
+		meta.HandlerState = st
+	}
+	return st
+}
+
+// serveMemcached speaks the memcached text protocol over TCP. memcached has
+// no banner, so the handler waits for commands and answers them until the
+// client quits.
+func serveMemcached(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	st := memcachedSession(meta)
+	buffer := make([]byte, meta.Limits.ReadBuffer)
+	var pending []byte
+	total := 0
+	for {
+		conn.SetReadDeadline(meta.NextReadDeadline(total > 0))
+		n, err := conn.Read(buffer[:min(len(buffer), meta.Limits.MaxBytes-total)])
+		total += n
+		pending = append(pending, buffer[:n]...)
+		if len(pending) > 0 && pending[0] == 0x80 {
+			meta.LogData(string(pending)) // binary protocol, disabled by default since 1.6
+			return nil
+		}
+		out, rest, quit := st.exec(meta, pending, "tcp")
+		pending = rest
+		if len(out) > 0 {
+			if _, werr := conn.Write(out); werr != nil {
+				return fmt.Errorf("writing to connection: %w", werr)
+			}
+		}
+		if quit {
+			return nil
+		}
+		if err != nil {
+			return binaryReadError(ctx, meta, pending, err, total > 0)
+		}
+		if total >= meta.Limits.MaxBytes {
+			if len(pending) > 0 {
+				meta.LogData(string(pending))
+			}
+			meta.Logf("Closing session on port %s from %s: %d bytes received", meta.Port, meta.ClientAddr, total)
+			return nil
+		}
+	}
+}
+
+// serveMemcachedPacket answers memcached requests over UDP, each a datagram
+// with a frame header. Answers go through the listener's reflection limits,
+// and get floods, the reconnaissance and preparation of memcached
+// amplification attacks, are reported as ddos_prep events.
+func serveMemcachedPacket(ctx context.Context, packet []byte, meta *ConnMeta) [][]byte {
+	if len(packet) < memcachedUDPHeader || binary.BigEndian.Uint16(packet[4:]) != 1 {
+		meta.LogData(string(packet)) // multi-datagram requests are not supported by memcached either
+		return nil
+	}
+	st := memcachedSession(meta)
+	out, rest, _ := st.exec(meta, packet[memcachedUDPHeader:], "udp")
+	if len(rest) > 0 {
+		meta.LogData(string(rest))
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	count := (len(out) + memcachedUDPPayload - 1) / memcachedUDPPayload
+	answers := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		chunk := out[i*memcachedUDPPayload : min(len(out), (i+1)*memcachedUDPPayload)]
+		answer := append([]byte(nil), packet[:2]...) // request ID
+		answer = binary.BigEndian.AppendUint16(answer, uint16(i))
+		answer = binary.BigEndian.AppendUint16(answer, uint16(count))
+		answer = append(answer, 0, 0)
+		answers = append(answers, append(answer, chunk...))
+	}
+	return answers
+}
+
+// exec runs the complete commands at the start of input and returns their
+// responses, the unprocessed rest and whether the client quit.
+func (st *memcachedState) exec(meta *ConnMeta, input []byte, transport string) (out, rest []byte, quit bool) {
+	for len(input) > 0 {
+		end := bytes.IndexByte(input, '\n')
+		if end < 0 {
+			break
+		}
+		line := strings.TrimRight(string(input[:end]), "\r")
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			input = input[end+1:]
+			continue
+		}
+		command := strings.ToLower(args[0])
+		var value []byte
+		if isMemcachedStorage(command) {
+			size, problem := memcachedValueSize(command, args)
+			if problem != "" {
+				input = input[end+1:]
+				out = append(out, problem+"\r\n"...)
+				continue
+			}
+			if len(input)-end-1 < size+2 {
+				break // value still arriving
+			}
+			value = input[end+1 : end+1+size]
+			if !bytes.HasPrefix(input[end+1+size:], []byte("\r\n")) {
+				input = input[end+1+size:]
+				out = append(out, "CLIENT_ERROR bad data chunk\r\n"...)
+				continue
+			}
+			input = input[end+1+size+2:]
+		} else {
+			input = input[end+1:]
+		}
+		st.log(meta, line, command, args, value, transport)
+		if command == "quit" {
+			return out, input, true
+		}
+		out = append(out, st.answer(meta, command, args, value)...)
+	}
+	return out, input, false
+}
+
+func isMemcachedStorage(command string) bool {
+	switch command {
+	case "set", "add", "replace", "append", "prepend", "cas":
+		return true
+	}
+	return false
+}
+
+// memcachedValueSize returns the length of the data block of a storage
+// command, or the error memcached answers a malformed one with.
+func memcachedValueSize(command string, args []string) (int, string) {
+	want := 5
+	if command == "cas" {
+		want = 6
+	}
+	if len(args) < want || len(args) > want+1 {
+		return 0, "ERROR"
+	}
+	size, err := strconv.Atoi(args[4])
+	if err != nil || size < 0 {
+		return 0, "CLIENT_ERROR bad command line format"
+	}
+	if size > memcachedMaxItem {
+		return 0, "SERVER_ERROR object too large for cache"
+	}
+	return size, ""
+}
+
+// log emits a command, and a ddos_prep event once UDP retrievals add up to a
+// flood or a value large enough to amplify is stored.
+func (st *memcachedState) log(meta *ConnMeta, line, command string, args []string, value []byte, transport string) {
+	var keys []string
+	switch command {
+	case "get", "gets":
+		keys = args[1:]
+	case "gat", "gats":
+		if len(args) > 2 {
+			keys = args[2:]
+		}
+	default:
+		if len(args) > 1 && command != "stats" && command != "verbosity" && command != "flush_all" {
+			keys = args[1:2]
+		}
+	}
+	retrieval := strings.HasPrefix(command, "get") || strings.HasPrefix(command, "gat")
+	if transport == "udp" && retrieval {
+		st.gets++
+		st.keys += len(keys)
+		st.request += memcachedUDPHeader + len(line) + 2
+		for _, key := range keys {
+			if item, ok := st.items[key]; ok {
+				st.answered += len(item.value) + len(key) + 32
+			}
+		}
+		st.answered += len("END\r\n")
+	}
+	if !(st.flooded && transport == "udp" && retrieval) {
+		fields := map[string]any{"data": line, "memcached_command": command, "memcached_transport": transport}
+		if len(keys) > 0 {
+			fields["memcached_keys"] = keys
+		}
+		message := fmt.Sprintf("Memcached %s on port %s/%s from %s: %q", command, meta.Port, transport, meta.ClientAddr, line)
+		if value != nil {
+			fields["memcached_value"] = string(value)
+			fields["memcached_value_size"] = len(value)
+			message += fmt.Sprintf(", %d byte value", len(value))
+		}
+		meta.Emit(Event{Type: EventData, Message: message, Fields: fields})
+		meta.Observe(line)
+		if value != nil {
+			meta.Observe(string(value))
+		}
+	}
+
+	if transport == "udp" && retrieval && st.gets == memcachedFloodGets {
+		st.flooded = true
+		factor := float64(st.answered) / float64(st.request)
+		meta.Emit(Event{
+			Type: EventDDoSPrep,
+			Message: fmt.Sprintf("Memcached UDP get flood on port %s from %s: %d retrievals of %d keys, amplification %.1fx; the source address may be spoofed and be the intended target",
+				meta.Port, meta.ClientAddr, st.gets, st.keys, factor),
+			Fields: map[string]any{
+				"ddos_kind": "memcached_get_flood", "ddos_requests": st.gets, "ddos_keys": st.keys,
+				"ddos_request_bytes": st.request, "ddos_amplification": factor, "memcached_transport": transport,
+			},
+		})
+	}
+	if len(value) >= memcachedPrimingSize {
+		meta.Emit(Event{
+			Type:    EventDDoSPrep,
+			Message: fmt.Sprintf("Memcached amplification payload stored on port %s/%s from %s: %d bytes under key %q", meta.Port, transport, meta.ClientAddr, len(value), args[1]),
+			Fields: map[string]any{
+				"ddos_kind": "memcached_payload", "ddos_payload_bytes": len(value), "memcached_keys": keys, "memcached_transport": transport,
+			},
+		})
+	}
+}
+
+// answer returns the response to a command.
+func (st *memcachedState) answer(meta *ConnMeta, command string, args []string, value []byte) []byte {
+	noreply := len(args) > 1 && args[len(args)-1] == "noreply"
+	reply := func(s string) []byte {
+		if noreply {
+			return nil
+		}
+		return []byte(s + "\r\n")
+	}
+	switch command {
+	case "get", "gets", "gat", "gats":
+		keys := args[1:]
+		if strings.HasPrefix(command, "gat") {
+			if len(args) < 3 {
+				return []byte("ERROR\r\n")
+			}
+			keys = args[2:]
+		}
+		if len(keys) == 0 {
+			return []byte("ERROR\r\n")
+		}
+		var out []byte
+		for _, key := range keys {
+			item, ok := st.items[key]
+			if !ok {
+				continue
+			}
+			if strings.HasSuffix(command, "s") {
+				out = fmt.Appendf(out, "VALUE %s %d %d %d\r\n", key, item.flags, len(item.value), item.cas)
+			} else {
+				out = fmt.Appendf(out, "VALUE %s %d %d\r\n", key, item.flags, len(item.value))
+			}
+			out = append(out, item.value...)
+			out = append(out, "\r\n"...)
+		}
+		return append(out, "END\r\n"...)
+	case "set", "add", "replace", "append", "prepend", "cas":
+		key := args[1]
+		flags, err := strconv.ParseUint(args[2], 10, 32)
+		if err != nil || len(key) > 250 {
+			return []byte("CLIENT_ERROR bad command line format\r\n")
+		}
+		item, exists := st.items[key]
+		switch {
+		case command == "add" && exists, command == "replace" && !exists, (command == "append" || command == "prepend") && !exists:
+			return reply("NOT_STORED")
+		case command == "cas" && !exists:
+			return reply("NOT_FOUND")
+		case command == "cas" && args[5] != strconv.FormatUint(item.cas, 10):
+			return reply("EXISTS")
+		}
+		st.cas++
+		st.sets++
+		switch command {
+		case "append":
+			item.value = append(item.value, value...)
+		case "prepend":
+			item.value = append(append([]byte(nil), value...), item.value...)
+		default:
+			item = &memcachedItem{flags: flags, value: append([]byte(nil), value...)}
+			st.items[key] = item
+		}
+		item.cas = st.cas
+		return reply("STORED")
+	case "delete":
+		if len(args) < 2 {
+			return []byte("ERROR\r\n")
+		}
+		if _, ok := st.items[args[1]]; !ok {
+			return reply("NOT_FOUND")
+		}
+		delete(st.items, args[1])
+		return reply("DELETED")
+	case "incr", "decr":
+		if len(args) < 3 {
+			return []byte("ERROR\r\n")
+		}
+		delta, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			return []byte("CLIENT_ERROR invalid numeric delta argument\r\n")
+		}
+		item, ok := st.items[args[1]]
+		if !ok {
+			return reply("NOT_FOUND")
+		}
+		n, err := strconv.ParseUint(string(item.value), 10, 64)
+		if err != nil {
+			return []byte("CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+		}
+		if command == "incr" {
+			n += delta
+		} else {
+			n -= min(n, delta)
+		}
+		st.cas++
+		item.value, item.cas = strconv.AppendUint(nil, n, 10), st.cas
+		return reply(string(item.value))
+	case "touch":
+		if len(args) < 3 {
+			return []byte("ERROR\r\n")
+		}
+		if _, ok := st.items[args[1]]; !ok {
+			return reply("NOT_FOUND")
+		}
+		return reply("TOUCHED")
+	case "stats":
+		return st.stats(meta, args[1:])
+	case "version":
+		return []byte("VERSION " + memcachedVersion(meta) + "\r\n")
+	case "verbosity", "flush_all":
+		if command == "flush_all" {
+			clear(st.items)
+		}
+		return reply("OK")
+	}
+	return []byte("ERROR\r\n")
+}
+
+func memcachedVersion(meta *ConnMeta) string {
+	if version := meta.Identity.Software["memcached"]; version != "" {
+		return version
+	}
+	return "1.6.18"
+}
+
+// stats answers the stats command with the counters of a busy cache that
+// has been up for weeks.
+func (st *memcachedState) stats(meta *ConnMeta, args []string) []byte {
+	seed := uint64(meta.Identity.seed())
+	boot := meta.server.started.Add(-time.Duration(seed%(60*24*3600)) * time.Second)
+	uptime := int64(time.Since(boot) / time.Second)
+	var out []byte
+	stat := func(name string, value any) {
+		out = fmt.Appendf(out, "STAT %s %v\r\n", name, value)
+	}
+	if len(args) > 0 {
+		if args[0] == "settings" {
+			stat("maxbytes", 67108864)
+			stat("maxconns", 1024)
+			stat("tcpport", 11211)
+			stat("udpport", 11211) // the UDP port amplification relies on, off by default since 1.5.6
+			stat("verbosity", 0)
+			stat("item_size_max", memcachedMaxItem)
+			stat("evictions", "on")
+			stat("cas_enabled", "yes")
+			stat("binding_protocol", "auto-negotiate")
+		}
+		return append(out, "END\r\n"...)
+	}
+	items := int64(seed%40000) + 12000 + int64(len(st.items))
+	gets := uptime*37 + int64(seed%100000)
+	stat("pid", 400+seed%30000)
+	stat("uptime", uptime)
+	stat("time", time.Now().Unix())
+	stat("version", memcachedVersion(meta))
+	stat("libevent", "2.1.12-stable")
+	stat("pointer_size", 64)
+	stat("rusage_user", fmt.Sprintf("%d.%06d", uptime/90, seed%1000000))
+	stat("rusage_system", fmt.Sprintf("%d.%06d", uptime/60, seed/7%1000000))
+	stat("max_connections", 1024)
+	stat("curr_connections", 2+seed%6)
+	stat("total_connections", uptime/4+int64(seed%5000))
+	stat("cmd_get", gets)
+	stat("cmd_set", uptime*3+st.sets)
+	stat("cmd_flush", 0)
+	stat("get_hits", gets*9/10)
+	stat("get_misses", gets-gets*9/10)
+	stat("bytes_read", gets*48)
+	stat("bytes_written", gets*910)
+	stat("limit_maxbytes", 67108864)
+	stat("threads", 4)
+	stat("bytes", items*843)
+	stat("curr_items", items)
+	stat("total_items", items*6)
+	stat("evictions", 0)
+	return append(out, "END\r\n"...)
+}
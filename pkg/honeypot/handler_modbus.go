@@ -0,0 +1,499 @@
+package honeypot
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"slices"
+	"strings"
+)
+
+func init() {
+	RegisterHandler("modbus", HandlerFunc(serveModbus))
+}
+
+// Modbus function codes answered by the modbus handler.
+const (
+	modbusReadCoils              = 0x01
+	modbusReadDiscreteInputs     = 0x02
+	modbusReadHoldingRegisters   = 0x03
+	modbusReadInputRegisters     = 0x04
+	modbusWriteSingleCoil        = 0x05
+	modbusWriteSingleRegister    = 0x06
+	modbusWriteMultipleCoils     = 0x0F
+	modbusWriteMultipleRegisters = 0x10
+	modbusReportServerID         = 0x11
+	modbusEncapsulatedInterface  = 0x2B
+	modbusReadDeviceID           = 0x0E // MEI type of Read Device Identification
+)
+
+// Modbus exception codes.
+const (
+	modbusIllegalFunction    = 0x01
+	modbusIllegalAddress     = 0x02
+	modbusIllegalValue       = 0x03
+	modbusGatewayNoResponse  = 0x0B
+	modbusMaxFrame           = 260 // MBAP header and the largest PDU
+	modbusMaxReadBits        = 2000
+	modbusMaxReadRegisters   = 125
+	modbusMaxWriteBits       = 1968
+	modbusMaxWriteRegisters  = 123
+	modbusDefaultRegisterLen = 128
+)
+
+var modbusFunctionNames = map[byte]string{
+	modbusReadCoils:              "Read Coils",
+	modbusReadDiscreteInputs:     "Read Discrete Inputs",
+	modbusReadHoldingRegisters:   "Read Holding Registers",
+	modbusReadInputRegisters:     "Read Input Registers",
+	modbusWriteSingleCoil:        "Write Single Coil",
+	modbusWriteSingleRegister:    "Write Single Register",
+	modbusWriteMultipleCoils:     "Write Multiple Coils",
+	modbusWriteMultipleRegisters: "Write Multiple Registers",
+	modbusReportServerID:         "Report Server ID",
+	modbusEncapsulatedInterface:  "Read Device Identification",
+	0x07:                         "Read Exception Status",
+	0x08:                         "Diagnostics",
+	0x14:                         "Read File Record",
+	0x15:                         "Write File Record",
+	0x16:                         "Mask Write Register",
+	0x17:                         "Read/Write Multiple Registers",
+	0x5A:                         "Schneider UMAS",
+}
+
+// ModbusDevice is the PLC impersonated by the modbus handler: its
+// identification strings, the unit IDs it answers and its data tables.
+// Coils and discrete inputs are strings of '0' and '1', one per address.
+type ModbusDevice struct {
+	Vendor           string   `json:"vendor"`
+	ProductCode      string   `json:"product_code"`
+	Revision         string   `json:"revision"`
+	ProductName      string   `json:"product_name,omitempty"`
+	ModelName        string   `json:"model_name,omitempty"`
+	Units            []int    `json:"units,omitempty"` // unit IDs answered, empty for all
+	Coils            string   `json:"coils,omitempty"`
+	DiscreteInputs   string   `json:"discrete_inputs,omitempty"`
+	HoldingRegisters []uint16 `json:"holding_registers,omitempty"`
+	InputRegisters   []uint16 `json:"input_registers,omitempty"`
+}
+
+// DefaultModbusDevice returns a Schneider Electric Modicon M340 with
+// plausible process values.
+func DefaultModbusDevice() *ModbusDevice {
+	d := &ModbusDevice{
+		Vendor:      "Schneider Electric",
+		ProductCode: "BMX P34 2020",
+		Revision:    "v3.10",
+		ProductName: "Modicon M340",
+		ModelName:   "BMX P34 2020",
+	}
+	d.fillDefaults()
+	return d
+}
+
+// LoadModbusDevice reads a device profile in JSON on top of DefaultModbusDevice.
+// Data tables left out of the profile get generated values.
+func LoadModbusDevice(path string) (*ModbusDevice, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &ModbusDevice{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(d); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	defaults := DefaultModbusDevice()
+	if d.Vendor == "" {
+		d.Vendor = defaults.Vendor
+	}
+	if d.ProductCode == "" {
+		d.ProductCode = defaults.ProductCode
+	}
+	if d.Revision == "" {
+		d.Revision = defaults.Revision
+	}
+	for _, unit := range d.Units {
+		if unit < 0 || unit > 255 {
+			return nil, fmt.Errorf("%s: unit ID %d out of range 0-255", path, unit)
+		}
+	}
+	for name, bits := range map[string]string{"coils": d.Coils, "discrete_inputs": d.DiscreteInputs} {
+		if strings.Trim(bits, "01") != "" {
+			return nil, fmt.Errorf("%s: %s must only contain 0 and 1", path, name)
+		}
+	}
+	d.fillDefaults()
+	return d, nil
+}
+
+// fillDefaults generates the data tables missing from d. Values are derived
+// from the product so a device looks the same across restarts: mostly idle
+// outputs, and registers holding setpoints, counters and slowly varying
+// measurements.
+func (d *ModbusDevice) fillDefaults() {
+	h := fnv.New64a()
+	h.Write([]byte(d.Vendor + d.ProductCode))
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+	bits := func() string {
+		var b strings.Builder
+		for i := 0; i < modbusDefaultRegisterLen; i++ {
+			if rng.Intn(4) == 0 {
+				b.WriteByte('1')
+			} else {
+				b.WriteByte('0')
+			}
+		}
+		return b.String()
+	}
+	registers := func() []uint16 {
+		values := make([]uint16, modbusDefaultRegisterLen)
+		for i := range values {
+			switch i % 8 {
+			case 0, 1:
+				values[i] = uint16(180 + rng.Intn(80)) // temperatures in tenths of a degree
+			case 2:
+				values[i] = uint16(rng.Intn(1000)) // flow
+			case 3:
+				values[i] = uint16(1000 + rng.Intn(50)*10) // setpoint
+			case 4:
+				values[i] = uint16(rng.Intn(65536)) // counter
+			default:
+				if rng.Intn(3) > 0 {
+					values[i] = uint16(rng.Intn(100))
+				}
+			}
+		}
+		return values
+	}
+	if d.Coils == "" {
+		d.Coils = bits()
+	}
+	if d.DiscreteInputs == "" {
+		d.DiscreteInputs = bits()
+	}
+	if d.HoldingRegisters == nil {
+		d.HoldingRegisters = registers()
+	}
+	if d.InputRegisters == nil {
+		d.InputRegisters = registers()
+	}
+}
+
+// modbusSession is the state of one connection: writes change the client's
+// copy of the data tables, so an attacker reading back sees its writes
+// without other sessions being affected.
+type modbusSession struct {
+	device    *ModbusDevice
+	coils     []bool
+	inputs    []bool
+	holding   []uint16
+	inputRegs []uint16
+}
+
+func newModbusSession(d *ModbusDevice) *modbusSession {
+	toBools := func(bits string) []bool {
+		values := make([]bool, len(bits))
+		for i := range bits {
+			values[i] = bits[i] == '1'
+		}
+		return values
+	}
+	return &modbusSession{
+		device:    d,
+		coils:     toBools(d.Coils),
+		inputs:    toBools(d.DiscreteInputs),
+		holding:   slices.Clone(d.HoldingRegisters),
+		inputRegs: slices.Clone(d.InputRegisters),
+	}
+}
+
+// serveModbus speaks Modbus/TCP: it answers reads, writes and device
+// identification from the server's ModbusDevice, answers other functions
+// with an exception, and logs the unit ID and function of every request.
+// Writes raise an ics_write alert.
+func serveModbus(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	device := meta.server.ModbusDevice
+	if device == nil {
+		device = DefaultModbusDevice()
+	}
+	session := newModbusSession(device)
+	total := 0
+	header := make([]byte, 7)
+	for {
+		conn.SetReadDeadline(meta.NextReadDeadline(total > 0))
+		n, err := io.ReadFull(conn, header)
+		total += n
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			meta.LogData(string(header[:n]))
+			return nil
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			if n > 0 {
+				meta.LogData(string(header[:n]))
+			}
+			switch {
+			case meta.SessionExpired():
+				meta.Logf("Closing session on port %s from %s: maximum duration of %s reached", meta.Port, meta.ClientAddr, meta.Limits.SessionTimeout)
+			case total == 0:
+				meta.Logf("Closing session on port %s from %s: no data within %s", meta.Port, meta.ClientAddr, meta.Limits.ConnectTimeout)
+			default:
+				meta.Logf("Closing session on port %s from %s: idle for %s", meta.Port, meta.ClientAddr, meta.Limits.IdleTimeout)
+			}
+			return nil
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil // shutting down
+			}
+			return fmt.Errorf("reading from connection: %w", err)
+		}
+
+		transaction := binary.BigEndian.Uint16(header[0:2])
+		protocol := binary.BigEndian.Uint16(header[2:4])
+		length := int(binary.BigEndian.Uint16(header[4:6]))
+		unit := header[6]
+		if protocol != 0 || length < 2 || length > modbusMaxFrame-6 {
+			// Not Modbus: log what the client sent, e.g. an HTTP probe
+			rest := make([]byte, meta.Limits.ReadBuffer)
+			conn.SetReadDeadline(meta.NextReadDeadline(true))
+			m, _ := conn.Read(rest)
+			meta.LogData(string(header) + string(rest[:m]))
+			return nil
+		}
+		pdu := make([]byte, length-1)
+		conn.SetReadDeadline(meta.NextReadDeadline(true))
+		n, err = io.ReadFull(conn, pdu)
+		total += n
+		if err != nil {
+			meta.LogData(string(header) + string(pdu[:n]))
+			return nil
+		}
+
+		response := session.handle(meta, unit, pdu, string(header)+string(pdu))
+		if response == nil {
+			continue // unit not served: real gateways stay silent too
+		}
+		frame := make([]byte, 7, 7+len(response))
+		binary.BigEndian.PutUint16(frame[0:2], transaction)
+		binary.BigEndian.PutUint16(frame[4:6], uint16(len(response)+1))
+		frame[6] = unit
+		if _, err := conn.Write(append(frame, response...)); err != nil {
+			return fmt.Errorf("writing to connection: %w", err)
+		}
+		if total >= meta.Limits.MaxBytes {
+			meta.Logf("Closing session on port %s from %s: %d bytes received", meta.Port, meta.ClientAddr, total)
+			return nil
+		}
+	}
+}
+
+// handle logs a request and returns the response PDU, or nil to send nothing.
+func (s *modbusSession) handle(meta *ConnMeta, unit byte, pdu []byte, raw string) []byte {
+	function := pdu[0]
+	data := pdu[1:]
+	name, known := modbusFunctionNames[function]
+	if !known {
+		name = fmt.Sprintf("function 0x%02X", function)
+	}
+	fields := map[string]any{"data": raw, "modbus_unit": int(unit), "modbus_function": int(function), "modbus_function_name": name}
+	detail := ""
+	if len(data) >= 4 && function >= modbusReadCoils && function <= modbusWriteMultipleRegisters {
+		address, value := binary.BigEndian.Uint16(data[0:2]), binary.BigEndian.Uint16(data[2:4])
+		fields["modbus_address"] = int(address)
+		if function == modbusWriteSingleCoil || function == modbusWriteSingleRegister {
+			fields["modbus_value"] = int(value)
+			detail = fmt.Sprintf(" address %d value %d", address, value)
+		} else {
+			fields["modbus_quantity"] = int(value)
+			detail = fmt.Sprintf(" address %d quantity %d", address, value)
+		}
+	}
+	meta.Emit(Event{
+		Type:    EventData,
+		Message: fmt.Sprintf("Modbus request on port %s from %s: unit %d %s%s", meta.Port, meta.ClientAddr, unit, name, detail),
+		Fields:  fields,
+	})
+
+	if len(s.device.Units) > 0 && !slices.Contains(s.device.Units, int(unit)) {
+		return nil
+	}
+	response, exception := s.execute(function, data)
+	if exception != 0 {
+		return []byte{function | 0x80, exception}
+	}
+	switch function {
+	case modbusWriteSingleCoil, modbusWriteSingleRegister, modbusWriteMultipleCoils, modbusWriteMultipleRegisters:
+		if meta.Stage < StageExploit {
+			meta.Stage = StageExploit
+		}
+		meta.RaiseAlert("medium", "ics_write", fmt.Sprintf("Modbus %s%s on unit %d from %s", name, detail, unit, meta.ClientAddr))
+	}
+	return append([]byte{function}, response...)
+}
+
+// execute runs a request against the session's data tables and returns the
+// response data or an exception code.
+func (s *modbusSession) execute(function byte, data []byte) ([]byte, byte) {
+	switch function {
+	case modbusReadCoils, modbusReadDiscreteInputs:
+		table := s.coils
+		if function == modbusReadDiscreteInputs {
+			table = s.inputs
+		}
+		address, quantity, exception := modbusRange(data, len(table), modbusMaxReadBits)
+		if exception != 0 {
+			return nil, exception
+		}
+		return append([]byte{byte(len(packBits(table[address : address+quantity])))}, packBits(table[address:address+quantity])...), 0
+
+	case modbusReadHoldingRegisters, modbusReadInputRegisters:
+		table := s.holding
+		if function == modbusReadInputRegisters {
+			table = s.inputRegs
+		}
+		address, quantity, exception := modbusRange(data, len(table), modbusMaxReadRegisters)
+		if exception != 0 {
+			return nil, exception
+		}
+		response := []byte{byte(quantity * 2)}
+		for _, v := range table[address : address+quantity] {
+			response = binary.BigEndian.AppendUint16(response, v)
+		}
+		return response, 0
+
+	case modbusWriteSingleCoil:
+		if len(data) != 4 {
+			return nil, modbusIllegalValue
+		}
+		address, value := int(binary.BigEndian.Uint16(data[0:2])), binary.BigEndian.Uint16(data[2:4])
+		if value != 0xFF00 && value != 0x0000 {
+			return nil, modbusIllegalValue
+		}
+		if address >= len(s.coils) {
+			return nil, modbusIllegalAddress
+		}
+		s.coils[address] = value == 0xFF00
+		return data, 0
+
+	case modbusWriteSingleRegister:
+		if len(data) != 4 {
+			return nil, modbusIllegalValue
+		}
+		address := int(binary.BigEndian.Uint16(data[0:2]))
+		if address >= len(s.holding) {
+			return nil, modbusIllegalAddress
+		}
+		s.holding[address] = binary.BigEndian.Uint16(data[2:4])
+		return data, 0
+
+	case modbusWriteMultipleCoils:
+		address, quantity, exception := modbusRange(data, len(s.coils), modbusMaxWriteBits)
+		if exception != 0 {
+			return nil, exception
+		}
+		if len(data) < 5 || int(data[4]) != (quantity+7)/8 || len(data) != 5+int(data[4]) {
+			return nil, modbusIllegalValue
+		}
+		for i := 0; i < quantity; i++ {
+			s.coils[address+i] = data[5+i/8]&(1<<(i%8)) != 0
+		}
+		return data[:4], 0
+
+	case modbusWriteMultipleRegisters:
+		address, quantity, exception := modbusRange(data, len(s.holding), modbusMaxWriteRegisters)
+		if exception != 0 {
+			return nil, exception
+		}
+		if len(data) < 5 || int(data[4]) != quantity*2 || len(data) != 5+quantity*2 {
+			return nil, modbusIllegalValue
+		}
+		for i := 0; i < quantity; i++ {
+			s.holding[address+i] = binary.BigEndian.Uint16(data[5+2*i:])
+		}
+		return data[:4], 0
+
+	case modbusReportServerID:
+		id := []byte(s.device.ProductCode)
+		response := []byte{byte(len(id) + 2)}
+		response = append(response, id...)
+		return append(response, 0x00, 0xFF), 0 // server ID, run indicator: running
+
+	case modbusEncapsulatedInterface:
+		if len(data) < 3 || data[0] != modbusReadDeviceID {
+			return nil, modbusIllegalFunction
+		}
+		return s.deviceIdentification(data[1], data[2])
+	}
+	return nil, modbusIllegalFunction
+}
+
+// deviceIdentification answers Read Device Identification: basic objects
+// (vendor, product code, revision) for code 1, regular ones for codes 2 and
+// up, a single object for code 4.
+func (s *modbusSession) deviceIdentification(code, objectID byte) ([]byte, byte) {
+	objects := []string{s.device.Vendor, s.device.ProductCode, s.device.Revision, "", s.device.ProductName, s.device.ModelName}
+	first, last := 0, 2
+	switch code {
+	case 1:
+	case 2, 3:
+		last = len(objects) - 1
+	case 4:
+		if int(objectID) >= len(objects) {
+			return nil, modbusIllegalAddress
+		}
+		first, last = int(objectID), int(objectID)
+	default:
+		return nil, modbusIllegalValue
+	}
+	response := []byte{modbusReadDeviceID, code, 0x02, 0x00, 0x00, 0x00} // conformity level 2, no more objects
+	count := 0
+	for id := first; id <= last; id++ {
+		if objects[id] == "" && id > 2 {
+			continue
+		}
+		response = append(response, byte(id), byte(len(objects[id])))
+		response = append(response, objects[id]...)
+		count++
+	}
+	response[5] = byte(count)
+	return response, 0
+}
+
+// modbusRange parses the address and quantity of a request and checks them
+// against the table size and the protocol maximum.
+func modbusRange(data []byte, size, maxQuantity int) (address, quantity int, exception byte) {
+	if len(data) < 4 {
+		return 0, 0, modbusIllegalValue
+	}
+	address = int(binary.BigEndian.Uint16(data[0:2]))
+	quantity = int(binary.BigEndian.Uint16(data[2:4]))
+	if quantity < 1 || quantity > maxQuantity {
+		return 0, 0, modbusIllegalValue
+	}
+	if address+quantity > size {
+		return 0, 0, modbusIllegalAddress
+	}
+	return address, quantity, 0
+}
+
+// packBits packs coil values, least significant bit first.
+func packBits(values []bool) []byte {
+	packed := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	return packed
+}
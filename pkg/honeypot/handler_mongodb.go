@@ -0,0 +1,454 @@
+package honeypot
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	RegisterHandler("mongodb", HandlerFunc(serveMongoDB))
+}
+
+// MongoDB wire protocol opcodes.
+const (
+	mongoOpReply      = 1
+	mongoOpQuery      = 2004
+	mongoOpMsg        = 2013
+	mongoMaxMessage   = 4 << 20
+	mongoMaxBSONSize  = 16 << 20
+	mongoChecksumFlag = 1 << 0
+	mongoMoreToCome   = 1 << 1
+)
+
+// mongoConnectionIDs numbers connections like mongod, which reports the
+// number in hello responses and its log.
+var mongoConnectionIDs atomic.Int32
+
+// mongoDatabases are the databases of the impersonated server besides the
+// built-in ones, with their size on disk.
+var mongoDatabases = []struct {
+	name        string
+	size        int64
+	collections []string
+}{
+	{"admin", 40960, []string{"system.version"}},
+	{"config", 110592, []string{"system.sessions"}},
+	{"local", 73728, []string{"startup_log"}},
+	{"app", 83886080, []string{"users", "orders", "payments", "sessions"}},
+}
+
+// mongoSession is the state of a MongoDB connection.
+type mongoSession struct {
+	conn         net.Conn
+	meta         *ConnMeta
+	connectionID int32
+	requestID    int32
+	dropped      map[string]bool // databases the client dropped, false once written to again
+}
+
+// serveMongoDB impersonates an unauthenticated MongoDB server, the kind
+// wiped by ransom campaigns: it answers hello, buildInfo and the other
+// commands drivers send on connecting over OP_MSG and legacy OP_QUERY,
+// lists a few databases and acknowledges writes. Every command is logged
+// with its database and arguments, including the documents inserted, so
+// ransom notes are kept; drops raise a high mongodb_drop alert.
+func serveMongoDB(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	s := &mongoSession{conn: conn, meta: meta, connectionID: mongoConnectionIDs.Add(1), dropped: make(map[string]bool)}
+	received := false
+	for {
+		conn.SetReadDeadline(meta.NextReadDeadline(received))
+		header := make([]byte, 16)
+		if n, err := io.ReadFull(conn, header); err != nil {
+			return binaryReadError(ctx, meta, header[:n], err, received)
+		}
+		received = true
+		length := int(int32(binary.LittleEndian.Uint32(header)))
+		if bytes.HasPrefix(header, []byte("GET ")) || bytes.HasPrefix(header, []byte("POST ")) || bytes.HasPrefix(header, []byte("HEAD ")) {
+			// mongod's answer to HTTP requests on its port
+			rest := make([]byte, meta.Limits.ReadBuffer)
+			n, _ := conn.Read(rest)
+			meta.LogData(string(append(header, rest[:n]...)))
+			text := "It looks like you are trying to access MongoDB over HTTP on the native driver port.\n"
+			fmt.Fprintf(conn, "HTTP/1.0 200 OK\r\nConnection: close\r\nContent-Type: text/plain\r\nContent-Length: %d\r\n\r\n%s", len(text), text)
+			return nil
+		}
+		if length < 16 || length > mongoMaxMessage {
+			rest := make([]byte, 1024)
+			n, _ := conn.Read(rest)
+			meta.LogData(string(append(header, rest[:n]...)))
+			return nil
+		}
+		msg := make([]byte, length)
+		copy(msg, header)
+		if n, err := io.ReadFull(conn, msg[16:]); err != nil {
+			return binaryReadError(ctx, meta, msg[:16+n], err, true)
+		}
+		reply, ok := s.handle(msg)
+		if !ok {
+			meta.LogData(string(msg))
+			return nil
+		}
+		if reply != nil {
+			if _, err := conn.Write(reply); err != nil {
+				return fmt.Errorf("writing to connection: %w", err)
+			}
+		}
+	}
+}
+
+// handle answers a message, returning no reply for requests that expect
+// none and false for messages that are not MongoDB's.
+func (s *mongoSession) handle(msg []byte) ([]byte, bool) {
+	requestID := int32(binary.LittleEndian.Uint32(msg[4:]))
+	opCode := binary.LittleEndian.Uint32(msg[12:])
+	switch opCode {
+	case mongoOpMsg:
+		if len(msg) < 21 {
+			return nil, false
+		}
+		flags := binary.LittleEndian.Uint32(msg[16:])
+		sections := msg[20:]
+		if flags&mongoChecksumFlag != 0 && len(sections) >= 4 {
+			sections = sections[:len(sections)-4]
+		}
+		var body bsonDoc
+		for len(sections) > 0 {
+			switch sections[0] {
+			case 0:
+				doc, n, err := parseBSON(sections[1:])
+				if err != nil {
+					return nil, false
+				}
+				body = append(doc, body...)
+				sections = sections[1+n:]
+			case 1:
+				// Document sequence, e.g. the documents of an insert
+				if len(sections) < 5 {
+					return nil, false
+				}
+				size := int(int32(binary.LittleEndian.Uint32(sections[1:])))
+				if size < 5 || size > len(sections)-1 {
+					return nil, false
+				}
+				seq := sections[5 : 1+size]
+				identifier, docs, ok := bytes.Cut(seq, []byte{0})
+				if !ok {
+					return nil, false
+				}
+				var values []any
+				for len(docs) > 0 {
+					doc, n, err := parseBSON(docs)
+					if err != nil {
+						return nil, false
+					}
+					values = append(values, doc)
+					docs = docs[n:]
+				}
+				body = append(body, bsonElem{Key: string(identifier), Value: values})
+				sections = sections[1+size:]
+			default:
+				return nil, false
+			}
+		}
+		if len(body) == 0 {
+			return nil, false
+		}
+		resp := s.command(body.String("$db"), body)
+		if flags&mongoMoreToCome != 0 {
+			return nil, true
+		}
+		reply := binary.LittleEndian.AppendUint32(nil, 0) // flags
+		reply = append(reply, 0)
+		return s.reply(requestID, mongoOpMsg, appendBSON(reply, resp)), true
+	case mongoOpQuery:
+		if len(msg) < 20 {
+			return nil, false
+		}
+		collection, rest, ok := bytes.Cut(msg[20:], []byte{0})
+		if !ok || len(rest) < 8 {
+			return nil, false
+		}
+		query, _, err := parseBSON(rest[8:])
+		if err != nil {
+			return nil, false
+		}
+		if wrapped := query.Doc("$query"); wrapped != nil {
+			query = wrapped
+		} else if wrapped := query.Doc("query"); wrapped != nil {
+			query = wrapped
+		}
+		db, name, _ := strings.Cut(string(collection), ".")
+		var resp bsonDoc
+		if name == "$cmd" && len(query) > 0 {
+			resp = s.command(db, query)
+		} else {
+			// A legacy query on a collection: find with the query as filter
+			s.command(db, bsonDoc{{Key: "find", Value: name}, {Key: "filter", Value: query}})
+		}
+		reply := binary.LittleEndian.AppendUint32(nil, 0) // responseFlags
+		reply = binary.LittleEndian.AppendUint64(reply, 0)
+		reply = binary.LittleEndian.AppendUint32(reply, 0)
+		if resp == nil {
+			reply = binary.LittleEndian.AppendUint32(reply, 0)
+		} else {
+			reply = binary.LittleEndian.AppendUint32(reply, 1)
+			reply = appendBSON(reply, resp)
+		}
+		return s.reply(requestID, mongoOpReply, reply), true
+	}
+	// OP_COMPRESSED is never negotiated, and the legacy write opcodes are
+	// gone since MongoDB 6.0
+	s.meta.LogData(string(msg))
+	return nil, true
+}
+
+func (s *mongoSession) reply(responseTo int32, opCode uint32, body []byte) []byte {
+	s.requestID++
+	msg := binary.LittleEndian.AppendUint32(nil, uint32(16+len(body)))
+	msg = binary.LittleEndian.AppendUint32(msg, uint32(s.requestID))
+	msg = binary.LittleEndian.AppendUint32(msg, uint32(responseTo))
+	msg = binary.LittleEndian.AppendUint32(msg, opCode)
+	return append(msg, body...)
+}
+
+// command logs a command and returns its result.
+func (s *mongoSession) command(db string, cmd bsonDoc) bsonDoc {
+	name := cmd[0].Key
+	target, _ := cmd[0].Value.(string)
+	content, _ := json.Marshal(cmd)
+	fields := map[string]any{"data": string(content), "mongo_command": name, "mongo_database": db}
+	message := fmt.Sprintf("MongoDB %s command on database %s on port %s from %s", name, db, s.meta.Port, s.meta.ClientAddr)
+	if target != "" {
+		fields["mongo_collection"] = target
+		message = fmt.Sprintf("MongoDB %s command on %s.%s on port %s from %s", name, db, target, s.meta.Port, s.meta.ClientAddr)
+	}
+	if client := cmd.Doc("client"); client != nil {
+		driver := client.Doc("driver")
+		fields["mongo_client_driver"] = strings.TrimSpace(driver.String("name") + " " + driver.String("version"))
+		os := client.Doc("os")
+		fields["mongo_client_os"] = strings.TrimSpace(os.String("type") + " " + os.String("name"))
+		if app := client.Doc("application").String("name"); app != "" {
+			fields["mongo_client_app"] = app
+		}
+	}
+	s.meta.Emit(Event{Type: EventData, Message: message + ": " + escapePayload(string(content)), Fields: fields})
+	s.meta.Observe(string(content))
+
+	ok := bsonElem{Key: "ok", Value: 1.0}
+	switch strings.ToLower(name) {
+	case "hello", "ismaster":
+		if auth := cmd.Doc("speculativeAuthenticate"); auth != nil {
+			s.logAuthentication(auth)
+		}
+		return s.hello(name)
+	case "buildinfo":
+		return s.buildInfo()
+	case "ping", "endsessions", "killcursors", "getparameter", "setparameter", "createindexes", "create":
+		return bsonDoc{ok}
+	case "whatsmyuri":
+		return bsonDoc{{Key: "you", Value: s.meta.ClientAddr}, ok}
+	case "getcmdlineopts":
+		return bsonDoc{
+			{Key: "argv", Value: []any{"/usr/bin/mongod", "--config", "/etc/mongod.conf"}},
+			{Key: "parsed", Value: bsonDoc{
+				{Key: "config", Value: "/etc/mongod.conf"},
+				{Key: "net", Value: bsonDoc{{Key: "bindIp", Value: "0.0.0.0"}, {Key: "port", Value: int32(27017)}}},
+				{Key: "storage", Value: bsonDoc{{Key: "dbPath", Value: "/var/lib/mongodb"}}},
+			}},
+			ok,
+		}
+	case "serverstatus":
+		return bsonDoc{
+			{Key: "host", Value: strings.ToLower(s.meta.Identity.Hostname)},
+			{Key: "version", Value: s.version()},
+			{Key: "process", Value: "mongod"},
+			{Key: "uptime", Value: float64(int(time.Since(s.meta.server.started).Seconds()))},
+			{Key: "localTime", Value: time.Now()},
+			ok,
+		}
+	case "getlog":
+		return bsonDoc{{Key: "totalLinesWritten", Value: int32(0)}, {Key: "log", Value: []any{}}, ok}
+	case "currentop":
+		return bsonDoc{{Key: "inprog", Value: []any{}}, ok}
+	case "listdatabases":
+		var databases []any
+		var total int64
+		for _, d := range mongoDatabases {
+			size := d.size
+			if dropped, ok := s.dropped[d.name]; ok {
+				if dropped {
+					continue
+				}
+				size = 8192
+			}
+			total += size
+			databases = append(databases, bsonDoc{{Key: "name", Value: d.name}, {Key: "sizeOnDisk", Value: size}, {Key: "empty", Value: false}})
+		}
+		return bsonDoc{{Key: "databases", Value: databases}, {Key: "totalSize", Value: total}, {Key: "totalSizeMb", Value: total >> 20}, ok}
+	case "listcollections":
+		var collections []any
+		for _, d := range mongoDatabases {
+			if _, dropped := s.dropped[db]; d.name != db || dropped {
+				continue
+			}
+			for _, c := range d.collections {
+				collections = append(collections, bsonDoc{{Key: "name", Value: c}, {Key: "type", Value: "collection"}, {Key: "options", Value: bsonDoc{}}})
+			}
+		}
+		return mongoCursor(db+".$cmd.listCollections", collections)
+	case "find", "aggregate", "listindexes":
+		return mongoCursor(db+"."+target, nil)
+	case "count":
+		return bsonDoc{{Key: "n", Value: int32(0)}, ok}
+	case "insert":
+		documents, _ := cmd.Get("documents").([]any)
+		if _, ok := s.dropped[db]; ok {
+			s.dropped[db] = false
+		}
+		return bsonDoc{{Key: "n", Value: int32(len(documents))}, ok}
+	case "update":
+		return bsonDoc{{Key: "n", Value: int32(0)}, {Key: "nModified", Value: int32(0)}, ok}
+	case "delete":
+		return bsonDoc{{Key: "n", Value: int32(0)}, ok}
+	case "drop":
+		s.meta.RaiseAlert("high", "mongodb_drop", fmt.Sprintf("%s dropped collection %s.%s", s.meta.ClientAddr, db, target))
+		return bsonDoc{{Key: "nIndexesWas", Value: int32(1)}, {Key: "ns", Value: db + "." + target}, ok}
+	case "dropdatabase":
+		s.meta.RaiseAlert("high", "mongodb_drop", fmt.Sprintf("%s dropped database %s", s.meta.ClientAddr, db))
+		s.dropped[db] = true
+		return bsonDoc{{Key: "dropped", Value: db}, ok}
+	case "saslstart", "authenticate":
+		s.logAuthentication(cmd)
+		return mongoError(18, "AuthenticationFailed", "Authentication failed.")
+	case "saslcontinue", "logout":
+		return mongoError(18, "AuthenticationFailed", "Authentication failed.")
+	}
+	return mongoError(59, "CommandNotFound", fmt.Sprintf("no such command: '%s'", name))
+}
+
+// logAuthentication logs the user of a saslStart, speculative or not, or of
+// a legacy authenticate.
+func (s *mongoSession) logAuthentication(cmd bsonDoc) {
+	user := cmd.String("user")
+	if payload, ok := cmd.Get("payload").([]byte); ok {
+		// SCRAM client-first-message: "n,,n=<user>,r=<nonce>"
+		for _, attr := range strings.Split(string(payload), ",") {
+			if strings.HasPrefix(attr, "n=") {
+				user = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(attr[2:])
+			}
+		}
+	}
+	if user == "" {
+		return
+	}
+	s.meta.Emit(Event{
+		Type: EventData,
+		Message: fmt.Sprintf("MongoDB authentication as %q with %s on port %s from %s",
+			user, cmd.String("mechanism"), s.meta.Port, s.meta.ClientAddr),
+		Fields: map[string]any{"mongo_user": user, "mongo_mechanism": cmd.String("mechanism")},
+	})
+	s.meta.LogCredential(Credential{Username: user})
+}
+
+// version returns the MongoDB version of the host identity.
+func (s *mongoSession) version() string {
+	if version := s.meta.Identity.Software["mongodb"]; version != "" {
+		return version
+	}
+	return "6.0.14"
+}
+
+// mongoWireVersions are the wire protocol versions of MongoDB releases.
+var mongoWireVersions = map[string]int32{"4.0": 7, "4.2": 8, "4.4": 9, "5.0": 13, "6.0": 17, "7.0": 21}
+
+func (s *mongoSession) hello(name string) bsonDoc {
+	version := s.version()
+	major, minor, _ := strings.Cut(version, ".")
+	minor, _, _ = strings.Cut(minor, ".")
+	wire, ok := mongoWireVersions[major+"."+minor]
+	if !ok {
+		wire = 17
+	}
+	primary := bsonElem{Key: "isWritablePrimary", Value: true}
+	if name != "hello" {
+		primary.Key = "ismaster"
+	}
+	return bsonDoc{
+		primary,
+		{Key: "topologyVersion", Value: bsonDoc{{Key: "processId", Value: mongoProcessID(s.meta.server)}, {Key: "counter", Value: int64(0)}}},
+		{Key: "maxBsonObjectSize", Value: int32(mongoMaxBSONSize)},
+		{Key: "maxMessageSizeBytes", Value: int32(48000000)},
+		{Key: "maxWriteBatchSize", Value: int32(100000)},
+		{Key: "localTime", Value: time.Now()},
+		{Key: "logicalSessionTimeoutMinutes", Value: int32(30)},
+		{Key: "connectionId", Value: s.connectionID},
+		{Key: "minWireVersion", Value: int32(0)},
+		{Key: "maxWireVersion", Value: wire},
+		{Key: "readOnly", Value: false},
+		{Key: "ok", Value: 1.0},
+	}
+}
+
+func (s *mongoSession) buildInfo() bsonDoc {
+	version := s.version()
+	var versionArray []any
+	for _, part := range strings.SplitN(strings.SplitN(version, "-", 2)[0], ".", 3) {
+		n, _ := strconv.Atoi(part)
+		versionArray = append(versionArray, int32(n))
+	}
+	versionArray = append(versionArray, int32(0))
+	return bsonDoc{
+		{Key: "version", Value: version},
+		{Key: "gitVersion", Value: "25fbd5d7ec3e190a1d6f1b7e0a51ad3b3f9c9e68"},
+		{Key: "modules", Value: []any{}},
+		{Key: "allocator", Value: "tcmalloc"},
+		{Key: "javascriptEngine", Value: "mozjs"},
+		{Key: "sysInfo", Value: "deprecated"},
+		{Key: "versionArray", Value: versionArray},
+		{Key: "openssl", Value: bsonDoc{{Key: "running", Value: "OpenSSL 3.0.11 19 Sep 2023"}, {Key: "compiled", Value: "OpenSSL 3.0.9 30 May 2023"}}},
+		{Key: "bits", Value: int32(64)},
+		{Key: "debug", Value: false},
+		{Key: "maxBsonObjectSize", Value: int32(mongoMaxBSONSize)},
+		{Key: "storageEngines", Value: []any{"devnull", "ephemeralForTest", "wiredTiger"}},
+		{Key: "ok", Value: 1.0},
+	}
+}
+
+// mongoProcessID returns the ObjectId of the server process, created when
+// the server started.
+func mongoProcessID(srv *Server) bsonObjectID {
+	var id bsonObjectID
+	binary.BigEndian.PutUint32(id[:], uint32(srv.started.Unix()))
+	binary.BigEndian.PutUint64(id[4:], uint64(srv.started.UnixNano()))
+	return id
+}
+
+// mongoCursor returns the result of a command returning documents, all of
+// them in the first batch.
+func mongoCursor(ns string, batch []any) bsonDoc {
+	if batch == nil {
+		batch = []any{}
+	}
+	return bsonDoc{
+		{Key: "cursor", Value: bsonDoc{{Key: "id", Value: int64(0)}, {Key: "ns", Value: ns}, {Key: "firstBatch", Value: batch}}},
+		{Key: "ok", Value: 1.0},
+	}
+}
+
+func mongoError(code int32, codeName, message string) bsonDoc {
+	return bsonDoc{
+		{Key: "ok", Value: 0.0},
+		{Key: "errmsg", Value: message},
+		{Key: "code", Value: code},
+		{Key: "codeName", Value: codeName},
+	}
+}
@@ -0,0 +1,265 @@
+package honeypot
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterPacketHandler("ntp", PacketHandlerFunc(serveNTP))
+}
+
+// NTP modes handled.
+const (
+	ntpModeClient  = 3
+	ntpModeServer  = 4
+	ntpModeControl = 6 // ntpq
+	ntpModePrivate = 7 // ntpdc
+)
+
+// Mode 6 opcodes and mode 7 request codes.
+const (
+	ntpReadStat      = 1
+	ntpReadVar       = 2
+	ntpMonGetList    = 20
+	ntpMonGetList1   = 42
+	ntpImplXNTPD     = 3
+	ntpErrRequest    = 2 // INFO_ERR_REQ, unknown request code
+	ntpMonitorItem   = 72
+	ntpMonlistLength = 6 // clients listed, a quiet LAN time server
+)
+
+var ntpControlOps = map[byte]string{
+	1: "readstat", 2: "readvar", 3: "writevar", 4: "readclock", 5: "writeclock", 6: "settrap",
+	8: "configure", 9: "saveconfig", 10: "read_mru", 11: "read_ordlist", 12: "req_nonce", 31: "unsettrap",
+}
+
+var ntpPrivateRequests = map[byte]string{
+	0: "peer_list", 1: "peer_list_sum", 2: "peer_info", 4: "sys_info", 5: "sys_stats", 6: "io_stats",
+	ntpMonGetList: "monlist", ntpMonGetList1: "monlist", 32: "if_stats",
+}
+
+// ntpRefServers are upstream servers the impersonated ntpd is synchronised
+// to, one picked per host.
+var ntpRefServers = []string{"162.159.200.1", "216.239.35.0", "129.6.15.28", "194.58.200.20"}
+
+// ntpState is what the ntp handler remembers of a session.
+type ntpState struct {
+	reported map[string]bool // ddos_prep kinds already emitted
+}
+
+// serveNTP impersonates ntpd 4.2.8: client requests get a stratum 2 time,
+// ntpq readvar and readstat get the system variables and ntpdc monlist a
+// short list of recent clients, the requester first, like
+// servers that still have the monitor enabled. Mode 6 and 7 queries are the
+// reconnaissance of NTP amplification attacks and emit a ddos_prep event
+// per session and kind, besides the log of every request. Windows hosts
+// only answer time requests, like W32Time.
+func serveNTP(ctx context.Context, packet []byte, meta *ConnMeta) [][]byte {
+	if len(packet) < 4 {
+		meta.LogData(string(packet))
+		return nil
+	}
+	version := packet[0] >> 3 & 0x07
+	mode := packet[0] & 0x07
+	var request string
+	switch mode {
+	case ntpModeClient:
+		request = "time request"
+	case ntpModeControl:
+		request = ntpControlOps[packet[1]&0x1F]
+		if request == "" {
+			request = fmt.Sprintf("control opcode %d", packet[1]&0x1F)
+		}
+	case ntpModePrivate:
+		request = ntpPrivateRequests[packet[3]]
+		if request == "" {
+			request = fmt.Sprintf("private request %d", packet[3])
+		}
+	default:
+		request = fmt.Sprintf("mode %d", mode)
+	}
+	fields := map[string]any{"data": string(packet), "ntp_version": int(version), "ntp_mode": int(mode), "ntp_request": request}
+	meta.Emit(Event{
+		Type:    EventData,
+		Message: fmt.Sprintf("NTP %s on port %s/udp from %s (version %d, mode %d)", request, meta.Port, meta.ClientAddr, version, mode),
+		Fields:  fields,
+	})
+	if mode == ntpModeControl || mode == ntpModePrivate {
+		kind := "ntp_mode6"
+		if mode == ntpModePrivate {
+			kind = "ntp_mode7"
+		}
+		if request == "monlist" {
+			kind = "ntp_monlist"
+		}
+		state, _ := meta.HandlerState.(*ntpState)
+		if state == nil {
+			state = &ntpState{reported: make(map[string]bool)}
+			meta.HandlerState = state
+		}
+		if !state.reported[kind] {
+			state.reported[kind] = true
+			meta.Emit(Event{
+				Type: EventDDoSPrep,
+				Message: fmt.Sprintf("NTP amplification probe on port %s/udp from %s: %s; the source address may be spoofed and be the intended target",
+					meta.Port, meta.ClientAddr, request),
+				Fields: map[string]any{"ddos_kind": kind, "ntp_request": request},
+			})
+		}
+	}
+
+	id := meta.Identity
+	switch {
+	case mode == ntpModeClient && len(packet) >= 48:
+		return [][]byte{ntpServerReply(id, packet, version)}
+	case id.IsWindows():
+		return nil
+	case mode == ntpModeControl && len(packet) >= 12:
+		op := packet[1] & 0x1F
+		if op != ntpReadVar && op != ntpReadStat {
+			return nil // ntpd needs authentication for the others and stays silent
+		}
+		return [][]byte{ntpControlReply(id, packet, op)}
+	case mode == ntpModePrivate && len(packet) >= 8:
+		return [][]byte{ntpPrivateReply(meta, packet, version)}
+	}
+	return nil
+}
+
+// ntpReference returns the upstream server of the host and when the clock
+// was last set from it.
+func ntpReference(id *HostIdentity, now time.Time) (net.IP, time.Time) {
+	seed := uint64(id.seed())
+	ref := net.ParseIP(ntpRefServers[seed%uint64(len(ntpRefServers))]).To4()
+	// Polled every 1024 s
+	offset := time.Duration(seed%1024) * time.Second
+	last := now.Add(-offset).Truncate(1024 * time.Second).Add(offset)
+	return ref, last
+}
+
+// ntpServerReply answers a client request (RFC 5905).
+func ntpServerReply(id *HostIdentity, req []byte, version byte) []byte {
+	now := time.Now()
+	ref, refTime := ntpReference(id, now)
+	resp := make([]byte, 48)
+	resp[0] = version<<3 | ntpModeServer
+	resp[1] = 2                                       // stratum
+	resp[2] = max(req[2], 6)                          // poll
+	resp[3] = 0xE8                                    // precision, 2^-24 s
+	binary.BigEndian.PutUint32(resp[4:], 0x0000_0150) // root delay, about 5 ms
+	binary.BigEndian.PutUint32(resp[8:], 0x0000_07A0) // root dispersion, about 30 ms
+	copy(resp[12:], ref)
+	binary.BigEndian.PutUint64(resp[16:], toNTPTime(refTime))
+	copy(resp[24:32], req[40:48]) // originate: the client's transmit timestamp
+	binary.BigEndian.PutUint64(resp[32:], toNTPTime(now))
+	binary.BigEndian.PutUint64(resp[40:], toNTPTime(time.Now()))
+	return resp
+}
+
+// ntpControlReply answers an ntpq readvar or readstat for the system
+// association with the system variables.
+func ntpControlReply(id *HostIdentity, req []byte, op byte) []byte {
+	now := time.Now()
+	ref, refTime := ntpReference(id, now)
+	rng := rand.New(rand.NewSource(now.Unix() / 64))
+	version := id.Software["ntpd"]
+	if version == "" {
+		version = "4.2.8p15"
+	}
+	vars := strings.Join([]string{
+		fmt.Sprintf(`version="ntpd %s@1.3728-o Wed Sep 23 11:46:38 UTC 2020 (1)"`, version),
+		`processor="x86_64"`, fmt.Sprintf(`system="Linux/%s"`, id.Kernel),
+		"leap=00", "stratum=2", "precision=-24",
+		fmt.Sprintf("rootdelay=%.3f", 4+rng.Float64()*2), fmt.Sprintf("rootdisp=%.3f", 28+rng.Float64()*8),
+		"refid=" + ref.String(),
+		fmt.Sprintf("reftime=%016x", toNTPTime(refTime)), fmt.Sprintf("clock=%016x", toNTPTime(now)),
+		fmt.Sprintf("peer=%d", 20000+uint64(id.seed())%40000), "tc=10", "mintc=3",
+		fmt.Sprintf("offset=%.6f", rng.NormFloat64()*0.3), fmt.Sprintf("frequency=%.3f", -12.5+float64(uint64(id.seed())%700)/100),
+		fmt.Sprintf("sys_jitter=%.6f", 0.1+rng.Float64()*0.4), fmt.Sprintf("clk_jitter=%.3f", 0.05+rng.Float64()*0.3),
+		fmt.Sprintf("clk_wander=%.3f", 0.005+rng.Float64()*0.02),
+	}, ", ")
+	data := []byte(vars + "\r\n")
+	if op == ntpReadStat {
+		// The association IDs and statuses of the peers: the upstream server
+		data = []byte{0xC3, 0x4E, 0x96, 0x1A}
+	}
+	resp := make([]byte, 12, 12+len(data)+3)
+	resp[0] = req[0]&0x38 | ntpModeControl
+	resp[1] = 0x80 | op                          // response
+	copy(resp[2:4], req[2:4])                    // sequence
+	binary.BigEndian.PutUint16(resp[4:], 0x0618) // status: leap none, source NTP, 1 event, last event clock sync
+	binary.BigEndian.PutUint16(resp[10:], uint16(len(data)))
+	resp = append(resp, data...)
+	for len(resp)%4 != 0 {
+		resp = append(resp, 0)
+	}
+	return resp
+}
+
+// ntpPrivateReply answers an ntpdc request: monlist gets the clients seen
+// recently, most recent first, other requests an error.
+func ntpPrivateReply(meta *ConnMeta, req []byte, version byte) []byte {
+	resp := []byte{0x80 | version<<3 | ntpModePrivate, 0, ntpImplXNTPD, req[3]}
+	if req[2] != ntpImplXNTPD && req[2] != 2 {
+		return append(resp, 0x10, 0, 0, 0) // INFO_ERR_IMPL
+	}
+	if req[3] != ntpMonGetList && req[3] != ntpMonGetList1 {
+		return append(resp, ntpErrRequest<<4, 0, 0, 0)
+	}
+
+	// A few LAN clients polling regularly, then the requester
+	id := meta.Identity
+	rng := rand.New(rand.NewSource(id.seed()))
+	daddr := net.IPv4(10, 0, 0, byte(2+rng.Intn(250))).To4() // sockets on all addresses don't tell theirs
+	if host, _, err := net.SplitHostPort(meta.LocalAddr); err == nil {
+		if ip := net.ParseIP(host).To4(); ip != nil && !ip.IsUnspecified() {
+			daddr = ip
+		}
+	}
+	type client struct {
+		addr          net.IP
+		port          int
+		count         int
+		avg, last     int
+		mode, version byte
+	}
+	var clients []client
+	for i := 0; i < ntpMonlistLength-1; i++ {
+		poll := 64 << rng.Intn(5)
+		clients = append(clients, client{
+			addr: net.IPv4(10, byte(rng.Intn(4)), byte(rng.Intn(256)), byte(2+rng.Intn(250))).To4(),
+			port: 123, count: 1000 + rng.Intn(90000), avg: poll, last: int(time.Now().Unix()) % poll, mode: ntpModeClient, version: 4,
+		})
+	}
+	ref, _ := ntpReference(id, time.Now())
+	clients = append(clients, client{addr: ref, port: 123, count: 2000 + rng.Intn(3000), avg: 1024, last: int(time.Now().Unix()) % 1024, mode: ntpModeServer, version: 4})
+	if host, portText, err := net.SplitHostPort(meta.ClientAddr); err == nil {
+		if ip := net.ParseIP(host).To4(); ip != nil {
+			var port int
+			fmt.Sscan(portText, &port)
+			clients = append([]client{{addr: ip, port: port, count: 1, mode: ntpModePrivate, version: version}}, clients...)
+		}
+	}
+	clients = clients[:min(len(clients), ntpMonlistLength)]
+
+	resp = binary.BigEndian.AppendUint16(resp, uint16(len(clients)))
+	resp = binary.BigEndian.AppendUint16(resp, ntpMonitorItem)
+	for _, c := range clients {
+		item := make([]byte, ntpMonitorItem)
+		binary.BigEndian.PutUint32(item[0:], uint32(c.avg))
+		binary.BigEndian.PutUint32(item[4:], uint32(c.last))
+		binary.BigEndian.PutUint32(item[12:], uint32(c.count))
+		copy(item[16:], c.addr)
+		copy(item[20:], daddr)
+		binary.BigEndian.PutUint16(item[28:], uint16(c.port))
+		item[30], item[31] = c.mode, c.version
+		resp = append(resp, item...)
+	}
+	return resp
+}
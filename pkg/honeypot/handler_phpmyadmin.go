@@ -0,0 +1,278 @@
+package honeypot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+func init() {
+	RegisterHandler("phpmyadmin", HandlerFunc(servePhpMyAdmin))
+}
+
+// phpMyAdminVersion is the impersonated phpMyAdmin release, unless the host
+// identity lists another one.
+const phpMyAdminVersion = "4.8.1"
+
+// phpMyAdminPrefixes are the paths phpMyAdmin is installed under, as
+// scanners probe them. The root is redirected to the first one.
+var phpMyAdminPrefixes = []string{"/phpmyadmin", "/phpMyAdmin", "/pma", "/mysql", "/dbadmin"}
+
+// phpMyAdminAccounts are the MySQL accounts let in, with an empty password
+// or one of phpMyAdminPasswords, as left by quick LAMP installs.
+var (
+	phpMyAdminAccounts  = []string{"root", "admin", "phpmyadmin"}
+	phpMyAdminPasswords = []string{"", "root", "toor", "password", "admin", "123456", "mysql"}
+)
+
+// phpMyAdminSession is one connection to the phpmyadmin handler.
+type phpMyAdminSession struct {
+	meta    *ConnMeta
+	version string
+	token   string // CSRF token of the forms
+}
+
+// servePhpMyAdmin impersonates a phpMyAdmin installation. Logins through
+// the cookie login form are logged; root and a few other accounts get in
+// with empty or common passwords. SQL run by logged in clients raises a
+// phpmyadmin_sql alert, high when it writes files or runs commands as in
+// webshell drops with SELECT ... INTO OUTFILE. The file inclusion of
+// 4.8.0 and 4.8.1 (CVE-2018-12613) and the setup script injection of
+// 2.11 and 3.1 (CVE-2009-1151) raise phpmyadmin_exploit.
+func servePhpMyAdmin(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	s := &phpMyAdminSession{meta: meta, version: phpMyAdminVersion, token: meta.randomID() + meta.randomID()}
+	if v := meta.Identity.Software["phpmyadmin"]; v != "" {
+		s.version = v
+	}
+	return serveHTTPPersona(ctx, conn, meta, "phpMyAdmin", s.handle)
+}
+
+func (s *phpMyAdminSession) handle(req *http.Request, body []byte) httpReply {
+	urlPath := path.Clean("/" + req.URL.Path)
+	if urlPath == "/" {
+		return httpRedirect(phpMyAdminPrefixes[0]+"/", phpHeaders(s.meta.Identity)...)
+	}
+	var base, page string
+	for _, prefix := range phpMyAdminPrefixes {
+		if rest, ok := strings.CutPrefix(urlPath, prefix); ok && (rest == "" || rest[0] == '/') {
+			base, page = prefix, strings.TrimPrefix(rest, "/")
+		}
+	}
+	if base == "" {
+		return s.notFound(req.URL.Path)
+	}
+	if page == "" && !strings.HasSuffix(req.URL.Path, "/") {
+		return httpReply{Status: http.StatusMovedPermanently, Headers: append(phpHeaders(s.meta.Identity), [2]string{"Location", base + "/"})}
+	}
+	query := req.URL.Query()
+	form := httpForm(body)
+
+	// CVE-2018-12613: index.php includes target when the part before a
+	// "?", double URL-encoded, is whitelisted
+	if target := query.Get("target"); (page == "" || page == "index.php") && target != "" && strings.Contains(target, "..") {
+		s.exploit(fmt.Sprintf("file inclusion of %q", target))
+		return httpPage(http.StatusOK, s.includedFile(target), phpHeaders(s.meta.Identity)...)
+	}
+	if strings.HasPrefix(page, "setup/") || strings.HasPrefix(page, "scripts/setup.php") {
+		if req.Method == http.MethodPost && (form.Has("configuration") || strings.Contains(string(body), "eval")) {
+			s.exploit("setup script injection to " + req.URL.Path)
+		}
+		return s.notFound(req.URL.Path)
+	}
+
+	switch page {
+	case "", "index.php":
+		if req.Method == http.MethodPost && form.Has("pma_username") {
+			return s.login(base, form.Get("pma_username"), form.Get("pma_password"))
+		}
+		if !s.loggedIn(req) {
+			return s.loginPage(base, "")
+		}
+		if sql := form.Get("sql_query"); sql != "" {
+			return s.query(base, sql)
+		}
+		return s.mainPage(base)
+	case "import.php", "sql.php", "db_sql.php", "tbl_sql.php":
+		if !s.loggedIn(req) {
+			return s.loginPage(base, "")
+		}
+		sql := form.Get("sql_query")
+		if sql == "" {
+			sql = query.Get("sql_query")
+		}
+		if sql == "" {
+			return s.mainPage(base)
+		}
+		return s.query(base, sql)
+	case "logout.php":
+		return httpRedirect(base+"/index.php", append(phpHeaders(s.meta.Identity), [2]string{"Set-Cookie", "pmaAuth-1=deleted; expires=Thu, 01-Jan-1970 00:00:01 GMT; Max-Age=0; path=" + base + "/; HttpOnly"})...)
+	case "README", "ChangeLog", "doc/html/index.html", "Documentation.html":
+		return httpReply{Status: http.StatusOK, Headers: append(phpHeaders(s.meta.Identity)[:2], [2]string{"Content-Type", "text/plain"}),
+			Body: []byte("phpMyAdmin - Readme\n===================\n\nVersion " + s.version + "\n\nA web interface for MySQL and MariaDB.\n\nhttps://www.phpmyadmin.net/\n")}
+	}
+	return s.notFound(req.URL.Path)
+}
+
+// loggedIn reports whether req carries the authentication cookie set by a
+// successful login.
+func (s *phpMyAdminSession) loggedIn(req *http.Request) bool {
+	c, err := req.Cookie("pmaAuth-1")
+	return err == nil && c.Value != "" && c.Value != "deleted"
+}
+
+// login logs the credentials posted to the login form, letting default
+// accounts in.
+func (s *phpMyAdminSession) login(base, user, password string) httpReply {
+	s.meta.LogCredential(Credential{Username: user, Password: password})
+	if !slices.Contains(phpMyAdminAccounts, user) || !slices.Contains(phpMyAdminPasswords, password) {
+		return s.loginPage(base, fmt.Sprintf(`mysqli::real_connect(): (HY000/1045): Access denied for user '%s'@'localhost' (using password: %s)`,
+			html.EscapeString(user), map[bool]string{true: "YES", false: "NO"}[password != ""]))
+	}
+	s.meta.Logf("phpMyAdmin login as %q accepted on port %s from %s", user, s.meta.Port, s.meta.ClientAddr)
+	return httpRedirect(base+"/index.php?route=/&server=1&token="+s.token, append(phpHeaders(s.meta.Identity),
+		[2]string{"Set-Cookie", "pmaUser-1=" + url.QueryEscape(user) + "; path=" + base + "/; HttpOnly"},
+		[2]string{"Set-Cookie", "pmaAuth-1=" + s.meta.randomID() + s.meta.randomID() + "; path=" + base + "/; HttpOnly"})...)
+}
+
+// query answers SQL run from the SQL tab or the import page, as if it
+// succeeded without returning rows.
+func (s *phpMyAdminSession) query(base, sql string) httpReply {
+	lower := strings.ToLower(sql)
+	severity := "medium"
+	for _, fragment := range []string{"into outfile", "into dumpfile", "load_file(", "sys_exec(", "sys_eval(", "general_log_file", "<?php", "lib_mysqludf"} {
+		if strings.Contains(lower, fragment) {
+			severity = "high"
+		}
+	}
+	if s.meta.Stage < StageExploit {
+		s.meta.Stage = StageExploit
+	}
+	s.meta.RaiseAlert(severity, "phpmyadmin_sql", fmt.Sprintf("SQL %q on port %s from %s", sql, s.meta.Port, s.meta.ClientAddr))
+	return s.frame(base, `<div class="alert alert-success" role="alert"><img src="themes/dot.gif" title="" alt="" class="icon ic_s_success"> MySQL returned an empty result set (i.e. zero rows). (Query took 0.0004 seconds.)</div>
+<code class="sql"><pre>`+html.EscapeString(sql)+`</pre></code>`)
+}
+
+// exploit raises a high phpmyadmin_exploit alert and marks the session as exploiting.
+func (s *phpMyAdminSession) exploit(what string) {
+	if s.meta.Stage < StageExploit {
+		s.meta.Stage = StageExploit
+	}
+	s.meta.RaiseAlert("high", "phpmyadmin_exploit", fmt.Sprintf("%s on port %s from %s", what, s.meta.Port, s.meta.ClientAddr))
+}
+
+// includedFile returns what the file inclusion of target shows: the decoy
+// file it names, or the PHP warning of a missing one.
+func (s *phpMyAdminSession) includedFile(target string) string {
+	name := target
+	if i := strings.Index(name, "../"); i >= 0 {
+		name = "/" + strings.TrimLeft(name[i:], "./")
+	}
+	if content, err := fs.ReadFile(s.meta.Files, strings.TrimPrefix(path.Clean(name), "/")); err == nil {
+		return html.EscapeString(string(content))
+	}
+	return fmt.Sprintf("<br />\n<b>Warning</b>:  include(%s): failed to open stream: No such file or directory in <b>/usr/share/phpmyadmin/index.php</b> on line <b>61</b><br />\n",
+		html.EscapeString(target))
+}
+
+func (s *phpMyAdminSession) loginPage(base, loginError string) httpReply {
+	if loginError != "" {
+		loginError = `<div class="alert alert-danger" role="alert"><img src="themes/dot.gif" title="" alt="" class="icon ic_s_error"> ` + loginError + `</div>`
+	}
+	body := fmt.Sprintf(`<!DOCTYPE HTML>
+<html lang="en" dir="ltr">
+<head>
+<meta charset="utf-8">
+<meta name="referrer" content="no-referrer">
+<meta name="robots" content="noindex,nofollow">
+<link rel="icon" href="favicon.ico" type="image/x-icon">
+<title>phpMyAdmin</title>
+<link rel="stylesheet" type="text/css" href="phpmyadmin.css.php?nocache=%[2]s&amp;server=1">
+<script data-cfasync="false" type="text/javascript" src="js/messages.php?l=en&amp;v=%[1]s"></script>
+</head>
+<body class="loginform">
+<div class="container">
+<a href="./url.php?url=https%%3A%%2F%%2Fwww.phpmyadmin.net%%2F" target="_blank" rel="noopener noreferrer" class="logo"><img src="./themes/pmahomme/img/logo_right.png" id="imLogo" name="imLogo" alt="phpMyAdmin" border="0"></a>
+<h1>Welcome to <bdo dir="ltr" lang="en">phpMyAdmin</bdo></h1>
+%[3]s
+<form method="post" id="login_form" action="index.php" name="login_form" class="disableAjax login hide js-show">
+<fieldset><legend>Log in</legend>
+<div class="item"><label for="input_username">Username:</label><input type="text" name="pma_username" id="input_username" value="" size="24" class="textfield"></div>
+<div class="item"><label for="input_password">Password:</label><input type="password" name="pma_password" id="input_password" value="" size="24" class="textfield"></div>
+<input type="hidden" name="server" value="1">
+</fieldset>
+<fieldset class="tblFooters"><input value="Go" type="submit" id="input_go"><input type="hidden" name="target" value="index.php"><input type="hidden" name="token" value="%[4]s"></fieldset>
+</form>
+</div>
+</body>
+</html>
+`, s.version, s.meta.randomID(), loginError, s.token)
+	return httpPage(http.StatusOK, body, append(phpHeaders(s.meta.Identity),
+		[2]string{"Cache-Control", "no-store, no-cache, must-revalidate,  pre-check=0, post-check=0, max-age=0"},
+		[2]string{"X-Frame-Options", "DENY"},
+		[2]string{"X-Robots-Tag", "noindex, nofollow"},
+		[2]string{"Set-Cookie", "phpMyAdmin=" + s.meta.randomID() + s.meta.randomID() + "; path=" + base + "/; HttpOnly"},
+		[2]string{"Set-Cookie", "pma_lang=en; Max-Age=2592000; path=" + base + "/; HttpOnly"})...)
+}
+
+func (s *phpMyAdminSession) mainPage(base string) httpReply {
+	id := s.meta.Identity
+	server := "MySQL"
+	if v := id.Software["mysql"]; v != "" {
+		server += " " + v
+	}
+	return s.frame(base, fmt.Sprintf(`<div class="group"><h2>Database server</h2><ul>
+<li id="li_server_info">Server: Localhost via UNIX socket</li>
+<li id="li_server_type">Server type: MySQL</li>
+<li id="li_server_version">Server version: %s</li>
+<li id="li_user_info">User: root@localhost</li>
+<li id="li_select_mysql_charset">Server charset: UTF-8 Unicode (utf8mb4)</li>
+</ul></div>
+<div class="group"><h2>Web server</h2><ul><li id="li_web_server_software">%s</li><li id="li_used_php_extension">PHP extension: mysqli</li></ul></div>
+<div class="group"><h2>phpMyAdmin</h2><ul><li id="li_pma_version">Version information: %s</li></ul></div>
+<form method="post" action="import.php" class="ajax lock-page" id="sqlqueryform" name="sqlform"><input type="hidden" name="token" value="%s">
+<textarea name="sql_query" id="sqlquery" cols="40" rows="15"></textarea><input class="btn btn-primary" type="submit" id="button_submit_query" name="SQL" value="Go"></form>`,
+		html.EscapeString(server), html.EscapeString(id.HTTPServerHeader()), s.version, s.token))
+}
+
+// frame wraps the content of a page shown to logged in users in the
+// navigation panel listing the databases.
+func (s *phpMyAdminSession) frame(base, content string) httpReply {
+	domain, _, _ := strings.Cut(s.meta.Identity.Domain, ".")
+	var databases strings.Builder
+	for _, db := range []string{domain + "_app", domain + "_crm", "information_schema", "mysql", "performance_schema", "sys", "wordpress"} {
+		fmt.Fprintf(&databases, `<li class="database"><a href="index.php?route=/database/structure&amp;db=%s">%s</a></li>`, url.QueryEscape(db), html.EscapeString(db))
+	}
+	body := `<!DOCTYPE HTML>
+<html lang="en" dir="ltr">
+<head><meta charset="utf-8"><title>localhost / ` + html.EscapeString(s.meta.Identity.Hostname) + ` | phpMyAdmin ` + s.version + `</title>
+<link rel="stylesheet" type="text/css" href="phpmyadmin.css.php?nocache=` + s.meta.randomID() + `&amp;server=1"></head>
+<body>
+<div id="pma_navigation"><div id="pma_navigation_tree"><ul>` + databases.String() + `</ul></div></div>
+<div id="page_content">` + content + `</div>
+</body>
+</html>
+`
+	return httpPage(http.StatusOK, body, append(phpHeaders(s.meta.Identity),
+		[2]string{"Cache-Control", "no-store, no-cache, must-revalidate,  pre-check=0, post-check=0, max-age=0"},
+		[2]string{"X-Frame-Options", "DENY"})...)
+}
+
+func (s *phpMyAdminSession) notFound(urlPath string) httpReply {
+	return httpPage(http.StatusNotFound, `<!DOCTYPE HTML PUBLIC "-//IETF//DTD HTML 2.0//EN">
+<html><head>
+<title>404 Not Found</title>
+</head><body>
+<h1>Not Found</h1>
+<p>The requested URL `+html.EscapeString(urlPath)+` was not found on this server.</p>
+<hr>
+<address>`+html.EscapeString(s.meta.Identity.HTTPServerHeader())+` Server at `+html.EscapeString(s.meta.Identity.FQDN())+` Port `+s.meta.Port+`</address>
+</body></html>
+`, phpHeaders(s.meta.Identity)[:2]...)
+}
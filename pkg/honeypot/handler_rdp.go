@@ -0,0 +1,313 @@
+package honeypot
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+func init() {
+	RegisterHandler("rdp", HandlerFunc(serveRDP))
+}
+
+// RDP security protocols of the negotiation (MS-RDPBCGR 2.2.1.1.1).
+const (
+	rdpProtocolSSL       = 0x01
+	rdpProtocolHybrid    = 0x02 // CredSSP, i.e. Network Level Authentication
+	rdpProtocolRDSTLS    = 0x04
+	rdpProtocolHybridEx  = 0x08
+	rdpProtocolRDSAAD    = 0x10
+	rdpSSLRequired       = 0x01 // RDP_NEG_FAILURE code
+	rdpMaxPDU            = 16 << 10
+	rdpCredSSPVersion    = 6
+	rdpStatusLogonFailed = -0x3FFFFF93 // STATUS_LOGON_FAILURE, 0xC000006D as a signed 32-bit integer
+)
+
+var rdpProtocolNames = []struct {
+	flag uint32
+	name string
+}{
+	{rdpProtocolSSL, "ssl"}, {rdpProtocolHybrid, "hybrid"}, {rdpProtocolRDSTLS, "rdstls"},
+	{rdpProtocolHybridEx, "hybrid_ex"}, {rdpProtocolRDSAAD, "rdsaad"},
+}
+
+// rdpProtocols names the protocols set in a requestedProtocols field;
+// "rdp" is standard RDP security, requested by leaving every flag unset.
+func rdpProtocols(flags uint32) []string {
+	if flags == 0 {
+		return []string{"rdp"}
+	}
+	var names []string
+	for _, p := range rdpProtocolNames {
+		if flags&p.flag != 0 {
+			names = append(names, p.name)
+		}
+	}
+	return names
+}
+
+// tsRequest is the CredSSP message (MS-CSSP 2.2.1).
+type tsRequest struct {
+	Version     int           `asn1:"explicit,tag:0"`
+	NegoTokens  []tsNegoToken `asn1:"explicit,optional,tag:1"`
+	AuthInfo    []byte        `asn1:"explicit,optional,tag:2"`
+	PubKeyAuth  []byte        `asn1:"explicit,optional,tag:3"`
+	ErrorCode   int           `asn1:"explicit,optional,tag:4"`
+	ClientNonce []byte        `asn1:"explicit,optional,tag:5"`
+}
+
+type tsNegoToken struct {
+	Token []byte `asn1:"explicit,tag:0"`
+}
+
+// serveRDP impersonates a Windows Remote Desktop server up to the user's
+// authentication: it answers the X.224 connection request, negotiating
+// Network Level Authentication (CredSSP) when the client offers it and TLS
+// otherwise, presents a self-signed certificate of the host identity and
+// runs NTLM far enough to learn the account the client logs on with. The
+// client's cookie, usually "mstshash=<username>", and requested protocols
+// are logged, and logons are rejected.
+func serveRDP(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	conn.SetReadDeadline(meta.NextReadDeadline(false))
+	tpdu, raw, err := readTPKT(conn)
+	if err != nil {
+		return binaryReadError(ctx, meta, raw, err, false)
+	}
+	// X.224 Connection Request: length indicator, CR code, references, class
+	if len(tpdu) < 7 || tpdu[1]&0xF0 != 0xE0 {
+		meta.LogData(string(raw))
+		return nil
+	}
+	data := tpdu[7:]
+	cookie := ""
+	if line, rest, ok := bytes.Cut(data, []byte("\r\n")); ok && bytes.HasPrefix(line, []byte("Cookie: ")) {
+		cookie, data = string(line[len("Cookie: "):]), rest
+	}
+	negotiated := len(data) >= 8 && data[0] == 0x01
+	var requested uint32
+	if negotiated {
+		requested = binary.LittleEndian.Uint32(data[4:8])
+	}
+	protocols := []string{}
+	if negotiated {
+		protocols = rdpProtocols(requested)
+	}
+	meta.Emit(Event{
+		Type: EventData,
+		Message: fmt.Sprintf("RDP connection request on port %s from %s: cookie %q, requested protocols %s",
+			meta.Port, meta.ClientAddr, cookie, strings.Join(protocols, ",")),
+		Fields: map[string]any{"data": string(raw), "rdp_cookie": cookie, "rdp_requested_protocols": protocols},
+	})
+
+	// Connection Confirm with the negotiation response, or the failure
+	// servers requiring TLS send to clients offering only standard security
+	var selected uint32
+	confirm := []byte{0x00, 0xD0, 0x00, 0x00, 0x12, 0x34, 0x00}
+	switch {
+	case !negotiated:
+	case requested&rdpProtocolHybrid != 0:
+		selected = rdpProtocolHybrid
+	case requested&rdpProtocolSSL != 0:
+		selected = rdpProtocolSSL
+	}
+	switch {
+	case negotiated && selected == 0:
+		confirm = append(confirm, 0x03, 0x00, 0x08, 0x00)
+		confirm = binary.LittleEndian.AppendUint32(confirm, rdpSSLRequired)
+	case negotiated:
+		// Extended client data, graphics pipeline, restricted admin and redirected authentication supported
+		confirm = append(confirm, 0x02, 0x1F, 0x08, 0x00)
+		confirm = binary.LittleEndian.AppendUint32(confirm, selected)
+	}
+	confirm[0] = byte(len(confirm) - 1)
+	if err := writeTPKT(conn, confirm); err != nil {
+		return err
+	}
+	if negotiated && selected == 0 {
+		return nil
+	}
+	if selected == 0 {
+		// Standard RDP security: log the MCS Connect Initial and hang up
+		conn.SetReadDeadline(meta.NextReadDeadline(true))
+		_, raw, err := readTPKT(conn)
+		return binaryReadError(ctx, meta, raw, err, true)
+	}
+
+	cert, err := meta.Identity.TLSCertificate()
+	if err != nil {
+		return fmt.Errorf("creating the RDP certificate: %w", err)
+	}
+	tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{*cert}, MinVersion: tls.VersionTLS10})
+	tlsConn.SetDeadline(meta.NextReadDeadline(true))
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		meta.Logf("RDP TLS handshake on port %s from %s failed: %s", meta.Port, meta.ClientAddr, err)
+		return nil
+	}
+	if selected == rdpProtocolSSL {
+		tlsConn.SetReadDeadline(meta.NextReadDeadline(true))
+		_, raw, err := readTPKT(tlsConn)
+		return binaryReadError(ctx, meta, raw, err, true)
+	}
+	return serveCredSSP(ctx, tlsConn, meta)
+}
+
+// serveCredSSP runs the NTLM exchange of Network Level Authentication and
+// logs the account and NetNTLM response of the client, then fails the logon.
+func serveCredSSP(ctx context.Context, conn *tls.Conn, meta *ConnMeta) error {
+	var challenge [8]byte
+	meta.Rand().Read(challenge[:])
+	for {
+		conn.SetDeadline(meta.NextReadDeadline(true))
+		raw, err := readDER(conn)
+		if err != nil {
+			return binaryReadError(ctx, meta, raw, err, true)
+		}
+		var req tsRequest
+		if _, err := asn1.Unmarshal(raw, &req); err != nil || len(req.NegoTokens) == 0 {
+			meta.LogData(string(raw))
+			return nil
+		}
+		version := min(req.Version, rdpCredSSPVersion)
+		token := req.NegoTokens[0].Token
+		switch ntlmMessageType(token) {
+		case ntlmNegotiate:
+			resp := tsRequest{Version: version, NegoTokens: []tsNegoToken{{Token: ntlmChallengeMessage(meta.Identity, challenge)}}}
+			if err := writeDER(conn, resp); err != nil {
+				return err
+			}
+		case ntlmAuthenticate:
+			auth, _ := parseNTLMAuthenticate(token)
+			hash := auth.Hash(challenge)
+			meta.Emit(Event{
+				Type: EventData,
+				Message: fmt.Sprintf("RDP NLA logon on port %s from %s: account %q from workstation %q",
+					meta.Port, meta.ClientAddr, auth.Username(), auth.Workstation),
+				Fields: map[string]any{"rdp_username": auth.Username(), "rdp_workstation": auth.Workstation, "ntlm_hash": hash},
+			})
+			if auth.User != "" {
+				meta.LogCredential(Credential{Username: auth.Username()})
+			}
+			if version >= 3 {
+				writeDER(conn, tsRequest{Version: version, ErrorCode: rdpStatusLogonFailed})
+			}
+			return nil
+		default:
+			meta.LogData(string(raw))
+			return nil
+		}
+	}
+}
+
+// binaryReadError logs what a client of a binary protocol sent before a read
+// failed and reports the failure like the banner handler, nil for hang-ups,
+// timeouts and clients speaking another protocol.
+func binaryReadError(ctx context.Context, meta *ConnMeta, raw []byte, err error, received bool) error {
+	if len(raw) > 0 {
+		meta.LogData(string(raw))
+	}
+	switch {
+	case err == nil, errors.Is(err, errNotRDP), errors.Is(err, errNotSMB), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return nil
+	case errors.Is(err, os.ErrDeadlineExceeded) && meta.SessionExpired():
+		meta.Logf("Closing session on port %s from %s: maximum duration of %s reached", meta.Port, meta.ClientAddr, meta.Limits.SessionTimeout)
+		return nil
+	case errors.Is(err, os.ErrDeadlineExceeded) && !received && len(raw) == 0:
+		meta.Logf("Closing session on port %s from %s: no data within %s", meta.Port, meta.ClientAddr, meta.Limits.ConnectTimeout)
+		return nil
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		meta.Logf("Closing session on port %s from %s: idle for %s", meta.Port, meta.ClientAddr, meta.Limits.IdleTimeout)
+		return nil
+	case ctx.Err() != nil:
+		return nil // shutting down
+	}
+	return fmt.Errorf("reading from connection: %w", err)
+}
+
+// errNotRDP reports a client that does not speak RDP, or CredSSP.
+var errNotRDP = errors.New("not a TPKT packet")
+
+// readTPKT reads a TPKT packet (RFC 1006) and returns its payload and
+// everything read, including what was read of a packet that is not TPKT.
+func readTPKT(r io.Reader) (payload, raw []byte, err error) {
+	header := make([]byte, 4)
+	n, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, header[:n], err
+	}
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if header[0] != 0x03 || length < 4 || length > rdpMaxPDU {
+		rest := make([]byte, 1024)
+		m, _ := r.Read(rest)
+		return nil, append(header, rest[:m]...), nil
+	}
+	packet := make([]byte, length)
+	copy(packet, header)
+	n, err = io.ReadFull(r, packet[4:])
+	if err != nil {
+		return nil, packet[:4+n], err
+	}
+	return packet[4:], packet, nil
+}
+
+func writeTPKT(conn net.Conn, payload []byte) error {
+	packet := []byte{0x03, 0x00, 0, 0}
+	binary.BigEndian.PutUint16(packet[2:], uint16(len(payload)+4))
+	if _, err := conn.Write(append(packet, payload...)); err != nil {
+		return fmt.Errorf("writing to connection: %w", err)
+	}
+	return nil
+}
+
+// readDER reads one DER-encoded SEQUENCE.
+func readDER(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if n, err := io.ReadFull(r, header); err != nil {
+		return header[:n], err
+	}
+	if header[0] != 0x30 {
+		rest := make([]byte, 1024)
+		m, _ := r.Read(rest)
+		return append(header, rest[:m]...), errNotRDP
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		size := make([]byte, length&0x7F)
+		if len(size) > 3 {
+			return header, errNotRDP
+		}
+		if n, err := io.ReadFull(r, size); err != nil {
+			return append(header, size[:n]...), err
+		}
+		header = append(header, size...)
+		length = 0
+		for _, b := range size {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > rdpMaxPDU {
+		return header, errNotRDP
+	}
+	msg := make([]byte, len(header)+length)
+	copy(msg, header)
+	n, err := io.ReadFull(r, msg[len(header):])
+	return msg[:len(header)+n], err
+}
+
+func writeDER(conn net.Conn, req tsRequest) error {
+	der, err := asn1.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(der); err != nil {
+		return fmt.Errorf("writing to connection: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+package honeypot
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	// SIP runs over both transports on the same port
+	RegisterHandler("sip", HandlerFunc(serveSIP))
+	RegisterPacketHandler("sip", PacketHandlerFunc(serveSIPPacket))
+}
+
+// What the sip handler impersonates: a FreePBX box running Asterisk with
+// PJSIP, the most common PBX exposed to the internet.
+const (
+	sipServer = "FPBX-16.0.40.7(18.20.2)"
+	sipRealm  = "asterisk"
+	sipAllow  = "OPTIONS, REGISTER, SUBSCRIBE, NOTIFY, PUBLISH, INVITE, ACK, BYE, CANCEL, UPDATE, PRACK, MESSAGE, REFER"
+)
+
+// sipCompactHeaders are the one-letter forms of header names (RFC 3261 7.3.3).
+var sipCompactHeaders = map[string]string{
+	"v": "via", "f": "from", "t": "to", "i": "call-id", "m": "contact",
+	"l": "content-length", "c": "content-type", "k": "supported", "s": "subject",
+}
+
+// sipHeader is a header of a SIP message, its name lowercased and expanded
+// from the compact form.
+type sipHeader struct {
+	name, value string
+}
+
+// sipRequest is a parsed SIP request.
+type sipRequest struct {
+	method, uri string
+	headers     []sipHeader
+}
+
+// get returns the first value of a header.
+func (r *sipRequest) get(name string) string {
+	for _, h := range r.headers {
+		if h.name == name {
+			return h.value
+		}
+	}
+	return ""
+}
+
+// parseSIPRequest parses the request line and headers of a SIP request.
+// The body is not needed to answer.
+func parseSIPRequest(msg []byte) (*sipRequest, error) {
+	head, _, _ := bytes.Cut(msg, []byte("\r\n\r\n"))
+	lines := strings.Split(strings.ReplaceAll(string(head), "\r\n", "\n"), "\n")
+	parts := strings.Fields(lines[0])
+	if len(parts) != 3 || parts[2] != "SIP/2.0" {
+		return nil, errors.New("not a SIP request")
+	}
+	req := &sipRequest{method: strings.ToUpper(parts[0]), uri: parts[1]}
+	for _, line := range lines[1:] {
+		if line == "" {
+			break
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(req.headers) > 0 {
+			req.headers[len(req.headers)-1].value += " " + strings.TrimSpace(line) // folded line
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if long, ok := sipCompactHeaders[name]; ok {
+			name = long
+		}
+		req.headers = append(req.headers, sipHeader{name: name, value: strings.TrimSpace(value)})
+	}
+	return req, nil
+}
+
+// splitSIPMessage returns the first complete message of a TCP stream and
+// what follows it. ok is false while the message is incomplete.
+func splitSIPMessage(stream []byte) (msg, rest []byte, ok bool) {
+	end := bytes.Index(stream, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil, stream, false
+	}
+	end += 4
+	length := 0
+	for _, line := range strings.Split(string(stream[:end]), "\r\n") {
+		name, value, _ := strings.Cut(line, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "content-length" || name == "l" {
+			length, _ = strconv.Atoi(strings.TrimSpace(value))
+		}
+	}
+	length = max(length, 0)
+	if len(stream)-end < length {
+		return nil, stream, false
+	}
+	return stream[:end+length], stream[end+length:], true
+}
+
+// looksLikeSIP reports whether the start of a stream can be a SIP request,
+// checking the request line once it is complete.
+func looksLikeSIP(stream []byte) bool {
+	line, _, complete := bytes.Cut(stream, []byte("\n"))
+	if !complete {
+		return true
+	}
+	return bytes.HasSuffix(bytes.TrimRight(line, "\r"), []byte(" SIP/2.0"))
+}
+
+// serveSIP speaks SIP over TCP: requests are answered one by one like over
+// UDP, see answerSIP.
+func serveSIP(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	buffer := make([]byte, meta.Limits.ReadBuffer)
+	var pending []byte
+	total := 0
+	for {
+		conn.SetReadDeadline(meta.NextReadDeadline(total > 0))
+		n, err := conn.Read(buffer[:min(len(buffer), meta.Limits.MaxBytes-total)])
+		total += n
+		pending = append(pending, buffer[:n]...)
+		for len(pending) > 0 {
+			// CRLF keep-alives (RFC 5626) between messages
+			if trimmed := bytes.TrimLeft(pending, "\r\n"); len(trimmed) < len(pending) {
+				pending = trimmed
+				continue
+			}
+			if !looksLikeSIP(pending) {
+				meta.LogData(string(pending)) // not SIP, e.g. an HTTP probe
+				return nil
+			}
+			msg, rest, ok := splitSIPMessage(pending)
+			if !ok {
+				break
+			}
+			pending = rest
+			for _, answer := range answerSIP(meta, msg, "tcp") {
+				if _, err := conn.Write(answer); err != nil {
+					return fmt.Errorf("writing to connection: %w", err)
+				}
+			}
+		}
+		switch {
+		case errors.Is(err, io.EOF):
+			if len(pending) > 0 {
+				meta.LogData(string(pending))
+			}
+			return nil
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			if len(pending) > 0 {
+				meta.LogData(string(pending))
+			}
+			switch {
+			case meta.SessionExpired():
+				meta.Logf("Closing session on port %s from %s: maximum duration of %s reached", meta.Port, meta.ClientAddr, meta.Limits.SessionTimeout)
+			case total == 0:
+				meta.Logf("Closing session on port %s from %s: no data within %s", meta.Port, meta.ClientAddr, meta.Limits.ConnectTimeout)
+			default:
+				meta.Logf("Closing session on port %s from %s: idle for %s", meta.Port, meta.ClientAddr, meta.Limits.IdleTimeout)
+			}
+			return nil
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil // shutting down
+			}
+			return fmt.Errorf("reading from connection: %w", err)
+		case total >= meta.Limits.MaxBytes:
+			if len(pending) > 0 {
+				meta.LogData(string(pending))
+			}
+			meta.Logf("Closing session on port %s from %s: %d bytes received", meta.Port, meta.ClientAddr, total)
+			return nil
+		}
+	}
+}
+
+// serveSIPPacket answers a SIP request received over UDP.
+func serveSIPPacket(ctx context.Context, packet []byte, meta *ConnMeta) [][]byte {
+	if len(bytes.TrimLeft(packet, "\r\n")) == 0 {
+		return nil // keep-alive
+	}
+	return answerSIP(meta, packet, "udp")
+}
+
+// answerSIP logs a SIP request and returns the responses of an Asterisk box
+// that wants authentication for everything but OPTIONS: REGISTER, INVITE and
+// the other dialog methods get a 401 digest challenge, also when they carry
+// credentials, since no password is right. The digest responses sent are
+// logged for offline cracking.
+func answerSIP(meta *ConnMeta, msg []byte, transport string) [][]byte {
+	req, err := parseSIPRequest(msg)
+	if err != nil {
+		meta.LogData(string(msg))
+		return nil
+	}
+	from, to, userAgent := req.get("from"), req.get("to"), req.get("user-agent")
+	fields := map[string]any{
+		"data": string(msg), "sip_method": req.method, "sip_uri": req.uri, "sip_transport": transport,
+		"sip_from": from, "sip_to": to, "sip_user_agent": userAgent, "sip_call_id": req.get("call-id"),
+	}
+	auth := req.get("authorization")
+	if auth == "" {
+		auth = req.get("proxy-authorization")
+	}
+	digest := parseDigest(auth)
+	for _, param := range []string{"username", "realm", "nonce", "uri", "response", "algorithm"} {
+		if v, ok := digest[param]; ok {
+			fields["sip_auth_"+param] = v
+		}
+	}
+	meta.Emit(Event{
+		Type:    EventData,
+		Message: fmt.Sprintf("SIP %s %s on port %s/%s from %s: From %s, To %s, User-Agent %q", req.method, req.uri, meta.Port, transport, meta.ClientAddr, from, to, userAgent),
+		Fields:  fields,
+	})
+	meta.Observe(string(msg))
+	if username, ok := digest["username"]; ok {
+		meta.LogCredential(Credential{Username: username})
+	}
+
+	switch req.method {
+	case "ACK":
+		return nil
+	case "OPTIONS":
+		return [][]byte{sipResponse(meta, req, "200 OK",
+			"Allow: "+sipAllow,
+			"Accept: application/sdp, application/simple-message-summary, application/dialog-info+xml, application/xpidf+xml, application/cpim-pidf+xml, application/pidf+xml, message/sipfrag;version=2.0",
+			"Supported: 100rel, timer, replaces, norefersub",
+			"Accept-Encoding: text/plain",
+			"Accept-Language: en",
+		)}
+	case "BYE", "CANCEL":
+		return [][]byte{sipResponse(meta, req, "481 Call/Transaction Does Not Exist")}
+	case "REGISTER", "SUBSCRIBE", "NOTIFY", "PUBLISH", "MESSAGE", "REFER", "UPDATE", "PRACK", "INFO":
+		return [][]byte{sipChallenge(meta, req)}
+	case "INVITE":
+		return [][]byte{sipResponse(meta, req, "100 Trying"), sipChallenge(meta, req)}
+	default:
+		return [][]byte{sipResponse(meta, req, "501 Not Implemented", "Allow: "+sipAllow)}
+	}
+}
+
+// sipChallenge returns a 401 response with a fresh digest challenge.
+func sipChallenge(meta *ConnMeta, req *sipRequest) []byte {
+	var random [24]byte
+	meta.Rand().Read(random[:])
+	nonce := fmt.Sprintf("%d/%s", time.Now().Unix(), hex.EncodeToString(random[:16]))
+	challenge := fmt.Sprintf(`WWW-Authenticate: Digest realm="%s",nonce="%s",opaque="%s",algorithm=md5,qop="auth"`,
+		sipRealm, nonce, hex.EncodeToString(random[16:]))
+	return sipResponse(meta, req, "401 Unauthorized", challenge)
+}
+
+// sipResponse builds a response to req with the given status and extra
+// headers, echoing the headers that identify the transaction.
+func sipResponse(meta *ConnMeta, req *sipRequest, status string, extra ...string) []byte {
+	var b strings.Builder
+	b.WriteString("SIP/2.0 " + status + "\r\n")
+	first := true
+	for _, h := range req.headers {
+		if h.name != "via" {
+			continue
+		}
+		via := h.value
+		if first {
+			via = sipReceived(via, meta.ClientAddr)
+			first = false
+		}
+		b.WriteString("Via: " + via + "\r\n")
+	}
+	b.WriteString("From: " + req.get("from") + "\r\n")
+	to := req.get("to")
+	if !strings.HasPrefix(status, "100 ") && !strings.Contains(strings.ToLower(to), ";tag=") {
+		// Stable per call, so retransmissions get the same tag
+		h := fnv.New32a()
+		h.Write([]byte(req.get("call-id")))
+		to += fmt.Sprintf(";tag=%08x", h.Sum32())
+	}
+	b.WriteString("To: " + to + "\r\n")
+	b.WriteString("Call-ID: " + req.get("call-id") + "\r\n")
+	b.WriteString("CSeq: " + req.get("cseq") + "\r\n")
+	for _, header := range extra {
+		b.WriteString(header + "\r\n")
+	}
+	b.WriteString("Server: " + sipServer + "\r\n")
+	b.WriteString("Content-Length: 0\r\n\r\n")
+	return []byte(b.String())
+}
+
+// sipReceived fills in the received and rport parameters of the topmost Via
+// with the address the request came from (RFC 3581).
+func sipReceived(via, clientAddr string) string {
+	host, port, err := net.SplitHostPort(clientAddr)
+	if err != nil {
+		return via
+	}
+	params := strings.Split(via, ";")
+	hasReceived := false
+	for i, param := range params {
+		name, _, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch strings.ToLower(name) {
+		case "rport":
+			params[i] = "rport=" + port
+		case "received":
+			hasReceived = true
+		}
+	}
+	if !hasReceived {
+		params = append(params, "received="+host)
+	}
+	return strings.Join(params, ";")
+}
+
+// parseDigest returns the parameters of a Digest Authorization header value,
+// with names lowercased and quotes removed. It returns nil for other schemes.
+func parseDigest(value string) map[string]string {
+	scheme, rest, _ := strings.Cut(value, " ")
+	if !strings.EqualFold(scheme, "Digest") {
+		return nil
+	}
+	params := make(map[string]string)
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		name, after, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		after = strings.TrimSpace(after)
+		var v string
+		if strings.HasPrefix(after, `"`) {
+			end := strings.Index(after[1:], `"`)
+			if end < 0 {
+				v, after = after[1:], ""
+			} else {
+				v, after = after[1:end+1], after[end+2:]
+			}
+		} else {
+			v, after, _ = strings.Cut(after, ",")
+			after = "," + after
+		}
+		params[name] = strings.TrimSpace(v)
+		_, rest, _ = strings.Cut(after, ",")
+		rest = strings.TrimSpace(rest)
+	}
+	return params
+}
@@ -0,0 +1,727 @@
+package honeypot
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterHandler("smb", HandlerFunc(serveSMB))
+}
+
+// NT status codes of the responses.
+const (
+	smbStatusSuccess           = 0x00000000
+	smbStatusNotImplemented    = 0xC0000002
+	smbStatusMoreProcessing    = 0xC0000016
+	smbStatusAccessDenied      = 0xC0000022
+	smbStatusLogonFailure      = 0xC000006D
+	smbStatusNotSupported      = 0xC00000BB
+	smbStatusInsuffResources   = 0xC0000205 // STATUS_INSUFF_SERVER_RESOURCES, what unpatched hosts answer the MS17-010 check with
+	smbMaxMessage              = 128 << 10
+	smb1Capabilities           = 0x0001F3FC // Unicode, large files, NT SMBs, RPC, NT status, oplocks, large reads and writes, ...
+	smb1CapExtendedSecurity    = 0x80000000
+	smb1Flags2ExtendedSecurity = 0x0800
+	smb1Flags2Unicode          = 0x8000
+	smb1UID                    = 0x0800
+	smb1TID                    = 0x0801
+	smb2MaxDialect             = 0x0302 // 3.1.1 needs negotiate contexts and preauthentication integrity
+	smb2Wildcard               = 0x02FF // asks a client that offered "SMB 2.???" over SMB1 to negotiate again with SMB2
+	smb2IPCTreeID              = 1
+)
+
+var (
+	smb1Magic = []byte("\xFFSMB")
+	smb2Magic = []byte("\xFESMB")
+)
+
+// SMB1 commands (MS-CIFS 2.2.2.1).
+const (
+	smb1Transaction          = 0x25
+	smb1TransactionSecondary = 0x26
+	smb1Echo                 = 0x2B
+	smb1Transaction2         = 0x32
+	smb1Transaction2Second   = 0x33
+	smb1TreeDisconnect       = 0x71
+	smb1Negotiate            = 0x72
+	smb1SessionSetup         = 0x73
+	smb1Logoff               = 0x74
+	smb1TreeConnect          = 0x75
+	smb1NTTransact           = 0xA0
+	smb1NTTransactSecondary  = 0xA1
+)
+
+var smb1CommandNames = map[byte]string{
+	smb1Transaction: "TRANSACTION", smb1TransactionSecondary: "TRANSACTION_SECONDARY", smb1Echo: "ECHO",
+	smb1Transaction2: "TRANSACTION2", smb1Transaction2Second: "TRANSACTION2_SECONDARY", smb1TreeDisconnect: "TREE_DISCONNECT",
+	smb1Negotiate: "NEGOTIATE", smb1SessionSetup: "SESSION_SETUP_ANDX", smb1Logoff: "LOGOFF_ANDX", smb1TreeConnect: "TREE_CONNECT_ANDX",
+	smb1NTTransact: "NT_TRANSACT", smb1NTTransactSecondary: "NT_TRANSACT_SECONDARY",
+	0x04: "CLOSE", 0x24: "LOCKING_ANDX", 0x2D: "OPEN_ANDX", 0x2E: "READ_ANDX", 0x2F: "WRITE_ANDX", 0xA2: "NT_CREATE_ANDX",
+}
+
+// SMB2 commands (MS-SMB2 2.2.1).
+const (
+	smb2Negotiate      = 0x00
+	smb2SessionSetup   = 0x01
+	smb2Logoff         = 0x02
+	smb2TreeConnect    = 0x03
+	smb2TreeDisconnect = 0x04
+	smb2Create         = 0x05
+	smb2Echo           = 0x0D
+)
+
+var smb2CommandNames = []string{
+	"NEGOTIATE", "SESSION_SETUP", "LOGOFF", "TREE_CONNECT", "TREE_DISCONNECT", "CREATE", "CLOSE", "FLUSH", "READ", "WRITE",
+	"LOCK", "IOCTL", "CANCEL", "ECHO", "QUERY_DIRECTORY", "CHANGE_NOTIFY", "QUERY_INFO", "SET_INFO", "OPLOCK_BREAK",
+}
+
+var smb2DialectNames = map[uint16]string{
+	0x0202: "2.0.2", 0x0210: "2.1", 0x0222: "2.2.2", 0x0224: "2.2.4", 0x0300: "3.0", 0x0302: "3.0.2", 0x0310: "3.1", 0x0311: "3.1.1", smb2Wildcard: "2.???",
+}
+
+// errNotSMB reports a client that does not speak SMB.
+var errNotSMB = errors.New("not an SMB message")
+
+// smbConn is the state of an SMB connection.
+type smbConn struct {
+	conn      net.Conn
+	meta      *ConnMeta
+	challenge [8]byte
+	sessionID uint64
+	alerted   bool // an exploitation attempt was already reported
+}
+
+// serveSMB impersonates the file server of the host identity up to the
+// shares: it negotiates SMB1 or SMB2 like Windows or Samba, runs NTLM to
+// learn the account of every logon, which all succeed as guest sessions, and
+// logs the shares clients connect to, granting only IPC$. The dialects
+// offered, NTLM negotiate messages and the MS17-010 (EternalBlue) checks
+// and exploits that still dominate port 445 are logged.
+func serveSMB(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	s := &smbConn{conn: conn, meta: meta}
+	meta.Rand().Read(s.challenge[:])
+	received := false
+	for {
+		conn.SetReadDeadline(meta.NextReadDeadline(received))
+		kind, msg, raw, err := readNetBIOS(conn)
+		if err != nil {
+			return binaryReadError(ctx, meta, raw, err, received)
+		}
+		received = true
+		switch {
+		case kind == 0x81:
+			// Session request of NetBIOS over TCP, port 139: accept any name
+			err = s.write([]byte{0x82, 0, 0, 0})
+		case kind == 0x85:
+			// Keep-alive
+		case kind != 0x00:
+			err = errNotSMB
+		case bytes.HasPrefix(msg, smb1Magic):
+			err = s.serveSMB1(msg)
+		case bytes.HasPrefix(msg, smb2Magic):
+			err = s.serveSMB2(msg)
+		default:
+			err = errNotSMB
+		}
+		if errors.Is(err, errNotSMB) {
+			meta.LogData(string(raw))
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readNetBIOS reads a NetBIOS session service message (RFC 1002 4.3) and
+// returns its type and payload, and everything read.
+func readNetBIOS(r io.Reader) (kind byte, payload, raw []byte, err error) {
+	header := make([]byte, 4)
+	n, err := io.ReadFull(r, header)
+	if err != nil {
+		return 0, nil, header[:n], err
+	}
+	length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+	if length > smbMaxMessage {
+		rest := make([]byte, 1024)
+		m, _ := r.Read(rest)
+		return 0, nil, append(header, rest[:m]...), errNotSMB
+	}
+	msg := make([]byte, 4+length)
+	copy(msg, header)
+	n, err = io.ReadFull(r, msg[4:])
+	if err != nil {
+		return 0, nil, msg[:4+n], err
+	}
+	return header[0], msg[4:], msg, nil
+}
+
+func (s *smbConn) write(msg []byte) error {
+	if _, err := s.conn.Write(msg); err != nil {
+		return fmt.Errorf("writing to connection: %w", err)
+	}
+	return nil
+}
+
+// writeMessage sends an SMB message in a NetBIOS session message.
+func (s *smbConn) writeMessage(msg []byte) error {
+	header := []byte{0, byte(len(msg) >> 16), byte(len(msg) >> 8), byte(len(msg))}
+	return s.write(append(header, msg...))
+}
+
+// serveSMB1 answers an SMB1 request.
+func (s *smbConn) serveSMB1(msg []byte) error {
+	if len(msg) < 35 || 35+2*int(msg[32]) > len(msg) {
+		return errNotSMB
+	}
+	command := msg[4]
+	words := msg[33 : 33+2*int(msg[32])]
+	dataOffset := 35 + len(words)
+	count := int(binary.LittleEndian.Uint16(msg[dataOffset-2:]))
+	data := msg[dataOffset:min(dataOffset+count, len(msg))]
+	unicode := binary.LittleEndian.Uint16(msg[10:])&smb1Flags2Unicode != 0
+
+	switch command {
+	case smb1Negotiate:
+		return s.negotiateSMB1(msg, data)
+	case smb1SessionSetup:
+		return s.sessionSetupSMB1(msg, words, data, dataOffset, unicode)
+	case smb1TreeConnect:
+		if len(words) < 8 {
+			return errNotSMB
+		}
+		passwordLength := int(binary.LittleEndian.Uint16(words[6:]))
+		path := smb1Strings(msg, dataOffset+passwordLength, unicode, 1)[0]
+		if !s.treeConnect(path) {
+			return s.writeMessage(smb1Reply(msg, smbStatusAccessDenied, nil, nil))
+		}
+		reply := smb1Reply(msg, smbStatusSuccess, []byte{0xFF, 0, 0, 0, 0x01, 0x00}, []byte("IPC\x00"))
+		binary.LittleEndian.PutUint16(reply[24:], smb1TID)
+		return s.writeMessage(reply)
+	case smb1Transaction:
+		// The MS17-010 check of Metasploit, nmap and most worms: PeekNamedPipe
+		// on FID 0 of IPC$, which patched hosts refuse with STATUS_INVALID_HANDLE
+		if len(words) >= 32 && words[26] == 2 && binary.LittleEndian.Uint16(words[28:]) == 0x0023 && binary.LittleEndian.Uint16(words[30:]) == 0 {
+			s.meta.Emit(Event{
+				Type:    EventData,
+				Message: fmt.Sprintf("SMB MS17-010 check on port %s from %s", s.meta.Port, s.meta.ClientAddr),
+				Fields:  map[string]any{"data": string(msg), "smb_command": smb1CommandNames[command], "smb_probe": "ms17-010"},
+			})
+			return s.writeMessage(smb1Reply(msg, smbStatusInsuffResources, nil, nil))
+		}
+		s.logCommand(smb1CommandNames[command], msg)
+		return s.writeMessage(smb1Reply(msg, smbStatusNotImplemented, nil, nil))
+	case smb1Transaction2, smb1NTTransact:
+		// EternalBlue overflows the FEA list of an NT_TRANSACT sent in
+		// secondaries, DoublePulsar is driven by TRANSACTION2 SESSION_SETUP
+		s.logCommand(smb1CommandNames[command], msg)
+		s.alertExploit(command, words)
+		if command == smb1NTTransact {
+			// Interim response, asking for the secondaries carrying the rest
+			return s.writeMessage(smb1Reply(msg, smbStatusSuccess, nil, nil))
+		}
+		return s.writeMessage(smb1Reply(msg, smbStatusNotImplemented, nil, nil))
+	case smb1TransactionSecondary, smb1Transaction2Second, smb1NTTransactSecondary:
+		// Secondaries are not answered
+		s.logCommand(smb1CommandNames[command], msg)
+		return nil
+	case smb1Echo:
+		return s.writeMessage(smb1Reply(msg, smbStatusSuccess, []byte{1, 0}, data))
+	case smb1TreeDisconnect:
+		return s.writeMessage(smb1Reply(msg, smbStatusSuccess, nil, nil))
+	case smb1Logoff:
+		return s.writeMessage(smb1Reply(msg, smbStatusSuccess, []byte{0xFF, 0, 0, 0}, nil))
+	}
+	name, ok := smb1CommandNames[command]
+	if !ok {
+		name = fmt.Sprintf("0x%02X", command)
+	}
+	s.logCommand(name, msg)
+	return s.writeMessage(smb1Reply(msg, smbStatusNotSupported, nil, nil))
+}
+
+// negotiateSMB1 answers the dialects of an SMB1 NEGOTIATE, switching to SMB2
+// when the client offers it like Windows does.
+func (s *smbConn) negotiateSMB1(msg, data []byte) error {
+	var dialects []string
+	for rest := data; len(rest) > 1 && rest[0] == 0x02; {
+		var name []byte
+		name, rest, _ = bytes.Cut(rest[1:], []byte{0})
+		dialects = append(dialects, string(name))
+	}
+	s.meta.Emit(Event{
+		Type: EventData,
+		Message: fmt.Sprintf("SMB1 negotiation on port %s from %s: dialects %s",
+			s.meta.Port, s.meta.ClientAddr, strings.Join(dialects, ", ")),
+		Fields: map[string]any{"data": string(msg), "smb_version": 1, "smb_dialects": dialects},
+	})
+	for _, d := range []struct {
+		name    string
+		dialect uint16
+	}{{"SMB 2.???", smb2Wildcard}, {"SMB 2.002", 0x0202}} {
+		for _, name := range dialects {
+			if name == d.name {
+				return s.writeMessage(s.smb2Reply(nil, smbStatusSuccess, s.negotiateResponse(d.dialect)))
+			}
+		}
+	}
+
+	index := -1
+	for i, name := range dialects {
+		if name == "NT LM 0.12" {
+			index = i
+		}
+	}
+	if index < 0 {
+		return s.writeMessage(smb1Reply(msg, smbStatusSuccess, []byte{0xFF, 0xFF}, nil))
+	}
+	id := s.meta.Identity
+	extended := binary.LittleEndian.Uint16(msg[10:])&smb1Flags2ExtendedSecurity != 0
+	words := binary.LittleEndian.AppendUint16(nil, uint16(index))
+	words = append(words, 0x03)                            // user level security, challenge/response
+	words = binary.LittleEndian.AppendUint16(words, 50)    // MaxMpxCount
+	words = binary.LittleEndian.AppendUint16(words, 1)     // MaxNumberVcs
+	words = binary.LittleEndian.AppendUint32(words, 16644) // MaxBufferSize
+	words = binary.LittleEndian.AppendUint32(words, 65536) // MaxRawSize
+	words = binary.LittleEndian.AppendUint32(words, 0)     // SessionKey
+	capabilities := uint32(smb1Capabilities)
+	if extended {
+		capabilities |= smb1CapExtendedSecurity
+	}
+	words = binary.LittleEndian.AppendUint32(words, capabilities)
+	words = binary.LittleEndian.AppendUint64(words, fileTime(time.Now()))
+	words = binary.LittleEndian.AppendUint16(words, 0) // ServerTimeZone
+	var body []byte
+	if extended {
+		words = append(words, 0)
+		guid := smbServerGUID(id)
+		body = append(guid[:], spnegoInit()...)
+	} else {
+		words = append(words, byte(len(s.challenge)))
+		body = append(s.challenge[:], smbString(strings.ToUpper(strings.SplitN(id.Domain, ".", 2)[0]), true)...)
+		body = append(body, smbString(id.SMBHostname(), true)...)
+	}
+	return s.writeMessage(smb1Reply(msg, smbStatusSuccess, words, body))
+}
+
+// sessionSetupSMB1 answers a SESSION_SETUP_ANDX, with a security blob when
+// extended security was negotiated and with the client's challenge
+// responses otherwise.
+func (s *smbConn) sessionSetupSMB1(msg, words, data []byte, dataOffset int, unicode bool) error {
+	os, lanman := s.nativeOS()
+	switch len(words) {
+	case 24:
+		blobLength := int(binary.LittleEndian.Uint16(words[14:]))
+		info := smb1Strings(msg, dataOffset+blobLength, unicode, 1)
+		status, token := s.authenticate(data[:min(blobLength, len(data))], info[0])
+		body := token
+		if len(body)%2 == 0 {
+			// Align the strings following the blob at 43 + its length
+			body = append(body, 0)
+		}
+		body = append(body, smbString(os, true)...)
+		body = append(body, smbString(lanman, true)...)
+		resp := []byte{0xFF, 0, 0, 0, 0x01, 0x00} // no AndX, guest
+		resp = binary.LittleEndian.AppendUint16(resp, uint16(len(token)))
+		reply := smb1Reply(msg, status, resp, body)
+		binary.LittleEndian.PutUint16(reply[28:], smb1UID)
+		return s.writeMessage(reply)
+	case 26:
+		lmLength := int(binary.LittleEndian.Uint16(words[14:]))
+		ntLength := int(binary.LittleEndian.Uint16(words[16:]))
+		if lmLength+ntLength > len(data) {
+			return errNotSMB
+		}
+		info := smb1Strings(msg, dataOffset+lmLength+ntLength, unicode, 3)
+		auth := ntlmAuth{User: info[0], Domain: info[1], LMResponse: data[:lmLength], NTResponse: data[lmLength : lmLength+ntLength]}
+		s.logLogon(auth, info[2])
+		body := []byte{0}
+		body = append(body, smbString(os, true)...)
+		body = append(body, smbString(lanman, true)...)
+		body = append(body, smbString(strings.ToUpper(strings.SplitN(s.meta.Identity.Domain, ".", 2)[0]), true)...)
+		reply := smb1Reply(msg, smbStatusSuccess, []byte{0xFF, 0, 0, 0, 0x01, 0x00}, body)
+		binary.LittleEndian.PutUint16(reply[28:], smb1UID)
+		return s.writeMessage(reply)
+	}
+	return errNotSMB
+}
+
+// smb1Reply returns the response to an SMB1 request.
+func smb1Reply(req []byte, status uint32, words, data []byte) []byte {
+	msg := append([]byte(nil), req[:32]...)
+	binary.LittleEndian.PutUint32(msg[5:], status)
+	msg[9] = 0x98 // reply, canonicalized and case-insensitive paths
+	flags2 := binary.LittleEndian.Uint16(req[10:])&(smb1Flags2Unicode|smb1Flags2ExtendedSecurity) | 0x4003
+	binary.LittleEndian.PutUint16(msg[10:], flags2)
+	clear(msg[14:22]) // no signature
+	msg = append(msg, byte(len(words)/2))
+	msg = append(msg, words...)
+	msg = binary.LittleEndian.AppendUint16(msg, uint16(len(data)))
+	return append(msg, data...)
+}
+
+// smb1Strings reads n null-terminated strings starting at msg[at:], which
+// are aligned to two bytes when Unicode. Missing strings are empty.
+func smb1Strings(msg []byte, at int, unicode bool, n int) []string {
+	if unicode && at%2 == 1 {
+		at++
+	}
+	values := make([]string, n)
+	for i := range values {
+		if at >= len(msg) {
+			break
+		}
+		rest := msg[at:]
+		if !unicode {
+			value, _, _ := bytes.Cut(rest, []byte{0})
+			values[i] = string(value)
+			at += len(value) + 1
+			continue
+		}
+		end := len(rest) &^ 1
+		for j := 0; j+1 < len(rest); j += 2 {
+			if rest[j] == 0 && rest[j+1] == 0 {
+				end = j
+				break
+			}
+		}
+		values[i] = utf16LEString(rest[:end])
+		at += end + 2
+	}
+	return values
+}
+
+// smbString encodes s null-terminated.
+func smbString(s string, unicode bool) []byte {
+	if !unicode {
+		return append([]byte(s), 0)
+	}
+	return append(utf16LE(s), 0, 0)
+}
+
+// alertExploit reports EternalBlue and DoublePulsar traffic, once per
+// connection.
+func (s *smbConn) alertExploit(command byte, words []byte) {
+	if s.alerted {
+		return
+	}
+	switch {
+	case command == smb1NTTransact:
+		s.meta.RaiseAlert("high", "smb_exploit", fmt.Sprintf("SMB1 NT_TRANSACT from %s, as sent by the EternalBlue exploit", s.meta.ClientAddr))
+	case command == smb1Transaction2 && len(words) >= 30 && binary.LittleEndian.Uint16(words[28:]) == 0x000E:
+		s.meta.RaiseAlert("high", "smb_exploit", fmt.Sprintf("SMB1 TRANSACTION2 SESSION_SETUP from %s, as sent to the DoublePulsar backdoor", s.meta.ClientAddr))
+	default:
+		return
+	}
+	s.alerted = true
+}
+
+// logCommand logs a request that is not emulated, with its content.
+func (s *smbConn) logCommand(name string, msg []byte) {
+	s.meta.Emit(Event{
+		Type:    EventData,
+		Message: fmt.Sprintf("SMB %s request on port %s from %s (%d bytes)", name, s.meta.Port, s.meta.ClientAddr, len(msg)),
+		Fields:  map[string]any{"data": string(msg), "smb_command": name},
+	})
+}
+
+// serveSMB2 answers an SMB2 request; further requests compounded with it
+// are ignored.
+func (s *smbConn) serveSMB2(msg []byte) error {
+	if len(msg) < 66 {
+		return errNotSMB
+	}
+	command := binary.LittleEndian.Uint16(msg[12:])
+	body := msg[64:]
+	switch command {
+	case smb2Negotiate:
+		if len(body) < 36 {
+			return errNotSMB
+		}
+		count := int(binary.LittleEndian.Uint16(body[2:]))
+		var offered []uint16
+		var names []string
+		for i := 0; i < count && 36+2*i+2 <= len(body); i++ {
+			dialect := binary.LittleEndian.Uint16(body[36+2*i:])
+			offered = append(offered, dialect)
+			name, ok := smb2DialectNames[dialect]
+			if !ok {
+				name = fmt.Sprintf("0x%04X", dialect)
+			}
+			names = append(names, name)
+		}
+		s.meta.Emit(Event{
+			Type: EventData,
+			Message: fmt.Sprintf("SMB2 negotiation on port %s from %s: dialects %s",
+				s.meta.Port, s.meta.ClientAddr, strings.Join(names, ", ")),
+			Fields: map[string]any{"data": string(msg), "smb_version": 2, "smb_dialects": names},
+		})
+		var selected uint16
+		for _, dialect := range offered {
+			if dialect <= smb2MaxDialect && dialect > selected {
+				selected = dialect
+			}
+		}
+		if selected == 0 {
+			return s.writeMessage(s.smb2Reply(msg, smbStatusNotSupported, smb2ErrorBody))
+		}
+		return s.writeMessage(s.smb2Reply(msg, smbStatusSuccess, s.negotiateResponse(selected)))
+	case smb2SessionSetup:
+		if len(body) < 24 {
+			return errNotSMB
+		}
+		offset := int(binary.LittleEndian.Uint16(body[12:]))
+		length := int(binary.LittleEndian.Uint16(body[14:]))
+		if offset > len(msg) || length > len(msg)-offset {
+			return errNotSMB
+		}
+		if s.sessionID == 0 {
+			binary.Read(s.meta.Rand(), binary.LittleEndian, &s.sessionID)
+			s.sessionID |= 1
+		}
+		status, token := s.authenticate(msg[offset:offset+length], "")
+		resp := []byte{9, 0}
+		if status == smbStatusSuccess {
+			resp = binary.LittleEndian.AppendUint16(resp, 0x0001) // guest session, so that it is not signed
+		} else {
+			resp = binary.LittleEndian.AppendUint16(resp, 0)
+		}
+		resp = binary.LittleEndian.AppendUint16(resp, 64+8)
+		resp = binary.LittleEndian.AppendUint16(resp, uint16(len(token)))
+		return s.writeMessage(s.smb2Reply(msg, status, append(resp, token...)))
+	case smb2TreeConnect:
+		if len(body) < 8 {
+			return errNotSMB
+		}
+		offset := int(binary.LittleEndian.Uint16(body[4:]))
+		length := int(binary.LittleEndian.Uint16(body[6:]))
+		if offset > len(msg) || length > len(msg)-offset {
+			return errNotSMB
+		}
+		if !s.treeConnect(utf16LEString(msg[offset : offset+length])) {
+			return s.writeMessage(s.smb2Reply(msg, smbStatusAccessDenied, smb2ErrorBody))
+		}
+		// A named pipe share without caching, with full access
+		resp := []byte{16, 0, 0x02, 0}
+		resp = binary.LittleEndian.AppendUint32(resp, 0x00000030)
+		resp = binary.LittleEndian.AppendUint32(resp, 0)
+		resp = binary.LittleEndian.AppendUint32(resp, 0x001F01FF)
+		reply := s.smb2Reply(msg, smbStatusSuccess, resp)
+		binary.LittleEndian.PutUint32(reply[36:], smb2IPCTreeID)
+		return s.writeMessage(reply)
+	case smb2Create:
+		// Opening the srvsvc pipe to list the shares, or a file
+		name := ""
+		if len(body) >= 48 {
+			offset := int(binary.LittleEndian.Uint16(body[44:]))
+			length := int(binary.LittleEndian.Uint16(body[46:]))
+			if offset <= len(msg) && length <= len(msg)-offset {
+				name = utf16LEString(msg[offset : offset+length])
+			}
+		}
+		s.meta.Emit(Event{
+			Type:    EventData,
+			Message: fmt.Sprintf("SMB open of %q on port %s from %s", name, s.meta.Port, s.meta.ClientAddr),
+			Fields:  map[string]any{"smb_command": smb2CommandNames[command], "smb_file": name},
+		})
+		return s.writeMessage(s.smb2Reply(msg, smbStatusAccessDenied, smb2ErrorBody))
+	case smb2Logoff, smb2TreeDisconnect, smb2Echo:
+		return s.writeMessage(s.smb2Reply(msg, smbStatusSuccess, []byte{4, 0, 0, 0}))
+	}
+	name := fmt.Sprintf("0x%04X", command)
+	if int(command) < len(smb2CommandNames) {
+		name = smb2CommandNames[command]
+	}
+	s.logCommand(name, msg)
+	return s.writeMessage(s.smb2Reply(msg, smbStatusNotSupported, smb2ErrorBody))
+}
+
+// smb2ErrorBody is the body of error responses, without error data.
+var smb2ErrorBody = []byte{9, 0, 0, 0, 0, 0, 0, 0, 0}
+
+// negotiateResponse returns the body of the NEGOTIATE response selecting
+// dialect.
+func (s *smbConn) negotiateResponse(dialect uint16) []byte {
+	guid := smbServerGUID(s.meta.Identity)
+	resp := []byte{65, 0, 0x01, 0} // signing enabled, not required
+	resp = binary.LittleEndian.AppendUint16(resp, dialect)
+	resp = binary.LittleEndian.AppendUint16(resp, 0) // no negotiate contexts
+	resp = append(resp, guid[:]...)
+	resp = binary.LittleEndian.AppendUint32(resp, 0x00000007) // DFS, leasing, large MTU
+	for i := 0; i < 3; i++ {
+		resp = binary.LittleEndian.AppendUint32(resp, 8<<20) // MaxTransactSize, MaxReadSize, MaxWriteSize
+	}
+	resp = binary.LittleEndian.AppendUint64(resp, fileTime(time.Now()))
+	resp = binary.LittleEndian.AppendUint64(resp, 0) // ServerStartTime
+	blob := spnegoInit()
+	resp = binary.LittleEndian.AppendUint16(resp, 64+64)
+	resp = binary.LittleEndian.AppendUint16(resp, uint16(len(blob)))
+	resp = binary.LittleEndian.AppendUint32(resp, 0)
+	return append(resp, blob...)
+}
+
+// smb2Reply returns the response to an SMB2 request, or to an SMB1 NEGOTIATE
+// switching to SMB2 if req is nil.
+func (s *smbConn) smb2Reply(req []byte, status uint32, body []byte) []byte {
+	msg := make([]byte, 64, 64+len(body))
+	copy(msg, smb2Magic)
+	binary.LittleEndian.PutUint16(msg[4:], 64)
+	credits := uint16(1)
+	if req != nil {
+		copy(msg[6:8], req[6:8])     // CreditCharge
+		copy(msg[12:14], req[12:14]) // Command
+		credits = max(binary.LittleEndian.Uint16(req[14:]), 1)
+		copy(msg[24:40], req[24:40]) // MessageId, ProcessId, TreeId
+	}
+	binary.LittleEndian.PutUint32(msg[8:], status)
+	binary.LittleEndian.PutUint16(msg[14:], credits)
+	binary.LittleEndian.PutUint32(msg[16:], 0x00000001) // SMB2_FLAGS_SERVER_TO_REDIR
+	binary.LittleEndian.PutUint64(msg[40:], s.sessionID)
+	return append(msg, body...)
+}
+
+// authenticate runs a step of NTLM in the security blob of a session setup:
+// it answers NEGOTIATE with the CHALLENGE of the host and logs the account
+// of AUTHENTICATE, which succeeds. Blobs are SPNEGO tokens or, from some
+// tools, bare NTLM messages; the response is wrapped alike. Other mechanisms,
+// such as Kerberos, fail.
+func (s *smbConn) authenticate(blob []byte, nativeOS string) (status uint32, token []byte) {
+	message := blob
+	if i := bytes.Index(blob, ntlmSignature); i >= 0 {
+		message = blob[i:]
+	}
+	wrapped := !bytes.HasPrefix(blob, ntlmSignature)
+	switch ntlmMessageType(message) {
+	case ntlmNegotiate:
+		info, _ := parseNTLMNegotiate(message)
+		fields := map[string]any{"ntlm_negotiate": hex.EncodeToString(message), "ntlm_flags": fmt.Sprintf("0x%08x", info.Flags)}
+		text := fmt.Sprintf("SMB NTLM negotiate on port %s from %s: flags 0x%08x", s.meta.Port, s.meta.ClientAddr, info.Flags)
+		if info.Version != "" {
+			fields["ntlm_client_version"] = info.Version
+			text += ", Windows " + info.Version
+		}
+		if info.Domain != "" || info.Workstation != "" {
+			fields["ntlm_domain"], fields["ntlm_workstation"] = info.Domain, info.Workstation
+			text += fmt.Sprintf(", domain %q, workstation %q", info.Domain, info.Workstation)
+		}
+		if nativeOS != "" {
+			fields["smb_native_os"] = nativeOS
+		}
+		s.meta.Emit(Event{Type: EventData, Message: text, Fields: fields})
+		token = ntlmChallengeMessage(s.meta.Identity, s.challenge)
+		if wrapped {
+			token = spnegoResponse(1, token)
+		}
+		return smbStatusMoreProcessing, token
+	case ntlmAuthenticate:
+		auth, _ := parseNTLMAuthenticate(message)
+		s.logLogon(auth, nativeOS)
+		if wrapped {
+			token = spnegoResponse(0, nil)
+		}
+		return smbStatusSuccess, token
+	}
+	s.meta.LogData(string(blob))
+	return smbStatusLogonFailure, nil
+}
+
+// logLogon logs the account of a session setup as a credential, with its
+// NetNTLM response in hashcat format.
+func (s *smbConn) logLogon(auth ntlmAuth, nativeOS string) {
+	fields := map[string]any{"smb_username": auth.Username(), "smb_workstation": auth.Workstation, "ntlm_hash": auth.Hash(s.challenge)}
+	if nativeOS != "" {
+		fields["smb_native_os"] = nativeOS
+	}
+	text := fmt.Sprintf("SMB logon on port %s from %s: account %q from workstation %q", s.meta.Port, s.meta.ClientAddr, auth.Username(), auth.Workstation)
+	if auth.User == "" {
+		text = fmt.Sprintf("SMB anonymous logon on port %s from %s", s.meta.Port, s.meta.ClientAddr)
+	}
+	s.meta.Emit(Event{Type: EventData, Message: text, Fields: fields})
+	if auth.User != "" {
+		s.meta.LogCredential(Credential{Username: auth.Username()})
+	}
+}
+
+// treeConnect logs a share a client connects to, given as \\server\share,
+// and reports whether it exists: only IPC$ does.
+func (s *smbConn) treeConnect(path string) bool {
+	share := path[strings.LastIndex(path, `\`)+1:]
+	s.meta.Emit(Event{
+		Type:    EventData,
+		Message: fmt.Sprintf("SMB tree connect to %q on port %s from %s", path, s.meta.Port, s.meta.ClientAddr),
+		Fields:  map[string]any{"smb_path": path, "smb_share": share},
+	})
+	return strings.EqualFold(share, "IPC$")
+}
+
+// nativeOS returns the NativeOS and NativeLanMan strings of SMB1 session
+// setups: the Windows release, or Samba's.
+func (s *smbConn) nativeOS() (os, lanman string) {
+	id := s.meta.Identity
+	if id.IsWindows() {
+		return id.OS, strings.TrimSuffix(id.OS, id.OS[strings.LastIndex(id.OS, " "):]) + " 6.3"
+	}
+	return "Windows 6.1", "Samba " + id.Software["samba"]
+}
+
+// smbServerGUID derives the server GUID from the host identity, so that it
+// stays the same across restarts like a real server's.
+func smbServerGUID(id *HostIdentity) [16]byte {
+	var guid [16]byte
+	mathrand.New(mathrand.NewSource(id.seed() ^ 445)).Read(guid[:])
+	return guid
+}
+
+// SPNEGO (RFC 4178) object identifiers, DER-encoded.
+var (
+	oidSPNEGO  = []byte{0x06, 0x06, 0x2B, 0x06, 0x01, 0x05, 0x05, 0x02}
+	oidNTLMSSP = []byte{0x06, 0x0A, 0x2B, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0A}
+)
+
+// spnegoInit returns the negTokenInit of NEGOTIATE responses, offering NTLM
+// with the hint Windows servers send.
+func spnegoInit() []byte {
+	mechTypes := derTLV(0xA0, derTLV(0x30, oidNTLMSSP))
+	hints := derTLV(0xA3, derTLV(0x30, derTLV(0xA0, derTLV(0x1B, []byte("not_defined_in_RFC4178@please_ignore")))))
+	return derTLV(0x60, oidSPNEGO, derTLV(0xA0, derTLV(0x30, mechTypes, hints)))
+}
+
+// spnegoResponse returns a negTokenResp with the state accept-completed (0)
+// or accept-incomplete (1), and the NTLM token if any.
+func spnegoResponse(state byte, token []byte) []byte {
+	content := [][]byte{derTLV(0xA0, []byte{0x0A, 0x01, state})}
+	if token != nil {
+		content = append(content, derTLV(0xA1, oidNTLMSSP), derTLV(0xA2, derTLV(0x04, token)))
+	}
+	return derTLV(0xA1, derTLV(0x30, content...))
+}
+
+// derTLV encodes a DER element of the given tag.
+func derTLV(tag byte, content ...[]byte) []byte {
+	length := 0
+	for _, c := range content {
+		length += len(c)
+	}
+	b := []byte{tag}
+	switch {
+	case length < 0x80:
+		b = append(b, byte(length))
+	case length < 0x100:
+		b = append(b, 0x81, byte(length))
+	default:
+		b = append(b, 0x82, byte(length>>8), byte(length))
+	}
+	for _, c := range content {
+		b = append(b, c...)
+	}
+	return b
+}
@@ -0,0 +1,658 @@
+package honeypot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterPacketHandler("snmp", PacketHandlerFunc(serveSNMP))
+}
+
+// BER tags of the SNMP messages and values handled.
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berNull        = 0x05
+	berOID         = 0x06
+	berSequence    = 0x30
+	berCounter32   = 0x41
+	berGauge32     = 0x42
+	berTimeTicks   = 0x43
+
+	snmpNoSuchObject   = 0x80
+	snmpNoSuchInstance = 0x81
+	snmpEndOfMibView   = 0x82
+
+	snmpGet      = 0xA0
+	snmpGetNext  = 0xA1
+	snmpResponse = 0xA2
+	snmpSet      = 0xA3
+	snmpGetBulk  = 0xA5
+)
+
+// SNMP error statuses.
+const (
+	snmpNoSuchName  = 2
+	snmpReadOnly    = 4
+	snmpNotWritable = 17
+)
+
+// snmpMaxVarbinds caps the variables of one response, e.g. of a GetBulk
+// asking for thousands of repetitions.
+const snmpMaxVarbinds = 50
+
+var snmpPDUNames = map[byte]string{
+	snmpGet: "GET", snmpGetNext: "GETNEXT", snmpResponse: "RESPONSE", snmpSet: "SET",
+	0xA4: "TRAP", snmpGetBulk: "GETBULK", 0xA6: "INFORM", 0xA7: "TRAPV2", 0xA8: "REPORT",
+}
+
+// SNMPInterface is a row of the interface table.
+type SNMPInterface struct {
+	Descr string `json:"descr"`
+	Type  int    `json:"type"` // IANAifType, 6 for Ethernet, 24 for loopback
+	MTU   int    `json:"mtu"`
+	Speed uint32 `json:"speed"` // bits per second
+	MAC   string `json:"mac,omitempty"`
+}
+
+// SNMPAgent is the MIB served by the snmp handler. Empty system strings and
+// interfaces are derived from the server's host identity.
+type SNMPAgent struct {
+	Communities      []string          `json:"communities"`       // read-only communities, others get no answer
+	WriteCommunities []string          `json:"write_communities"` // communities whose SETs are accepted
+	SysDescr         string            `json:"sys_descr,omitempty"`
+	SysObjectID      string            `json:"sys_object_id,omitempty"`
+	SysContact       string            `json:"sys_contact,omitempty"`
+	SysName          string            `json:"sys_name,omitempty"`
+	SysLocation      string            `json:"sys_location,omitempty"`
+	Interfaces       []SNMPInterface   `json:"interfaces,omitempty"`
+	OIDs             map[string]string `json:"oids,omitempty"` // extra string values by dotted OID
+}
+
+// DefaultSNMPAgent returns an agent answering the community "public" and
+// accepting SETs with "private", the defaults of countless devices.
+func DefaultSNMPAgent() *SNMPAgent {
+	return &SNMPAgent{Communities: []string{"public"}, WriteCommunities: []string{"private"}}
+}
+
+// LoadSNMPAgent reads an agent profile in JSON on top of DefaultSNMPAgent.
+func LoadSNMPAgent(path string) (*SNMPAgent, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	agent := DefaultSNMPAgent()
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(agent); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if agent.SysObjectID != "" {
+		if _, err := parseOID(agent.SysObjectID); err != nil {
+			return nil, fmt.Errorf("%s: sys_object_id: %w", path, err)
+		}
+	}
+	for oid := range agent.OIDs {
+		if _, err := parseOID(oid); err != nil {
+			return nil, fmt.Errorf("%s: oids: %w", path, err)
+		}
+	}
+	for i, iface := range agent.Interfaces {
+		if iface.MAC != "" {
+			if _, err := net.ParseMAC(iface.MAC); err != nil {
+				return nil, fmt.Errorf("%s: interfaces[%d]: %w", path, i, err)
+			}
+		}
+	}
+	return agent, nil
+}
+
+// snmpState is what the snmp handler remembers of a session.
+type snmpState struct {
+	communities map[string]bool // communities already logged
+}
+
+// serveSNMP answers SNMP v1 and v2c GET, GETNEXT and GETBULK requests with a
+// matching community from the server's SNMPAgent, and logs every request and
+// the communities tried. SETs raise an snmp_set alert.
+func serveSNMP(ctx context.Context, packet []byte, meta *ConnMeta) [][]byte {
+	agent := meta.server.SNMPAgent
+	if agent == nil {
+		agent = DefaultSNMPAgent()
+	}
+	msg, err := parseSNMP(packet)
+	if err != nil {
+		meta.LogData(string(packet))
+		return nil
+	}
+	version := "v1"
+	switch msg.version {
+	case 1:
+		version = "v2c"
+	case 3:
+		version = "v3"
+	}
+	pduName := snmpPDUNames[msg.pdu]
+	if pduName == "" {
+		pduName = fmt.Sprintf("PDU 0x%02X", msg.pdu)
+	}
+	oids := make([]string, len(msg.varbinds))
+	for i, vb := range msg.varbinds {
+		oids[i] = formatOID(vb.oid)
+	}
+	meta.Emit(Event{
+		Type:    EventData,
+		Message: fmt.Sprintf("SNMP %s %s on port %s from %s: community %q, %s", version, pduName, meta.Port, meta.ClientAddr, msg.community, strings.Join(oids, " ")),
+		Fields: map[string]any{
+			"data": string(packet), "snmp_version": version, "snmp_community": msg.community,
+			"snmp_pdu": pduName, "snmp_oids": oids,
+		},
+	})
+	if msg.version == 3 {
+		return nil // USM needs users we do not have; unknown engines get no answer
+	}
+
+	state, _ := meta.HandlerState.(*snmpState)
+	if state == nil {
+		state = &snmpState{communities: make(map[string]bool)}
+		meta.HandlerState = state
+	}
+	if !state.communities[msg.community] {
+		state.communities[msg.community] = true
+		meta.LogCredential(Credential{Password: msg.community})
+	}
+	writable := slices.Contains(agent.WriteCommunities, msg.community)
+	if !writable && !slices.Contains(agent.Communities, msg.community) {
+		return nil // agents drop requests with a wrong community
+	}
+
+	mib := agent.mib(meta)
+	resp := snmpMessage{version: msg.version, community: msg.community, pdu: snmpResponse, requestID: msg.requestID}
+	switch msg.pdu {
+	case snmpGet:
+		for i, vb := range msg.varbinds {
+			value, ok := mib.get(vb.oid)
+			if !ok && msg.version == 0 {
+				resp.varbinds, resp.errorStatus, resp.errorIndex = msg.varbinds, snmpNoSuchName, i+1
+				break
+			}
+			if !ok {
+				value = mib.missing(vb.oid)
+			}
+			resp.varbinds = append(resp.varbinds, snmpVarbind{oid: vb.oid, value: value})
+		}
+	case snmpGetNext:
+		for i, vb := range msg.varbinds {
+			next, ok := mib.next(vb.oid)
+			if !ok && msg.version == 0 {
+				resp.varbinds, resp.errorStatus, resp.errorIndex = msg.varbinds, snmpNoSuchName, i+1
+				break
+			}
+			if !ok {
+				next = snmpVarbind{oid: vb.oid, value: berValue{tag: snmpEndOfMibView}}
+			}
+			resp.varbinds = append(resp.varbinds, next)
+		}
+	case snmpGetBulk:
+		if msg.version == 0 {
+			return nil
+		}
+		// For GETBULK the error fields hold non-repeaters and max-repetitions
+		nonRepeaters := min(max(msg.errorStatus, 0), len(msg.varbinds))
+		repetitions := max(msg.errorIndex, 0)
+		for _, vb := range msg.varbinds[:nonRepeaters] {
+			next, ok := mib.next(vb.oid)
+			if !ok {
+				next = snmpVarbind{oid: vb.oid, value: berValue{tag: snmpEndOfMibView}}
+			}
+			resp.varbinds = append(resp.varbinds, next)
+		}
+		cursors := make([][]uint32, 0, len(msg.varbinds)-nonRepeaters)
+		for _, vb := range msg.varbinds[nonRepeaters:] {
+			cursors = append(cursors, vb.oid)
+		}
+	repeat:
+		for r := 0; r < repetitions && len(cursors) > 0; r++ {
+			for i := range cursors {
+				if len(resp.varbinds) >= snmpMaxVarbinds {
+					break repeat
+				}
+				next, ok := mib.next(cursors[i])
+				if !ok {
+					next = snmpVarbind{oid: cursors[i], value: berValue{tag: snmpEndOfMibView}}
+				}
+				resp.varbinds = append(resp.varbinds, next)
+				cursors[i] = next.oid
+			}
+		}
+	case snmpSet:
+		meta.RaiseAlert("medium", "snmp_set", fmt.Sprintf("SNMP SET of %s with community %q from %s", strings.Join(oids, " "), msg.community, meta.ClientAddr))
+		if meta.Stage < StageExploit {
+			meta.Stage = StageExploit
+		}
+		resp.varbinds = msg.varbinds
+		if !writable {
+			resp.errorIndex = 1
+			resp.errorStatus = snmpNotWritable
+			if msg.version == 0 {
+				resp.errorStatus = snmpReadOnly
+			}
+		}
+	default:
+		return nil // traps, informs and responses are only logged
+	}
+	return [][]byte{resp.encode()}
+}
+
+// snmpMIB is the agent's MIB at the time of a request, sorted by OID.
+type snmpMIB struct {
+	entries []snmpVarbind
+}
+
+func (m *snmpMIB) find(oid []uint32) (int, bool) {
+	return slices.BinarySearchFunc(m.entries, oid, func(e snmpVarbind, target []uint32) int {
+		return slices.Compare(e.oid, target)
+	})
+}
+
+func (m *snmpMIB) get(oid []uint32) (berValue, bool) {
+	if i, ok := m.find(oid); ok {
+		return m.entries[i].value, true
+	}
+	return berValue{}, false
+}
+
+// next returns the first entry after oid, as GETNEXT walks the MIB.
+func (m *snmpMIB) next(oid []uint32) (snmpVarbind, bool) {
+	i, ok := m.find(oid)
+	if ok {
+		i++
+	}
+	if i >= len(m.entries) {
+		return snmpVarbind{}, false
+	}
+	return m.entries[i], true
+}
+
+// missing returns the v2c exception for an OID without a value:
+// noSuchInstance below an existing object, noSuchObject otherwise.
+func (m *snmpMIB) missing(oid []uint32) berValue {
+	if len(oid) > 1 {
+		if next, ok := m.next(oid[:len(oid)-1]); ok && slices.Equal(next.oid[:min(len(next.oid), len(oid)-1)], oid[:len(oid)-1]) {
+			return berValue{tag: snmpNoSuchInstance}
+		}
+	}
+	return berValue{tag: snmpNoSuchObject}
+}
+
+// OIDs of the system and interfaces groups of MIB-II.
+var (
+	oidSystem     = []uint32{1, 3, 6, 1, 2, 1, 1}
+	oidInterfaces = []uint32{1, 3, 6, 1, 2, 1, 2}
+)
+
+// mib builds the MIB seen by a session. Uptime and traffic counters grow
+// from a boot time derived from the host identity.
+func (a *SNMPAgent) mib(meta *ConnMeta) *snmpMIB {
+	id := meta.Identity
+	seed := id.seed()
+	boot := meta.server.started.Add(-time.Duration(uint64(seed)%(90*24*3600)) * time.Second)
+	uptime := time.Since(boot)
+
+	descr, objectID, contact, name, location := a.SysDescr, a.SysObjectID, a.SysContact, a.SysName, a.SysLocation
+	if descr == "" {
+		descr = id.SNMPSysDescr()
+	}
+	if objectID == "" {
+		objectID = "1.3.6.1.4.1.8072.3.2.10" // net-snmp on Linux
+		if id.IsWindows() {
+			objectID = "1.3.6.1.4.1.311.1.1.3.1.2" // Windows Server
+		}
+	}
+	if contact == "" {
+		contact = "it-ops@" + id.Domain
+	}
+	if name == "" {
+		name = id.Hostname
+	}
+	if location == "" {
+		location = "Server Room"
+	}
+	sysObjectID, _ := parseOID(objectID)
+
+	m := &snmpMIB{}
+	add := func(oid []uint32, value berValue) {
+		m.entries = append(m.entries, snmpVarbind{oid: oid, value: value})
+	}
+	sub := func(base []uint32, arcs ...uint32) []uint32 {
+		return append(slices.Clone(base), arcs...)
+	}
+	add(sub(oidSystem, 1, 0), berString(descr))
+	add(sub(oidSystem, 2, 0), berValue{tag: berOID, content: encodeOID(sysObjectID)})
+	add(sub(oidSystem, 3, 0), berUnsigned(berTimeTicks, uint32(uptime/(10*time.Millisecond))))
+	add(sub(oidSystem, 4, 0), berString(contact))
+	add(sub(oidSystem, 5, 0), berString(name))
+	add(sub(oidSystem, 6, 0), berString(location))
+	add(sub(oidSystem, 7, 0), berInt(berInteger, 72))
+
+	interfaces := a.Interfaces
+	if len(interfaces) == 0 {
+		interfaces = defaultSNMPInterfaces(id)
+	}
+	add(sub(oidInterfaces, 1, 0), berInt(berInteger, int64(len(interfaces))))
+	seconds := uint64(uptime / time.Second)
+	for i, iface := range interfaces {
+		index := uint32(i + 1)
+		mac, _ := net.ParseMAC(iface.MAC)
+		// Traffic grows at a few kB/s, faster on busier interfaces
+		rate := uint64(2000 + (uint64(seed)>>(i%8*4))%20000)
+		columns := []berValue{
+			1:  berInt(berInteger, int64(index)),
+			2:  berString(iface.Descr),
+			3:  berInt(berInteger, int64(iface.Type)),
+			4:  berInt(berInteger, int64(iface.MTU)),
+			5:  berUnsigned(berGauge32, iface.Speed),
+			6:  {tag: berOctetString, content: mac},
+			7:  berInt(berInteger, 1), // ifAdminStatus up
+			8:  berInt(berInteger, 1), // ifOperStatus up
+			10: berUnsigned(berCounter32, uint32(seconds*rate*3)),
+			16: berUnsigned(berCounter32, uint32(seconds*rate)),
+		}
+		for column, value := range columns {
+			if value.tag != 0 {
+				add(sub(oidInterfaces, 2, 1, uint32(column), index), value)
+			}
+		}
+	}
+	for dotted, value := range a.OIDs {
+		oid, _ := parseOID(dotted)
+		add(oid, berString(value))
+	}
+	slices.SortFunc(m.entries, func(a, b snmpVarbind) int { return slices.Compare(a.oid, b.oid) })
+	return m
+}
+
+// defaultSNMPInterfaces returns a loopback interface and one Ethernet
+// interface per MAC address of the host.
+func defaultSNMPInterfaces(id *HostIdentity) []SNMPInterface {
+	interfaces := []SNMPInterface{{Descr: "lo", Type: 24, MTU: 65536, Speed: 10_000_000}}
+	if id.IsWindows() {
+		interfaces[0].Descr = "Software Loopback Interface 1"
+		interfaces[0].MTU = 1500
+	}
+	for i, mac := range id.MACs {
+		descr := "eth" + strconv.Itoa(i)
+		if id.IsWindows() {
+			descr = "Intel(R) 82574L Gigabit Network Connection"
+			if i > 0 {
+				descr += " #" + strconv.Itoa(i+1)
+			}
+		}
+		interfaces = append(interfaces, SNMPInterface{Descr: descr, Type: 6, MTU: 1500, Speed: 1_000_000_000, MAC: mac})
+	}
+	return interfaces
+}
+
+// snmpMessage is an SNMP v1 or v2c message.
+type snmpMessage struct {
+	version     int
+	community   string
+	pdu         byte
+	requestID   int
+	errorStatus int // non-repeaters for GETBULK
+	errorIndex  int // max-repetitions for GETBULK
+	varbinds    []snmpVarbind
+}
+
+type snmpVarbind struct {
+	oid   []uint32
+	value berValue
+}
+
+// berValue is an encoded BER value.
+type berValue struct {
+	tag     byte
+	content []byte
+}
+
+func berString(s string) berValue { return berValue{tag: berOctetString, content: []byte(s)} }
+
+func berInt(tag byte, v int64) berValue {
+	content := []byte{byte(v)}
+	for v > 127 || v < -128 {
+		v >>= 8
+		content = append([]byte{byte(v)}, content...)
+	}
+	return berValue{tag: tag, content: content}
+}
+
+// berUnsigned encodes Counter32, Gauge32 and TimeTicks values.
+func berUnsigned(tag byte, v uint32) berValue {
+	return berInt(tag, int64(v))
+}
+
+var errBER = errors.New("malformed BER encoding")
+
+// readTLV splits the first BER value off data.
+func readTLV(data []byte) (tag byte, content, rest []byte, err error) {
+	if len(data) < 2 {
+		return 0, nil, nil, errBER
+	}
+	tag, length := data[0], int(data[1])
+	data = data[2:]
+	if length&0x80 != 0 {
+		n := length & 0x7F
+		if n == 0 || n > 3 || len(data) < n {
+			return 0, nil, nil, errBER
+		}
+		length = 0
+		for _, b := range data[:n] {
+			length = length<<8 | int(b)
+		}
+		data = data[n:]
+	}
+	if length > len(data) {
+		return 0, nil, nil, errBER
+	}
+	return tag, data[:length], data[length:], nil
+}
+
+func parseBERInt(content []byte) (int, error) {
+	if len(content) == 0 || len(content) > 8 {
+		return 0, errBER
+	}
+	v := int64(int8(content[0]))
+	for _, b := range content[1:] {
+		v = v<<8 | int64(b)
+	}
+	return int(v), nil
+}
+
+func decodeOID(content []byte) ([]uint32, error) {
+	if len(content) == 0 {
+		return nil, errBER
+	}
+	oid := []uint32{uint32(content[0]) / 40, uint32(content[0]) % 40}
+	if content[0] >= 80 {
+		oid = []uint32{2, uint32(content[0]) - 80}
+	}
+	var arc uint32
+	for i, b := range content[1:] {
+		arc = arc<<7 | uint32(b&0x7F)
+		if b&0x80 == 0 {
+			oid = append(oid, arc)
+			arc = 0
+		} else if i == len(content)-2 {
+			return nil, errBER
+		}
+	}
+	return oid, nil
+}
+
+func encodeOID(oid []uint32) []byte {
+	if len(oid) < 2 {
+		return []byte{0}
+	}
+	content := []byte{byte(oid[0]*40 + oid[1])}
+	for _, arc := range oid[2:] {
+		var chunk []byte
+		for {
+			chunk = append([]byte{byte(arc & 0x7F)}, chunk...)
+			arc >>= 7
+			if arc == 0 {
+				break
+			}
+		}
+		for i := 0; i < len(chunk)-1; i++ {
+			chunk[i] |= 0x80
+		}
+		content = append(content, chunk...)
+	}
+	return content
+}
+
+// parseOID parses a dotted OID such as "1.3.6.1.2.1.1.1.0".
+func parseOID(dotted string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimPrefix(dotted, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", dotted)
+	}
+	oid := make([]uint32, len(parts))
+	for i, part := range parts {
+		arc, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", dotted)
+		}
+		oid[i] = uint32(arc)
+	}
+	if oid[0] > 2 || (oid[0] < 2 && oid[1] >= 40) {
+		return nil, fmt.Errorf("invalid OID %q", dotted)
+	}
+	return oid, nil
+}
+
+func formatOID(oid []uint32) string {
+	parts := make([]string, len(oid))
+	for i, arc := range oid {
+		parts[i] = strconv.FormatUint(uint64(arc), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// parseSNMP decodes a message. v3 messages only have their version set.
+func parseSNMP(packet []byte) (*snmpMessage, error) {
+	tag, content, _, err := readTLV(packet)
+	if err != nil || tag != berSequence {
+		return nil, errBER
+	}
+	msg := &snmpMessage{}
+	tag, value, content, err := readTLV(content)
+	if err != nil || tag != berInteger {
+		return nil, errBER
+	}
+	if msg.version, err = parseBERInt(value); err != nil {
+		return nil, err
+	}
+	if msg.version == 3 {
+		return msg, nil
+	}
+	if msg.version != 0 && msg.version != 1 {
+		return nil, errBER
+	}
+	tag, value, content, err = readTLV(content)
+	if err != nil || tag != berOctetString {
+		return nil, errBER
+	}
+	msg.community = string(value)
+	msg.pdu, content, _, err = readTLV(content)
+	if err != nil || msg.pdu&0xE0 != 0xA0 {
+		return nil, errBER
+	}
+	if msg.pdu == 0xA4 {
+		return msg, nil // v1 traps have another layout, only the community is of interest
+	}
+	ints := make([]int, 3)
+	for i := range ints {
+		tag, value, content, err = readTLV(content)
+		if err != nil || tag != berInteger {
+			return nil, errBER
+		}
+		if ints[i], err = parseBERInt(value); err != nil {
+			return nil, err
+		}
+	}
+	msg.requestID, msg.errorStatus, msg.errorIndex = ints[0], ints[1], ints[2]
+	tag, content, _, err = readTLV(content)
+	if err != nil || tag != berSequence {
+		return nil, errBER
+	}
+	for len(content) > 0 {
+		var vb []byte
+		if tag, vb, content, err = readTLV(content); err != nil || tag != berSequence {
+			return nil, errBER
+		}
+		tag, value, vb, err = readTLV(vb)
+		if err != nil || tag != berOID {
+			return nil, errBER
+		}
+		oid, err := decodeOID(value)
+		if err != nil {
+			return nil, err
+		}
+		valueTag, valueContent, _, err := readTLV(vb)
+		if err != nil {
+			return nil, err
+		}
+		msg.varbinds = append(msg.varbinds, snmpVarbind{oid: oid, value: berValue{tag: valueTag, content: valueContent}})
+	}
+	return msg, nil
+}
+
+func appendTLV(dst []byte, tag byte, content []byte) []byte {
+	dst = append(dst, tag)
+	switch n := len(content); {
+	case n < 0x80:
+		dst = append(dst, byte(n))
+	case n <= 0xFF:
+		dst = append(dst, 0x81, byte(n))
+	default:
+		dst = append(dst, 0x82, byte(n>>8), byte(n))
+	}
+	return append(dst, content...)
+}
+
+func (m *snmpMessage) encode() []byte {
+	var varbinds []byte
+	for _, vb := range m.varbinds {
+		var inner []byte
+		inner = appendTLV(inner, berOID, encodeOID(vb.oid))
+		tag, content := vb.value.tag, vb.value.content
+		if tag == 0 {
+			tag = berNull
+		}
+		inner = appendTLV(inner, tag, content)
+		varbinds = appendTLV(varbinds, berSequence, inner)
+	}
+	var pdu []byte
+	for _, v := range []int{m.requestID, m.errorStatus, m.errorIndex} {
+		i := berInt(berInteger, int64(v))
+		pdu = appendTLV(pdu, i.tag, i.content)
+	}
+	pdu = appendTLV(pdu, berSequence, varbinds)
+	version := berInt(berInteger, int64(m.version))
+	var body []byte
+	body = appendTLV(body, version.tag, version.content)
+	body = appendTLV(body, berOctetString, []byte(m.community))
+	body = appendTLV(body, m.pdu, pdu)
+	return appendTLV(nil, berSequence, body)
+}
@@ -0,0 +1,213 @@
+package honeypot
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+func init() {
+	RegisterHandler("telnet", HandlerFunc(serveTelnet))
+}
+
+// Telnet commands and limits of the telnet handler.
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetMaxLogins   = 3   // failed logins before the server hangs up, Windows profiles only
+	telnetMaxCommands = 500 // shell command lines read before the session is closed
+)
+
+// telnetNegotiation is what BusyBox telnetd sends first: DO ECHO, DO NAWS,
+// WILL ECHO and WILL SUPPRESS-GO-AHEAD.
+const telnetNegotiation = "\xff\xfd\x01\xff\xfd\x1f\xff\xfb\x01\xff\xfb\x03"
+
+// errTelnetEnd is returned by readLine once the session is over without
+// an error: the client hung up, even if only its sending half, the session
+// timed out or the server is shutting down.
+var errTelnetEnd = errors.New("telnet session ended")
+
+// telnetSession is one connection to the telnet handler.
+type telnetSession struct {
+	ctx      context.Context
+	conn     net.Conn
+	reader   *bufio.Reader
+	meta     *ConnMeta
+	received bool
+	total    int
+	afterCR  bool // the last line ended with CR, which may be followed by LF or NUL
+}
+
+// serveTelnet impersonates the BusyBox telnetd of an embedded Linux device,
+// the door IoT botnets such as Mirai come in through. Every login is
+// accepted, since those bots try a single password per connection, and the
+// client gets a fake BusyBox shell whose commands are logged, see
+// shellSession. Windows profiles reject every login instead.
+func serveTelnet(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	s := &telnetSession{ctx: ctx, conn: conn, meta: meta, reader: bufio.NewReaderSize(conn, meta.Limits.ReadBuffer)}
+	if err := s.serve(); !errors.Is(err, errTelnetEnd) {
+		return err
+	}
+	return nil
+}
+
+// serve runs the login and the shell of the session.
+func (s *telnetSession) serve() error {
+	meta := s.meta
+	if err := s.send(telnetNegotiation + meta.Identity.LegalBanner("")); err != nil {
+		return err
+	}
+	user, failed := "", 0
+	for s.ctx.Err() == nil {
+		if err := s.send("\r\n" + meta.Identity.Hostname + " login: "); err != nil {
+			return err
+		}
+		line, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		if user = strings.TrimSpace(line); user == "" {
+			continue
+		}
+		if err := s.send("Password: "); err != nil {
+			return err
+		}
+		password, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		meta.LogCredential(Credential{Username: user, Password: password})
+		if !meta.Identity.IsWindows() {
+			break
+		}
+		if failed++; failed == telnetMaxLogins {
+			return s.send("\r\nLogin incorrect\r\n")
+		}
+		if err := s.send("\r\nLogin incorrect\r\n"); err != nil {
+			return err
+		}
+	}
+	if s.ctx.Err() != nil {
+		return nil
+	}
+
+	sh := newShellSession(meta, user)
+	if err := s.send(strings.ReplaceAll(shellBanner, "\n", "\r\n") + sh.prompt()); err != nil {
+		return err
+	}
+	for i := 0; i < telnetMaxCommands && s.ctx.Err() == nil; i++ {
+		line, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(line) == "" {
+			if err := s.send("\r\n" + sh.prompt()); err != nil {
+				return err
+			}
+			continue
+		}
+		output, exit := sh.run(line)
+		// The server echoes what the client types
+		reply := line + "\r\n" + strings.ReplaceAll(output, "\n", "\r\n")
+		if exit {
+			return s.send(reply)
+		}
+		if err := s.send(reply + sh.prompt()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send writes text to the client.
+func (s *telnetSession) send(text string) error {
+	if _, err := io.WriteString(s.conn, text); err != nil {
+		return fmt.Errorf("writing to connection: %w", err)
+	}
+	return nil
+}
+
+// readLine reads a line typed by the client, without its line ending and
+// the telnet commands interleaved with it, or the first ReadBuffer bytes of
+// a longer one. Lines end with CR LF, CR NUL or LF. Once the session is
+// over, it returns errTelnetEnd or the error that ended it.
+func (s *telnetSession) readLine() (string, error) {
+	var line []byte
+	for len(line) < s.meta.Limits.ReadBuffer {
+		if s.ctx.Err() != nil {
+			return "", errTelnetEnd
+		}
+		if s.total >= s.meta.Limits.MaxBytes {
+			s.meta.Logf("Closing session on port %s from %s: %d bytes received", s.meta.Port, s.meta.ClientAddr, s.total)
+			return "", errTelnetEnd
+		}
+		s.conn.SetReadDeadline(s.meta.NextReadDeadline(s.received))
+		c, err := s.reader.ReadByte()
+		if err != nil {
+			return "", s.readError(line, err)
+		}
+		s.total++
+		s.received = true
+		afterCR := s.afterCR
+		s.afterCR = false
+		switch {
+		case c == telnetIAC:
+			if err := s.skipCommand(); err != nil {
+				return "", s.readError(line, err)
+			}
+		case afterCR && len(line) == 0 && (c == '\n' || c == 0):
+		case c == '\n':
+			return string(line), nil
+		case c == '\r':
+			s.afterCR = true
+			return string(line), nil
+		default:
+			line = append(line, c)
+		}
+	}
+	return string(line), nil
+}
+
+// readError reports a failed read like binaryReadError, returning
+// errTelnetEnd for the failures it ignores, such as hang-ups.
+func (s *telnetSession) readError(line []byte, err error) error {
+	if err := binaryReadError(s.ctx, s.meta, line, err, s.received); err != nil {
+		return err
+	}
+	return errTelnetEnd
+}
+
+// skipCommand reads the rest of a telnet command after IAC. An escaped
+// IAC, a data byte of 255, is dropped too: clients typing it are rare.
+func (s *telnetSession) skipCommand() error {
+	command, err := s.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	s.total++
+	switch {
+	case command >= telnetWILL && command <= telnetDONT:
+		_, err = s.reader.ReadByte() // the option
+		s.total++
+	case command == telnetSB:
+		for previous := byte(0); err == nil && s.total < s.meta.Limits.MaxBytes; {
+			var c byte
+			if c, err = s.reader.ReadByte(); err == nil {
+				s.total++
+				if previous == telnetIAC && c == telnetSE {
+					return nil
+				}
+				previous = c
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package honeypot
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTelnetHalfClose checks that a client closing its sending half ends
+// the session instead of being sent prompts until the handler budget runs
+// out.
+func TestTelnetHalfClose(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input string
+	}{
+		{"before the username", ""},
+		{"after the username", "root\r\n"},
+		{"in the shell", "root\r\nadmin\r\nuname -a\r\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := NewServer(1)
+			srv.Ports["23"] = &PortOptions{Handler: "telnet"}
+			defer srv.Shutdown()
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go srv.NewListener(ln, "23").Serve()
+
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			reader := bufio.NewReader(conn)
+			if _, err := reader.ReadString(':'); err != nil { // the login prompt
+				t.Fatal(err)
+			}
+			io.WriteString(conn, tc.input)
+			conn.(*net.TCPConn).CloseWrite()
+
+			rest, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("session still open after the client hung up: %v", err)
+			}
+			if len(rest) > 4096 {
+				t.Fatalf("sent %d bytes after the client hung up", len(rest))
+			}
+			if prompts := strings.Count(string(rest), "login: "); prompts > 1 {
+				t.Fatalf("sent the login prompt %d times after the client hung up", prompts)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package honeypot
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterPacketHandler("tftp", PacketHandlerFunc(serveTFTP))
+}
+
+// TFTP opcodes (RFC 1350, RFC 2347).
+const (
+	tftpRRQ   = 1
+	tftpWRQ   = 2
+	tftpDATA  = 3
+	tftpACK   = 4
+	tftpERROR = 5
+	tftpOACK  = 6
+)
+
+// TFTP error codes.
+const (
+	tftpErrNotFound  = 1
+	tftpErrIllegal   = 4
+	tftpErrUnknownID = 5
+)
+
+const (
+	tftpBlockSize    = 512
+	tftpMaxBlockSize = 1468 // the largest blksize answered, fitting an Ethernet frame
+	// tftpMaxBait is the part of a bait file served, so that a transfer
+	// fits the reflection allowance of a sender acknowledging blindly
+	tftpMaxBait = 3 * tftpBlockSize
+)
+
+var tftpOpcodes = map[uint16]string{
+	tftpRRQ: "RRQ", tftpWRQ: "WRQ", tftpDATA: "DATA", tftpACK: "ACK", tftpERROR: "ERROR", tftpOACK: "OACK",
+}
+
+// tftpTransfer is the transfer in progress in a tftp session.
+type tftpTransfer struct {
+	write     bool
+	filename  string
+	blockSize int
+	block     uint16 // last block sent or acknowledged
+	data      []byte // bait file served, or data uploaded so far
+	done      bool
+}
+
+// serveTFTP impersonates tftpd-hpa on port 69, the server network gear
+// loads configurations and firmware from. Every read and write request is
+// logged with the file name, which reveals what the attacker is after
+// (tftp_file_kind classifies it as config, firmware or boot), the transfer
+// mode and options. Reads are served from Server.TFTPRoot when the file
+// exists there, cut to tftpMaxBait, and get "File not found" otherwise.
+// Writes are accepted: the file uploaded is logged as data once complete
+// and raises a medium tftp_upload alert.
+func serveTFTP(ctx context.Context, packet []byte, meta *ConnMeta) [][]byte {
+	if len(packet) < 4 {
+		meta.LogData(string(packet))
+		return nil
+	}
+	t, _ := meta.HandlerState.(*tftpTransfer)
+	switch opcode := binary.BigEndian.Uint16(packet); opcode {
+	case tftpRRQ, tftpWRQ:
+		return tftpRequest(meta, opcode, packet[2:])
+	case tftpACK:
+		if t == nil || t.write || t.done {
+			return nil
+		}
+		if binary.BigEndian.Uint16(packet[2:]) != t.block {
+			return nil // a duplicate: resending would double the traffic (RFC 1123 4.2.3.1)
+		}
+		if int(t.block)*t.blockSize > len(t.data) {
+			t.done = true
+			meta.Logf("TFTP read of %s on port %s/udp from %s complete (%d bytes)", t.filename, meta.Port, meta.ClientAddr, len(t.data))
+			return nil
+		}
+		t.block++
+		return [][]byte{t.dataPacket()}
+	case tftpDATA:
+		if t == nil || !t.write {
+			return [][]byte{tftpError(tftpErrUnknownID, "Unknown transfer ID")}
+		}
+		if block := binary.BigEndian.Uint16(packet[2:]); block == t.block+1 && !t.done {
+			t.block = block
+			t.data = append(t.data, packet[4:]...)
+			if len(t.data) > meta.Limits.MaxBytes {
+				t.data = t.data[:meta.Limits.MaxBytes]
+			}
+			if len(packet)-4 < t.blockSize {
+				t.done = true
+				tftpUploaded(meta, t)
+			}
+		}
+		return [][]byte{tftpAck(t.block)} // duplicates are acknowledged again
+	case tftpERROR:
+		message, _, _ := bytes.Cut(packet[4:], []byte{0})
+		meta.Logf("TFTP error %d from %s on port %s/udp: %s", binary.BigEndian.Uint16(packet[2:]), meta.ClientAddr, meta.Port, message)
+		if t != nil {
+			t.done = true
+		}
+		return nil
+	default:
+		meta.LogData(string(packet))
+		return [][]byte{tftpError(tftpErrIllegal, "Illegal TFTP operation")}
+	}
+}
+
+// tftpRequest logs a read or write request and starts its transfer.
+func tftpRequest(meta *ConnMeta, opcode uint16, body []byte) [][]byte {
+	parts := strings.Split(string(body), "\x00")
+	if len(parts) < 3 || parts[0] == "" {
+		meta.LogData(string(body))
+		return [][]byte{tftpError(tftpErrIllegal, "Illegal TFTP operation")}
+	}
+	filename, mode := parts[0], strings.ToLower(parts[1])
+	options := make(map[string]string)
+	for i := 2; i+1 < len(parts); i += 2 {
+		options[strings.ToLower(parts[i])] = parts[i+1]
+	}
+	fields := map[string]any{"data": filename, "tftp_opcode": tftpOpcodes[opcode], "tftp_filename": filename, "tftp_mode": mode}
+	if kind := tftpFileKind(filename); kind != "" {
+		fields["tftp_file_kind"] = kind
+	}
+	if len(options) > 0 {
+		fields["tftp_options"] = options
+	}
+	verb := "read"
+	if opcode == tftpWRQ {
+		verb = "write"
+	}
+	meta.Emit(Event{
+		Type:    EventData,
+		Message: fmt.Sprintf("TFTP %s request for %s on port %s/udp from %s (mode %s)", verb, filename, meta.Port, meta.ClientAddr, mode),
+		Fields:  fields,
+	})
+	meta.Observe(filename)
+
+	t := &tftpTransfer{write: opcode == tftpWRQ, filename: filename, blockSize: tftpBlockSize}
+	if !t.write {
+		data, ok := tftpBait(meta.server.TFTPRoot, filename)
+		if !ok {
+			return [][]byte{tftpError(tftpErrNotFound, "File not found")}
+		}
+		t.data = data
+	}
+	meta.HandlerState = t
+
+	// Acknowledge the options understood, tftpd-hpa style; the client
+	// answers the OACK with ACK 0 or DATA 1
+	var oack []string
+	if size, err := strconv.Atoi(options["blksize"]); err == nil && size >= 8 {
+		t.blockSize = min(size, tftpMaxBlockSize)
+		oack = append(oack, "blksize", strconv.Itoa(t.blockSize))
+	}
+	if tsize, ok := options["tsize"]; ok {
+		if !t.write {
+			tsize = strconv.Itoa(len(t.data))
+		}
+		oack = append(oack, "tsize", tsize)
+	}
+	if timeout, err := strconv.Atoi(options["timeout"]); err == nil && timeout >= 1 && timeout <= 255 {
+		oack = append(oack, "timeout", options["timeout"])
+	}
+	if len(oack) > 0 {
+		return [][]byte{append([]byte{0, tftpOACK}, strings.Join(oack, "\x00")+"\x00"...)}
+	}
+	if t.write {
+		return [][]byte{tftpAck(0)}
+	}
+	t.block = 1
+	return [][]byte{t.dataPacket()}
+}
+
+// tftpUploaded logs a completed upload.
+func tftpUploaded(meta *ConnMeta, t *tftpTransfer) {
+	data := string(t.data)
+	meta.Emit(Event{
+		Type:    EventData,
+		Message: fmt.Sprintf("TFTP upload of %s on port %s/udp from %s (%d bytes)", t.filename, meta.Port, meta.ClientAddr, len(t.data)),
+		Fields:  map[string]any{"data": data, "tftp_opcode": "DATA", "tftp_filename": t.filename, "tftp_upload_bytes": len(t.data)},
+	})
+	meta.logDropperURLs(data)
+	meta.Observe(data)
+	if meta.Stage < StageExploit {
+		meta.Stage = StageExploit
+	}
+	meta.RaiseAlert("medium", "tftp_upload", fmt.Sprintf("TFTP upload of %s on port %s/udp from %s (%d bytes)", t.filename, meta.Port, meta.ClientAddr, len(t.data)))
+}
+
+// dataPacket returns the DATA packet of the current block of a read.
+func (t *tftpTransfer) dataPacket() []byte {
+	start := min((int(t.block)-1)*t.blockSize, len(t.data))
+	end := min(start+t.blockSize, len(t.data))
+	packet := binary.BigEndian.AppendUint16([]byte{0, tftpDATA}, t.block)
+	return append(packet, t.data[start:end]...)
+}
+
+func tftpAck(block uint16) []byte {
+	return binary.BigEndian.AppendUint16([]byte{0, tftpACK}, block)
+}
+
+func tftpError(code uint16, message string) []byte {
+	packet := binary.BigEndian.AppendUint16([]byte{0, tftpERROR}, code)
+	return append(append(packet, message...), 0)
+}
+
+// tftpBait returns the start of the file name requested under root, and
+// false when root is empty or has no such regular file. Names are resolved
+// like tftpd-hpa's -s option does: the leading slash is optional and
+// nothing outside root can be reached.
+func tftpBait(root, name string) ([]byte, bool) {
+	if root == "" {
+		return nil, false
+	}
+	name = path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+	f, err := os.Open(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		return nil, false
+	}
+	data := make([]byte, tftpMaxBait)
+	n, _ := io.ReadFull(f, data)
+	return data[:n], true
+}
+
+// tftpFileKinds classify requested file names by the fragments they contain.
+var tftpFileKinds = []struct {
+	kind      string
+	fragments []string
+}{
+	{"config", []string{"config", "cfg", ".conf", "startup", "running", "network-confg", "settings", "backup"}},
+	{"firmware", []string{"firmware", "fw", ".bin", ".img", ".trx", ".chk", "image", "sysupgrade"}},
+	{"boot", []string{"pxelinux", "pxeboot", "bootx64", "grubx64", ".efi", ".kpxe"}},
+}
+
+// tftpFileKind returns what a requested file appears to be: "config",
+// "firmware" or "boot", or "" when the name doesn't tell.
+func tftpFileKind(filename string) string {
+	lower := strings.ToLower(path.Base(strings.ReplaceAll(filename, "\\", "/")))
+	for _, k := range tftpFileKinds {
+		for _, fragment := range k.fragments {
+			if strings.Contains(lower, fragment) {
+				return k.kind
+			}
+		}
+	}
+	return ""
+}
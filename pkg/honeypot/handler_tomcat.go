@@ -0,0 +1,210 @@
+package honeypot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+func init() {
+	RegisterHandler("tomcat", HandlerFunc(serveTomcat))
+}
+
+// tomcatVersion is the impersonated Apache Tomcat release, unless the host
+// identity lists another one.
+const tomcatVersion = "9.0.71"
+
+// tomcatDefaultCredentials are the manager accounts left in tomcat-users.xml
+// by installers and tutorials, which the handler lets in.
+var tomcatDefaultCredentials = map[string]string{
+	"tomcat": "tomcat", "admin": "admin", "manager": "manager", "role1": "role1",
+	"both": "tomcat", "root": "root",
+}
+
+// tomcatDefaultPasswords are the other passwords of tomcatDefaultCredentials
+// users that are let in, for any of them.
+var tomcatDefaultPasswords = []string{"s3cret", "password", "changethis"}
+
+// serveTomcat impersonates an Apache Tomcat server with its manager and host
+// manager applications behind Basic authentication. Login attempts are
+// logged; default accounts are let in, and a WAR deployed through them
+// raises a tomcat_deploy alert. JSP uploads with PUT (CVE-2017-12615)
+// raise tomcat_put_jsp, and serialized session objects
+// (CVE-2020-9484) raise java_deserialization.
+func serveTomcat(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	version := tomcatVersion
+	if v := meta.Identity.Software["tomcat"]; v != "" {
+		version = v
+	}
+	return serveHTTPPersona(ctx, conn, meta, "Tomcat", func(req *http.Request, body []byte) httpReply {
+		return tomcatReply(meta, version, req, body)
+	})
+}
+
+func tomcatReply(meta *ConnMeta, version string, req *http.Request, body []byte) httpReply {
+	urlPath := path.Clean("/" + req.URL.Path)
+	if strings.HasSuffix(req.URL.Path, "/") && urlPath != "/" {
+		urlPath += "/"
+	}
+	cookie := [2]string{"Set-Cookie", "JSESSIONID=" + jsessionID(meta) + "; Path=/manager; HttpOnly"}
+	switch {
+	case req.Method == http.MethodPut && !strings.HasPrefix(urlPath, "/manager"):
+		// Tomcat on Windows and with readonly=false accepts "x.jsp/",
+		// "x.jsp%20" and "x.jsp::$DATA" for x.jsp
+		name := strings.ToLower(req.URL.Path)
+		if strings.Contains(name, ".jsp") {
+			if meta.Stage < StageExploit {
+				meta.Stage = StageExploit
+			}
+			meta.RaiseAlert("high", "tomcat_put_jsp", fmt.Sprintf("JSP upload to %s on port %s from %s (%d bytes)", req.URL.Path, meta.Port, meta.ClientAddr, len(body)))
+			return httpReply{Status: http.StatusCreated, Headers: [][2]string{httpDate()}}
+		}
+		return tomcatError(version, http.StatusForbidden, "Access to the specified resource has been forbidden.")
+	case urlPath == "/":
+		return httpPage(http.StatusOK, tomcatIndex(version), httpDate())
+	case strings.HasPrefix(urlPath, "/manager") || strings.HasPrefix(urlPath, "/host-manager"):
+		realm := "Tomcat Manager Application"
+		if strings.HasPrefix(urlPath, "/host-manager") {
+			realm = "Tomcat Host Manager Application"
+		}
+		user, password, ok := req.BasicAuth()
+		if !ok || !tomcatDefaultLogin(user, password) {
+			reply := tomcatError(version, http.StatusUnauthorized, "You are not authorized to view this page. If you have not changed any configuration files, please examine the file conf/tomcat-users.xml in your installation.")
+			reply.Headers = append(reply.Headers, [2]string{"WWW-Authenticate", `Basic realm="` + realm + `"`})
+			return reply
+		}
+		if meta.Stage < StageBruteForce {
+			meta.Stage = StageBruteForce
+		}
+		if strings.HasPrefix(urlPath, "/manager/text/deploy") || (strings.HasPrefix(urlPath, "/manager/html/upload") && req.Method == http.MethodPost) {
+			contextPath := req.URL.Query().Get("path")
+			if contextPath == "" {
+				contextPath = "/" + strings.TrimSuffix(path.Base(req.URL.Query().Get("war")), ".war")
+			}
+			if meta.Stage < StageExploit {
+				meta.Stage = StageExploit
+			}
+			meta.RaiseAlert("high", "tomcat_deploy", fmt.Sprintf("application deployed at %s by %q on port %s from %s (%d bytes)", contextPath, user, meta.Port, meta.ClientAddr, len(body)))
+			if strings.HasPrefix(urlPath, "/manager/text") {
+				return httpReply{Status: http.StatusOK, Headers: [][2]string{httpDate(), {"Content-Type", "text/plain;charset=utf-8"}},
+					Body: []byte("OK - Deployed application at context path [" + contextPath + "]\n")}
+			}
+		}
+		if strings.HasPrefix(urlPath, "/manager/text") {
+			return httpReply{Status: http.StatusOK, Headers: [][2]string{httpDate(), {"Content-Type", "text/plain;charset=utf-8"}},
+				Body: []byte(tomcatApps(meta, true))}
+		}
+		return httpPage(http.StatusOK, tomcatManager(meta, version), httpDate(), cookie, [2]string{"Cache-Control", "private"})
+	case urlPath == "/docs" || urlPath == "/examples":
+		return httpRedirect(urlPath+"/", httpDate())
+	}
+	// CVE-2020-9484: with the PersistentManager, a session id of
+	// "../../../x" loads x.session, uploaded by the attacker
+	if c, err := req.Cookie("JSESSIONID"); err == nil && strings.Contains(c.Value, "..") {
+		if meta.Stage < StageExploit {
+			meta.Stage = StageExploit
+		}
+		meta.RaiseAlert("high", "java_deserialization", fmt.Sprintf("JSESSIONID path traversal %q on port %s from %s", c.Value, meta.Port, meta.ClientAddr))
+		return tomcatError(version, http.StatusInternalServerError, "The server encountered an unexpected condition that prevented it from fulfilling the request.")
+	}
+	return tomcatError(version, http.StatusNotFound, "The origin server did not find a current representation for the target resource or is not willing to disclose that one exists.")
+}
+
+// tomcatDefaultLogin reports whether the manager lets user in.
+func tomcatDefaultLogin(user, password string) bool {
+	want, ok := tomcatDefaultCredentials[user]
+	if !ok {
+		return false
+	}
+	return password == want || slices.Contains(tomcatDefaultPasswords, password)
+}
+
+// tomcatApps lists the deployed applications, in the format of the text
+// manager, or as HTML table rows.
+func tomcatApps(meta *ConnMeta, text bool) string {
+	domain, _, _ := strings.Cut(meta.Identity.Domain, ".")
+	apps := [][3]string{
+		{"/", "ROOT", "0"}, {"/examples", "examples", "0"}, {"/host-manager", "host-manager", "0"},
+		{"/manager", "manager", "1"}, {"/docs", "docs", "0"}, {"/" + domain + "-portal", domain + "-portal", "3"},
+	}
+	var b strings.Builder
+	if text {
+		b.WriteString("OK - Listed applications for virtual host [localhost]\n")
+	}
+	for _, app := range apps {
+		if text {
+			fmt.Fprintf(&b, "%s:running:%s:%s\n", app[0], app[2], app[1])
+		} else {
+			fmt.Fprintf(&b, `<tr><td class="row-left"><small><a href="%s/">%[1]s</a></small></td><td class="row-left"><small>%s</small></td><td class="row-center"><small>true</small></td><td class="row-center"><small>%s</small></td></tr>`,
+				html.EscapeString(app[0]), html.EscapeString(app[1]), app[2])
+		}
+	}
+	return b.String()
+}
+
+func tomcatManager(meta *ConnMeta, version string) string {
+	id := meta.Identity
+	return `<!DOCTYPE html><html><head><link href="/manager/images/favicon.ico" rel="icon" type="image/x-icon"><title>/manager</title></head>
+<body bgcolor="#FFFFFF"><table cellspacing="4" border="0"><tr><td colspan="2"><a href="https://tomcat.apache.org/"><img border="0" alt="The Tomcat Servlet/JSP Container" align="left" src="/manager/images/tomcat.svg"></a></td></tr></table>
+<hr size="1" noshade="noshade"><table cellspacing="0" cellpadding="3" border="0" width="100%"><tr><td class="page-title" bordercolor="#000000" align="left" nowrap><font size="+2">Tomcat Web Application Manager</font></td></tr></table>
+<table border="1" cellspacing="0" cellpadding="3"><tr><td colspan="5" class="title">Applications</td></tr><tr><td class="header-left"><small>Path</small></td><td class="header-left"><small>Display Name</small></td><td class="header-center"><small>Running</small></td><td class="header-center"><small>Sessions</small></td></tr>` +
+		tomcatApps(meta, false) + `</table>
+<table border="1" cellspacing="0" cellpadding="3"><tr><td colspan="2" class="title">Deploy</td></tr><tr><td colspan="2" class="header-left"><small>WAR file to deploy</small></td></tr><tr><td colspan="2">
+<form method="post" action="/manager/html/upload?org.apache.catalina.filters.CSRF_NONCE=` + strings.ToUpper(meta.randomID()+meta.randomID()) + `" enctype="multipart/form-data"><input type="file" name="deployWar" size="40"><input type="submit" value="Deploy"></form></td></tr></table>
+<table border="1" cellspacing="0" cellpadding="3"><tr><td colspan="8" class="title">Server Information</td></tr><tr><td class="header-center"><small>Tomcat Version</small></td><td class="header-center"><small>JVM Version</small></td><td class="header-center"><small>OS Name</small></td><td class="header-center"><small>Hostname</small></td></tr>
+<tr><td class="row-center"><small>Apache Tomcat/` + version + `</small></td><td class="row-center"><small>11.0.18+10-post-Ubuntu-0ubuntu122.04</small></td><td class="row-center"><small>Linux</small></td><td class="row-center"><small>` + html.EscapeString(id.Hostname) + `</small></td></tr></table>
+<hr size="1" noshade="noshade"><center><font size="-1" color="#525D76"><em>Copyright &copy; 1999-2023, Apache Software Foundation</em></font></center></body></html>`
+}
+
+func tomcatIndex(version string) string {
+	return `<!DOCTYPE html>
+<html lang="en">
+    <head>
+        <meta charset="UTF-8" />
+        <title>Apache Tomcat/` + version + `</title>
+        <link href="favicon.ico" rel="icon" type="image/x-icon" />
+        <link href="tomcat.css" rel="stylesheet" type="text/css" />
+    </head>
+    <body>
+        <div id="wrapper">
+            <div id="navigation" class="curved container">
+                <span id="nav-home"><a href="https://tomcat.apache.org/">Home</a></span>
+                <span id="nav-hosts"><a href="/docs/">Documentation</a></span>
+                <span id="nav-config"><a href="/docs/config/">Configuration</a></span>
+                <span id="nav-examples"><a href="/examples/">Examples</a></span>
+                <br class="separator" />
+            </div>
+            <div id="asf-box">
+                <h1>Apache Tomcat/` + version + `</h1>
+            </div>
+            <div id="upper" class="curved container">
+                <div id="congrats" class="curved container">
+                    <h2>If you're seeing this, you've successfully installed Tomcat. Congratulations!</h2>
+                </div>
+                <div id="actions">
+                    <div class="button"><a class="container shadow" href="/manager/status"><span>Server Status</span></a></div>
+                    <div class="button"><a class="container shadow" href="/manager/html"><span>Manager App</span></a></div>
+                    <div class="button"><a class="container shadow" href="/host-manager/html"><span>Host Manager</span></a></div>
+                </div>
+            </div>
+            <p class="copyright">Copyright &copy;1999-2023 Apache Software Foundation.  All Rights Reserved</p>
+        </div>
+    </body>
+</html>
+`
+}
+
+// tomcatError returns the error page of Tomcat's ErrorReportValve.
+func tomcatError(version string, status int, description string) httpReply {
+	text := http.StatusText(status)
+	body := fmt.Sprintf(`<!doctype html><html lang="en"><head><title>HTTP Status %d – %s</title><style type="text/css">body {font-family:Tahoma,Arial,sans-serif;} h1, h2, h3, b {color:white;background-color:#525D76;} h1 {font-size:22px;} h2 {font-size:16px;} h3 {font-size:14px;} p {font-size:12px;} a {color:black;} .line {height:1px;background-color:#525D76;border:none;}</style></head><body><h1>HTTP Status %[1]d – %[2]s</h1><hr class="line" /><p><b>Type</b> Status Report</p><p><b>Description</b> %s</p><hr class="line" /><h3>Apache Tomcat/%s</h3></body></html>`,
+		status, text, description, version)
+	return httpReply{Status: status, Headers: [][2]string{
+		{"Content-Type", "text/html;charset=utf-8"}, {"Content-Language", "en"}, httpDate(),
+	}, Body: []byte(body)}
+}
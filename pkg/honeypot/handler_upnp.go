@@ -0,0 +1,389 @@
+package honeypot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterPacketHandler("ssdp", PacketHandlerFunc(serveSSDP))
+	RegisterHandler("upnp", HandlerFunc(serveUPnP))
+}
+
+// UPnP device and service types of an Internet gateway.
+const (
+	upnpGateway     = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+	upnpWANDevice   = "urn:schemas-upnp-org:device:WANDevice:1"
+	upnpWANConnDev  = "urn:schemas-upnp-org:device:WANConnectionDevice:1"
+	upnpIPConn      = "urn:schemas-upnp-org:service:WANIPConnection:1"
+	upnpCommonIface = "urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1"
+)
+
+// UPnPDevice is the Internet gateway announced by the ssdp handler and
+// served by the upnp handler. Empty fields get the values of miniupnpd on
+// OpenWrt, the UPnP stack of countless home routers.
+type UPnPDevice struct {
+	Port             string `json:"port"`              // TCP port of the upnp handler advertised in LOCATION
+	Address          string `json:"address,omitempty"` // address advertised, by default the sensor's address facing the client
+	Server           string `json:"server,omitempty"`  // SERVER header of SSDP and HTTP replies
+	FriendlyName     string `json:"friendly_name,omitempty"`
+	Manufacturer     string `json:"manufacturer,omitempty"`
+	ManufacturerURL  string `json:"manufacturer_url,omitempty"`
+	ModelName        string `json:"model_name,omitempty"`
+	ModelNumber      string `json:"model_number,omitempty"`
+	ModelDescription string `json:"model_description,omitempty"`
+	SerialNumber     string `json:"serial_number,omitempty"` // derived from the host identity if empty
+	UUID             string `json:"uuid,omitempty"`          // derived from the host identity if empty
+}
+
+// DefaultUPnPDevice returns an OpenWrt router running miniupnpd with its
+// HTTP server on port 5000.
+func DefaultUPnPDevice() *UPnPDevice {
+	d := &UPnPDevice{}
+	d.fillDefaults()
+	return d
+}
+
+func (d *UPnPDevice) fillDefaults() {
+	defaults := map[*string]string{
+		&d.Port:             "5000",
+		&d.Server:           "OpenWrt/22.03.5 UPnP/1.1 MiniUPnPd/2.3.0",
+		&d.FriendlyName:     "OpenWrt router",
+		&d.Manufacturer:     "OpenWrt",
+		&d.ManufacturerURL:  "https://openwrt.org/",
+		&d.ModelName:        "OpenWrt router",
+		&d.ModelNumber:      "22.03.5",
+		&d.ModelDescription: "OpenWrt router",
+	}
+	for field, value := range defaults {
+		if *field == "" {
+			*field = value
+		}
+	}
+}
+
+// LoadUPnPDevice reads a device profile in JSON on top of DefaultUPnPDevice.
+func LoadUPnPDevice(path string) (*UPnPDevice, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &UPnPDevice{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(d); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	d.fillDefaults()
+	if port, err := strconv.Atoi(d.Port); err != nil || port < 1 || port > 65535 {
+		return nil, fmt.Errorf("%s: invalid port %q", path, d.Port)
+	}
+	if d.Address != "" && net.ParseIP(d.Address) == nil {
+		return nil, fmt.Errorf("%s: invalid address %q", path, d.Address)
+	}
+	return d, nil
+}
+
+// upnpDevice returns the server's UPnPDevice with the serial number and
+// UUID of the host filled in.
+func upnpDevice(meta *ConnMeta) UPnPDevice {
+	d := DefaultUPnPDevice()
+	if meta.server.UPnPDevice != nil {
+		d = meta.server.UPnPDevice
+	}
+	device := *d
+	rng := rand.New(rand.NewSource(meta.Identity.seed()))
+	if device.SerialNumber == "" {
+		device.SerialNumber = fmt.Sprintf("%08d", rng.Intn(100000000))
+	}
+	if device.UUID == "" {
+		b := make([]byte, 16)
+		rng.Read(b)
+		device.UUID = fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	}
+	return device
+}
+
+// upnpAddress returns the address advertised to the client: the device's,
+// the local address of the session or, on a socket bound to all addresses,
+// the address the sensor reaches the client from.
+func upnpAddress(device UPnPDevice, meta *ConnMeta) string {
+	if device.Address != "" {
+		return device.Address
+	}
+	if host, _, err := net.SplitHostPort(meta.LocalAddr); err == nil {
+		if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+			return host
+		}
+	}
+	// Dialing UDP sends nothing, it only picks the route
+	if conn, err := net.Dial("udp", meta.ClientAddr); err == nil {
+		defer conn.Close()
+		host, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+		return host
+	}
+	return "192.168.1.1"
+}
+
+// ssdpState is what the ssdp handler remembers of a session.
+type ssdpState struct {
+	reported bool // ddos_prep emitted
+}
+
+// serveSSDP answers SSDP M-SEARCH discovery like miniupnpd: every search
+// target the gateway implements gets a reply pointing to the description
+// served by the upnp handler, and ssdp:all gets the main ones. Every
+// request is logged with its headers (ssdp_method, ssdp_st, ssdp_man,
+// ssdp_user_agent). SSDP is a reflection amplifier, so discovery also emits
+// a ddos_prep event once per session.
+func serveSSDP(ctx context.Context, packet []byte, meta *ConnMeta) [][]byte {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(packet)))
+	if err != nil {
+		meta.LogData(string(packet))
+		return nil
+	}
+	st, man := req.Header.Get("St"), strings.Trim(req.Header.Get("Man"), `"`)
+	meta.Emit(Event{
+		Type:    EventData,
+		Message: fmt.Sprintf("SSDP %s for %s on port %s/udp from %s", req.Method, st, meta.Port, meta.ClientAddr),
+		Fields: map[string]any{
+			"data": string(packet), "ssdp_method": req.Method, "ssdp_st": st, "ssdp_man": man, "ssdp_user_agent": req.Header.Get("User-Agent"),
+		},
+	})
+	meta.Observe(string(packet))
+	if req.Method != "M-SEARCH" || man != "ssdp:discover" {
+		return nil // NOTIFY and the like are not answered
+	}
+
+	state, _ := meta.HandlerState.(*ssdpState)
+	if state == nil {
+		state = &ssdpState{}
+		meta.HandlerState = state
+	}
+	if !state.reported {
+		state.reported = true
+		meta.Emit(Event{
+			Type: EventDDoSPrep,
+			Message: fmt.Sprintf("SSDP amplification probe on port %s/udp from %s: M-SEARCH for %s; the source address may be spoofed and be the intended target",
+				meta.Port, meta.ClientAddr, st),
+			Fields: map[string]any{"ddos_kind": "ssdp_discover", "ssdp_st": st},
+		})
+	}
+
+	device := upnpDevice(meta)
+	targets := []string{"upnp:rootdevice", "uuid:" + device.UUID, upnpGateway, upnpWANDevice, upnpWANConnDev, upnpIPConn, upnpCommonIface}
+	var replies [][]byte
+	for _, target := range targets {
+		if st == "ssdp:all" && (target == upnpWANDevice || target == upnpWANConnDev || target == upnpCommonIface) {
+			continue
+		}
+		if st != "ssdp:all" && !strings.EqualFold(st, target) {
+			continue
+		}
+		usn := "uuid:" + device.UUID
+		if !strings.HasPrefix(target, "uuid:") {
+			usn += "::" + target
+		}
+		replies = append(replies, []byte(strings.Join([]string{
+			"HTTP/1.1 200 OK",
+			"CACHE-CONTROL: max-age=120",
+			"ST: " + target,
+			"USN: " + usn,
+			"EXT:",
+			"SERVER: " + device.Server,
+			fmt.Sprintf("LOCATION: http://%s/rootDesc.xml", net.JoinHostPort(upnpAddress(device, meta), device.Port)),
+			`OPT: "http://schemas.upnp.org/upnp/1/0/"; ns=01`,
+			"01-NLS: 1",
+			"BOOTID.UPNP.ORG: 1",
+			"CONFIGID.UPNP.ORG: 1337",
+			"", "",
+		}, "\r\n")))
+	}
+	return replies
+}
+
+// serveUPnP serves the description and control URLs of the gateway the
+// ssdp handler announces. SOAP actions are logged with their arguments
+// (upnp_action, upnp_arguments) and answered like miniupnpd. AddPortMapping
+// appears to succeed and raises a high upnp_port_mapping alert: mappings
+// to a client outside the LAN are how UPnProxy turns routers into proxies.
+func serveUPnP(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	device := upnpDevice(meta)
+	return serveHTTPPersona(ctx, conn, meta, "UPnP", func(req *http.Request, body []byte) httpReply {
+		return upnpReply(meta, device, req, body)
+	})
+}
+
+func upnpReply(meta *ConnMeta, device UPnPDevice, req *http.Request, body []byte) httpReply {
+	headers := [][2]string{{"Server", device.Server}, {"Ext", ""}}
+	xmlReply := func(status int, content string) httpReply {
+		return httpReply{Status: status, Headers: append(headers, [2]string{"Content-Type", `text/xml; charset="utf-8"`}), Body: []byte(content)}
+	}
+	switch {
+	case req.URL.Path == "/rootDesc.xml" && req.Method == http.MethodGet:
+		return xmlReply(http.StatusOK, upnpDescription(device, upnpAddress(device, meta)))
+	case (req.URL.Path == "/WANIPCn.xml" || req.URL.Path == "/WANCfg.xml") && req.Method == http.MethodGet:
+		return xmlReply(http.StatusOK, upnpSCPD)
+	case strings.HasPrefix(req.URL.Path, "/evt/") && req.Method == "SUBSCRIBE":
+		return httpReply{Status: http.StatusOK, Headers: append(headers, [2]string{"SID", "uuid:" + meta.randomID()}, [2]string{"Timeout", "Second-1800"})}
+	case strings.HasPrefix(req.URL.Path, "/ctl/") && req.Method == http.MethodPost:
+		action, args := parseSOAPAction(req.Header.Get("Soapaction"), body)
+		status, content := upnpControl(meta, device, action, args)
+		return xmlReply(status, content)
+	}
+	return httpReply{Status: http.StatusNotFound, Headers: append(headers, [2]string{"Content-Type", "text/html"}),
+		Body: []byte("<HTML><HEAD><TITLE>404 Not Found</TITLE></HEAD><BODY><H1>Not Found</H1>The requested URL was not found on this server.</BODY></HTML>\r\n")}
+}
+
+// parseSOAPAction returns the action of a SOAP request, from the body or
+// the SOAPAction header, and its arguments.
+func parseSOAPAction(header string, body []byte) (string, map[string]string) {
+	_, action, _ := strings.Cut(strings.Trim(header, `"`), "#")
+	args := make(map[string]string)
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	depth, bodyDepth := 0, -1
+	var arg string
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case t.Name.Local == "Body" && bodyDepth < 0:
+				bodyDepth = depth
+			case bodyDepth > 0 && depth == bodyDepth+1:
+				action = t.Name.Local
+			case bodyDepth > 0 && depth == bodyDepth+2:
+				arg = t.Name.Local
+				args[arg] = ""
+			}
+		case xml.CharData:
+			if arg != "" {
+				args[arg] += strings.TrimSpace(string(t))
+			}
+		case xml.EndElement:
+			depth--
+			arg = ""
+		}
+	}
+	return action, args
+}
+
+// upnpControl runs a SOAP action and returns the status and body of the
+// reply.
+func upnpControl(meta *ConnMeta, device UPnPDevice, action string, args map[string]string) (int, string) {
+	meta.Emit(Event{
+		Type:    EventData,
+		Message: fmt.Sprintf("UPnP action %s on port %s from %s: %v", action, meta.Port, meta.ClientAddr, args),
+		Fields:  map[string]any{"upnp_action": action, "upnp_arguments": args},
+	})
+	var result string
+	switch action {
+	case "AddPortMapping", "AddAnyPortMapping":
+		if meta.Stage < StageExploit {
+			meta.Stage = StageExploit
+		}
+		client := args["NewInternalClient"]
+		target := "a LAN host"
+		if ip := net.ParseIP(client); ip == nil || !ip.IsPrivate() {
+			target = "outside the LAN, as in UPnProxy"
+		}
+		meta.RaiseAlert("high", "upnp_port_mapping", fmt.Sprintf("UPnP port mapping of %s port %s to %s:%s (%s, %q) on port %s from %s",
+			args["NewProtocol"], args["NewExternalPort"], client, args["NewInternalPort"], target, args["NewPortMappingDescription"], meta.Port, meta.ClientAddr))
+		if action == "AddAnyPortMapping" {
+			result = "<NewReservedPort>" + html.EscapeString(args["NewExternalPort"]) + "</NewReservedPort>"
+		}
+	case "DeletePortMapping", "DeletePortMappingRange":
+	case "GetExternalIPAddress":
+		result = "<NewExternalIPAddress>" + upnpAddress(device, meta) + "</NewExternalIPAddress>"
+	case "GetStatusInfo":
+		result = "<NewConnectionStatus>Connected</NewConnectionStatus><NewLastConnectionError>ERROR_NONE</NewLastConnectionError><NewUptime>" +
+			strconv.Itoa(86400+meta.Rand().Intn(2000000)) + "</NewUptime>"
+	case "GetConnectionTypeInfo":
+		result = "<NewConnectionType>IP_Routed</NewConnectionType><NewPossibleConnectionTypes>IP_Routed</NewPossibleConnectionTypes>"
+	case "GetNATRSIPStatus":
+		result = "<NewRSIPAvailable>0</NewRSIPAvailable><NewNATEnabled>1</NewNATEnabled>"
+	case "GetGenericPortMappingEntry":
+		return upnpFault(713, "SpecifiedArrayIndexInvalid")
+	case "GetSpecificPortMappingEntry":
+		return upnpFault(714, "NoSuchEntryInArray")
+	case "GetCommonLinkProperties":
+		result = "<NewWANAccessType>Cable</NewWANAccessType><NewLayer1UpstreamMaxBitRate>100000000</NewLayer1UpstreamMaxBitRate>" +
+			"<NewLayer1DownstreamMaxBitRate>500000000</NewLayer1DownstreamMaxBitRate><NewPhysicalLinkStatus>Up</NewPhysicalLinkStatus>"
+	default:
+		return upnpFault(401, "Invalid Action")
+	}
+	service := upnpIPConn
+	if action == "GetCommonLinkProperties" {
+		service = upnpCommonIface
+	}
+	return http.StatusOK, fmt.Sprintf(`<?xml version="1.0"?>`+"\r\n"+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`+
+		`<s:Body><u:%sResponse xmlns:u="%s">%s</u:%sResponse></s:Body></s:Envelope>`+"\r\n", action, service, result, action)
+}
+
+// upnpFault returns a UPnP error reply.
+func upnpFault(code int, description string) (int, string) {
+	return http.StatusInternalServerError, fmt.Sprintf(`<?xml version="1.0"?>`+"\r\n"+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`+
+		`<s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`+
+		`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError>`+
+		`</detail></s:Fault></s:Body></s:Envelope>`+"\r\n", code, description)
+}
+
+// upnpDescription returns the device description of the gateway at address.
+func upnpDescription(d UPnPDevice, address string) string {
+	e := html.EscapeString
+	return `<?xml version="1.0"?>` + "\r\n" +
+		`<root xmlns="urn:schemas-upnp-org:device-1-0" configId="1337"><specVersion><major>1</major><minor>1</minor></specVersion>` +
+		`<device><deviceType>` + upnpGateway + `</deviceType><friendlyName>` + e(d.FriendlyName) + `</friendlyName>` +
+		`<manufacturer>` + e(d.Manufacturer) + `</manufacturer><manufacturerURL>` + e(d.ManufacturerURL) + `</manufacturerURL>` +
+		`<modelDescription>` + e(d.ModelDescription) + `</modelDescription><modelName>` + e(d.ModelName) + `</modelName>` +
+		`<modelNumber>` + e(d.ModelNumber) + `</modelNumber><modelURL>` + e(d.ManufacturerURL) + `</modelURL>` +
+		`<serialNumber>` + e(d.SerialNumber) + `</serialNumber><UDN>uuid:` + e(d.UUID) + `</UDN>` +
+		`<serviceList><service><serviceType>urn:schemas-upnp-org:service:Layer3Forwarding:1</serviceType><serviceId>urn:upnp-org:serviceId:L3Forwarding1</serviceId>` +
+		`<SCPDURL>/L3F.xml</SCPDURL><controlURL>/ctl/L3F</controlURL><eventSubURL>/evt/L3F</eventSubURL></service></serviceList>` +
+		`<deviceList><device><deviceType>` + upnpWANDevice + `</deviceType><friendlyName>WANDevice</friendlyName>` +
+		`<manufacturer>MiniUPnP</manufacturer><manufacturerURL>http://miniupnp.free.fr/</manufacturerURL><modelDescription>WAN Device</modelDescription>` +
+		`<modelName>WAN Device</modelName><modelNumber>` + e(d.ModelNumber) + `</modelNumber><serialNumber>` + e(d.SerialNumber) + `</serialNumber>` +
+		`<UDN>uuid:` + e(d.UUID) + `</UDN><UPC>000000000000</UPC>` +
+		`<serviceList><service><serviceType>` + upnpCommonIface + `</serviceType><serviceId>urn:upnp-org:serviceId:WANCommonIFC1</serviceId>` +
+		`<SCPDURL>/WANCfg.xml</SCPDURL><controlURL>/ctl/CmnIfCfg</controlURL><eventSubURL>/evt/CmnIfCfg</eventSubURL></service></serviceList>` +
+		`<deviceList><device><deviceType>` + upnpWANConnDev + `</deviceType><friendlyName>WANConnectionDevice</friendlyName>` +
+		`<manufacturer>MiniUPnP</manufacturer><manufacturerURL>http://miniupnp.free.fr/</manufacturerURL><modelDescription>MiniUPnP daemon</modelDescription>` +
+		`<modelName>MiniUPnPd</modelName><modelNumber>` + e(d.ModelNumber) + `</modelNumber><serialNumber>` + e(d.SerialNumber) + `</serialNumber>` +
+		`<UDN>uuid:` + e(d.UUID) + `</UDN><UPC>000000000000</UPC>` +
+		`<serviceList><service><serviceType>` + upnpIPConn + `</serviceType><serviceId>urn:upnp-org:serviceId:WANIPConn1</serviceId>` +
+		`<SCPDURL>/WANIPCn.xml</SCPDURL><controlURL>/ctl/IPConn</controlURL><eventSubURL>/evt/IPConn</eventSubURL></service></serviceList>` +
+		`</device></deviceList></device></deviceList>` +
+		`<presentationURL>http://` + e(address) + `/</presentationURL></device></root>` + "\r\n"
+}
+
+// upnpSCPD is the service description listing the actions answered.
+var upnpSCPD = func() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?>` + "\r\n" + `<scpd xmlns="urn:schemas-upnp-org:service-1-0"><specVersion><major>1</major><minor>0</minor></specVersion><actionList>`)
+	for _, action := range []string{
+		"SetConnectionType", "GetConnectionTypeInfo", "RequestConnection", "ForceTermination", "GetStatusInfo", "GetNATRSIPStatus",
+		"GetGenericPortMappingEntry", "GetSpecificPortMappingEntry", "AddPortMapping", "DeletePortMapping", "GetExternalIPAddress",
+		"GetCommonLinkProperties",
+	} {
+		b.WriteString("<action><name>" + action + "</name></action>")
+	}
+	b.WriteString("</actionList></scpd>\r\n")
+	return b.String()
+}()
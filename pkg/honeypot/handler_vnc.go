@@ -0,0 +1,192 @@
+package honeypot
+
+import (
+	"bytes"
+	"context"
+	"crypto/des"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/bits"
+	"net"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterHandler("vnc", HandlerFunc(serveVNC))
+}
+
+// RFB security types (RFC 6143 7.1.2 and the IANA registry).
+const (
+	vncSecurityInvalid = 0
+	vncSecurityNone    = 1
+	vncSecurityVNC     = 2
+)
+
+var vncSecurityNames = map[byte]string{
+	vncSecurityInvalid: "invalid", vncSecurityNone: "none", vncSecurityVNC: "vnc", 5: "ra2", 6: "ra2ne",
+	16: "tight", 17: "ultra", 18: "tls", 19: "vencrypt", 20: "sasl", 21: "md5", 22: "xvp", 30: "apple-ard",
+}
+
+// vncPasswords are tried against every VNC authentication response. VNC
+// passwords are at most 8 characters, so brute-forcers' lists are short and
+// full of defaults.
+var vncPasswords = []string{
+	"password", "123456", "12345678", "1234", "12345", "123", "1", "111111", "11111111", "00000000",
+	"admin", "administ", "root", "vnc", "vncpass", "vnc123", "secret", "test", "guest", "qwerty",
+	"abc123", "letmein", "raspberr", "pass", "passw0rd", "P@ssw0rd", "changeme", "default", "welcome", "system",
+	"user", "support", "1q2w3e4r", "88888888", "654321", "666666", "asdf", "access", "master", "server",
+}
+
+// serveVNC impersonates a VNC server up to its password check: it sends an
+// RFB 3.8 banner, follows the client down to 3.7 or 3.3, offers VNC
+// authentication only and fails every response to its challenge. The
+// challenge and DES response are logged in John the Ripper's format for
+// offline cracking, and checked against common VNC passwords so that the
+// password tried is logged as a credential when it is one of them.
+func serveVNC(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	if _, err := conn.Write([]byte("RFB 003.008\n")); err != nil {
+		return fmt.Errorf("writing to connection: %w", err)
+	}
+	conn.SetReadDeadline(meta.NextReadDeadline(false))
+	version := make([]byte, 12)
+	if n, err := io.ReadFull(conn, version); err != nil {
+		return binaryReadError(ctx, meta, version[:n], err, false)
+	}
+	minor, ok := parseRFBVersion(version)
+	if !ok {
+		rest := make([]byte, meta.Limits.ReadBuffer)
+		n, _ := conn.Read(rest)
+		meta.LogData(string(append(version, rest[:n]...)))
+		return nil
+	}
+	clientVersion := strings.TrimSpace(string(version[4:]))
+	meta.Emit(Event{
+		Type:    EventData,
+		Message: fmt.Sprintf("VNC handshake on port %s from %s: client version %s", meta.Port, meta.ClientAddr, clientVersion),
+		Fields:  map[string]any{"data": string(version), "vnc_client_version": clientVersion},
+	})
+
+	// RFB 3.3 servers impose the security type, later ones let the client pick
+	selected := byte(vncSecurityVNC)
+	if minor < 7 {
+		if err := vncWrite(conn, binary.BigEndian.AppendUint32(nil, vncSecurityVNC)); err != nil {
+			return err
+		}
+	} else {
+		if err := vncWrite(conn, []byte{1, vncSecurityVNC}); err != nil {
+			return err
+		}
+		conn.SetReadDeadline(meta.NextReadDeadline(true))
+		choice := make([]byte, 1)
+		if _, err := io.ReadFull(conn, choice); err != nil {
+			return binaryReadError(ctx, meta, nil, err, true)
+		}
+		selected = choice[0]
+	}
+	name, ok := vncSecurityNames[selected]
+	if !ok {
+		name = strconv.Itoa(int(selected))
+	}
+	meta.Emit(Event{
+		Type:    EventData,
+		Message: fmt.Sprintf("VNC security type %s selected on port %s from %s", name, meta.Port, meta.ClientAddr),
+		Fields:  map[string]any{"vnc_offered_types": []string{"vnc"}, "vnc_selected_type": name},
+	})
+	if selected != vncSecurityVNC {
+		return vncFail(conn, minor, "Security type not offered")
+	}
+
+	challenge := make([]byte, 16)
+	meta.Rand().Read(challenge)
+	if err := vncWrite(conn, challenge); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(meta.NextReadDeadline(true))
+	response := make([]byte, 16)
+	if n, err := io.ReadFull(conn, response); err != nil {
+		return binaryReadError(ctx, meta, response[:n], err, true)
+	}
+	hash := fmt.Sprintf("$vnc$*%X*%X", challenge, response)
+	fields := map[string]any{"vnc_challenge": hex.EncodeToString(challenge), "vnc_response": hex.EncodeToString(response), "vnc_hash": hash}
+	message := fmt.Sprintf("VNC authentication on port %s from %s: %s", meta.Port, meta.ClientAddr, hash)
+	password, cracked := crackVNCResponse(challenge, response)
+	if cracked {
+		fields["vnc_password"] = password
+		message += fmt.Sprintf(", password %q", password)
+	}
+	meta.Emit(Event{Type: EventData, Message: message, Fields: fields})
+	if cracked {
+		meta.LogCredential(Credential{Password: password})
+	} else if meta.Stage < StageBruteForce {
+		meta.Stage = StageBruteForce
+	}
+	return vncFail(conn, minor, "Authentication failed")
+}
+
+// parseRFBVersion parses a ProtocolVersion message, "RFB 003.008\n", and
+// returns the minor version the server speaks with the client: 3, 7 or 8.
+// Versions above 3.8, such as Apple's 3.889 or RealVNC's 4.x and 5.x, are
+// answered as 3.8.
+func parseRFBVersion(version []byte) (int, bool) {
+	if !bytes.HasPrefix(version, []byte("RFB ")) || version[7] != '.' || version[11] != '\n' {
+		return 0, false
+	}
+	major, err1 := strconv.Atoi(string(version[4:7]))
+	minor, err2 := strconv.Atoi(string(version[8:11]))
+	switch {
+	case err1 != nil || err2 != nil:
+		return 0, false
+	case major > 3 || minor >= 8:
+		return 8, true
+	case minor == 7:
+		return 7, true
+	}
+	return 3, true
+}
+
+// crackVNCResponse returns the password among vncPasswords that encrypts
+// challenge into response.
+func crackVNCResponse(challenge, response []byte) (string, bool) {
+	for _, password := range vncPasswords {
+		if bytes.Equal(vncEncrypt(password, challenge), response) {
+			return password, true
+		}
+	}
+	return "", false
+}
+
+// vncEncrypt encrypts a challenge like VNC clients: with DES keyed by the
+// first 8 bytes of the password, each with its bits in reverse order.
+func vncEncrypt(password string, challenge []byte) []byte {
+	key := make([]byte, 8)
+	copy(key, password)
+	for i, b := range key {
+		key[i] = bits.Reverse8(b)
+	}
+	cipher, _ := des.NewCipher(key)
+	out := make([]byte, len(challenge))
+	for i := 0; i+8 <= len(challenge); i += 8 {
+		cipher.Encrypt(out[i:], challenge[i:])
+	}
+	return out
+}
+
+// vncFail sends a failed SecurityResult, with a reason from RFB 3.8 on.
+func vncFail(conn net.Conn, minor int, reason string) error {
+	result := binary.BigEndian.AppendUint32(nil, 1)
+	if minor >= 8 {
+		result = binary.BigEndian.AppendUint32(result, uint32(len(reason)))
+		result = append(result, reason...)
+	}
+	return vncWrite(conn, result)
+}
+
+func vncWrite(conn net.Conn, msg []byte) error {
+	if _, err := conn.Write(msg); err != nil {
+		return fmt.Errorf("writing to connection: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package honeypot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"strings"
+)
+
+func init() {
+	RegisterHandler("weblogic", HandlerFunc(serveWebLogic))
+}
+
+// webLogicVersion is the impersonated Oracle WebLogic Server release, unless
+// the host identity lists another one.
+const webLogicVersion = "12.2.1.3.0"
+
+// serveWebLogic impersonates an Oracle WebLogic Server admin server, which
+// listens for HTTP and its T3 RMI protocol on the same port, 7001. It serves
+// the console login, whose credentials are logged, and the WLS-WSAT and
+// async SOAP services. XMLDecoder payloads posted to the services
+// (CVE-2017-10271, CVE-2019-2725) raise a weblogic_xmldecoder alert and
+// console paths bypassing authentication (CVE-2020-14882) raise
+// weblogic_console_bypass. T3 clients get the server's HELO, and the
+// serialized objects they send then raise java_deserialization.
+func serveWebLogic(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	version := webLogicVersion
+	if v := meta.Identity.Software["weblogic"]; v != "" {
+		version = v
+	}
+	reader := bufio.NewReaderSize(conn, meta.Limits.ReadBuffer)
+	conn.SetReadDeadline(meta.NextReadDeadline(false))
+	if _, err := reader.Peek(1); err != nil {
+		return binaryReadError(ctx, meta, nil, err, false)
+	}
+	if start, _ := reader.Peek(reader.Buffered()); bytes.HasPrefix(start, []byte("t3 ")) || bytes.HasPrefix(start, []byte("t3s ")) {
+		return serveT3(ctx, conn, reader, meta, version)
+	}
+	return serveHTTPRequests(ctx, conn, reader, meta, "WebLogic", func(req *http.Request, body []byte) httpReply {
+		return webLogicReply(meta, version, req, body)
+	})
+}
+
+// serveT3 answers the T3 handshake, "t3 VERSION\nAS:...\nHL:...\n\n", and
+// reads what the client sends after it: the JVM messages carrying
+// serialized objects of deserialization exploits.
+func serveT3(ctx context.Context, conn net.Conn, reader *bufio.Reader, meta *ConnMeta, version string) error {
+	var hello strings.Builder
+	for hello.Len() < 1024 {
+		line, err := reader.ReadString('\n')
+		hello.WriteString(line)
+		if err != nil {
+			return binaryReadError(ctx, meta, []byte(hello.String()), err, true)
+		}
+		if line == "\n" {
+			break
+		}
+	}
+	meta.Emit(Event{Type: EventData, Message: fmt.Sprintf("WebLogic T3 handshake on port %s from %s: %q", meta.Port, meta.ClientAddr, hello.String()),
+		Fields: map[string]any{"data": hello.String()}})
+	if _, err := fmt.Fprintf(conn, "HELO:%s.false\nAS:2048\nHL:19\nMS:10000000\n\n", version); err != nil {
+		return fmt.Errorf("writing to connection: %w", err)
+	}
+
+	var raw []byte
+	buf := make([]byte, meta.Limits.ReadBuffer)
+	for len(raw) < meta.Limits.MaxBytes {
+		conn.SetReadDeadline(meta.NextReadDeadline(len(raw) > 0))
+		n, err := reader.Read(buf)
+		raw = append(raw, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	if javaSerialized(raw) {
+		if meta.Stage < StageExploit {
+			meta.Stage = StageExploit
+		}
+		meta.RaiseAlert("high", "java_deserialization", fmt.Sprintf("serialized Java object sent over WebLogic T3 on port %s from %s (%d bytes)", meta.Port, meta.ClientAddr, len(raw)))
+	}
+	if len(raw) > 0 {
+		meta.LogData(string(raw))
+	}
+	return nil
+}
+
+func webLogicReply(meta *ConnMeta, version string, req *http.Request, body []byte) httpReply {
+	headers := [][2]string{httpDate(), {"X-Powered-By", "Servlet/3.1 JSP/2.3"}}
+	uri := strings.ToLower(req.URL.RequestURI())
+	urlPath := req.URL.Path
+	if strings.Contains(uri, "console.portal") && (strings.Contains(uri, "%252e%252e") || strings.Contains(uri, "..%2f") || strings.Contains(uri, "%2e%2e")) {
+		if meta.Stage < StageExploit {
+			meta.Stage = StageExploit
+		}
+		message := fmt.Sprintf("console authentication bypass %s on port %s from %s", req.URL.RequestURI(), meta.Port, meta.ClientAddr)
+		// CVE-2020-14883 runs code through the handle parameter
+		if handle := req.URL.Query().Get("handle"); handle != "" {
+			message += ", handle " + handle
+		}
+		meta.RaiseAlert("high", "weblogic_console_bypass", message)
+		return webLogicPage(version, "Home Page - base_domain - WLS Console", `<div id="content"><h1>Home Page</h1><p>Information and Resources</p></div>`, headers...)
+	}
+	switch {
+	case strings.HasPrefix(urlPath, "/wls-wsat/") || strings.HasPrefix(urlPath, "/_async/"):
+		lower := strings.ToLower(string(body))
+		if req.Method == http.MethodPost && (strings.Contains(lower, "java.beans.xmldecoder") || strings.Contains(lower, "<work:workcontext") || strings.Contains(lower, "<java")) {
+			if meta.Stage < StageExploit {
+				meta.Stage = StageExploit
+			}
+			meta.RaiseAlert("high", "weblogic_xmldecoder", fmt.Sprintf("XMLDecoder payload posted to %s on port %s from %s (%d bytes)", urlPath, meta.Port, meta.ClientAddr, len(body)))
+		}
+		if req.Method == http.MethodPost {
+			if strings.HasPrefix(urlPath, "/_async/") {
+				return httpReply{Status: http.StatusAccepted, Headers: headers}
+			}
+			return httpReply{Status: http.StatusInternalServerError, Headers: append(headers, [2]string{"Content-Type", "text/xml; charset=utf-8"}),
+				Body: []byte(`<?xml version='1.0' encoding='UTF-8'?><S:Envelope xmlns:S="http://schemas.xmlsoap.org/soap/envelope/"><S:Body><S:Fault xmlns:ns4="http://www.w3.org/2003/05/soap-envelope"><faultcode>S:Server</faultcode><faultstring>0</faultstring></S:Fault></S:Body></S:Envelope>`)}
+		}
+		service := urlPath[strings.LastIndex(urlPath, "/")+1:]
+		return webLogicPage(version, "Web Services", `<h2>Web Services</h2><table border="1"><tr><th>Endpoint</th><th>Information</th></tr><tr><td><b>Service Name:</b> `+html.EscapeString(service)+`<br><b>Port Name:</b> `+html.EscapeString(service)+`Port</td><td><b>Address:</b> http://`+html.EscapeString(req.Host+urlPath)+`<br><b>WSDL:</b> <a href="`+html.EscapeString(urlPath)+`?wsdl">`+html.EscapeString(urlPath)+`?wsdl</a></td></tr></table>`, headers...)
+	case urlPath == "/console" || urlPath == "/console/":
+		return httpRedirect("/console/login/LoginForm.jsp", headers...)
+	case urlPath == "/console/j_security_check":
+		form := httpForm(body)
+		meta.LogCredential(Credential{Username: form.Get("j_username"), Password: form.Get("j_password")})
+		return webLogicLogin(meta, version, "Authentication Denied", headers)
+	case strings.HasPrefix(urlPath, "/console/login/"):
+		return webLogicLogin(meta, version, "", headers)
+	}
+	return httpReply{Status: http.StatusNotFound, Headers: append(headers, [2]string{"Content-Type", "text/html; charset=UTF-8"}),
+		Body: []byte(`<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.0 Draft//EN">
+<HTML>
+<HEAD>
+<TITLE>Error 404--Not Found</TITLE>
+</HEAD>
+<BODY bgcolor="white">
+<FONT FACE=Helvetica><BR CLEAR=all>
+<TABLE border=0 cellspacing=5><TR><TD><BR CLEAR=all>
+<FONT FACE="Helvetica" COLOR="black" SIZE="3"><H2>Error 404--Not Found</H2>
+</FONT></TD></TR>
+</TABLE>
+<TABLE border=0 width=100% cellpadding=10><TR><TD VALIGN=top WIDTH=100% BGCOLOR=white><FONT FACE="Courier New"><FONT FACE="Helvetica" SIZE="3"><H3>From RFC 2068 <i>Hypertext Transfer Protocol -- HTTP/1.1</i>:</H3>
+</FONT><FONT FACE="Helvetica" SIZE="3"><H4>10.4.5 404 Not Found</H4>
+</FONT><P><FONT FACE="Courier New">The server has not found anything matching the Request-URI. No indication is given of whether the condition is temporary or permanent.</p><p>If the server does not wish to make this information available to the client, the status code 403 (Forbidden) can be used instead. The 410 (Gone) status code SHOULD be used if the server knows, through some internally configurable mechanism, that an old resource is permanently unavailable and has no forwarding address.</FONT></P>
+</FONT></TD></TR>
+</TABLE>
+
+</FONT>
+</BODY>
+</HTML>
+`)}
+}
+
+func webLogicLogin(meta *ConnMeta, version, failure string, headers [][2]string) httpReply {
+	content := `<div id="login_form"><form id="loginData" method="post" action="/console/j_security_check"><div class="message">Log in to work with the WebLogic Server domain</div>`
+	if failure != "" {
+		content += `<div class="loginFailed">` + failure + `</div>`
+	}
+	content += `<label for="j_username">Username:</label><input type="text" id="j_username" name="j_username" class="textinput" autocomplete="off">
+<label for="j_password">Password:</label><input type="password" id="j_password" name="j_password" class="textinput" autocomplete="off">
+<input type="hidden" name="j_character_encoding" value="UTF-8"><input type="submit" class="formButton" value="Login"></form></div>`
+	return webLogicPage(version, "Oracle WebLogic Server Administration Console 12c", content,
+		append(headers, [2]string{"Set-Cookie", "ADMINCONSOLESESSION=" + meta.randomID() + meta.randomID() + "!" + fmt.Sprint(len(version)*97531) + "; path=/console/; HttpOnly"})...)
+}
+
+func webLogicPage(version, title, content string, headers ...[2]string) httpReply {
+	body := `<!DOCTYPE html><html><head><meta http-equiv="Content-Type" content="text/html; charset=UTF-8"><title>` + html.EscapeString(title) + `</title>
+<link href="/console/framework/skins/wlsconsole/css/general.css" rel="stylesheet" type="text/css"></head>
+<body class="loginPage"><div id="top"><div id="login_header"><img src="/console/framework/skins/wlsconsole/images/Login_GC_LoginPage_Bg.gif" alt="Oracle WebLogic Server Administration Console 12c"></div></div>
+<div id="content">` + content + `</div>
+<div id="footer"><p id="footerVersion">WebLogic Server Version: ` + html.EscapeString(version) + `</p><p id="copyright">Copyright &copy; 1996,2017, Oracle and/or its affiliates. All rights reserved.</p></div></body></html>`
+	return httpReply{Status: http.StatusOK, Headers: append(headers, [2]string{"Content-Type", "text/html; charset=UTF-8"}), Body: []byte(body)}
+}
@@ -0,0 +1,314 @@
+package honeypot
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+func init() {
+	RegisterHandler("wordpress", HandlerFunc(serveWordPress))
+}
+
+// wordpressVersion is the impersonated WordPress release, unless the host
+// identity lists another one.
+const wordpressVersion = "6.4.3"
+
+// wordpressPlugins are the installed plugins, at releases with well-known
+// unauthenticated upload vulnerabilities scanners check readme.txt for.
+var wordpressPlugins = map[string]struct{ name, version string }{
+	"wp-file-manager": {"File Manager", "6.8"},        // CVE-2020-25213, fixed in 6.9
+	"contact-form-7":  {"Contact Form 7", "5.3.1"},    // CVE-2020-35489, fixed in 5.3.2
+	"revslider":       {"Slider Revolution", "4.1.4"}, // arbitrary file download and upload
+}
+
+// wordpressSession is one connection to the wordpress handler.
+type wordpressSession struct {
+	meta    *ConnMeta
+	version string
+	title   string
+}
+
+// serveWordPress impersonates a WordPress blog. It serves the front page,
+// the login form and XML-RPC, whose credentials are logged, and lists the
+// site's users in the REST API and author archives, as user enumeration
+// expects before brute-forcing. XML-RPC system.multicall calls, which try
+// hundreds of passwords in one request, raise a wordpress_multicall alert
+// and pingbacks wordpress_pingback. The readme.txt of plugin releases with
+// upload vulnerabilities is served, PHP files uploaded anywhere raise
+// php_upload and requests to PHP files under the upload directories raise
+// webshell_access, answered with an empty page.
+func serveWordPress(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	s := &wordpressSession{meta: meta, version: wordpressVersion}
+	if v := meta.Identity.Software["wordpress"]; v != "" {
+		s.version = v
+	}
+	domain, _, _ := strings.Cut(meta.Identity.Domain, ".")
+	s.title = "News"
+	if domain != "" {
+		s.title = strings.ToUpper(domain[:1]) + domain[1:] + " News"
+	}
+	return serveHTTPPersona(ctx, conn, meta, "WordPress", s.handle)
+}
+
+func (s *wordpressSession) handle(req *http.Request, body []byte) httpReply {
+	urlPath := path.Clean("/" + req.URL.Path)
+	if uploads := phpUploads(req, body); len(uploads) > 0 {
+		s.exploit("php_upload", fmt.Sprintf("PHP file %s uploaded to %s", strings.Join(uploads, ", "), req.URL.Path))
+		if strings.HasPrefix(urlPath, "/wp-content/plugins/wp-file-manager/") {
+			return s.json(http.StatusOK, fmt.Sprintf(`{"added":[{"mime":"text/x-php","ts":1707312000,"read":1,"write":1,"size":%d,"hash":"l1_%s","name":%q,"phash":"l1_Lw","url":"/wp-content/plugins/wp-file-manager/lib/files/%[3]s"}],"removed":[]}`,
+				len(body), s.meta.randomID(), uploads[0]))
+		}
+	}
+	switch {
+	case urlPath == "/" && req.URL.Query().Get("author") != "":
+		return httpRedirect("/author/"+s.user()+"/", phpHeaders(s.meta.Identity)...)
+	case urlPath == "/":
+		return s.page(http.StatusOK, s.title, `<main class="wp-block-group"><h2 class="wp-block-post-title"><a href="/2024/02/07/welcome/">Welcome to our new site</a></h2>
+<div class="entry-content"><p>We moved the team pages and project updates here. Reach out to IT if your account does not work yet.</p></div></main>`)
+	case urlPath == "/wp-login.php":
+		return s.login(req, body)
+	case urlPath == "/xmlrpc.php":
+		if req.Method != http.MethodPost {
+			return httpReply{Status: http.StatusMethodNotAllowed, Headers: append(phpHeaders(s.meta.Identity), [2]string{"Allow", "POST"}, [2]string{"Content-Type", "text/plain;charset=UTF-8"}),
+				Body: []byte("XML-RPC server accepts POST requests only.")}
+		}
+		return s.xmlrpc(body)
+	case urlPath == "/wp-json/wp/v2/users" || urlPath == "/wp-json" && req.URL.Query().Get("rest_route") == "/wp/v2/users":
+		user := s.user()
+		users, _ := json.Marshal([]map[string]any{{
+			"id": 1, "name": user, "url": "", "description": "", "link": "/author/" + user + "/", "slug": user,
+			"avatar_urls": map[string]string{"24": "https://secure.gravatar.com/avatar/" + s.meta.randomID() + "?s=24&d=mm&r=g"},
+		}})
+		return s.json(http.StatusOK, string(users))
+	case urlPath == "/author/"+s.user():
+		return s.page(http.StatusOK, s.user()+" – "+s.title, `<main class="wp-block-group"><h1 class="wp-block-query-title">Author: <span>`+html.EscapeString(s.user())+`</span></h1></main>`)
+	case urlPath == "/wp-admin" || strings.HasPrefix(urlPath, "/wp-admin/") && urlPath != "/wp-admin/admin-ajax.php":
+		return httpRedirect("/wp-login.php?redirect_to="+strings.ReplaceAll(req.URL.Path, "/", "%2F")+"&reauth=1", phpHeaders(s.meta.Identity)...)
+	case urlPath == "/wp-admin/admin-ajax.php":
+		return httpReply{Status: http.StatusBadRequest, Headers: append(phpHeaders(s.meta.Identity), [2]string{"Content-Type", "text/html; charset=UTF-8"}), Body: []byte("0")}
+	case urlPath == "/readme.html":
+		return httpPage(http.StatusOK, `<!DOCTYPE html><html lang="en"><head><meta charset="utf-8" /><title>WordPress &#8250; ReadMe</title></head><body><h1 id="logo"><a href="https://wordpress.org/"><img alt="WordPress" src="wp-admin/images/wordpress-logo.png" /></a></h1>
+<p style="text-align: center">Semantic Personal Publishing Platform</p><h2>First Things First</h2><p>Welcome. WordPress is a very special project to me.</p></body></html>`, phpHeaders(s.meta.Identity)...)
+	case strings.HasPrefix(urlPath, "/wp-content/uploads/") && isPHPFile(urlPath),
+		strings.HasPrefix(urlPath, "/wp-content/plugins/wp-file-manager/lib/files/") && isPHPFile(urlPath):
+		s.exploit("webshell_access", "request to uploaded PHP file "+req.URL.Path)
+		return httpPage(http.StatusOK, "", phpHeaders(s.meta.Identity)...)
+	case strings.HasPrefix(urlPath, "/wp-content/plugins/"):
+		slug, file, _ := strings.Cut(strings.TrimPrefix(urlPath, "/wp-content/plugins/"), "/")
+		if plugin, ok := wordpressPlugins[slug]; ok {
+			switch {
+			case file == "readme.txt":
+				return httpReply{Status: http.StatusOK, Headers: append(phpHeaders(s.meta.Identity)[:2], [2]string{"Content-Type", "text/plain"}),
+					Body: []byte(fmt.Sprintf("=== %s ===\nContributors: %s\nRequires at least: 4.0\nTested up to: 6.4\nStable tag: %s\nLicense: GPLv2\n\n== Changelog ==\n\n= %[3]s =\n* Minor fixes\n", plugin.name, slug, plugin.version))}
+			case isPHPFile(file):
+				return httpPage(http.StatusOK, "", phpHeaders(s.meta.Identity)...)
+			}
+		}
+	}
+	return s.page(http.StatusNotFound, "Page not found – "+s.title, `<main class="wp-block-group"><h1 class="wp-block-heading">Page Not Found</h1><p>The page you are looking for does not exist, or it has been moved.</p></main>`)
+}
+
+// user returns the login of the site's author.
+func (s *wordpressSession) user() string {
+	return strings.ToLower(s.meta.Identity.User)
+}
+
+// exploit raises a high alert of kind and marks the session as exploiting.
+func (s *wordpressSession) exploit(kind, what string) {
+	if s.meta.Stage < StageExploit {
+		s.meta.Stage = StageExploit
+	}
+	s.meta.RaiseAlert("high", kind, fmt.Sprintf("%s on port %s from %s", what, s.meta.Port, s.meta.ClientAddr))
+}
+
+func (s *wordpressSession) page(status int, title, content string) httpReply {
+	body := fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en-US">
+<head>
+	<meta charset="UTF-8" />
+	<meta name="viewport" content="width=device-width, initial-scale=1" />
+<meta name='robots' content='max-image-preview:large' />
+<title>%s</title>
+<link rel='stylesheet' id='wp-block-library-css' href='/wp-includes/css/dist/block-library/style.min.css?ver=%[2]s' media='all' />
+<link rel="https://api.w.org/" href="/wp-json/" /><link rel="EditURI" type="application/rsd+xml" title="RSD" href="/xmlrpc.php?rsd" />
+<meta name="generator" content="WordPress %[2]s" />
+</head>
+<body class="home blog wp-embed-responsive">
+<div class="wp-site-blocks"><header class="wp-block-template-part"><p class="wp-block-site-title"><a href="/" rel="home">%[3]s</a></p></header>
+%[4]s
+<footer class="wp-block-template-part"><p class="has-text-align-center">Proudly powered by <a href="https://wordpress.org" rel="nofollow">WordPress</a></p></footer></div>
+<script src="/wp-includes/js/wp-emoji-release.min.js?ver=%[2]s" id="wp-emoji-js"></script>
+</body>
+</html>
+`, html.EscapeString(title), s.version, html.EscapeString(s.title), content)
+	headers := append(phpHeaders(s.meta.Identity), [2]string{"Link", `</wp-json/>; rel="https://api.w.org/"`})
+	return httpPage(status, body, headers...)
+}
+
+func (s *wordpressSession) json(status int, body string) httpReply {
+	return httpReply{Status: status, Headers: append(phpHeaders(s.meta.Identity),
+		[2]string{"Content-Type", "application/json; charset=UTF-8"}, [2]string{"X-Robots-Tag", "noindex"}), Body: []byte(body)}
+}
+
+// login serves wp-login.php, logging the credentials posted to it. Every
+// attempt fails, with the error WordPress gives for a known or unknown user.
+func (s *wordpressSession) login(req *http.Request, body []byte) httpReply {
+	message := ""
+	if req.Method == http.MethodPost {
+		form := httpForm(body)
+		user := form.Get("log")
+		s.meta.LogCredential(Credential{Username: user, Password: form.Get("pwd")})
+		if strings.EqualFold(user, s.user()) || strings.EqualFold(user, "admin") {
+			message = `<strong>Error:</strong> The password you entered for the username <strong>` + html.EscapeString(user) + `</strong> is incorrect. <a href="/wp-login.php?action=lostpassword">Lost your password?</a>`
+		} else {
+			message = `<strong>Error:</strong> The username <strong>` + html.EscapeString(user) + `</strong> is not registered on this site. If you are unsure of your username, try your email address instead.`
+		}
+		message = `<div id="login_error" class="notice notice-error"><p>` + message + `</p></div>`
+	}
+	page := fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en-US">
+<head>
+	<meta http-equiv="Content-Type" content="text/html; charset=UTF-8" />
+	<title>Log In &lsaquo; %s &#8212; WordPress</title>
+	<meta name='robots' content='max-image-preview:large, noindex, noarchive' />
+<link rel='stylesheet' id='login-css' href='/wp-admin/css/login.min.css?ver=%s' media='all' />
+</head>
+<body class="login no-js login-action-login wp-core-ui locale-en-us">
+<div id="login">
+	<h1><a href="https://wordpress.org/">Powered by WordPress</a></h1>
+	%s
+	<form name="loginform" id="loginform" action="/wp-login.php" method="post">
+		<p><label for="user_login">Username or Email Address</label><input type="text" name="log" id="user_login" class="input" value="" size="20" autocapitalize="off" autocomplete="username" required="required" /></p>
+		<div class="user-pass-wrap"><label for="user_pass">Password</label><div class="wp-pwd"><input type="password" name="pwd" id="user_pass" class="input password-input" value="" size="20" autocomplete="current-password" spellcheck="false" required="required" /></div></div>
+		<p class="forgetmenot"><input name="rememberme" type="checkbox" id="rememberme" value="forever"  /> <label for="rememberme">Remember Me</label></p>
+		<p class="submit"><input type="submit" name="wp-submit" id="wp-submit" class="button button-primary button-large" value="Log In" /><input type="hidden" name="redirect_to" value="/wp-admin/" /><input type="hidden" name="testcookie" value="1" /></p>
+	</form>
+	<p id="nav"><a href="/wp-login.php?action=lostpassword">Lost your password?</a></p>
+</div>
+</body>
+</html>
+`, html.EscapeString(s.title), s.version, message)
+	return httpPage(http.StatusOK, page, append(phpHeaders(s.meta.Identity),
+		[2]string{"Expires", "Wed, 11 Jan 1984 05:00:00 GMT"},
+		[2]string{"Cache-Control", "no-cache, must-revalidate, max-age=0"},
+		[2]string{"Set-Cookie", "wordpress_test_cookie=WP%20Cookie%20check; path=/; HttpOnly"},
+		[2]string{"X-Frame-Options", "SAMEORIGIN"})...)
+}
+
+// xmlrpcCall is an XML-RPC request.
+type xmlrpcCall struct {
+	Method string        `xml:"methodName"`
+	Params []xmlrpcValue `xml:"params>param>value"`
+}
+
+// xmlrpcValue is an XML-RPC value: a scalar, an array or a struct.
+type xmlrpcValue struct {
+	Text   string        `xml:",chardata"`
+	String *string       `xml:"string"`
+	Array  []xmlrpcValue `xml:"array>data>value"`
+	Member []struct {
+		Name  string      `xml:"name"`
+		Value xmlrpcValue `xml:"value"`
+	} `xml:"struct>member"`
+}
+
+// scalar returns the text of a string or untyped value.
+func (v xmlrpcValue) scalar() string {
+	if v.String != nil {
+		return *v.String
+	}
+	return strings.TrimSpace(v.Text)
+}
+
+// member returns the member of a struct value called name.
+func (v xmlrpcValue) member(name string) xmlrpcValue {
+	for _, m := range v.Member {
+		if m.Name == name {
+			return m.Value
+		}
+	}
+	return xmlrpcValue{}
+}
+
+// xmlrpcLogin returns the credentials a call authenticates with, if its
+// method takes any: wp.getUsersBlogs(username, password), and the
+// other wp, metaWeblog, blogger and mt methods (blog, username, password, ...).
+func xmlrpcLogin(method string, params []xmlrpcValue) (Credential, bool) {
+	prefix, _, _ := strings.Cut(method, ".")
+	switch {
+	case method == "wp.getUsersBlogs" && len(params) >= 2:
+		return Credential{Username: params[0].scalar(), Password: params[1].scalar()}, true
+	case (prefix == "wp" || prefix == "metaWeblog" || prefix == "blogger" || prefix == "mt") && len(params) >= 3:
+		return Credential{Username: params[1].scalar(), Password: params[2].scalar()}, true
+	}
+	return Credential{}, false
+}
+
+const xmlrpcLoginFault = `<value><struct><member><name>faultCode</name><value><int>403</int></value></member><member><name>faultString</name><value><string>Incorrect username or password.</string></value></member></struct></value>`
+
+// xmlrpc answers an XML-RPC request. Every login fails.
+func (s *wordpressSession) xmlrpc(body []byte) httpReply {
+	respond := func(content string) httpReply {
+		return httpReply{Status: http.StatusOK, Headers: append(phpHeaders(s.meta.Identity), [2]string{"Connection", "close"}, [2]string{"Content-Type", "text/xml; charset=UTF-8"}),
+			Body: []byte(`<?xml version="1.0" encoding="UTF-8"?>` + "\n<methodResponse>\n" + content + "\n</methodResponse>\n")}
+	}
+	fault := func(code int, message string) httpReply {
+		return respond(fmt.Sprintf(`<fault><value><struct><member><name>faultCode</name><value><int>%d</int></value></member><member><name>faultString</name><value><string>%s</string></value></member></struct></value></fault>`,
+			code, html.EscapeString(message)))
+	}
+	var call xmlrpcCall
+	if err := xml.Unmarshal(body, &call); err != nil {
+		return fault(-32700, "parse error. not well formed")
+	}
+	if cred, ok := xmlrpcLogin(call.Method, call.Params); ok {
+		s.meta.LogCredential(cred)
+		return respond("<fault>" + xmlrpcLoginFault + "</fault>")
+	}
+	switch call.Method {
+	case "system.multicall":
+		if len(call.Params) == 0 {
+			return fault(-32602, "server error. invalid method parameters")
+		}
+		var results strings.Builder
+		logins := 0
+		for _, sub := range call.Params[0].Array {
+			if cred, ok := xmlrpcLogin(sub.member("methodName").scalar(), sub.member("params").Array); ok {
+				s.meta.LogCredential(cred)
+				logins++
+				results.WriteString(xmlrpcLoginFault)
+			} else {
+				results.WriteString(`<value><struct><member><name>faultCode</name><value><int>-32601</int></value></member><member><name>faultString</name><value><string>server error. requested method does not exist.</string></value></member></struct></value>`)
+			}
+		}
+		if logins > 0 {
+			s.meta.RaiseAlert("medium", "wordpress_multicall", fmt.Sprintf("%d XML-RPC logins in one system.multicall on port %s from %s", logins, s.meta.Port, s.meta.ClientAddr))
+		}
+		return respond("<params><param><value><array><data>" + results.String() + "</data></array></value></param></params>")
+	case "pingback.ping":
+		var source, target string
+		if len(call.Params) >= 2 {
+			source, target = call.Params[0].scalar(), call.Params[1].scalar()
+		}
+		s.meta.RaiseAlert("medium", "wordpress_pingback", fmt.Sprintf("pingback from %q to %q on port %s from %s", source, target, s.meta.Port, s.meta.ClientAddr))
+		return fault(0, "")
+	case "system.listMethods":
+		var methods strings.Builder
+		for _, m := range []string{"system.multicall", "system.listMethods", "system.getCapabilities", "demo.addTwoNumbers", "demo.sayHello",
+			"pingback.extensions.getPingbacks", "pingback.ping", "mt.publishPost", "mt.getTrackbackPings", "mt.supportedTextFilters",
+			"metaWeblog.newPost", "metaWeblog.getPost", "metaWeblog.getUsersBlogs", "blogger.getUsersBlogs", "wp.getUsersBlogs",
+			"wp.getProfile", "wp.getUsers", "wp.getPosts", "wp.newPost", "wp.uploadFile", "wp.getOptions"} {
+			methods.WriteString("<value><string>" + m + "</string></value>")
+		}
+		return respond("<params><param><value><array><data>" + methods.String() + "</data></array></value></param></params>")
+	case "demo.sayHello":
+		return respond("<params><param><value><string>Hello!</string></value></param></params>")
+	}
+	return fault(-32601, "server error. requested method "+call.Method+" does not exist.")
+}
@@ -0,0 +1,146 @@
+package honeypot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Thresholds of the pool saturation and output queue fill above which a
+// server is degraded.
+const (
+	healthMaxSaturation = 0.9
+	healthMaxBacklog    = 0.9
+)
+
+// Health states, see Health.Status.
+const (
+	HealthOK       = "ok"       // serving every port, nothing is backing up
+	HealthDegraded = "degraded" // serving, but see Health.Problems
+	HealthDown     = "down"     // not listening yet, or shutting down
+)
+
+// ListenerStatus describes a port ListenAndServe was asked to listen on.
+type ListenerStatus struct {
+	Port      string `json:"port"`
+	Transport string `json:"transport"`       // "tcp" or "udp"
+	Handler   string `json:"handler"`         // handler serving it
+	Listening bool   `json:"listening"`       // false when it could not be opened
+	Error     string `json:"error,omitempty"` // why it could not be opened
+	Limited   uint64 `json:"port_limited"`    // connections closed unserved because the port was at its limit
+}
+
+// Health describes the state of a server, see Server.Health.
+type Health struct {
+	Status     string                `json:"status"` // one of the Health* constants
+	Problems   []string              `json:"problems,omitempty"`
+	Uptime     time.Duration         `json:"uptime_ns"`
+	Listeners  []ListenerStatus      `json:"listeners"`   // in the order of ListenAndServe
+	Pool       PoolStats             `json:"pool"`        // see Server.Stats
+	Saturation float64               `json:"saturation"`  // connections queued or in service over what the pool can hold, from 0 to 1
+	Outputs    map[string]BatchStats `json:"outputs"`     // backlogs and deliveries of the batching outputs, see Server.OutputStats
+	LastEvents map[string]time.Time  `json:"last_events"` // time the last event of each type was emitted
+}
+
+// Health returns the state of s: its listeners, the saturation of its
+// connection pool, the backlogs of its outputs and the last event of each
+// type. s is degraded when a port could not be opened, the pool is more
+// than 90% saturated, or an output is offline or its queue more than 90%
+// full.
+func (s *Server) Health() Health {
+	h := Health{
+		Status:     HealthOK,
+		Uptime:     time.Since(s.started),
+		Pool:       s.Stats(),
+		Outputs:    s.OutputStats(),
+		LastEvents: make(map[string]time.Time),
+	}
+	s.connMu.Lock()
+	h.Listeners = append([]ListenerStatus{}, s.listenerStatus...)
+	s.connMu.Unlock()
+	s.lastEventsMu.Lock()
+	for eventType, t := range s.lastEvents {
+		h.LastEvents[eventType] = t
+	}
+	s.lastEventsMu.Unlock()
+
+	for i, l := range h.Listeners {
+		if l.Transport == "tcp" {
+			h.Listeners[i].Limited = h.Pool.Limited[l.Port]
+		}
+		if !l.Listening {
+			h.Problems = append(h.Problems, fmt.Sprintf("port %s/%s not listening: %s", l.Port, l.Transport, l.Error))
+		}
+	}
+	if capacity := h.Pool.Workers + h.Pool.QueueSize; capacity > 0 {
+		h.Saturation = float64(h.Pool.Busy+h.Pool.Queued) / float64(capacity)
+	}
+	if h.Saturation > healthMaxSaturation {
+		h.Problems = append(h.Problems, fmt.Sprintf("connection pool %.0f%% saturated", 100*h.Saturation))
+	}
+	names := make([]string, 0, len(h.Outputs))
+	for name := range h.Outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		st := h.Outputs[name]
+		if st.Offline {
+			h.Problems = append(h.Problems, fmt.Sprintf("output %s offline", name))
+		}
+		if st.QueueSize > 0 && float64(st.Queued) > healthMaxBacklog*float64(st.QueueSize) {
+			h.Problems = append(h.Problems, fmt.Sprintf("output %s backlog at %d of %d events", name, st.Queued, st.QueueSize))
+		}
+	}
+
+	switch {
+	case !s.Ready():
+		h.Status = HealthDown
+	case len(h.Problems) > 0:
+		h.Status = HealthDegraded
+	}
+	return h
+}
+
+// noteEvent records the time of the last event of each type for Health.
+func (s *Server) noteEvent(ev Event) {
+	s.lastEventsMu.Lock()
+	defer s.lastEventsMu.Unlock()
+	if s.lastEvents == nil {
+		s.lastEvents = make(map[string]time.Time)
+	}
+	s.lastEvents[ev.Type] = time.Now()
+}
+
+// serveHealthz answers with the Health of s, with 503 Service Unavailable
+// unless it is ok, for uptime monitors.
+func serveHealthz(s *Server, w http.ResponseWriter) {
+	h := s.Health()
+	status := http.StatusOK
+	if h.Status != HealthOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, h)
+}
+
+// serveReadyz answers with the Health of s, with 503 Service Unavailable
+// while it is down, so load balancers only send it clients while it
+// listens.
+func serveReadyz(s *Server, w http.ResponseWriter) {
+	h := s.Health()
+	status := http.StatusOK
+	if h.Status == HealthDown {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, h)
+}
+
+func writeHealth(w http.ResponseWriter, status int, h Health) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(h)
+}
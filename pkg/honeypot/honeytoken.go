@@ -0,0 +1,347 @@
+package honeypot
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HoneytokenKinds are the bait values a HoneytokenStore generates: AWS
+// access keys, URLs of the tracking listener and login credentials.
+var HoneytokenKinds = []string{"aws", "url", "credential"}
+
+// honeytokenReload is how often a HoneytokenStore checks its file for tokens
+// generated since it was read.
+const honeytokenReload = 10 * time.Second
+
+// HoneytokenConfig enables honeytokens, see Server.EnableHoneytokens.
+type HoneytokenConfig struct {
+	File     string `json:"file"`                // JSON file of the tokens generated, empty to disable honeytokens
+	TrackURL string `json:"track_url,omitempty"` // public address of the tracking listener URL tokens point to, e.g. https://cdn.example.com/assets
+	Listen   string `json:"listen,omitempty"`    // address the tracking listener serves on, e.g. ":8081", empty for none
+}
+
+// Honeytoken is a unique bait value: nobody has a reason to use it but
+// someone who found it where it was planted.
+type Honeytoken struct {
+	ID              string    `json:"id"`
+	Kind            string    `json:"kind"` // one of HoneytokenKinds
+	Note            string    `json:"note,omitempty"`
+	Created         time.Time `json:"created"`
+	AccessKeyID     string    `json:"access_key_id,omitempty"`     // aws
+	SecretAccessKey string    `json:"secret_access_key,omitempty"` // aws
+	URL             string    `json:"url,omitempty"`               // url, the tracking listener's with the ID
+	Username        string    `json:"username,omitempty"`          // credential
+	Password        string    `json:"password,omitempty"`          // credential
+}
+
+// values returns the values of t whose use gives it away: all unique
+// enough not to be typed by chance.
+func (t *Honeytoken) values() []string {
+	values := []string{t.ID}
+	for _, v := range []string{t.AccessKeyID, t.SecretAccessKey, t.Password} {
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// HoneytokenStore keeps the honeytokens generated for a deployment in a
+// JSON file, shared by the sensors checking for them and gopot honeytoken,
+// which adds to it.
+type HoneytokenStore struct {
+	path     string
+	trackURL string
+
+	mu      sync.Mutex
+	tokens  []*Honeytoken
+	modTime time.Time // of the file when it was read
+	checked time.Time // when the file was last checked for changes
+}
+
+// OpenHoneytokens reads the honeytokens of cfg.File, which need not exist yet.
+func OpenHoneytokens(cfg HoneytokenConfig) (*HoneytokenStore, error) {
+	if cfg.File == "" {
+		return nil, errors.New("honeytokens need a file")
+	}
+	st := &HoneytokenStore{path: cfg.File, trackURL: strings.TrimSuffix(cfg.TrackURL, "/")}
+	if err := st.load(); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// load reads the file of st. Callers must hold st.mu, or own st.
+func (st *HoneytokenStore) load() error {
+	st.checked = time.Now()
+	info, err := os.Stat(st.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(st.modTime) {
+		return nil
+	}
+	content, err := os.ReadFile(st.path)
+	if err != nil {
+		return err
+	}
+	var tokens []*Honeytoken
+	if err := json.Unmarshal(content, &tokens); err != nil {
+		return fmt.Errorf("honeytokens %s: %w", st.path, err)
+	}
+	st.tokens, st.modTime = tokens, info.ModTime()
+	return nil
+}
+
+// save writes the tokens of st to its file, readable by its owner only
+// since it holds the values to watch for. Callers must hold st.mu.
+func (st *HoneytokenStore) save() error {
+	content, err := json.MarshalIndent(st.tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := st.path + ".tmp"
+	if err := os.WriteFile(tmp, append(content, '\n'), 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, st.path); err != nil {
+		return err
+	}
+	if info, err := os.Stat(st.path); err == nil {
+		st.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// Tokens returns the honeytokens of st, oldest first.
+func (st *HoneytokenStore) Tokens() []Honeytoken {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	tokens := make([]Honeytoken, len(st.tokens))
+	for i, t := range st.tokens {
+		tokens[i] = *t
+	}
+	return tokens
+}
+
+// Generate creates a honeytoken of kind with random values, and username
+// for a credential if not empty, records where it will be planted in note
+// and saves it.
+func (st *HoneytokenStore) Generate(kind, note, username string) (*Honeytoken, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err := st.load(); err != nil {
+		return nil, err
+	}
+	return st.generate(kind, note, username)
+}
+
+// generate is Generate for callers holding st.mu.
+func (st *HoneytokenStore) generate(kind, note, username string) (*Honeytoken, error) {
+	id := make([]byte, 8)
+	rand.Read(id)
+	t := &Honeytoken{ID: hex.EncodeToString(id), Kind: kind, Note: note, Created: time.Now().UTC()}
+	switch kind {
+	case "aws":
+		t.AccessKeyID = "AKIA" + randomToken(16, "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567")
+		t.SecretAccessKey = randomToken(40, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/")
+	case "url":
+		if st.trackURL == "" {
+			return nil, errors.New("URL tokens need the track URL of the tracking listener")
+		}
+		t.URL = st.trackURL + "/" + t.ID
+	case "credential":
+		t.Username = username
+		if t.Username == "" {
+			t.Username = "svc_" + randomToken(6, "abcdefghijkmnpqrstuvwxyz23456789")
+		}
+		t.Password = randomToken(16, "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789!@#%")
+	default:
+		return nil, fmt.Errorf("unknown honeytoken kind %q, want %s", kind, strings.Join(HoneytokenKinds, ", "))
+	}
+	st.tokens = append(st.tokens, t)
+	if err := st.save(); err != nil {
+		st.tokens = st.tokens[:len(st.tokens)-1]
+		return nil, fmt.Errorf("saving honeytokens: %w", err)
+	}
+	return t, nil
+}
+
+// ensure returns the honeytoken of kind planted where note says, generating
+// it the first time.
+func (st *HoneytokenStore) ensure(kind, note, username string) (*Honeytoken, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, t := range st.tokens {
+		if t.Kind == kind && t.Note == note {
+			return t, nil
+		}
+	}
+	return st.generate(kind, note, username)
+}
+
+// match returns the honeytoken whose values text contains, or nil. Tokens
+// generated since the file was read are picked up every honeytokenReload.
+func (st *HoneytokenStore) match(text string) *Honeytoken {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if time.Since(st.checked) >= honeytokenReload {
+		st.load() // keep the tokens read so far on error
+	}
+	for _, t := range st.tokens {
+		for _, v := range t.values() {
+			if strings.Contains(text, v) {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+// randomToken returns n characters of alphabet picked by crypto/rand.
+func randomToken(n int, alphabet string) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return string(b)
+}
+
+// EnableHoneytokens watches for the honeytokens of st: a data or credential
+// event carrying one of their values raises a high honeytoken_triggered
+// alert, as does a request to HoneytokenTracker. The cloud keys and database
+// password of the decoy files of the server and its decoy hosts are replaced
+// by tokens of their own, and the notes of each host mention a tracked URL
+// if st has a track URL.
+func (s *Server) EnableHoneytokens(st *HoneytokenStore) error {
+	hosts := map[*HostIdentity]*DecoyFS{s.Identity: s.Files}
+	for _, host := range s.Decoys {
+		hosts[host.Identity] = host.Files
+	}
+	for identity, files := range hosts {
+		note := "decoy files of " + identity.FQDN()
+		aws, err := st.ensure("aws", note, "")
+		if err != nil {
+			return err
+		}
+		credential, err := st.ensure("credential", note, decoyDBUser)
+		if err != nil {
+			return err
+		}
+		var url *Honeytoken
+		if st.trackURL != "" {
+			if url, err = st.ensure("url", note, ""); err != nil {
+				return err
+			}
+		}
+		files.plantHoneytokens(aws, credential, url)
+	}
+	s.honeytokens = st
+	return nil
+}
+
+// checkHoneytokens raises a honeytoken_triggered alert when the payload of
+// ev carries a honeytoken, once per token and session.
+func (m *ConnMeta) checkHoneytokens(ev Event) {
+	t := m.server.honeytokens.match(PayloadText(ev))
+	if t == nil || m.honeytokens[t.ID] {
+		return
+	}
+	if m.honeytokens == nil {
+		m.honeytokens = make(map[string]bool)
+	}
+	m.honeytokens[t.ID] = true
+	alert := honeytokenAlert(t, fmt.Sprintf("on port %s from %s", m.Port, m.ClientAddr))
+	alert.Fields["honeytoken_via"] = ev.Type
+	m.Emit(alert)
+}
+
+// honeytokenAlert returns the alert about the use of t, where it was used.
+func honeytokenAlert(t *Honeytoken, where string) Event {
+	planted := ""
+	if t.Note != "" {
+		planted = ", planted in " + t.Note
+	}
+	ev := newAlertEvent("high", "honeytoken_triggered", fmt.Sprintf("%s honeytoken %s%s used %s", t.Kind, t.ID, planted, where))
+	ev.Fields["honeytoken_id"] = t.ID
+	ev.Fields["honeytoken_kind"] = t.Kind
+	if t.Note != "" {
+		ev.Fields["honeytoken_note"] = t.Note
+	}
+	return ev
+}
+
+// trackingPixel is a transparent 1x1 GIF, the answer to requests for URL
+// tokens embedded as images.
+var trackingPixel = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\xff\xff\xff!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+// HoneytokenTracker returns the tracking listener of the honeytokens of s:
+// a request whose URL, headers or body carries a honeytoken, such as a URL
+// token opened or an AWS key presented in an Authorization header, raises a
+// high honeytoken_triggered alert and is answered with a transparent GIF.
+// Other requests get 404 Not Found.
+func (s *Server) HoneytokenTracker() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+		var b strings.Builder
+		b.WriteString(r.URL.String())
+		for name, values := range r.Header {
+			b.WriteString("\n" + name + ": " + strings.Join(values, ", "))
+		}
+		b.WriteString("\n")
+		b.Write(body)
+		t := s.honeytokens.match(b.String())
+		if t == nil {
+			http.NotFound(w, r)
+			return
+		}
+		ev := honeytokenAlert(t, fmt.Sprintf("in a request for %s from %s", r.URL.Path, r.RemoteAddr))
+		ev.SrcAddr = r.RemoteAddr
+		ev.Fields["honeytoken_via"] = "tracker"
+		ev.Fields["http_method"] = r.Method
+		ev.Fields["http_path"] = r.URL.RequestURI()
+		ev.Fields["http_user_agent"] = r.UserAgent()
+		s.Emit(ev)
+		w.Header().Set("Content-Type", "image/gif")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(trackingPixel)
+	})
+}
+
+// decoyDBUser is the database user of the decoy configuration files.
+const decoyDBUser = "webapp"
+
+// plantHoneytokens replaces the generated cloud keys and database password
+// of dfs with those of the aws and credential honeytokens, and adds the URL
+// of url, if not nil, to the meeting notes.
+func (dfs *DecoyFS) plantHoneytokens(aws, credential, url *Honeytoken) {
+	replacer := strings.NewReplacer(
+		dfs.honeytokens[0], aws.AccessKeyID,
+		dfs.honeytokens[1], aws.SecretAccessKey,
+		dfs.honeytokens[2], credential.Password,
+	)
+	for name, file := range dfs.files {
+		if file.mode.IsDir() {
+			continue
+		}
+		file.content = []byte(replacer.Replace(string(file.content)))
+		if url != nil && path.Base(name) == "meeting-notes-q3.txt" {
+			file.content = append(file.content, "- new backup portal (use the VPN): "+url.URL+"\n"...)
+		}
+	}
+	dfs.honeytokens = []string{aws.AccessKeyID, aws.SecretAccessKey, credential.Password}
+}
@@ -0,0 +1,181 @@
+package honeypot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"time"
+)
+
+// hookWaitDelay is how long a hook that finished or was killed may keep its
+// output open, through the children it left, before it is closed.
+const hookWaitDelay = 2 * time.Second
+
+// HookConfig runs an external command or an inline shell script for every
+// matching event, e.g. to traceroute an attacker or snapshot firewall counters.
+type HookConfig struct {
+	Name          string   `json:"name"`                     // used in log messages
+	Events        []string `json:"events,omitempty"`         // event types to act on, empty for all
+	Alerts        []string `json:"alerts,omitempty"`         // alert kinds to act on, e.g. "watchlist_hit", empty for all
+	Match         string   `json:"match,omitempty"`          // regular expression the event text must match
+	Command       []string `json:"command,omitempty"`        // program and arguments, run without a shell
+	Script        string   `json:"script,omitempty"`         // shell script, used when Command is empty
+	Timeout       string   `json:"timeout,omitempty"`        // how long a run may take, default 30s
+	MaxConcurrent int      `json:"max_concurrent,omitempty"` // runs in flight at once, default 1
+}
+
+// HookOutput is an Output that runs a hook asynchronously for matching events.
+// The event is passed as JSON on standard input and its main properties as
+// GOPOT_* environment variables. Events arriving while MaxConcurrent runs are
+// in flight are dropped and reported as a write error.
+type HookOutput struct {
+	name    string
+	events  map[string]bool
+	alerts  map[string]bool
+	match   *regexp.Regexp
+	argv    []string
+	timeout time.Duration
+	slots   chan struct{}
+	log     *log.Logger
+}
+
+// NewHookOutput validates cfg and returns its output. Failed runs are reported to logger.
+func NewHookOutput(cfg HookConfig, logger *log.Logger) (*HookOutput, error) {
+	h := &HookOutput{name: hookName(cfg), timeout: 30 * time.Second, log: logger}
+
+	switch {
+	case len(cfg.Command) > 0:
+		h.argv = cfg.Command
+	case cfg.Script != "" && runtime.GOOS == "windows":
+		h.argv = []string{"cmd", "/C", cfg.Script}
+	case cfg.Script != "":
+		h.argv = []string{"/bin/sh", "-c", cfg.Script}
+	default:
+		return nil, fmt.Errorf("hook %s: either command or script is required", h.name)
+	}
+
+	if cfg.Match != "" {
+		re, err := regexp.Compile(cfg.Match)
+		if err != nil {
+			return nil, fmt.Errorf("hook %s: %w", h.name, err)
+		}
+		h.match = re
+	}
+	if cfg.Timeout != "" {
+		timeout, err := time.ParseDuration(cfg.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("hook %s: invalid timeout %q", h.name, cfg.Timeout)
+		}
+		h.timeout = timeout
+	}
+	if cfg.MaxConcurrent < 0 {
+		return nil, fmt.Errorf("hook %s: max_concurrent must not be negative", h.name)
+	}
+	h.slots = make(chan struct{}, max(cfg.MaxConcurrent, 1))
+	h.events = stringSet(cfg.Events)
+	h.alerts = stringSet(cfg.Alerts)
+	return h, nil
+}
+
+// hookName returns the name of a hook used in log messages and filters.
+func hookName(cfg HookConfig) string {
+	if cfg.Name == "" {
+		return "hook"
+	}
+	return cfg.Name
+}
+
+// Name returns the name of the hook.
+func (h *HookOutput) Name() string { return h.name }
+
+// Write implements Output.
+func (h *HookOutput) Write(ev Event) error {
+	if !h.matches(ev) {
+		return nil
+	}
+	select {
+	case h.slots <- struct{}{}:
+	default:
+		return fmt.Errorf("hook %s busy, skipped %s event", h.name, ev.Type)
+	}
+	go func() {
+		defer func() { <-h.slots }()
+		h.run(ev)
+	}()
+	return nil
+}
+
+func (h *HookOutput) matches(ev Event) bool {
+	if len(h.events) > 0 && !h.events[ev.Type] {
+		return false
+	}
+	if len(h.alerts) > 0 {
+		kind, _ := ev.Fields["alert"].(string)
+		if ev.Type != EventAlert || !h.alerts[kind] {
+			return false
+		}
+	}
+	return h.match == nil || h.match.MatchString(ev.Text())
+}
+
+// run executes the hook for ev and waits for it to finish or time out.
+func (h *HookOutput) run(ev Event) {
+	input, err := json.Marshal(ev)
+	if err != nil {
+		h.log.Printf("Hook %s: %s", h.name, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.argv[0], h.argv[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = append(os.Environ(), hookEnv(ev)...)
+	killHookGroup(cmd)
+	// A child left holding the output pipe would otherwise keep the slot of
+	// the hook past its timeout
+	cmd.WaitDelay = hookWaitDelay
+	output, err := cmd.CombinedOutput()
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		h.log.Printf("Hook %s timed out after %s", h.name, h.timeout)
+	case err != nil:
+		h.log.Printf("Hook %s failed: %s: %s", h.name, err, bytes.TrimSpace(output))
+	}
+}
+
+// hookEnv describes ev as environment variables for hook commands.
+func hookEnv(ev Event) []string {
+	srcIP := ev.SrcAddr
+	if host, _, err := net.SplitHostPort(ev.SrcAddr); err == nil {
+		srcIP = host
+	}
+	kind, _ := ev.Fields["alert"].(string)
+	return []string{
+		"GOPOT_EVENT_TYPE=" + ev.Type,
+		"GOPOT_EVENT_TIME=" + ev.Time.UTC().Format(time.RFC3339),
+		"GOPOT_SEVERITY=" + ev.Severity,
+		"GOPOT_ALERT=" + kind,
+		"GOPOT_PORT=" + ev.Port,
+		"GOPOT_SRC_ADDR=" + ev.SrcAddr,
+		"GOPOT_SRC_IP=" + srcIP,
+		"GOPOT_DST_ADDR=" + ev.DstAddr,
+		"GOPOT_MESSAGE=" + ev.Message,
+	}
+}
+
+func stringSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package honeypot
+
+import "os/exec"
+
+// killHookGroup leaves the cancellation of cmd killing the hook process only:
+// process groups are a Unix notion. Its children are cut off by WaitDelay.
+func killHookGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package honeypot
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killHookGroup runs cmd in a process group of its own and makes the
+// cancellation of its context kill the whole group, so that the children a
+// hook script leaves behind, such as a traceroute, go with it.
+func killHookGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
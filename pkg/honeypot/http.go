@@ -0,0 +1,220 @@
+package honeypot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// httpReply is the response of an HTTP persona to one request.
+type httpReply struct {
+	Status  int
+	Headers [][2]string // sent in order, then Content-Length, lower case if the first header's name is
+	Body    []byte
+}
+
+// httpPage returns a reply of type text/html.
+func httpPage(status int, body string, headers ...[2]string) httpReply {
+	return httpReply{Status: status, Headers: append(headers, [2]string{"Content-Type", "text/html;charset=utf-8"}), Body: []byte(body)}
+}
+
+// httpRedirect returns a 302 reply to location.
+func httpRedirect(location string, headers ...[2]string) httpReply {
+	return httpReply{Status: http.StatusFound, Headers: append(headers, [2]string{"Location", location})}
+}
+
+// serveHTTPPersona reads HTTP/1.1 requests from conn until the client is
+// done, logging each one with its body as a data event of product, and
+// writes the replies handle returns. Basic auth credentials are logged,
+// and Java serialized objects in bodies raise a java_deserialization alert,
+// the payload of most Java RCE scanners. Paths handle answers 404 get a
+// generated page instead when the server has a Responder. Streams not
+// starting with an HTTP method are logged as data and closed.
+func serveHTTPPersona(ctx context.Context, conn net.Conn, meta *ConnMeta, product string, handle func(req *http.Request, body []byte) httpReply) error {
+	return serveHTTPRequests(ctx, conn, bufio.NewReaderSize(conn, meta.Limits.ReadBuffer), meta, product, handle)
+}
+
+// serveHTTPRequests is serveHTTPPersona reading from reader, a buffered
+// reader of conn that handlers sniffing other protocols have peeked into.
+func serveHTTPRequests(ctx context.Context, conn net.Conn, reader *bufio.Reader, meta *ConnMeta, product string, handle func(req *http.Request, body []byte) httpReply) error {
+	total := 0
+	for requests := 0; ; requests++ {
+		conn.SetReadDeadline(meta.NextReadDeadline(requests > 0))
+		if _, err := reader.Peek(1); err != nil {
+			return binaryReadError(ctx, meta, nil, err, requests > 0)
+		}
+		if start, _ := reader.Peek(reader.Buffered()); !looksLikeHTTP(start) {
+			meta.LogData(string(start))
+			return nil
+		}
+		req, err := http.ReadRequest(reader)
+		var netErr net.Error
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.As(err, &netErr) {
+			meta.Logf("Malformed HTTP request on port %s from %s: %s", meta.Port, meta.ClientAddr, err)
+			return nil
+		}
+		if err != nil {
+			return binaryReadError(ctx, meta, nil, err, true)
+		}
+		body, err := io.ReadAll(io.LimitReader(req.Body, int64(meta.Limits.MaxBytes-total)))
+		total += len(body)
+		if err != nil {
+			return binaryReadError(ctx, meta, body, err, true)
+		}
+		logHTTPRequest(meta, product, req, body)
+		reply := handle(req, body)
+		if reply.Status == http.StatusNotFound {
+			if page, ok := meta.respond(ResponderHTTP, req.Method+" "+req.URL.RequestURI()); ok {
+				reply.Status, reply.Body = http.StatusOK, []byte(page)
+			}
+		}
+		if _, err := conn.Write(reply.bytes(req.Method == http.MethodHead)); err != nil {
+			return fmt.Errorf("writing to connection: %w", err)
+		}
+		if req.Close || total >= meta.Limits.MaxBytes {
+			return nil
+		}
+	}
+}
+
+// logHTTPRequest records a request received by an HTTP persona.
+func logHTTPRequest(meta *ConnMeta, product string, req *http.Request, body []byte) {
+	fields := map[string]any{
+		"data": string(body), "http_method": req.Method, "http_path": req.URL.RequestURI(), "http_user_agent": req.UserAgent(),
+	}
+	message := fmt.Sprintf("%s %s %s on port %s from %s", product, req.Method, req.URL.RequestURI(), meta.Port, meta.ClientAddr)
+	if len(body) > 0 {
+		message += ": " + escapePayload(string(body))
+	}
+	meta.Emit(Event{Type: EventData, Message: message, Fields: fields})
+	meta.Observe(req.URL.RequestURI())
+	if len(body) > 0 {
+		meta.Observe(string(body))
+	}
+	if user, password, ok := req.BasicAuth(); ok {
+		meta.LogCredential(Credential{Username: user, Password: password})
+	}
+	if javaSerialized(body) {
+		if meta.Stage < StageExploit {
+			meta.Stage = StageExploit
+		}
+		meta.RaiseAlert("high", "java_deserialization", fmt.Sprintf("serialized Java object sent to %s %s on port %s from %s (%d bytes)",
+			product, req.URL.Path, meta.Port, meta.ClientAddr, len(body)))
+	}
+}
+
+// javaSerialized reports whether data carries a Java serialization stream,
+// raw or base64 encoded as in ysoserial payloads sent in forms and headers.
+func javaSerialized(data []byte) bool {
+	return bytes.Contains(data, []byte{0xAC, 0xED, 0x00, 0x05}) ||
+		bytes.Contains(data, []byte("rO0AB")) ||
+		bytes.Contains(bytes.ToLower(data), []byte("aced0005"))
+}
+
+// bytes encodes the reply, without the body for HEAD requests.
+func (r httpReply) bytes(head bool) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "HTTP/1.1 %d %s\r\n", r.Status, http.StatusText(r.Status))
+	for _, h := range r.Headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", h[0], h[1])
+	}
+	length := "Content-Length"
+	if len(r.Headers) > 0 && r.Headers[0][0] == strings.ToLower(r.Headers[0][0]) {
+		length = "content-length"
+	}
+	fmt.Fprintf(&b, "%s: %d\r\n\r\n", length, len(r.Body))
+	if !head {
+		b.Write(r.Body)
+	}
+	return b.Bytes()
+}
+
+// httpDate returns the Date header of a reply sent now.
+func httpDate() [2]string {
+	return [2]string{"Date", time.Now().UTC().Format(http.TimeFormat)}
+}
+
+// httpForm returns the fields of a POSTed form, empty if body is not one.
+func httpForm(body []byte) url.Values {
+	form, _ := url.ParseQuery(string(body))
+	return form
+}
+
+// looksLikeHTTP reports whether a stream starts with an HTTP method.
+func looksLikeHTTP(start []byte) bool {
+	method, _, found := bytes.Cut(start, []byte(" "))
+	if !found {
+		method = start
+	}
+	if len(method) == 0 || len(method) > 10 || (!found && len(start) > 10) {
+		return false
+	}
+	for _, c := range method {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// jsessionID returns a new JSESSIONID cookie value of Java application
+// servers, 32 upper case hex digits.
+func jsessionID(meta *ConnMeta) string {
+	return strings.ToUpper(meta.randomID() + meta.randomID())
+}
+
+// phpHeaders returns the Server and X-Powered-By headers of a PHP
+// application on the host's web server.
+func phpHeaders(id *HostIdentity) [][2]string {
+	version := id.Software["php"]
+	if version == "" {
+		version = "8.1.27"
+	}
+	return [][2]string{httpDate(), {"Server", id.HTTPServerHeader()}, {"X-Powered-By", "PHP/" + version}}
+}
+
+// phpExtensions are the file name extensions Apache and nginx setups hand
+// to PHP, which upload vulnerabilities are exploited with.
+var phpExtensions = []string{".php", ".phtml", ".php3", ".php4", ".php5", ".php7", ".pht", ".phar", ".phps"}
+
+// isPHPFile reports whether name would be run by PHP.
+func isPHPFile(name string) bool {
+	ext := strings.ToLower(path.Ext(strings.TrimRight(name, ". ")))
+	for _, e := range phpExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// phpUploads returns the names of the PHP files in a multipart form body.
+func phpUploads(req *http.Request, body []byte) []string {
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return nil
+	}
+	var names []string
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return names
+		}
+		if name := part.FileName(); name != "" && isPHPFile(name) {
+			names = append(names, name)
+		}
+		part.Close()
+	}
+}
@@ -0,0 +1,221 @@
+package honeypot
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	mathrand "math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostIdentity describes the single fake host that all personas of a profile
+// impersonate, so banners, headers, share names and certificates agree.
+type HostIdentity struct {
+	Profile  string
+	Hostname string
+	Domain   string
+	OS       string            // human readable OS name, e.g. "Ubuntu 22.04.4 LTS"
+	Kernel   string            // uname -r output
+	User     string            // primary interactive user
+	MACs     []string          // one per fake network interface
+	Software map[string]string // installed server software and versions, by product
+	TLSNames []string          // DNS names presented in certificates
+
+	LegalNotice string // consent banner interactive personas show first, empty for none, see LegalBanner
+	Seed        string // makes the randomness of personas reproducible across runs, see Rand; empty for unpredictable
+}
+
+// DefaultProfile is the host profile used unless another one is selected.
+const DefaultProfile = "ubuntu-web"
+
+// identityPresets are the built-in host profiles.
+var identityPresets = map[string]HostIdentity{
+	"ubuntu-web": {
+		Hostname: "web01",
+		Domain:   "corp.local",
+		OS:       "Ubuntu 22.04.4 LTS",
+		Kernel:   "5.15.0-105-generic",
+		User:     "deploy",
+		Software: map[string]string{"openssh": "8.9p1 Ubuntu-3ubuntu0.7", "apache": "2.4.52", "vsftpd": "3.0.5", "mysql": "8.0.36-0ubuntu0.22.04.1", "samba": "4.15.13-Ubuntu", "php": "8.1.2-1ubuntu2.14"},
+	},
+	"debian-db": {
+		Hostname: "db-prod-02",
+		Domain:   "internal.lan",
+		OS:       "Debian GNU/Linux 12 (bookworm)",
+		Kernel:   "6.1.0-18-amd64",
+		User:     "dbadmin",
+		Software: map[string]string{"openssh": "9.2p1 Debian-2+deb12u2", "nginx": "1.22.1", "mysql": "8.0.36", "redis": "7.0.15", "mongodb": "6.0.14", "memcached": "1.6.18", "samba": "4.17.12-Debian", "php": "8.2.7"},
+	},
+	"windows-fileserver": {
+		Hostname: "FS01",
+		Domain:   "corp.local",
+		OS:       "Windows Server 2019 Standard 17763",
+		Kernel:   "10.0.17763",
+		User:     "Administrator",
+		Software: map[string]string{"iis": "10.0", "mssql": "15.0.2000.5", "rdp": "10.0", "smb": "3.1.1"},
+	},
+}
+
+// NewHostIdentity builds the identity for a preset profile. A non-empty
+// hostname overrides the preset's. MAC addresses are derived from the
+// hostname so they stay stable across restarts.
+func NewHostIdentity(profile, hostname string) (*HostIdentity, error) {
+	preset, ok := identityPresets[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q (available: %s)", profile, strings.Join(IdentityProfiles(), ", "))
+	}
+	id := preset
+	id.Profile = profile
+	if hostname != "" {
+		id.Hostname = hostname
+	}
+
+	rng := mathrand.New(mathrand.NewSource(id.seed()))
+	// 52:54:00 is the QEMU/KVM vendor prefix, typical for virtual servers
+	for i := 0; i < 2; i++ {
+		id.MACs = append(id.MACs, fmt.Sprintf("52:54:00:%02x:%02x:%02x", rng.Intn(256), rng.Intn(256), rng.Intn(256)))
+	}
+	id.TLSNames = []string{id.FQDN(), strings.ToLower(id.Hostname)}
+	return &id, nil
+}
+
+// IdentityProfiles returns the names of the built-in profiles.
+func IdentityProfiles() []string {
+	names := make([]string, 0, len(identityPresets))
+	for name := range identityPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// seed derives a stable random seed from the profile and hostname.
+func (id *HostIdentity) seed() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(id.Profile + "/" + id.Hostname))
+	return int64(h.Sum64())
+}
+
+// FQDN returns the fully qualified host name.
+func (id *HostIdentity) FQDN() string {
+	return strings.ToLower(id.Hostname) + "." + id.Domain
+}
+
+// IsWindows reports whether the identity impersonates a Windows host.
+func (id *HostIdentity) IsWindows() bool {
+	return strings.HasPrefix(id.OS, "Windows")
+}
+
+// SSHBanner returns the SSH identification string matching the installed OpenSSH.
+func (id *HostIdentity) SSHBanner() string {
+	if version, ok := id.Software["openssh"]; ok {
+		return "SSH-2.0-OpenSSH_" + version
+	}
+	return "SSH-2.0-OpenSSH_for_Windows_8.1"
+}
+
+// HTTPServerHeader returns the Server header value of the installed web server.
+func (id *HostIdentity) HTTPServerHeader() string {
+	switch {
+	case id.Software["apache"] != "":
+		distro := "Unix"
+		if strings.Contains(id.OS, "Ubuntu") {
+			distro = "Ubuntu"
+		} else if strings.Contains(id.OS, "Debian") {
+			distro = "Debian"
+		}
+		return fmt.Sprintf("Apache/%s (%s)", id.Software["apache"], distro)
+	case id.Software["nginx"] != "":
+		return "nginx/" + id.Software["nginx"]
+	case id.Software["iis"] != "":
+		return "Microsoft-IIS/" + id.Software["iis"]
+	}
+	return "Apache"
+}
+
+// SNMPSysDescr returns the sysDescr an SNMP agent on this host reports.
+func (id *HostIdentity) SNMPSysDescr() string {
+	if id.IsWindows() {
+		build := id.Kernel[strings.LastIndex(id.Kernel, ".")+1:]
+		return "Hardware: Intel64 Family 6 Model 85 Stepping 7 AT/AT COMPATIBLE - Software: Windows Version 6.3 (Build " + build + " Multiprocessor Free)"
+	}
+	return fmt.Sprintf("Linux %s %s #1 SMP x86_64", strings.ToLower(id.Hostname), id.Kernel)
+}
+
+// SMBHostname returns the NetBIOS computer name: upper case, at most 15 characters.
+func (id *HostIdentity) SMBHostname() string {
+	name := strings.ToUpper(id.Hostname)
+	if len(name) > 15 {
+		name = name[:15]
+	}
+	return name
+}
+
+// identityCerts caches the certificates of TLSCertificate by host name, as
+// generating an RSA key takes a while.
+var identityCerts sync.Map
+
+// TLSCertificate returns a self-signed certificate for the host like the
+// ones Windows generates for Remote Desktop: RSA 2048, the FQDN as subject
+// and issuer, the TLSNames as DNS names, valid for six months. It is
+// generated on first use and kept for the life of the process; with a Seed,
+// the same certificate is generated on every run.
+func (id *HostIdentity) TLSCertificate() (*tls.Certificate, error) {
+	name := id.FQDN()
+	dnsNames := id.TLSNames
+	if len(dnsNames) == 0 {
+		dnsNames = []string{name}
+	}
+	cacheKey := strings.Join(dnsNames, ",") + "/" + name + "/" + id.Seed
+	if cert, ok := identityCerts.Load(cacheKey); ok {
+		return cert.(*tls.Certificate), nil
+	}
+	var key *rsa.PrivateKey
+	var err error
+	rng := id.Rand("certificate")
+	if id.Seed != "" {
+		key, err = seededRSAKey(rng, 2048)
+	} else {
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rng, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	// Renewed every six months at a point that stays the same for the host,
+	// so restarts do not change the validity
+	const period = 182 * 24 * time.Hour
+	offset := time.Duration(uint64(id.seed()) % uint64(period))
+	notBefore := time.Unix(0, 0).Add(offset + time.Since(time.Unix(0, 0).Add(offset)).Truncate(period))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     dnsNames,
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(period),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, _ := identityCerts.LoadOrStore(cacheKey, &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key})
+	return cert.(*tls.Certificate), nil
+}
+
+// DecoyFS generates the decoy file system belonging to this host.
+func (id *HostIdentity) DecoyFS() *DecoyFS {
+	return GenerateDecoyFS(id.Hostname, id.User, id.IsWindows(), id.seed())
+}
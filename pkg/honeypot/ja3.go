@@ -0,0 +1,129 @@
+package honeypot
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// JA3 fingerprints the TLS ClientHello at the start of data, the first bytes
+// a TLS client sends. It returns the JA3 string (version, cipher suites,
+// extensions, elliptic curves and point formats) and its MD5 hash, the usual
+// form of the fingerprint, or ok false when data does not start with a
+// complete ClientHello. GREASE values are left out as JA3 specifies.
+func JA3(data []byte) (fingerprint, hash string, ok bool) {
+	// TLS record: handshake, version, length
+	if len(data) < 5 || data[0] != 0x16 || data[1] != 0x03 {
+		return "", "", false
+	}
+	record := data[5:]
+	if n := int(binary.BigEndian.Uint16(data[3:5])); n < len(record) {
+		record = record[:n]
+	}
+	// Handshake: ClientHello, 24-bit length
+	if len(record) < 4 || record[0] != 0x01 {
+		return "", "", false
+	}
+	n := int(record[1])<<16 | int(record[2])<<8 | int(record[3])
+	if len(record)-4 < n {
+		return "", "", false
+	}
+	hello := ja3Reader(record[4 : 4+n])
+
+	version, _ := hello.uint16()
+	hello.skip(32)  // random
+	hello.vector(1) // session ID
+	suites := hello.vector(2)
+	hello.vector(1) // compression methods
+	var extensions ja3Reader
+	if len(hello) > 0 {
+		extensions = hello.vector(2)
+	}
+	if hello == nil {
+		return "", "", false
+	}
+
+	var ciphers, types, curves, formats []string
+	for len(suites) >= 2 {
+		v, _ := suites.uint16()
+		ciphers = appendJA3Value(ciphers, v)
+	}
+	for len(extensions) >= 4 {
+		typ, _ := extensions.uint16()
+		body := extensions.vector(2)
+		if extensions == nil {
+			return "", "", false
+		}
+		types = appendJA3Value(types, typ)
+		switch typ {
+		case 10: // supported_groups
+			list := body.vector(2)
+			for len(list) >= 2 {
+				v, _ := list.uint16()
+				curves = appendJA3Value(curves, v)
+			}
+		case 11: // ec_point_formats
+			for _, v := range body.vector(1) {
+				formats = append(formats, strconv.Itoa(int(v)))
+			}
+		}
+	}
+	fingerprint = strings.Join([]string{
+		strconv.Itoa(int(version)),
+		strings.Join(ciphers, "-"),
+		strings.Join(types, "-"),
+		strings.Join(curves, "-"),
+		strings.Join(formats, "-"),
+	}, ",")
+	sum := md5.Sum([]byte(fingerprint))
+	return fingerprint, hex.EncodeToString(sum[:]), true
+}
+
+// appendJA3Value appends v in decimal unless it is a GREASE value (RFC 8701).
+func appendJA3Value(values []string, v uint16) []string {
+	if v&0x0f0f == 0x0a0a && v>>8 == v&0xff {
+		return values
+	}
+	return append(values, strconv.Itoa(int(v)))
+}
+
+// ja3Reader consumes a ClientHello. It becomes nil once a read runs past the
+// end, so a truncated message is detected with a single check.
+type ja3Reader []byte
+
+func (r *ja3Reader) skip(n int) []byte {
+	if *r == nil || len(*r) < n {
+		*r = nil
+		return nil
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b
+}
+
+func (r *ja3Reader) uint16() (uint16, bool) {
+	b := r.skip(2)
+	if b == nil {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(b), true
+}
+
+// vector reads a length-prefixed field whose length takes size bytes.
+func (r *ja3Reader) vector(size int) ja3Reader {
+	prefix := r.skip(size)
+	if prefix == nil {
+		return nil
+	}
+	n := 0
+	for _, b := range prefix {
+		n = n<<8 | int(b)
+	}
+	b := r.skip(n)
+	if b == nil {
+		return nil
+	}
+	return ja3Reader(b)
+}
@@ -0,0 +1,172 @@
+package honeypot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JournalSocket is where journald receives entries in its native protocol.
+const JournalSocket = "/run/systemd/journal/socket"
+
+// journalMaxField bounds the values of an entry sent again after the whole
+// entry was too large for a datagram.
+const journalMaxField = 4096
+
+// JournalConfig enables the journald output.
+type JournalConfig struct {
+	Enabled    bool   `json:"enabled"`              // write events to the journal, Linux only
+	Identifier string `json:"identifier,omitempty"` // SYSLOG_IDENTIFIER of the entries, default gopot
+	Socket     string `json:"socket,omitempty"`     // native protocol socket, default JournalSocket
+}
+
+// JournalOutput is an Output writing each event to the systemd journal as
+// an entry of its own with structured fields: MESSAGE is the log line of the
+// event, PRIORITY follows its severity, and the properties of the event are
+// GOPOT_ fields, so "journalctl -t gopot -o json" returns them as they are.
+// Only available on Linux.
+type JournalOutput struct {
+	identifier string
+	conn       net.Conn
+}
+
+// NewJournalOutput connects to the journal.
+func NewJournalOutput(cfg JournalConfig) (*JournalOutput, error) {
+	o := &JournalOutput{identifier: cfg.Identifier}
+	if o.identifier == "" {
+		o.identifier = "gopot"
+	}
+	socket := cfg.Socket
+	if socket == "" {
+		socket = JournalSocket
+	}
+	conn, err := dialJournal(socket)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the journal: %w", err)
+	}
+	o.conn = conn
+	return o, nil
+}
+
+// journalPriority returns the syslog priority of ev.
+func journalPriority(ev Event) int {
+	switch {
+	case ev.Type == EventError, ev.Type == EventHandlerTimeout:
+		return 3 // err
+	case ev.Severity == "high", ev.Type == EventCanary:
+		return 2 // crit
+	case ev.Severity == "medium":
+		return 4 // warning
+	case ev.Severity == "low", ev.Type == EventCredential:
+		return 5 // notice
+	}
+	return 6 // info
+}
+
+// journalField returns name as part of a journal field name: upper case
+// letters, digits and underscores, short enough for the 64 characters of a
+// field name.
+func journalField(name string) string {
+	b := []byte(strings.ToUpper(name))
+	if len(b) > 48 {
+		b = b[:48]
+	}
+	for i, c := range b {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// journalEntry encodes ev in the native protocol of journald, with values
+// longer than limit cut, unless it is 0.
+func (o *JournalOutput) journalEntry(ev Event, limit int) []byte {
+	var b bytes.Buffer
+	add := func(name, value string) {
+		if limit > 0 && len(value) > limit {
+			value = value[:limit]
+		}
+		if !strings.Contains(value, "\n") {
+			b.WriteString(name + "=" + value + "\n")
+			return
+		}
+		// Values with newlines are sent with their length
+		b.WriteString(name + "\n")
+		binary.Write(&b, binary.LittleEndian, uint64(len(value)))
+		b.WriteString(value + "\n")
+	}
+	add("MESSAGE", ev.Text())
+	add("PRIORITY", strconv.Itoa(journalPriority(ev)))
+	add("SYSLOG_IDENTIFIER", o.identifier)
+	add("GOPOT_EVENT_TYPE", ev.Type)
+	add("GOPOT_EVENT_TIME", ev.Time.UTC().Format(time.RFC3339Nano))
+	add("GOPOT_SEQ", strconv.FormatUint(ev.Seq, 10))
+	add("GOPOT_BOOT_ID", ev.BootID)
+	for _, field := range []struct{ name, value string }{
+		{"GOPOT_SESSION", ev.Session},
+		{"GOPOT_SEVERITY", ev.Severity},
+		{"GOPOT_PORT", ev.Port},
+		{"GOPOT_SRC_ADDR", ev.SrcAddr},
+		{"GOPOT_SRC_IP", srcIP(ev.SrcAddr)},
+		{"GOPOT_DST_ADDR", ev.DstAddr},
+		{"GOPOT_HOST", ev.Host},
+	} {
+		if field.value != "" {
+			add(field.name, field.value)
+		}
+	}
+	keys := make([]string, 0, len(ev.Labels))
+	for key := range ev.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		add("GOPOT_LABEL_"+journalField(key), ev.Labels[key])
+	}
+	keys = keys[:0]
+	for key := range ev.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var value string
+		switch v := ev.Fields[key].(type) {
+		case string:
+			value = v
+		default:
+			data, err := json.Marshal(v)
+			if err != nil {
+				continue
+			}
+			value = string(data)
+		}
+		add("GOPOT_"+journalField(key), value)
+	}
+	if limit > 0 {
+		add("GOPOT_TRUNCATED", "1")
+	}
+	return b.Bytes()
+}
+
+// Write implements Output. Entries too large for a datagram are sent again
+// with their values cut and GOPOT_TRUNCATED set.
+func (o *JournalOutput) Write(ev Event) error {
+	if _, err := o.conn.Write(o.journalEntry(ev, 0)); err != nil {
+		if _, err := o.conn.Write(o.journalEntry(ev, journalMaxField)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close disconnects from the journal.
+func (o *JournalOutput) Close() error {
+	return o.conn.Close()
+}
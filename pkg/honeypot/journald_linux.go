@@ -0,0 +1,10 @@
+//go:build linux
+
+package honeypot
+
+import "net"
+
+// dialJournal connects to the native protocol socket of journald.
+func dialJournal(socket string) (net.Conn, error) {
+	return net.Dial("unixgram", socket)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package honeypot
+
+import (
+	"errors"
+	"net"
+)
+
+// dialJournal is only implemented on Linux.
+func dialJournal(socket string) (net.Conn, error) {
+	return nil, errors.New("journald is only available on Linux")
+}
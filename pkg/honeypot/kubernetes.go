@@ -0,0 +1,161 @@
+package honeypot
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of the Kubernetes mode.
+const (
+	DefaultProbeAddr      = ":8086"
+	DefaultReloadInterval = 10 * time.Second
+)
+
+// kubernetesNamespaceFile holds the namespace of the pod, mounted with its
+// service account token.
+const kubernetesNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// KubernetesConfig runs GoPot as an in-cluster deception workload.
+type KubernetesConfig struct {
+	Enabled  bool                `json:"enabled"`            // opt-in
+	Probes   string              `json:"probes,omitempty"`   // address of the /livez and /readyz endpoints, default DefaultProbeAddr
+	Reload   string              `json:"reload,omitempty"`   // how often the configuration file is checked for changes, default 10s, 0 to never reload
+	Services []KubernetesService `json:"services,omitempty"` // personas exposed by Services of their own
+}
+
+// KubernetesService is a persona exposed by a Service of its own, which maps
+// the port clients target to the container port GoPot listens on.
+type KubernetesService struct {
+	Name       string            `json:"name"`                  // name of the Service, in the k8s_service label of events
+	Port       int               `json:"port"`                  // port of the Service, reported in events
+	TargetPort int               `json:"target_port,omitempty"` // container port, default Port
+	Handler    string            `json:"handler,omitempty"`     // persona, empty for the default handler
+	Proxy      bool              `json:"proxy,omitempty"`       // expect a PROXY protocol header
+	Labels     map[string]string `json:"labels,omitempty"`      // echoed into every event of the Service
+}
+
+// ReloadInterval returns how often the configuration file is checked for
+// changes, 0 for never.
+func (c KubernetesConfig) ReloadInterval() (time.Duration, error) {
+	if c.Reload == "" {
+		return DefaultReloadInterval, nil
+	}
+	interval, err := time.ParseDuration(c.Reload)
+	if err != nil || interval < 0 {
+		return 0, fmt.Errorf("invalid reload interval %q", c.Reload)
+	}
+	return interval, nil
+}
+
+// ProbeAddr returns the address of the probe endpoints.
+func (c KubernetesConfig) ProbeAddr() string {
+	if c.Probes == "" {
+		return DefaultProbeAddr
+	}
+	return c.Probes
+}
+
+// check reports the first invalid setting of c.
+func (c KubernetesConfig) check() error {
+	if _, err := c.ReloadInterval(); err != nil {
+		return err
+	}
+	if _, _, err := net.SplitHostPort(c.ProbeAddr()); err != nil {
+		return fmt.Errorf("invalid probe address %q: %w", c.ProbeAddr(), err)
+	}
+	names := make(map[string]bool)
+	for i, svc := range c.Services {
+		if svc.Name == "" {
+			return fmt.Errorf("services[%d]: name is required", i)
+		}
+		if names[svc.Name] {
+			return fmt.Errorf("services[%d]: duplicate service %s", i, svc.Name)
+		}
+		names[svc.Name] = true
+		if svc.Port < 1 || svc.Port > 65535 || svc.TargetPort < 0 || svc.TargetPort > 65535 {
+			return fmt.Errorf("services[%d]: ports must be between 1 and 65535", i)
+		}
+	}
+	return nil
+}
+
+// portGroups returns the port groups listening for the Services.
+func (c KubernetesConfig) portGroups() []PortConfig {
+	groups := make([]PortConfig, 0, len(c.Services))
+	for _, svc := range c.Services {
+		target := svc.TargetPort
+		if target == 0 {
+			target = svc.Port
+		}
+		labels := map[string]string{"k8s_service": svc.Name}
+		for key, value := range svc.Labels {
+			labels[key] = value
+		}
+		groups = append(groups, PortConfig{Ports: strconv.Itoa(target), Handler: svc.Handler, Proxy: svc.Proxy, Labels: labels, ServicePort: svc.Port})
+	}
+	return groups
+}
+
+// KubernetesMetadata returns the labels identifying the pod GoPot runs in:
+// k8s_pod, k8s_namespace, k8s_node and k8s_pod_ip. They come from the
+// POD_NAME, POD_NAMESPACE, NODE_NAME and POD_IP environment variables,
+// which the downward API sets, with the host name and the namespace of the
+// service account as fallbacks. Unknown ones are left out.
+func KubernetesMetadata() map[string]string {
+	labels := make(map[string]string)
+	set := func(key, value string) {
+		if value = strings.TrimSpace(value); value != "" {
+			labels[key] = value
+		}
+	}
+	pod := os.Getenv("POD_NAME")
+	if pod == "" {
+		pod, _ = os.Hostname()
+	}
+	set("k8s_pod", pod)
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		content, _ := os.ReadFile(kubernetesNamespaceFile)
+		namespace = string(content)
+	}
+	set("k8s_namespace", namespace)
+	set("k8s_node", os.Getenv("NODE_NAME"))
+	set("k8s_pod_ip", os.Getenv("POD_IP"))
+	return labels
+}
+
+// ProbeHandler answers the probes of Kubernetes: /livez succeeds as long as
+// the process responds, /readyz once s listens on its ports and until it
+// starts shutting down, so Services stop sending it clients while it
+// drains. /readyz and /healthz answer with the Health of s, /healthz
+// failing while it is not ok.
+func ProbeHandler(s *Server) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) { serveReadyz(s, w) })
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { serveHealthz(s, w) })
+	return mux
+}
+
+// ServeProbes serves ProbeHandler on addr in the background, reporting
+// failures to s.Log.
+func ServeProbes(s *Server, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := http.Serve(ln, ProbeHandler(s)); err != nil && !errors.Is(err, net.ErrClosed) {
+			s.Log.Printf("Probe endpoints stopped: %s", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,92 @@
+package honeypot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// legalNotices are consent banners worded after the notices organizations in
+// each jurisdiction put on their own logins. They are templates, not legal
+// advice: have counsel approve the text deployed.
+var legalNotices = map[string]string{
+	"us": `WARNING: This system is for the use of authorized users only.
+Individuals using this computer system without authority, or in excess of
+their authority, are subject to having all of their activities on this
+system monitored and recorded. Anyone using this system expressly consents
+to such monitoring. Unauthorized access may be prosecuted under 18 U.S.C. 1030.`,
+	"uk": `WARNING: Access to this system is restricted to authorised users.
+Unauthorised access is an offence under the Computer Misuse Act 1990.
+All activity on this system is monitored and recorded, and may be disclosed
+to law enforcement.`,
+	"eu": `NOTICE: This system is restricted to authorised users.
+Connections and all activity are logged, including source addresses and the
+data sent, and processed for the security of our networks on the basis of
+legitimate interests (Article 6(1)(f) GDPR). Unauthorised access is a
+criminal offence (Directive 2013/40/EU).`,
+	"de": `HINWEIS: Zugriff nur fuer autorisierte Benutzer.
+Alle Verbindungen und Aktivitaeten auf diesem System werden einschliesslich
+der Quelladressen protokolliert und zur Gewaehrleistung der IT-Sicherheit
+verarbeitet (Art. 6 Abs. 1 lit. f DSGVO). Unbefugter Zugriff ist nach
+Paragraph 202a StGB strafbar.`,
+	"au": `WARNING: This system is for authorised use only.
+Unauthorised access or modification of data is an offence under Part 10.7
+of the Criminal Code Act 1995. Activity on this system is monitored and
+recorded, and evidence of unauthorised use may be provided to the police.`,
+}
+
+// LegalNoticeJurisdictions returns the names of the built-in notices.
+func LegalNoticeJurisdictions() []string {
+	names := make([]string, 0, len(legalNotices))
+	for name := range legalNotices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LegalNoticeConfig selects the consent banner interactive personas show
+// before their own greeting, see HostIdentity.LegalNotice.
+type LegalNoticeConfig struct {
+	Jurisdiction string                       `json:"jurisdiction,omitempty"` // built-in notice, see LegalNoticeJurisdictions
+	Text         string                       `json:"text,omitempty"`         // custom notice, overrides Jurisdiction
+	Profiles     map[string]LegalNoticeConfig `json:"profiles,omitempty"`     // notices of host profiles that differ, by profile name
+}
+
+// Notice returns the notice for the host profile, empty for none.
+func (c LegalNoticeConfig) Notice(profile string) (string, error) {
+	if override, ok := c.Profiles[profile]; ok {
+		if len(override.Profiles) > 0 {
+			return "", fmt.Errorf("profile %s: profiles cannot be nested", profile)
+		}
+		c = override
+	}
+	if c.Text != "" {
+		return strings.TrimRight(c.Text, "\r\n"), nil
+	}
+	if c.Jurisdiction == "" {
+		return "", nil
+	}
+	notice, ok := legalNotices[c.Jurisdiction]
+	if !ok {
+		return "", fmt.Errorf("unknown jurisdiction %q (available: %s)", c.Jurisdiction, strings.Join(LegalNoticeJurisdictions(), ", "))
+	}
+	return notice, nil
+}
+
+// LegalBanner returns the legal notice formatted for a line-oriented
+// protocol: every line starts with prefix, e.g. "220-" to send it as the
+// first lines of a multi-line FTP greeting, and ends with CRLF. It returns
+// an empty string when the host has no notice.
+func (id *HostIdentity) LegalBanner(prefix string) string {
+	if id.LegalNotice == "" {
+		return ""
+	}
+	var b strings.Builder
+	for _, line := range strings.Split(id.LegalNotice, "\n") {
+		b.WriteString(prefix)
+		b.WriteString(strings.TrimRight(line, "\r"))
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
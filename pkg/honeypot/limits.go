@@ -0,0 +1,104 @@
+package honeypot
+
+import (
+	"fmt"
+	"time"
+)
+
+// SessionLimits bound how long and how much handlers read from a client.
+// Different protocols need very different timing to look real: a telnet
+// login waits patiently, a web server hangs up quickly.
+type SessionLimits struct {
+	ConnectTimeout time.Duration // time the client has to send its first data
+	IdleTimeout    time.Duration // silence between later chunks that ends the session
+	SessionTimeout time.Duration // total length of a session
+	HandlerTimeout time.Duration // wall-clock budget of the handler of a session, enforced by the server, see HandlerBudget
+	ReadBuffer     int           // bytes read, and logged, at once
+	MaxBytes       int           // bytes read before the session is closed
+}
+
+// DefaultSessionLimits returns the limits used when none are configured.
+func DefaultSessionLimits() SessionLimits {
+	return SessionLimits{
+		ConnectTimeout: 10 * time.Second,
+		IdleTimeout:    15 * time.Second,
+		SessionTimeout: 2 * time.Minute,
+		ReadBuffer:     1024,
+		MaxBytes:       64 << 10,
+	}
+}
+
+// LimitsConfig is the configuration file form of SessionLimits. Durations
+// use Go syntax ("30s", "5m"); unset fields keep the inherited value.
+type LimitsConfig struct {
+	ConnectTimeout string `json:"connect_timeout,omitempty"`
+	IdleTimeout    string `json:"idle_timeout,omitempty"`
+	SessionTimeout string `json:"session_timeout,omitempty"`
+	HandlerTimeout string `json:"handler_timeout,omitempty"`
+	ReadBuffer     int    `json:"read_buffer,omitempty"`
+	MaxBytes       int    `json:"max_bytes,omitempty"`
+}
+
+// Apply returns base with the fields set in c replaced.
+func (c LimitsConfig) Apply(base SessionLimits) (SessionLimits, error) {
+	for _, d := range []struct {
+		name  string
+		value string
+		field *time.Duration
+	}{
+		{"connect_timeout", c.ConnectTimeout, &base.ConnectTimeout},
+		{"idle_timeout", c.IdleTimeout, &base.IdleTimeout},
+		{"session_timeout", c.SessionTimeout, &base.SessionTimeout},
+		{"handler_timeout", c.HandlerTimeout, &base.HandlerTimeout},
+	} {
+		if d.value == "" {
+			continue
+		}
+		timeout, err := time.ParseDuration(d.value)
+		if err != nil || timeout <= 0 {
+			return base, fmt.Errorf("invalid %s %q", d.name, d.value)
+		}
+		*d.field = timeout
+	}
+	if c.ReadBuffer < 0 || c.MaxBytes < 0 {
+		return base, fmt.Errorf("read_buffer and max_bytes must not be negative")
+	}
+	if c.ReadBuffer > 0 {
+		base.ReadBuffer = c.ReadBuffer
+	}
+	if c.MaxBytes > 0 {
+		base.MaxBytes = c.MaxBytes
+	}
+	return base, nil
+}
+
+// HandlerBudget returns how long the handler of a session may run before
+// the server gives up on it: HandlerTimeout, or by default SessionTimeout
+// plus a minute, leaving handlers that honour their read deadlines time to
+// finish on their own.
+func (l SessionLimits) HandlerBudget() time.Duration {
+	if l.HandlerTimeout > 0 {
+		return l.HandlerTimeout
+	}
+	return l.SessionTimeout + time.Minute
+}
+
+// NextReadDeadline returns the deadline for the next read of the session:
+// ConnectTimeout or IdleTimeout from now, depending on whether the client has
+// sent anything yet, but no later than SessionTimeout after it connected.
+func (m *ConnMeta) NextReadDeadline(received bool) time.Time {
+	timeout := m.Limits.IdleTimeout
+	if !received {
+		timeout = m.Limits.ConnectTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if end := m.Started.Add(m.Limits.SessionTimeout); end.Before(deadline) {
+		return end
+	}
+	return deadline
+}
+
+// SessionExpired reports whether the session has lasted SessionTimeout.
+func (m *ConnMeta) SessionExpired() bool {
+	return time.Since(m.Started) >= m.Limits.SessionTimeout
+}
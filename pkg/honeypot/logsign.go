@@ -0,0 +1,304 @@
+package honeypot
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LogSigningMethods are the ways a LogFileOutput signs its lines: an
+// HMAC-SHA256 chain whose key moves forward every day, or Ed25519
+// signatures anyone with the public key can check.
+var LogSigningMethods = []string{"hmac", "ed25519"}
+
+// logSigMarker separates a signed line of the text log from its signature.
+const logSigMarker = " sig="
+
+// LogSigningConfig makes the text log tamper-evident, see
+// LogFileOutput.EnableSigning.
+type LogSigningConfig struct {
+	Method string `json:"method"` // one of LogSigningMethods, empty to disable
+	Key    string `json:"key"`    // file of the hex HMAC key, replaced daily, or of the PEM PKCS #8 Ed25519 private key
+}
+
+// logSigner signs the lines of a log, each signature covering the
+// previous one.
+type logSigner struct {
+	method  string
+	keyPath string
+	key     []byte             // HMAC key of the current epoch
+	epoch   int                // HMAC key updates since the initial key
+	private ed25519.PrivateKey // with ed25519
+	prev    []byte             // signature of the last line
+}
+
+func newLogSigner(cfg LogSigningConfig) (*logSigner, error) {
+	s := &logSigner{method: cfg.Method, keyPath: cfg.Key}
+	content, err := os.ReadFile(cfg.Key)
+	if err != nil {
+		return nil, fmt.Errorf("log signing key: %w", err)
+	}
+	switch cfg.Method {
+	case "hmac":
+		if s.key, s.epoch, err = parseLogHMACKey(content); err != nil {
+			return nil, fmt.Errorf("log signing key %s: %w", cfg.Key, err)
+		}
+	case "ed25519":
+		block, _ := pem.Decode(content)
+		if block == nil {
+			return nil, fmt.Errorf("log signing key %s: no PEM block", cfg.Key)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		private, ok := key.(ed25519.PrivateKey)
+		if err != nil || !ok {
+			return nil, fmt.Errorf("log signing key %s: not a PKCS #8 Ed25519 private key", cfg.Key)
+		}
+		s.private = private
+	default:
+		return nil, fmt.Errorf("unknown log signing method %q, want %s", cfg.Method, strings.Join(LogSigningMethods, " or "))
+	}
+	return s, nil
+}
+
+// parseLogHMACKey parses an HMAC key file: the key in hex, optionally
+// followed by the number of updates it went through.
+func parseLogHMACKey(content []byte) ([]byte, int, error) {
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, 0, errors.New("want the key in hex, optionally followed by its epoch")
+	}
+	key, err := hex.DecodeString(fields[0])
+	if err != nil || len(key) < 16 {
+		return nil, 0, errors.New("want a key of at least 16 bytes in hex")
+	}
+	epoch := 0
+	if len(fields) == 2 {
+		if epoch, err = strconv.Atoi(fields[1]); err != nil || epoch < 0 {
+			return nil, 0, fmt.Errorf("invalid epoch %q", fields[1])
+		}
+	}
+	return key, epoch, nil
+}
+
+// nextLogKey returns the HMAC key following key, from which key cannot be
+// recovered.
+func nextLogKey(key []byte) []byte {
+	sum := sha256.Sum256(append([]byte("gopot log key\x00"), key...))
+	return sum[:]
+}
+
+// sign returns the signature suffix of line, chained to the previous one.
+func (s *logSigner) sign(line string) string {
+	msg := append(append([]byte(nil), s.prev...), line...)
+	var sig []byte
+	if s.method == "hmac" {
+		mac := hmac.New(sha256.New, s.key)
+		mac.Write(msg)
+		sig = mac.Sum(nil)
+	} else {
+		sig = ed25519.Sign(s.private, msg)
+	}
+	s.prev = sig
+	return logSigMarker + strconv.Itoa(s.epoch) + ":" + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// advance replaces the HMAC key with the next one, in memory and in its
+// file, so the lines signed so far can no longer be forged with what the
+// sensor holds.
+func (s *logSigner) advance() error {
+	if s.method != "hmac" {
+		return nil
+	}
+	s.key, s.epoch = nextLogKey(s.key), s.epoch+1
+	f, err := os.OpenFile(s.keyPath, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return fmt.Errorf("updating log signing key: %w", err)
+	}
+	_, err = fmt.Fprintf(f, "%s %d\n", hex.EncodeToString(s.key), s.epoch)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("updating log signing key: %w", err)
+	}
+	return nil
+}
+
+// splitLogSignature splits a signed line into its text and the epoch and
+// signature of its suffix.
+func splitLogSignature(line string) (string, int, []byte, bool) {
+	i := strings.LastIndex(line, logSigMarker)
+	if i < 0 {
+		return line, 0, nil, false
+	}
+	epoch, encoded, ok := strings.Cut(line[i+len(logSigMarker):], ":")
+	if !ok {
+		return line, 0, nil, false
+	}
+	n, err := strconv.Atoi(epoch)
+	sig, err2 := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || err2 != nil || n < 0 {
+		return line, 0, nil, false
+	}
+	return line[:i], n, sig, true
+}
+
+// lastLogSignature returns the signature of the last signed line of the
+// log in dir: of log.txt, or else of its latest daily archive.
+func lastLogSignature(dir string) []byte {
+	archives, _ := filepath.Glob(filepath.Join(dir, "log-*.txt"))
+	sort.Strings(archives)
+	slices.Reverse(archives)
+	for _, path := range append([]string{filepath.Join(dir, "log.txt")}, archives...) {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+		for i := len(lines) - 1; i >= 0; i-- {
+			if _, _, sig, ok := splitLogSignature(lines[i]); ok {
+				return sig
+			}
+		}
+	}
+	return nil
+}
+
+// LogVerification is the outcome of VerifyLog.
+type LogVerification struct {
+	Files    int      // files read
+	Lines    int      // lines whose signature holds
+	Unsigned int      // lines before the first signed one, written before signing was enabled
+	Problems []string // lines failing verification, by file and line number
+}
+
+// VerifyLog checks the signature chain of the text log files given in
+// order, oldest first, with the key in keyPath: the initial HMAC key or a
+// later one, or the PEM Ed25519 public or private key. Every line must carry
+// a valid signature covering the previous one, so a line altered, inserted,
+// deleted or reordered breaks the chain; the check resumes after each break
+// so it is reported once. Lines deleted by a purge show up as breaks too.
+// The first line checked must start the chain, so the files must go back
+// to when signing was enabled; the deletion of the last lines of the last
+// file is not detected, since nothing follows them.
+//
+// With HMAC, the lines of a file share one epoch: that of the key given
+// for the first file signed, and one more for each file after it, since
+// the key moves on at every daily rotation. A file deleted from the list,
+// or lines signed with a later key, such as the one a compromised sensor
+// holds, break the chain.
+func VerifyLog(method, keyPath string, files []string) (*LogVerification, error) {
+	content, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		hmacKey []byte
+		epoch   int
+		public  ed25519.PublicKey
+	)
+	switch method {
+	case "hmac":
+		if hmacKey, epoch, err = parseLogHMACKey(content); err != nil {
+			return nil, fmt.Errorf("%s: %w", keyPath, err)
+		}
+	case "ed25519":
+		block, _ := pem.Decode(content)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no PEM block", keyPath)
+		}
+		if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+			public, _ = key.(ed25519.PublicKey)
+		} else if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			if private, ok := key.(ed25519.PrivateKey); ok {
+				public = private.Public().(ed25519.PublicKey)
+			}
+		}
+		if public == nil {
+			return nil, fmt.Errorf("%s: not an Ed25519 public or private key", keyPath)
+		}
+	default:
+		return nil, fmt.Errorf("unknown log signing method %q, want %s", method, strings.Join(LogSigningMethods, " or "))
+	}
+
+	v := &LogVerification{}
+	var (
+		prev      []byte
+		signed    bool // a signed line was seen
+		fileEpoch = epoch
+	)
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return v, err
+		}
+		v.Files++
+		if signed {
+			fileEpoch++
+		}
+		wrongEpoch := false // reported for the file
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64*1024), 16<<20)
+		for n := 1; sc.Scan(); n++ {
+			problem := func(format string, args ...any) {
+				v.Problems = append(v.Problems, fmt.Sprintf("%s:%d: ", file, n)+fmt.Sprintf(format, args...))
+			}
+			text, lineEpoch, sig, ok := splitLogSignature(sc.Text())
+			switch {
+			case !ok && !signed:
+				v.Unsigned++
+				continue
+			case !ok:
+				problem("unsigned line")
+				continue
+			case method == "hmac" && lineEpoch != fileEpoch:
+				if !wrongEpoch {
+					problem("signed with the key of epoch %d where the file should have epoch %d: a file is missing, or lines were signed with another key", lineEpoch, fileEpoch)
+				}
+				signed, prev, wrongEpoch = true, sig, true
+				continue
+			}
+			msg := append(append([]byte(nil), prev...), text...)
+			var valid bool
+			if method == "hmac" {
+				for epoch < fileEpoch {
+					hmacKey, epoch = nextLogKey(hmacKey), epoch+1
+				}
+				mac := hmac.New(sha256.New, hmacKey)
+				mac.Write(msg)
+				valid = hmac.Equal(mac.Sum(nil), sig)
+			} else {
+				valid = ed25519.Verify(public, msg, sig)
+			}
+			if valid {
+				v.Lines++
+			} else if !signed {
+				problem("signature does not match: the line was altered, or does not start the chain")
+			} else {
+				problem("signature does not match: the line was altered, or lines before it were altered, deleted or reordered")
+			}
+			signed, prev = true, sig
+		}
+		f.Close()
+		if err := sc.Err(); err != nil {
+			return v, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return v, nil
+}
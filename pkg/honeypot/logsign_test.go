@@ -0,0 +1,76 @@
+package honeypot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestVerifyLogEpochs checks that VerifyLog ties the HMAC epoch of each
+// daily file to its place in the chain.
+func TestVerifyLogEpochs(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		forged   int   // key updates an intruder went through before signing every file
+		advances []int // key updates before each file after the first
+		lines    []int // lines of each file
+		problems int
+	}{
+		{name: "correct chain", advances: []int{1, 1, 1}, lines: []int{3, 2, 4, 1}},
+		{name: "day without events", advances: []int{1, 1}, lines: []int{3, 0, 2}},
+		// The key of the last day signs that day, and only that day
+		{name: "forged with a later key", forged: 3, lines: []int{3, 2, 4, 1}, problems: 3},
+		{name: "skipped epoch", advances: []int{1, 2}, lines: []int{3, 2, 2}, problems: 1},
+		{name: "repeated epoch", advances: []int{1, 0}, lines: []int{3, 2, 2}, problems: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			initial := strings.Repeat("ab", 32)
+			keyPath := filepath.Join(dir, "log.key")
+			if err := os.WriteFile(keyPath, []byte(initial+"\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			signer, err := newLogSigner(LogSigningConfig{Method: "hmac", Key: keyPath})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tc.forged; i++ {
+				signer.key, signer.epoch = nextLogKey(signer.key), signer.epoch+1
+			}
+			var files []string
+			for day, lines := range tc.lines {
+				if day > 0 && tc.forged == 0 {
+					for i := 0; i < tc.advances[day-1]; i++ {
+						if err := signer.advance(); err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+				var b strings.Builder
+				for i := 0; i < lines; i++ {
+					line := fmt.Sprintf("2026/10/%02d 12:00:%02d Received connection on port 23", day+1, i)
+					b.WriteString(line + signer.sign(line) + "\n")
+				}
+				path := filepath.Join(dir, fmt.Sprintf("log-2026-10-%02d.txt", day+1))
+				if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				files = append(files, path)
+			}
+
+			initialPath := filepath.Join(dir, "log.key.initial")
+			if err := os.WriteFile(initialPath, []byte(initial+"\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			v, err := VerifyLog("hmac", initialPath, files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(v.Problems) != tc.problems {
+				t.Fatalf("got %d problems, want %d: %q", len(v.Problems), tc.problems, v.Problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package honeypot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigHashAnnotation is the annotation of the pod template holding the
+// hash of the configuration, so applying a changed manifest rolls the pods.
+const ConfigHashAnnotation = "gopot/config-hash"
+
+// manifestConfigDir is where the configuration is mounted in the container.
+const manifestConfigDir = "/etc/gopot"
+
+// ManifestOptions describe the workload KubernetesManifest generates.
+type ManifestOptions struct {
+	Name        string   // of the Deployment, its configuration and the Service of ports without one of their own, default "gopot"
+	Namespace   string   // empty for the namespace of the kubectl context
+	Image       string   // container image, required
+	Replicas    int      // pods, default 1
+	ServiceType string   // type of the Services, default "LoadBalancer"
+	Secret      bool     // ship the configuration as a Secret rather than a ConfigMap, for configurations holding API keys
+	Args        []string // further arguments of the container, such as -handler-map
+}
+
+// manifestPort is a port exposed by a Service.
+type manifestPort struct {
+	protocol string // "TCP" or "UDP"
+	port     int    // of the Service
+	target   int    // of the container
+}
+
+// KubernetesManifest returns the manifests running cfg in a cluster, as
+// YAML documents: the configuration, as a ConfigMap or Secret, a Deployment
+// whose pods mount it, run in Kubernetes mode and are probed on its probe
+// endpoints, and the Services exposing ports, which are those of cfg as
+// expanded into ports, with their handler's transports. The ports of
+// kubernetes.services get a Service each; the others share the Service
+// named after the workload. The pod template is annotated with the hash of
+// the configuration, see ConfigHashAnnotation.
+//
+// The configuration is cfg as loaded, with its environment applied, so
+// settings given by flags or environment variables are part of it. Files
+// it refers to, such as the watchlist or plugins, must be in the image.
+func KubernetesManifest(cfg *Config, ports map[string]*PortOptions, opts ManifestOptions) (string, error) {
+	if opts.Image == "" {
+		return "", errors.New("an image is required")
+	}
+	if opts.Name == "" {
+		opts.Name = "gopot"
+	}
+	if opts.Replicas == 0 {
+		opts.Replicas = 1
+	}
+	if opts.ServiceType == "" {
+		opts.ServiceType = "LoadBalancer"
+	}
+	switch opts.ServiceType {
+	case "ClusterIP", "NodePort", "LoadBalancer":
+	default:
+		return "", fmt.Errorf("invalid service type %q, want ClusterIP, NodePort or LoadBalancer", opts.ServiceType)
+	}
+	if opts.Replicas < 0 {
+		return "", errors.New("replicas cannot be negative")
+	}
+	_, probePort, err := net.SplitHostPort(cfg.Kubernetes.ProbeAddr())
+	if err != nil {
+		return "", fmt.Errorf("invalid probe address %q: %w", cfg.Kubernetes.ProbeAddr(), err)
+	}
+
+	shipped := *cfg
+	shipped.Environment, shipped.Environments = "", nil
+	shipped.Kubernetes.Enabled = true
+	content, err := json.MarshalIndent(&shipped, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	// Group the ports by Service
+	services := map[string][]manifestPort{opts.Name: nil}
+	var container []manifestPort
+	for port, po := range ports {
+		target, _ := strconv.Atoi(port)
+		public := target
+		if po.ServicePort != "" {
+			public, _ = strconv.Atoi(po.ServicePort)
+		}
+		service := opts.Name
+		if name := po.Labels["k8s_service"]; name != "" {
+			service = name
+		}
+		name := po.Handler
+		if name == "" {
+			name = cfg.DefaultHandler
+		}
+		_, stream := LookupHandler(name)
+		_, packet := LookupPacketHandler(name)
+		for _, protocol := range []string{"TCP", "UDP"} {
+			if (protocol == "TCP" && (stream || !packet)) || (protocol == "UDP" && packet) {
+				services[service] = append(services[service], manifestPort{protocol, public, target})
+				container = append(container, manifestPort{protocol, target, target})
+			}
+		}
+	}
+	sortManifestPorts(container)
+
+	kind, volume := "ConfigMap", "configMap: {name: "+quoteYAML(opts.Name+"-config")+"}"
+	if opts.Secret {
+		kind, volume = "Secret", "secret: {secretName: "+quoteYAML(opts.Name+"-config")+"}"
+	}
+	var b strings.Builder
+	metadata := func(name string) {
+		fmt.Fprintf(&b, "metadata:\n  name: %s\n", quoteYAML(name))
+		if opts.Namespace != "" {
+			fmt.Fprintf(&b, "  namespace: %s\n", quoteYAML(opts.Namespace))
+		}
+		fmt.Fprintf(&b, "  labels: {app.kubernetes.io/name: gopot, app.kubernetes.io/instance: %s}\n", quoteYAML(opts.Name))
+	}
+
+	fmt.Fprintf(&b, "apiVersion: v1\nkind: %s\n", kind)
+	metadata(opts.Name + "-config")
+	if opts.Secret {
+		b.WriteString("stringData:\n")
+	} else {
+		b.WriteString("data:\n")
+	}
+	b.WriteString("  gopot.json: |\n")
+	for _, line := range strings.Split(string(content), "\n") {
+		b.WriteString("    " + line + "\n")
+	}
+
+	b.WriteString("---\napiVersion: apps/v1\nkind: Deployment\n")
+	metadata(opts.Name)
+	selector := fmt.Sprintf("{app.kubernetes.io/name: gopot, app.kubernetes.io/instance: %s}", quoteYAML(opts.Name))
+	fmt.Fprintf(&b, "spec:\n  replicas: %d\n  selector:\n    matchLabels: %s\n", opts.Replicas, selector)
+	fmt.Fprintf(&b, "  template:\n    metadata:\n      labels: %s\n      annotations:\n        %s: %s\n", selector, ConfigHashAnnotation, quoteYAML("sha256:"+hash))
+	b.WriteString("    spec:\n      containers:\n        - name: gopot\n")
+	fmt.Fprintf(&b, "          image: %s\n", quoteYAML(opts.Image))
+	args := append([]string{"-config", manifestConfigDir + "/gopot.json"}, opts.Args...)
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteYAML(arg)
+	}
+	fmt.Fprintf(&b, "          args: [%s]\n", strings.Join(quoted, ", "))
+	b.WriteString("          env:\n" +
+		"            - {name: POD_NAME, valueFrom: {fieldRef: {fieldPath: metadata.name}}}\n" +
+		"            - {name: POD_NAMESPACE, valueFrom: {fieldRef: {fieldPath: metadata.namespace}}}\n" +
+		"            - {name: NODE_NAME, valueFrom: {fieldRef: {fieldPath: spec.nodeName}}}\n" +
+		"            - {name: POD_IP, valueFrom: {fieldRef: {fieldPath: status.podIP}}}\n")
+	if len(container) > 0 {
+		b.WriteString("          ports:\n")
+		for _, p := range container {
+			fmt.Fprintf(&b, "            - {name: %s, containerPort: %d, protocol: %s}\n", p.name(), p.target, p.protocol)
+		}
+	}
+	fmt.Fprintf(&b, "          livenessProbe: {httpGet: {path: /livez, port: %s}}\n", probePort)
+	fmt.Fprintf(&b, "          readinessProbe: {httpGet: {path: /readyz, port: %s}}\n", probePort)
+	capabilities := "drop: [ALL]"
+	for _, p := range container {
+		if p.target < 1024 {
+			capabilities += ", add: [NET_BIND_SERVICE]"
+			break
+		}
+	}
+	fmt.Fprintf(&b, "          securityContext: {allowPrivilegeEscalation: false, capabilities: {%s}}\n", capabilities)
+	fmt.Fprintf(&b, "          volumeMounts:\n            - {name: config, mountPath: %s, readOnly: true}\n", manifestConfigDir)
+	fmt.Fprintf(&b, "      volumes:\n        - {name: config, %s}\n", volume)
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		exposed := services[name]
+		if len(exposed) == 0 {
+			continue
+		}
+		sortManifestPorts(exposed)
+		b.WriteString("---\napiVersion: v1\nkind: Service\n")
+		metadata(name)
+		fmt.Fprintf(&b, "spec:\n  type: %s\n", opts.ServiceType)
+		if opts.ServiceType != "ClusterIP" {
+			b.WriteString("  externalTrafficPolicy: Local # keeps the addresses of clients\n")
+		}
+		fmt.Fprintf(&b, "  selector: %s\n  ports:\n", selector)
+		for _, p := range exposed {
+			fmt.Fprintf(&b, "    - {name: %s, port: %d, targetPort: %d, protocol: %s}\n", p.name(), p.port, p.target, p.protocol)
+		}
+	}
+	return b.String(), nil
+}
+
+// name returns the name of the port in a Service or container, unique
+// within either.
+func (p manifestPort) name() string {
+	return strings.ToLower(p.protocol) + "-" + strconv.Itoa(p.port)
+}
+
+// sortManifestPorts sorts ports by number, TCP first.
+func sortManifestPorts(ports []manifestPort) {
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].port != ports[j].port {
+			return ports[i].port < ports[j].port
+		}
+		return ports[i].protocol < ports[j].protocol
+	})
+}
+
+// quoteYAML quotes s as a YAML string; JSON strings are YAML.
+func quoteYAML(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
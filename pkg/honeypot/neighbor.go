@@ -0,0 +1,81 @@
+package honeypot
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// DecoyAddressConfig lets one sensor impersonate several hosts of a LAN:
+// GoPot answers ARP and NDP for unused addresses of the subnet and serves
+// connections to each as a host of its own profile.
+type DecoyAddressConfig struct {
+	Interface string            `json:"interface,omitempty"` // interface the addresses are announced on, default the one on their subnet
+	Hosts     []DecoyHostConfig `json:"hosts"`               // additional addresses and the hosts impersonated on them
+}
+
+// AnnounceDecoys answers ARP requests and IPv6 neighbor solicitations for
+// the addresses of Decoys on iface, or the interface on their subnet if
+// iface is empty, with the interface's MAC address until Shutdown, after
+// announcing them with gratuitous ARP and unsolicited neighbor
+// advertisements. The kernel must still accept packets to the addresses,
+// e.g. through a local route. Announcing needs raw socket privileges
+// (CAP_NET_RAW) and is only supported on Linux.
+func (s *Server) AnnounceDecoys(iface string) error {
+	if len(s.Decoys) == 0 {
+		return errors.New("no decoy addresses to announce")
+	}
+	var ifi *net.Interface
+	var err error
+	if iface != "" {
+		ifi, err = net.InterfaceByName(iface)
+	} else {
+		ifi, err = decoyInterface(s.Decoys)
+	}
+	if err != nil {
+		return err
+	}
+	if len(ifi.HardwareAddr) != 6 {
+		return fmt.Errorf("interface %s has no Ethernet address", ifi.Name)
+	}
+	addrs := make([]netip.Addr, 0, len(s.Decoys))
+	for addr := range s.Decoys {
+		addrs = append(addrs, addr)
+	}
+	if err := announceNeighbors(s.ctx, ifi, addrs, s.logf); err != nil {
+		return fmt.Errorf("announcing decoy addresses on %s: %w", ifi.Name, err)
+	}
+	s.logf(EventInfo, "Answering ARP and NDP for %d decoy addresses on %s", len(addrs), ifi.Name)
+	return nil
+}
+
+// decoyInterface returns the interface with an address on the subnet of
+// one of the decoy addresses.
+func decoyInterface(decoys map[netip.Addr]*DecoyHost) (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagLoopback != 0 || ifi.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			for addr := range decoys {
+				if ipnet.Contains(addr.AsSlice()) {
+					return &ifi, nil
+				}
+			}
+		}
+	}
+	return nil, errors.New("no interface on the subnet of the decoy addresses, set an interface")
+}
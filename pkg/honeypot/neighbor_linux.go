@@ -0,0 +1,261 @@
+//go:build linux
+
+package honeypot
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+const (
+	icmpv6NeighborSolicitation  = 135
+	icmpv6NeighborAdvertisement = 136
+)
+
+// announceNeighbors starts answering ARP and NDP for addrs on ifi, after
+// announcing them, until ctx is done. Errors answering are logged with logf.
+func announceNeighbors(ctx context.Context, ifi *net.Interface, addrs []netip.Addr, logf func(string, string, ...any)) error {
+	owned := make(map[netip.Addr]bool, len(addrs))
+	var v4, v6 []netip.Addr
+	for _, addr := range addrs {
+		owned[addr] = true
+		if addr.Is4() {
+			v4 = append(v4, addr)
+		} else {
+			v6 = append(v6, addr)
+		}
+	}
+	mac := ifi.HardwareAddr
+	if len(v4) > 0 {
+		fd, err := neighborSocket(ifi, syscall.ETH_P_ARP)
+		if err != nil {
+			return err
+		}
+		for _, addr := range v4 {
+			if err := sendFrame(fd, ifi, arpPacket(mac, broadcastMAC, 1, mac, addr, nil, addr)); err != nil {
+				syscall.Close(fd)
+				return fmt.Errorf("announcing %s: %w", addr, err)
+			}
+		}
+		go serveNeighbors(ctx, fd, ifi, logf, func(frame []byte) []byte { return arpReply(frame, mac, owned) })
+	}
+	if len(v6) > 0 {
+		fd, err := neighborSocket(ifi, syscall.ETH_P_IPV6)
+		if err != nil {
+			return err
+		}
+		for _, addr := range v6 {
+			// Neighbor solicitations go to the solicited-node multicast group
+			group := [6]byte{0x33, 0x33, 0xff}
+			copy(group[3:], addr.AsSlice()[13:])
+			if err := joinMulticast(fd, ifi, group[:]); err != nil {
+				syscall.Close(fd)
+				return fmt.Errorf("joining the solicited-node group of %s: %w", addr, err)
+			}
+			if err := sendFrame(fd, ifi, neighborAdvertisement(mac, allNodesMAC, addr, allNodes, false)); err != nil {
+				syscall.Close(fd)
+				return fmt.Errorf("announcing %s: %w", addr, err)
+			}
+		}
+		go serveNeighbors(ctx, fd, ifi, logf, func(frame []byte) []byte { return ndpReply(frame, mac, owned) })
+	}
+	return nil
+}
+
+var (
+	broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	allNodesMAC  = net.HardwareAddr{0x33, 0x33, 0, 0, 0, 1}
+	allNodes     = netip.MustParseAddr("ff02::1")
+)
+
+// neighborSocket opens a packet socket receiving the Ethernet frames of
+// one protocol on ifi.
+func neighborSocket(ifi *net.Interface, protocol uint16) (int, error) {
+	proto := htons(protocol)
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(proto))
+	if err != nil {
+		return -1, fmt.Errorf("opening packet socket: %w", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: proto, Ifindex: ifi.Index}); err != nil {
+		syscall.Close(fd)
+		return -1, fmt.Errorf("binding to %s: %w", ifi.Name, err)
+	}
+	// Wake up regularly to notice the shutdown
+	tv := syscall.NsecToTimeval(int64(500 * time.Millisecond))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// joinMulticast makes ifi receive the frames sent to a multicast MAC address.
+func joinMulticast(fd int, ifi *net.Interface, mac []byte) error {
+	// struct packet_mreq: ifindex, type, address length, address
+	mreq := make([]byte, 16)
+	binary.NativeEndian.PutUint32(mreq[0:], uint32(ifi.Index))
+	binary.NativeEndian.PutUint16(mreq[4:], syscall.PACKET_MR_MULTICAST)
+	binary.NativeEndian.PutUint16(mreq[6:], uint16(len(mac)))
+	copy(mreq[8:], mac)
+	return syscall.SetsockoptString(fd, syscall.SOL_PACKET, syscall.PACKET_ADD_MEMBERSHIP, string(mreq))
+}
+
+// serveNeighbors sends the replies answer returns for the frames read from
+// fd until ctx is done, then closes fd.
+func serveNeighbors(ctx context.Context, fd int, ifi *net.Interface, logf func(string, string, ...any), answer func([]byte) []byte) {
+	defer syscall.Close(fd)
+	buf := make([]byte, 1514)
+	for ctx.Err() == nil {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			logf(EventError, "Reading neighbor requests on %s: %s", ifi.Name, err)
+			return
+		}
+		if reply := answer(buf[:n]); reply != nil {
+			if err := sendFrame(fd, ifi, reply); err != nil {
+				logf(EventError, "Answering a neighbor request on %s: %s", ifi.Name, err)
+			}
+		}
+	}
+}
+
+func sendFrame(fd int, ifi *net.Interface, frame []byte) error {
+	addr := &syscall.SockaddrLinklayer{Ifindex: ifi.Index, Halen: 6}
+	copy(addr.Addr[:], frame[:6])
+	return syscall.Sendto(fd, frame, 0, addr)
+}
+
+// ethernetFrame prepends an Ethernet header to payload.
+func ethernetFrame(dst, src net.HardwareAddr, etherType uint16, payload []byte) []byte {
+	frame := make([]byte, 14, 14+len(payload))
+	copy(frame[0:], dst)
+	copy(frame[6:], src)
+	binary.BigEndian.PutUint16(frame[12:], etherType)
+	return append(frame, payload...)
+}
+
+// arpPacket builds an Ethernet ARP request (op 1) or reply (op 2).
+func arpPacket(src, dst net.HardwareAddr, op uint16, senderMAC net.HardwareAddr, senderIP netip.Addr, targetMAC net.HardwareAddr, targetIP netip.Addr) []byte {
+	arp := make([]byte, 28)
+	binary.BigEndian.PutUint16(arp[0:], 1) // Ethernet
+	binary.BigEndian.PutUint16(arp[2:], syscall.ETH_P_IP)
+	arp[4], arp[5] = 6, 4
+	binary.BigEndian.PutUint16(arp[6:], op)
+	copy(arp[8:], senderMAC)
+	copy(arp[14:], senderIP.AsSlice())
+	copy(arp[18:], targetMAC)
+	copy(arp[24:], targetIP.AsSlice())
+	return ethernetFrame(dst, src, syscall.ETH_P_ARP, arp)
+}
+
+// arpReply returns the reply to an ARP request frame for one of the owned
+// addresses, or nil.
+func arpReply(frame []byte, mac net.HardwareAddr, owned map[netip.Addr]bool) []byte {
+	if len(frame) < 14+28 {
+		return nil
+	}
+	arp := frame[14:]
+	if binary.BigEndian.Uint16(arp[0:]) != 1 || binary.BigEndian.Uint16(arp[2:]) != syscall.ETH_P_IP ||
+		arp[4] != 6 || arp[5] != 4 || binary.BigEndian.Uint16(arp[6:]) != 1 {
+		return nil
+	}
+	target := netip.AddrFrom4([4]byte(arp[24:28]))
+	if !owned[target] {
+		return nil
+	}
+	senderMAC := net.HardwareAddr(arp[8:14])
+	if senderMAC.String() == mac.String() {
+		return nil
+	}
+	sender := netip.AddrFrom4([4]byte(arp[14:18]))
+	return arpPacket(mac, senderMAC, 2, mac, target, senderMAC, sender)
+}
+
+// ndpReply returns the neighbor advertisement answering a neighbor
+// solicitation frame for one of the owned addresses, or nil.
+func ndpReply(frame []byte, mac net.HardwareAddr, owned map[netip.Addr]bool) []byte {
+	if len(frame) < 14+40+24 {
+		return nil
+	}
+	ip := frame[14:]
+	if ip[0]>>4 != 6 || ip[6] != syscall.IPPROTO_ICMPV6 || ip[7] != 255 {
+		return nil
+	}
+	icmp := ip[40:]
+	if end := 40 + int(binary.BigEndian.Uint16(ip[4:])); end <= len(ip) {
+		icmp = ip[40:end]
+	}
+	if len(icmp) < 24 || icmp[0] != icmpv6NeighborSolicitation || icmp[1] != 0 {
+		return nil
+	}
+	target := netip.AddrFrom16([16]byte(icmp[8:24]))
+	if !owned[target] {
+		return nil
+	}
+	src := netip.AddrFrom16([16]byte(ip[8:24]))
+	if src.IsUnspecified() {
+		// Duplicate address detection: defend the address to all nodes
+		return neighborAdvertisement(mac, allNodesMAC, target, allNodes, false)
+	}
+	dstMAC := net.HardwareAddr(frame[6:12])
+	for opts := icmp[24:]; len(opts) >= 8 && opts[1] > 0 && len(opts) >= int(opts[1])*8; opts = opts[int(opts[1])*8:] {
+		if opts[0] == 1 { // source link-layer address
+			dstMAC = net.HardwareAddr(opts[2:8])
+		}
+	}
+	return neighborAdvertisement(mac, dstMAC, target, src, true)
+}
+
+// neighborAdvertisement builds an Ethernet frame advertising that target
+// is at mac, sent from target to dst.
+func neighborAdvertisement(mac, dstMAC net.HardwareAddr, target, dst netip.Addr, solicited bool) []byte {
+	icmp := make([]byte, 32)
+	icmp[0] = icmpv6NeighborAdvertisement
+	icmp[4] = 0x20 // override
+	if solicited {
+		icmp[4] |= 0x40
+	}
+	copy(icmp[8:], target.AsSlice())
+	icmp[24], icmp[25] = 2, 1 // target link-layer address, 8 bytes
+	copy(icmp[26:], mac)
+
+	ip := make([]byte, 40, 40+len(icmp))
+	ip[0] = 6 << 4
+	binary.BigEndian.PutUint16(ip[4:], uint16(len(icmp)))
+	ip[6], ip[7] = syscall.IPPROTO_ICMPV6, 255
+	copy(ip[8:], target.AsSlice())
+	copy(ip[24:], dst.AsSlice())
+	binary.BigEndian.PutUint16(icmp[2:], icmpv6Checksum(ip[8:24], ip[24:40], icmp))
+	return ethernetFrame(dstMAC, mac, syscall.ETH_P_IPV6, append(ip, icmp...))
+}
+
+// icmpv6Checksum computes the checksum of an ICMPv6 message over the IPv6
+// pseudo-header.
+func icmpv6Checksum(src, dst, msg []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(b[i])<<8 | uint32(b[i+1])
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(src)
+	add(dst)
+	sum += uint32(len(msg)) + syscall.IPPROTO_ICMPV6
+	add(msg)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package honeypot
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+)
+
+// announceNeighbors is only implemented on Linux.
+func announceNeighbors(ctx context.Context, ifi *net.Interface, addrs []netip.Addr, logf func(string, string, ...any)) error {
+	return errors.New("announcing decoy addresses is only supported on Linux")
+}
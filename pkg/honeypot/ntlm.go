@@ -0,0 +1,196 @@
+package honeypot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// NTLM message types (MS-NLMP 2.2.1).
+const (
+	ntlmNegotiate    = 1
+	ntlmChallenge    = 2
+	ntlmAuthenticate = 3
+)
+
+// ntlmServerFlags are the flags of a Windows server's CHALLENGE: Unicode,
+// target info, extended session security, 128- and 56-bit keys, ...
+const ntlmServerFlags = 0xE28A8215
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// ntlmMessageType returns the type of an NTLM message, 0 if msg is none.
+func ntlmMessageType(msg []byte) uint32 {
+	if len(msg) < 12 || !bytes.HasPrefix(msg, ntlmSignature) {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(msg[8:12])
+}
+
+// ntlmChallengeMessage returns the CHALLENGE of the impersonated host: its
+// NetBIOS and DNS names and Windows version, which scanners like nmap's
+// rdp-ntlm-info and smb-os-discovery report.
+func ntlmChallengeMessage(id *HostIdentity, challenge [8]byte) []byte {
+	domain := strings.ToUpper(strings.SplitN(id.Domain, ".", 2)[0])
+	computer := id.SMBHostname()
+	var info []byte
+	avPair := func(typ uint16, value []byte) {
+		info = binary.LittleEndian.AppendUint16(info, typ)
+		info = binary.LittleEndian.AppendUint16(info, uint16(len(value)))
+		info = append(info, value...)
+	}
+	avPair(2, utf16LE(domain))   // MsvAvNbDomainName
+	avPair(1, utf16LE(computer)) // MsvAvNbComputerName
+	avPair(4, utf16LE(id.Domain))
+	avPair(3, utf16LE(strings.ToLower(id.Hostname)+"."+id.Domain))
+	avPair(5, utf16LE(id.Domain)) // MsvAvDnsTreeName
+	avPair(7, binary.LittleEndian.AppendUint64(nil, fileTime(time.Now())))
+	avPair(0, nil) // MsvAvEOL
+
+	target := utf16LE(domain)
+	const headerLen = 56
+	msg := append([]byte(nil), ntlmSignature...)
+	msg = binary.LittleEndian.AppendUint32(msg, ntlmChallenge)
+	msg = appendNTLMField(msg, len(target), headerLen)
+	msg = binary.LittleEndian.AppendUint32(msg, ntlmServerFlags)
+	msg = append(msg, challenge[:]...)
+	msg = append(msg, make([]byte, 8)...) // reserved
+	msg = appendNTLMField(msg, len(info), headerLen+len(target))
+	msg = append(msg, ntlmVersion(id)...)
+	msg = append(msg, target...)
+	return append(msg, info...)
+}
+
+// ntlmVersion returns the VERSION structure of the impersonated Windows
+// release, Windows Server 2019 for hosts that are not Windows.
+func ntlmVersion(id *HostIdentity) []byte {
+	major, minor, build := 10, 0, 17763
+	if id.IsWindows() {
+		parts := strings.Split(id.Kernel, ".")
+		if len(parts) == 3 {
+			major, _ = strconv.Atoi(parts[0])
+			minor, _ = strconv.Atoi(parts[1])
+			build, _ = strconv.Atoi(parts[2])
+		}
+	}
+	version := []byte{byte(major), byte(minor)}
+	version = binary.LittleEndian.AppendUint16(version, uint16(build))
+	return append(version, 0, 0, 0, 15) // reserved, NTLMSSP_REVISION_W2K3
+}
+
+func appendNTLMField(msg []byte, length, offset int) []byte {
+	msg = binary.LittleEndian.AppendUint16(msg, uint16(length))
+	msg = binary.LittleEndian.AppendUint16(msg, uint16(length))
+	return binary.LittleEndian.AppendUint32(msg, uint32(offset))
+}
+
+// ntlmNegotiateFlagVersion is set in NEGOTIATE messages carrying the
+// client's Windows version.
+const ntlmNegotiateFlagVersion = 0x02000000
+
+// ntlmNegotiateInfo is what a NEGOTIATE message reveals about the client.
+type ntlmNegotiateInfo struct {
+	Flags               uint32
+	Domain, Workstation string // OEM names, which few clients send
+	Version             string // Windows version of the client, e.g. "10.0.19041", empty if not sent
+}
+
+// parseNTLMNegotiate parses a NEGOTIATE message.
+func parseNTLMNegotiate(msg []byte) (ntlmNegotiateInfo, bool) {
+	if ntlmMessageType(msg) != ntlmNegotiate || len(msg) < 16 {
+		return ntlmNegotiateInfo{}, false
+	}
+	info := ntlmNegotiateInfo{Flags: binary.LittleEndian.Uint32(msg[12:])}
+	if len(msg) >= 32 {
+		info.Domain = string(ntlmField(msg, 16))
+		info.Workstation = string(ntlmField(msg, 24))
+	}
+	if info.Flags&ntlmNegotiateFlagVersion != 0 && len(msg) >= 40 {
+		info.Version = fmt.Sprintf("%d.%d.%d", msg[32], msg[33], binary.LittleEndian.Uint16(msg[34:]))
+	}
+	return info, true
+}
+
+// ntlmAuth is what an AUTHENTICATE message reveals.
+type ntlmAuth struct {
+	User, Domain, Workstation string
+	LMResponse, NTResponse    []byte
+}
+
+// parseNTLMAuthenticate parses an AUTHENTICATE message.
+func parseNTLMAuthenticate(msg []byte) (ntlmAuth, bool) {
+	if ntlmMessageType(msg) != ntlmAuthenticate || len(msg) < 64 {
+		return ntlmAuth{}, false
+	}
+	text := func(b []byte) string { return string(b) }
+	if binary.LittleEndian.Uint32(msg[60:])&0x1 != 0 {
+		text = utf16LEString
+	}
+	return ntlmAuth{
+		LMResponse:  ntlmField(msg, 12),
+		NTResponse:  ntlmField(msg, 20),
+		Domain:      text(ntlmField(msg, 28)),
+		User:        text(ntlmField(msg, 36)),
+		Workstation: text(ntlmField(msg, 44)),
+	}, true
+}
+
+// ntlmField returns the payload referenced by the length and offset fields
+// at msg[at:], nil if it lies outside msg.
+func ntlmField(msg []byte, at int) []byte {
+	length := int(binary.LittleEndian.Uint16(msg[at:]))
+	offset := int(binary.LittleEndian.Uint32(msg[at+4:]))
+	if offset > len(msg) || length > len(msg)-offset {
+		return nil
+	}
+	return msg[offset : offset+length]
+}
+
+// Username returns the account in DOMAIN\user form.
+func (a ntlmAuth) Username() string {
+	if a.Domain == "" {
+		return a.User
+	}
+	return a.Domain + `\` + a.User
+}
+
+// Hash returns the challenge-response in the format of hashcat and John the
+// Ripper, NetNTLMv2 (hashcat mode 5600) or NetNTLMv1 (5500), so the password
+// can be cracked offline. It is empty for anonymous logons.
+func (a ntlmAuth) Hash(challenge [8]byte) string {
+	switch {
+	case a.User == "" || len(a.NTResponse) == 0:
+		return ""
+	case len(a.NTResponse) == 24:
+		return a.User + "::" + a.Domain + ":" + hex.EncodeToString(a.LMResponse) + ":" + hex.EncodeToString(a.NTResponse) + ":" + hex.EncodeToString(challenge[:])
+	case len(a.NTResponse) > 16:
+		return a.User + "::" + a.Domain + ":" + hex.EncodeToString(challenge[:]) + ":" + hex.EncodeToString(a.NTResponse[:16]) + ":" + hex.EncodeToString(a.NTResponse[16:])
+	}
+	return ""
+}
+
+func utf16LE(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+func utf16LEString(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// fileTime converts t to a Windows FILETIME: 100ns intervals since 1601.
+func fileTime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
@@ -0,0 +1,53 @@
+//go:build linux
+
+package honeypot
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+)
+
+// soOriginalDst is SO_ORIGINAL_DST / IP6T_SO_ORIGINAL_DST from the netfilter headers.
+const soOriginalDst = 80
+
+// originalDestination returns the pre-NAT destination of a connection that was
+// redirected to us by netfilter (iptables/nftables REDIRECT or DNAT).
+// It returns nil when the connection was not redirected or the lookup fails.
+func originalDestination(conn net.Conn) *net.TCPAddr {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	local, _ := conn.LocalAddr().(*net.TCPAddr)
+	var addr *net.TCPAddr
+	rawConn.Control(func(fd uintptr) {
+		if local != nil && local.IP.To4() == nil {
+			// sockaddr_in6 fits in the leading bytes of IPv6MTUInfo
+			info, err := syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.SOL_IPV6, soOriginalDst)
+			if err != nil {
+				return
+			}
+			// the port is stored in network byte order
+			port := binary.NativeEndian.AppendUint16(nil, info.Addr.Port)
+			addr = &net.TCPAddr{IP: net.IP(info.Addr.Addr[:]), Port: int(binary.BigEndian.Uint16(port))}
+			return
+		}
+		// sockaddr_in fits in the 16-byte Multiaddr field of IPv6Mreq
+		mreq, err := syscall.GetsockoptIPv6Mreq(int(fd), syscall.SOL_IP, soOriginalDst)
+		if err != nil {
+			return
+		}
+		sa := mreq.Multiaddr
+		addr = &net.TCPAddr{
+			IP:   net.IPv4(sa[4], sa[5], sa[6], sa[7]),
+			Port: int(binary.BigEndian.Uint16(sa[2:4])),
+		}
+	})
+	return addr
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package honeypot
+
+import "net"
+
+// originalDestination is only implemented on Linux, where netfilter exposes
+// the pre-NAT destination through SO_ORIGINAL_DST.
+func originalDestination(conn net.Conn) *net.TCPAddr {
+	return nil
+}
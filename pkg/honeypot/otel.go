@@ -0,0 +1,687 @@
+package honeypot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OTelSignals are the signals an OTelOutput exports.
+var OTelSignals = []string{"traces", "metrics"}
+
+// Bounds of the state an OTelOutput keeps: sessions followed at once, span
+// events recorded per session, and the upper bounds in seconds of the
+// buckets of the session duration histogram.
+const (
+	otelMaxSessions   = 10000
+	otelMaxSpanEvents = 128
+)
+
+var otelDurationBounds = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600}
+
+// OTLP span kind, status code and aggregation temporality, see
+// opentelemetry-proto.
+const (
+	otlpSpanKindServer = 2
+	otlpStatusError    = 2
+	otlpCumulative     = 2
+)
+
+// OTelConfig exports traces and metrics to an OpenTelemetry collector, or
+// any backend with an OTLP/HTTP receiver, see OTelOutput.
+type OTelConfig struct {
+	Endpoint    string            `json:"endpoint"`               // base URL of the OTLP/HTTP receiver, e.g. http://otel-collector:4318, empty to disable
+	Headers     map[string]string `json:"headers,omitempty"`      // extra request headers, e.g. the API key of a hosted backend
+	ServiceName string            `json:"service_name,omitempty"` // service.name of the exported resource, default "gopot"
+	Signals     []string          `json:"signals,omitempty"`      // "traces" and "metrics", default both
+	Interval    string            `json:"interval,omitempty"`     // metrics export period, default 60s
+	Batch       BatchConfig       `json:"batch"`                  // spans per request, default 100 at least every 5s
+}
+
+// otelSession is a session an OTelOutput follows until it ends.
+type otelSession struct {
+	start   time.Time
+	events  []otlpSpanEvent
+	dropped int
+	failed  bool // a handler failed or overran its budget
+}
+
+// otelPoint identifies a data point of a counter: its metric and up to two
+// attributes.
+type otelPoint struct {
+	metric string
+	keys   [2]string
+	values [2]string
+}
+
+// otelHistogram counts session durations in otelDurationBounds buckets.
+type otelHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// otelMetrics describes the counters an OTelOutput keeps, by name.
+var otelMetrics = map[string]struct{ unit, description string }{
+	"gopot.events":           {"{event}", "Events emitted, by type"},
+	"gopot.connections":      {"{connection}", "Connections received, by port and transport"},
+	"gopot.sessions":         {"{session}", "Sessions ended, by handler and attack stage"},
+	"gopot.session.received": {"By", "Bytes received from clients, by handler"},
+	"gopot.session.sent":     {"By", "Bytes sent to clients, by handler"},
+}
+
+// OTelOutput is an Output exporting to an OTLP/HTTP receiver with the JSON
+// encoding, so GoPot joins the observability stack of operators who
+// standardized on OpenTelemetry without a client library.
+//
+// Every session is exported as a server span named after its handler, from
+// the moment the connection was accepted to its close, with span events
+// marking the accept, the banner (the first bytes sent), every read of
+// client data, the other events of the session and the close. Its trace and
+// span IDs are derived from the boot ID and session ID, so a trace can be
+// found from any event of the session. Spans are exported when sessions
+// end, so the output must receive the connection, data and session_end
+// events.
+//
+// Metrics are exported every interval as cumulative sums: events by type,
+// connections by port, sessions by handler and stage, bytes received and
+// sent, a histogram of session durations and, when Server is set, the
+// connection pool and the backlogs of the batching outputs.
+type OTelOutput struct {
+	*Batcher
+	Server *Server // source of the pool and output metrics, nil to leave them out
+
+	endpoint string
+	headers  map[string]string
+	service  string
+	version  string
+	traces   bool
+	metrics  bool
+	interval time.Duration
+	log      *log.Logger
+	client   *http.Client
+	started  time.Time
+
+	mu        sync.Mutex
+	sessions  map[string]*otelSession
+	counters  map[otelPoint]uint64
+	durations map[string]*otelHistogram // by handler
+	bootID    string                    // of the last event, identifies this instance
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewOTelOutput validates cfg and starts exporting. Failed exports are
+// reported to logger.
+func NewOTelOutput(cfg OTelConfig, logger *log.Logger) (*OTelOutput, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("otel: %q is not an http or https URL", cfg.Endpoint)
+	}
+	o := &OTelOutput{
+		endpoint:  strings.TrimSuffix(cfg.Endpoint, "/"),
+		headers:   cfg.Headers,
+		service:   cfg.ServiceName,
+		version:   ReadBuildInfo().Version,
+		interval:  time.Minute,
+		log:       logger,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		started:   time.Now(),
+		sessions:  make(map[string]*otelSession),
+		counters:  make(map[otelPoint]uint64),
+		durations: make(map[string]*otelHistogram),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if o.service == "" {
+		o.service = "gopot"
+	}
+	signals := cfg.Signals
+	if len(signals) == 0 {
+		signals = OTelSignals
+	}
+	for _, signal := range signals {
+		if !slices.Contains(OTelSignals, signal) {
+			return nil, fmt.Errorf("otel: unknown signal %q, want %s", signal, strings.Join(OTelSignals, " or "))
+		}
+	}
+	o.traces, o.metrics = slices.Contains(signals, "traces"), slices.Contains(signals, "metrics")
+	if cfg.Interval != "" {
+		if o.interval, err = time.ParseDuration(cfg.Interval); err != nil || o.interval <= 0 {
+			return nil, fmt.Errorf("otel: invalid interval %q", cfg.Interval)
+		}
+	}
+	if cfg.Batch.Compression != "" && cfg.Batch.Compression != "gzip" {
+		return nil, fmt.Errorf("otel: unsupported compression %q, OTLP/HTTP takes gzip or none", cfg.Batch.Compression)
+	}
+	batcher, err := NewBatcher("otel", cfg.Batch, o.encode, o.postTraces)
+	if err != nil {
+		return nil, err
+	}
+	batcher.Log = logger
+	o.Batcher = batcher
+	if o.metrics {
+		go o.run()
+	} else {
+		close(o.done)
+	}
+	return o, nil
+}
+
+// Write implements Output, counting ev in the metrics and recording it in
+// the span of its session, which is queued for export once it ends.
+func (o *OTelOutput) Write(ev Event) error {
+	o.mu.Lock()
+	if ev.Labels["sensor"] == "" {
+		o.bootID = ev.BootID // not one of the sensors of a collector
+	}
+	if o.metrics {
+		o.count(ev)
+	}
+	if !o.traces || ev.Session == "" {
+		o.mu.Unlock()
+		return nil
+	}
+	// Dropped and refused connections have no session to follow
+	if ev.Fields["dropped"] == true || ev.Fields["refused"] == true {
+		o.mu.Unlock()
+		return nil
+	}
+	key := ev.BootID + "/" + ev.Session
+	sess, ok := o.sessions[key]
+	if !ok && ev.Type != EventSessionEnd && len(o.sessions) < otelMaxSessions {
+		sess = &otelSession{start: ev.Time}
+		o.sessions[key] = sess
+	}
+	if ev.Type != EventSessionEnd {
+		if sess != nil {
+			sess.record(ev)
+		}
+		o.mu.Unlock()
+		return nil
+	}
+	delete(o.sessions, key)
+	o.mu.Unlock()
+
+	if sess == nil {
+		sess = &otelSession{}
+	}
+	fields := make(map[string]any, len(ev.Fields)+1)
+	for key, value := range ev.Fields {
+		fields[key] = value
+	}
+	fields["otel_span"] = sess.span(ev)
+	ev.Fields = fields
+	return o.Batcher.Write(ev)
+}
+
+// count adds ev to the counters. The caller holds o.mu.
+func (o *OTelOutput) count(ev Event) {
+	o.counters[otelPoint{metric: "gopot.events", keys: [2]string{"event.type"}, values: [2]string{ev.Type}}]++
+	handler, _ := ev.Fields["handler"].(string)
+	switch ev.Type {
+	case EventConnection:
+		transport, _ := ev.Fields["transport"].(string)
+		if transport == "" {
+			transport = "tcp"
+		}
+		o.counters[otelPoint{metric: "gopot.connections", keys: [2]string{"server.port", "network.transport"}, values: [2]string{ev.Port, transport}}]++
+	case EventSessionEnd:
+		stage, _ := ev.Fields["stage"].(string)
+		o.counters[otelPoint{metric: "gopot.sessions", keys: [2]string{"gopot.handler", "gopot.stage"}, values: [2]string{handler, stage}}]++
+		if received, ok := fieldNumber(ev.Fields, "received_bytes"); ok {
+			o.counters[otelPoint{metric: "gopot.session.received", keys: [2]string{"gopot.handler"}, values: [2]string{handler}}] += uint64(received)
+		}
+		if sent, ok := fieldNumber(ev.Fields, "sent_bytes"); ok {
+			o.counters[otelPoint{metric: "gopot.session.sent", keys: [2]string{"gopot.handler"}, values: [2]string{handler}}] += uint64(sent)
+		}
+		if duration, ok := fieldNumber(ev.Fields, "duration"); ok {
+			h, ok := o.durations[handler]
+			if !ok {
+				h = &otelHistogram{counts: make([]uint64, len(otelDurationBounds)+1)}
+				o.durations[handler] = h
+			}
+			h.counts[sort.SearchFloat64s(otelDurationBounds, duration)]++
+			h.count++
+			h.sum += duration
+		}
+	}
+}
+
+// record adds ev to the span events of the session.
+func (sess *otelSession) record(ev Event) {
+	if ev.Type == EventError || ev.Type == EventHandlerTimeout {
+		sess.failed = true
+	}
+	if len(sess.events) >= otelMaxSpanEvents {
+		sess.dropped++
+		return
+	}
+	name := ev.Type
+	var attrs []otlpKeyValue
+	switch ev.Type {
+	case EventConnection:
+		name = "accept"
+	case EventData:
+		name, attrs = "read", []otlpKeyValue{otlpInt("gopot.bytes", int64(len(EventPayload(ev))))}
+	default:
+		attrs = []otlpKeyValue{otlpString("gopot.message", ev.Message)}
+		if ev.Severity != "" {
+			attrs = append(attrs, otlpString("gopot.severity", ev.Severity))
+		}
+	}
+	sess.events = append(sess.events, otlpSpanEvent{TimeUnixNano: unixNano(ev.Time), Name: name, Attributes: attrs})
+}
+
+// span returns the span of the session ended by ev.
+func (sess *otelSession) span(ev Event) otlpSpan {
+	duration, _ := fieldNumber(ev.Fields, "duration")
+	start := sess.start
+	if start.IsZero() {
+		start = ev.Time.Add(-time.Duration(duration * float64(time.Second)))
+	}
+	handler, _ := ev.Fields["handler"].(string)
+	received, _ := fieldNumber(ev.Fields, "received_bytes")
+	sent, _ := fieldNumber(ev.Fields, "sent_bytes")
+	events := sess.events
+	if first, ok := fieldNumber(ev.Fields, "first_sent"); ok {
+		at := start.Add(time.Duration(first * float64(time.Second)))
+		events = append(events, otlpSpanEvent{TimeUnixNano: unixNano(at), Name: "banner"})
+	}
+	events = append(events, otlpSpanEvent{TimeUnixNano: unixNano(ev.Time), Name: "close", Attributes: []otlpKeyValue{
+		otlpInt("gopot.received_bytes", int64(received)), otlpInt("gopot.sent_bytes", int64(sent)),
+	}})
+	sort.SliceStable(events, func(i, j int) bool { return events[i].TimeUnixNano < events[j].TimeUnixNano })
+
+	id := sha256.Sum256([]byte(ev.BootID + "/" + ev.Session))
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(id[:16]),
+		SpanID:            hex.EncodeToString(id[16:24]),
+		Name:              handler + " session",
+		Kind:              otlpSpanKindServer,
+		StartTimeUnixNano: unixNano(start),
+		EndTimeUnixNano:   unixNano(ev.Time),
+		Events:            events,
+		DroppedEvents:     sess.dropped,
+	}
+	span.Attributes = []otlpKeyValue{
+		otlpString("gopot.session", ev.Session),
+		otlpString("gopot.handler", handler),
+		otlpString("server.port", ev.Port),
+		otlpString("client.address", srcIP(ev.SrcAddr)),
+		otlpInt("gopot.received_bytes", int64(received)),
+		otlpInt("gopot.sent_bytes", int64(sent)),
+	}
+	if stage, _ := ev.Fields["stage"].(string); stage != "" {
+		span.Attributes = append(span.Attributes, otlpString("gopot.stage", stage))
+	}
+	if ev.Host != "" {
+		span.Attributes = append(span.Attributes, otlpString("gopot.host", ev.Host))
+	}
+	keys := make([]string, 0, len(ev.Labels))
+	for key := range ev.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		span.Attributes = append(span.Attributes, otlpString("gopot.label."+key, ev.Labels[key]))
+	}
+	if sess.failed {
+		span.Status.Code = otlpStatusError
+	}
+	return span
+}
+
+// encode turns the ended sessions of a batch into an OTLP export request,
+// with a resource per boot ID, which tells the sensors of a collector apart.
+func (o *OTelOutput) encode(events []Event) ([]byte, error) {
+	var req otlpTracesRequest
+	resources := make(map[string]int)
+	for _, ev := range events {
+		span, ok := ev.Fields["otel_span"].(otlpSpan)
+		if !ok {
+			continue
+		}
+		i, ok := resources[ev.BootID]
+		if !ok {
+			i = len(req.ResourceSpans)
+			resources[ev.BootID] = i
+			req.ResourceSpans = append(req.ResourceSpans, otlpResourceSpans{
+				Resource:   o.resource(ev.BootID),
+				ScopeSpans: []otlpScopeSpans{{Scope: o.scope()}},
+			})
+		}
+		scope := &req.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, span)
+	}
+	return json.Marshal(req)
+}
+
+func (o *OTelOutput) postTraces(body []byte, encoding string) error {
+	return o.post("/v1/traces", body, encoding)
+}
+
+// post sends an export request to path of the endpoint.
+func (o *OTelOutput) post(path string, body []byte, encoding string) error {
+	req, err := http.NewRequest(http.MethodPost, o.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	for key, value := range o.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", o.endpoint+path, resp.Status)
+	}
+	return nil
+}
+
+// run exports the metrics every interval until the output is closed.
+func (o *OTelOutput) run() {
+	defer close(o.done)
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-o.stop:
+			o.mu.Lock()
+			counted := len(o.counters) > 0
+			o.mu.Unlock()
+			if counted || o.Server != nil {
+				o.exportMetrics()
+			}
+			return
+		}
+		o.exportMetrics()
+	}
+}
+
+// exportMetrics sends the current value of every metric.
+func (o *OTelOutput) exportMetrics() {
+	body, err := json.Marshal(o.collect(time.Now()))
+	if err == nil {
+		err = o.post("/v1/metrics", body, "")
+	}
+	if err != nil && o.log != nil {
+		o.log.Printf("Output otel: unable to export metrics: %s", err)
+	}
+}
+
+// collect returns the export request of the metrics at time now.
+func (o *OTelOutput) collect(now time.Time) otlpMetricsRequest {
+	start, at := unixNano(o.started), unixNano(now)
+	var metrics []otlpMetric
+	sum := func(name, unit, description string, points []otlpNumberPoint) {
+		metrics = append(metrics, otlpMetric{Name: name, Unit: unit, Description: description,
+			Sum: &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true, DataPoints: points}})
+	}
+	gauge := func(name, unit, description string, points []otlpNumberPoint) {
+		metrics = append(metrics, otlpMetric{Name: name, Unit: unit, Description: description,
+			Gauge: &otlpGauge{DataPoints: points}})
+	}
+	point := func(value uint64, attrs ...otlpKeyValue) otlpNumberPoint {
+		return otlpNumberPoint{Attributes: attrs, StartTimeUnixNano: start, TimeUnixNano: at, AsInt: strconv.FormatUint(value, 10)}
+	}
+
+	o.mu.Lock()
+	bootID := o.bootID
+	byMetric := make(map[string][]otlpNumberPoint)
+	for p, value := range o.counters {
+		var attrs []otlpKeyValue
+		for i, key := range p.keys {
+			if key != "" {
+				attrs = append(attrs, otlpString(key, p.values[i]))
+			}
+		}
+		byMetric[p.metric] = append(byMetric[p.metric], point(value, attrs...))
+	}
+	var durations []otlpHistogramPoint
+	for handler, h := range o.durations {
+		counts := make([]string, len(h.counts))
+		for i, n := range h.counts {
+			counts[i] = strconv.FormatUint(n, 10)
+		}
+		durations = append(durations, otlpHistogramPoint{
+			Attributes:        []otlpKeyValue{otlpString("gopot.handler", handler)},
+			StartTimeUnixNano: start,
+			TimeUnixNano:      at,
+			Count:             strconv.FormatUint(h.count, 10),
+			Sum:               h.sum,
+			BucketCounts:      counts,
+			ExplicitBounds:    otelDurationBounds,
+		})
+	}
+	o.mu.Unlock()
+
+	names := make([]string, 0, len(byMetric))
+	for name := range byMetric {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sum(name, otelMetrics[name].unit, otelMetrics[name].description, byMetric[name])
+	}
+	if len(durations) > 0 {
+		metrics = append(metrics, otlpMetric{Name: "gopot.session.duration", Unit: "s", Description: "Duration of sessions, by handler",
+			Histogram: &otlpHistogram{AggregationTemporality: otlpCumulative, DataPoints: durations}})
+	}
+	if o.Server != nil {
+		pool := o.Server.Stats()
+		gauge("gopot.pool.workers", "{connection}", "Connections served at once", []otlpNumberPoint{point(uint64(pool.Workers))})
+		gauge("gopot.pool.busy", "{connection}", "Workers serving a connection", []otlpNumberPoint{point(uint64(pool.Busy))})
+		gauge("gopot.pool.queued", "{connection}", "Accepted connections waiting for a worker", []otlpNumberPoint{point(uint64(pool.Queued))})
+		sum("gopot.pool.dropped", "{connection}", "Connections closed unserved because the queue was full", []otlpNumberPoint{point(pool.Dropped)})
+		sum("gopot.pool.shed", "{connection}", "Queued connections closed unserved for ones of a higher priority", []otlpNumberPoint{point(pool.Shed)})
+		var queued, dropped []otlpNumberPoint
+		for name, st := range o.Server.OutputStats() {
+			queued = append(queued, point(uint64(st.Queued), otlpString("gopot.output", name)))
+			dropped = append(dropped, point(st.Dropped, otlpString("gopot.output", name)))
+		}
+		if len(queued) > 0 {
+			gauge("gopot.output.queued", "{event}", "Events waiting to be batched, by output", queued)
+			sum("gopot.output.dropped", "{event}", "Events lost to a full queue or failed batches, by output", dropped)
+		}
+	}
+	return otlpMetricsRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     o.resource(bootID),
+		ScopeMetrics: []otlpScopeMetrics{{Scope: o.scope(), Metrics: metrics}},
+	}}}
+}
+
+// resource describes the GoPot instance with boot ID bootID.
+func (o *OTelOutput) resource(bootID string) otlpResource {
+	attrs := []otlpKeyValue{otlpString("service.name", o.service), otlpString("service.version", o.version)}
+	if bootID != "" {
+		attrs = append(attrs, otlpString("service.instance.id", bootID))
+	}
+	return otlpResource{Attributes: attrs}
+}
+
+func (o *OTelOutput) scope() otlpScope {
+	return otlpScope{Name: "github.com/jackyes/GoPot", Version: o.version}
+}
+
+// Close exports the queued spans and the last metrics, and stops the output.
+func (o *OTelOutput) Close() error {
+	err := o.Batcher.Close()
+	select {
+	case <-o.stop:
+	default:
+		close(o.stop)
+	}
+	<-o.done
+	return err
+}
+
+// fieldNumber returns the numeric field key of fields, which is an int for
+// the events of this server and a float64 for those decoded from JSON.
+func fieldNumber(fields map[string]any, key string) (float64, bool) {
+	switch v := fields[key].(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+// The OTLP/HTTP JSON encoding of the export requests, see
+// opentelemetry-proto: 64-bit integers are strings, IDs are hex and enums
+// are numbers.
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    string  `json:"intValue,omitempty"`
+}
+
+func otlpString(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value int64) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: strconv.FormatInt(value, 10)}}
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpTracesRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano uint64          `json:"startTimeUnixNano,string"`
+	EndTimeUnixNano   uint64          `json:"endTimeUnixNano,string"`
+	Attributes        []otlpKeyValue  `json:"attributes,omitempty"`
+	Events            []otlpSpanEvent `json:"events,omitempty"`
+	DroppedEvents     int             `json:"droppedEventsCount,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpSpanEvent struct {
+	TimeUnixNano uint64         `json:"timeUnixNano,string"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code,omitempty"`
+}
+
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Unit        string         `json:"unit,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+}
+
+type otlpSum struct {
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+	DataPoints             []otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpNumberPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64         `json:"startTimeUnixNano,string"`
+	TimeUnixNano      uint64         `json:"timeUnixNano,string"`
+	AsInt             string         `json:"asInt"`
+}
+
+type otlpHistogram struct {
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+	DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+}
+
+type otlpHistogramPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64         `json:"startTimeUnixNano,string"`
+	TimeUnixNano      uint64         `json:"timeUnixNano,string"`
+	Count             string         `json:"count"`
+	Sum               float64        `json:"sum"`
+	BucketCounts      []string       `json:"bucketCounts"`
+	ExplicitBounds    []float64      `json:"explicitBounds"`
+}
@@ -0,0 +1,180 @@
+package honeypot
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Output receives every event emitted by a Server.
+// Write may be called concurrently from many connections.
+type Output interface {
+	Write(ev Event) error
+}
+
+// LogOutput writes each event as a timestamped text line, see Event.Text.
+type LogOutput struct {
+	logger *log.Logger
+}
+
+// NewLogOutput returns an output writing event messages to w.
+func NewLogOutput(w io.Writer) *LogOutput {
+	return &LogOutput{logger: log.New(w, "", log.LstdFlags)}
+}
+
+// Write implements Output.
+func (o *LogOutput) Write(ev Event) error {
+	return o.logger.Output(2, ev.Text())
+}
+
+// LogFormats are the formats of the lines of a LogFileOutput: timestamped
+// text lines, see Event.Text, or CEF records, see FormatCEF.
+var LogFormats = []string{"text", "cef"}
+
+// LogFileOutput writes event text lines to log.txt in a directory and starts a
+// new file every day, archiving the previous day's log as log-YYYY-MM-DD.txt.
+type LogFileOutput struct {
+	Format string // one of LogFormats, empty for text
+
+	dir    string
+	mu     sync.Mutex
+	file   *os.File
+	logger *log.Logger
+	date   string     // date of the current log file
+	signer *logSigner // nil unless signing
+}
+
+// NewLogFileOutput opens (or creates) log.txt in dir.
+func NewLogFileOutput(dir string) (*LogFileOutput, error) {
+	o := &LogFileOutput{dir: dir}
+	if err := o.rotate(time.Now()); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Write implements Output.
+func (o *LogFileOutput) Write(ev Event) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.rotate(time.Now()); err != nil {
+		return err
+	}
+	if o.signer != nil {
+		line := time.Now().Format("2006/01/02 15:04:05 ") + ev.Text()
+		if o.Format == "cef" {
+			line = FormatCEF(ev)
+		}
+		// One line per signature
+		line = strings.ReplaceAll(line, "\n", `\n`)
+		_, err := o.file.WriteString(line + o.signer.sign(line) + "\n")
+		return err
+	}
+	if o.Format == "cef" {
+		_, err := o.file.WriteString(FormatCEF(ev) + "\n")
+		return err
+	}
+	return o.logger.Output(2, ev.Text())
+}
+
+// rotate archives the current log file when the date has changed and opens
+// a new one. Callers other than NewLogFileOutput must hold o.mu.
+func (o *LogFileOutput) rotate(now time.Time) error {
+	currentDate := now.Format("2006-01-02") // Current date formatted as YYYY-MM-DD
+	if o.date == currentDate {
+		return nil
+	}
+
+	current := filepath.Join(o.dir, "log.txt")
+	archived := o.file != nil
+	if archived {
+		o.file.Close()
+		// Rename the current log file to include the date for archiving
+		os.Rename(current, filepath.Join(o.dir, fmt.Sprintf("log-%s.txt", o.date)))
+	}
+
+	file, err := os.OpenFile(current, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("unable to open log file: %w", err)
+	}
+	o.file = file
+	o.logger = log.New(file, "", log.LstdFlags)
+	o.date = currentDate
+	if archived && o.signer != nil {
+		return o.signer.advance()
+	}
+	return nil
+}
+
+// EnableSigning signs every line written from now on, chaining it to the
+// last signed line of the log, see VerifyLog. Line breaks in messages are
+// written as \n. With an HMAC key, the key and its file move on to the
+// next key at every daily rotation, so a sensor compromised today cannot
+// forge the lines of the previous days.
+func (o *LogFileOutput) EnableSigning(cfg LogSigningConfig) error {
+	signer, err := newLogSigner(cfg)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	signer.prev = lastLogSignature(o.dir)
+	o.signer = signer
+	return nil
+}
+
+// Purge deletes the lines mentioning ip from log.txt and its daily archives
+// and returns the files rewritten and the number of lines deleted.
+func (o *LogFileOutput) Purge(ip string) ([]string, int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	archives, _ := filepath.Glob(filepath.Join(o.dir, "log-*.txt"))
+	sort.Strings(archives)
+	paths := append(archives, filepath.Join(o.dir, "log.txt"))
+	var (
+		files []string
+		total int
+		errs  []string
+	)
+	for _, path := range paths {
+		n, err := purgeLines(path, ip)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if n > 0 {
+			files = append(files, path)
+			total += n
+		}
+	}
+	// Keep appending to the rewritten log.txt
+	if o.file != nil {
+		o.file.Close()
+		o.file, o.date = nil, ""
+		if err := o.rotate(time.Now()); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return files, total, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return files, total, nil
+}
+
+// Close closes the current log file.
+func (o *LogFileOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.file == nil {
+		return nil
+	}
+	err := o.file.Close()
+	o.file = nil
+	return err
+}
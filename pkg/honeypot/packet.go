@@ -0,0 +1,278 @@
+package honeypot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PacketHandler emulates a datagram protocol on a UDP port. ServePacket is
+// called with each datagram and the session of its sender, and returns the
+// datagrams to send back, if any.
+type PacketHandler interface {
+	ServePacket(ctx context.Context, packet []byte, meta *ConnMeta) [][]byte
+}
+
+// PacketHandlerFunc adapts an ordinary function to the PacketHandler interface.
+type PacketHandlerFunc func(ctx context.Context, packet []byte, meta *ConnMeta) [][]byte
+
+// ServePacket calls f(ctx, packet, meta).
+func (f PacketHandlerFunc) ServePacket(ctx context.Context, packet []byte, meta *ConnMeta) [][]byte {
+	return f(ctx, packet, meta)
+}
+
+var (
+	packetHandlers   = map[string]PacketHandler{} // registry of datagram handlers by name
+	packetHandlersMu sync.RWMutex
+)
+
+// RegisterPacketHandler makes a UDP handler available for assignment to
+// ports. Ports assigned to it are listened on over UDP; a protocol spoken
+// over both transports, like SIP, registers a Handler under the same name so
+// its ports are listened on over TCP too.
+func RegisterPacketHandler(name string, h PacketHandler) {
+	if err := AddPacketHandler(name, h); err != nil {
+		panic(err.Error())
+	}
+}
+
+// AddPacketHandler is RegisterPacketHandler returning an error when name is
+// taken instead of panicking, see AddHandler.
+func AddPacketHandler(name string, h PacketHandler) error {
+	packetHandlersMu.Lock()
+	defer packetHandlersMu.Unlock()
+	if _, exists := packetHandlers[name]; exists {
+		return fmt.Errorf("packet handler registered twice: %s", name)
+	}
+	packetHandlers[name] = h
+	return nil
+}
+
+// LookupPacketHandler returns the UDP handler registered under name.
+func LookupPacketHandler(name string) (PacketHandler, bool) {
+	packetHandlersMu.RLock()
+	defer packetHandlersMu.RUnlock()
+	h, ok := packetHandlers[name]
+	return h, ok
+}
+
+// HandlerRegistered reports whether name is registered as a TCP or UDP handler.
+func HandlerRegistered(name string) bool {
+	_, stream := LookupHandler(name)
+	_, packet := LookupPacketHandler(name)
+	return stream || packet
+}
+
+func packetHandlerNames() []string {
+	packetHandlersMu.RLock()
+	defer packetHandlersMu.RUnlock()
+	names := make([]string, 0, len(packetHandlers))
+	for name := range packetHandlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Limits protecting third parties from reflected traffic: UDP source
+// addresses are easily spoofed, so a sender gets at most packetRate answers
+// per second and packetAmplification times the bytes it sent.
+const (
+	packetRate          = 50
+	packetAmplification = 3
+	packetMinAllowance  = 2048 // bytes any sender may receive, so short probes are answered
+	maxPacketSessions   = 4096 // senders tracked per port; datagrams of others are dropped
+	maxPacketSize       = 65535
+)
+
+// packetSession is the session of one sender on a UDP port: its datagrams
+// share a ConnMeta until the sender is idle for IdleTimeout.
+type packetSession struct {
+	meta     *ConnMeta
+	last     time.Time
+	received int
+	sent     int
+	window   time.Time // start of the second answers are counted in
+	answers  int
+}
+
+// PacketListener receives the datagrams of one UDP port of a Server.
+type PacketListener struct {
+	Port    string
+	server  *Server
+	pc      net.PacketConn
+	handler PacketHandler
+
+	sessions map[string]*packetSession // by sender address, only used by Serve
+}
+
+// ListenPacket opens a UDP socket on port served by the port's packet
+// handler. Call Serve on the result to start receiving.
+func (s *Server) ListenPacket(port string) (*PacketListener, error) {
+	name := s.handlerName(s.portOptions(port))
+	handler, ok := LookupPacketHandler(name)
+	if !ok {
+		return nil, fmt.Errorf("handler %q does not serve UDP", name)
+	}
+	pc, err := net.ListenPacket("udp", ":"+port)
+	if err != nil {
+		return nil, err
+	}
+	return s.newPacketListener(pc, port, handler), nil
+}
+
+// NewPacketListener wraps an existing net.PacketConn, for example one bound
+// to a single address, so its datagrams are served as port.
+func (s *Server) NewPacketListener(pc net.PacketConn, port string) (*PacketListener, error) {
+	name := s.handlerName(s.portOptions(port))
+	handler, ok := LookupPacketHandler(name)
+	if !ok {
+		return nil, fmt.Errorf("handler %q does not serve UDP", name)
+	}
+	return s.newPacketListener(pc, port, handler), nil
+}
+
+func (s *Server) newPacketListener(pc net.PacketConn, port string, handler PacketHandler) *PacketListener {
+	l := &PacketListener{Port: port, server: s, pc: pc, handler: handler, sessions: make(map[string]*packetSession)}
+	s.connMu.Lock()
+	s.packetListeners[l] = struct{}{}
+	s.connMu.Unlock()
+	return l
+}
+
+// Serve receives datagrams until the listener is closed. Sessions still
+// open then are ended. Datagrams are handled one at a time, so handlers
+// should answer without blocking.
+func (l *PacketListener) Serve() error {
+	s := l.server
+	buffer := make([]byte, maxPacketSize)
+	lastExpiry := time.Now()
+	for {
+		// Wake up every second to end idle sessions
+		if now := time.Now(); now.Sub(lastExpiry) >= time.Second {
+			l.expire(now)
+			lastExpiry = now
+		}
+		l.pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, addr, err := l.pc.ReadFrom(buffer)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			l.endSessions(func(*packetSession) bool { return true })
+			if s.closing.Load() || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			s.logf(EventError, "Error receiving on port %s/udp: %s", l.Port, err)
+			return err
+		}
+		s.pool.accepted.Add(1)
+		sess := l.session(addr)
+		if sess == nil {
+			continue
+		}
+		packet := append([]byte(nil), buffer[:n]...)
+		sess.received += n
+		sess.meta.received += n
+		for _, answer := range l.handler.ServePacket(s.ctx, packet, sess.meta) {
+			if s.ReadOnly {
+				sess.meta.withheld += len(answer)
+				continue
+			}
+			if !l.allow(sess, len(answer)) {
+				break
+			}
+			if _, err := l.pc.WriteTo(answer, addr); err != nil {
+				break
+			}
+		}
+		if sess.received >= sess.meta.Limits.MaxBytes || sess.meta.SessionExpired() {
+			l.endSessions(func(other *packetSession) bool { return other == sess })
+		}
+	}
+}
+
+// session returns the session of the sender of a datagram, starting one if
+// needed. It returns nil when the datagram is to be ignored.
+func (l *PacketListener) session(addr net.Addr) *packetSession {
+	s := l.server
+	key := addr.String()
+	if sess, ok := l.sessions[key]; ok {
+		sess.last = time.Now()
+		if sess.meta == nil {
+			return nil
+		}
+		return sess
+	}
+	if len(l.sessions) >= maxPacketSessions {
+		s.pool.limited(l.Port)
+		return nil
+	}
+
+	s.countConnection(l.Port)
+	now := time.Now()
+	meta := s.newConnMeta(l.Port, key, addrPort(l.pc.LocalAddr()), netip.AddrPort{}, s.portOptions(l.Port), now)
+	meta.datagram = true
+	sess := &packetSession{meta: meta, last: now}
+	if !s.startSession(meta, fmt.Sprintf("Received datagram on port %s/udp from %s to %s", meta.Port, meta.ClientAddr, meta.LocalAddr)) {
+		// Remember refused senders for IdleTimeout like the others, so their
+		// datagrams are not logged one by one
+		sess.meta = nil
+	}
+	l.sessions[key] = sess
+	if sess.meta == nil {
+		return nil
+	}
+	return sess
+}
+
+// allow reports whether an answer of size bytes may be sent to a session
+// without exceeding the reflection limits, counting it if so.
+func (l *PacketListener) allow(sess *packetSession, size int) bool {
+	now := time.Now()
+	if now.Sub(sess.window) >= time.Second {
+		sess.window, sess.answers = now, 0
+	}
+	if sess.answers >= packetRate || sess.sent+size > max(packetAmplification*sess.received, packetMinAllowance) {
+		return false
+	}
+	sess.answers++
+	sess.sent += size
+	return true
+}
+
+// expire ends the sessions idle for their IdleTimeout or past their SessionTimeout.
+func (l *PacketListener) expire(now time.Time) {
+	l.endSessions(func(sess *packetSession) bool {
+		if sess.meta == nil {
+			return now.Sub(sess.last) >= l.server.Limits.IdleTimeout
+		}
+		return now.Sub(sess.last) >= sess.meta.Limits.IdleTimeout || sess.meta.SessionExpired()
+	})
+}
+
+// endSessions ends the sessions selected by done.
+func (l *PacketListener) endSessions(done func(*packetSession) bool) {
+	for key, sess := range l.sessions {
+		if done(sess) {
+			delete(l.sessions, key)
+			if sess.meta != nil {
+				l.server.endSession(sess.meta)
+			}
+		}
+	}
+}
+
+// Close stops the listener; Serve ends the open sessions and returns.
+func (l *PacketListener) Close() error {
+	l.server.connMu.Lock()
+	delete(l.server.packetListeners, l)
+	l.server.connMu.Unlock()
+	return l.pc.Close()
+}
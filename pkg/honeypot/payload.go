@@ -0,0 +1,120 @@
+package honeypot
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DataEncodings are the ways the data field of data events holds payloads
+// that are not printable text, such as binary exploits: with Go string
+// escapes (\x00, \n) readable as text, in hex or in base64. Printable UTF-8
+// payloads are always written as they are.
+var DataEncodings = []string{"escaped", "hex", "base64"}
+
+// encodePayload returns the data field of a payload and its data_encoding:
+// "text" for printable UTF-8, otherwise encoding, one of DataEncodings,
+// "escaped" if empty.
+func encodePayload(data, encoding string) (string, string) {
+	if printableText(data) {
+		return data, "text"
+	}
+	switch encoding {
+	case "hex":
+		return hex.EncodeToString([]byte(data)), "hex"
+	case "base64":
+		return base64.StdEncoding.EncodeToString([]byte(data)), "base64"
+	}
+	return escapePayload(data), "escaped"
+}
+
+// printableText reports whether data is valid UTF-8 made of printable
+// characters, spaces and line breaks only.
+func printableText(data string) bool {
+	for _, r := range data {
+		if r == utf8.RuneError || !unicode.IsPrint(r) && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// escapePayload writes data on a single line: backslashes, line breaks,
+// control characters and bytes that are not UTF-8 are escaped as in a Go
+// string literal, which unescapePayload reverses. Quotes are left alone.
+func escapePayload(data string) string {
+	var b strings.Builder
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRuneInString(data[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&b, `\x%02x`, data[i])
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case unicode.IsPrint(r):
+			b.WriteRune(r)
+		case r < utf8.RuneSelf:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		case r <= 0xFFFF:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			fmt.Fprintf(&b, `\U%08x`, r)
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// unescapePayload returns the payload escapePayload wrote as s.
+func unescapePayload(s string) ([]byte, error) {
+	var data []byte
+	for len(s) > 0 {
+		r, multibyte, tail, err := strconv.UnquoteChar(s, 0)
+		if err != nil {
+			return nil, err
+		}
+		if multibyte {
+			data = utf8.AppendRune(data, r)
+		} else {
+			data = append(data, byte(r))
+		}
+		s = tail
+	}
+	return data, nil
+}
+
+// EventPayload returns the bytes the client sent according to a data event,
+// decoding its data field as its data_encoding says. Events written before
+// payloads were encoded hold them as they are.
+func EventPayload(ev Event) []byte {
+	data, _ := ev.Fields["data"].(string)
+	encoding, _ := ev.Fields["data_encoding"].(string)
+	var (
+		payload []byte
+		err     error
+	)
+	switch encoding {
+	case "escaped":
+		payload, err = unescapePayload(data)
+	case "hex":
+		payload, err = hex.DecodeString(data)
+	case "base64":
+		payload, err = base64.StdEncoding.DecodeString(data)
+	default:
+		return []byte(data)
+	}
+	if err != nil {
+		return []byte(data) // altered, e.g. by redaction
+	}
+	return payload
+}
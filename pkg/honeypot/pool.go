@@ -0,0 +1,298 @@
+package honeypot
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// queuedConn is an accepted connection waiting for a worker.
+type queuedConn struct {
+	conn     net.Conn
+	listener *Listener
+	accepted time.Time
+}
+
+// PoolStats describes the connection worker pool, see Server.Stats.
+type PoolStats struct {
+	Workers    int               `json:"workers"`      // connections served at once
+	Busy       int               `json:"busy"`         // workers currently serving a connection
+	Queued     int               `json:"queued"`       // accepted connections waiting for a worker
+	QueueSize  int               `json:"queue_size"`   // connections that may wait before new ones are dropped
+	PeakQueued int               `json:"peak_queued"`  // highest Queued seen
+	Accepted   uint64            `json:"accepted"`     // connections accepted since start, including dropped ones
+	Dropped    uint64            `json:"dropped"`      // connections closed unserved because the queue was full
+	Shed       uint64            `json:"shed"`         // queued connections closed unserved to make room for ones of a higher priority
+	Limited    map[string]uint64 `json:"port_limited"` // connections closed unserved because their listener was at its limit, by port
+	MaxWait    time.Duration     `json:"max_wait_ns"`  // longest time a connection waited for a worker
+}
+
+func (p PoolStats) String() string {
+	var limited uint64
+	for _, n := range p.Limited {
+		limited += n
+	}
+	return fmt.Sprintf("workers=%d busy=%d queued=%d/%d peak_queued=%d accepted=%d dropped=%d shed=%d port_limited=%d max_wait=%s",
+		p.Workers, p.Busy, p.Queued, p.QueueSize, p.PeakQueued, p.Accepted, p.Dropped, p.Shed, limited, p.MaxWait.Round(time.Millisecond))
+}
+
+// poolCounters are the counters behind PoolStats.
+type poolCounters struct {
+	busy       atomic.Int64
+	peakQueued atomic.Int64
+	accepted   atomic.Uint64
+	dropped    atomic.Uint64
+	shed       atomic.Uint64
+	maxWait    atomic.Int64 // nanoseconds
+
+	limitedMu sync.Mutex
+	limitedBy map[string]uint64 // see PoolStats.Limited
+}
+
+// limited counts a connection refused by the listener of port.
+func (c *poolCounters) limited(port string) {
+	c.limitedMu.Lock()
+	defer c.limitedMu.Unlock()
+	if c.limitedBy == nil {
+		c.limitedBy = make(map[string]uint64)
+	}
+	c.limitedBy[port]++
+}
+
+// Stats returns a snapshot of the connection worker pool.
+func (s *Server) Stats() PoolStats {
+	s.pool.limitedMu.Lock()
+	limited := make(map[string]uint64, len(s.pool.limitedBy))
+	for port, n := range s.pool.limitedBy {
+		limited[port] = n
+	}
+	s.pool.limitedMu.Unlock()
+	return PoolStats{
+		Limited:    limited,
+		Workers:    s.workers,
+		Busy:       int(s.pool.busy.Load()),
+		Queued:     s.queue.len(),
+		QueueSize:  max(s.QueueSize, 0),
+		PeakQueued: int(s.pool.peakQueued.Load()),
+		Accepted:   s.pool.accepted.Load(),
+		Dropped:    s.pool.dropped.Load(),
+		Shed:       s.pool.shed.Load(),
+		MaxWait:    time.Duration(s.pool.maxWait.Load()),
+	}
+}
+
+// startWorkers creates the connection queue and its workers the first time a
+// listener starts serving, so QueueSize can be set after NewServer.
+func (s *Server) startWorkers() {
+	s.poolOnce.Do(func() {
+		s.queue = newConnQueue(max(s.QueueSize, 0) + s.workers)
+		for i := 0; i < s.workers; i++ {
+			go s.worker()
+		}
+		go s.reportBackpressure(time.Minute)
+	})
+}
+
+// enqueue hands an accepted connection to the workers. When every worker is
+// busy and the queue is full the connection takes the place of the oldest
+// queued connection of the lowest priority below its own, which is closed
+// and counted as shed, see PortOptions.Priority. Failing that it is closed
+// immediately instead of piling up goroutines, and counted as dropped.
+func (s *Server) enqueue(conn net.Conn, l *Listener) {
+	s.connMu.Lock()
+	s.active[conn] = struct{}{}
+	s.connMu.Unlock()
+
+	qc := queuedConn{conn: conn, listener: l, accepted: time.Now()}
+	// Idle workers take connections at once, so the queue proper is what
+	// exceeds them
+	room := max(s.QueueSize, 0) + s.workers - int(s.pool.busy.Load())
+	queued, shed, ok := s.queue.push(qc, room)
+	if !ok {
+		s.pool.dropped.Add(1)
+		s.discard(qc)
+		return
+	}
+	storeMax(&s.pool.peakQueued, int64(queued))
+	if shed != nil {
+		s.pool.shed.Add(1)
+		s.discard(*shed)
+	}
+}
+
+// discard closes a connection that won't be served.
+func (s *Server) discard(qc queuedConn) {
+	s.connMu.Lock()
+	delete(s.active, qc.conn)
+	s.connMu.Unlock()
+	qc.conn.Close()
+	qc.listener.release()
+}
+
+// discardQueued closes the connections waiting for a worker, see Shutdown.
+func (s *Server) discardQueued() {
+	if s.queue == nil {
+		return
+	}
+	for {
+		select {
+		case <-s.queue.ready:
+			s.discard(s.queue.pop())
+		default:
+			return
+		}
+	}
+}
+
+// worker serves queued connections until the server shuts down.
+func (s *Server) worker() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.queue.ready:
+			qc := s.queue.pop()
+			s.pool.busy.Add(1) // before checking closing, so Shutdown waits for this connection
+			if s.closing.Load() {
+				s.pool.busy.Add(-1)
+				s.discard(qc) // picked up after Shutdown started
+				continue
+			}
+			storeMax(&s.pool.maxWait, int64(time.Since(qc.accepted)))
+			s.handleConnection(qc.conn, qc.listener.Port, qc.accepted)
+			s.pool.busy.Add(-1)
+			qc.listener.release()
+		}
+	}
+}
+
+// reportBackpressure emits a warning every interval in which connections
+// were dropped because the pool was saturated or a port was at its limit.
+func (s *Server) reportBackpressure(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var reported, reportedShed uint64
+	reportedPorts := make(map[string]uint64)
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			stats := s.Stats()
+			if stats.Dropped != reported || stats.Shed != reportedShed {
+				s.Emit(Event{
+					Type: EventError,
+					Message: fmt.Sprintf("Connection pool saturated, dropped %d and shed %d connections in the last %s: %s",
+						stats.Dropped-reported, stats.Shed-reportedShed, interval, stats),
+					Fields: map[string]any{"dropped": stats.Dropped - reported, "shed": stats.Shed - reportedShed, "queued": stats.Queued, "busy": stats.Busy},
+				})
+				reported, reportedShed = stats.Dropped, stats.Shed
+			}
+			for port, n := range stats.Limited {
+				if n == reportedPorts[port] {
+					continue
+				}
+				s.Emit(Event{
+					Type:    EventError,
+					Port:    port,
+					Message: fmt.Sprintf("Port %s at its connection limit, dropped %d connections in the last %s", port, n-reportedPorts[port], interval),
+					Fields:  map[string]any{"dropped": n - reportedPorts[port]},
+				})
+				reportedPorts[port] = n
+			}
+		}
+	}
+}
+
+// connQueue holds the accepted connections waiting for a worker by
+// priority. Workers take the oldest connection of the highest priority, so
+// high-value ports are served first when the pool is saturated.
+type connQueue struct {
+	ready chan struct{} // holds a token per queued connection, workers wait on it
+
+	mu     sync.Mutex
+	levels []connLevel // by descending priority
+	queued int
+}
+
+// connLevel holds the queued connections of one priority, oldest first.
+type connLevel struct {
+	priority int
+	conns    []queuedConn
+}
+
+// newConnQueue returns a queue holding at most size connections.
+func newConnQueue(size int) *connQueue {
+	return &connQueue{ready: make(chan struct{}, size)}
+}
+
+// push queues qc when fewer than room connections are queued. Otherwise qc
+// replaces the oldest connection of the lowest priority below its own,
+// which is returned as shed. It reports the connections queued and whether
+// qc was.
+func (q *connQueue) push(qc queuedConn, room int) (queued int, shed *queuedConn, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	full := q.queued >= min(room, cap(q.ready))
+	if full {
+		for i := len(q.levels) - 1; i >= 0 && q.levels[i].priority < qc.listener.priority; i-- {
+			if level := &q.levels[i]; len(level.conns) > 0 {
+				oldest := level.conns[0]
+				level.conns = level.conns[1:]
+				shed = &oldest
+				break
+			}
+		}
+		if shed == nil {
+			return q.queued, nil, false
+		}
+	}
+
+	i := sort.Search(len(q.levels), func(i int) bool { return q.levels[i].priority <= qc.listener.priority })
+	if i == len(q.levels) || q.levels[i].priority != qc.listener.priority {
+		q.levels = append(q.levels, connLevel{})
+		copy(q.levels[i+1:], q.levels[i:])
+		q.levels[i] = connLevel{priority: qc.listener.priority}
+	}
+	q.levels[i].conns = append(q.levels[i].conns, qc)
+	if !full {
+		q.queued++
+		q.ready <- struct{}{} // cannot block: tokens never outnumber queued connections
+	}
+	return q.queued, shed, true
+}
+
+// pop removes the oldest connection of the highest priority. The caller
+// must have taken a token from ready.
+func (q *connQueue) pop() queuedConn {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range q.levels {
+		if level := &q.levels[i]; len(level.conns) > 0 {
+			qc := level.conns[0]
+			level.conns = level.conns[1:]
+			q.queued--
+			return qc
+		}
+	}
+	panic("connection queue popped without a token")
+}
+
+// len returns the connections queued.
+func (q *connQueue) len() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queued
+}
+
+// storeMax raises v to n if n is larger.
+func storeMax(v *atomic.Int64, n int64) {
+	for old := v.Load(); n > old && !v.CompareAndSwap(old, n); old = v.Load() {
+	}
+}
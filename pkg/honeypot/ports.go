@@ -0,0 +1,74 @@
+package honeypot
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParsePortSpec expands a comma-separated port specification into a sorted list
+// of unique ports. Besides single ports it accepts ranges ("8000-8100"), the
+// wildcard "*" for every port, and exclusions prefixed with '!' ("!8080",
+// "!8050-8060") that are removed from the result regardless of their position.
+// Entries that cannot be parsed are returned in invalid.
+func ParsePortSpec(spec string) (ports []string, invalid []string) {
+	include := make(map[int]bool)
+	exclude := make(map[int]bool)
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target := include
+		rangeSpec := entry
+		if strings.HasPrefix(entry, "!") {
+			target = exclude
+			rangeSpec = entry[1:]
+		}
+
+		low, high, ok := parsePortRange(rangeSpec)
+		if !ok {
+			invalid = append(invalid, entry)
+			continue
+		}
+		for p := low; p <= high; p++ {
+			target[p] = true
+		}
+	}
+
+	sorted := make([]int, 0, len(include))
+	for p := range include {
+		if !exclude[p] {
+			sorted = append(sorted, p)
+		}
+	}
+	sort.Ints(sorted)
+	for _, p := range sorted {
+		ports = append(ports, strconv.Itoa(p))
+	}
+	return ports, invalid
+}
+
+// parsePortRange parses a single port, a "low-high" range or "*".
+func parsePortRange(spec string) (low, high int, ok bool) {
+	if spec == "*" {
+		return 1, 65535, true
+	}
+	lowStr, highStr, isRange := strings.Cut(spec, "-")
+	if !isRange {
+		highStr = lowStr
+	}
+	if !IsValidPort(lowStr) || !IsValidPort(highStr) {
+		return 0, 0, false
+	}
+	low, _ = strconv.Atoi(lowStr)
+	high, _ = strconv.Atoi(highStr)
+	return low, high, low <= high
+}
+
+// IsValidPort checks if the provided port string is a valid TCP port.
+func IsValidPort(port string) bool {
+	p, err := strconv.Atoi(port)
+	return err == nil && p > 0 && p <= 65535
+}
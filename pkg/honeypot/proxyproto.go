@@ -0,0 +1,189 @@
+package honeypot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyHeaderTimeout bounds how long we wait for a load balancer to send the PROXY header.
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Signature is the fixed 12-byte preamble of a PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyConn wraps a connection accepted from a load balancer and reports the
+// client and destination addresses announced in its PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) { return c.reader.Read(b) }
+
+func (c *proxyConn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+func (c *proxyConn) LocalAddr() net.Addr { return c.localAddr }
+
+// ParseTrustedProxies parses the addresses and CIDR networks of the load
+// balancers allowed to send PROXY headers, see Server.TrustedProxies.
+func ParseTrustedProxies(list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range list {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(s)
+			if aerr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q, want an address or a network", s)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// proxyTrusted reports whether the PROXY header of a connection from remote
+// is believed: remote is in TrustedProxies, or a loopback or private address
+// when that is empty. Anyone else could claim any client address with one.
+func (s *Server) proxyTrusted(remote net.Addr) bool {
+	addr, err := netip.ParseAddr(srcIP(remote.String()))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	if len(s.TrustedProxies) == 0 {
+		return addr.IsLoopback() || addr.IsPrivate()
+	}
+	for _, prefix := range s.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// readProxyHeader consumes a PROXY protocol v1 or v2 header from conn and returns
+// a connection whose addresses are those of the original client.
+// Headers announcing a LOCAL or UNKNOWN connection keep the socket addresses.
+func readProxyHeader(conn net.Conn) (net.Conn, error) {
+	pc := &proxyConn{
+		Conn:       conn,
+		reader:     bufio.NewReader(conn),
+		remoteAddr: conn.RemoteAddr(),
+		localAddr:  conn.LocalAddr(),
+	}
+
+	conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	// Fail fast on clients that connect directly instead of through the proxy
+	if first, err := pc.reader.Peek(1); err != nil {
+		return pc, fmt.Errorf("reading PROXY header: %w", err)
+	} else if first[0] != proxyV2Signature[0] && first[0] != 'P' {
+		return pc, errors.New("missing PROXY protocol header")
+	}
+
+	sig, err := pc.reader.Peek(len(proxyV2Signature))
+	if err == nil && bytes.Equal(sig, proxyV2Signature) {
+		return pc, pc.readV2()
+	}
+	if len(sig) >= 6 && string(sig[:6]) == "PROXY " {
+		return pc, pc.readV1()
+	}
+	if err != nil {
+		return pc, fmt.Errorf("reading PROXY header: %w", err)
+	}
+	return pc, errors.New("missing PROXY protocol header")
+}
+
+// readV1 parses the human-readable header, e.g. "PROXY TCP4 1.2.3.4 5.6.7.8 5555 21\r\n".
+func (c *proxyConn) readV1() error {
+	var line []byte
+	for len(line) < 107 { // maximum v1 header length
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return fmt.Errorf("reading PROXY v1 header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return errors.New("malformed PROXY v1 header")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("malformed PROXY v1 header: %q", strings.TrimSpace(string(line)))
+	}
+	src, err := parseProxyAddr(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	dst, err := parseProxyAddr(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+	c.remoteAddr, c.localAddr = src, dst
+	return nil
+}
+
+// readV2 parses the binary header defined in section 2.2 of the PROXY protocol spec.
+func (c *proxyConn) readV2() error {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return fmt.Errorf("reading PROXY v2 header: %w", err)
+	}
+	if header[12]>>4 != 2 {
+		return fmt.Errorf("unsupported PROXY protocol version %d", header[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return fmt.Errorf("reading PROXY v2 addresses: %w", err)
+	}
+
+	if header[12]&0x0f == 0x0 { // LOCAL: health check from the proxy itself
+		return nil
+	}
+	switch header[13] >> 4 {
+	case 0x1: // AF_INET
+		if len(payload) < 12 {
+			return errors.New("short PROXY v2 IPv4 address block")
+		}
+		c.remoteAddr = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		c.localAddr = &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+	case 0x2: // AF_INET6
+		if len(payload) < 36 {
+			return errors.New("short PROXY v2 IPv6 address block")
+		}
+		c.remoteAddr = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		c.localAddr = &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+	}
+	// AF_UNSPEC and AF_UNIX carry no usable network address; keep the socket addresses
+	return nil
+}
+
+// parseProxyAddr builds a TCP address from the textual IP and port of a v1 header.
+func parseProxyAddr(ip, port string) (*net.TCPAddr, error) {
+	parsedIP := net.ParseIP(ip)
+	p, err := strconv.Atoi(port)
+	if parsedIP == nil || err != nil || p < 0 || p > 65535 {
+		return nil, fmt.Errorf("invalid PROXY v1 address %s:%s", ip, port)
+	}
+	return &net.TCPAddr{IP: parsedIP, Port: p}, nil
+}
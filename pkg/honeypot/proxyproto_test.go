@@ -0,0 +1,73 @@
+package honeypot
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// eventRecorder is an Output passing the events on to a test.
+type eventRecorder chan Event
+
+func (r eventRecorder) Write(ev Event) error {
+	select {
+	case r <- ev:
+	default:
+	}
+	return nil
+}
+
+// TestProxyTrusted checks that PROXY headers only set the client address
+// when they come from a trusted proxy, and that other peers are served as
+// the clients they are.
+func TestProxyTrusted(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		trusted []netip.Prefix
+		client  string
+	}{
+		{"loopback by default", nil, "192.0.2.1"},
+		{"listed network", []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}, "192.0.2.1"},
+		{"listed address", []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}, "192.0.2.1"},
+		{"peer not listed", []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, "127.0.0.1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := NewServer(1)
+			srv.Ports["23"] = &PortOptions{Handler: "telnet", Proxy: true}
+			srv.TrustedProxies = tc.trusted
+			events := make(eventRecorder, 64)
+			srv.AddOutput(events)
+			defer srv.Shutdown()
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go srv.NewListener(ln, "23").Serve()
+
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			io.WriteString(conn, "PROXY TCP4 192.0.2.1 198.51.100.1 40000 23\r\n")
+
+			timeout := time.After(5 * time.Second)
+			for {
+				select {
+				case ev := <-events:
+					if ev.Type != EventConnection {
+						continue
+					}
+					if client := srcIP(ev.SrcAddr); client != tc.client {
+						t.Fatalf("connection from %s, want %s", client, tc.client)
+					}
+					return
+				case <-timeout:
+					t.Fatal("no connection event")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package honeypot
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PurgeReport records what a Purge deleted, to answer a removal request
+// such as an erasure request under Article 17 GDPR.
+type PurgeReport struct {
+	IP        string    `json:"ip"`
+	Time      time.Time `json:"time"`
+	Events    int       `json:"events"`              // stored events deleted, -1 if the backend does not tell
+	LogLines  int       `json:"log_lines"`           // text log lines deleted
+	LogFiles  []string  `json:"log_files,omitempty"` // text log files rewritten
+	Artifacts []string  `json:"artifacts,omitempty"` // artifact IDs deleted
+	Captures  []string  `json:"captures,omitempty"`  // pcap files deleted
+	Archived  []string  `json:"archived,omitempty"`  // archived objects of the files above deleted or replaced
+	Remaining []string  `json:"remaining,omitempty"` // files still mentioning the address, to review by hand
+	Errors    []string  `json:"errors,omitempty"`    // backends that could not be purged
+}
+
+// Purger deletes everything a sensor keeps about a client address. Fields
+// left nil or empty are skipped. It cannot recall events already delivered
+// to hooks, webhooks or remote collectors.
+type Purger struct {
+	Storage    Storage        // event storage, must be a StoragePurger
+	Logs       *LogFileOutput // text log files; the current one keeps being written
+	FailLog    string         // fail log file, see FailLogOutput; reopened by a running output
+	Artifacts  *ArtifactDB
+	CaptureDir string    // directory of follow-up traffic captures, see CaptureConfig
+	ReportDir  string    // directory of aggregation and trend reports, only checked for mentions
+	Archive    *Archiver // bucket the log files and captures were archived to
+}
+
+// Purge deletes the stored events of ip, the text log lines mentioning it,
+// its artifacts and the captures of its traffic, from the archive too,
+// where the rewritten log files replace the archived ones. Failures of one
+// backend are recorded in the report and don't stop the others; only an
+// invalid address is an error.
+func (p *Purger) Purge(ip string) (*PurgeReport, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, fmt.Errorf("invalid IP address %q", ip)
+	}
+	ip = parsed.String()
+	report := &PurgeReport{IP: ip, Time: time.Now().UTC()}
+	fail := func(backend string, err error) {
+		report.Errors = append(report.Errors, backend+": "+err.Error())
+	}
+
+	if p.Storage != nil {
+		purger, ok := p.Storage.(StoragePurger)
+		if !ok {
+			fail("storage", fmt.Errorf("%T cannot delete events", p.Storage))
+		} else if n, err := purger.PurgeSource(ip); err != nil {
+			fail("storage", err)
+		} else {
+			report.Events = n
+		}
+	}
+	if p.Logs != nil {
+		files, lines, err := p.Logs.Purge(ip)
+		if err != nil {
+			fail("log files", err)
+		}
+		report.LogFiles, report.LogLines = files, lines
+	}
+	if p.FailLog != "" {
+		lines, err := purgeLines(p.FailLog, ip)
+		if err != nil && !os.IsNotExist(err) {
+			fail("fail log", err)
+		}
+		if lines > 0 {
+			report.LogFiles = append(report.LogFiles, p.FailLog)
+			report.LogLines += lines
+		}
+	}
+	if p.Artifacts != nil {
+		removed, err := p.Artifacts.Purge(ip)
+		if err != nil {
+			fail("artifacts", err)
+		}
+		report.Artifacts = removed
+	}
+	if p.CaptureDir != "" {
+		// Named after the address, see capturer.start
+		matches, _ := filepath.Glob(filepath.Join(p.CaptureDir, "*-"+strings.ReplaceAll(ip, ":", "_")+"-*.pcap"))
+		for _, path := range matches {
+			if err := os.Remove(path); err != nil {
+				fail("captures", err)
+				continue
+			}
+			report.Captures = append(report.Captures, path)
+		}
+	}
+	if p.Archive != nil {
+		keys, err := p.Archive.Sync(append(append([]string(nil), report.LogFiles...), report.Captures...))
+		if err != nil {
+			fail("archive", err)
+		}
+		report.Archived = keys
+	}
+	if p.ReportDir != "" {
+		filepath.WalkDir(p.ReportDir, func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && fileMentionsIP(path, ip) {
+				report.Remaining = append(report.Remaining, path)
+			}
+			return nil
+		})
+	}
+	return report, nil
+}
+
+// purgeLines rewrites the text file at path without the lines mentioning
+// ip and returns how many were deleted. The file is left alone if none is.
+func purgeLines(path, ip string) (int, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 16<<20)
+	deleted := 0
+	for scanner.Scan() {
+		if mentionsIP(scanner.Text(), ip) {
+			deleted++
+			continue
+		}
+		w.Write(scanner.Bytes())
+		w.WriteByte('\n')
+	}
+	err = scanner.Err()
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || deleted == 0 {
+		return 0, err
+	}
+	if info, err := in.Stat(); err == nil {
+		os.Chmod(tmp.Name(), info.Mode())
+	}
+	return deleted, os.Rename(tmp.Name(), path)
+}
+
+// fileMentionsIP reports whether the file at path mentions ip.
+func fileMentionsIP(path, ip string) bool {
+	content, err := os.ReadFile(path)
+	return err == nil && mentionsIP(string(content), ip)
+}
+
+// mentionsIP reports whether text contains ip as a whole address, so
+// 192.0.2.1 is found in "from 192.0.2.1:4711" but not in "192.0.2.10".
+func mentionsIP(text, ip string) bool {
+	addrChar := func(c byte) bool {
+		return c == '.' || c == ':' || c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+	}
+	v4 := strings.Contains(ip, ".")
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], ip)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(ip)
+		before := start == 0 || !addrChar(text[start-1])
+		// An IPv4 address may be followed by its port or end a sentence
+		after := end == len(text) || !addrChar(text[end]) || v4 && text[end] == ':' ||
+			text[end] == '.' && (end+1 == len(text) || text[end+1] < '0' || text[end+1] > '9')
+		if before && after {
+			return true
+		}
+		offset = start + 1
+	}
+}
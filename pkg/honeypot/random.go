@@ -0,0 +1,131 @@
+package honeypot
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/big"
+	mathrand "math/rand"
+)
+
+// cryptoSource is a math/rand source reading crypto/rand, for the
+// unpredictable randomness of identities without a Seed.
+type cryptoSource struct{}
+
+func (cryptoSource) Uint64() uint64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+func (s cryptoSource) Int63() int64 { return int64(s.Uint64() &^ (1 << 63)) }
+func (cryptoSource) Seed(int64)     {}
+
+// Rand returns a source of randomness for purpose. When Seed is set it is
+// seeded from Seed, the profile, the host name and purpose, so the same
+// values come back on every run and every host its own; otherwise it is
+// unpredictable.
+func (id *HostIdentity) Rand(purpose string) *mathrand.Rand {
+	if id.Seed == "" {
+		return mathrand.New(cryptoSource{})
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s/%s/%s/%s", id.Seed, id.Profile, id.Hostname, purpose)
+	return mathrand.New(mathrand.NewSource(int64(h.Sum64())))
+}
+
+// Rand returns the source of randomness of the session: challenges, cookies,
+// tokens and the other values personas make up. With a seeded Identity the
+// nth session on a port gets the same values on every run, see
+// HostIdentity.Rand.
+func (m *ConnMeta) Rand() *mathrand.Rand {
+	if m.rand == nil {
+		m.rand = m.Identity.Rand(fmt.Sprintf("session/%s/%d", m.Port, m.server.sessionOrdinal(m.Identity, m.Port)))
+	}
+	return m.rand
+}
+
+// randomID returns 16 random hex digits from the session's source, in the
+// format of newSessionID.
+func (m *ConnMeta) randomID() string {
+	var id [8]byte
+	m.Rand().Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// sessionOrdinal counts the sessions of a seeded identity on port, so each
+// gets a stream of its own. Unseeded identities are not counted.
+func (s *Server) sessionOrdinal(id *HostIdentity, port string) uint64 {
+	if id.Seed == "" {
+		return 0
+	}
+	key := id.FQDN() + "/" + port
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.sessionOrdinals == nil {
+		s.sessionOrdinals = make(map[string]uint64)
+	}
+	s.sessionOrdinals[key]++
+	return s.sessionOrdinals[key]
+}
+
+// seededRSAKey generates an RSA key from rng. Unlike rsa.GenerateKey, which
+// deliberately varies its use of the reader, the same stream always gives
+// the same key.
+func seededRSAKey(rng io.Reader, bits int) (*rsa.PrivateKey, error) {
+	if bits%16 != 0 {
+		return nil, errors.New("key size must be a multiple of 16 bits")
+	}
+	e := big.NewInt(65537)
+	one := big.NewInt(1)
+	for {
+		p, err := seededPrime(rng, bits/2)
+		if err != nil {
+			return nil, err
+		}
+		q, err := seededPrime(rng, bits/2)
+		if err != nil {
+			return nil, err
+		}
+		if p.Cmp(q) == 0 {
+			continue
+		}
+		phi := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+		d := new(big.Int).ModInverse(e, phi)
+		if d == nil {
+			continue // e divides phi
+		}
+		key := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: new(big.Int).Mul(p, q), E: int(e.Int64())},
+			D:         d,
+			Primes:    []*big.Int{p, q},
+		}
+		key.Precompute()
+		return key, key.Validate()
+	}
+}
+
+// seededPrime returns the first prime from a random odd number of bits
+// bits, whose two top bits are set so the product of two has 2*bits bits.
+func seededPrime(rng io.Reader, bits int) (*big.Int, error) {
+	b := make([]byte, bits/8)
+	if _, err := io.ReadFull(rng, b); err != nil {
+		return nil, err
+	}
+	b[0] |= 0xC0
+	b[len(b)-1] |= 1
+	p := new(big.Int).SetBytes(b)
+	two := big.NewInt(2)
+	for !p.ProbablyPrime(20) {
+		p.Add(p, two)
+	}
+	if p.BitLen() != bits {
+		return seededPrime(rng, bits) // wrapped past the top
+	}
+	return p, nil
+}
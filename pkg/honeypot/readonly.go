@@ -0,0 +1,17 @@
+package honeypot
+
+import "net"
+
+// readOnlyConn is a connection whose writes are discarded, which a server in
+// read-only mode hands its handlers: they still parse and log what clients
+// send, but whatever they answer never leaves the host. Writes report
+// success so handlers carry on reading as if the client had been answered.
+type readOnlyConn struct {
+	net.Conn
+	meta *ConnMeta
+}
+
+func (c readOnlyConn) Write(b []byte) (int, error) {
+	c.meta.withheld += len(b)
+	return len(b), nil
+}
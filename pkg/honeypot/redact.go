@@ -0,0 +1,179 @@
+package honeypot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// redactionPattern is a kind of personal data and how to find it. check,
+// when set, rejects matches that only look like one, such as digit runs
+// failing a card number's checksum.
+type redactionPattern struct {
+	re    *regexp.Regexp
+	check func(match string) bool
+}
+
+// redactionPatterns are the built-in patterns, by name.
+var redactionPatterns = map[string]redactionPattern{
+	"email": {re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)},
+	"card":  {re: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), check: luhnValid},
+	"iban":  {re: regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`), check: ibanValid},
+	"ssn": {re: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), check: func(m string) bool {
+		return m[:3] != "000" && m[:3] != "666" && m[0] != '9' && m[4:6] != "00" && m[7:] != "0000"
+	}},
+	"nino": {re: regexp.MustCompile(`\b[A-CEGHJ-PR-TW-Z][A-CEGHJ-NPR-TW-Z] ?\d{2} ?\d{2} ?\d{2} ?[A-D]\b`)},
+}
+
+// RedactionPatterns returns the names of the built-in patterns: email
+// addresses, payment card numbers, IBANs, US social security numbers and UK
+// national insurance numbers.
+func RedactionPatterns() []string {
+	names := make([]string, 0, len(redactionPatterns))
+	for name := range redactionPatterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RedactionConfig selects the personal data masked in events, see Redactor.
+type RedactionConfig struct {
+	Patterns []string          `json:"patterns,omitempty"` // built-in patterns, see RedactionPatterns
+	Custom   map[string]string `json:"custom,omitempty"`   // further regular expressions, by name
+}
+
+// Enabled reports whether c masks anything.
+func (c RedactionConfig) Enabled() bool {
+	return len(c.Patterns) > 0 || len(c.Custom) > 0
+}
+
+// Redactor masks personal data in events before outputs, storage and
+// alerting see them, for sensors whose traffic may carry data of employees
+// or customers, such as those inside corporate networks. Matches in the
+// message and string fields are replaced by "[redacted:NAME]"; each field
+// changed gets a FIELD_sha256 field with the hash of its original value, so
+// payloads and credentials can still be correlated across sessions and
+// sensors, and the redacted field lists the patterns found.
+type Redactor struct {
+	names    []string
+	patterns []redactionPattern
+}
+
+// NewRedactor validates and compiles c.
+func NewRedactor(c RedactionConfig) (*Redactor, error) {
+	r := &Redactor{}
+	for _, name := range c.Patterns {
+		p, ok := redactionPatterns[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown redaction pattern %q (available: %s)", name, strings.Join(RedactionPatterns(), ", "))
+		}
+		r.names = append(r.names, name)
+		r.patterns = append(r.patterns, p)
+	}
+	custom := make([]string, 0, len(c.Custom))
+	for name := range c.Custom {
+		custom = append(custom, name)
+	}
+	sort.Strings(custom)
+	for _, name := range custom {
+		if _, ok := redactionPatterns[name]; ok {
+			return nil, fmt.Errorf("custom redaction pattern %s: name taken by a built-in pattern", name)
+		}
+		re, err := regexp.Compile(c.Custom[name])
+		if err != nil {
+			return nil, fmt.Errorf("custom redaction pattern %s: %w", name, err)
+		}
+		r.names = append(r.names, name)
+		r.patterns = append(r.patterns, redactionPattern{re: re})
+	}
+	return r, nil
+}
+
+// Redact returns ev with the personal data it contains masked. ev's Fields
+// map is copied, not modified.
+func (r *Redactor) Redact(ev Event) Event {
+	found := make(map[string]bool)
+	ev.Message = r.redact(ev.Message, found)
+	if len(ev.Fields) == 0 {
+		return ev
+	}
+	fields := make(map[string]any, len(ev.Fields))
+	for key, value := range ev.Fields {
+		fields[key] = value
+		text, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if masked := r.redact(text, found); masked != text {
+			fields[key] = masked
+			sum := sha256.Sum256([]byte(text))
+			fields[key+"_sha256"] = hex.EncodeToString(sum[:])
+		}
+	}
+	if len(found) > 0 {
+		kinds := make([]string, 0, len(found))
+		for name := range found {
+			kinds = append(kinds, name)
+		}
+		sort.Strings(kinds)
+		fields["redacted"] = kinds
+	}
+	ev.Fields = fields
+	return ev
+}
+
+// redact masks the matches of every pattern in text, recording the names
+// of those found.
+func (r *Redactor) redact(text string, found map[string]bool) string {
+	for i, p := range r.patterns {
+		name := r.names[i]
+		text = p.re.ReplaceAllStringFunc(text, func(match string) string {
+			if p.check != nil && !p.check(match) {
+				return match
+			}
+			found[name] = true
+			return "[redacted:" + name + "]"
+		})
+	}
+	return text
+}
+
+// luhnValid reports whether the digits of s pass the Luhn checksum of
+// payment card numbers.
+func luhnValid(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+		d := int(s[i] - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// ibanValid reports whether s passes the ISO 13616 mod 97 check.
+func ibanValid(s string) bool {
+	s = strings.ReplaceAll(s, " ", "")
+	var digits strings.Builder
+	for _, c := range s[4:] + s[:4] {
+		if c >= 'A' && c <= 'Z' {
+			fmt.Fprintf(&digits, "%d", c-'A'+10)
+		} else {
+			digits.WriteRune(c)
+		}
+	}
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
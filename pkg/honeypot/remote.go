@@ -0,0 +1,282 @@
+package honeypot
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RemoteConfig sends events to an HTTP endpoint as newline-delimited JSON
+// or CEF, or to a GoPot collector over the transport registered for the
+// scheme of its URL, see RegisterRemoteTransport.
+type RemoteConfig struct {
+	Name    string            `json:"name"`              // used in log messages, statistics and filters
+	URL     string            `json:"url"`               // endpoint batches are POSTed to, or grpcs://host:port of a GoPot collector
+	Headers map[string]string `json:"headers,omitempty"` // extra request headers, e.g. Authorization, sent as metadata by other transports
+	Timeout string            `json:"timeout,omitempty"` // per request, default 30s
+	TLS     *RemoteTLSConfig  `json:"tls,omitempty"`     // client certificate for collectors requiring mutual TLS
+	Format  string            `json:"format,omitempty"`  // one of RemoteFormats for http and https URLs, default json
+	Batch   BatchConfig       `json:"batch"`
+}
+
+// RemoteFormats are the formats a remote output sends events in: JSON
+// objects or CEF records, see FormatCEF, one per line.
+var RemoteFormats = []string{"json", "cef"}
+
+// RemoteTLSConfig authenticates a remote output with a client certificate,
+// as a GoPot collector requires, see Collector.
+type RemoteTLSConfig struct {
+	Cert string `json:"cert"`         // PEM client certificate, whose common name a GoPot collector names the sensor by
+	Key  string `json:"key"`          // PEM private key of Cert
+	CA   string `json:"ca,omitempty"` // PEM certificates of the CAs the server certificate must chain to, empty for the system roots
+}
+
+// RemoteTransport delivers the batches of a remote output whose URL has a
+// scheme other than http and https, see RegisterRemoteTransport.
+type RemoteTransport interface {
+	// Encode turns a batch of events into the payload Send delivers.
+	Encode(events []Event) ([]byte, error)
+	// Send delivers a payload, compressed when encoding is not empty.
+	Send(ctx context.Context, body []byte, encoding string) error
+	Close() error
+}
+
+// RemoteTransportOpener opens a transport to the URL of cfg.
+type RemoteTransportOpener func(cfg RemoteConfig) (RemoteTransport, error)
+
+var (
+	remoteTransports   = map[string]RemoteTransportOpener{}
+	remoteTransportsMu sync.RWMutex
+)
+
+// RegisterRemoteTransport makes remote outputs deliver to URLs of scheme
+// with the transports open returns. Packages providing one, such as
+// eventrpc for grpcs, call it from an init function.
+func RegisterRemoteTransport(scheme string, open RemoteTransportOpener) {
+	remoteTransportsMu.Lock()
+	defer remoteTransportsMu.Unlock()
+	if _, exists := remoteTransports[scheme]; exists || scheme == "http" || scheme == "https" {
+		panic("remote transport registered twice: " + scheme)
+	}
+	remoteTransports[scheme] = open
+}
+
+// RemoteSchemes returns the URL schemes of remote outputs: http, https and
+// the registered transports in sorted order.
+func RemoteSchemes() []string {
+	remoteTransportsMu.RLock()
+	defer remoteTransportsMu.RUnlock()
+	schemes := make([]string, 0, len(remoteTransports))
+	for scheme := range remoteTransports {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return append([]string{"http", "https"}, schemes...)
+}
+
+// RemoteOutput is an Output delivering batches of events: POSTed to an
+// HTTP endpoint, one JSON object or CEF record per line, or sent over a
+// RemoteTransport.
+type RemoteOutput struct {
+	*Batcher
+	url         string
+	headers     map[string]string
+	contentType string
+	client      *http.Client
+	timeout     time.Duration
+	transport   RemoteTransport // nil for http and https URLs
+}
+
+// NewRemoteOutput validates cfg and starts delivering to its URL. Failed
+// deliveries are reported to logger.
+func NewRemoteOutput(cfg RemoteConfig, logger *log.Logger) (*RemoteOutput, error) {
+	name := remoteName(cfg)
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Host == "" || !slices.Contains(RemoteSchemes(), u.Scheme) {
+		return nil, fmt.Errorf("%s: %q is not a URL of scheme %s", name, cfg.URL, strings.Join(RemoteSchemes(), ", "))
+	}
+	timeout := 30 * time.Second
+	if cfg.Timeout != "" {
+		if timeout, err = time.ParseDuration(cfg.Timeout); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("%s: invalid timeout %q", name, cfg.Timeout)
+		}
+	}
+	o := &RemoteOutput{url: cfg.URL, headers: cfg.Headers, timeout: timeout}
+	var encode BatchEncoder
+	send := o.post
+	if u.Scheme == "http" || u.Scheme == "https" {
+		o.contentType, o.client = "application/x-ndjson", &http.Client{Timeout: timeout}
+		encode = encodeNDJSON
+		switch cfg.Format {
+		case "", "json":
+		case "cef":
+			encode, o.contentType = encodeCEF, "text/plain; charset=utf-8"
+		default:
+			return nil, fmt.Errorf("%s: unknown format %q, want %s", name, cfg.Format, strings.Join(RemoteFormats, " or "))
+		}
+		if cfg.TLS != nil {
+			if u.Scheme != "https" {
+				return nil, fmt.Errorf("%s: client certificates need an https URL", name)
+			}
+			config, err := cfg.TLS.ClientConfig()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			o.client.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: config, ForceAttemptHTTP2: true}
+		}
+	} else {
+		if cfg.Format != "" {
+			return nil, fmt.Errorf("%s: format only applies to http and https URLs", name)
+		}
+		remoteTransportsMu.RLock()
+		open := remoteTransports[u.Scheme]
+		remoteTransportsMu.RUnlock()
+		if o.transport, err = open(cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		encode, send = o.transport.Encode, o.sendTransport
+	}
+	batcher, err := NewBatcher(name, cfg.Batch, encode, send)
+	if err != nil {
+		if o.transport != nil {
+			o.transport.Close()
+		}
+		return nil, err
+	}
+	batcher.Log = logger
+	o.Batcher = batcher
+	return o, nil
+}
+
+// encodeNDJSON encodes events as one JSON object per line.
+func encodeNDJSON(events []Event) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, ev := range events {
+		if err := enc.Encode(ev); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeCEF encodes events as one CEF record per line.
+func encodeCEF(events []Event) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, ev := range events {
+		buf.WriteString(FormatCEF(ev))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func (o *RemoteOutput) post(body []byte, encoding string) error {
+	req, err := http.NewRequest(http.MethodPost, o.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", o.contentType)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	for key, value := range o.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", o.url, resp.Status)
+	}
+	return nil
+}
+
+// sendTransport delivers a payload over the transport, within the timeout.
+func (o *RemoteOutput) sendTransport(body []byte, encoding string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+	return o.transport.Send(ctx, body, encoding)
+}
+
+// Close delivers the events still queued, or spools them if the destination
+// is unreachable, and closes the transport.
+func (o *RemoteOutput) Close() error {
+	err := o.Batcher.Close()
+	if o.transport != nil {
+		if cerr := o.transport.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// ClientConfig returns the TLS configuration presenting the client
+// certificate of c.
+func (c *RemoteTLSConfig) ClientConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("loading client certificate: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if c.CA != "" {
+		if config.RootCAs, err = loadCertPool(c.CA); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// loadCertPool reads the PEM certificates of a file into a pool.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("%s holds no PEM certificate", path)
+	}
+	return pool, nil
+}
+
+// remoteName returns the name of a remote output used in log messages and filters.
+func remoteName(cfg RemoteConfig) string {
+	if cfg.Name == "" {
+		return "remote"
+	}
+	return cfg.Name
+}
+
+// OutputStats returns the delivery statistics of the batching outputs of s,
+// such as RemoteOutput, by name.
+func (s *Server) OutputStats() map[string]BatchStats {
+	stats := make(map[string]BatchStats)
+	for _, o := range s.outputs {
+		if f, ok := o.(*FilteredOutput); ok {
+			o = f.Output
+		}
+		if b, ok := o.(interface {
+			Name() string
+			Stats() BatchStats
+		}); ok {
+			stats[b.Name()] = b.Stats()
+		}
+	}
+	return stats
+}
@@ -0,0 +1,259 @@
+package honeypot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kinds of input a Responder replies to.
+const (
+	ResponderShell = "shell" // a command line typed into a fake shell
+	ResponderHTTP  = "http"  // a request for a path a persona doesn't know
+)
+
+// Responder defaults.
+const (
+	responderMaxBytes  = 2048
+	responderPerHour   = 120
+	responderCacheSize = 1024
+)
+
+// ResponderConfig configures a Responder.
+type ResponderConfig struct {
+	URL      string            `json:"url"`                 // chat completions endpoint, empty to disable
+	Model    string            `json:"model,omitempty"`     // model name sent with each request
+	Headers  map[string]string `json:"headers,omitempty"`   // extra request headers, e.g. Authorization for hosted APIs
+	Timeout  string            `json:"timeout,omitempty"`   // per reply, default 10s
+	MaxBytes int               `json:"max_bytes,omitempty"` // replies are cut to this length, default 2048
+	PerHour  int               `json:"per_hour,omitempty"`  // model requests per hour, default 120; cached replies are free
+}
+
+// Responder generates replies to the shell commands and HTTP requests
+// personas have no canned answer for, with a language model behind an
+// OpenAI-compatible chat completions API: a local llama.cpp, Ollama or vLLM
+// server keeps the sensor offline, a hosted API works too. Replies are
+// filtered and cut before they reach the attacker, and cached by host and
+// input so that asking twice gives the same answer. When the model is
+// unreachable, too slow, over budget or its reply is filtered out, personas
+// fall back to their canned answers.
+type Responder struct {
+	url      string
+	model    string
+	headers  map[string]string
+	client   *http.Client
+	maxBytes int
+	perHour  int
+
+	mu     sync.Mutex
+	hour   time.Time // start of the hour requests are counted in
+	used   int       // requests sent in that hour
+	cache  map[string]string
+	cached []string // keys of cache, oldest first
+}
+
+// NewResponder validates cfg and returns a Responder using its model.
+func NewResponder(cfg ResponderConfig) (*Responder, error) {
+	if u, err := url.Parse(cfg.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an http or https URL", cfg.URL)
+	}
+	timeout := 10 * time.Second
+	if cfg.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(cfg.Timeout); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", cfg.Timeout)
+		}
+	}
+	if cfg.MaxBytes < 0 || cfg.PerHour < 0 {
+		return nil, errors.New("max_bytes and per_hour cannot be negative")
+	}
+	r := &Responder{
+		url: cfg.URL, model: cfg.Model, headers: cfg.Headers, client: &http.Client{Timeout: timeout},
+		maxBytes: responderMaxBytes, perHour: responderPerHour, cache: make(map[string]string),
+	}
+	if cfg.MaxBytes > 0 {
+		r.maxBytes = cfg.MaxBytes
+	}
+	if cfg.PerHour > 0 {
+		r.perHour = cfg.PerHour
+	}
+	return r, nil
+}
+
+// respond returns the server's Responder's reply to input of kind on this
+// connection, logging the exchange, and false when there is none.
+func (m *ConnMeta) respond(kind, input string) (string, bool) {
+	r := m.server.Responder
+	if r == nil || strings.TrimSpace(input) == "" {
+		return "", false
+	}
+	ctx, cancel := context.WithTimeout(m.server.ctx, r.client.Timeout)
+	defer cancel()
+	reply, cached, err := r.reply(ctx, m.Identity, kind, input)
+	if err != nil {
+		m.Logf("No generated reply to %q on port %s from %s: %s", input, m.Port, m.ClientAddr, err)
+		return "", false
+	}
+	m.Emit(Event{
+		Type:    EventData,
+		Message: fmt.Sprintf("Generated %s reply to %q on port %s from %s: %s", kind, input, m.Port, m.ClientAddr, escapePayload(reply)),
+		Fields:  map[string]any{"responder_kind": kind, "responder_input": input, "responder_output": reply, "responder_cached": cached},
+	})
+	return reply, true
+}
+
+// reply returns the filtered reply of the model to input, from the cache
+// when the host was asked the same before.
+func (r *Responder) reply(ctx context.Context, id *HostIdentity, kind, input string) (reply string, cached bool, err error) {
+	key := id.FQDN() + "\x00" + kind + "\x00" + input
+	r.mu.Lock()
+	if reply, ok := r.cache[key]; ok {
+		r.mu.Unlock()
+		return reply, true, nil
+	}
+	if now := time.Now(); now.Sub(r.hour) >= time.Hour {
+		r.hour, r.used = now, 0
+	}
+	if r.used >= r.perHour {
+		r.mu.Unlock()
+		return "", false, fmt.Errorf("budget of %d requests per hour used up", r.perHour)
+	}
+	r.used++
+	r.mu.Unlock()
+
+	text, err := r.complete(ctx, responderPrompt(id, kind), input)
+	if err != nil {
+		return "", false, err
+	}
+	if reply, err = filterResponse(kind, text, r.maxBytes); err != nil {
+		return "", false, err
+	}
+
+	r.mu.Lock()
+	if _, ok := r.cache[key]; !ok {
+		if len(r.cached) >= responderCacheSize {
+			delete(r.cache, r.cached[0])
+			r.cached = r.cached[1:]
+		}
+		r.cache[key] = reply
+		r.cached = append(r.cached, key)
+	}
+	r.mu.Unlock()
+	return reply, false, nil
+}
+
+// complete sends one chat completion request and returns the text of the
+// first choice.
+func (r *Responder) complete(ctx context.Context, system, input string) (string, error) {
+	type message struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	body, err := json.Marshal(map[string]any{
+		"model":       r.model,
+		"messages":    []message{{Role: "system", Content: system}, {Role: "user", Content: input}},
+		"max_tokens":  r.maxBytes / 2,
+		"temperature": 0.2,
+		"stream":      false,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range r.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		io.Copy(io.Discard, resp.Body)
+		return "", fmt.Errorf("%s returned %s", r.url, resp.Status)
+	}
+	var completion struct {
+		Choices []struct {
+			Message message `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&completion); err != nil {
+		return "", fmt.Errorf("decoding the reply of %s: %w", r.url, err)
+	}
+	if len(completion.Choices) == 0 {
+		return "", fmt.Errorf("%s returned no choices", r.url)
+	}
+	return completion.Choices[0].Message.Content, nil
+}
+
+// responderPrompt returns the system prompt describing the host that
+// replies to input of kind.
+func responderPrompt(id *HostIdentity, kind string) string {
+	if kind == ResponderHTTP {
+		return fmt.Sprintf("You are the web server %q of the host %s (%s). The user message is an HTTP request line. "+
+			"Reply with only the HTML body this server would send for it: a plausible, short page of an ordinary business site. "+
+			"No explanations, no markdown, no headers.", id.HTTPServerHeader(), id.FQDN(), id.OS)
+	}
+	user := id.User
+	if user == "" {
+		user = "root"
+	}
+	return fmt.Sprintf("You are the terminal of the host %s (%s), logged in as %s. The user message is a command line. "+
+		"Reply with only the exact output the command would print, empty if it prints nothing, or the shell's error message. "+
+		"No explanations, no markdown, no prompt.", id.FQDN(), id.OS, user)
+}
+
+// responderTells are phrases giving away that a reply was generated; replies
+// containing one are discarded.
+var responderTells = []string{
+	"as an ai", "language model", "i'm sorry", "i am sorry", "i cannot", "i can't", "i'm unable", "honeypot",
+	"simulat", "openai", "chatgpt", "here is the output", "here's the output",
+}
+
+// filterResponse cleans up the text of a model: it removes markdown code
+// fences, rejects replies giving the game away, drops control characters
+// and cuts the reply to maxBytes. Shell output ends with a newline.
+func filterResponse(kind, text string, maxBytes int) (string, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "```") {
+		_, text, _ = strings.Cut(text, "\n")
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+	}
+	lower := strings.ToLower(text)
+	for _, tell := range responderTells {
+		if strings.Contains(lower, tell) {
+			return "", fmt.Errorf("reply discarded for containing %q", tell)
+		}
+	}
+	text = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || (unicode.IsControl(r) && r != '\n' && r != '\t') {
+			return -1
+		}
+		return r
+	}, text)
+	if len(text) > maxBytes {
+		cut := maxBytes
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut]
+	}
+	if kind == ResponderShell && text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return text, nil
+}
@@ -0,0 +1,316 @@
+package honeypot
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Defaults of sample fetching.
+const (
+	defaultSampleMaxSize = 10 << 20
+	defaultSampleTimeout = 30 * time.Second
+	sampleQueue          = 1000 // URLs waiting to be fetched before new ones are skipped
+	sampleUserAgent      = "Wget/1.21.2"
+)
+
+// SampleConfig enables fetching the samples behind payload URLs.
+type SampleConfig struct {
+	Dir          string `json:"dir"`                     // directory samples are stored in, named by their SHA-256, empty to disable
+	MaxSize      int64  `json:"max_size,omitempty"`      // bytes kept of a sample, default 10 MB, larger ones are cut
+	Timeout      string `json:"timeout,omitempty"`       // how long a download may take, default 30s
+	Rate         int    `json:"rate,omitempty"`          // downloads per minute, default 10
+	Proxy        string `json:"proxy,omitempty"`         // HTTP or SOCKS5 proxy HTTP downloads go through, e.g. socks5://127.0.0.1:9050
+	AllowPrivate bool   `json:"allow_private,omitempty"` // also fetch from private, loopback and link-local addresses
+}
+
+// SampleInfo describes a sample fetched from a payload URL.
+type SampleInfo struct {
+	URL       string    `json:"url"`                 // URL it was first fetched from
+	Size      int64     `json:"size"`                // bytes stored
+	Type      string    `json:"type"`                // e.g. "elf/mips", "script" or a MIME type, see sampleType
+	Truncated bool      `json:"truncated,omitempty"` // the sample was larger than max_size
+	Fetched   time.Time `json:"fetched"`
+}
+
+// SampleFetch is the outcome of fetching a payload URL, kept on its
+// artifact.
+type SampleFetch struct {
+	Time   time.Time `json:"time"`
+	Sample string    `json:"sample,omitempty"` // SHA-256 of what it served
+	Error  string    `json:"error,omitempty"`
+}
+
+// sampleFetcher downloads samples into a directory. Downloads never reach
+// the addresses of the sensor's own networks unless allowed, their size and
+// duration are bounded, and samples are stored read-only and never run.
+type sampleFetcher struct {
+	dir          string
+	maxSize      int64
+	timeout      time.Duration
+	interval     time.Duration
+	allowPrivate bool
+	client       *http.Client
+}
+
+// newSampleFetcher validates cfg.
+func newSampleFetcher(cfg SampleConfig) (*sampleFetcher, error) {
+	f := &sampleFetcher{dir: cfg.Dir, maxSize: cfg.MaxSize, timeout: defaultSampleTimeout, allowPrivate: cfg.AllowPrivate}
+	if f.maxSize == 0 {
+		f.maxSize = defaultSampleMaxSize
+	}
+	if f.maxSize < 0 {
+		return nil, errors.New("samples: max_size must be positive")
+	}
+	if cfg.Timeout != "" {
+		timeout, err := time.ParseDuration(cfg.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("samples: invalid timeout %q", cfg.Timeout)
+		}
+		f.timeout = timeout
+	}
+	rate := cfg.Rate
+	if rate == 0 {
+		rate = 10
+	}
+	if rate < 0 {
+		return nil, errors.New("samples: rate must be positive")
+	}
+	f.interval = time.Minute / time.Duration(rate)
+
+	transport := &http.Transport{
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second, Control: f.checkDial}).DialContext,
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true}, // droppers rarely have valid certificates
+		ResponseHeaderTimeout: f.timeout,
+		DisableKeepAlives:     true,
+	}
+	if cfg.Proxy != "" {
+		proxy, err := url.Parse(cfg.Proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("samples: invalid proxy %q", cfg.Proxy)
+		}
+		// The proxy is usually local, and resolves and connects for us
+		transport.Proxy = http.ProxyURL(proxy)
+		transport.DialContext = (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+	}
+	f.client = &http.Client{
+		Transport: transport,
+		Timeout:   f.timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+	return f, nil
+}
+
+// checkDial refuses connections to addresses that are not on the internet,
+// so attackers cannot point the downloader at the sensor's own networks.
+func (f *sampleFetcher) checkDial(network, address string, _ syscall.RawConn) error {
+	if f.allowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return fmt.Errorf("refusing to fetch from %s, not an internet address", host)
+	}
+	return nil
+}
+
+// fetch downloads the sample at rawURL, over HTTP, HTTPS or TFTP, and
+// stores it. It returns the hash and description of the sample.
+func (f *sampleFetcher) fetch(rawURL string) (string, *SampleInfo, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", nil, err
+	}
+	tmp, err := os.CreateTemp(f.dir, ".fetch-*")
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	sum := sha256.New()
+	w := &sampleWriter{w: io.MultiWriter(tmp, sum), left: f.maxSize}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		err = f.fetchHTTP(u, w)
+	case "tftp":
+		err = f.fetchTFTP(u, w)
+	default:
+		err = fmt.Errorf("%s URLs are not fetched", u.Scheme)
+	}
+	if err != nil && !errors.Is(err, errSampleTooLarge) {
+		return "", nil, err
+	}
+	if w.size == 0 {
+		return "", nil, errors.New("empty response")
+	}
+	if err := tmp.Close(); err != nil {
+		return "", nil, err
+	}
+	hash := hex.EncodeToString(sum.Sum(nil))
+	path := filepath.Join(f.dir, hash)
+	if err := os.Chmod(tmp.Name(), 0o440); err != nil {
+		return "", nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", nil, err
+	}
+	info := &SampleInfo{URL: rawURL, Size: w.size, Type: sampleType(w.head), Truncated: w.truncated, Fetched: time.Now().UTC()}
+	return hash, info, nil
+}
+
+func (f *sampleFetcher) fetchHTTP(u *url.URL, w io.Writer) error {
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", sampleUserAgent)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", u.Host, resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// fetchTFTP reads a file with a TFTP read request in octet mode (RFC 1350).
+func (f *sampleFetcher) fetchTFTP(u *url.URL, w io.Writer) error {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "69")
+	}
+	addr, err := net.ResolveUDPAddr("udp", host)
+	if err != nil {
+		return err
+	}
+	if err := f.checkDial("udp", addr.String(), nil); err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(f.timeout))
+
+	request := append([]byte{0, 1}, strings.TrimPrefix(u.Path, "/")...)
+	request = append(append(request, 0), "octet\x00"...)
+	if _, err := conn.WriteToUDP(request, addr); err != nil {
+		return err
+	}
+	buffer := make([]byte, 4+512)
+	expected := uint16(1)
+	for {
+		n, from, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return err
+		}
+		// The server answers from a port of its own for the transfer
+		if !from.IP.Equal(addr.IP) || n < 4 {
+			continue
+		}
+		switch binary.BigEndian.Uint16(buffer) {
+		case 3: // DATA
+			if block := binary.BigEndian.Uint16(buffer[2:]); block == expected {
+				if _, err := w.Write(buffer[4:n]); err != nil {
+					return err
+				}
+				ack := []byte{0, 4, buffer[2], buffer[3]}
+				if _, err := conn.WriteToUDP(ack, from); err != nil {
+					return err
+				}
+				if n < len(buffer) {
+					return nil
+				}
+				expected++
+			}
+		case 5: // ERROR
+			return fmt.Errorf("TFTP error %d: %s", binary.BigEndian.Uint16(buffer[2:]), strings.TrimRight(string(buffer[4:n]), "\x00"))
+		}
+	}
+}
+
+// errSampleTooLarge stops a download at the size limit.
+var errSampleTooLarge = errors.New("sample too large")
+
+// sampleWriter counts the bytes of a sample, keeps its first bytes to
+// identify it, and stops once it reaches its size limit.
+type sampleWriter struct {
+	w         io.Writer
+	left      int64
+	size      int64
+	head      []byte
+	truncated bool
+}
+
+func (s *sampleWriter) Write(p []byte) (int, error) {
+	if len(s.head) < 512 {
+		s.head = append(s.head, p[:min(len(p), 512-len(s.head))]...)
+	}
+	if int64(len(p)) > s.left {
+		p = p[:s.left]
+		s.truncated = true
+	}
+	n, err := s.w.Write(p)
+	s.left -= int64(n)
+	s.size += int64(n)
+	if err == nil && s.truncated {
+		err = errSampleTooLarge
+	}
+	return n, err
+}
+
+// elfMachines names the architectures of ELF executables bots are built
+// for, by e_machine.
+var elfMachines = map[uint16]string{
+	2: "sparc", 3: "x86", 4: "m68k", 8: "mips", 20: "powerpc", 21: "powerpc64", 40: "arm", 42: "sh", 62: "x86-64", 183: "aarch64", 243: "riscv",
+}
+
+// sampleType identifies a sample by its first bytes: "elf/" followed by
+// the architecture of ELF executables, such as "elf/mipsel", "script" for
+// shell scripts, or else the MIME type Go detects.
+func sampleType(head []byte) string {
+	if len(head) >= 20 && string(head[:4]) == "\x7fELF" {
+		var order binary.ByteOrder = binary.LittleEndian
+		if head[5] == 2 {
+			order = binary.BigEndian
+		}
+		arch, ok := elfMachines[order.Uint16(head[18:])]
+		if !ok {
+			arch = fmt.Sprintf("machine-%d", order.Uint16(head[18:]))
+		}
+		if arch == "mips" && head[5] == 1 {
+			arch = "mipsel"
+		}
+		return "elf/" + arch
+	}
+	if strings.HasPrefix(string(head), "#!") {
+		return "script"
+	}
+	return strings.SplitN(http.DetectContentType(head), ";", 2)[0]
+}
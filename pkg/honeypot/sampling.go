@@ -0,0 +1,239 @@
+package honeypot
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// UnsampledEvents are the event types sampling never drops: the evidence of
+// what clients tried and the alerts and blocks it raised.
+var UnsampledEvents = []string{EventCredential, EventCanary, EventData, EventAlert, EventDDoSPrep, EventFirewall, EventConfigSummary}
+
+// SamplingRule thins out one noisy event type, such as the connection
+// events of a scanning wave. Events are counted by client network within a
+// window: the first Keep are kept, then one in OneIn.
+type SamplingRule struct {
+	Type       string `json:"type"`                  // event type thinned out, not one of UnsampledEvents
+	Window     string `json:"window,omitempty"`      // how long a network's count lasts, default 1m
+	Keep       int    `json:"keep,omitempty"`        // events of a network kept every window before sampling starts
+	OneIn      int    `json:"one_in,omitempty"`      // keep one in this many of the others, 0 to drop them
+	IPv4Prefix int    `json:"ipv4_prefix,omitempty"` // length of the IPv4 networks counted together, default 24
+	IPv6Prefix int    `json:"ipv6_prefix,omitempty"` // length of the IPv6 networks counted together, default 48
+}
+
+// sampler applies the sampling rules of a server, see EnableSampling.
+type sampler struct {
+	rules  map[string]SamplingRule // by event type, with defaults applied
+	window map[string]time.Duration
+	mu     sync.Mutex
+	groups map[samplingKey]*samplingGroup
+}
+
+type samplingKey struct {
+	eventType string
+	network   string // client network, empty for events without a client
+}
+
+// samplingGroup counts the events of one type and network in a window.
+type samplingGroup struct {
+	start time.Time
+	seen  int
+	kept  int
+}
+
+func newSampler(rules []SamplingRule) (*sampler, error) {
+	sa := &sampler{
+		rules:  make(map[string]SamplingRule),
+		window: make(map[string]time.Duration),
+		groups: make(map[samplingKey]*samplingGroup),
+	}
+	for _, r := range rules {
+		switch {
+		case r.Type == "":
+			return nil, fmt.Errorf("sampling rule without an event type")
+		case slices.Contains(UnsampledEvents, r.Type):
+			return nil, fmt.Errorf("%s events are never sampled", r.Type)
+		case sa.rules[r.Type].Type != "":
+			return nil, fmt.Errorf("%s events sampled by two rules", r.Type)
+		case r.Keep < 0 || r.OneIn < 0:
+			return nil, fmt.Errorf("%s sampling: keep and one_in cannot be negative", r.Type)
+		case r.Keep == 0 && r.OneIn == 0:
+			return nil, fmt.Errorf("%s sampling: set keep or one_in, or drop the events with an output filter", r.Type)
+		case r.IPv4Prefix < 0 || r.IPv4Prefix > 32:
+			return nil, fmt.Errorf("%s sampling: invalid IPv4 prefix length %d", r.Type, r.IPv4Prefix)
+		case r.IPv6Prefix < 0 || r.IPv6Prefix > 128:
+			return nil, fmt.Errorf("%s sampling: invalid IPv6 prefix length %d", r.Type, r.IPv6Prefix)
+		}
+		window := time.Minute
+		if r.Window != "" {
+			d, err := time.ParseDuration(r.Window)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("%s sampling: invalid window %q", r.Type, r.Window)
+			}
+			window = d
+		}
+		if r.IPv4Prefix == 0 {
+			r.IPv4Prefix = 24
+		}
+		if r.IPv6Prefix == 0 {
+			r.IPv6Prefix = 48
+		}
+		sa.rules[r.Type], sa.window[r.Type] = r, window
+	}
+	return sa, nil
+}
+
+// EnableSampling keeps only a sample of the events of the types rules name,
+// counted by client network so that a scanning wave cannot flood the logs.
+// Sampled-out events never reach the outputs, nor take a sequence number,
+// but still count towards firewall blocks. Once a window in which events were
+// dropped ends, an info event reports how many.
+func (s *Server) EnableSampling(rules []SamplingRule) error {
+	sa, err := newSampler(rules)
+	if err != nil {
+		return err
+	}
+	s.sampler = sa
+	go s.sweepSamples()
+	return nil
+}
+
+// sample reports whether ev is kept, emitting the summary of the window of
+// its network that just ended, if any.
+func (s *Server) sample(ev Event) bool {
+	keep, summary := s.sampler.allow(ev, time.Now())
+	if summary != nil {
+		s.Emit(*summary)
+	}
+	return keep
+}
+
+// sweepSamples reports the windows that ended until Shutdown, which reports
+// the others.
+func (s *Server) sweepSamples() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, summary := range s.sampler.sweep(now, false) {
+				s.Emit(summary)
+			}
+		}
+	}
+}
+
+// flushSamples reports the windows still open, as Shutdown ends.
+func (s *Server) flushSamples() {
+	if s.sampler == nil {
+		return
+	}
+	for _, summary := range s.sampler.sweep(time.Now(), true) {
+		s.Emit(summary)
+	}
+}
+
+// allow counts ev in its group and reports whether it is kept, along with
+// the summary of the group's previous window when ev starts a new one.
+func (sa *sampler) allow(ev Event, now time.Time) (bool, *Event) {
+	r, ok := sa.rules[ev.Type]
+	if !ok {
+		return true, nil
+	}
+	key := samplingKey{eventType: ev.Type, network: r.network(ev.SrcAddr)}
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	var summary *Event
+	g := sa.groups[key]
+	if g != nil && now.Sub(g.start) >= sa.window[ev.Type] {
+		if g.seen > g.kept {
+			previous := sa.summary(key, g, now)
+			summary = &previous
+		}
+		g = nil
+	}
+	if g == nil {
+		g = &samplingGroup{start: now}
+		sa.groups[key] = g
+	}
+	g.seen++
+	keep := g.seen <= r.Keep || r.OneIn > 0 && (g.seen-r.Keep-1)%r.OneIn == 0
+	if keep {
+		g.kept++
+	}
+	return keep, summary
+}
+
+// sweep forgets the groups whose window ended, or all of them, and returns
+// the summaries of those that dropped events, in a stable order.
+func (sa *sampler) sweep(now time.Time, all bool) []Event {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	var keys []samplingKey
+	for key, g := range sa.groups {
+		if all || now.Sub(g.start) >= sa.window[key.eventType] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].eventType != keys[j].eventType {
+			return keys[i].eventType < keys[j].eventType
+		}
+		return keys[i].network < keys[j].network
+	})
+	var summaries []Event
+	for _, key := range keys {
+		if g := sa.groups[key]; g.seen > g.kept {
+			summaries = append(summaries, sa.summary(key, g, now))
+		}
+		delete(sa.groups, key)
+	}
+	return summaries
+}
+
+// summary describes the events of g dropped by sampling.
+func (sa *sampler) summary(key samplingKey, g *samplingGroup, now time.Time) Event {
+	from := ""
+	if key.network != "" {
+		from = " from " + key.network
+	}
+	elapsed := min(now.Sub(g.start), sa.window[key.eventType]).Round(time.Second)
+	ev := Event{
+		Type:    EventInfo,
+		Message: fmt.Sprintf("Sampling kept %d of %d %s events%s in %s", g.kept, g.seen, key.eventType, from, elapsed),
+		Fields: map[string]any{
+			"sampled_type": key.eventType,
+			"kept":         g.kept,
+			"dropped":      g.seen - g.kept,
+		},
+	}
+	if key.network != "" {
+		ev.Fields["src_network"] = key.network
+	}
+	return ev
+}
+
+// network returns the client network of addr the rule counts events by, or
+// an empty string if addr is not an IP address.
+func (r SamplingRule) network(addr string) string {
+	ip, err := netip.ParseAddr(srcIP(addr))
+	if err != nil {
+		return ""
+	}
+	ip = ip.Unmap().WithZone("")
+	bits := r.IPv6Prefix
+	if ip.Is4() {
+		bits = r.IPv4Prefix
+	}
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
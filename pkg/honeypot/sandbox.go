@@ -0,0 +1,102 @@
+package honeypot
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// SandboxPolicy is what the process still needs once its listeners are
+// bound, see EnterSandbox.
+type SandboxPolicy struct {
+	ReadOnly  []string // files and directories read
+	ReadWrite []string // files and directories written, including the files created and removed in them
+	Exec      bool     // runs commands, for hooks and the host firewall
+	Programs  []string // programs run besides those of the system directories, with Exec
+}
+
+// sandboxSystemPaths are read by the standard library after startup: name
+// resolution, TLS roots, time zones and the CPU quota of the cgroup.
+var sandboxSystemPaths = []string{
+	"/etc/resolv.conf", "/etc/hosts", "/etc/nsswitch.conf", "/etc/gai.conf", "/etc/services", "/etc/protocols",
+	"/etc/localtime", "/usr/share/zoneinfo",
+	"/etc/ssl", "/etc/pki", "/etc/ca-certificates", "/usr/share/ca-certificates", "/usr/local/share/certs",
+	"/proc/self", "/sys/fs/cgroup", "/etc/ld.so.cache", "/etc/ld.so.conf", "/etc/ld.so.conf.d",
+}
+
+// sandboxExecPaths hold the programs hooks and the firewall run and their
+// libraries.
+var sandboxExecPaths = []string{"/bin", "/sbin", "/usr/bin", "/usr/sbin", "/usr/local/bin", "/usr/local/sbin", "/lib", "/lib64", "/usr/lib", "/usr/lib64", "/usr/libexec", "/usr/local/lib"}
+
+// SandboxPolicy returns the policy the sensor configured by c needs: the
+// log, report, capture, storage, artifact and spool directories are
+// written, the TFTP root and file feeds read, and commands run when there
+// are hooks, a firewall backend or Kubernetes reloads. Hook commands given
+// by path may lie outside the system directories.
+func (c *Config) SandboxPolicy() SandboxPolicy {
+	var p SandboxPolicy
+	dirOf := func(path string) {
+		if path != "" {
+			p.ReadWrite = append(p.ReadWrite, filepath.Dir(path))
+		}
+	}
+	for _, dir := range []string{c.LogDir, c.Capture.Dir, c.Reports.Dir, c.Artifacts.Samples.Dir, c.DShield.Batch.Spool.Dir, c.CrowdSec.Batch.Spool.Dir, c.OTel.Batch.Spool.Dir, c.GELF.Batch.Spool.Dir, c.Splunk.Batch.Spool.Dir} {
+		if dir != "" {
+			p.ReadWrite = append(p.ReadWrite, dir)
+		}
+	}
+	for _, rc := range c.Remotes {
+		if rc.Batch.Spool.Dir != "" {
+			p.ReadWrite = append(p.ReadWrite, rc.Batch.Spool.Dir)
+		}
+	}
+	// Files are replaced on rotation and purges, so their directories are written
+	dirOf(c.FailLog.Path)
+	dirOf(c.Artifacts.Path)
+	dirOf(c.Honeytokens.File)
+	if c.LogSigning.Method == "hmac" {
+		// Replaced by the next key every day
+		p.ReadWrite = append(p.ReadWrite, c.LogSigning.Key)
+	}
+	if u, err := url.Parse(c.Storage.DSN); err == nil && (u.Scheme == "file" || u.Scheme == "sqlite") {
+		path := u.Path
+		if u.Opaque != "" {
+			path = u.Opaque
+		}
+		dirOf(path)
+	}
+
+	if c.TFTPRoot != "" {
+		p.ReadOnly = append(p.ReadOnly, c.TFTPRoot)
+	}
+	for _, fc := range c.Feeds {
+		if u, err := url.Parse(fc.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			path := fc.URL
+			if err == nil && u.Scheme == "file" {
+				path = u.Path
+			}
+			p.ReadOnly = append(p.ReadOnly, path)
+		}
+	}
+	p.Exec = len(c.Hooks) > 0 || c.Firewall.Backend != ""
+	// Reloads validate the new configuration and replace the process with the executable
+	if interval, err := c.Kubernetes.ReloadInterval(); c.Kubernetes.Enabled && err == nil && interval > 0 && c.Path != "" {
+		p.Exec = true
+		if exe, err := os.Executable(); err == nil {
+			p.Programs = append(p.Programs, exe)
+		}
+		p.ReadOnly = append(p.ReadOnly, filepath.Dir(c.Path))
+	}
+	for _, hc := range c.Hooks {
+		if len(hc.Command) > 0 && filepath.IsAbs(hc.Command[0]) {
+			p.Programs = append(p.Programs, hc.Command[0])
+		}
+	}
+	switch c.Firewall.Backend {
+	case FirewallIptables:
+		p.ReadWrite = append(p.ReadWrite, "/run/xtables.lock")
+	case FirewallPF:
+		p.ReadWrite = append(p.ReadWrite, "/dev/pf")
+	}
+	return p
+}
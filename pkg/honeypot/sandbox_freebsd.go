@@ -0,0 +1,35 @@
+package honeypot
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	sysProcctl             = 544
+	procTraceCtl           = 7
+	procTraceCtlDisableExe = 3
+	procNoNewPrivsCtl      = 19
+	procNoNewPrivsEnable   = 1
+	pPID                   = 0
+)
+
+// EnterSandbox restricts the process to p for the rest of its life and
+// returns the mechanisms applied. On FreeBSD, procctl(2) keeps commands from
+// gaining privileges through setuid binaries and the process from being
+// debugged. Capsicum's capability mode is not entered: it forbids opening
+// files by path and connecting sockets, which log rotation, storage and
+// every network output need after startup, so p is not enforced.
+func EnterSandbox(p SandboxPolicy) ([]string, error) {
+	pid := uintptr(syscall.Getpid())
+	nnp := procNoNewPrivsEnable
+	if _, _, errno := syscall.Syscall6(sysProcctl, pPID, pid, procNoNewPrivsCtl, uintptr(unsafe.Pointer(&nnp)), 0, 0); errno != 0 {
+		return nil, fmt.Errorf("procctl no_new_privs: %w", errno)
+	}
+	trace := procTraceCtlDisableExe
+	if _, _, errno := syscall.Syscall6(sysProcctl, pPID, pid, procTraceCtl, uintptr(unsafe.Pointer(&trace)), 0, 0); errno != 0 {
+		return []string{"no_new_privs"}, fmt.Errorf("procctl trace: %w", errno)
+	}
+	return []string{"no_new_privs", "trace_ctl"}, nil
+}
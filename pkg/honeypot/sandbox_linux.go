@@ -0,0 +1,228 @@
+//go:build linux
+
+package honeypot
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Landlock system calls and flags, see landlock(7).
+const (
+	sysLandlockCreateRuleset     = 444
+	sysLandlockAddRule           = 445
+	sysLandlockRestrictSelf      = 446
+	landlockCreateRulesetVersion = 1
+	landlockRulePathBeneath      = 1
+	oPath                        = 0x200000
+)
+
+// Landlock file system access rights.
+const (
+	landlockExecute = 1 << iota
+	landlockWriteFile
+	landlockReadFile
+	landlockReadDir
+	landlockRemoveDir
+	landlockRemoveFile
+	landlockMakeChar
+	landlockMakeDir
+	landlockMakeReg
+	landlockMakeSock
+	landlockMakeFifo
+	landlockMakeBlock
+	landlockMakeSym
+	landlockRefer    // ABI 2
+	landlockTruncate // ABI 3
+)
+
+// landlockFileRights are the rights that apply to files rather than
+// directories.
+const landlockFileRights = landlockExecute | landlockWriteFile | landlockReadFile | landlockTruncate
+
+// seccomp and BPF constants, see seccomp(2).
+const (
+	prSetNoNewPrivs        = 38
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+	seccompRetAllow        = 0x7fff0000
+	seccompRetErrno        = 0x00050000
+	bpfLdWAbs              = 0x20 // BPF_LD | BPF_W | BPF_ABS
+	bpfJeqK                = 0x15 // BPF_JMP | BPF_JEQ | BPF_K
+	bpfJgeK                = 0x35 // BPF_JMP | BPF_JGE | BPF_K
+	bpfRetK                = 0x06 // BPF_RET | BPF_K
+)
+
+type sockFilter struct {
+	code uint16
+	jt   uint8
+	jf   uint8
+	k    uint32
+}
+
+type sockFprog struct {
+	len    uint16
+	filter *sockFilter
+}
+
+// EnterSandbox restricts the process to p for the rest of its life and
+// returns the mechanisms applied. On Linux, no_new_privs keeps commands from
+// gaining privileges, Landlock (kernel 5.13 or later) limits the files the
+// process can open to those of p and of the standard library, and a seccomp
+// filter denies the system calls GoPot never makes, like ptrace, mount,
+// bpf and module loading, and execve unless p.Exec is set. Landlock is
+// skipped on kernels without it, and when the binary uses cgo, e.g. for
+// plugins, as then its rules cannot be applied to every thread.
+func EnterSandbox(p SandboxPolicy) ([]string, error) {
+	// Without AllThreadsSyscall, no_new_privs is set on this thread only,
+	// and the seccomp filter extends it to the others
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var applied []string
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno == 0 {
+		applied = append(applied, "no_new_privs")
+	} else if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return applied, fmt.Errorf("setting no_new_privs: %w", errno)
+	} else {
+		applied = append(applied, "no_new_privs (main thread)")
+	}
+
+	switch err := enterLandlock(p); {
+	case err == nil:
+		applied = append(applied, "landlock")
+	case errors.Is(err, syscall.ENOSYS), errors.Is(err, syscall.EOPNOTSUPP), errors.Is(err, syscall.ENOTSUP):
+		// Not supported by the kernel or with cgo
+	default:
+		return applied, fmt.Errorf("landlock: %w", err)
+	}
+
+	if seccompAuditArch == 0 {
+		return applied, nil
+	}
+	if err := enterSeccomp(p.Exec); err != nil {
+		return applied, fmt.Errorf("seccomp: %w", err)
+	}
+	return append(applied, "seccomp"), nil
+}
+
+// enterLandlock allows the process to open the paths of p, the system
+// paths and, if p.Exec is set, the programs, and nothing else. Commands
+// inherit the restriction.
+func enterLandlock(p SandboxPolicy) error {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return errno
+	}
+	handled := uint64(landlockMakeSym<<1 - 1)
+	if abi >= 2 {
+		handled |= landlockRefer
+	}
+	if abi >= 3 {
+		handled |= landlockTruncate
+	}
+	attr := struct{ handledAccessFS uint64 }{handled}
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return errno
+	}
+	defer syscall.Close(int(fd))
+
+	read := uint64(landlockReadFile | landlockReadDir)
+	write := handled &^ (landlockExecute | landlockMakeChar | landlockMakeBlock)
+	rules := []struct {
+		paths  []string
+		access uint64
+	}{
+		{sandboxSystemPaths, read},
+		{p.ReadOnly, read},
+		{p.ReadWrite, write},
+		{[]string{"/dev/null"}, landlockReadFile | landlockWriteFile},
+	}
+	if p.Exec {
+		rules = append(rules, struct {
+			paths  []string
+			access uint64
+		}{append(sandboxExecPaths[:len(sandboxExecPaths):len(sandboxExecPaths)], p.Programs...), read | landlockExecute})
+	}
+	for _, rule := range rules {
+		for _, path := range rule.paths {
+			if err := landlockAllow(int(fd), path, rule.access); err != nil {
+				return fmt.Errorf("allowing %s: %w", path, err)
+			}
+		}
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(sysLandlockRestrictSelf, fd, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// landlockAllow adds a rule granting access beneath path, if it exists.
+func landlockAllow(ruleset int, path string, access uint64) error {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if errors.Is(err, syscall.ENOENT) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return err
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= landlockFileRights
+	}
+	// struct landlock_path_beneath_attr is packed: the descriptor follows the
+	// rights without padding, which this layout matches for its first 12 bytes
+	attr := struct {
+		allowedAccess uint64
+		parentFD      int32
+		_             int32
+	}{access, int32(fd), 0}
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(ruleset), landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// enterSeccomp installs, on every thread, a filter failing the system calls
+// of seccompDenied, and of seccompExec unless exec is set, with EPERM. So do
+// calls of another architecture's numbering.
+func enterSeccomp(exec bool) error {
+	denied := seccompDenied
+	if !exec {
+		denied = append(denied[:len(denied):len(denied)], seccompExec...)
+	}
+	filter := []sockFilter{
+		{code: bpfLdWAbs, k: 4}, // seccomp_data.arch
+		{code: bpfJeqK, jt: 1, k: seccompAuditArch},
+		{code: bpfRetK, k: seccompRetErrno | uint32(syscall.EPERM)},
+		{code: bpfLdWAbs, k: 0}, // seccomp_data.nr
+	}
+	if seccompX32 {
+		filter = append(filter, sockFilter{code: bpfJgeK, k: 0x40000000})
+	}
+	for _, nr := range denied {
+		filter = append(filter, sockFilter{code: bpfJeqK, k: uint32(nr)})
+	}
+	filter = append(filter,
+		sockFilter{code: bpfRetK, k: seccompRetAllow},
+		sockFilter{code: bpfRetK, k: seccompRetErrno | uint32(syscall.EPERM)},
+	)
+	// Matches jump to the final instruction
+	deny := len(filter) - 1
+	for i := 4; i < deny-1; i++ {
+		filter[i].jt = uint8(deny - i - 1)
+	}
+
+	prog := sockFprog{len: uint16(len(filter)), filter: &filter[0]}
+	if _, _, errno := syscall.Syscall(seccompSysSeccomp, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package honeypot
+
+// seccomp filter of x86-64, see enterSeccomp.
+const (
+	seccompAuditArch  = 0xc000003e // AUDIT_ARCH_X86_64
+	seccompX32        = true       // x32 system calls share the architecture, with bit 30 set
+	seccompSysSeccomp = 317
+)
+
+// seccompDenied are the system calls GoPot never makes: debugging other
+// processes, mounts, namespaces, kernel modules and keys, eBPF, clock and
+// reboot, io_uring, which seccomp cannot see into, and port I/O.
+var seccompDenied = []uintptr{
+	101, 310, 311, // ptrace, process_vm_readv, process_vm_writev
+	165, 166, 155, 161, 428, 429, 430, 432, // mount, umount2, pivot_root, chroot, open_tree, move_mount, fsopen, fsmount
+	308, 272, 304, // setns, unshare, open_by_handle_at
+	175, 313, 176, 246, 320, // init_module, finit_module, delete_module, kexec_load, kexec_file_load
+	248, 249, 250, // add_key, request_key, keyctl
+	321, 298, 323, // bpf, perf_event_open, userfaultfd
+	159, 164, 227, 305, // adjtimex, settimeofday, clock_settime, clock_adjtime
+	169, 167, 168, 163, 179, 103, // reboot, swapon, swapoff, acct, quotactl, syslog
+	425, 172, 173, // io_uring_setup, iopl, ioperm
+}
+
+// seccompExec are the system calls running programs.
+var seccompExec = []uintptr{59, 322} // execve, execveat
@@ -0,0 +1,26 @@
+package honeypot
+
+// seccomp filter of AArch64, see enterSeccomp.
+const (
+	seccompAuditArch  = 0xc00000b7 // AUDIT_ARCH_AARCH64
+	seccompX32        = false
+	seccompSysSeccomp = 277
+)
+
+// seccompDenied are the system calls GoPot never makes: debugging other
+// processes, mounts, namespaces, kernel modules and keys, eBPF, clock and
+// reboot, and io_uring, which seccomp cannot see into.
+var seccompDenied = []uintptr{
+	117, 270, 271, // ptrace, process_vm_readv, process_vm_writev
+	40, 39, 41, 51, 428, 429, 430, 432, // mount, umount2, pivot_root, chroot, open_tree, move_mount, fsopen, fsmount
+	268, 97, 265, // setns, unshare, open_by_handle_at
+	105, 273, 106, 104, 294, // init_module, finit_module, delete_module, kexec_load, kexec_file_load
+	217, 218, 219, // add_key, request_key, keyctl
+	280, 241, 282, // bpf, perf_event_open, userfaultfd
+	171, 170, 112, 266, // adjtimex, settimeofday, clock_settime, clock_adjtime
+	142, 224, 225, 89, 60, 116, // reboot, swapon, swapoff, acct, quotactl, syslog
+	425, // io_uring_setup
+}
+
+// seccompExec are the system calls running programs.
+var seccompExec = []uintptr{221, 281} // execve, execveat
@@ -0,0 +1,13 @@
+//go:build linux && !amd64 && !arm64
+
+package honeypot
+
+// The seccomp filter is only built for x86-64 and AArch64; elsewhere
+// EnterSandbox applies no_new_privs and Landlock only.
+const (
+	seccompAuditArch  = 0
+	seccompX32        = false
+	seccompSysSeccomp = 0
+)
+
+var seccompDenied, seccompExec []uintptr
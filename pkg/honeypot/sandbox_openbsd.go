@@ -0,0 +1,71 @@
+package honeypot
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	sysPledge = 108
+	sysUnveil = 114
+)
+
+// EnterSandbox restricts the process to p for the rest of its life and
+// returns the mechanisms applied. On OpenBSD, unveil(2) hides every path but
+// those of p, the system paths and, if p.Exec is set, the programs, and
+// pledge(2) limits the process to I/O on files and sockets, name resolution
+// and, if p.Exec is set, running commands.
+func EnterSandbox(p SandboxPolicy) ([]string, error) {
+	unveils := []struct {
+		paths       []string
+		permissions string
+	}{
+		{sandboxSystemPaths, "r"},
+		{p.ReadOnly, "r"},
+		{p.ReadWrite, "rwc"},
+		{[]string{"/dev/null"}, "rw"},
+	}
+	promises := "stdio rpath wpath cpath inet dns unix"
+	if p.Exec {
+		unveils = append(unveils, struct {
+			paths       []string
+			permissions string
+		}{append(sandboxExecPaths[:len(sandboxExecPaths):len(sandboxExecPaths)], p.Programs...), "rx"})
+		promises += " proc exec"
+	}
+	for _, unveil := range unveils {
+		for _, path := range unveil.paths {
+			err := unveilPath(path, unveil.permissions)
+			if err != nil && err != syscall.ENOENT {
+				return nil, fmt.Errorf("unveiling %s: %w", path, err)
+			}
+		}
+	}
+	if _, _, errno := syscall.Syscall(sysUnveil, 0, 0, 0); errno != 0 {
+		return nil, fmt.Errorf("locking unveil: %w", errno)
+	}
+	promisesPtr, err := syscall.BytePtrFromString(promises)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, errno := syscall.Syscall(sysPledge, uintptr(unsafe.Pointer(promisesPtr)), 0, 0); errno != 0 {
+		return []string{"unveil"}, fmt.Errorf("pledge: %w", errno)
+	}
+	return []string{"unveil", "pledge"}, nil
+}
+
+func unveilPath(path, permissions string) error {
+	pathPtr, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	permissionsPtr, err := syscall.BytePtrFromString(permissions)
+	if err != nil {
+		return err
+	}
+	if _, _, errno := syscall.Syscall(sysUnveil, uintptr(unsafe.Pointer(pathPtr)), uintptr(unsafe.Pointer(permissionsPtr)), 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !openbsd && !freebsd
+
+package honeypot
+
+import "errors"
+
+// EnterSandbox is only supported on Linux, OpenBSD and FreeBSD.
+func EnterSandbox(p SandboxPolicy) ([]string, error) {
+	return nil, errors.New("the sandbox is only supported on Linux, OpenBSD and FreeBSD")
+}
@@ -0,0 +1,339 @@
+package honeypot
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits of the state scan detection keeps, so that a flood of spoofed
+// sources or a full port range cannot exhaust memory.
+const (
+	maxScanSources = 65536 // clients followed at once, new ones are ignored beyond
+	maxScanProbes  = 4096  // connections remembered per client, the oldest are forgotten beyond
+	maxScanList    = 100   // ports or addresses listed by a scan event
+)
+
+// ScanMethods are how a scan reached the ports of the sensor, decided by the
+// sessions of the scanning client that ended: full_connect when it sent
+// nothing and reset the connection or left before being sent anything, as
+// connect scans do; banner_grab when it sent nothing but read what it was
+// sent, such as a banner, and closed the connection; probe when it sent
+// something. A scan whose sessions are of no single method for the most
+// part is mixed.
+var ScanMethods = []string{"full_connect", "banner_grab", "probe", "mixed"}
+
+// ScanConfig enables scan detection, see Server.EnableScanDetection.
+type ScanConfig struct {
+	Ports      int    `json:"ports"`                 // distinct ports one client reaches within window that make a vertical scan, 0 disables detection
+	Hosts      int    `json:"hosts,omitempty"`       // distinct addresses of the sensor one client reaches on a port within window that make a horizontal scan, default 3
+	Window     string `json:"window,omitempty"`      // how close together the connections of a scan are, default 1m
+	SlowWindow string `json:"slow_window,omitempty"` // how long the connections of a slow scan may spread over, default 1h
+}
+
+// scanDetector correlates the connections of each client, see
+// EnableScanDetection.
+type scanDetector struct {
+	ports, hosts       int
+	window, slowWindow time.Duration
+	mu                 sync.Mutex
+	sources            map[netip.Addr]*scanSource
+}
+
+// scanSource is what scan detection remembers of a client.
+type scanSource struct {
+	probes   []scanProbe          // oldest first, none older than the slow window
+	reported map[string]time.Time // when each kind of scan, and horizontal scans of each port, were last reported
+}
+
+// scanProbe is a connection of a client.
+type scanProbe struct {
+	time    time.Time
+	session string
+	port    string // with /udp for UDP sessions
+	target  string // address of the sensor reached
+	method  string // one of ScanMethods but mixed, empty until the session ends
+}
+
+func newScanDetector(cfg ScanConfig) (*scanDetector, error) {
+	d := &scanDetector{ports: cfg.Ports, hosts: cfg.Hosts, window: time.Minute, slowWindow: time.Hour, sources: make(map[netip.Addr]*scanSource)}
+	if cfg.Ports < 2 {
+		return nil, fmt.Errorf("scan detection needs at least 2 ports, got %d", cfg.Ports)
+	}
+	if cfg.Hosts < 0 || cfg.Hosts == 1 {
+		return nil, fmt.Errorf("horizontal scans need at least 2 hosts, got %d", cfg.Hosts)
+	}
+	if d.hosts == 0 {
+		d.hosts = 3
+	}
+	for _, w := range []struct {
+		name, value string
+		d           *time.Duration
+	}{{"window", cfg.Window, &d.window}, {"slow window", cfg.SlowWindow, &d.slowWindow}} {
+		if w.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(w.value)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid scan %s %q", w.name, w.value)
+		}
+		*w.d = duration
+	}
+	if d.slowWindow < d.window {
+		return nil, fmt.Errorf("the scan slow window %s is shorter than the window %s", d.slowWindow, d.window)
+	}
+	return d, nil
+}
+
+// EnableScanDetection correlates the connections of each client across
+// listeners and addresses into scan events: a vertical scan when it reaches
+// cfg.Ports distinct ports within the window, a horizontal scan when it
+// reaches one port on cfg.Hosts addresses of the sensor, such as decoy
+// addresses, within the window, and a slow scan when it reaches cfg.Ports
+// ports over the slow window without doing so within any window. A scan is
+// reported when one of its sessions ends, so that scan_method can tell how
+// it reached the ports, and again every window while it goes on. Connection
+// events dropped by sampling count too.
+func (s *Server) EnableScanDetection(cfg ScanConfig) error {
+	d, err := newScanDetector(cfg)
+	if err != nil {
+		return err
+	}
+	s.scans = d
+	go s.sweepScans()
+	return nil
+}
+
+// sweepScans forgets the clients whose connections all left the slow window
+// until Shutdown.
+func (s *Server) sweepScans() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.scans.sweep(now)
+		}
+	}
+}
+
+func (d *scanDetector) sweep(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for addr, src := range d.sources {
+		if now.Sub(src.probes[len(src.probes)-1].time) > d.slowWindow {
+			delete(d.sources, addr)
+		}
+	}
+}
+
+// observe records the connection events of clients and classifies their
+// sessions as they end, returning the scan events the end of a session
+// completes.
+func (d *scanDetector) observe(ev Event) []Event {
+	if ev.Type != EventConnection && ev.Type != EventSessionEnd {
+		return nil
+	}
+	addr, err := netip.ParseAddr(srcIP(ev.SrcAddr))
+	if err != nil {
+		return nil
+	}
+	addr = addr.Unmap().WithZone("")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	src := d.sources[addr]
+	if ev.Type == EventConnection {
+		if src == nil {
+			if len(d.sources) >= maxScanSources {
+				return nil
+			}
+			src = &scanSource{reported: make(map[string]time.Time)}
+			d.sources[addr] = src
+		}
+		port := ev.Port
+		if ev.Fields["transport"] == "udp" {
+			port += "/udp"
+		}
+		src.probes = append(src.probes, scanProbe{time: ev.Time, session: ev.Session, port: port, target: scanTarget(ev.DstAddr)})
+		if len(src.probes) > maxScanProbes {
+			src.probes = src.probes[len(src.probes)-maxScanProbes:]
+		}
+		return nil
+	}
+
+	if src == nil {
+		return nil
+	}
+	found := false
+	for i := len(src.probes) - 1; i >= 0; i-- {
+		if src.probes[i].session == ev.Session {
+			src.probes[i].method = sessionMethod(ev)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+	now := ev.Time
+	for len(src.probes) > 0 && now.Sub(src.probes[0].time) > d.slowWindow {
+		src.probes = src.probes[1:]
+	}
+	var recent []scanProbe
+	for i := len(src.probes) - 1; i >= 0 && now.Sub(src.probes[i].time) <= d.window; i-- {
+		recent = append(recent, src.probes[i])
+	}
+	reportable := func(key string, within time.Duration) bool {
+		last, ok := src.reported[key]
+		return !ok || now.Sub(last) >= within
+	}
+
+	var scans []Event
+	if ports := distinctPorts(recent); len(ports) >= d.ports && reportable("vertical", d.window) {
+		src.reported["vertical"] = now
+		scans = append(scans, scanEvent(addr, "vertical", recent, fmt.Sprintf("%d ports", len(ports))))
+	}
+	byPort := make(map[string][]scanProbe)
+	for _, p := range recent {
+		if p.target != "" {
+			byPort[p.port] = append(byPort[p.port], p)
+		}
+	}
+	for _, port := range sortedPorts(byPort) {
+		targets := distinctTargets(byPort[port])
+		if len(targets) < d.hosts || !reportable("horizontal "+port, d.window) {
+			continue
+		}
+		src.reported["horizontal "+port] = now
+		ev := scanEvent(addr, "horizontal", byPort[port], fmt.Sprintf("port %s on %d addresses", port, len(targets)))
+		ev.Fields["targets"] = targets[:min(len(targets), maxScanList)]
+		ev.Fields["target_count"] = len(targets)
+		scans = append(scans, ev)
+	}
+	if ports := distinctPorts(src.probes); len(ports) >= d.ports && reportable("vertical", d.slowWindow) && reportable("slow", d.slowWindow) {
+		src.reported["slow"] = now
+		scans = append(scans, scanEvent(addr, "slow", src.probes, fmt.Sprintf("%d ports", len(ports))))
+	}
+	return scans
+}
+
+// scanEvent describes a scan of kind by the client addr made of probes,
+// which reached what.
+func scanEvent(addr netip.Addr, kind string, probes []scanProbe, what string) Event {
+	first, last := probes[0].time, probes[0].time
+	counts := make(map[string]int)
+	for _, p := range probes {
+		if p.time.Before(first) {
+			first = p.time
+		}
+		if p.time.After(last) {
+			last = p.time
+		}
+		if p.method != "" {
+			counts[p.method]++
+		}
+	}
+	classified := counts["full_connect"] + counts["banner_grab"] + counts["probe"]
+	method := "mixed"
+	for _, m := range ScanMethods[:3] {
+		if counts[m]*2 > classified {
+			method = m
+		}
+	}
+	ports := distinctPorts(probes)
+	elapsed := last.Sub(first).Round(time.Second)
+	return Event{
+		Type:    EventScan,
+		SrcAddr: addr.String(),
+		Message: fmt.Sprintf("%s%s scan from %s: %s in %s, %s", strings.ToUpper(kind[:1]), kind[1:], addr, what, elapsed, method),
+		Fields: map[string]any{
+			"scan_kind":     kind,
+			"scan_method":   method,
+			"ports":         ports[:min(len(ports), maxScanList)],
+			"port_count":    len(ports),
+			"connections":   len(probes),
+			"full_connects": counts["full_connect"],
+			"banner_grabs":  counts["banner_grab"],
+			"probes":        counts["probe"],
+			"duration":      last.Sub(first).Round(time.Millisecond).Seconds(),
+		},
+	}
+}
+
+// sessionMethod returns how the session whose end is ev reached its port,
+// see ScanMethods.
+func sessionMethod(ev Event) string {
+	received, _ := fieldNumber(ev.Fields, "received_bytes")
+	sent, _ := fieldNumber(ev.Fields, "sent_bytes")
+	reset, _ := ev.Fields["reset"].(bool)
+	switch {
+	case received > 0:
+		return "probe"
+	case reset || sent == 0:
+		return "full_connect"
+	}
+	return "banner_grab"
+}
+
+// scanTarget returns the address of the sensor a client reached according
+// to the dst_addr of its events: the original destination of redirected
+// connections.
+func scanTarget(dst string) string {
+	if _, orig, ok := strings.Cut(dst, "(original destination "); ok {
+		dst = strings.TrimSuffix(orig, ")")
+	}
+	if host, _, err := net.SplitHostPort(dst); err == nil {
+		return host
+	}
+	return dst
+}
+
+// distinctPorts returns the ports probes reached in numeric order, UDP
+// ports after TCP ones.
+func distinctPorts(probes []scanProbe) []string {
+	seen := make(map[string][]scanProbe)
+	for _, p := range probes {
+		seen[p.port] = nil
+	}
+	return sortedPorts(seen)
+}
+
+func sortedPorts[V any](ports map[string]V) []string {
+	sorted := make([]string, 0, len(ports))
+	for port := range ports {
+		sorted = append(sorted, port)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		ni, udpI := strings.CutSuffix(sorted[i], "/udp")
+		nj, udpJ := strings.CutSuffix(sorted[j], "/udp")
+		if udpI != udpJ {
+			return udpJ
+		}
+		a, _ := strconv.Atoi(ni)
+		b, _ := strconv.Atoi(nj)
+		if a != b {
+			return a < b
+		}
+		return ni < nj
+	})
+	return sorted
+}
+
+// distinctTargets returns the addresses probes reached, sorted.
+func distinctTargets(probes []scanProbe) []string {
+	var targets []string
+	for _, p := range probes {
+		targets = append(targets, p.target)
+	}
+	sort.Strings(targets)
+	return slices.Compact(targets)
+}
@@ -0,0 +1,136 @@
+package honeypot
+
+// EventSchema is the JSON Schema of the events of EventSchemaVersion, as
+// structured outputs, hooks, storage and the API encode them. Besides the
+// properties common to every event it names the fields of each event type
+// downstream parsers may rely on; handlers add fields of their own, which
+// the schema allows without describing.
+const EventSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "$id": "urn:gopot:event:1",
+  "title": "GoPot event",
+  "description": "A record of honeypot activity. Within a schema_version field names and meanings never change; fields and event types may be added.",
+  "type": "object",
+  "required": ["schema_version", "time", "seq", "mono_ns", "boot_id", "type", "message"],
+  "properties": {
+    "schema_version": {"const": 1, "description": "version of this schema; events without it predate versioning"},
+    "time": {"type": "string", "format": "date-time", "description": "wall clock of the sensor when the event was emitted"},
+    "seq": {"type": "integer", "minimum": 1, "description": "position of the event among those emitted since boot_id started"},
+    "mono_ns": {"type": "integer", "description": "monotonic time since boot_id started, in nanoseconds"},
+    "boot_id": {"type": "string", "description": "random identifier of the emitting GoPot process"},
+    "clock_offset_ns": {"type": "integer", "description": "NTP-measured correction of time, in nanoseconds"},
+    "type": {"enum": ["connection", "data", "credential", "canary", "session_end", "alert", "ddos_prep", "firewall", "scan", "error", "info", "handler_timeout", "config_summary"]},
+    "session": {"type": "string", "description": "identifier shared by the events of a connection"},
+    "handler": {"type": "string", "description": "handler serving the session, which names the protocol spoken"},
+    "severity": {"enum": ["low", "medium", "high"], "description": "set on alerts"},
+    "port": {"type": "string", "description": "port the client targeted"},
+    "src_addr": {"type": "string", "description": "client address and port"},
+    "dst_addr": {"type": "string", "description": "local address the client reached"},
+    "host": {"type": "string", "description": "virtual host the client reached"},
+    "message": {"type": "string", "description": "human readable description"},
+    "labels": {"type": "object", "additionalProperties": {"type": "string"}, "description": "labels of the sensor and of the port"},
+    "fields": {"type": "object", "description": "properties of the event type, see $defs; those of the events of UDP sessions include transport udp"}
+  },
+  "allOf": [
+    {"if": {"properties": {"type": {"const": "connection"}}}, "then": {"properties": {"fields": {"$ref": "#/$defs/connection"}}}},
+    {"if": {"properties": {"type": {"const": "data"}}}, "then": {"properties": {"fields": {"$ref": "#/$defs/data"}}}},
+    {"if": {"properties": {"type": {"const": "credential"}}}, "then": {"properties": {"fields": {"$ref": "#/$defs/credential"}}}},
+    {"if": {"properties": {"type": {"const": "canary"}}}, "then": {"properties": {"fields": {"$ref": "#/$defs/canary"}}}},
+    {"if": {"properties": {"type": {"const": "session_end"}}}, "then": {"properties": {"fields": {"$ref": "#/$defs/session_end"}}}},
+    {"if": {"properties": {"type": {"const": "alert"}}}, "then": {"required": ["severity"], "properties": {"fields": {"$ref": "#/$defs/alert"}}}},
+    {"if": {"properties": {"type": {"const": "firewall"}}}, "then": {"properties": {"fields": {"$ref": "#/$defs/firewall"}}}},
+    {"if": {"properties": {"type": {"const": "scan"}}}, "then": {"properties": {"fields": {"$ref": "#/$defs/scan"}}}},
+    {"if": {"properties": {"type": {"const": "handler_timeout"}}}, "then": {"properties": {"fields": {"$ref": "#/$defs/handler_timeout"}}}}
+  ],
+  "$defs": {
+    "connection": {
+      "type": "object",
+      "properties": {
+        "transport": {"const": "udp", "description": "absent for TCP"},
+        "reputation": {"type": "array", "description": "reputation feeds listing the client"},
+        "dropped": {"type": "boolean", "description": "the connection was closed because a feed listed the client"},
+        "refused": {"type": "boolean", "description": "the virtual host reached has no service on the port"}
+      }
+    },
+    "data": {
+      "type": "object",
+      "required": ["data"],
+      "properties": {
+        "data": {"type": "string", "description": "what the client sent, encoded as data_encoding says"},
+        "data_encoding": {"enum": ["text", "escaped", "hex", "base64"], "description": "text for printable UTF-8 written as is, otherwise how the payload was encoded; absent before payloads were encoded"}
+      }
+    },
+    "credential": {
+      "type": "object",
+      "required": ["username", "password"],
+      "properties": {
+        "username": {"type": "string"},
+        "password": {"type": "string"}
+      }
+    },
+    "canary": {
+      "type": "object",
+      "required": ["username"],
+      "properties": {
+        "username": {"type": "string", "description": "as presented, with the password of a canary credential"},
+        "canary_note": {"type": "string", "description": "where the credential was seeded"}
+      }
+    },
+    "session_end": {
+      "type": "object",
+      "required": ["stage", "handler", "received_bytes", "duration"],
+      "properties": {
+        "stage": {"type": "string", "description": "furthest attack stage reached"},
+        "handler": {"type": "string", "description": "same as the handler of the event"},
+        "received_bytes": {"type": "integer", "description": "bytes the client sent"},
+        "sent_bytes": {"type": "integer", "description": "bytes the client was sent, absent for none"},
+        "first_sent": {"type": "number", "description": "seconds from the accept to the first byte sent"},
+        "withheld_bytes": {"type": "integer", "description": "bytes discarded in read-only mode"},
+        "reset": {"const": true, "description": "the client reset the connection"},
+        "duration": {"type": "number", "description": "length of the session in seconds"},
+        "reputation": {"type": "array", "description": "reputation feeds listing the client"}
+      }
+    },
+    "alert": {
+      "type": "object",
+      "required": ["alert", "description"],
+      "properties": {
+        "alert": {"type": "string", "description": "kind of alert, e.g. watchlist_hit"},
+        "description": {"type": "string"}
+      }
+    },
+    "firewall": {
+      "type": "object",
+      "properties": {
+        "firewall_action": {"enum": ["block", "unblock"]},
+        "backend": {"type": "string"},
+        "until": {"type": "string", "format": "date-time"}
+      }
+    },
+    "scan": {
+      "type": "object",
+      "required": ["scan_kind", "scan_method", "ports", "port_count", "connections", "duration"],
+      "properties": {
+        "scan_kind": {"enum": ["vertical", "horizontal", "slow"]},
+        "scan_method": {"enum": ["full_connect", "banner_grab", "probe", "mixed"], "description": "how most sessions of the scan reached their port"},
+        "ports": {"type": "array", "items": {"type": "string"}, "description": "ports reached, /udp for UDP, the first 100"},
+        "port_count": {"type": "integer"},
+        "targets": {"type": "array", "items": {"type": "string"}, "description": "addresses of the sensor reached by a horizontal scan, the first 100"},
+        "target_count": {"type": "integer"},
+        "connections": {"type": "integer", "description": "connections of the scan"},
+        "full_connects": {"type": "integer"},
+        "banner_grabs": {"type": "integer"},
+        "probes": {"type": "integer"},
+        "duration": {"type": "number", "description": "seconds from the first connection of the scan to the last"}
+      }
+    },
+    "handler_timeout": {
+      "type": "object",
+      "properties": {
+        "handler": {"type": "string"},
+        "budget": {"type": "number", "description": "wall-clock budget overrun, in seconds"}
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,330 @@
+package honeypot
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Limits applied to every scripted dialog, in addition to the port's SessionLimits.
+const (
+	scriptMaxTurns = 200  // lines read before the session is closed
+	scriptMaxLine  = 4096 // longer input is processed in chunks of this size
+)
+
+// DialogScript is a state machine describing a multi-turn fake dialog.
+//
+// Scripts are line oriented. "state NAME" starts a state; the first state is
+// where the dialog begins. Inside a state, "send" lines are executed when the
+// state is entered, "on" rules are tried in order against each line received
+// and "default" applies when no rule matches:
+//
+//	state login
+//	  send "220 ${fqdn} FTP server ready\r\n"
+//	  on '^USER (\S+)' send "331 Password required for $1\r\n"
+//	  on "^PASS" send "530 Login incorrect.\r\n" goto login
+//	  on "^QUIT" send "221 Goodbye.\r\n" close
+//	  default send "500 Unknown command.\r\n"
+//
+// "respond" makes a rule send the reply of the server's Responder to the
+// line, as output of the command it names, before its send text; without a
+// Responder, or when the model fails, only the send text is sent. It suits
+// the default rule of a fake shell, whose send text is the next prompt:
+//
+//	default respond send "${hostname}:~$ "
+//
+// "notice PREFIX" sends the host's legal notice, if it has one, with every
+// line prefixed, e.g. notice "220-" before an FTP greeting (see
+// HostIdentity.LegalBanner); like send, it runs when its state is entered.
+//
+// Double-quoted strings use Go escapes ("\r\n"); single-quoted strings are
+// taken literally, which suits regular expressions. Sent text may reference
+// regexp captures ($1, ${name}) and the variables ${hostname}, ${fqdn},
+// ${os}, ${client} and ${port}; "$$" is a literal '$'.
+type DialogScript struct {
+	path    string
+	initial string
+	states  map[string]*dialogState
+}
+
+// dialogState is one state of a DialogScript.
+type dialogState struct {
+	name     string
+	greeting []dialogSend // sent when the state is entered
+	rules    []*dialogRule
+	fallback *dialogRule // "default" rule, may be nil
+}
+
+// dialogSend is a send or notice statement of a state.
+type dialogSend struct {
+	text   string
+	notice bool // text is the prefix of the lines of the legal notice
+}
+
+// dialogRule reacts to a received line.
+type dialogRule struct {
+	pattern *regexp.Regexp // nil for the default rule
+	send    string
+	respond bool   // send the Responder's reply to the line before send, if there is one
+	next    string // state to switch to, empty to stay
+	close   bool
+}
+
+// LoadDialogScript reads and validates a dialog script file.
+func LoadDialogScript(path string) (*DialogScript, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	script := &DialogScript{path: path, states: make(map[string]*dialogState)}
+	var current *dialogState
+
+	for number, line := range strings.Split(string(content), "\n") {
+		tokens, err := tokenizeScriptLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, number+1, err)
+		}
+		if len(tokens) == 0 || strings.HasPrefix(tokens[0], "#") {
+			continue
+		}
+		if tokens[0] != "state" && current == nil {
+			return nil, fmt.Errorf("%s:%d: %q outside of a state", path, number+1, tokens[0])
+		}
+
+		switch tokens[0] {
+		case "state":
+			if len(tokens) != 2 {
+				return nil, fmt.Errorf("%s:%d: want: state NAME", path, number+1)
+			}
+			if _, exists := script.states[tokens[1]]; exists {
+				return nil, fmt.Errorf("%s:%d: state %q defined twice", path, number+1, tokens[1])
+			}
+			current = &dialogState{name: tokens[1]}
+			script.states[current.name] = current
+			if script.initial == "" {
+				script.initial = current.name
+			}
+		case "send":
+			if len(tokens) != 2 {
+				return nil, fmt.Errorf("%s:%d: want: send TEXT", path, number+1)
+			}
+			current.greeting = append(current.greeting, dialogSend{text: tokens[1]})
+		case "notice":
+			if len(tokens) > 2 {
+				return nil, fmt.Errorf("%s:%d: want: notice [PREFIX]", path, number+1)
+			}
+			prefix := ""
+			if len(tokens) == 2 {
+				prefix = tokens[1]
+			}
+			current.greeting = append(current.greeting, dialogSend{text: prefix, notice: true})
+		case "on", "default":
+			rule := &dialogRule{}
+			actions := tokens[1:]
+			if tokens[0] == "on" {
+				if len(tokens) < 2 {
+					return nil, fmt.Errorf("%s:%d: want: on REGEXP [send TEXT] [goto STATE] [close]", path, number+1)
+				}
+				if rule.pattern, err = regexp.Compile(tokens[1]); err != nil {
+					return nil, fmt.Errorf("%s:%d: %w", path, number+1, err)
+				}
+				actions = tokens[2:]
+			}
+			if err := parseRuleActions(rule, actions); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, number+1, err)
+			}
+			if rule.pattern == nil {
+				current.fallback = rule
+			} else {
+				current.rules = append(current.rules, rule)
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown statement %q", path, number+1, tokens[0])
+		}
+	}
+
+	if script.initial == "" {
+		return nil, fmt.Errorf("%s: script defines no states", path)
+	}
+	for _, state := range script.states {
+		for _, rule := range append(state.rules, state.fallback) {
+			if rule != nil && rule.next != "" && script.states[rule.next] == nil {
+				return nil, fmt.Errorf("%s: state %q jumps to undefined state %q", path, state.name, rule.next)
+			}
+		}
+	}
+	return script, nil
+}
+
+// parseRuleActions fills in the send/respond/goto/close actions of a rule.
+func parseRuleActions(rule *dialogRule, actions []string) error {
+	for i := 0; i < len(actions); i++ {
+		switch actions[i] {
+		case "send", "goto":
+			if i+1 >= len(actions) {
+				return fmt.Errorf("%s needs an argument", actions[i])
+			}
+			if actions[i] == "send" {
+				rule.send = actions[i+1]
+			} else {
+				rule.next = actions[i+1]
+			}
+			i++
+		case "close":
+			rule.close = true
+		case "respond":
+			rule.respond = true
+		default:
+			return fmt.Errorf("unknown action %q", actions[i])
+		}
+	}
+	return nil
+}
+
+// tokenizeScriptLine splits a line into words, double-quoted strings following
+// Go string literal escaping and literal single-quoted strings.
+// Text after an unquoted '#' is a comment.
+func tokenizeScriptLine(line string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(line); {
+		switch {
+		case unicode.IsSpace(rune(line[i])):
+			i++
+		case line[i] == '#':
+			return tokens, nil
+		case line[i] == '"':
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, errors.New("unterminated string")
+			}
+			unquoted, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s: %w", line[i:end+1], err)
+			}
+			tokens = append(tokens, unquoted)
+			i = end + 1
+		case line[i] == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, line[i+1:i+1+end])
+			i += end + 2
+		default:
+			end := i
+			for end < len(line) && !unicode.IsSpace(rune(line[end])) {
+				end++
+			}
+			tokens = append(tokens, line[i:end])
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// Serve runs the dialog until a rule closes it, the client goes quiet or
+// disconnects, or a session limit is reached.
+func (s *DialogScript) Serve(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+	vars := strings.NewReplacer(
+		"$$", "$$",
+		"${hostname}", meta.Identity.Hostname,
+		"${fqdn}", meta.Identity.FQDN(),
+		"${os}", meta.Identity.OS,
+		"${client}", meta.ClientAddr,
+		"${port}", meta.Port,
+	)
+	reader := bufio.NewReaderSize(conn, scriptMaxLine)
+	state := s.states[s.initial]
+
+	enter := func(next *dialogState) error {
+		state = next
+		for _, g := range state.greeting {
+			text := expandDialogText(vars, g.text, nil, "", nil)
+			if g.notice {
+				text = meta.Identity.LegalBanner(text)
+			}
+			if text == "" {
+				continue
+			}
+			if _, err := conn.Write([]byte(text)); err != nil {
+				return fmt.Errorf("writing to connection: %w", err)
+			}
+		}
+		return nil
+	}
+	if err := enter(state); err != nil {
+		return err
+	}
+
+	total := 0
+	for turn := 0; turn < scriptMaxTurns && total < meta.Limits.MaxBytes && ctx.Err() == nil; turn++ {
+		conn.SetReadDeadline(meta.NextReadDeadline(turn > 0))
+		raw, err := reader.ReadSlice('\n')
+		if len(raw) == 0 && err != nil {
+			return nil // client went idle or disconnected
+		}
+		total += len(raw)
+
+		data := string(raw)
+		meta.LogData(data)
+
+		line := strings.TrimRight(data, "\r\n")
+		rule, captures := state.match(line)
+		if rule == nil {
+			continue
+		}
+		text := expandDialogText(vars, rule.send, rule.pattern, line, captures)
+		if rule.respond {
+			if reply, ok := meta.respond(ResponderShell, line); ok {
+				text = strings.ReplaceAll(reply, "\n", "\r\n") + text
+			}
+		}
+		if text != "" {
+			if _, err := conn.Write([]byte(text)); err != nil {
+				return fmt.Errorf("writing to connection: %w", err)
+			}
+		}
+		if rule.close {
+			return nil
+		}
+		if rule.next != "" {
+			if err := enter(s.states[rule.next]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// match returns the first rule matching line and its capture indexes,
+// or the default rule when none matches.
+func (st *dialogState) match(line string) (*dialogRule, []int) {
+	for _, rule := range st.rules {
+		if captures := rule.pattern.FindStringSubmatchIndex(line); captures != nil {
+			return rule, captures
+		}
+	}
+	return st.fallback, nil
+}
+
+// expandDialogText substitutes variables and the regexp captures of line into text.
+func expandDialogText(vars *strings.Replacer, text string, pattern *regexp.Regexp, line string, captures []int) string {
+	text = vars.Replace(text)
+	if pattern == nil {
+		return strings.ReplaceAll(text, "$$", "$")
+	}
+	return string(pattern.ExpandString(nil, text, line, captures))
+}
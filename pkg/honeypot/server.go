@@ -0,0 +1,738 @@
+package honeypot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// MaxListeners is the number of sockets ListenAndServe opens at most, as
+// each costs a file descriptor, a parked goroutine and a collision probe.
+// Wider port lists are listened on through a RedirectPort.
+const MaxListeners = 4096
+
+// DefaultPorts are the ports GoPot listens on when none are configured.
+const DefaultPorts = "21,23,110,135,136,137,138,139,445,995,143,993,3306,3389,5900,6379,27017,5060"
+
+// Server accepts connections on any number of ports and dispatches each one
+// to the Handler assigned to its port. Configure the exported fields before
+// calling Serve or ListenAndServe.
+type Server struct {
+	Identity       *HostIdentity             // fake host impersonated by every persona
+	Files          *DecoyFS                  // decoy file system of Identity
+	Decoys         map[netip.Addr]*DecoyHost // hosts impersonated instead on additional local addresses, see AnnounceDecoys
+	DefaultHandler string                    // handler serving ports that don't name one
+	Ports          map[string]*PortOptions   // per-port settings, ports without an entry use the defaults
+	RedirectPort   string                    // port netfilter redirects the TCP ports to, the only one ListenAndServe listens on for them; empty to listen on each
+	Watchlist      *Watchlist                // leaked credentials that raise an alert when used, may be nil
+	Canaries       []CanaryCredential        // logins seeded to detect leaks, presenting one emits a canary event and a high alert
+	Reputation     *ReputationFeeds          // IP reputation feeds clients are looked up in, may be nil
+	GreyNoise      *GreyNoise                // classifies clients as background scanners in the greynoise field of their events, may be nil
+	Sandbox        *SandboxPolicy            // restricts the process once ListenAndServe has bound its ports, may be nil
+	Container      *ContainerInfo            // container GoPot runs in, to detect proxies hiding client addresses, may be nil
+	TrustedProxies []netip.Prefix            // peers whose PROXY headers are believed on Proxy ports, see ParseTrustedProxies; nil for loopback and private addresses
+	Labels         map[string]string         // added to the labels of every event, e.g. KubernetesMetadata; those of ports win
+	AlertWebhook   string                    // URL alerts are POSTed to as JSON, empty to disable
+	WebhookFilter  *EventFilter              // alerts posted to AlertWebhook, nil for all
+	QueueSize      int                       // accepted connections that may wait for a worker, see Stats
+	PortLimit      int                       // connections one listener may have queued or in service, 0 for no limit
+	Limits         SessionLimits             // timeouts and read limits of ports without their own
+	DrainTimeout   time.Duration             // how long Shutdown lets active connections finish before closing them
+	ModbusDevice   *ModbusDevice             // PLC impersonated by the modbus handler, nil for DefaultModbusDevice
+	SNMPAgent      *SNMPAgent                // MIB served by the snmp handler, nil for DefaultSNMPAgent
+	Elasticsearch  *ElasticsearchCluster     // cluster impersonated by the elasticsearch handler, nil for DefaultElasticsearchCluster
+	UPnPDevice     *UPnPDevice               // gateway impersonated by the ssdp and upnp handlers, nil for DefaultUPnPDevice
+	TFTPRoot       string                    // directory of the bait files served by the tftp handler, empty to serve none
+	ShellTree      *ShellTree                // files the shell emulator shows on top of DefaultShellTree, nil for none
+	PortCollision  string                    // what ListenAndServe does about local services on its ports, see CollisionPolicies; empty for CollisionWarn
+	ReadOnly       bool                      // never send clients anything, only record what they send, see readOnlyConn
+	DataEncoding   string                    // how data events hold payloads that are not printable text, one of DataEncodings; empty for "escaped"
+	Redactor       *Redactor                 // masks personal data in every event before outputs see it, nil to disable
+	Responder      *Responder                // generates replies personas have no canned answer for, nil to disable
+	Summary        *RunSummary               // described in the config_summary event of ListenAndServe along with the ports and build, may be nil
+	Log            *log.Logger               // operational messages such as "Listening on port 21"
+
+	outputs         []Output
+	workers         int        // connections served at once
+	queue           *connQueue // accepted connections waiting for a worker
+	pool            poolCounters
+	poolOnce        sync.Once
+	connMu          sync.Mutex
+	active          map[net.Conn]struct{}
+	listeners       map[*Listener]struct{}
+	packetListeners map[*PacketListener]struct{}
+	ctx             context.Context // cancelled by Shutdown once draining is over
+	cancel          context.CancelFunc
+	closing         atomic.Bool          // set when Shutdown starts
+	done            chan struct{}        // closed when Shutdown has finished
+	stages          [len(stageNames)]int // finished sessions per attack stage
+	abandoned       map[string]int       // handlers abandoned by the watchdog and still running, by port, see serveWatched
+	capture         *capturer            // follow-up traffic captures, see EnableCapture
+	anomaly         *anomalyDetector     // connection rate baselines, see EnableAnomalyDetection
+	firewall        *firewall            // blocks clients on the host firewall, see EnableFirewall
+	sampler         *sampler             // thins out noisy event types, see EnableSampling
+	honeytokens     *HoneytokenStore     // bait values watched for, see EnableHoneytokens
+	scans           *scanDetector        // correlates connections into scan events, see EnableScanDetection
+	sessionOrdinals map[string]uint64    // sessions of seeded identities by host and port, see ConnMeta.Rand
+	listenerStatus  []ListenerStatus     // ports ListenAndServe was asked to listen on, see Health
+	lastEventsMu    sync.Mutex
+	lastEvents      map[string]time.Time // time of the last event by type, see Health
+
+	bootID       string
+	started      time.Time // carries the monotonic clock reading events are measured from
+	seq          atomic.Uint64
+	clockOffset  atomic.Int64 // see CheckClock
+	clockChecked atomic.Bool
+	proxyWarned  atomic.Bool // see checkContainerProxy
+	ready        atomic.Bool // set once ListenAndServe listens, see Ready
+}
+
+// NewServer returns a server impersonating the default host profile that
+// serves at most maxConnections connections at once with a fixed pool of
+// workers. As many connections again may queue before new ones are dropped.
+func NewServer(maxConnections int) *Server {
+	identity, _ := NewHostIdentity(DefaultProfile, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		Identity:        identity,
+		Files:           identity.DecoyFS(),
+		DefaultHandler:  "banner",
+		Ports:           make(map[string]*PortOptions),
+		Limits:          DefaultSessionLimits(),
+		Log:             log.New(io.Discard, "", 0),
+		QueueSize:       maxConnections,
+		workers:         max(maxConnections, 1),
+		active:          make(map[net.Conn]struct{}),
+		listeners:       make(map[*Listener]struct{}),
+		packetListeners: make(map[*PacketListener]struct{}),
+		ctx:             ctx,
+		cancel:          cancel,
+		done:            make(chan struct{}),
+		bootID:          newSessionID(),
+		started:         time.Now(),
+	}
+}
+
+// AddOutput registers an output that receives every subsequent event.
+func (s *Server) AddOutput(o Output) {
+	s.outputs = append(s.outputs, o)
+}
+
+// Emit timestamps and numbers ev and delivers it to every output, unless
+// sampling drops it.
+func (s *Server) Emit(ev Event) {
+	if s.sampler != nil && !s.sample(ev) {
+		s.noteEvent(ev)
+		if ev.Time.IsZero() {
+			ev.Time = time.Now()
+		}
+	} else {
+		ev = s.stamp(ev)
+		s.deliver(ev)
+	}
+	s.observe(ev)
+}
+
+// observe passes ev, delivered or dropped by sampling, to the detectors
+// following what clients do across sessions.
+func (s *Server) observe(ev Event) {
+	if s.firewall != nil {
+		s.firewall.observe(ev)
+	}
+	if s.scans != nil {
+		for _, scan := range s.scans.observe(ev) {
+			s.Emit(scan)
+		}
+	}
+}
+
+// deliver writes ev to the outputs and the alert webhook.
+func (s *Server) deliver(ev Event) {
+	s.noteEvent(ev)
+	for _, o := range s.outputs {
+		if err := o.Write(ev); err != nil {
+			s.Log.Printf("Error writing %s event to %T: %s", ev.Type, o, err)
+		}
+	}
+	if ev.Type == EventAlert {
+		s.deliverAlert(ev)
+	}
+}
+
+// stamp sets the time, unless ev has one, the schema version and the
+// ordering fields of an event about to be emitted, and redacts it.
+func (s *Server) stamp(ev Event) Event {
+	now := time.Now()
+	if ev.Time.IsZero() {
+		ev.Time = now
+	}
+	ev.Schema = EventSchemaVersion
+	ev.Seq = s.seq.Add(1)
+	ev.Mono = now.Sub(s.started)
+	ev.BootID = s.bootID
+	if len(s.Labels) > 0 {
+		labels := make(map[string]string, len(s.Labels)+len(ev.Labels))
+		for key, value := range s.Labels {
+			labels[key] = value
+		}
+		for key, value := range ev.Labels {
+			labels[key] = value
+		}
+		ev.Labels = labels
+	}
+	if s.Redactor != nil {
+		ev = s.Redactor.Redact(ev)
+	}
+	if s.clockChecked.Load() {
+		ev.Offset = time.Duration(s.clockOffset.Load())
+	}
+	return ev
+}
+
+// logf emits an event of the given type for a message not tied to a connection.
+func (s *Server) logf(eventType, format string, args ...any) {
+	s.Emit(Event{Type: eventType, Message: fmt.Sprintf(format, args...)})
+}
+
+// Listener accepts connections for one port of a Server.
+type Listener struct {
+	Port     string
+	server   *Server
+	ln       net.Listener
+	slots    chan struct{} // connections of this listener in the pool, nil for no limit
+	priority int           // see PortOptions.Priority
+}
+
+// Listen opens a TCP listener on port. Call Serve on the result to start accepting.
+func (s *Server) Listen(port string) (*Listener, error) {
+	ln, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return nil, err
+	}
+	return s.NewListener(ln, port), nil
+}
+
+// NewListener wraps an existing net.Listener, for example one created by an
+// embedding application, so its connections are served as port. The
+// listener's concurrency limit is the port's MaxConnections, or PortLimit,
+// and its connections are queued with the port's Priority.
+func (s *Server) NewListener(ln net.Listener, port string) *Listener {
+	opts := s.portOptions(port)
+	l := &Listener{Port: port, server: s, ln: ln, priority: opts.Priority}
+	limit := s.PortLimit
+	if opts.MaxConnections > 0 {
+		limit = opts.MaxConnections
+	}
+	if limit > 0 {
+		l.slots = make(chan struct{}, limit)
+	}
+	s.connMu.Lock()
+	s.listeners[l] = struct{}{}
+	s.connMu.Unlock()
+	return l
+}
+
+// Serve accepts connections until the listener is closed and queues them for
+// the server's workers. Idle listeners hold no worker, so any number of ports
+// can be served. A listener at its concurrency limit closes new connections
+// right away rather than blocking, so a flood on one port leaves the workers
+// to the others.
+func (l *Listener) Serve() error {
+	s := l.server
+	s.startWorkers()
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			if s.closing.Load() || errors.Is(err, net.ErrClosed) {
+				return nil // shutting down or closed
+			}
+			s.logf(EventError, "Error accepting connection on port %s: %s", l.Port, err)
+			continue
+		}
+		s.pool.accepted.Add(1)
+		if !l.acquire() {
+			s.pool.limited(l.Port)
+			conn.Close()
+			continue
+		}
+		s.enqueue(conn, l)
+	}
+}
+
+// acquire takes one of the listener's connection slots if it has a limit.
+func (l *Listener) acquire() bool {
+	if l.slots == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release returns a slot taken by acquire.
+func (l *Listener) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// Close stops the listener from accepting new connections.
+func (l *Listener) Close() error {
+	l.server.connMu.Lock()
+	delete(l.server.listeners, l)
+	l.server.connMu.Unlock()
+	return l.ln.Close()
+}
+
+// ListenAndServe listens on every port and serves connections until Shutdown
+// is called and has finished. Ports are listened on over TCP, UDP or both,
+// depending on the transports their handler is registered for; with a
+// RedirectPort, TCP ports are listened on through that single port instead.
+// Ports that cannot be opened are reported and skipped, and so are those
+// beyond MaxListeners sockets. Before listening on a TCP port, it checks that
+// no local service already answers on it, see PortCollision. With a Sandbox,
+// the process enters it once every port is bound and before any is served;
+// if that fails, the server shuts down rather than serve unconfined. Before
+// serving, a config_summary event describes the run, see Summary.
+func (s *Server) ListenAndServe(ports []string) {
+	var sockets []ListenerStatus
+	redirected := false
+	for _, port := range ports {
+		name := s.handlerName(s.portOptions(port))
+		_, stream := LookupHandler(name)
+		_, packet := LookupPacketHandler(name)
+		switch {
+		case !stream && packet:
+		case s.RedirectPort == "":
+			sockets = append(sockets, ListenerStatus{Port: port, Transport: "tcp", Handler: name})
+		case !redirected:
+			redirected = true
+			sockets = append(sockets, ListenerStatus{Port: s.RedirectPort, Transport: "tcp", Handler: s.handlerName(s.portOptions(s.RedirectPort))})
+		}
+		if packet {
+			sockets = append(sockets, ListenerStatus{Port: port, Transport: "udp", Handler: name})
+		}
+	}
+	var skipped []ListenerStatus
+	if len(sockets) > MaxListeners {
+		s.logf(EventError, "Not listening on %d ports: more than %d sockets, redirect the TCP ports to one with netfilter, see RedirectPort", len(sockets)-MaxListeners, MaxListeners)
+		sockets, skipped = sockets[:MaxListeners], sockets[MaxListeners:]
+		for i := range skipped {
+			skipped[i].Error = fmt.Sprintf("more than %d sockets", MaxListeners)
+		}
+	}
+
+	var serve []func() error
+	var bound []summaryPort
+	var status []ListenerStatus
+	for _, st := range sockets {
+		port := st.Port
+		if st.Transport == "tcp" {
+			if !s.checkCollision(port) {
+				st.Error = "a local service answers on the port"
+			} else if l, err := s.Listen(port); err != nil {
+				s.logf(EventError, "Error listening on port %s: %s", port, err)
+				st.Error = err.Error()
+			} else {
+				s.Log.Printf("Listening on port %s", port)
+				serve = append(serve, l.Serve)
+				bound = append(bound, summaryPort{port, "tcp", st.Handler})
+				st.Listening = true
+			}
+		} else {
+			if l, err := s.ListenPacket(port); err != nil {
+				s.logf(EventError, "Error listening on port %s/udp: %s", port, err)
+				st.Error = err.Error()
+			} else {
+				s.Log.Printf("Listening on port %s/udp", port)
+				serve = append(serve, l.Serve)
+				bound = append(bound, summaryPort{port, "udp", st.Handler})
+				st.Listening = true
+			}
+		}
+		status = append(status, st)
+	}
+	status = append(status, skipped...)
+	s.connMu.Lock()
+	s.listenerStatus = status
+	s.connMu.Unlock()
+	var sandbox []string
+	if s.Sandbox != nil {
+		// Once every port is bound, before the first client is served
+		applied, err := EnterSandbox(*s.Sandbox)
+		if err != nil {
+			s.logf(EventError, "Unable to enter the sandbox, shutting down: %s", err)
+			go s.Shutdown()
+		} else {
+			s.logf(EventInfo, "Entered the sandbox: %s", strings.Join(applied, ", "))
+			sandbox = applied
+		}
+	}
+	s.emitSummary(bound, sandbox)
+
+	s.ready.Store(len(serve) > 0)
+	var wg sync.WaitGroup
+	for _, fn := range serve {
+		wg.Add(1)
+		go func(serve func() error) {
+			defer wg.Done()
+			serve()
+		}(fn)
+	}
+	wg.Wait() // Wait for all port listeners to finish
+	if s.closing.Load() {
+		<-s.done // and for Shutdown to drain their connections
+	}
+}
+
+// Ready reports whether ListenAndServe listens on at least one port and
+// Shutdown has not been called.
+func (s *Server) Ready() bool {
+	return s.ready.Load() && !s.closing.Load()
+}
+
+// Shutdown stops accepting connections and gives the connections being
+// served up to DrainTimeout to finish, so their data still gets logged. Then
+// it closes the remaining connections and waits briefly for their handlers to
+// log their last events. Connections still queued for a worker are closed
+// unserved. Calling Shutdown again waits for the first call to complete.
+func (s *Server) Shutdown() {
+	if !s.closing.CompareAndSwap(false, true) {
+		<-s.done
+		return
+	}
+	defer close(s.done)
+
+	s.connMu.Lock()
+	for l := range s.listeners {
+		l.ln.Close()
+		delete(s.listeners, l)
+	}
+	for l := range s.packetListeners {
+		l.pc.Close() // Serve ends the open sessions
+		delete(s.packetListeners, l)
+	}
+	s.connMu.Unlock()
+	s.discardQueued()
+
+	if busy := s.pool.busy.Load(); busy > 0 && s.DrainTimeout > 0 {
+		s.logf(EventInfo, "Waiting up to %s for %d active connections to finish.", s.DrainTimeout, busy)
+		if !s.waitIdle(s.DrainTimeout) {
+			s.logf(EventInfo, "Drain timeout reached, closing %d active connections.", s.pool.busy.Load())
+		}
+	}
+
+	s.cancel() // Tell handlers to wind down
+	s.connMu.Lock()
+	for conn := range s.active {
+		_ = conn.Close() // Close the connection and ignore the error if any
+		delete(s.active, conn)
+	}
+	s.connMu.Unlock()
+	s.waitIdle(forcedCloseGrace)
+	s.flushSamples()
+	s.logf(EventInfo, "All active connections closed.")
+	s.closeFirewall()
+}
+
+// forcedCloseGrace is how long Shutdown waits for handlers whose connection it closed.
+const forcedCloseGrace = 2 * time.Second
+
+// waitIdle waits until no worker is serving a connection, or timeout passes.
+// It reports whether the workers became idle.
+func (s *Server) waitIdle(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for s.pool.busy.Load() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return true
+}
+
+// handleConnection handles incoming connections and logs the details.
+// It also manages connection timeouts and closes the connection after handling.
+func (s *Server) handleConnection(conn net.Conn, port string, accepted time.Time) {
+	defer func(conn net.Conn) {
+		s.connMu.Lock()
+		delete(s.active, conn)
+		s.connMu.Unlock()
+		conn.Close() // Close the connection
+	}(conn)
+
+	// Behind a load balancer, recover the real client address from the PROXY header.
+	// Peers that are not trusted proxies are served as the clients they are, header and all.
+	opts := s.portOptions(port)
+	if opts.Proxy && s.proxyTrusted(conn.RemoteAddr()) {
+		proxied, err := readProxyHeader(conn)
+		if err != nil {
+			s.logf(EventError, "Invalid PROXY header on port %s from %s: %s", port, conn.RemoteAddr(), err)
+			return
+		}
+		conn = proxied
+	} else {
+		s.checkContainerProxy(conn.RemoteAddr())
+	}
+
+	// Connections redirected to this listener by netfilter (e.g. a whole port range
+	// sent to one socket) are attributed to the port the client actually targeted
+	local := addrPort(conn.LocalAddr())
+	var orig netip.AddrPort
+	if dst := originalDestination(conn); dst != nil {
+		orig = addrPort(dst)
+	}
+	if orig.IsValid() && strconv.Itoa(int(orig.Port())) != port {
+		redirect := port == s.RedirectPort
+		port = strconv.Itoa(int(orig.Port()))
+		if targeted, ok := s.Ports[port]; ok {
+			opts = targeted
+		} else if redirect {
+			opts = nil // a port that is not listed, closed
+		}
+	}
+
+	// Virtual hosts have services of their own
+	host := s.decoyHost(local, orig)
+	opts, served := s.hostPortOptions(host, port, opts)
+	served = served && opts != nil
+	s.countConnection(port)
+	if !served {
+		s.refuseConnection(conn, s.newConnMeta(port, conn.RemoteAddr().String(), local, orig, &PortOptions{}, accepted))
+		return
+	}
+	meta := s.newConnMeta(port, conn.RemoteAddr().String(), local, orig, opts, accepted)
+	if abandoned := s.abandonedHandlers(meta.Port); abandoned >= maxAbandonedHandlers {
+		meta.Emit(Event{
+			Type:    EventConnection,
+			Message: fmt.Sprintf("Refused connection on port %s from %s to %s: %d abandoned handlers are still running on it", meta.Port, meta.ClientAddr, meta.LocalAddr, abandoned),
+			Fields:  map[string]any{"refused": true, "abandoned_handlers": abandoned},
+		})
+		return
+	}
+	if !s.startSession(meta, fmt.Sprintf("Received connection on port %s from %s to %s", meta.Port, meta.ClientAddr, meta.LocalAddr)) {
+		return
+	}
+
+	name := s.handlerName(opts)
+	handler, ok := LookupHandler(name)
+	if !ok {
+		meta.Emit(Event{Type: EventError, Message: fmt.Sprintf("No handler named %q for port %s", name, port)})
+		return
+	}
+	if err := s.serveWatched(handler, name, conn, meta); err != nil {
+		meta.Emit(Event{Type: EventError, Message: fmt.Sprintf("Error in %s handler on port %s from %s: %s", name, meta.Port, meta.ClientAddr, err)})
+	}
+	s.endSession(meta)
+}
+
+// newConnMeta describes a new session of a client on port, or on the
+// service port of opts, that reached the local address local, redirected
+// from orig by netfilter when orig is valid.
+func (s *Server) newConnMeta(port, clientAddr string, local, orig netip.AddrPort, opts *PortOptions, started time.Time) *ConnMeta {
+	limits := s.Limits
+	if opts.Limits != nil {
+		limits = *opts.Limits
+	}
+	if opts.ServicePort != "" {
+		port = opts.ServicePort
+	}
+	meta := &ConnMeta{
+		Session:    newSessionID(),
+		Port:       port,
+		ClientAddr: clientAddr,
+		LocalAddr:  destinationAddr(local, orig),
+		Identity:   s.Identity,
+		Files:      s.Files,
+		Labels:     opts.Labels,
+		Limits:     limits,
+		Started:    started,
+		server:     s,
+		handler:    s.handlerName(opts),
+	}
+	if len(s.Decoys) > 0 {
+		if host := s.decoyHost(local, orig); host != nil {
+			meta.Identity, meta.Files = host.Identity, host.Files
+			if len(host.Labels) > 0 {
+				meta.Labels = make(map[string]string, len(host.Labels)+len(opts.Labels))
+				for key, value := range host.Labels {
+					meta.Labels[key] = value
+				}
+				for key, value := range opts.Labels {
+					meta.Labels[key] = value
+				}
+			}
+		}
+		meta.host = meta.Identity.FQDN()
+	}
+	return meta
+}
+
+// startSession looks the client up in the reputation feeds and logs the
+// start of its session with message. It returns false, after logging why,
+// when a drop feed refuses the client.
+func (s *Server) startSession(meta *ConnMeta, message string) bool {
+	connected := Event{Type: EventConnection, Message: message, Fields: map[string]any{}}
+	if meta.datagram {
+		connected.Fields["transport"] = "udp" // TCP otherwise
+	}
+	if s.Reputation != nil {
+		meta.Reputation = s.Reputation.Lookup(meta.ClientAddr)
+	}
+	if len(meta.Reputation) > 0 {
+		if drop, ok := feedVerdict(meta.Reputation); ok {
+			meta.Emit(Event{
+				Type:    EventConnection,
+				Message: fmt.Sprintf("Dropped connection on port %s from %s to %s: listed by %s (%s)", meta.Port, meta.ClientAddr, meta.LocalAddr, drop.Feed, drop.Range),
+				Fields:  map[string]any{"reputation": meta.Reputation, "dropped": true},
+			})
+			return false
+		}
+		connected.Message += ", listed by " + feedNames(meta.Reputation)
+		connected.Fields["reputation"] = meta.Reputation
+	}
+	meta.Emit(connected)
+	return true
+}
+
+// endSession logs the credentials still pending and the end of the session.
+func (s *Server) endSession(meta *ConnMeta) {
+	meta.FlushCredentials()
+	s.recordStage(meta.Stage)
+	ended := Event{
+		Type:    EventSessionEnd,
+		Message: fmt.Sprintf("Session on port %s from %s ended at stage %s", meta.Port, meta.ClientAddr, meta.Stage),
+		Fields: map[string]any{
+			"stage": meta.Stage.String(), "handler": meta.handler, "received_bytes": meta.received,
+			"duration": time.Since(meta.Started).Round(time.Millisecond).Seconds(),
+		},
+	}
+	if len(meta.Reputation) > 0 {
+		ended.Fields["reputation"] = meta.Reputation
+	}
+	if meta.withheld > 0 {
+		ended.Fields["withheld_bytes"] = meta.withheld
+	}
+	if meta.reset {
+		ended.Fields["reset"] = true
+	}
+	if meta.sent > 0 {
+		ended.Fields["sent_bytes"] = meta.sent
+		ended.Fields["first_sent"] = meta.firstSent.Sub(meta.Started).Round(time.Millisecond).Seconds()
+	}
+	meta.Emit(ended)
+}
+
+// countingConn counts the bytes a client sends and is sent in its ConnMeta,
+// and records when it was first sent anything. After each read and write it
+// publishes the ConnMeta to progress, see serveWatched.
+type countingConn struct {
+	net.Conn
+	meta     *ConnMeta
+	progress *sessionProgress
+}
+
+func (c countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.meta.received += n
+	if errors.Is(err, syscall.ECONNRESET) {
+		c.meta.reset = true
+	}
+	c.progress.publish(c.meta)
+	return n, err
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 && c.meta.sent == 0 {
+		c.meta.firstSent = time.Now()
+	}
+	c.meta.sent += n
+	c.progress.publish(c.meta)
+	return n, err
+}
+
+// handlerName returns the name of the handler serving a port.
+func (s *Server) handlerName(opts *PortOptions) string {
+	if opts.Handler != "" {
+		return opts.Handler
+	}
+	return s.DefaultHandler
+}
+
+// portOptions returns the settings of port, or defaults when it has none.
+func (s *Server) portOptions(port string) *PortOptions {
+	if opts, ok := s.Ports[port]; ok {
+		return opts
+	}
+	return &PortOptions{}
+}
+
+// logCredential logs a login attempt and raises a watchlist_hit alert when
+// the credential matches the leaked-credential watchlist.
+func (m *ConnMeta) logCredential(cred Credential) {
+	m.Emit(Event{
+		Type:    EventCredential,
+		Message: fmt.Sprintf("Credential attempt on port %s from %s: username=%q password=%q", m.Port, m.ClientAddr, cred.Username, cred.Password),
+		Fields:  map[string]any{"username": cred.Username, "password": cred.Password},
+	})
+
+	m.checkCanaries(cred)
+	s := m.server
+	if s.Watchlist == nil {
+		return
+	}
+	if hits := s.Watchlist.match(cred); len(hits) > 0 {
+		m.RaiseAlert("high", "watchlist_hit", fmt.Sprintf("watchlisted %s used on port %s from %s (username=%q)",
+			strings.Join(hits, " and "), m.Port, m.ClientAddr, cred.Username))
+	}
+}
+
+// newSessionID returns a random identifier for a connection.
+func newSessionID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// destinationAddr describes the address the client connected to, for
+// messages and events. On multi-IP hosts this tells which decoy address
+// attracted the traffic; for connections redirected by netfilter from orig
+// the original destination is included too.
+func destinationAddr(local, orig netip.AddrPort) string {
+	if orig.IsValid() && orig != local {
+		return fmt.Sprintf("%s (original destination %s)", local, orig)
+	}
+	return local.String()
+}
+
+// addrPort returns the address and port of a TCP or UDP address, or of
+// another address in host:port form, with IPv4 addresses unmapped so they
+// print as they do in net.Addr form.
+func addrPort(addr net.Addr) netip.AddrPort {
+	var ap netip.AddrPort
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ap = addr.AddrPort()
+	case *net.UDPAddr:
+		ap = addr.AddrPort()
+	default:
+		ap, _ = netip.ParseAddrPort(addr.String())
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
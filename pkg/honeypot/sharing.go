@@ -0,0 +1,300 @@
+package honeypot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Limits of the shared reports.
+const (
+	sharedMaxPayloads = 100 // payload hashes per report, the most frequent
+	sharedMaxPending  = 24  // reports kept for a later attempt while the endpoint fails
+)
+
+// SharingConfig opts in to sharing anonymized statistics with a community
+// endpoint, see Sharer.
+type SharingConfig struct {
+	URL      string            `json:"url"`                // endpoint reports are POSTed to, empty to disable
+	Sensor   string            `json:"sensor,omitempty"`   // pseudonymous sensor name, random for every run by default
+	Headers  map[string]string `json:"headers,omitempty"`  // extra request headers, e.g. Authorization with the sensor's key
+	Interval string            `json:"interval,omitempty"` // period of each report, default 1h
+}
+
+// SharedReport is what a Sharer uploads: counts and hashes, never client
+// addresses or payloads.
+type SharedReport struct {
+	Sensor    string          `json:"sensor"`
+	Start     time.Time       `json:"start"`
+	End       time.Time       `json:"end"`
+	Ports     []SharedCount   `json:"ports"`     // by connections, descending
+	Countries []SharedCount   `json:"countries"` // by connections, descending
+	Payloads  []SharedPayload `json:"payloads"`  // by count, descending
+	Stages    map[string]int  `json:"stages"`    // sessions by furthest attack stage reached
+}
+
+// SharedCount is the activity seen on a port or from a country.
+type SharedCount struct {
+	Key         string `json:"key"` // port number or country code
+	Connections int    `json:"connections"`
+	Sources     int    `json:"sources"` // distinct source addresses
+}
+
+// SharedPayload is a payload received, identified by its SHA-256.
+type SharedPayload struct {
+	SHA256   string   `json:"sha256"`
+	Count    int      `json:"count"`
+	Sources  int      `json:"sources"`
+	Families []string `json:"families,omitempty"` // see PayloadFamilies
+}
+
+// sharedBucket accumulates one SharedCount or SharedPayload.
+type sharedBucket struct {
+	count    int
+	sources  map[string]bool
+	families []string
+}
+
+// Sharer is an Output contributing to a DShield-style community picture:
+// every interval it POSTs a SharedReport of the ports targeted, the
+// countries attacks came from, the hashes of the payloads received and the
+// attack stages reached. Client addresses are only used to count distinct
+// sources and resolve countries; neither they nor payloads, credentials or
+// the sensor's own addresses leave the sensor. Reports that cannot be
+// delivered are retried with the next one.
+type Sharer struct {
+	url      string
+	sensor   string
+	headers  map[string]string
+	interval time.Duration
+	geo      *GeoDB
+	client   *http.Client
+
+	mu        sync.Mutex
+	start     time.Time
+	ports     map[string]*sharedBucket
+	countries map[string]*sharedBucket
+	payloads  map[string]*sharedBucket
+	stages    map[string]int
+
+	sendMu  sync.Mutex // held while uploading
+	pending []SharedReport
+
+	log       *log.Logger
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// checkSharing validates cfg and returns its report interval.
+func checkSharing(cfg SharingConfig) (time.Duration, error) {
+	if u, err := url.Parse(cfg.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return 0, fmt.Errorf("%q is not an http or https URL", cfg.URL)
+	}
+	interval := time.Hour
+	if cfg.Interval != "" {
+		var err error
+		if interval, err = time.ParseDuration(cfg.Interval); err != nil || interval < time.Minute {
+			return 0, fmt.Errorf("invalid interval %q, want at least 1m", cfg.Interval)
+		}
+	}
+	return interval, nil
+}
+
+// NewSharer validates cfg and starts sharing. geo resolves the countries
+// of sources and may be nil, in which case every country is unknown.
+// Failed uploads are reported to logger.
+func NewSharer(cfg SharingConfig, geo *GeoDB, logger *log.Logger) (*Sharer, error) {
+	interval, err := checkSharing(cfg)
+	if err != nil {
+		return nil, err
+	}
+	sensor := cfg.Sensor
+	if sensor == "" {
+		sensor = newSessionID()
+	}
+	s := &Sharer{
+		url: cfg.URL, sensor: sensor, headers: cfg.Headers, interval: interval, geo: geo,
+		client: &http.Client{Timeout: 30 * time.Second}, log: logger, stop: make(chan struct{}),
+	}
+	s.reset(time.Now())
+	go s.run()
+	return s, nil
+}
+
+// Sensor returns the name the sensor's reports are shared under.
+func (s *Sharer) Sensor() string {
+	return s.sensor
+}
+
+func (s *Sharer) reset(now time.Time) {
+	s.start = now
+	s.ports = make(map[string]*sharedBucket)
+	s.countries = make(map[string]*sharedBucket)
+	s.payloads = make(map[string]*sharedBucket)
+	s.stages = make(map[string]int)
+}
+
+// Write implements Output.
+func (s *Sharer) Write(ev Event) error {
+	source, _, err := net.SplitHostPort(ev.SrcAddr)
+	if err != nil {
+		return nil
+	}
+	switch ev.Type {
+	case EventConnection:
+		if dropped, _ := ev.Fields["dropped"].(bool); dropped {
+			return nil
+		}
+		country := s.geo.Lookup(net.ParseIP(source)).Country
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		sharedCount(s.ports, ev.Port, source)
+		sharedCount(s.countries, country, source)
+	case EventData:
+		data := EventPayload(ev)
+		if len(data) == 0 {
+			return nil
+		}
+		hash, _ := ev.Fields["data_sha256"].(string) // hash of the data before redaction
+		if hash == "" {
+			sum := sha256.Sum256(data)
+			hash = hex.EncodeToString(sum[:])
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if sharedCount(s.payloads, hash, source).count == 1 {
+			s.payloads[hash].families = PayloadFamilies(string(data))
+		}
+	case EventSessionEnd:
+		stage, _ := ev.Fields["stage"].(string)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.stages[stage]++
+	}
+	return nil
+}
+
+// sharedCount counts an event of source under key.
+func sharedCount(buckets map[string]*sharedBucket, key, source string) *sharedBucket {
+	b, ok := buckets[key]
+	if !ok {
+		b = &sharedBucket{sources: make(map[string]bool)}
+		buckets[key] = b
+	}
+	b.count++
+	b.sources[source] = true
+	return b
+}
+
+// run shares a report every interval until Close is called.
+func (s *Sharer) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.share(now)
+		}
+	}
+}
+
+// share closes the current period and uploads its report, after those
+// that could not be delivered before.
+func (s *Sharer) share(now time.Time) {
+	s.mu.Lock()
+	report := SharedReport{
+		Sensor:    s.sensor,
+		Start:     s.start.UTC(),
+		End:       now.UTC(),
+		Ports:     sharedCounts(s.ports),
+		Countries: sharedCounts(s.countries),
+		Stages:    s.stages,
+	}
+	for hash, b := range s.payloads {
+		report.Payloads = append(report.Payloads, SharedPayload{SHA256: hash, Count: b.count, Sources: len(b.sources), Families: b.families})
+	}
+	s.reset(now)
+	s.mu.Unlock()
+	sort.Slice(report.Payloads, func(i, j int) bool {
+		if report.Payloads[i].Count != report.Payloads[j].Count {
+			return report.Payloads[i].Count > report.Payloads[j].Count
+		}
+		return report.Payloads[i].SHA256 < report.Payloads[j].SHA256
+	})
+	report.Payloads = report.Payloads[:min(len(report.Payloads), sharedMaxPayloads)]
+
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	s.pending = append(s.pending, report)
+	if len(s.pending) > sharedMaxPending {
+		s.pending = s.pending[len(s.pending)-sharedMaxPending:]
+	}
+	for len(s.pending) > 0 {
+		if err := s.post(s.pending[0]); err != nil {
+			s.log.Printf("Error sharing statistics with %s (%d reports pending): %s", s.url, len(s.pending), err)
+			return
+		}
+		s.pending = s.pending[1:]
+	}
+}
+
+func (s *Sharer) post(report SharedReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+// sharedCounts returns buckets as SharedCounts, by connections, descending.
+func sharedCounts(buckets map[string]*sharedBucket) []SharedCount {
+	counts := make([]SharedCount, 0, len(buckets))
+	for key, b := range buckets {
+		counts = append(counts, SharedCount{Key: key, Connections: b.count, Sources: len(b.sources)})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Connections != counts[j].Connections {
+			return counts[i].Connections > counts[j].Connections
+		}
+		return counts[i].Key < counts[j].Key
+	})
+	return counts
+}
+
+// Close stops the periodic reports and shares the current partial period.
+func (s *Sharer) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		s.share(time.Now())
+	})
+	return nil
+}
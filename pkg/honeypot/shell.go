@@ -0,0 +1,1002 @@
+package honeypot
+
+import (
+	"fmt"
+	"io/fs"
+	mathrand "math/rand"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shellBanner is what BusyBox ash prints when an interactive shell starts.
+const shellBanner = "\nBusyBox v1.30.1 (Ubuntu 1:1.30.1-7ubuntu3) built-in shell (ash)\nEnter 'help' for a list of built-in commands.\n\n"
+
+// shellApplets are the applets the fake BusyBox lists. Those without a case
+// in shellSession.exec succeed silently, like chmod or kill usually do.
+var shellApplets = []string{
+	"[", "ash", "busybox", "cat", "cd", "chmod", "cp", "curl", "echo", "false", "find", "free", "ftpget",
+	"grep", "head", "help", "hostname", "id", "kill", "killall", "ls", "mkdir", "mv", "nproc", "ps",
+	"pwd", "rm", "sh", "sleep", "tftp", "touch", "true", "uname", "uptime", "wc", "wget", "whoami",
+}
+
+// shellDirs are directories every Linux host has, which the shell shows
+// even when the decoy file system has nothing in them.
+var shellDirs = map[string]bool{
+	"/": true, "/bin": true, "/dev": true, "/dev/shm": true, "/etc": true, "/home": true, "/lib": true,
+	"/mnt": true, "/proc": true, "/root": true, "/run": true, "/sbin": true, "/sys": true, "/tmp": true,
+	"/usr": true, "/usr/bin": true, "/usr/sbin": true, "/var": true, "/var/run": true, "/var/tmp": true,
+}
+
+// shellSession is a fake BusyBox shell a client logged into. It answers the
+// commands IoT bots run to fingerprint a device and drop their payload with
+// plausible output from the host identity and a file tree of its own, see
+// shellFS, and logs every command with its timing.
+type shellSession struct {
+	meta     *ConnMeta
+	user     string
+	cwd      string
+	files    *shellFS
+	login    time.Time
+	last     time.Time // when the previous command was received
+	commands int
+	alerted  map[string]bool // honeytokens whose reading raised an alert
+}
+
+// newShellSession starts a shell for user in their home directory.
+func newShellSession(meta *ConnMeta, user string) *shellSession {
+	now := time.Now()
+	sh := &shellSession{meta: meta, user: user, login: now, last: now, alerted: make(map[string]bool)}
+	sh.cwd = sh.home()
+	sh.files = newShellFS(meta, user, sh.home())
+	return sh
+}
+
+// home returns the home directory of the shell's user.
+func (sh *shellSession) home() string {
+	if sh.user == "root" {
+		return "/root"
+	}
+	return "/home/" + sh.user
+}
+
+// prompt returns BusyBox's default prompt, the working directory followed by # for root.
+func (sh *shellSession) prompt() string {
+	dir := sh.cwd
+	if dir == sh.home() {
+		dir = "~"
+	}
+	if sh.user == "root" {
+		return dir + " # "
+	}
+	return dir + " $ "
+}
+
+// run logs a command line and executes it, returning its output and whether
+// the line ends the session.
+func (sh *shellSession) run(line string) (output string, exit bool) {
+	now := time.Now()
+	sh.commands++
+	sh.meta.Emit(Event{
+		Type:    EventData,
+		Message: fmt.Sprintf("Shell command on port %s from %s: %s", sh.meta.Port, sh.meta.ClientAddr, escapePayload(line)),
+		Fields: map[string]any{
+			"data":          line,
+			"shell_command": line,
+			"shell_user":    sh.user,
+			"shell_cwd":     sh.cwd,
+			"shell_seq":     sh.commands,
+			"shell_elapsed": now.Sub(sh.login).Round(time.Millisecond).Seconds(),
+			"shell_delay":   now.Sub(sh.last).Round(time.Millisecond).Seconds(),
+		},
+	})
+	sh.last = now
+	sh.meta.logDropperURLs(line)
+	if sh.meta.Stage < StagePostExploit {
+		sh.meta.Stage = StagePostExploit
+	}
+	return sh.execLine(line, 0)
+}
+
+// execLine runs the commands of a line, honouring ;, &&, || and pipes.
+// depth counts nested sh -c invocations.
+func (sh *shellSession) execLine(line string, depth int) (string, bool) {
+	var out strings.Builder
+	status := 0
+	stdin := ""
+	commands, operators := shellSplit(line)
+	for i, command := range commands {
+		before := ""
+		if i > 0 {
+			before = operators[i-1]
+		}
+		if (before == "&&" && status != 0) || (before == "||" && status == 0) {
+			continue
+		}
+		if before != "|" {
+			stdin = ""
+		}
+		words := shellWords(command)
+		if len(words) == 0 {
+			continue
+		}
+		output, code, exit := sh.exec(words, stdin, depth)
+		if exit {
+			return out.String(), true
+		}
+		status = code
+		if operators[i] == "|" {
+			stdin = output
+			continue
+		}
+		out.WriteString(output)
+	}
+	return out.String(), false
+}
+
+// exec runs a single command with its redirections. Output redirected to
+// a file is written to the file tree of the session.
+func (sh *shellSession) exec(words []string, stdin string, depth int) (output string, status int, exit bool) {
+	var args []string
+	var input, target string
+	appending := false
+	for i := 0; i < len(words); i++ {
+		word, operator := words[i], ""
+		for _, op := range []string{"2>>", "2>", ">>", ">", "<"} {
+			if strings.HasPrefix(word, op) {
+				operator = op
+				break
+			}
+		}
+		if operator == "" {
+			args = append(args, word)
+			continue
+		}
+		file := word[len(operator):]
+		if file == "" && i+1 < len(words) {
+			i++
+			file = words[i]
+		}
+		switch operator {
+		case "<":
+			input = file
+		case ">", ">>":
+			if !strings.HasPrefix(file, "&") {
+				target, appending = file, operator == ">>"
+			}
+		}
+	}
+	if input != "" {
+		content, err := sh.readFile(sh.resolve(input))
+		if err != "" {
+			return fmt.Sprintf("-sh: can't open %s: %s\n", input, err), 1, false
+		}
+		stdin = content
+	}
+	dest := sh.resolve(target)
+	device := strings.HasPrefix(dest, "/dev/") // such as /dev/null
+	if target != "" && !device {
+		if _, err := sh.files.create(dest, sh.user); err != "" {
+			return fmt.Sprintf("-sh: can't create %s: %s\n", target, err), 1, false
+		}
+	}
+	if len(args) > 0 {
+		output, status, exit = sh.applet(args, stdin, depth)
+	}
+	if target != "" {
+		if !device {
+			if err := sh.files.write(dest, sh.user, output, appending); err != "" {
+				return "-sh: write error: " + err + "\n", 1, exit
+			}
+		}
+		output = ""
+	}
+	return output, status, exit
+}
+
+// applet runs the command named by args[0].
+func (sh *shellSession) applet(args []string, stdin string, depth int) (string, int, bool) {
+	name := args[0]
+	if strings.Contains(name, "/") {
+		switch path.Dir(name) {
+		case "/bin", "/sbin", "/usr/bin", "/usr/sbin":
+			name = path.Base(name)
+		default:
+			// Runs a downloaded payload, which prints nothing
+			switch node := sh.files.stat(sh.resolve(name)); {
+			case node == nil:
+				return fmt.Sprintf("-sh: %s: not found\n", name), 127, false
+			case node.mode.IsDir() || node.mode&0111 == 0:
+				return fmt.Sprintf("-sh: %s: Permission denied\n", name), 126, false
+			}
+			return "", 0, false
+		}
+	}
+	id := sh.meta.Identity
+	switch name {
+	case "exit", "logout", "quit":
+		return "", 0, true
+	case "busybox":
+		if len(args) == 1 {
+			return "BusyBox v1.30.1 (Ubuntu 1:1.30.1-7ubuntu3) multi-call binary.\n" +
+				"BusyBox is copyrighted by many authors between 1998-2015.\n" +
+				"Licensed under GPLv2. See source distribution for detailed\ncopyright notices.\n\n" +
+				"Currently defined functions:\n\t" + strings.Join(shellApplets, ", ") + "\n", 0, false
+		}
+		if !shellApplet(args[1]) {
+			return args[1] + ": applet not found\n", 127, false
+		}
+		return sh.applet(args[1:], stdin, depth)
+	case "enable", "system", "shell", "linuxshell", "ash", "sh":
+		if (name == "sh" || name == "ash") && len(args) > 2 && args[1] == "-c" && depth < 4 {
+			out, exit := sh.execLine(args[2], depth+1)
+			return out, 0, exit
+		}
+		return "", 0, false
+	case "help":
+		return "Built-in commands:\n------------------\n\t. : [ [[ alias bg break cd chdir command continue echo eval exec exit export false fg getopts hash help history jobs kill let local printf pwd read readonly return set shift source test times trap true type ulimit umask unalias unset wait\n", 0, false
+	case "true", ":":
+		return "", 0, false
+	case "false":
+		return "", 1, false
+	case "echo":
+		return shellEcho(args[1:]), 0, false
+	case "cd":
+		dir := sh.home()
+		if len(args) > 1 {
+			dir = sh.resolve(args[1])
+		}
+		if !sh.isDir(dir) {
+			return fmt.Sprintf("-sh: cd: can't cd to %s: No such file or directory\n", args[1]), 2, false
+		}
+		sh.cwd = dir
+		return "", 0, false
+	case "pwd":
+		return sh.cwd + "\n", 0, false
+	case "cat":
+		if len(args) == 1 {
+			return stdin, 0, false
+		}
+		var out strings.Builder
+		status := 0
+		for _, name := range args[1:] {
+			if strings.HasPrefix(name, "-") {
+				continue
+			}
+			content, err := sh.readFile(sh.resolve(name))
+			if err != "" {
+				if err == "Is a directory" {
+					fmt.Fprintf(&out, "cat: read error: %s\n", err)
+				} else {
+					fmt.Fprintf(&out, "cat: can't open '%s': %s\n", name, err)
+				}
+				status = 1
+				continue
+			}
+			out.WriteString(content)
+		}
+		return out.String(), status, false
+	case "ls":
+		return sh.list(args[1:])
+	case "find":
+		return sh.find(args[1:])
+	case "mkdir", "touch", "rm", "cp", "mv", "chmod":
+		return sh.change(name, args[1:])
+	case "uname":
+		return shellUname(id, args[1:]), 0, false
+	case "id":
+		if sh.user == "root" {
+			return "uid=0(root) gid=0(root) groups=0(root)\n", 0, false
+		}
+		return fmt.Sprintf("uid=1000(%s) gid=1000(%s) groups=1000(%s)\n", sh.user, sh.user, sh.user), 0, false
+	case "whoami":
+		return sh.user + "\n", 0, false
+	case "hostname":
+		return id.Hostname + "\n", 0, false
+	case "nproc":
+		return strconv.Itoa(shellCPUs(id)) + "\n", 0, false
+	case "ps":
+		return sh.processes(), 0, false
+	case "free":
+		total := shellMemory(id)
+		used := total * 3 / 10
+		return fmt.Sprintf("              total        used        free      shared  buff/cache   available\n"+
+			"Mem:       %8d    %8d    %8d        1184    %8d    %8d\nSwap:             0           0           0\n",
+			total, used, total-used-total/5, total/5, total-used), 0, false
+	case "uptime":
+		up := time.Since(shellBoot(id))
+		return fmt.Sprintf(" %s up %d days, %2d:%02d,  load average: 0.08, 0.03, 0.01\n",
+			time.Now().Format("15:04:05"), int(up.Hours())/24, int(up.Hours())%24, int(up.Minutes())%60), 0, false
+	case "grep":
+		if len(args) < 2 {
+			return "BusyBox v1.30.1 (Ubuntu 1:1.30.1-7ubuntu3) multi-call binary.\n\nUsage: grep [-HhnlLoqvsriwFE] [-m N] [-A/B/C N] PATTERN/-e PATTERN.../-f FILE [FILE]...\n", 1, false
+		}
+		pattern := args[len(args)-1]
+		if stdin == "" && len(args) > 2 {
+			pattern = args[len(args)-2]
+			stdin, _ = sh.readFile(sh.resolve(args[len(args)-1]))
+		}
+		var out strings.Builder
+		for _, line := range strings.SplitAfter(stdin, "\n") {
+			if line != "" && strings.Contains(line, pattern) {
+				out.WriteString(line)
+			}
+		}
+		if out.Len() == 0 {
+			return "", 1, false
+		}
+		return out.String(), 0, false
+	case "head":
+		lines := 10
+		var files []string
+		for i := 1; i < len(args); i++ {
+			if args[i] == "-n" && i+1 < len(args) {
+				i++
+			}
+			if n, err := strconv.Atoi(strings.TrimPrefix(args[i], "-")); err == nil && n > 0 {
+				lines = n
+			} else if !strings.HasPrefix(args[i], "-") {
+				files = append(files, args[i])
+			}
+		}
+		if len(files) > 0 {
+			content, err := sh.readFile(sh.resolve(files[0]))
+			if err != "" {
+				return fmt.Sprintf("head: %s: %s\n", files[0], err), 1, false
+			}
+			stdin = content
+		}
+		kept := strings.SplitAfter(stdin, "\n")
+		return strings.Join(kept[:min(lines, len(kept))], ""), 0, false
+	case "wc":
+		if len(args) > 1 && !strings.HasPrefix(args[len(args)-1], "-") {
+			content, err := sh.readFile(sh.resolve(args[len(args)-1]))
+			if err != "" {
+				return fmt.Sprintf("wc: %s: %s\n", args[len(args)-1], err), 1, false
+			}
+			return fmt.Sprintf("%d %s\n", strings.Count(content, "\n"), args[len(args)-1]), 0, false
+		}
+		return fmt.Sprintf("%d\n", strings.Count(stdin, "\n")), 0, false
+	case "wget":
+		return sh.wget(args[1:])
+	}
+	if shellApplet(name) {
+		return "", 0, false
+	}
+	if reply, ok := sh.meta.respond(ResponderShell, strings.Join(args, " ")); ok {
+		return reply, 0, false
+	}
+	return fmt.Sprintf("-sh: %s: not found\n", args[0]), 127, false
+}
+
+// shellApplet reports whether BusyBox has an applet name.
+func shellApplet(name string) bool {
+	i := sort.SearchStrings(shellApplets, name)
+	return i < len(shellApplets) && shellApplets[i] == name
+}
+
+// resolve returns the absolute, cleaned form of name relative to the working directory.
+func (sh *shellSession) resolve(name string) string {
+	if name == "~" || strings.HasPrefix(name, "~/") {
+		name = sh.home() + name[1:]
+	}
+	if !strings.HasPrefix(name, "/") {
+		name = sh.cwd + "/" + name
+	}
+	return path.Clean(name)
+}
+
+// isDir reports whether the absolute path dir is a directory.
+func (sh *shellSession) isDir(dir string) bool {
+	node := sh.files.stat(dir)
+	return node != nil && node.mode.IsDir()
+}
+
+// readFile returns the content of the absolute path name, or the error cat
+// prints. Reading a honeytoken raises a honeytoken_read alert, once per
+// file and session.
+func (sh *shellSession) readFile(name string) (string, string) {
+	id := sh.meta.Identity
+	resolved, node := sh.files.resolve(name)
+	switch {
+	case node == nil:
+		return "", "No such file or directory"
+	case node.mode.IsDir():
+		return "", "Is a directory"
+	case node.honeytoken && !sh.alerted[resolved]:
+		sh.alerted[resolved] = true
+		sh.meta.RaiseAlert("medium", "honeytoken_read", fmt.Sprintf("%s read %s in a shell on port %s", sh.meta.ClientAddr, resolved, sh.meta.Port))
+	}
+	switch resolved {
+	case "/proc/cpuinfo":
+		return shellCPUInfo(id), ""
+	case "/proc/version":
+		return fmt.Sprintf("Linux version %s (buildd@lcy02-amd64-080) (gcc (Ubuntu 11.4.0-1ubuntu1~22.04) 11.4.0, GNU ld (GNU Binutils for Ubuntu) 2.38) %s\n", id.Kernel, shellKernelVersion(id)), ""
+	case "/proc/meminfo":
+		total := shellMemory(id)
+		return fmt.Sprintf("MemTotal:       %8d kB\nMemFree:        %8d kB\nMemAvailable:   %8d kB\nBuffers:           52344 kB\nCached:         %8d kB\n", total, total/2, total*7/10, total/5), ""
+	case "/proc/mounts":
+		return "/dev/vda1 / ext4 rw,relatime 0 0\nproc /proc proc rw,nosuid,nodev,noexec,relatime 0 0\nsysfs /sys sysfs rw,nosuid,nodev,noexec,relatime 0 0\n" +
+			"tmpfs /run tmpfs rw,nosuid,nodev,noexec,relatime,size=401172k,mode=755 0 0\ntmpfs /dev/shm tmpfs rw,nosuid,nodev 0 0\n", ""
+	}
+	return node.content, ""
+}
+
+// list runs ls with args.
+func (sh *shellSession) list(args []string) (string, int, bool) {
+	long, all := false, false
+	var names []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			long = long || strings.Contains(arg, "l")
+			all = all || strings.ContainsAny(arg, "aA")
+			continue
+		}
+		names = append(names, arg)
+	}
+	if len(names) == 0 {
+		names = []string{"."}
+	}
+	var out strings.Builder
+	status := 0
+	for _, name := range names {
+		dir := sh.resolve(name)
+		node := sh.files.stat(dir)
+		if node == nil {
+			fmt.Fprintf(&out, "ls: %s: No such file or directory\n", name)
+			status = 1
+			continue
+		}
+		if !node.mode.IsDir() {
+			if long {
+				out.WriteString(sh.longEntry(dir, name))
+			} else {
+				out.WriteString(name + "\n")
+			}
+			continue
+		}
+		var files []string
+		for _, file := range sh.files.children(dir) {
+			if all || !strings.HasPrefix(file, ".") {
+				files = append(files, file)
+			}
+		}
+		if !long {
+			if len(files) > 0 {
+				out.WriteString(strings.Join(files, "  ") + "\n")
+			}
+			continue
+		}
+		for _, file := range files {
+			out.WriteString(sh.longEntry(path.Join(dir, file), file))
+		}
+	}
+	return out.String(), status, false
+}
+
+// longEntry returns the line of ls -l for the absolute path name, shown as
+// shown.
+func (sh *shellSession) longEntry(name, shown string) string {
+	node := sh.files.nodes[name]
+	links := 1
+	if node.mode.IsDir() {
+		links = 2
+	}
+	if node.link != "" {
+		shown += " -> " + node.link
+	}
+	return fmt.Sprintf("%s %4d %-8s %-8s %9d %s %s\n", node.modeString(), links, node.owner, node.owner, node.length(), node.modTime.Format("Jan _2 15:04"), shown)
+}
+
+// find runs find with args: the paths to search, then the expression, of
+// which -name, -iname, -path, -type and -maxdepth are understood and other
+// tests are ignored.
+func (sh *shellSession) find(args []string) (string, int, bool) {
+	var roots []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") && args[0] != "(" && args[0] != "!" {
+		roots = append(roots, args[0])
+		args = args[1:]
+	}
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	var tests []func(name string, node *shellNode) bool
+	maxDepth := -1
+	for i := 0; i < len(args); i++ {
+		test, value := args[i], ""
+		if shellFindValues[test] && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		switch test {
+		case "-exec", "-ok":
+			for i < len(args) && args[i] != ";" && args[i] != "+" {
+				i++
+			}
+		case "-name":
+			tests = append(tests, func(name string, _ *shellNode) bool {
+				matched, _ := path.Match(value, path.Base(name))
+				return matched
+			})
+		case "-iname":
+			tests = append(tests, func(name string, _ *shellNode) bool {
+				matched, _ := path.Match(strings.ToLower(value), strings.ToLower(path.Base(name)))
+				return matched
+			})
+		case "-path":
+			tests = append(tests, func(name string, _ *shellNode) bool {
+				matched, _ := path.Match(value, name)
+				return matched
+			})
+		case "-type":
+			tests = append(tests, func(_ string, node *shellNode) bool {
+				switch value {
+				case "d":
+					return node.mode.IsDir()
+				case "l":
+					return node.link != ""
+				}
+				return node.mode.IsRegular()
+			})
+		case "-maxdepth":
+			maxDepth, _ = strconv.Atoi(value)
+		}
+	}
+
+	var out strings.Builder
+	status := 0
+	for _, root := range roots {
+		if root == "~" || strings.HasPrefix(root, "~/") {
+			root = sh.home() + root[1:] // as the shell expands it
+		}
+		base := sh.resolve(root)
+		if sh.files.nodes[base] == nil {
+			fmt.Fprintf(&out, "find: %s: No such file or directory\n", root)
+			status = 1
+			continue
+		}
+		var found []string
+		for name := range sh.files.nodes {
+			if name == base || strings.HasPrefix(name, strings.TrimSuffix(base, "/")+"/") {
+				found = append(found, name)
+			}
+		}
+		sort.Strings(found)
+		for _, name := range found {
+			rel := strings.TrimPrefix(strings.TrimPrefix(name, base), "/")
+			if maxDepth >= 0 && rel != "" && strings.Count(rel, "/")+1 > maxDepth {
+				continue
+			}
+			shown := root
+			if rel != "" {
+				shown = strings.TrimSuffix(root, "/") + "/" + rel
+			}
+			matched := true
+			for _, test := range tests {
+				matched = matched && test(shown, sh.files.nodes[name])
+			}
+			if matched {
+				out.WriteString(shown + "\n")
+			}
+		}
+	}
+	return out.String(), status, false
+}
+
+// shellFindValues are the tests of find taking a value.
+var shellFindValues = map[string]bool{
+	"-name": true, "-iname": true, "-path": true, "-type": true, "-maxdepth": true, "-mindepth": true,
+	"-perm": true, "-user": true, "-group": true, "-size": true, "-mtime": true, "-newer": true,
+}
+
+// change runs the applets changing the file tree: mkdir, touch, rm, cp, mv
+// and chmod, with the arguments args.
+func (sh *shellSession) change(applet string, args []string) (string, int, bool) {
+	recursive, parents, force := false, false, false
+	var operands []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+			recursive = recursive || strings.ContainsAny(arg, "rR")
+			parents = parents || strings.Contains(arg, "p")
+			force = force || strings.Contains(arg, "f")
+			continue
+		}
+		operands = append(operands, arg)
+	}
+	if len(operands) == 0 || (applet == "cp" || applet == "mv" || applet == "chmod") && len(operands) < 2 {
+		return fmt.Sprintf("BusyBox v1.30.1 (Ubuntu 1:1.30.1-7ubuntu3) multi-call binary.\n\nUsage: %s [OPTIONS] FILE...\n", applet), 1, false
+	}
+	var out strings.Builder
+	status := 0
+	fail := func(format string, args ...any) {
+		fmt.Fprintf(&out, applet+": "+format+"\n", args...)
+		status = 1
+	}
+	now := time.Now()
+	switch applet {
+	case "mkdir":
+		for _, operand := range operands {
+			name := sh.resolve(operand)
+			switch node := sh.files.nodes[name]; {
+			case node != nil && (!parents || !node.mode.IsDir()):
+				fail("can't create directory '%s': File exists", operand)
+			case node == nil && !parents && !sh.isDir(path.Dir(name)):
+				fail("can't create directory '%s': No such file or directory", operand)
+			case node == nil && !sh.files.charge(len(name)+64):
+				fail("can't create directory '%s': No space left on device", operand)
+			case node == nil:
+				sh.files.add(name, &shellNode{mode: fs.ModeDir | 0755, owner: sh.user, modTime: now})
+			}
+		}
+	case "touch":
+		for _, operand := range operands {
+			node := sh.files.stat(sh.resolve(operand))
+			if node == nil {
+				var err string
+				if node, err = sh.files.create(sh.resolve(operand), sh.user); err != "" {
+					fail("%s: %s", operand, err)
+					continue
+				}
+			}
+			node.modTime = now
+		}
+	case "rm":
+		for _, operand := range operands {
+			name := sh.resolve(operand)
+			switch node := sh.files.nodes[name]; {
+			case node == nil:
+				if !force {
+					fail("can't remove '%s': No such file or directory", operand)
+				}
+			case node.mode.IsDir() && !recursive:
+				fail("'%s' is a directory", operand)
+			default:
+				sh.files.remove(name)
+			}
+		}
+	case "cp", "mv":
+		dest := sh.resolve(operands[len(operands)-1])
+		for _, operand := range operands[:len(operands)-1] {
+			name := sh.resolve(operand)
+			target := dest
+			if sh.isDir(dest) {
+				target = path.Join(dest, path.Base(name))
+			}
+			node := sh.files.nodes[name]
+			switch {
+			case node == nil:
+				fail("can't stat '%s': No such file or directory", operand)
+			case applet == "mv":
+				if parent := sh.files.stat(path.Dir(target)); parent == nil || !parent.mode.IsDir() {
+					fail("can't rename '%s': No such file or directory", operand)
+				} else {
+					sh.files.move(name, target)
+				}
+			case node.mode.IsDir() && !recursive:
+				fail("omitting directory '%s'", operand)
+			case node.mode.IsDir():
+				copies := make(map[string]*shellNode)
+				for copied, child := range sh.files.nodes {
+					if (copied == name || strings.HasPrefix(copied, name+"/")) && sh.files.charge(len(child.content)+64) {
+						duplicate := *child
+						duplicate.owner, duplicate.modTime = sh.user, now
+						copies[target+copied[len(name):]] = &duplicate
+					}
+				}
+				for copied, duplicate := range copies {
+					sh.files.add(copied, duplicate)
+				}
+			default:
+				content, err := sh.readFile(name)
+				if err == "" {
+					err = sh.files.write(target, sh.user, content, false)
+				}
+				if err != "" {
+					fail("can't create '%s': %s", operands[len(operands)-1], err)
+				} else if copied := sh.files.stat(target); copied != nil {
+					copied.mode, copied.size = node.mode, node.size
+				}
+			}
+		}
+	case "chmod":
+		mode := operands[0]
+		for _, operand := range operands[1:] {
+			node := sh.files.stat(sh.resolve(operand))
+			if node == nil {
+				fail("%s: No such file or directory", operand)
+				continue
+			}
+			if perm, err := strconv.ParseUint(mode, 8, 12); err == nil {
+				node.mode = node.mode&fs.ModeType | fs.FileMode(perm)
+			} else if strings.Contains(mode, "+x") {
+				node.mode |= 0111
+			} else if strings.Contains(mode, "-x") {
+				node.mode &^= 0111
+			}
+		}
+	}
+	return out.String(), status, false
+}
+
+// wget pretends to download the URL of args, as BusyBox wget reports it.
+func (sh *shellSession) wget(args []string) (string, int, bool) {
+	var target, saveAs string
+	quiet := false
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-O" && i+1 < len(args):
+			i++
+			saveAs = args[i]
+		case arg == "-q":
+			quiet = true
+		case strings.HasPrefix(arg, "-"):
+		default:
+			target = arg
+		}
+	}
+	if target == "" {
+		return "BusyBox v1.30.1 (Ubuntu 1:1.30.1-7ubuntu3) multi-call binary.\n\nUsage: wget [-c|--continue] [--spider] [-q|--quiet] [-O|--output-document FILE]\n\t[-o|--output-file FILE] [--header 'header: value'] [-Y|--proxy on/off]\n\t[-P DIR] [-S|--server-response] [-U|--user-agent AGENT] [-T SEC] URL...\n", 1, false
+	}
+	rest := target
+	if _, after, ok := strings.Cut(target, "://"); ok {
+		rest = after
+	}
+	host, file, _ := strings.Cut(rest, "/")
+	if saveAs == "" {
+		saveAs = path.Base("/" + file)
+		if saveAs == "/" {
+			saveAs = "index.html"
+		}
+	}
+	size := 40000 + sh.meta.Rand().Intn(100000)
+	if saveAs != "-" {
+		// The download is a binary of the size reported, as far as ls and cat can tell
+		if err := sh.files.write(sh.resolve(saveAs), sh.user, shellELFHeader, false); err != "" {
+			return fmt.Sprintf("wget: can't open '%s': %s\n", saveAs, err), 1, false
+		}
+		sh.files.stat(sh.resolve(saveAs)).size = int64(size)
+	}
+	if quiet || saveAs == "-" {
+		return "", 0, false
+	}
+	address := host
+	if !strings.Contains(host, ":") {
+		address += ":80"
+	}
+	return fmt.Sprintf("Connecting to %s (%s)\nsaving to '%s'\n%-20s 100%% |********************************| %4dk  0:00:00 ETA\n'%s' saved\n",
+		host, address, saveAs, saveAs, size/1024, saveAs), 0, false
+}
+
+// processes returns the output of ps.
+func (sh *shellSession) processes() string {
+	rng := mathrand.New(mathrand.NewSource(sh.meta.Identity.seed()))
+	var out strings.Builder
+	out.WriteString("  PID USER       VSZ STAT COMMAND\n")
+	pid := 1
+	for _, p := range []struct{ user, command string }{
+		{"root", "/sbin/init"}, {"root", "[kthreadd]"}, {"root", "[ksoftirqd/0]"}, {"root", "[kworker/0:0H]"},
+		{"root", "/sbin/syslogd -n"}, {"root", "/sbin/klogd -n"}, {"root", "/usr/sbin/crond -f"},
+		{"root", "/usr/sbin/telnetd -F"}, {"root", "/usr/sbin/dropbear -R"}, {"root", "/sbin/udhcpc -i eth0"},
+	} {
+		fmt.Fprintf(&out, "%5d %-8s %5d S    %s\n", pid, p.user, 1000+rng.Intn(3000), p.command)
+		pid += 1 + rng.Intn(200)
+	}
+	fmt.Fprintf(&out, "%5d %-8s %5d S    -sh\n", pid+1000+rng.Intn(1000), sh.user, 1400+rng.Intn(100))
+	return out.String()
+}
+
+// shellSplit splits a command line into its commands and the operators
+// following each (";", "&&", "||", "|", or "" after the last), ignoring
+// separators inside quotes.
+func shellSplit(line string) (commands, operators []string) {
+	var quote byte
+	start := 0
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '\\':
+			i++
+		case c == ';' || c == '\n' || c == '|' || (c == '&' && i+1 < len(line) && line[i+1] == '&'):
+			op := string(c)
+			if c == '\n' {
+				op = ";"
+			}
+			if (c == '|' || c == '&') && i+1 < len(line) && line[i+1] == c {
+				op = line[i : i+2]
+			}
+			commands = append(commands, line[start:i])
+			operators = append(operators, op)
+			i += len(op) - 1
+			start = i + 1
+		}
+	}
+	commands = append(commands, line[start:])
+	operators = append(operators, "")
+	return commands, operators
+}
+
+// shellWords splits a command into words, removing quotes and escapes.
+func shellWords(command string) []string {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote byte
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else if c == '\\' && i+1 < len(command) && strings.IndexByte(`"\$`+"`", command[i+1]) >= 0 {
+				i++
+				word.WriteByte(command[i])
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case c == '\\' && i+1 < len(command):
+			i++
+			word.WriteByte(command[i])
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// shellEcho runs echo with args, understanding -n and -e like BusyBox.
+// Mirai checks for a working shell by echoing hex escapes.
+func shellEcho(args []string) string {
+	newline, escapes := true, false
+	for len(args) > 0 && len(args[0]) > 1 && strings.Trim(args[0], "-neE") == "" && args[0][0] == '-' {
+		newline = newline && !strings.Contains(args[0], "n")
+		escapes = escapes || strings.Contains(args[0], "e")
+		args = args[1:]
+	}
+	text := strings.Join(args, " ")
+	if escapes {
+		var out strings.Builder
+		for i := 0; i < len(text); i++ {
+			if text[i] != '\\' || i+1 == len(text) {
+				out.WriteByte(text[i])
+				continue
+			}
+			i++
+			switch c := text[i]; c {
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			case 'r':
+				out.WriteByte('\r')
+			case '\\':
+				out.WriteByte('\\')
+			case 'c':
+				return out.String()
+			case 'x', '0':
+				digits, base, width := "", 16, 2
+				if c == '0' {
+					base, width = 8, 3
+				}
+				for j := i + 1; j < len(text) && j <= i+width; j++ {
+					if _, err := strconv.ParseUint(text[j:j+1], base, 8); err != nil {
+						break
+					}
+					digits += text[j : j+1]
+				}
+				if value, err := strconv.ParseUint(digits, base, 8); err == nil {
+					out.WriteByte(byte(value))
+					i += len(digits)
+				} else if c == '0' {
+					out.WriteByte(0)
+				} else {
+					out.WriteString(`\x`)
+				}
+			default:
+				out.WriteByte('\\')
+				out.WriteByte(c)
+			}
+		}
+		text = out.String()
+	}
+	if newline {
+		text += "\n"
+	}
+	return text
+}
+
+// shellUname returns the output of uname with args for host id.
+func shellUname(id *HostIdentity, args []string) string {
+	fields := map[byte]string{'s': "Linux", 'n': id.Hostname, 'r': id.Kernel, 'v': shellKernelVersion(id), 'm': "x86_64", 'o': "GNU/Linux"}
+	selected := make(map[byte]bool)
+	for _, arg := range args {
+		for _, flag := range strings.TrimPrefix(arg, "-") {
+			if flag == 'a' {
+				for f := range fields {
+					selected[f] = true
+				}
+			}
+			selected[byte(flag)] = true
+		}
+	}
+	if len(selected) == 0 {
+		selected['s'] = true
+	}
+	var parts []string
+	for _, f := range []byte("snrvmo") {
+		if selected[f] {
+			parts = append(parts, fields[f])
+		}
+	}
+	return strings.Join(parts, " ") + "\n"
+}
+
+// shellKernelVersion returns the build string of the kernel, uname -v.
+func shellKernelVersion(id *HostIdentity) string {
+	if strings.HasPrefix(id.OS, "Debian") {
+		return "#1 SMP PREEMPT_DYNAMIC Debian 6.1.76-1 (2024-02-01)"
+	}
+	return "#115-Ubuntu SMP Mon Apr 15 09:52:04 UTC 2024"
+}
+
+// shellCPUs returns the number of processors of host id, stable across
+// sessions and restarts.
+func shellCPUs(id *HostIdentity) int {
+	return []int{1, 2, 2, 4, 4, 8}[mathrand.New(mathrand.NewSource(id.seed())).Intn(6)]
+}
+
+// shellMemory returns the memory of host id in KiB.
+func shellMemory(id *HostIdentity) int {
+	return shellCPUs(id) * 2 * 1014524
+}
+
+// shellBoot returns when host id last booted, a few weeks ago.
+func shellBoot(id *HostIdentity) time.Time {
+	days := 3 + int(uint64(id.seed())%40)
+	return time.Now().Add(-time.Duration(days) * 24 * time.Hour).Truncate(time.Hour)
+}
+
+// shellCPUInfo returns /proc/cpuinfo of host id, the virtual CPUs of a KVM guest.
+func shellCPUInfo(id *HostIdentity) string {
+	var out strings.Builder
+	for i := 0; i < shellCPUs(id); i++ {
+		fmt.Fprintf(&out, "processor\t: %d\nvendor_id\t: GenuineIntel\ncpu family\t: 6\nmodel\t\t: 85\n"+
+			"model name\t: Intel Xeon Processor (Cascadelake)\nstepping\t: 6\nmicrocode\t: 0x1\ncpu MHz\t\t: 2593.906\n"+
+			"cache size\t: 16384 KB\nphysical id\t: %d\nsiblings\t: 1\ncore id\t\t: 0\ncpu cores\t: 1\n"+
+			"flags\t\t: fpu vme de pse tsc msr pae mce cx8 apic sep mtrr pge mca cmov pat pse36 clflush mmx fxsr sse sse2 ss syscall nx pdpe1gb rdtscp lm constant_tsc rep_good nopl xtopology cpuid tsc_known_freq pni pclmulqdq ssse3 fma cx16 pcid sse4_1 sse4_2 x2apic movbe popcnt aes xsave avx f16c rdrand hypervisor lahf_lm abm 3dnowprefetch avx2 bmi2 avx512f\n"+
+			"bogomips\t: 5187.81\naddress sizes\t: 46 bits physical, 48 bits virtual\n\n", i, i)
+	}
+	return out.String()
+}
+
+// shellELFHeader is the start of an x86-64 executable, what bots read from
+// /proc/self/exe or /bin/busybox to learn the architecture of the device.
+const shellELFHeader = "\x7fELF\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x03\x00\x3e\x00\x01\x00\x00\x00"
@@ -0,0 +1,190 @@
+package honeypot
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// SplunkConfig sends events to a Splunk HTTP Event Collector, see
+// SplunkOutput.
+type SplunkConfig struct {
+	URL        string      `json:"url"`                  // HEC endpoint, e.g. https://splunk:8088, empty to disable
+	Token      string      `json:"token"`                // HEC token
+	Index      string      `json:"index,omitempty"`      // index of the events, default the default index of the token
+	Sourcetype string      `json:"sourcetype,omitempty"` // default "gopot"
+	Source     string      `json:"source,omitempty"`     // default "gopot"
+	Host       string      `json:"host,omitempty"`       // default the host name of the sensor
+	CA         string      `json:"ca,omitempty"`         // PEM certificates of the CAs the HEC certificate must chain to, empty for the system roots
+	Timeout    string      `json:"timeout,omitempty"`    // per request, default 30s
+	Batch      BatchConfig `json:"batch"`                // default 100 events at least every 5s, gzip compressed unless "none"
+}
+
+// splunkEvent is an event as the HEC event endpoint takes it.
+type splunkEvent struct {
+	Time       float64           `json:"time"`
+	Host       string            `json:"host,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	Sourcetype string            `json:"sourcetype,omitempty"`
+	Index      string            `json:"index,omitempty"`
+	Event      Event             `json:"event"`
+	Fields     map[string]string `json:"fields,omitempty"`
+}
+
+// SplunkOutput is an Output sending events to the event endpoint of a Splunk
+// HTTP Event Collector, so Splunk users need no forwarder on the sensor.
+// Each event is sent whole as the JSON event of a HEC event, timestamped
+// with its time, and its type, session, handler, port, client address and
+// labels are also sent as indexed fields, so searches on them need no
+// extraction.
+type SplunkOutput struct {
+	*Batcher
+	url        string
+	token      string
+	index      string
+	sourcetype string
+	source     string
+	host       string
+	client     *http.Client
+}
+
+// NewSplunkOutput validates cfg and starts sending. Failed deliveries are
+// reported to logger.
+func NewSplunkOutput(cfg SplunkConfig, logger *log.Logger) (*SplunkOutput, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("splunk: %q is not an http or https URL", cfg.URL)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/services/collector/event"
+	}
+	if cfg.Token == "" {
+		return nil, errors.New("splunk: token is required")
+	}
+	timeout := 30 * time.Second
+	if cfg.Timeout != "" {
+		if timeout, err = time.ParseDuration(cfg.Timeout); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("splunk: invalid timeout %q", cfg.Timeout)
+		}
+	}
+	o := &SplunkOutput{
+		url:        u.String(),
+		token:      cfg.Token,
+		index:      cfg.Index,
+		sourcetype: cfg.Sourcetype,
+		source:     cfg.Source,
+		host:       cfg.Host,
+		client:     &http.Client{Timeout: timeout},
+	}
+	if o.sourcetype == "" {
+		o.sourcetype = "gopot"
+	}
+	if o.source == "" {
+		o.source = "gopot"
+	}
+	if o.host == "" {
+		o.host, _ = os.Hostname()
+	}
+	if cfg.CA != "" {
+		pool, err := loadCertPool(cfg.CA)
+		if err != nil {
+			return nil, fmt.Errorf("splunk: %w", err)
+		}
+		o.client.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}, ForceAttemptHTTP2: true}
+	}
+	batch := cfg.Batch
+	switch batch.Compression {
+	case "":
+		batch.Compression = "gzip"
+	case "none":
+		batch.Compression = ""
+	case "gzip":
+	default:
+		return nil, fmt.Errorf("splunk: unsupported compression %q, HEC takes gzip or none", batch.Compression)
+	}
+	batcher, err := NewBatcher("splunk", batch, o.encode, o.post)
+	if err != nil {
+		return nil, err
+	}
+	batcher.Log = logger
+	o.Batcher = batcher
+	return o, nil
+}
+
+// encode turns a batch of events into HEC events, which the collector takes
+// one after the other.
+func (o *SplunkOutput) encode(events []Event) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, ev := range events {
+		fields := map[string]string{"event_type": ev.Type}
+		for name, value := range map[string]string{
+			"session":  ev.Session,
+			"handler":  ev.Handler,
+			"severity": ev.Severity,
+			"port":     ev.Port,
+			"src_ip":   srcIP(ev.SrcAddr),
+			"boot_id":  ev.BootID,
+		} {
+			if value != "" {
+				fields[name] = value
+			}
+		}
+		for key, value := range ev.Labels {
+			fields["label_"+key] = value
+		}
+		err := enc.Encode(splunkEvent{
+			Time:       float64(ev.Time.UnixMilli()) / 1000,
+			Host:       o.host,
+			Source:     o.source,
+			Sourcetype: o.sourcetype,
+			Index:      o.index,
+			Event:      ev,
+			Fields:     fields,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// post sends a batch to the collector, reporting the reason the collector
+// gives for refusing it.
+func (o *SplunkOutput) post(body []byte, encoding string) error {
+	req, err := http.NewRequest(http.MethodPost, o.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	req.Header.Set("Authorization", "Splunk "+o.token)
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var reply struct {
+			Text string `json:"text"`
+			Code int    `json:"code"`
+		}
+		if json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&reply) == nil && reply.Text != "" {
+			return fmt.Errorf("%s returned %s: %s (code %d)", o.url, resp.Status, strings.TrimSuffix(reply.Text, "."), reply.Code)
+		}
+		return fmt.Errorf("%s returned %s", o.url, resp.Status)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,187 @@
+package honeypot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultSpoolBytes is the spool quota when none is configured.
+const defaultSpoolBytes = 100 << 20
+
+// SpoolConfig keeps the batches an output cannot deliver on disk until its
+// destination is reachable again.
+type SpoolConfig struct {
+	Dir      string `json:"dir"`                 // directory of the spooled batches, empty to disable
+	MaxBytes int64  `json:"max_bytes,omitempty"` // quota, the oldest batches are evicted beyond it, default 100 MiB
+}
+
+// SpoolStats describes the state of a spool.
+type SpoolStats struct {
+	Files        int    `json:"files"`         // batches waiting on disk
+	Bytes        int64  `json:"bytes"`         // their size
+	Spooled      uint64 `json:"spooled"`       // batches written to the spool
+	Replayed     uint64 `json:"replayed"`      // spooled batches delivered
+	Evicted      uint64 `json:"evicted"`       // spooled batches deleted to respect the quota
+	EvictedBytes uint64 `json:"evicted_bytes"` // their size
+}
+
+// spoolFile is a batch waiting in the spool.
+type spoolFile struct {
+	name   string
+	size   int64
+	events int // events in the batch
+	raw    int // payload size before compression
+}
+
+// parseSpoolName reads the event count and raw size from a spool file name.
+func parseSpoolName(name string) (events, raw int) {
+	var stamp int64
+	var seq int
+	fmt.Sscanf(name, "%d-%d-%d-%d", &stamp, &seq, &events, &raw)
+	return events, raw
+}
+
+// spool stores undelivered payloads as files named after the time they were
+// spooled, so they are replayed, and evicted, oldest first. The names also
+// hold the number of events and the uncompressed size of the payload, and
+// compressed payloads have a suffix naming their compression, .gz for gzip.
+type spool struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	files []spoolFile // oldest first
+	bytes int64
+	seq   int
+
+	spooled, replayed, evicted, evictedBytes atomic.Uint64
+}
+
+// openSpool creates the spool directory or picks up the batches left in it.
+func openSpool(cfg SpoolConfig) (*spool, error) {
+	sp := &spool{dir: cfg.Dir, maxBytes: cfg.MaxBytes}
+	if sp.maxBytes <= 0 {
+		sp.maxBytes = defaultSpoolBytes
+	}
+	if err := os.MkdirAll(sp.dir, 0o750); err != nil {
+		return nil, fmt.Errorf("creating spool directory: %w", err)
+	}
+	entries, err := os.ReadDir(sp.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !strings.Contains(entry.Name(), ".batch") || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		events, raw := parseSpoolName(entry.Name())
+		sp.files = append(sp.files, spoolFile{name: entry.Name(), size: info.Size(), events: events, raw: raw})
+		sp.bytes += info.Size()
+	}
+	sort.Slice(sp.files, func(i, j int) bool { return sp.files[i].name < sp.files[j].name })
+	return sp, nil
+}
+
+// put stores a payload of events events and raw bytes before compression,
+// evicting the oldest batches if the quota requires. It returns the number of
+// events evicted.
+func (sp *spool) put(body []byte, encoding string, events, raw int) (int, error) {
+	size := int64(len(body))
+	if size > sp.maxBytes {
+		return 0, fmt.Errorf("batch of %d bytes exceeds the spool quota", size)
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	evicted := 0
+	for sp.bytes+size > sp.maxBytes && len(sp.files) > 0 {
+		oldest := sp.files[0]
+		os.Remove(filepath.Join(sp.dir, oldest.name))
+		sp.files = sp.files[1:]
+		sp.bytes -= oldest.size
+		sp.evicted.Add(1)
+		sp.evictedBytes.Add(uint64(oldest.size))
+		evicted += oldest.events
+	}
+	sp.seq++
+	name := fmt.Sprintf("%020d-%06d-%d-%d.batch", time.Now().UnixNano(), sp.seq%1_000_000, events, raw)
+	switch encoding {
+	case "":
+	case "gzip":
+		name += ".gz"
+	default:
+		name += "." + encoding
+	}
+	path := filepath.Join(sp.dir, name)
+	if err := os.WriteFile(path+".tmp", body, 0o640); err != nil {
+		os.Remove(path + ".tmp")
+		return evicted, err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return evicted, err
+	}
+	sp.files = append(sp.files, spoolFile{name: name, size: size, events: events, raw: raw})
+	sp.bytes += size
+	sp.spooled.Add(1)
+	return evicted, nil
+}
+
+// oldest returns the oldest spooled batch and its payload, if any.
+func (sp *spool) oldest() (f spoolFile, body []byte, encoding string, ok bool) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	for len(sp.files) > 0 {
+		f = sp.files[0]
+		body, err := os.ReadFile(filepath.Join(sp.dir, f.name))
+		if err != nil {
+			// Deleted behind our back, skip it
+			sp.files = sp.files[1:]
+			sp.bytes -= f.size
+			continue
+		}
+		if _, suffix, _ := strings.Cut(f.name, ".batch."); suffix == "gz" {
+			encoding = "gzip"
+		} else {
+			encoding = suffix
+		}
+		return f, body, encoding, true
+	}
+	return f, nil, "", false
+}
+
+// remove deletes a replayed payload.
+func (sp *spool) remove(name string) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	for i, f := range sp.files {
+		if f.name == name {
+			os.Remove(filepath.Join(sp.dir, name))
+			sp.files = append(sp.files[:i], sp.files[i+1:]...)
+			sp.bytes -= f.size
+			sp.replayed.Add(1)
+			return
+		}
+	}
+}
+
+func (sp *spool) stats() *SpoolStats {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return &SpoolStats{
+		Files:        len(sp.files),
+		Bytes:        sp.bytes,
+		Spooled:      sp.spooled.Load(),
+		Replayed:     sp.replayed.Load(),
+		Evicted:      sp.evicted.Load(),
+		EvictedBytes: sp.evictedBytes.Load(),
+	}
+}
@@ -0,0 +1,135 @@
+package honeypot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AttackStage is a coarse kill-chain classification of a session.
+// Stages are ordered: a session is tagged with the furthest stage it reached.
+type AttackStage int
+
+const (
+	StageRecon       AttackStage = iota // connected, grabbed the banner or sent a probe
+	StageBruteForce                     // attempted to log in
+	StageExploit                        // sent a payload matching an exploit signature
+	StagePostExploit                    // issued shell commands as if already on the box
+)
+
+var stageNames = [...]string{"recon", "brute-force", "exploit", "post-exploit"}
+
+func (s AttackStage) String() string { return stageNames[s] }
+
+// exploitSignatures are lowercase fragments typical of exploitation attempts,
+// with the payload family they belong to.
+var exploitSignatures = []struct{ fragment, family string }{
+	{"${jndi:", "log4shell"},
+	{"() { :; };", "shellshock"},
+	{"() { :;};", "shellshock"},
+	{"../../", "path-traversal"},
+	{"..%2f", "path-traversal"},       // encoded
+	{"ro0ab", "java-deserialization"}, // base64 of a serialization stream
+	{"aced0005", "java-deserialization"},
+	{"java.beans.xmldecoder", "java-deserialization"},
+	{"union select", "sql-injection"},
+	{"<?php", "php-injection"},
+	{"cmd.exe", "windows-command"},
+	{"powershell", "windows-command"},
+	{"/bin/sh", "command-injection"},
+	{"\x90\x90\x90\x90", "nop-sled"},
+	{"eval(base64_decode", "php-webshell"},
+}
+
+// shellCommands are commands bots run once they believe they have a shell.
+var shellCommands = map[string]bool{
+	"uname": true, "cat": true, "cd": true, "wget": true, "curl": true, "tftp": true, "ftpget": true,
+	"chmod": true, "busybox": true, "/bin/busybox": true, "echo": true, "rm": true, "ps": true,
+	"nproc": true, "id": true, "whoami": true, "ls": true, "free": true, "history": true,
+	"enable": true, "system": true, "shell": true, "sh": true,
+}
+
+// classifyData returns the stage indicated by data sent by the client.
+func classifyData(data string) AttackStage {
+	lower := strings.ToLower(data)
+	stage := StageRecon
+	if len(ExtractCredentials(data)) > 0 {
+		stage = StageBruteForce
+	}
+	for _, sig := range exploitSignatures {
+		if strings.Contains(lower, sig.fragment) {
+			stage = StageExploit
+			break
+		}
+	}
+	if looksLikeShellSession(lower) {
+		stage = StagePostExploit
+	}
+	return stage
+}
+
+// PayloadFamilies names the kinds of attack payload found in data, such as
+// "log4shell" or "sql-injection", in sorted order. Shell command sequences are
+// reported as "shell-commands" and download commands as "dropper".
+func PayloadFamilies(data string) []string {
+	lower := strings.ToLower(data)
+	found := make(map[string]bool)
+	for _, sig := range exploitSignatures {
+		if strings.Contains(lower, sig.fragment) {
+			found[sig.family] = true
+		}
+	}
+	if looksLikeShellSession(lower) {
+		found["shell-commands"] = true
+	}
+	if len(ExtractDropperURLs(data)) > 0 {
+		found["dropper"] = true
+	}
+	families := make([]string, 0, len(found))
+	for family := range found {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+	return families
+}
+
+// looksLikeShellSession reports whether at least half the lines of data start with a shell command.
+func looksLikeShellSession(lower string) bool {
+	var lines, commands int
+	for _, line := range strings.Split(lower, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		lines++
+		if shellCommands[strings.TrimLeft(fields[0], ";&|")] {
+			commands++
+		}
+	}
+	return lines > 0 && commands*2 >= lines
+}
+
+// Observe records data sent by the client, advancing the session's stage.
+func (m *ConnMeta) Observe(data string) {
+	if stage := classifyData(data); stage > m.Stage {
+		m.Stage = stage
+	}
+}
+
+// recordStage adds a finished session to the per-stage counters.
+func (s *Server) recordStage(stage AttackStage) {
+	s.connMu.Lock()
+	s.stages[stage]++
+	s.connMu.Unlock()
+}
+
+// StageSummary formats the per-stage session counters, e.g. "recon=12 brute-force=3 ...".
+func (s *Server) StageSummary() string {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	parts := make([]string, len(stageNames))
+	for i, name := range stageNames {
+		parts[i] = fmt.Sprintf("%s=%d", name, s.stages[i])
+	}
+	return strings.Join(parts, " ")
+}
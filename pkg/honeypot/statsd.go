@@ -0,0 +1,225 @@
+package honeypot
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatsDFormats are the ways a StatsDOutput sends tags: DogStatsD tags
+// after the value, InfluxDB-style tags after the metric name, as the statsd
+// input of Telegraf reads them, or none for plain StatsD.
+var StatsDFormats = []string{"dogstatsd", "influx", "none"}
+
+// Packets of a StatsDOutput: the payload that fits an Ethernet frame with
+// room for the headers, and how long metrics wait for their packet to fill.
+const (
+	statsdMaxPacket = 1432
+	statsdFlush     = time.Second
+)
+
+// StatsDConfig sends counters and timings to a StatsD agent, see
+// StatsDOutput.
+type StatsDConfig struct {
+	Addr   string            `json:"addr"`             // UDP host:port of the agent, e.g. 127.0.0.1:8125, or unix:// and the path of a DogStatsD socket; empty to disable
+	Prefix string            `json:"prefix,omitempty"` // prepended to the metric names, default "gopot."
+	Format string            `json:"format,omitempty"` // how tags are sent, one of StatsDFormats, default dogstatsd
+	Tags   map[string]string `json:"tags,omitempty"`   // added to every metric, besides the labels of its event
+}
+
+// StatsDOutput is an Output sending metrics to a StatsD, DogStatsD or
+// Telegraf agent, for shops that run Datadog or Telegraf rather than
+// scraping: the counters connections (by port and transport), events (by
+// type), sessions (by handler and attack stage), bytes.received and
+// bytes.sent (by handler), and the timing session.duration in
+// milliseconds (by handler). Every metric is also tagged with the labels of
+// its event, such as node_id, and the configured tags.
+//
+// Metrics are sent over UDP, or a Unix datagram socket, several to a
+// packet at least every second; an agent that is down loses them without
+// slowing the server.
+type StatsDOutput struct {
+	prefix string
+	format string
+	tags   map[string]string
+	conn   net.Conn
+	log    *log.Logger
+
+	mu      sync.Mutex
+	buf     []byte
+	failing bool // the last packet could not be sent
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewStatsDOutput validates cfg and connects to the agent. Failed sends are
+// reported to logger.
+func NewStatsDOutput(cfg StatsDConfig, logger *log.Logger) (*StatsDOutput, error) {
+	o := &StatsDOutput{prefix: cfg.Prefix, format: cfg.Format, tags: cfg.Tags, log: logger, stop: make(chan struct{}), done: make(chan struct{})}
+	if o.prefix == "" {
+		o.prefix = "gopot."
+	}
+	if strings.ContainsAny(o.prefix, ":|@#,= \n") {
+		return nil, fmt.Errorf("statsd: invalid prefix %q", o.prefix)
+	}
+	if o.format == "" {
+		o.format = "dogstatsd"
+	}
+	if !slices.Contains(StatsDFormats, o.format) {
+		return nil, fmt.Errorf("statsd: unknown format %q, want %s", o.format, strings.Join(StatsDFormats, ", "))
+	}
+	var err error
+	if path, ok := strings.CutPrefix(cfg.Addr, "unix://"); ok {
+		o.conn, err = net.Dial("unixgram", path)
+	} else {
+		o.conn, err = net.Dial("udp", cfg.Addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %w", err)
+	}
+	go o.run()
+	return o, nil
+}
+
+// Write implements Output.
+func (o *StatsDOutput) Write(ev Event) error {
+	handler, _ := ev.Fields["handler"].(string)
+	o.send(ev, "events", "1|c", "type", ev.Type)
+	switch ev.Type {
+	case EventConnection:
+		transport, _ := ev.Fields["transport"].(string)
+		if transport == "" {
+			transport = "tcp"
+		}
+		o.send(ev, "connections", "1|c", "port", ev.Port, "transport", transport)
+	case EventSessionEnd:
+		stage, _ := ev.Fields["stage"].(string)
+		o.send(ev, "sessions", "1|c", "handler", handler, "stage", stage)
+		if received, ok := fieldNumber(ev.Fields, "received_bytes"); ok {
+			o.send(ev, "bytes.received", strconv.FormatInt(int64(received), 10)+"|c", "handler", handler)
+		}
+		if sent, ok := fieldNumber(ev.Fields, "sent_bytes"); ok {
+			o.send(ev, "bytes.sent", strconv.FormatInt(int64(sent), 10)+"|c", "handler", handler)
+		}
+		if duration, ok := fieldNumber(ev.Fields, "duration"); ok {
+			o.send(ev, "session.duration", strconv.FormatInt(int64(duration*1000), 10)+"|ms", "handler", handler)
+		}
+	}
+	return nil
+}
+
+// send queues the metric name of ev with value, a StatsD value and type such
+// as "1|c", tagged with the pairs of tags, the labels of ev and the
+// configured tags.
+func (o *StatsDOutput) send(ev Event, name, value string, tags ...string) {
+	all := make(map[string]string, len(o.tags)+len(ev.Labels)+len(tags)/2)
+	for key, v := range o.tags {
+		all[key] = v
+	}
+	for key, v := range ev.Labels {
+		all[key] = v
+	}
+	for i := 0; i+1 < len(tags); i += 2 {
+		if tags[i+1] != "" {
+			all[tags[i]] = tags[i+1]
+		}
+	}
+	keys := make([]string, 0, len(all))
+	for key := range all {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var line strings.Builder
+	line.WriteString(o.prefix + name)
+	switch o.format {
+	case "dogstatsd":
+		line.WriteString(":" + value)
+		for i, key := range keys {
+			if i == 0 {
+				line.WriteString("|#")
+			} else {
+				line.WriteByte(',')
+			}
+			line.WriteString(statsdTag(key, "|,:#\n") + ":" + statsdTag(all[key], "|,#\n"))
+		}
+	case "influx":
+		for _, key := range keys {
+			line.WriteString("," + statsdTag(key, ",= :|\n") + "=" + statsdTag(all[key], ",= :|\n"))
+		}
+		line.WriteString(":" + value)
+	default:
+		line.WriteString(":" + value)
+	}
+	o.queue(line.String())
+}
+
+// statsdTag replaces the characters of s that have a meaning in the
+// format with underscores.
+func statsdTag(s, special string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(special, r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// queue adds a metric line to the packet being filled, sending the packet
+// first when the line does not fit.
+func (o *StatsDOutput) queue(line string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.buf) > 0 && len(o.buf)+1+len(line) > statsdMaxPacket {
+		o.flush()
+	}
+	if len(o.buf) > 0 {
+		o.buf = append(o.buf, '\n')
+	}
+	o.buf = append(o.buf, line...)
+}
+
+// flush sends the packet being filled. The caller holds o.mu.
+func (o *StatsDOutput) flush() {
+	if len(o.buf) == 0 {
+		return
+	}
+	_, err := o.conn.Write(o.buf)
+	o.buf = o.buf[:0]
+	if err != nil && !o.failing && o.log != nil {
+		o.log.Printf("Output statsd: unable to send metrics: %s", err)
+	}
+	o.failing = err != nil
+}
+
+func (o *StatsDOutput) run() {
+	defer close(o.done)
+	ticker := time.NewTicker(statsdFlush)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-o.stop:
+			return
+		}
+		o.mu.Lock()
+		o.flush()
+		o.mu.Unlock()
+	}
+}
+
+// Close sends the metrics still waiting and disconnects from the agent.
+func (o *StatsDOutput) Close() error {
+	close(o.stop)
+	<-o.done
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.flush()
+	return o.conn.Close()
+}
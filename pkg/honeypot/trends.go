@@ -0,0 +1,340 @@
+package honeypot
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits of the weekly trend report.
+const (
+	trendTopN          = 10      // attackers and ports listed
+	trendMaxSources    = 200_000 // distinct sources tracked per week, later ones are only counted
+	trendCheckInterval = time.Hour
+)
+
+// WeekSummary is the activity of one ISO week, kept to compare weeks.
+type WeekSummary struct {
+	Week        string         `json:"week"` // ISO week, e.g. "2024-W18"
+	Connections int            `json:"connections"`
+	Sources     map[string]int `json:"sources"`  // connections by source address
+	Ports       map[string]int `json:"ports"`    // connections by port
+	Families    map[string]int `json:"families"` // data chunks by payload family, see PayloadFamilies
+}
+
+// TrendEntry is one line of a trend report.
+type TrendEntry struct {
+	Key      string `json:"key"`
+	Count    int    `json:"count"`
+	Previous int    `json:"previous"` // count in the previous week
+	New      bool   `json:"new"`      // not seen in the previous week
+}
+
+// TrendReport compares a finished week with the week before.
+type TrendReport struct {
+	Week                string       `json:"week"`
+	Previous            string       `json:"previous"`
+	Connections         int          `json:"connections"`
+	PreviousConnections int          `json:"previous_connections"`
+	TopAttackers        []TrendEntry `json:"top_attackers"`
+	TopPorts            []TrendEntry `json:"top_ports"`
+	NewPorts            []TrendEntry `json:"new_ports"`    // ports targeted this week but not the week before
+	NewFamilies         []TrendEntry `json:"new_families"` // payload families seen this week but not the week before
+	Families            []TrendEntry `json:"families"`
+}
+
+// Summary is a one-line description of the report, used for notifications.
+func (r TrendReport) Summary() string {
+	newAttackers := 0
+	for _, e := range r.TopAttackers {
+		if e.New {
+			newAttackers++
+		}
+	}
+	return fmt.Sprintf("week %s: %d connections (previous week %d), %d new top attackers, %d newly targeted ports, %d new payload families",
+		r.Week, r.Connections, r.PreviousConnections, newAttackers, len(r.NewPorts), len(r.NewFamilies))
+}
+
+// TrendTracker is an Output that summarises each ISO week and, when a week
+// is over, writes a report diffing it against the week before as Markdown and
+// HTML to its directory. Week summaries are kept in weeks/ so the comparison
+// survives restarts.
+type TrendTracker struct {
+	// Notify, if set, is called with every finished report and the paths of
+	// its Markdown and HTML files, e.g. to push it to the alert channels.
+	Notify func(report TrendReport, markdown, html string)
+
+	dir  string
+	log  *log.Logger
+	mu   sync.Mutex
+	week *WeekSummary
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewTrendTracker resumes the summary of the current week stored in dir, if any.
+func NewTrendTracker(dir string, logger *log.Logger) (*TrendTracker, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "weeks"), 0o750); err != nil {
+		return nil, err
+	}
+	t := &TrendTracker{dir: dir, log: logger, stop: make(chan struct{})}
+	week, err := t.loadWeek(isoWeek(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	t.week = week
+	go t.run()
+	return t, nil
+}
+
+func isoWeek(t time.Time) string {
+	year, week := t.UTC().ISOWeek()
+	return fmt.Sprintf("%04d-W%02d", year, week)
+}
+
+func newWeekSummary(week string) *WeekSummary {
+	return &WeekSummary{Week: week, Sources: map[string]int{}, Ports: map[string]int{}, Families: map[string]int{}}
+}
+
+// loadWeek reads a stored week summary, returning an empty one when there is none.
+func (t *TrendTracker) loadWeek(week string) (*WeekSummary, error) {
+	content, err := os.ReadFile(filepath.Join(t.dir, "weeks", week+".json"))
+	if os.IsNotExist(err) {
+		return newWeekSummary(week), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	summary := newWeekSummary(week)
+	if err := json.Unmarshal(content, summary); err != nil {
+		return nil, fmt.Errorf("reading week %s: %w", week, err)
+	}
+	return summary, nil
+}
+
+// saveWeek stores the current week's summary. Callers must hold t.mu.
+func (t *TrendTracker) saveWeek() error {
+	content, err := json.Marshal(t.week)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(t.dir, "weeks", t.week.Week+".json")
+	if err := os.WriteFile(path+".tmp", content, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Write implements Output.
+func (t *TrendTracker) Write(ev Event) error {
+	if ev.Type != EventConnection && ev.Type != EventData {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover(ev.Time)
+	switch ev.Type {
+	case EventConnection:
+		t.week.Connections++
+		t.week.Ports[ev.Port]++
+		host, _, err := net.SplitHostPort(ev.SrcAddr)
+		if err != nil {
+			host = ev.SrcAddr
+		}
+		if _, ok := t.week.Sources[host]; ok || len(t.week.Sources) < trendMaxSources {
+			t.week.Sources[host]++
+		}
+	case EventData:
+		for _, family := range PayloadFamilies(string(EventPayload(ev))) {
+			t.week.Families[family]++
+		}
+	}
+	return nil
+}
+
+// rollover finishes the current week if now is in a later one. Callers must hold t.mu.
+func (t *TrendTracker) rollover(now time.Time) {
+	week := isoWeek(now)
+	if week <= t.week.Week {
+		return
+	}
+	if err := t.saveWeek(); err != nil {
+		t.log.Printf("Error storing week %s: %s", t.week.Week, err)
+	}
+	finished := t.week
+	t.week = newWeekSummary(week)
+
+	previous, err := t.loadWeek(isoWeek(weekStart(finished.Week).AddDate(0, 0, -7)))
+	if err != nil {
+		t.log.Printf("Error loading previous week: %s", err)
+		previous = newWeekSummary("")
+	}
+	report := CompareWeeks(finished, previous)
+	markdown, html, err := t.writeReport(report)
+	if err != nil {
+		t.log.Printf("Error writing trend report for %s: %s", report.Week, err)
+		return
+	}
+	if t.Notify != nil {
+		go t.Notify(report, markdown, html)
+	}
+}
+
+// weekStart returns the Monday of an ISO week such as "2024-W18".
+func weekStart(week string) time.Time {
+	var year, n int
+	fmt.Sscanf(week, "%d-W%d", &year, &n)
+	// January 4th is always in week 1
+	jan4 := time.Date(year, 1, 4, 0, 0, 0, 0, time.UTC)
+	monday := jan4.AddDate(0, 0, -((int(jan4.Weekday()) + 6) % 7))
+	return monday.AddDate(0, 0, 7*(n-1))
+}
+
+// run saves the current week and checks for its end every hour, so reports
+// are written even when no traffic arrives.
+func (t *TrendTracker) run() {
+	ticker := time.NewTicker(trendCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case now := <-ticker.C:
+			t.mu.Lock()
+			t.rollover(now)
+			if err := t.saveWeek(); err != nil {
+				t.log.Printf("Error storing week %s: %s", t.week.Week, err)
+			}
+			t.mu.Unlock()
+		}
+	}
+}
+
+// Close stores the summary of the current week.
+func (t *TrendTracker) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.stop)
+		t.mu.Lock()
+		err = t.saveWeek()
+		t.mu.Unlock()
+	})
+	return err
+}
+
+// CompareWeeks builds the trend report of week against previous.
+func CompareWeeks(week, previous *WeekSummary) TrendReport {
+	report := TrendReport{
+		Week:                week.Week,
+		Previous:            previous.Week,
+		Connections:         week.Connections,
+		PreviousConnections: previous.Connections,
+		TopAttackers:        trendEntries(week.Sources, previous.Sources, trendTopN, false),
+		TopPorts:            trendEntries(week.Ports, previous.Ports, trendTopN, false),
+		NewPorts:            trendEntries(week.Ports, previous.Ports, 0, true),
+		Families:            trendEntries(week.Families, previous.Families, 0, false),
+		NewFamilies:         trendEntries(week.Families, previous.Families, 0, true),
+	}
+	return report
+}
+
+// trendEntries lists counts by descending count, at most n of them (0 for
+// all), optionally only those absent from previous.
+func trendEntries(counts, previous map[string]int, n int, onlyNew bool) []TrendEntry {
+	var entries []TrendEntry
+	for key, count := range counts {
+		_, seen := previous[key]
+		if onlyNew && seen {
+			continue
+		}
+		entries = append(entries, TrendEntry{Key: key, Count: count, Previous: previous[key], New: !seen})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// writeReport writes the Markdown and HTML forms of report and returns their paths.
+func (t *TrendTracker) writeReport(report TrendReport) (string, string, error) {
+	base := filepath.Join(t.dir, "trend-"+report.Week)
+	if err := os.WriteFile(base+".md", []byte(report.Markdown()), 0o640); err != nil {
+		return "", "", err
+	}
+	var html strings.Builder
+	if err := trendHTML.Execute(&html, report); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(base+".html", []byte(html.String()), 0o640); err != nil {
+		return "", "", err
+	}
+	return base + ".md", base + ".html", nil
+}
+
+// Markdown renders the report as a Markdown document.
+func (r TrendReport) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# GoPot weekly report %s\n\n", r.Week)
+	fmt.Fprintf(&b, "%d connections, previous week (%s) %d.\n", r.Connections, r.Previous, r.PreviousConnections)
+	section := func(title, column string, entries []TrendEntry) {
+		fmt.Fprintf(&b, "\n## %s\n\n", title)
+		if len(entries) == 0 {
+			b.WriteString("None.\n")
+			return
+		}
+		fmt.Fprintf(&b, "| %s | This week | Previous week |\n|---|---|---|\n", column)
+		for _, e := range entries {
+			key := e.Key
+			if e.New {
+				key += " **(new)**"
+			}
+			fmt.Fprintf(&b, "| %s | %d | %d |\n", key, e.Count, e.Previous)
+		}
+	}
+	section("Top attackers", "Source", r.TopAttackers)
+	section("Top ports", "Port", r.TopPorts)
+	section("Newly targeted ports", "Port", r.NewPorts)
+	section("New payload families", "Family", r.NewFamilies)
+	section("Payload families", "Family", r.Families)
+	return b.String()
+}
+
+var trendHTML = template.Must(template.New("trend").Funcs(template.FuncMap{
+	"section": func(column string, entries []TrendEntry) any {
+		return struct {
+			Column  string
+			Entries []TrendEntry
+		}{column, entries}
+	},
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>GoPot weekly report {{.Week}}</title>
+<style>body{font-family:sans-serif}table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:2px 8px}.new{color:#b00;font-weight:bold}</style>
+</head><body>
+<h1>GoPot weekly report {{.Week}}</h1>
+<p>{{.Connections}} connections, previous week ({{.Previous}}) {{.PreviousConnections}}.</p>
+{{define "section"}}<table><tr><th>{{.Column}}</th><th>This week</th><th>Previous week</th></tr>
+{{range .Entries}}<tr><td>{{.Key}}{{if .New}} <span class="new">new</span>{{end}}</td><td>{{.Count}}</td><td>{{.Previous}}</td></tr>
+{{else}}<tr><td colspan="3">None</td></tr>
+{{end}}</table>{{end}}
+<h2>Top attackers</h2>{{template "section" (section "Source" .TopAttackers)}}
+<h2>Top ports</h2>{{template "section" (section "Port" .TopPorts)}}
+<h2>Newly targeted ports</h2>{{template "section" (section "Port" .NewPorts)}}
+<h2>New payload families</h2>{{template "section" (section "Family" .NewFamilies)}}
+<h2>Payload families</h2>{{template "section" (section "Family" .Families)}}
+</body></html>
+`))
@@ -0,0 +1,152 @@
+package honeypot
+
+import (
+	"fmt"
+	neturl "net/url"
+	"regexp"
+	"strings"
+)
+
+// urlPattern matches the URL schemes malware is usually fetched from.
+var urlPattern = regexp.MustCompile(`(?i)\b(?:https?|ftp|tftp|ldap|rmi)://[^\s'"<>;|&()\x60]+`)
+
+// downloaders are lowercase fragments of commands that fetch a payload.
+var downloaders = []string{
+	"wget", "curl", "tftp", "ftpget", "fetch ", "lwp-download", "powershell", "certutil",
+	"bitsadmin", "invoke-webrequest", "downloadstring", "downloadfile", "jndi:",
+}
+
+// ExtractDropperURLs returns the URLs in data that appear on a line running a
+// download command (wget, curl, tftp, PowerShell, a JNDI lookup, ...), in order
+// of appearance and without duplicates. Plain links, e.g. in a Referer header,
+// are ignored. The tftp and ftpget commands of Mirai droppers, which take a
+// host and a file name rather than a URL, are turned into tftp:// and ftp://
+// URLs.
+func ExtractDropperURLs(data string) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(data, "\n") {
+		lower := strings.ToLower(line)
+		downloads := false
+		for _, d := range downloaders {
+			if strings.Contains(lower, d) {
+				downloads = true
+				break
+			}
+		}
+		if !downloads {
+			continue
+		}
+		found := urlPattern.FindAllString(line, -1)
+		for _, command := range commandSeparators.Split(line, -1) {
+			if url := commandURL(strings.Fields(command)); url != "" {
+				found = append(found, url)
+			}
+		}
+		for _, url := range found {
+			url = strings.TrimRight(url, ".,}]")
+			if !seen[url] {
+				seen[url] = true
+				urls = append(urls, url)
+			}
+		}
+	}
+	return urls
+}
+
+// commandSeparators split a shell line into commands.
+var commandSeparators = regexp.MustCompile(`;|&&|\|\||\||\n|\x60|\$\(`)
+
+// commandURL returns the URL a tftp or ftpget command fetches, or "":
+//
+//	tftp -g -r bins.sh [-l local] 203.0.113.9 [69]   (BusyBox)
+//	tftp 203.0.113.9 -c get bins.sh                   (tftp-hpa)
+//	ftpget [-v] [-u user] [-p pass] [-P port] 203.0.113.9 local bins.sh
+func commandURL(args []string) string {
+	name := func(arg string) string { return arg[strings.LastIndex(arg, "/")+1:] }
+	for len(args) > 0 && (name(args[0]) == "busybox" || name(args[0]) == "sudo") {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return ""
+	}
+	switch name(args[0]) {
+	case "tftp":
+		var remote, host, port string
+		var operands []string
+		for i := 1; i < len(args); i++ {
+			switch arg := args[i]; {
+			case arg == "-r" && i+1 < len(args):
+				i++
+				remote = args[i]
+			case (arg == "-l" || arg == "-b") && i+1 < len(args):
+				i++
+			case arg == "-c" && i+2 < len(args) && args[i+1] == "get":
+				remote = args[i+2]
+				i += 2
+			case strings.HasPrefix(arg, "-"):
+			default:
+				operands = append(operands, arg)
+			}
+		}
+		if len(operands) > 0 {
+			host = operands[0]
+		}
+		if len(operands) > 1 {
+			port = operands[1]
+		}
+		if remote == "" || host == "" {
+			return ""
+		}
+		if port != "" && port != "69" {
+			host += ":" + port
+		}
+		return "tftp://" + host + "/" + strings.TrimPrefix(remote, "/")
+	case "ftpget":
+		var operands []string
+		port := ""
+		for i := 1; i < len(args); i++ {
+			switch arg := args[i]; {
+			case (arg == "-u" || arg == "-p") && i+1 < len(args):
+				i++
+			case arg == "-P" && i+1 < len(args):
+				i++
+				port = args[i]
+			case strings.HasPrefix(arg, "-"):
+			default:
+				operands = append(operands, arg)
+			}
+		}
+		if len(operands) < 3 {
+			return ""
+		}
+		host := operands[0]
+		if port != "" && port != "21" {
+			host += ":" + port
+		}
+		return "ftp://" + host + "/" + strings.TrimPrefix(operands[2], "/")
+	}
+	return ""
+}
+
+// logDropperURLs raises a dropper_url alert for every new payload URL in data
+// and starts a capture of the attacker's follow-up traffic if configured.
+func (m *ConnMeta) logDropperURLs(data string) {
+	for _, url := range ExtractDropperURLs(data) {
+		if m.droppers[url] {
+			continue
+		}
+		if m.droppers == nil {
+			m.droppers = make(map[string]bool)
+		}
+		m.droppers[url] = true
+		ev := newAlertEvent("medium", "dropper_url", fmt.Sprintf("payload URL %s sent on port %s from %s", url, m.Port, m.ClientAddr))
+		ev.Fields["url"] = url
+		ev.Fields["ioc"] = "url"
+		if u, err := neturl.Parse(url); err == nil && u.Host != "" {
+			ev.Fields["url_host"] = u.Hostname()
+		}
+		m.Emit(ev)
+		m.server.startCapture(m, url)
+	}
+}
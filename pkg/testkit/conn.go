@@ -0,0 +1,85 @@
+// Package testkit exercises the protocol handlers of the honeypot package
+// without a network: fake connections, recorders of the Transcript a client
+// and a persona exchange, and golden files transcripts are compared with.
+// The fixtures in testdata replay a typical client against each shipped
+// persona; TestFixtures runs them all with go test.
+package testkit
+
+import (
+	"net"
+	"sync"
+)
+
+// Addresses of the fake connections: the client is in TEST-NET-1 and the
+// honeypot in TEST-NET-2, so neither can be mistaken for a real host.
+var (
+	ClientIP = net.IPv4(192, 0, 2, 10)
+	ServerIP = net.IPv4(198, 51, 100, 1)
+)
+
+// ClientPort is the source port of the fake connections.
+const ClientPort = 40000
+
+// pipeConn is one end of a net.Pipe with the addresses of a TCP connection.
+type pipeConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c pipeConn) LocalAddr() net.Addr  { return c.local }
+func (c pipeConn) RemoteAddr() net.Addr { return c.remote }
+
+// Pipe returns the two ends of a synchronous in-memory connection, like
+// net.Pipe, that report the addresses of a TCP connection from client to
+// server. Deadlines are supported, as handlers rely on them.
+func Pipe(client, server net.Addr) (serverEnd, clientEnd net.Conn) {
+	s, c := net.Pipe()
+	return pipeConn{Conn: s, local: server, remote: client}, pipeConn{Conn: c, local: client, remote: server}
+}
+
+// Listener is a net.Listener accepting the connections of Dial, to serve
+// them with honeypot.Server.NewListener.
+type Listener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewListener returns a listener on the fake address addr.
+func NewListener(addr net.Addr) *Listener {
+	return &Listener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+// Dial connects a client from addr and returns its end of the connection
+// once the listener has accepted it.
+func (l *Listener) Dial(from net.Addr) (net.Conn, error) {
+	server, client := Pipe(from, l.addr)
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Accept implements net.Listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener.
+func (l *Listener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr implements net.Listener.
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,320 @@
+package testkit
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackyes/GoPot/pkg/honeypot"
+)
+
+// DefaultQuiet is how long the server of a fixture may stay silent before
+// its answer is considered complete.
+const DefaultQuiet = 200 * time.Millisecond
+
+// DefaultSeed seeds the randomness of the personas of fixtures, so cookies,
+// tokens and challenges need no mask.
+const DefaultSeed = "testkit"
+
+// maxWait bounds the wait for the end of one answer of the server, for
+// personas that never stop talking.
+const maxWait = 10 * time.Second
+
+// Fixture is a client session replayed against a persona. Its file, with
+// the .fixture extension, has one directive per line and the data the
+// client sends, in the text form of Transcript with only > steps:
+//
+//	# Comments start with #
+//	handler pop3
+//	port 110
+//	mask ^\+OK POP3 ready <([^>]*)>
+//	> "USER root\r\n"
+//	> "PASS toor\r\n"
+//
+// Directives are handler (required), port (default 0), udp (the session is
+// one of datagrams), profile (host identity, default
+// honeypot.DefaultProfile), seed (of the randomness of the persona, default
+// DefaultSeed, see honeypot.HostIdentity.Seed), quiet (a duration, default
+// DefaultQuiet) and mask (a regular expression, see Transcript.Mask). The
+// expected transcript is kept next to the fixture, with the .golden
+// extension.
+type Fixture struct {
+	Path    string
+	Name    string // file name without the extension
+	Handler string
+	Port    string
+	UDP     bool
+	Profile string
+	Seed    string
+	Quiet   time.Duration
+	Masks   []*regexp.Regexp
+	Client  Transcript // what the client sends, each step once the server is quiet
+}
+
+// LoadFixture reads a fixture file.
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &Fixture{
+		Path:    path,
+		Name:    strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		Port:    "0",
+		Profile: honeypot.DefaultProfile,
+		Seed:    DefaultSeed,
+		Quiet:   DefaultQuiet,
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		if err := f.parseLine(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+	}
+	if f.Handler == "" {
+		return nil, fmt.Errorf("%s: no handler directive", path)
+	}
+	return f, nil
+}
+
+func (f *Fixture) parseLine(line string) error {
+	if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	if strings.HasPrefix(line, "> ") || strings.HasPrefix(line, "  ") {
+		return f.Client.parseLine(line)
+	}
+	directive, value, _ := strings.Cut(line, " ")
+	value = strings.TrimSpace(value)
+	switch directive {
+	case "handler":
+		f.Handler = value
+	case "port":
+		if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+			return fmt.Errorf("invalid port %q", value)
+		}
+		f.Port = value
+	case "udp":
+		f.UDP = true
+	case "profile":
+		f.Profile = value
+	case "seed":
+		f.Seed = value
+	case "quiet":
+		quiet, err := time.ParseDuration(value)
+		if err != nil || quiet <= 0 {
+			return fmt.Errorf("invalid quiet duration %q", value)
+		}
+		f.Quiet = quiet
+	case "mask":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return fmt.Errorf("invalid mask: %w", err)
+		}
+		f.Masks = append(f.Masks, re)
+	default:
+		return fmt.Errorf("unknown directive %q", directive)
+	}
+	return nil
+}
+
+// GoldenPath returns the file the expected transcript of f is kept in.
+func (f *Fixture) GoldenPath() string {
+	return strings.TrimSuffix(f.Path, filepath.Ext(f.Path)) + ".golden"
+}
+
+// LoadFixtures reads the fixtures of dir, sorted by name.
+func LoadFixtures(dir string) ([]*Fixture, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.fixture"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	fixtures := make([]*Fixture, 0, len(paths))
+	for _, path := range paths {
+		f, err := LoadFixture(path)
+		if err != nil {
+			return nil, err
+		}
+		fixtures = append(fixtures, f)
+	}
+	return fixtures, nil
+}
+
+// Result is what the replay of a fixture produced.
+type Result struct {
+	Transcript Transcript       // masked with the fixture's masks
+	Events     []honeypot.Event // emitted by the server during the session
+}
+
+// eventLog is an Output collecting events.
+type eventLog struct {
+	mu     sync.Mutex
+	events []honeypot.Event
+}
+
+func (l *eventLog) Write(ev honeypot.Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, ev)
+	return nil
+}
+
+// Run replays f against a fresh Server serving f.Handler: it connects,
+// plays the client steps with Exchange and shuts the server down. TCP
+// sessions go through a Pipe; datagrams through a UDP socket on the loopback
+// interface.
+func Run(f *Fixture) (*Result, error) {
+	srv := honeypot.NewServer(1)
+	identity, err := honeypot.NewHostIdentity(f.Profile, "")
+	if err != nil {
+		return nil, err
+	}
+	identity.Seed = f.Seed
+	srv.Identity, srv.Files = identity, identity.DecoyFS()
+	srv.Ports[f.Port] = &honeypot.PortOptions{Handler: f.Handler}
+	events := &eventLog{}
+	srv.AddOutput(events)
+	defer srv.Shutdown()
+
+	var conn net.Conn
+	if f.UDP {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		l, err := srv.NewPacketListener(pc, f.Port)
+		if err != nil {
+			pc.Close()
+			return nil, err
+		}
+		go l.Serve()
+		if conn, err = net.Dial("udp", pc.LocalAddr().String()); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, ok := honeypot.LookupHandler(f.Handler); !ok {
+			return nil, fmt.Errorf("no TCP handler named %q", f.Handler)
+		}
+		port, _ := strconv.Atoi(f.Port)
+		ln := NewListener(&net.TCPAddr{IP: ServerIP, Port: port})
+		go srv.NewListener(ln, f.Port).Serve()
+		if conn, err = ln.Dial(&net.TCPAddr{IP: ClientIP, Port: ClientPort}); err != nil {
+			return nil, err
+		}
+	}
+	transcript := Exchange(conn, f.Client, f.Quiet, f.UDP)
+	srv.Shutdown()
+
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	return &Result{Transcript: transcript.Mask(f.Masks), Events: events.events}, nil
+}
+
+// Exchange plays the client side of a session over conn: it waits for the
+// server to be quiet for quiet, sends each step of client and waits again,
+// then hangs up and returns the transcript of the session. With datagrams,
+// each step is a datagram and so is each read.
+func Exchange(conn net.Conn, client Transcript, quiet time.Duration, datagrams bool) Transcript {
+	rec := Record(conn)
+	rec.Datagrams = datagrams
+	defer rec.Close()
+
+	// Read everything the server sends, signalling each chunk
+	activity := make(chan struct{}, 1)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		buffer := make([]byte, 65535)
+		for {
+			if _, err := rec.Read(buffer); err != nil {
+				return
+			}
+			select {
+			case activity <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	wait := func() {
+		deadline := time.After(maxWait)
+		for {
+			select {
+			case <-activity:
+			case <-closed:
+				return
+			case <-deadline:
+				return
+			case <-time.After(quiet):
+				return
+			}
+		}
+	}
+
+	wait()
+	for _, step := range client {
+		rec.SetWriteDeadline(time.Now().Add(maxWait))
+		if _, err := rec.Write(step.Data); err != nil {
+			break // the server hung up or stopped reading
+		}
+		wait()
+	}
+	rec.Close()
+	<-closed
+	return rec.Transcript()
+}
+
+// CheckGolden compares got with the transcript in the golden file path and
+// returns an error describing the difference. When update is set, got is
+// written to path instead.
+func CheckGolden(path string, got Transcript, update bool) error {
+	if update {
+		return os.WriteFile(path, []byte(got.String()), 0o644)
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s does not exist, create it with the update option", path)
+	}
+	if err != nil {
+		return err
+	}
+	want, err := ParseTranscript(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if diff := Diff(want, got); diff != "" {
+		return fmt.Errorf("%s: %s", path, diff)
+	}
+	return nil
+}
+
+// TB is the part of testing.TB Golden needs.
+type TB interface {
+	Helper()
+	Errorf(format string, args ...any)
+}
+
+// Golden is CheckGolden for tests, reporting a difference as a test error.
+func Golden(tb TB, path string, got Transcript, update bool) {
+	tb.Helper()
+	if err := CheckGolden(path, got, update); err != nil {
+		tb.Errorf("%s", err)
+	}
+}
+
+// Check replays f and compares the result with its golden file, see
+// CheckGolden.
+func Check(f *Fixture, update bool) error {
+	result, err := Run(f)
+	if err != nil {
+		return err
+	}
+	return CheckGolden(f.GoldenPath(), result.Transcript, update)
+}
@@ -0,0 +1,26 @@
+package testkit
+
+import (
+	"flag"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the transcripts obtained")
+
+// TestFixtures replays every persona fixture of testdata and compares the
+// transcript with its golden file, or rewrites the golden file with -update.
+func TestFixtures(t *testing.T) {
+	fixtures, err := LoadFixtures("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fixtures {
+		t.Run(f.Name, func(t *testing.T) {
+			result, err := Run(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			Golden(t, f.GoldenPath(), result.Transcript, *update)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package testkit
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackyes/GoPot/pkg/honeypot"
+)
+
+// SessionFixture returns a fixture replaying a session recorded by a
+// sensor, from its events in order: the client steps are the payloads of
+// its data events, see honeypot.EventPayload, and the handler, port and
+// transport those of its events. Handlers that log raw client data, like
+// banner, modbus or smb, are replayed exactly; others, like the HTTP
+// personas that log request bodies, only in part. The fixture is named
+// after the session and impersonates profile.
+func SessionFixture(events []honeypot.Event, profile string) (*Fixture, error) {
+	if len(events) == 0 {
+		return nil, errors.New("no events")
+	}
+	f := &Fixture{
+		Name:    events[0].Session,
+		Port:    events[0].Port,
+		Profile: profile,
+		Seed:    DefaultSeed,
+		Quiet:   DefaultQuiet,
+	}
+	for _, ev := range events {
+		if ev.Session != f.Name {
+			return nil, fmt.Errorf("events of sessions %s and %s", f.Name, ev.Session)
+		}
+		if f.Handler == "" {
+			f.Handler = ev.Handler
+		}
+		if transport, _ := ev.Fields["transport"].(string); transport == "udp" {
+			f.UDP = true
+		}
+		if ev.Type == honeypot.EventData {
+			if payload := honeypot.EventPayload(ev); len(payload) > 0 {
+				f.Client = append(f.Client, Step{From: FromClient, Data: payload})
+			}
+		}
+	}
+	if f.Handler == "" {
+		return nil, fmt.Errorf("session %s: no event names its handler", f.Name)
+	}
+	if f.Port == "" {
+		f.Port = "0"
+	}
+	return f, nil
+}
@@ -0,0 +1,247 @@
+package testkit
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Direction tells who sent the data of a Step.
+type Direction byte
+
+const (
+	FromClient Direction = '>'
+	FromServer Direction = '<'
+)
+
+// Step is the data sent at once by one side of a connection: the bytes
+// written between two reads of the other side, or one datagram.
+type Step struct {
+	From Direction
+	Data []byte
+}
+
+// Transcript is what a client and a persona exchanged, in order. Its text
+// form, used by golden files, has a line per step starting with the
+// direction and the data quoted as a Go string, split after newlines and
+// every stepLineBytes bytes onto indented continuation lines:
+//
+//	< "+OK POP3 server ready\r\n"
+//	> "USER root\r\n"
+//	< "+OK\r\n"
+type Transcript []Step
+
+// stepLineBytes is the most data quoted on one line of a transcript.
+const stepLineBytes = 48
+
+// String returns the text form of the transcript.
+func (t Transcript) String() string {
+	var b strings.Builder
+	for _, step := range t {
+		prefix := string(step.From) + " "
+		data := step.Data
+		for first := true; first || len(data) > 0; first = false {
+			n := len(data)
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				n = i + 1
+			}
+			n = min(n, stepLineBytes)
+			b.WriteString(prefix)
+			b.WriteString(strconv.Quote(string(data[:n])))
+			b.WriteByte('\n')
+			data = data[n:]
+			prefix = "  "
+		}
+	}
+	return b.String()
+}
+
+// ParseTranscript parses the text form of a transcript. Blank lines and
+// lines starting with # are ignored.
+func ParseTranscript(text string) (Transcript, error) {
+	var t Transcript
+	for i, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := t.parseLine(line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+	}
+	return t, nil
+}
+
+// parseLine adds a step or continuation line of the text form to t.
+func (t *Transcript) parseLine(line string) error {
+	var from Direction
+	switch {
+	case strings.HasPrefix(line, "> "):
+		from = FromClient
+	case strings.HasPrefix(line, "< "):
+		from = FromServer
+	case !strings.HasPrefix(line, "  "):
+		return fmt.Errorf("%q starts with neither >, < nor two spaces", line)
+	case len(*t) == 0:
+		return fmt.Errorf("continuation line %q before any step", line)
+	}
+	data, err := strconv.Unquote(strings.TrimSpace(line[2:]))
+	if err != nil {
+		return fmt.Errorf("%s is not a quoted string", strings.TrimSpace(line[2:]))
+	}
+	if from == 0 {
+		last := &(*t)[len(*t)-1]
+		last.Data = append(last.Data, data...)
+	} else {
+		*t = append(*t, Step{From: from, Data: []byte(data)})
+	}
+	return nil
+}
+
+// Mask returns a copy of t in which what the expressions match in the data
+// of the server is replaced by as many asterisks, so values that change
+// from run to run, like dates or session identifiers, compare equal. When
+// an expression has groups only the groups are masked. Expressions match
+// bytes, as if the data were Latin-1: . is any one byte and \xff the byte
+// 0xff. Lengths are kept, so length fields of binary protocols still add up.
+func (t Transcript) Mask(masks []*regexp.Regexp) Transcript {
+	masked := make(Transcript, len(t))
+	for i, step := range t {
+		data := append([]byte(nil), step.Data...)
+		if step.From == FromServer && len(masks) > 0 {
+			text, offsets := latin1(data)
+			for _, re := range masks {
+				for _, m := range re.FindAllStringSubmatchIndex(text, -1) {
+					spans := m[2:]
+					if len(spans) == 0 {
+						spans = m[:2]
+					}
+					for j := 0; j+1 < len(spans); j += 2 {
+						if spans[j] < 0 {
+							continue // group not matched
+						}
+						for k := offsets[spans[j]]; k < offsets[spans[j+1]]; k++ {
+							data[k] = '*'
+						}
+					}
+				}
+			}
+		}
+		masked[i] = Step{From: step.From, Data: data}
+	}
+	return masked
+}
+
+// latin1 returns data decoded as Latin-1, a rune per byte, and the index in
+// data of each offset of the result.
+func latin1(data []byte) (string, []int) {
+	var b strings.Builder
+	offsets := make([]int, 0, 2*len(data)+1)
+	for i, c := range data {
+		n, _ := b.WriteRune(rune(c))
+		for ; n > 0; n-- {
+			offsets = append(offsets, i)
+		}
+	}
+	offsets = append(offsets, len(data))
+	return b.String(), offsets
+}
+
+// Diff compares two transcripts by their text form and returns "" when they
+// are equal, or the first lines that differ with some context.
+func Diff(want, got Transcript) string {
+	wantLines := strings.Split(want.String(), "\n")
+	gotLines := strings.Split(got.String(), "\n")
+	i := 0
+	for i < len(wantLines) && i < len(gotLines) && wantLines[i] == gotLines[i] {
+		i++
+	}
+	if i == len(wantLines) && i == len(gotLines) {
+		return ""
+	}
+	const context = 3
+	var b strings.Builder
+	fmt.Fprintf(&b, "transcripts differ at line %d\n", i+1)
+	for _, line := range wantLines[max(i-context, 0):i] {
+		fmt.Fprintf(&b, "  %s\n", line)
+	}
+	for _, line := range wantLines[i:min(i+context, len(wantLines))] {
+		fmt.Fprintf(&b, "- %s\n", line)
+	}
+	for _, line := range gotLines[i:min(i+context, len(gotLines))] {
+		fmt.Fprintf(&b, "+ %s\n", line)
+	}
+	return b.String()
+}
+
+// Recorder is the client end of a connection that records its Transcript:
+// what is written to it was sent by the client, what is read from it by the
+// server. Consecutive reads or writes form one step, unless Datagrams is
+// set, for datagram sockets, in which case each is a step of its own.
+type Recorder struct {
+	net.Conn
+	Datagrams bool
+
+	mu    sync.Mutex
+	steps Transcript
+}
+
+// Record returns a Recorder of conn.
+func Record(conn net.Conn) *Recorder {
+	return &Recorder{Conn: conn}
+}
+
+// add records data and returns the index of its step.
+func (r *Recorder) add(from Direction, data []byte) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n := len(r.steps); n > 0 && r.steps[n-1].From == from && !r.Datagrams {
+		r.steps[n-1].Data = append(r.steps[n-1].Data, data...)
+		return n - 1
+	}
+	r.steps = append(r.steps, Step{From: from, Data: append([]byte(nil), data...)})
+	return len(r.steps) - 1
+}
+
+// Read reads what the server sent and records it.
+func (r *Recorder) Read(b []byte) (int, error) {
+	n, err := r.Conn.Read(b)
+	if n > 0 {
+		r.add(FromServer, b[:n])
+	}
+	return n, err
+}
+
+// Write sends b for the client and records what was sent. It is recorded
+// first, since the server may answer before Write returns.
+func (r *Recorder) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return r.Conn.Write(b)
+	}
+	i := r.add(FromClient, b)
+	n, err := r.Conn.Write(b)
+	if n < len(b) {
+		r.mu.Lock()
+		step := &r.steps[i]
+		step.Data = step.Data[:len(step.Data)-(len(b)-n)]
+		if len(step.Data) == 0 {
+			r.steps = append(r.steps[:i], r.steps[i+1:]...)
+		}
+		r.mu.Unlock()
+	}
+	return n, err
+}
+
+// Transcript returns a copy of what was recorded so far.
+func (r *Recorder) Transcript() Transcript {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := make(Transcript, len(r.steps))
+	for i, step := range r.steps {
+		t[i] = Step{From: step.From, Data: append([]byte(nil), step.Data...)}
+	}
+	return t
+}
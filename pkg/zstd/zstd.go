@@ -0,0 +1,35 @@
+// Package zstd registers zstd compression for the batching outputs of
+// package honeypot, such as remote outputs, with the encoder of
+// github.com/klauspost/compress. Import it for its side effect:
+//
+//	import _ "github.com/jackyes/GoPot/pkg/zstd"
+//
+// after which "compression": "zstd" is accepted in batch configurations
+// and batches are sent with Content-Encoding: zstd.
+package zstd
+
+import (
+	"io"
+
+	"github.com/jackyes/GoPot/pkg/honeypot"
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxWindow bounds the memory a decoder may use for a payload, as RFC 8878
+// recommends for HTTP content coding.
+const maxWindow = 8 << 20
+
+func init() {
+	honeypot.RegisterCompression("zstd", honeypot.Compression{
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(maxWindow))
+			if err != nil {
+				return nil, err
+			}
+			return zr.IOReadCloser(), nil
+		},
+	})
+}